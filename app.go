@@ -0,0 +1,212 @@
+package wptsync
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// appTokenMargin is how long before it expires an installation token is
+// replaced, so that no request goes out with a token about to lapse.
+const appTokenMargin = 5 * time.Minute
+
+// appTokens authenticates as a GitHub App installation, minting installation
+// tokens (valid for an hour) as needed and sharing them between the requests
+// of a run. See SyncOptions.AppID.
+type appTokens struct {
+	id           int64
+	key          string
+	installation int64
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newAppTokens returns the GitHub App credentials o sets, falling back to
+// the GITHUB_APP_ID, GITHUB_APP_PRIVATE_KEY, and GITHUB_APP_INSTALLATION_ID
+// environment variables, or nil when there is no app ID.
+func newAppTokens(o *SyncOptions) (*appTokens, error) {
+	a := &appTokens{}
+	if o != nil {
+		a.id, a.key, a.installation = o.AppID, o.AppKey, o.AppInstallation
+	}
+	var err error
+	if a.id == 0 && os.Getenv("GITHUB_APP_ID") != "" {
+		if a.id, err = strconv.ParseInt(os.Getenv("GITHUB_APP_ID"), 10, 64); err != nil {
+			return nil, invalidConfig(fmt.Errorf("GITHUB_APP_ID: %w", err))
+		}
+	}
+	if a.id == 0 {
+		return nil, nil
+	}
+	if a.key == "" {
+		a.key = os.Getenv("GITHUB_APP_PRIVATE_KEY")
+	}
+	if a.installation == 0 && os.Getenv("GITHUB_APP_INSTALLATION_ID") != "" {
+		if a.installation, err = strconv.ParseInt(os.Getenv("GITHUB_APP_INSTALLATION_ID"), 10, 64); err != nil {
+			return nil, invalidConfig(fmt.Errorf("GITHUB_APP_INSTALLATION_ID: %w", err))
+		}
+	}
+	if a.key == "" {
+		return nil, invalidConfig(fmt.Errorf("GitHub App %d: no private key; pass -app-key or set GITHUB_APP_PRIVATE_KEY", a.id))
+	}
+	return a, nil
+}
+
+// appJWT returns the JSON Web Token the app authenticates with to mint
+// installation tokens: signed with its private key, and valid for nine
+// minutes from a minute ago, to allow for clock drift.
+func (a *appTokens) appJWT(now time.Time) (string, error) {
+	key, err := parseAppKey(a.key)
+	if err != nil {
+		return "", invalidConfig(fmt.Errorf("GitHub App %d private key: %w", a.id, err))
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(a.id, 10),
+	})
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", fmt.Errorf("sign GitHub App token: %w", err)
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+// parseAppKey decodes a PEM-encoded RSA private key, in the PKCS #1 form
+// GitHub hands out or in PKCS #8.
+func parseAppKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("not a PEM-encoded key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+	return key, nil
+}
+
+// appToken returns an installation token of g's app, minting one when there
+// is none yet or the last is about to expire.
+func (g *githubAPI) appToken(ctx context.Context) (string, error) {
+	a := g.app
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if a.token != "" && now.Add(appTokenMargin).Before(a.expires) {
+		return a.token, nil
+	}
+
+	jwt, err := a.appJWT(now)
+	if err != nil {
+		return "", err
+	}
+	root, _, _ := strings.Cut(g.baseURL, "/repos/")
+	if a.installation == 0 {
+		if a.installation, err = g.findInstallation(ctx, root, jwt); err != nil {
+			return "", err
+		}
+	}
+	var result struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	endpoint := fmt.Sprintf("%s/app/installations/%d/access_tokens", root, a.installation)
+	if err := g.appRequest(ctx, http.MethodPost, endpoint, jwt, &result); err != nil {
+		return "", fmt.Errorf("mint GitHub App installation token: %w", err)
+	}
+	if result.Token == "" {
+		return "", errors.New("mint GitHub App installation token: no token in response")
+	}
+	a.token, a.expires = result.Token, result.ExpiresAt
+	return a.token, nil
+}
+
+// findInstallation returns the installation of g's app on the owner of g's
+// repository, or else its only installation. An app installed on several
+// other accounts needs SyncOptions.AppInstallation to pick one.
+func (g *githubAPI) findInstallation(ctx context.Context, root, jwt string) (int64, error) {
+	var installations []struct {
+		ID      int64 `json:"id"`
+		Account struct {
+			Login string `json:"login"`
+		} `json:"account"`
+	}
+	if err := g.appRequest(ctx, http.MethodGet, root+"/app/installations?per_page=100", jwt, &installations); err != nil {
+		return 0, fmt.Errorf("list GitHub App installations: %w", err)
+	}
+	owner, _, _ := strings.Cut(g.repo, "/")
+	var logins []string
+	for _, inst := range installations {
+		if strings.EqualFold(inst.Account.Login, owner) {
+			return inst.ID, nil
+		}
+		logins = append(logins, fmt.Sprintf("%s (%d)", inst.Account.Login, inst.ID))
+	}
+	switch len(installations) {
+	case 0:
+		return 0, invalidConfig(fmt.Errorf("GitHub App %d is not installed anywhere", g.app.id))
+	case 1:
+		return installations[0].ID, nil
+	}
+	return 0, invalidConfig(fmt.Errorf("GitHub App %d has several installations (%s); pick one with -app-installation", g.app.id, strings.Join(logins, ", ")))
+}
+
+// appRequest sends a request authenticated as the app itself, with jwt, and
+// decodes the JSON response into out. It counts against the run's budget
+// like any other API request.
+func (g *githubAPI) appRequest(ctx context.Context, method, endpoint, jwt string, out any) error {
+	if err := g.budget.spend(); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := rateLimitError(resp, true); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return &statusError{code: resp.StatusCode, status: resp.Status, service: "GitHub API"}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package wptsync
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGitHubAppInstallationToken(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "ghp_ignored")
+	t.Setenv("GITHUB_APP_ID", "")
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", "")
+	t.Setenv("GITHUB_APP_INSTALLATION_ID", "")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+
+	installations := `[{"id":42,"account":{"login":"some-org"}}]`
+	minted := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		switch r.URL.Path {
+		case "/app/installations", "/app/installations/42/access_tokens":
+			if err := checkAppJWT(auth, &key.PublicKey, "7"); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Path == "/app/installations" {
+				w.Write([]byte(installations))
+				return
+			}
+			minted++
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]any{"token": "ghs_installation", "expires_at": time.Now().Add(time.Hour)})
+		case "/repos/o/n/commits/master":
+			if auth != "ghs_installation" {
+				http.Error(w, "bad credentials", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"sha":"c1"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	opts := (&SyncOptions{APIURL: srv.URL + "/repos/o/n", AppID: 7, AppKey: keyPEM}).forConfig(&Config{Repo: "o/n"})
+	for range 2 {
+		commit, err := opts.github().latestCommit(context.Background())
+		if err != nil || commit != "c1" {
+			t.Fatalf("latestCommit = %q, %v, want c1", commit, err)
+		}
+	}
+	if minted != 1 {
+		t.Errorf("minted %d installation tokens, want 1 shared by the run", minted)
+	}
+
+	installations = `[{"id":42,"account":{"login":"some-org"}},{"id":43,"account":{"login":"other-org"}}]`
+	opts = (&SyncOptions{APIURL: srv.URL + "/repos/o/n", AppID: 7, AppKey: keyPEM}).forConfig(&Config{Repo: "o/n"})
+	if _, err := opts.github().latestCommit(context.Background()); err == nil || !strings.Contains(err.Error(), "-app-installation") {
+		t.Errorf("latestCommit with several installations = %v, want a hint to pick one", err)
+	}
+	opts = (&SyncOptions{APIURL: srv.URL + "/repos/o/n", AppID: 7, AppKey: keyPEM, AppInstallation: 42}).forConfig(&Config{Repo: "o/n"})
+	if _, err := opts.github().latestCommit(context.Background()); err != nil {
+		t.Errorf("latestCommit with -app-installation: %v", err)
+	}
+
+	if err := (&SyncOptions{AppID: 7, AppKey: "not a key"}).validate(); err == nil {
+		t.Error("validate accepted a malformed private key")
+	}
+}
+
+// checkAppJWT checks that token is an RS256 JSON Web Token signed by key and
+// issued by the app iss.
+func checkAppJWT(token string, key *rsa.PublicKey, iss string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errMalformedJWT
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+		Exp int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return err
+	}
+	if claims.Iss != iss || time.Unix(claims.Exp, 0).Before(time.Now()) {
+		return errMalformedJWT
+	}
+	return nil
+}
+
+var errMalformedJWT = errors.New("malformed JWT")
+
+func TestRecordRedactsAppInstallationToken(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app/installations/42/access_tokens":
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]any{"token": "ghs_installation", "expires_at": time.Now().Add(time.Hour)})
+		case "/repos/o/n/commits/master":
+			w.Write([]byte(`{"sha":"c1"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	recordDir := t.TempDir()
+	opts := (&SyncOptions{APIURL: srv.URL + "/repos/o/n", AppID: 7, AppKey: keyPEM, AppInstallation: 42, RecordDir: recordDir}).forConfig(&Config{Repo: "o/n"})
+	if _, err := opts.github().latestCommit(context.Background()); err != nil {
+		t.Fatalf("latestCommit: %v", err)
+	}
+	entries, err := os.ReadDir(recordDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("recorded %d responses, want the token and the commit", len(entries))
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(recordDir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rec recording
+		if err := json.Unmarshal(data, &rec); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data)+string(rec.Body), "ghs_installation") {
+			t.Errorf("recording of %s holds the installation token: %s", rec.URL, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package wptsync
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DefaultArchiveURL is the default base URL commit tarballs are downloaded
+// from in archive mode.
+const DefaultArchiveURL = "https://codeload.github.com/web-platform-tests/wpt/tar.gz"
+
+// Sync modes for SyncOptions.Mode.
+const (
+	// ModeRaw downloads each file with its own request.
+	ModeRaw = "raw"
+	// ModeArchive downloads the tarball for the pinned commit once and
+	// extracts the configured files from it.
+	ModeArchive = "archive"
+	// ModeBatch fetches small text files many at a time through the GitHub
+	// GraphQL API and the rest with one raw request each.
+	ModeBatch = "batch"
+)
+
+// errNotStaged reports a file missing from the staging directory.
+var errNotStaged = errors.New("not found in the archive")
+
+func (o *SyncOptions) archiveURL() string {
+	if o == nil || o.ArchiveURL == "" {
+		return DefaultArchiveURL
+	}
+	return o.ArchiveURL
+}
+
+// stageFiles fetches files in bulk ahead of the per-file workers in archive
+// and batch mode, or when the sync reads a bundle; see stageArchive,
+// stageBlobs, and stageBundle. When it reads a clone, the returned options
+// read files from it instead; see stageCheckout. In other modes, or in a
+// dry run, opts is returned as is.
+func stageFiles(ctx context.Context, root string, cfg *Config, files []FileSpec, opts *SyncOptions) (*SyncOptions, func(), error) {
+	if opts == nil || opts.DryRun || len(files) == 0 {
+		return opts, func() {}, nil
+	}
+	if checkout := opts.sourcePath(root, cfg, gitScheme); checkout != "" {
+		return stageCheckout(ctx, checkout, cfg, files, opts)
+	}
+	if opts.bundlePath(root, cfg) != "" {
+		// A bundle holds a single commit, and nothing is downloaded.
+		for _, f := range files {
+			if f.Commit != "" {
+				return nil, nil, fmt.Errorf("%s is pinned at %s, which the bundle does not hold", f.Dst, shortSHA(f.Commit))
+			}
+		}
+		return stageBundle(ctx, root, cfg, files, opts)
+	}
+	// Pinned files are fetched one by one at their own commit.
+	files = slices.DeleteFunc(slices.Clone(files), func(f FileSpec) bool { return f.Commit != "" })
+	if len(files) == 0 {
+		return opts, func() {}, nil
+	}
+	switch opts.Mode {
+	case ModeArchive:
+		return stageArchive(ctx, root, cfg, files, opts)
+	case ModeBatch:
+		return stageBlobs(ctx, root, cfg, files, opts)
+	}
+	return opts, func() {}, nil
+}
+
+// stageArchive streams the tarball for cfg's commit and extracts files into
+// a staging directory under target_dir. It returns a copy of opts whose
+// downloads are served from that directory, and a cleanup function that
+// removes it.
+func stageArchive(ctx context.Context, root string, cfg *Config, files []FileSpec, opts *SyncOptions) (*SyncOptions, func(), error) {
+	staging, cleanup, err := newStaging(root, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	wanted := wantedSrcs(files)
+
+	url := fmt.Sprintf("%s/%s", opts.archiveURL(), cfg.Commit)
+	opts.logf("Downloading archive %s\n", url)
+	downloadCtx, cancel := withTimeout(ctx, opts.timeouts().Download)
+	defer cancel()
+	n, err := extractArchive(downloadCtx, opts.httpClient(), url, staging, wanted)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("download archive: %w", err)
+	}
+	opts.logf("Extracted %d of %d files from the archive\n", n, len(wanted))
+
+	cp := *opts
+	cp.staged = staging
+	return &cp, cleanup, nil
+}
+
+// newStaging creates a staging directory under cfg's target directory, on
+// the same filesystem as the files it is copied to, and returns it with a
+// function that removes it.
+func newStaging(root string, cfg *Config) (string, func(), error) {
+	targetDir := filepath.Join(root, cfg.TargetDir)
+	if err := os.MkdirAll(targetDir, 0o755); err != nil {
+		return "", nil, fmt.Errorf("create target directory: %w", err)
+	}
+	staging, err := os.MkdirTemp(targetDir, ".wpt-archive-")
+	if err != nil {
+		return "", nil, fmt.Errorf("create staging directory: %w", err)
+	}
+	return staging, func() { os.RemoveAll(staging) }, nil
+}
+
+// wantedSrcs returns the set of files' sources, as paths relative to the
+// repository root.
+func wantedSrcs(files []FileSpec) map[string]bool {
+	wanted := make(map[string]bool, len(files))
+	for _, f := range files {
+		wanted[strings.TrimLeft(f.Src, "/")] = true
+	}
+	return wanted
+}
+
+// extractArchive downloads the gzipped tarball at url and writes the entries
+// in wanted (paths relative to the repository root, ignoring the tarball's
+// top-level directory) below dir. It returns how many were found.
+func extractArchive(ctx context.Context, client *http.Client, url, dir string, wanted map[string]bool) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+	found, _, err := extractTar(gz, dir, wanted)
+	return found, err
+}
+
+// extractTar writes the entries of the tarball r in wanted (paths relative
+// to the repository root, ignoring the tarball's top-level directory) below
+// dir. It returns how many were found, and the commit the tarball says it
+// was made from, if any: git archive, and so GitHub, records it in a pax
+// global header.
+func extractTar(r io.Reader, dir string, wanted map[string]bool) (found int, commit string, err error) {
+	tr := tar.NewReader(r)
+	for found < len(wanted) {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return found, commit, err
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			commit = hdr.PAXRecords["comment"]
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		// Entries are prefixed with "<repo>-<commit>/".
+		_, name, ok := strings.Cut(hdr.Name, "/")
+		if !ok || !wanted[name] || !filepath.IsLocal(filepath.FromSlash(name)) {
+			continue
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return found, commit, err
+		}
+		f, err := os.Create(dest)
+		if err != nil {
+			return found, commit, err
+		}
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return found, commit, fmt.Errorf("extract %s: %w", name, err)
+		}
+		found++
+	}
+	return found, commit, nil
+}
+
+// copyStaged copies the staged copy of src to dest, replacing dest by
+// rename like download does.
+func copyStaged(staging, src, dest string) error {
+	in, err := os.Open(filepath.Join(staging, filepath.FromSlash(src)))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s %w", src, errNotStaged)
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("create destination directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".wpt-download-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
@@ -0,0 +1,85 @@
+package wptsync
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeTarball returns a gzipped tarball laid out like GitHub's codeload
+// archives: every path below a "wpt-<commit>/" directory.
+func makeTarball(t *testing.T, commit string, files map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, body := range files {
+		hdr := &tar.Header{Name: "wpt-" + commit + "/" + name, Mode: 0o644, Size: int64(len(body)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestSyncArchiveMode(t *testing.T) {
+	content := map[string]string{
+		"/archive/c1": makeTarball(t, "c1", map[string]string{
+			"url/a.any.js":   "a\n",
+			"url/b.js":       "b\n",
+			"css/ignored.js": "x\n",
+		}),
+	}
+	server, dir, requests := newFixture(t, content)
+
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "url/a.any.js", Dst: "url/a.js"}, {Src: "url/b.js"}},
+	})
+	opts := &SyncOptions{Mode: ModeArchive, ArchiveURL: server.URL + "/archive", BaseURL: server.URL}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if got := requests(); got != 1 {
+		t.Errorf("made %d requests, want 1 (the archive)", got)
+	}
+	for rel, want := range map[string]string{"url/a.js": "a\n", "url/b.js": "b\n"} {
+		got, err := os.ReadFile(filepath.Join(dir, "wpt", filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatalf("read %s: %v", rel, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", rel, got, want)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "wpt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "url" && e.Name() != stampFileName {
+			t.Errorf("unexpected %s left in target_dir", e.Name())
+		}
+	}
+
+	// A file missing from the archive fails the sync.
+	configPath = saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "url/missing.js"}}})
+	if err := Sync(context.Background(), configPath, opts); err == nil {
+		t.Error("Sync succeeded for a file that is not in the archive")
+	}
+}
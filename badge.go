@@ -0,0 +1,119 @@
+package wptsync
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"time"
+)
+
+// Badge describes how far the pinned commit is behind upstream, for a
+// freshness badge on a README or dashboard.
+type Badge struct {
+	// Commit is the pinned commit and Latest the head of the tracked ref.
+	Commit string `json:"commit"`
+	Latest string `json:"latest"`
+	// CommitDate and LatestDate are when they were committed.
+	CommitDate time.Time `json:"commit_date"`
+	LatestDate time.Time `json:"latest_date"`
+	// DaysBehind is the number of whole days between CommitDate and
+	// LatestDate.
+	DaysBehind int `json:"days_behind"`
+	// Label, Message, and Color are what the badge shows, named as in
+	// shields.io so its dynamic JSON badges can read them.
+	Label   string `json:"label"`
+	Message string `json:"message"`
+	Color   string `json:"color"`
+}
+
+// badgeColors maps how many days behind a pin is, at most, to the badge
+// color. Older pins are red.
+var badgeColors = []struct {
+	days  int
+	color string
+}{
+	{7, "brightgreen"},
+	{30, "yellow"},
+	{90, "orange"},
+}
+
+// badgeHex holds shields.io's values for the badge colors.
+var badgeHex = map[string]string{
+	"brightgreen": "#4c1",
+	"yellow":      "#dfb317",
+	"orange":      "#fe7d37",
+	"red":         "#e05d44",
+}
+
+// LoadBadge compares the commit pinned in the configuration at configPath
+// with the head of its tracked ref.
+func LoadBadge(ctx context.Context, configPath string, opts *SyncOptions) (*Badge, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.forConfig(cfg)
+
+	ctx, cancel := withTimeout(ctx, opts.timeouts().Resolve)
+	defer cancel()
+
+	gh := opts.github()
+	latest, err := gh.latestCommit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch latest commit: %w", err)
+	}
+	b := &Badge{Commit: cfg.Commit, Latest: latest, Label: "wpt"}
+	if b.CommitDate, err = gh.commitDate(ctx, cfg.Commit); err != nil {
+		return nil, err
+	}
+	b.LatestDate = b.CommitDate
+	if latest != cfg.Commit {
+		if b.LatestDate, err = gh.commitDate(ctx, latest); err != nil {
+			return nil, err
+		}
+	}
+	b.DaysBehind = max(int(b.LatestDate.Sub(b.CommitDate)/(24*time.Hour)), 0)
+
+	switch {
+	case latest == cfg.Commit:
+		b.Message = shortSHA(cfg.Commit) + " · up to date"
+	case b.DaysBehind == 1:
+		b.Message = shortSHA(cfg.Commit) + " · 1 day behind"
+	default:
+		b.Message = fmt.Sprintf("%s · %d days behind", shortSHA(cfg.Commit), b.DaysBehind)
+	}
+	b.Color = "red"
+	for _, c := range badgeColors {
+		if b.DaysBehind <= c.days {
+			b.Color = c.color
+			break
+		}
+	}
+	return b, nil
+}
+
+// SVG renders b as a flat badge in the style of shields.io.
+func (b *Badge) SVG() []byte {
+	// Verdana at 11px averages about 7px a character.
+	labelWidth := 7*len([]rune(b.Label)) + 10
+	messageWidth := 7*len([]rune(b.Message)) + 10
+	width := labelWidth + messageWidth
+	label, message := html.EscapeString(b.Label), html.EscapeString(b.Message)
+	return fmt.Appendf(nil, `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="14">%[4]s</text>
+<text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>
+`, width, labelWidth, messageWidth, label, message, badgeHex[b.Color], labelWidth/2, labelWidth+messageWidth/2)
+}
@@ -0,0 +1,38 @@
+package wptsync
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestLoadBadge(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/commits/master": `{"sha":"c2"}`,
+		"/repos/o/n/commits/c1":     `{"sha":"c1","commit":{"committer":{"date":"2024-05-01T10:00:00Z"}}}`,
+		"/repos/o/n/commits/c2":     `{"sha":"c2","commit":{"committer":{"date":"2024-06-10T09:00:00Z"}}}`,
+	})
+	configPath := saveTestConfig(t, t.TempDir(), &Config{Commit: "c1", TargetDir: "wpt"})
+
+	badge, err := LoadBadge(context.Background(), configPath, &SyncOptions{APIURL: apiURL})
+	if err != nil {
+		t.Fatalf("LoadBadge: %v", err)
+	}
+	if badge.DaysBehind != 39 || badge.Color != "orange" || badge.Message != "c1 · 39 days behind" {
+		t.Errorf("badge = %+v, want 39 days behind in orange", badge)
+	}
+	svg := badge.SVG()
+	if !bytes.Contains(svg, []byte("c1 · 39 days behind")) || !bytes.Contains(svg, []byte(badgeHex["orange"])) {
+		t.Errorf("SVG lacks the message or color:\n%s", svg)
+	}
+
+	configPath = saveTestConfig(t, t.TempDir(), &Config{Commit: "c2", TargetDir: "wpt"})
+	if badge, err = LoadBadge(context.Background(), configPath, &SyncOptions{APIURL: apiURL}); err != nil {
+		t.Fatalf("LoadBadge at the latest commit: %v", err)
+	}
+	if badge.DaysBehind != 0 || badge.Color != "brightgreen" || badge.Message != "c2 · up to date" {
+		t.Errorf("badge = %+v, want up to date in brightgreen", badge)
+	}
+}
@@ -0,0 +1,91 @@
+package wptsync
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// batchMaxSize is the largest blob ModeBatch inlines in a GraphQL query;
+// larger ones are downloaded with a raw request of their own.
+const batchMaxSize = 64 << 10
+
+// stageBlobs fetches the small text files among files with batched GraphQL
+// queries (graphQLBatchSize per request) and writes them into a staging
+// directory under target_dir. It returns a copy of opts whose downloads are
+// served from that directory, falling back to raw downloads for everything
+// not staged: binary or large blobs, and text the API could not return
+// byte for byte. Without a GitHub token every file is downloaded raw.
+func stageBlobs(ctx context.Context, root string, cfg *Config, files []FileSpec, opts *SyncOptions) (*SyncOptions, func(), error) {
+	gh := opts.github()
+	gql := gh.graphQL(ctx)
+	if gql == nil {
+		opts.logf("Batch mode needs a GitHub token for GraphQL; downloading every file individually\n")
+		return opts, func() {}, nil
+	}
+
+	targetDir := filepath.Join(root, cfg.TargetDir)
+	if err := os.MkdirAll(targetDir, 0o755); err != nil {
+		return nil, nil, fmt.Errorf("create target directory: %w", err)
+	}
+	staging, err := os.MkdirTemp(targetDir, ".wpt-batch-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("create staging directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(staging) }
+
+	// Entries split by scope share a src.
+	srcs := make([]string, len(files))
+	for i, f := range files {
+		srcs[i] = strings.TrimLeft(f.Src, "/")
+	}
+	slices.Sort(srcs)
+	srcs = slices.Compact(srcs)
+
+	downloadCtx, cancel := withTimeout(ctx, opts.timeouts().Download)
+	defer cancel()
+	blobs, err := gql.fetchBlobs(downloadCtx, cfg.Commit, srcs)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("fetch files in batches: %w", err)
+	}
+
+	staged := 0
+	for _, src := range srcs {
+		blob, ok := blobs[src]
+		if !ok || blob.IsBinary || blob.Size > batchMaxSize || gitBlobSHA(blob.Text) != blob.OID {
+			continue
+		}
+		dest := filepath.Join(staging, filepath.FromSlash(src))
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		if err := os.WriteFile(dest, []byte(blob.Text), 0o644); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		staged++
+	}
+	opts.logf("Fetched %d of %d files in batched queries; downloading the rest individually\n", staged, len(srcs))
+
+	cp := *opts
+	cp.staged = staging
+	return &cp, cleanup, nil
+}
+
+// gitBlobSHA returns the object ID git gives a blob with content, which
+// proves text returned by the GraphQL API is the file byte for byte (it is
+// not for files that are not valid UTF-8).
+func gitBlobSHA(content string) string {
+	h := sha1.New()
+	h.Write([]byte("blob " + strconv.Itoa(len(content)) + "\x00"))
+	h.Write([]byte(content))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,80 @@
+package wptsync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSyncBatchMode(t *testing.T) {
+	big := strings.Repeat("x", batchMaxSize+1)
+	blobs := map[string]map[string]any{
+		"c1:a/small.js": {"__typename": "Blob", "oid": gitBlobSHA("small\n"), "byteSize": 6, "text": "small\n"},
+		"c1:a/big.js":   {"__typename": "Blob", "oid": gitBlobSHA(big), "byteSize": len(big), "text": big},
+		// The API replaced a byte that is not valid UTF-8.
+		"c1:a/latin1.js": {"__typename": "Blob", "oid": gitBlobSHA("caf\xe9\n"), "byteSize": 5, "text": "caf�\n"},
+	}
+	raw := map[string]string{"/c1/a/big.js": big, "/c1/a/latin1.js": "caf\xe9\n"}
+
+	aliasRe := regexp.MustCompile(`(f\d+): object\(expression: "([^"]*)"\)`)
+	var queries, downloads int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/graphql" {
+			queries++
+			var req struct {
+				Query string `json:"query"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			repo := map[string]any{}
+			for _, m := range aliasRe.FindAllStringSubmatch(req.Query, -1) {
+				repo[m[1]] = blobs[m[2]]
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"repository": repo}})
+			return
+		}
+		downloads++
+		body, ok := raw[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "a/small.js"}, {Src: "a/big.js"}, {Src: "a/latin1.js"}},
+	})
+	opts := &SyncOptions{Mode: ModeBatch, BaseURL: srv.URL, APIURL: srv.URL + "/repos/o/n", Token: "tok"}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if queries != 1 || downloads != 2 {
+		t.Errorf("made %d queries and %d downloads, want 1 and 2", queries, downloads)
+	}
+	for src, want := range map[string]string{"a/small.js": "small\n", "a/big.js": big, "a/latin1.js": "caf\xe9\n"} {
+		got, err := os.ReadFile(filepath.Join(dir, "wpt", filepath.FromSlash(src)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", src, got[:min(len(got), 20)], want[:min(len(want), 20)])
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "wpt"))
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".wpt-batch-") {
+			t.Errorf("staging directory %s was not removed", e.Name())
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package wptsync
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// binaryExtensions are the extensions of files that are binary whatever
+// their first bytes hold: images, fonts, audio and video, archives, and
+// WebAssembly, which WPT ships as test resources.
+var binaryExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true, ".avif": true, ".bmp": true, ".ico": true, ".cur": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".mp3": true, ".mp4": true, ".m4a": true, ".webm": true, ".ogg": true, ".ogv": true, ".oga": true, ".wav": true, ".flac": true, ".opus": true,
+	".zip": true, ".gz": true, ".br": true, ".zst": true, ".tar": true, ".pdf": true,
+	".wasm": true, ".bin": true, ".der": true, ".p12": true, ".pfx": true,
+}
+
+// binarySniffLen is how much of the start of a file looksBinary is given,
+// as git does.
+const binarySniffLen = 8000
+
+// looksBinary reports whether content, the start of a file, holds a NUL
+// byte, which text files do not.
+func looksBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), binarySniffLen)], 0) >= 0
+}
+
+// isBinaryExt reports whether dst has one of binaryExtensions.
+func isBinaryExt(dst string) bool {
+	return binaryExtensions[strings.ToLower(path.Ext(dst))]
+}
+
+// isBinaryFile reports whether the file at p, synced to dst, is binary: dst
+// has one of binaryExtensions, or its content looks binary.
+func isBinaryFile(p, dst string) (bool, error) {
+	if isBinaryExt(dst) {
+		return true, nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	buf := make([]byte, binarySniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, err
+	}
+	return looksBinary(buf[:n]), nil
+}
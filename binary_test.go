@@ -0,0 +1,81 @@
+package wptsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncLeavesBinaryContentAlone(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	png := "\x89PNG\r\n\x1a\nfoo\n"
+	blob := "foo\x00bar\nfoo\n"
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/img/a.png": png,
+		"/c1/img/b.dat": blob,
+		"/c1/img/c.dat": "foo\n",
+	})
+	replace := []Transform{{Replace: "foo", With: "baz"}}
+	// b.dat has no binary extension, but a NUL byte; c.dat is text.
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:         "c1",
+		TargetDir:      "wpt",
+		LicenseHeaders: map[string]string{".png": "LICENSE\n", ".dat": "LICENSE\n"},
+		Files: []FileSpec{
+			{Src: "img/a.png", Transforms: replace},
+			{Src: "img/b.dat", Transforms: replace, Patch: "patches/b.patch"},
+			{Src: "img/c.dat", Transforms: replace},
+		},
+	})
+
+	patch := "--- a/img/b.dat\n+++ b/img/b.dat\n@@ -1 +1 @@\n-foo\n+qux\n"
+	if err := os.MkdirAll(filepath.Join(dir, "patches"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "patches", "b.patch"), []byte(patch), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logged strings.Builder
+	opts := &SyncOptions{BaseURL: server.URL, Logf: func(format string, args ...any) { fmt.Fprintf(&logged, format, args...) }}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	for dst, want := range map[string]string{"a.png": png, "b.dat": blob, "c.dat": "LICENSE\nbaz\n"} {
+		if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "img", dst)); string(got) != want {
+			t.Errorf("%s = %q, want %q", dst, got, want)
+		}
+	}
+	for _, want := range []string{
+		"warning: img/a.png is binary; skipping its transforms",
+		"warning: img/b.dat is binary; skipping its patch",
+		"warning: img/b.dat is binary; not adding its header",
+	} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("log = %q, want %q", logged.String(), want)
+		}
+	}
+	if strings.Contains(logged.String(), "c.dat is binary") {
+		t.Errorf("log = %q, want c.dat treated as text", logged.String())
+	}
+}
+
+func TestVerifyBinaryFileWithoutHeader(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, dir, _ := newFixture(t, map[string]string{"/c1/a/x.js": "x\x00y\n"})
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:         "c1",
+		TargetDir:      "wpt",
+		LicenseHeaders: map[string]string{".js": "// LICENSE\n"},
+		Files:          []FileSpec{{Src: "a/x.js"}},
+	})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify after syncing a binary .js = %v, want no header missing", err)
+	}
+}
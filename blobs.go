@@ -0,0 +1,215 @@
+package wptsync
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// blobIndex maps a commit and a path in it (see blobKey) to the SHA of the
+// git blob the path names upstream.
+type blobIndex map[string]string
+
+func blobKey(commit, src string) string {
+	return commit + ":" + strings.TrimLeft(src, "/")
+}
+
+// expectedBlob returns the blob SHA the content of src at commit must hash
+// to, or "" when the run does not check downloads against blob SHAs.
+func (o *SyncOptions) expectedBlob(commit, src string) string {
+	if o == nil {
+		return ""
+	}
+	return o.blobs[blobKey(commit, src)]
+}
+
+// withBlobs returns a copy of o that checks the downloads of files against
+// their blob SHAs in the upstream trees, when o.VerifyBlobs asks for it.
+// Reading from a local clone needs no checking: git verifies its objects.
+func (o *SyncOptions) withBlobs(ctx context.Context, cfg *Config, files []FileSpec) (*SyncOptions, error) {
+	if o == nil || !o.VerifyBlobs || o.DryRun || o.checkout != "" || len(files) == 0 {
+		return o, nil
+	}
+	blobs, err := o.blobSHAs(ctx, cfg, files)
+	if err != nil {
+		return nil, fmt.Errorf("look up blob SHAs: %w", err)
+	}
+	cp := *o
+	cp.blobs = blobs
+	return &cp, nil
+}
+
+// blobSHAs looks up the blob SHA of every file in the upstream tree of the
+// commit it is synced at. Files missing upstream are left out: their
+// download fails on its own.
+func (o *SyncOptions) blobSHAs(ctx context.Context, cfg *Config, files []FileSpec) (blobIndex, error) {
+	byCommit := make(map[string][]string)
+	for _, file := range files {
+		commit := cfg.commitOf(file)
+		byCommit[commit] = append(byCommit[commit], strings.TrimLeft(file.Src, "/"))
+	}
+	ctx, cancel := withTimeout(ctx, o.timeouts().Resolve)
+	defer cancel()
+	gh := o.github()
+	blobs := make(blobIndex)
+	for commit, srcs := range byCommit {
+		shas, err := gh.blobSHAs(ctx, commit, srcs)
+		if err != nil {
+			return nil, err
+		}
+		for src, sha := range shas {
+			blobs[blobKey(commit, src)] = sha
+		}
+	}
+	return blobs, nil
+}
+
+// blobSHAs returns the blob SHA of each of paths at commit, leaving out
+// those that name no blob. It lists each directory on the way to them once,
+// without recursion, so the cost is one request per directory rather than
+// the listing of the whole repository.
+func (g *githubAPI) blobSHAs(ctx context.Context, commit string, paths []string) (map[string]string, error) {
+	dirs := map[string][]treeEntry{}
+	var list func(dir string) ([]treeEntry, error)
+	list = func(dir string) ([]treeEntry, error) {
+		if entries, ok := dirs[dir]; ok {
+			return entries, nil
+		}
+		sha := commit
+		if dir != "" {
+			parent, name := path.Split(dir)
+			entries, err := list(strings.TrimSuffix(parent, "/"))
+			if err != nil {
+				return nil, err
+			}
+			sha = ""
+			for _, e := range entries {
+				if e.Path == name && e.Type == "tree" {
+					sha = e.SHA
+				}
+			}
+		}
+		var entries []treeEntry
+		if sha != "" {
+			tree, err := g.tree(ctx, sha, false, nil)
+			if err != nil {
+				return nil, err
+			}
+			if tree.Truncated {
+				return nil, fmt.Errorf("GitHub truncated the tree listing for %q even without recursion", dir)
+			}
+			entries = tree.Tree
+		}
+		dirs[dir] = entries
+		return entries, nil
+	}
+
+	shas := make(map[string]string, len(paths))
+	for _, p := range paths {
+		dir, name := path.Split(p)
+		entries, err := list(strings.TrimSuffix(dir, "/"))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Path == name && e.Type == "blob" {
+				shas[p] = e.SHA
+			}
+		}
+	}
+	return shas, nil
+}
+
+// hashGitBlob returns the object ID git gives a blob with the content of
+// the file at p (see gitBlobSHA).
+func hashGitBlob(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	h.Write([]byte("blob " + strconv.FormatInt(info.Size(), 10) + "\x00"))
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkBlob returns an error wrapping ErrVerification unless the content of
+// src fetched to p hashes to the blob SHA want.
+func checkBlob(p, src, want string) error {
+	got, err := hashGitBlob(p)
+	if err != nil {
+		return fmt.Errorf("hash %s: %w", src, err)
+	}
+	if got != want {
+		return fmt.Errorf("%w: %s hashes to git blob %s, but upstream has %s; the download is truncated or corrupt", ErrVerification, src, shortSHA(got), shortSHA(want))
+	}
+	return nil
+}
+
+// upstreamMismatches checks files, as the lock file records them, against
+// their blobs in the upstream tree, without downloading them: the blob SHA
+// their lock entry records, and the content on disk of those written as
+// they were downloaded (with no patch, transform, or license header). It
+// returns a description of each file that does not match.
+func (o *SyncOptions) upstreamMismatches(ctx context.Context, root string, cfg *Config, lock *lockFile, files []FileSpec) ([]string, error) {
+	blobs, err := o.blobSHAs(ctx, cfg, files)
+	if err != nil {
+		return nil, fmt.Errorf("look up blob SHAs: %w", err)
+	}
+	var mismatched []string
+	for _, file := range files {
+		want, ok := blobs[blobKey(cfg.commitOf(file), file.Src)]
+		if !ok {
+			mismatched = append(mismatched, file.Dst+" (not found upstream)")
+			continue
+		}
+		entry := lock.Files[file.Dst]
+		if entry.Blob != "" && entry.Blob != want {
+			mismatched = append(mismatched, fmt.Sprintf("%s (lock records blob %s, upstream has %s)", file.Dst, shortSHA(entry.Blob), shortSHA(want)))
+			continue
+		}
+		if file.Patch != "" || entry.HeaderSHA256 != "" || transformsHash(file) != "" {
+			continue
+		}
+		dest := filepath.Join(root, cfg.TargetDir, filepath.FromSlash(file.Dst))
+		got, err := hashGitBlob(dest)
+		if err != nil {
+			// Missing files are reported as drift.
+			continue
+		}
+		if got != want && !isLFSObjectOf(dest, want) {
+			mismatched = append(mismatched, file.Dst+" (differs from upstream)")
+		}
+	}
+	return mismatched, nil
+}
+
+// isLFSObjectOf reports whether the file at p is the Git LFS object of the
+// pointer whose blob SHA is blob: the pointer is derived from the object's
+// hash and size, so this needs no download either.
+func isLFSObjectOf(p, blob string) bool {
+	info, err := os.Stat(p)
+	if err != nil {
+		return false
+	}
+	sum, err := hashFile(p)
+	if err != nil {
+		return false
+	}
+	pointer := fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, sum, info.Size())
+	return gitBlobSHA(pointer) == blob
+}
@@ -0,0 +1,74 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncVerifyBlobs(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/url/a.js": "a\n",
+		"/c1/url/b.js": "trunc",
+	})
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1":    `{"tree":[{"path":"url","type":"tree","sha":"t-url"}]}`,
+		"/repos/o/n/git/trees/t-url": fmt.Sprintf(`{"tree":[{"path":"a.js","type":"blob","sha":%q},{"path":"b.js","type":"blob","sha":%q}]}`, gitBlobSHA("a\n"), gitBlobSHA("truncated\n")),
+	})
+	b := filepath.Join(dir, "wpt", "url", "b.js")
+	if err := os.MkdirAll(filepath.Dir(b), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("b before\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "url/a.js", Dst: "url/a.js"}, {Src: "url/b.js", Dst: "url/b.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	opts := &SyncOptions{BaseURL: server.URL, APIURL: apiURL, VerifyBlobs: true, KeepGoing: true}
+	err := Sync(context.Background(), configPath, opts)
+	if !errors.Is(err, ErrVerification) || ExitCode(err) != ExitVerification {
+		t.Fatalf("Sync = %v, want the truncated url/b.js to fail verification", err)
+	}
+	if got, _ := os.ReadFile(b); string(got) != "b before\n" {
+		t.Errorf("url/b.js = %q, want the content that failed verification kept off disk", got)
+	}
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lock.Files["url/a.js"].Blob; got != gitBlobSHA("a\n") {
+		t.Errorf("lock blob of url/a.js = %q, want its verified blob SHA", got)
+	}
+
+	cfg.Files = cfg.Files[:1]
+	saveTestConfig(t, dir, cfg)
+	verifyOpts := &SyncOptions{APIURL: apiURL, VerifyBlobs: true}
+	if err := Verify(context.Background(), configPath, verifyOpts); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// Edited with a matching lock, the file still differs from upstream.
+	a := filepath.Join(dir, "wpt", "url", "a.js")
+	if err := os.WriteFile(a, []byte("edited\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := newLockEntry(dir, cfg, cfg.Files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Files["url/a.js"] = entry
+	if err := saveLock(lockPath(configPath), lock, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(context.Background(), configPath, &SyncOptions{}); err != nil {
+		t.Errorf("Verify without blobs = %v, want the lock to match", err)
+	}
+	if err := Verify(context.Background(), configPath, verifyOpts); !errors.Is(err, ErrVerification) {
+		t.Errorf("Verify = %v, want the edited file reported against upstream", err)
+	}
+}
@@ -0,0 +1,52 @@
+package wptsync
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNoAPICalls marks GitHub API requests that were not made because the
+// run is offline or spent its API call budget (SyncOptions.Offline and
+// SyncOptions.Budget).
+var ErrNoAPICalls = errors.New("no GitHub API calls left")
+
+// apiBudget counts down the GitHub API requests a run may make. A nil
+// *apiBudget is unlimited.
+type apiBudget struct {
+	mu      sync.Mutex
+	left    int
+	total   int
+	offline bool
+}
+
+// newAPIBudget returns the budget o sets, or nil when it sets none.
+func newAPIBudget(o *SyncOptions) *apiBudget {
+	switch {
+	case o == nil:
+		return nil
+	case o.Offline:
+		return &apiBudget{offline: true}
+	case o.Budget > 0:
+		return &apiBudget{left: o.Budget, total: o.Budget}
+	}
+	return nil
+}
+
+// spend takes a request from b, or returns an error wrapping ErrNoAPICalls
+// when none is left.
+func (b *apiBudget) spend() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.offline {
+		return fmt.Errorf("%w: running offline", ErrNoAPICalls)
+	}
+	if b.left == 0 {
+		return fmt.Errorf("%w: the budget of %d was spent", ErrNoAPICalls, b.total)
+	}
+	b.left--
+	return nil
+}
@@ -0,0 +1,216 @@
+package wptsync
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// bundleScheme prefixes a Config.Source naming a local tarball of the
+// repository to sync from instead of the network, gitScheme one naming a
+// local clone of it.
+const (
+	bundleScheme = "bundle:"
+	gitScheme    = "git:"
+)
+
+// checkSource reports a malformed Config.Source or SyncOptions.Source.
+func checkSource(source string) error {
+	if source == "" {
+		return nil
+	}
+	for _, scheme := range []string{bundleScheme, gitScheme} {
+		if p, ok := strings.CutPrefix(source, scheme); ok {
+			if p == "" {
+				return fmt.Errorf("source %q: path is empty", source)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown source %q (want %q or %q followed by a path)", source, bundleScheme, gitScheme)
+}
+
+// source returns the source files are synced from: o's Source if set, or
+// else cfg's.
+func (o *SyncOptions) source(cfg *Config) string {
+	if o != nil && o.Source != "" {
+		return o.Source
+	}
+	return cfg.Source
+}
+
+// sourcePath returns the path of the source the sync of cfg reads with
+// scheme, relative paths being relative to root (the configuration's
+// directory), or "" when it reads from elsewhere.
+func (o *SyncOptions) sourcePath(root string, cfg *Config, scheme string) string {
+	p, ok := strings.CutPrefix(o.source(cfg), scheme)
+	if !ok || p == "" {
+		return ""
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, filepath.FromSlash(p))
+	}
+	return p
+}
+
+// bundlePath returns the path of the bundle the sync of cfg reads, or ""
+// when it does not read one.
+func (o *SyncOptions) bundlePath(root string, cfg *Config) string {
+	return o.sourcePath(root, cfg, bundleScheme)
+}
+
+// openBundle opens the tarball at p for reading, decompressing it according
+// to its extension: .tar, .tar.gz or .tgz, or .tar.zst or .tzst. Zstandard
+// is decompressed by the zstd command, which must be installed; closing the
+// reader reports it failing, such as on corrupt input.
+func openBundle(ctx context.Context, p string) (io.ReadCloser, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	switch name := strings.ToLower(p); {
+	case strings.HasSuffix(name, ".tar"):
+		return f, nil
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &bundleReader{Reader: gz, close: func() error { gz.Close(); return f.Close() }}, nil
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		if _, err := exec.LookPath("zstd"); err != nil {
+			f.Close()
+			return nil, errors.New("reading a .tar.zst bundle requires the zstd command; install it or recompress the bundle with gzip")
+		}
+		cmd := exec.CommandContext(ctx, "zstd", "-d", "-c", "-q")
+		cmd.Stdin = f
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("run zstd: %w", err)
+		}
+		return &bundleReader{Reader: out, close: func() error {
+			// Reading may stop early; the pipe closing ends zstd, which
+			// then fails writing to it, and that is no error.
+			out.Close()
+			err := cmd.Wait()
+			if err != nil && brokenPipe(err, stderr.String()) {
+				err = nil
+			}
+			if err != nil {
+				err = fmt.Errorf("zstd: %w: %s", err, strings.TrimSpace(stderr.String()))
+			}
+			return errors.Join(err, f.Close())
+		}}, nil
+	}
+	f.Close()
+	return nil, fmt.Errorf("%s: unknown bundle format (want .tar, .tar.gz, .tgz, .tar.zst, or .tzst)", p)
+}
+
+// brokenPipe reports whether err, with stderr, is a command failing only
+// because the read end of its output pipe was closed.
+func brokenPipe(err error, stderr string) bool {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() && ws.Signal() == syscall.SIGPIPE {
+			return true
+		}
+	}
+	return strings.Contains(stderr, "Broken pipe")
+}
+
+// bundleReader is a decompressed bundle.
+type bundleReader struct {
+	io.Reader
+	close func() error
+}
+
+func (r *bundleReader) Close() error { return r.close() }
+
+// stageBundle extracts files from the bundle the sync of cfg reads into a
+// staging directory, as stageArchive does for a downloaded tarball. The
+// bundle must be of cfg's commit when it records one.
+func stageBundle(ctx context.Context, root string, cfg *Config, files []FileSpec, opts *SyncOptions) (*SyncOptions, func(), error) {
+	bundle := opts.bundlePath(root, cfg)
+	staging, cleanup, err := newStaging(root, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	wanted := wantedSrcs(files)
+
+	opts.logf("Reading bundle %s\n", bundle)
+	r, err := openBundle(ctx, bundle)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("open bundle: %w", err)
+	}
+	n, commit, err := extractTar(r, staging, wanted)
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && commit != "" && !sameCommit(commit, cfg.Commit) {
+		err = fmt.Errorf("bundle is of commit %s, but the configuration pins %s", shortSHA(commit), shortSHA(cfg.Commit))
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("read bundle %s: %w", bundle, err)
+	}
+	opts.logf("Extracted %d of %d files from the bundle\n", n, len(wanted))
+
+	cp := *opts
+	cp.staged = staging
+	return &cp, cleanup, nil
+}
+
+// sameCommit reports whether a and b name the same commit, either being
+// possibly abbreviated.
+func sameCommit(a, b string) bool {
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// bundleFiles lists the regular files in the bundle at p, as paths relative
+// to the repository root, for expanding glob entries without the network.
+func bundleFiles(ctx context.Context, p string) (files []string, err error) {
+	r, err := openBundle(ctx, p)
+	if err != nil {
+		return nil, fmt.Errorf("open bundle: %w", err)
+	}
+	defer func() {
+		if cerr := r.Close(); err == nil && cerr != nil {
+			files, err = nil, fmt.Errorf("read bundle %s: %w", p, cerr)
+		}
+	}()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read bundle %s: %w", p, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if _, name, ok := strings.Cut(hdr.Name, "/"); ok {
+			files = append(files, name)
+		}
+	}
+}
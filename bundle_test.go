@@ -0,0 +1,132 @@
+package wptsync
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncFromBundle(t *testing.T) {
+	server, dir, requests := newFixture(t, nil)
+	bundle := makeTarball(t, "c1", map[string]string{
+		"url/a.any.js":           "a\n",
+		"url/resources/one.json": "1\n",
+		"url/resources/two.json": "2\n",
+		"css/ignored.js":         "x\n",
+	})
+	if err := os.WriteFile(filepath.Join(dir, "wpt-bundle.tar.gz"), []byte(bundle), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Source:    "bundle:wpt-bundle.tar.gz",
+		Files:     []FileSpec{{Src: "url/a.any.js", Dst: "url/a.js"}, {Src: "url/resources/*.json"}},
+	})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, APIURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := requests(); got != 0 {
+		t.Errorf("made %d requests, want none", got)
+	}
+	for rel, want := range map[string]string{"url/a.js": "a\n", "url/resources/one.json": "1\n", "url/resources/two.json": "2\n"} {
+		if got, _ := os.ReadFile(filepath.Join(dir, "wpt", filepath.FromSlash(rel))); string(got) != want {
+			t.Errorf("%s = %q, want %q", rel, got, want)
+		}
+	}
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestSyncRejectsBundleOfAnotherCommit(t *testing.T) {
+	// git archive records the commit in a pax global header.
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header", PAXRecords: map[string]string{"comment": strings.Repeat("b", 40)}})
+	tw.WriteHeader(&tar.Header{Name: "wpt/a.js", Mode: 0o644, Size: 2, Typeflag: tar.TypeReg})
+	tw.Write([]byte("a\n"))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "wpt.tar"), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    strings.Repeat("a", 40),
+		TargetDir: "wpt",
+		Source:    "bundle:wpt.tar",
+		Files:     []FileSpec{{Src: "a.js"}},
+	})
+	err := Sync(context.Background(), configPath, nil)
+	if err == nil || !strings.Contains(err.Error(), "bundle is of commit bbbbbbbbbb") {
+		t.Errorf("Sync = %v, want a commit mismatch", err)
+	}
+}
+
+func TestSyncFromZstdBundle(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd not on PATH")
+	}
+	dir := t.TempDir()
+	gz, err := gzip.NewReader(strings.NewReader(makeTarball(t, "c1", map[string]string{"url/b.js": "b\n"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarball, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("zstd", "-q", "-o", filepath.Join(dir, "wpt.tar.zst"))
+	cmd.Stdin = bytes.NewReader(tarball)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("zstd: %v: %s", err, out)
+	}
+
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Source: "bundle:wpt.tar.zst", Files: []FileSpec{{Src: "url/b.js"}}})
+	if err := Sync(context.Background(), configPath, nil); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "url", "b.js")); string(got) != "b\n" {
+		t.Errorf("b.js = %q, want %q", got, "b\n")
+	}
+}
+
+func TestSyncFromCorruptZstdBundle(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd not on PATH")
+	}
+	dir := t.TempDir()
+	gz, err := gzip.NewReader(strings.NewReader(makeTarball(t, "c1", map[string]string{"url/b.js": "b\n"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarball, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("zstd", "-q", "-c")
+	cmd.Stdin = bytes.NewReader(tarball)
+	compressed, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("zstd: %v", err)
+	}
+	// The tarball decompresses whole, but zstd fails on what follows it.
+	if err := os.WriteFile(filepath.Join(dir, "wpt.tar.zst"), append(compressed, "garbage"...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Source: "bundle:wpt.tar.zst", Files: []FileSpec{{Src: "url/b.js"}}})
+	if err := Sync(context.Background(), configPath, nil); err == nil || !strings.Contains(err.Error(), "zstd") {
+		t.Errorf("Sync = %v, want zstd's failure", err)
+	}
+}
@@ -0,0 +1,167 @@
+package wptsync
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultCacheSize is the size the download cache is trimmed to when
+// SyncOptions.CacheSize is zero.
+const DefaultCacheSize = 1 << 30
+
+// DefaultCacheDir returns the download cache under the user's cache
+// directory (e.g. ~/.cache/wptsync on Linux), or "" if there is none.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "wptsync")
+}
+
+// cachePath returns where the upstream content of src at commit is cached,
+// or "" when caching is off. Only full commit SHAs are cached, since any
+// other ref can move; recording and replay runs bypass the cache so they
+// see every request.
+func (o *SyncOptions) cachePath(commit, src string) string {
+	if o == nil || o.CacheDir == "" || o.RecordDir != "" || o.ReplayDir != "" || !isFullSHA(commit) {
+		return ""
+	}
+	return filepath.Join(o.CacheDir, commit, filepath.FromSlash(src))
+}
+
+// isFullSHA reports whether s is a 40-character hexadecimal commit SHA.
+func isFullSHA(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	return strings.Trim(s, "0123456789abcdef") == ""
+}
+
+// loadCached copies the cached file at p to dest, reporting whether there
+// was one. A hit refreshes the entry's modification time, which eviction
+// treats as its last use. An entry failing its integrity check is deleted
+// and counts as a miss.
+func loadCached(p, dest string) bool {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return false
+	}
+	content, err := decodeState(data)
+	if err != nil {
+		os.Remove(p)
+		return false
+	}
+	if err := writeFileAtomic(dest, content, 0o644); err != nil {
+		return false
+	}
+	now := time.Now()
+	os.Chtimes(p, now, now)
+	return true
+}
+
+// storeCached copies the downloaded file src into the cache at p,
+// compressed (see encodeState). Failing to cache never fails a sync.
+func storeCached(p, src string) {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return
+	}
+	if content, err = encodeState(content, true); err != nil {
+		return
+	}
+	writeFileAtomic(p, content, 0o644)
+}
+
+// trimCache evicts the least recently used files under o.CacheDir until it
+// holds at most o.CacheSize bytes (DefaultCacheSize when zero), then removes
+// the directories that left empty.
+func (o *SyncOptions) trimCache() error {
+	if o == nil || o.CacheDir == "" {
+		return nil
+	}
+	limit := o.CacheSize
+	if limit == 0 {
+		limit = DefaultCacheSize
+	}
+
+	type entry struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var entries []entry
+	var total int64
+	err := filepath.WalkDir(o.CacheDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{p, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil || total <= limit {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+	for _, e := range entries {
+		if total <= limit {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		total -= e.size
+		removeEmptyDirs(filepath.Dir(e.path), o.CacheDir)
+	}
+	return nil
+}
+
+// CleanCache deletes the download cache at dir and returns how many bytes
+// it held.
+func CleanCache(dir string) (int64, error) {
+	if dir == "" {
+		return 0, invalidConfig(errors.New("no cache directory"))
+	}
+	var size int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("scan cache: %w", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return 0, fmt.Errorf("remove cache: %w", err)
+	}
+	return size, nil
+}
@@ -0,0 +1,62 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncServesRepeatedDownloadsFromCache(t *testing.T) {
+	commit := strings.Repeat("ab", 20)
+	server, dir, requestCount := newFixture(t, map[string]string{"/" + commit + "/a/foo.js": "content A\n"})
+	configPath := saveTestConfig(t, dir, &Config{Commit: commit, TargetDir: "wpt", Files: []FileSpec{{Src: "a/foo.js"}}})
+	cacheDir := t.TempDir()
+
+	for range 2 {
+		if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Force: true, CacheDir: cacheDir}); err != nil {
+			t.Fatalf("Sync: %v", err)
+		}
+	}
+	if got := requestCount(); got != 1 {
+		t.Errorf("requests = %d, want the second sync served from the cache", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "a", "foo.js")); string(got) != "content A\n" {
+		t.Errorf("synced content = %q", got)
+	}
+
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Force: true}); err != nil {
+		t.Fatalf("Sync without cache: %v", err)
+	}
+	if got := requestCount(); got != 2 {
+		t.Errorf("requests = %d, want a download when the cache is off", got)
+	}
+}
+
+func TestTrimCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cacheDir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	for i, name := range []string{"c1/old.js", "c2/new.js"} {
+		p := filepath.Join(cacheDir, filepath.FromSlash(name))
+		if err := writeFileAtomic(p, []byte(strings.Repeat("x", 10)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			os.Chtimes(p, old, old)
+		}
+	}
+
+	opts := &SyncOptions{CacheDir: cacheDir, CacheSize: 15}
+	if err := opts.trimCache(); err != nil {
+		t.Fatalf("trimCache: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "c1")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("least recently used entry and its directory survived: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "c2", "new.js")); err != nil {
+		t.Errorf("recent entry evicted: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ChangesOptions configures a Changes run. A nil *ChangesOptions is
+// equivalent to its zero value.
+type ChangesOptions struct {
+	SyncOptions
+	// From is the commit to compare from. Empty means the pinned commit.
+	From string
+	// To is the commit to compare to. Empty means the latest commit of the
+	// upstream ref.
+	To string
+}
+
+// FileChange is a configured source that changed upstream.
+type FileChange struct {
+	Src string `json:"src"`
+	// Dst is the entry's dst, or empty for a file only a glob entry
+	// matches.
+	Dst string `json:"dst,omitempty"`
+	// Status is how the compare API reports the change: "added",
+	// "modified", "removed", or "renamed" (from PreviousSrc).
+	Status      string `json:"status"`
+	PreviousSrc string `json:"previous_src,omitempty"`
+	// Commits lists the commits that touched Src, newest first.
+	Commits []ChangeCommit `json:"commits"`
+}
+
+// ChangeCommit is a commit in a FileChange.
+type ChangeCommit struct {
+	SHA string `json:"sha"`
+	// Title is the first line of the commit message.
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// ChangesReport is the result of Changes.
+type ChangesReport struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Files lists the configured sources that changed, in path order.
+	Files []FileChange `json:"files"`
+	// Upstream is how many files changed upstream in all.
+	Upstream int `json:"upstream"`
+	// Complete is false when GitHub's change list was truncated, so that
+	// configured files may be missing from Files.
+	Complete bool `json:"complete"`
+	// Limited says why some files lack their Commits, when
+	// SyncOptions.Budget ran out before they were listed.
+	Limited string `json:"limited,omitempty"`
+}
+
+// Changes reports which sources the configuration at configPath syncs were
+// added, modified, removed, or renamed upstream between opts.From and
+// opts.To, with the commits that touched them, to judge whether a commit
+// bump is worth it before running update. Glob entries count every file
+// they match at either commit. Disabled, pinned, and frozen files are left
+// out, as update does not move them. Once opts.Budget is spent, the files left are
+// reported without their commits.
+func Changes(ctx context.Context, configPath string, opts *ChangesOptions) (*ChangesReport, error) {
+	if opts == nil {
+		opts = &ChangesOptions{}
+	}
+	if err := opts.SyncOptions.validate(); err != nil {
+		return nil, err
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	syncOpts := opts.SyncOptions.forConfig(cfg)
+
+	ctx, cancel := withTimeout(ctx, syncOpts.timeouts().Resolve)
+	defer cancel()
+	gh := syncOpts.github()
+	report := &ChangesReport{From: opts.From, To: opts.To, Files: []FileChange{}, Complete: true}
+	if report.From == "" {
+		report.From = cfg.Commit
+	}
+	if report.To == "" {
+		if report.To, err = gh.latestCommit(ctx); err != nil {
+			return nil, fmt.Errorf("fetch latest commit: %w", err)
+		}
+	}
+	if sameCommit(report.From, report.To) {
+		return report, nil
+	}
+
+	files, complete, err := gh.compare(ctx, report.From, report.To)
+	if err != nil {
+		return nil, err
+	}
+	report.Upstream, report.Complete = len(files), complete
+
+	dsts, configured := movingSrcs(cfg)
+	for _, f := range files {
+		if !configured(f.Filename) && !configured(f.PreviousFilename) {
+			continue
+		}
+		dst, ok := dsts[f.Filename]
+		if !ok {
+			dst = dsts[f.PreviousFilename]
+		}
+		report.Files = append(report.Files, FileChange{Src: f.Filename, Dst: dst, Status: f.Status, PreviousSrc: f.PreviousFilename})
+	}
+	if len(report.Files) == 0 {
+		return report, nil
+	}
+
+	since, err := gh.commitDate(ctx, report.From)
+	if errors.Is(err, ErrNoAPICalls) {
+		report.Limited = err.Error()
+		return report, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range report.Files {
+		commits, err := gh.pathCommits(ctx, report.To, f.Src, since)
+		if errors.Is(err, ErrNoAPICalls) {
+			report.Limited = err.Error()
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.Files[i].Commits = []ChangeCommit{}
+		for _, c := range commits {
+			// since is inclusive, and From is not part of the change.
+			if sameCommit(c.SHA, report.From) {
+				continue
+			}
+			title, _, _ := strings.Cut(c.Commit.Message, "\n")
+			report.Files[i].Commits = append(report.Files[i].Commits, ChangeCommit{SHA: c.SHA, Title: title, URL: c.HTMLURL})
+		}
+	}
+	slices.SortFunc(report.Files, func(a, b FileChange) int { return strings.Compare(a.Src, b.Src) })
+	return report, nil
+}
+
+// movingSrcs returns the dst of each src of cfg that update moves to a new
+// commit (its enabled files that are neither pinned nor frozen), and a
+// function reporting whether a path upstream is one of them or matches one
+// of its glob entries.
+func movingSrcs(cfg *Config) (map[string]string, func(p string) bool) {
+	dsts := make(map[string]string)
+	var globs []string
+	for _, f := range cfg.Files {
+		switch {
+		case !f.IsEnabled() || f.Commit != "" || f.Frozen:
+		case isGlob(f.Src):
+			globs = append(globs, f.Src)
+		default:
+			src := strings.TrimLeft(f.Src, "/")
+			if _, ok := dsts[src]; !ok {
+				dsts[src] = f.Dst
+			}
+		}
+	}
+	return dsts, func(p string) bool {
+		_, ok := dsts[p]
+		return p != "" && (ok || slices.ContainsFunc(globs, func(pattern string) bool { return matchGlob(pattern, p) }))
+	}
+}
@@ -0,0 +1,60 @@
+package wptsync
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestChangesListsConfiguredFiles(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	commits := func(p string) string {
+		return "/repos/o/n/commits?" + url.Values{"sha": {"c3"}, "path": {p}, "since": {"2024-01-02T03:04:05Z"}, "per_page": {"100"}}.Encode()
+	}
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/commits/master": `{"sha":"c3"}`,
+		"/repos/o/n/commits/c1":     `{"sha":"c1","commit":{"committer":{"date":"2024-01-02T03:04:05Z"}}}`,
+		"/repos/o/n/compare/c1...c3": `{"files":[
+			{"filename":"a.js","status":"modified"},
+			{"filename":"moved.js","previous_filename":"old.js","status":"renamed"},
+			{"filename":"url/new.json","status":"added"},
+			{"filename":"pinned.js","status":"modified"},
+			{"filename":"off.js","status":"removed"},
+			{"filename":"unrelated.js","status":"modified"}
+		]}`,
+		commits("a.js"): `[
+			{"sha":"c3","html_url":"https://github.com/o/n/commit/c3","commit":{"message":"Fix a.js\n\nDetails."}},
+			{"sha":"c1","html_url":"https://github.com/o/n/commit/c1","commit":{"message":"Older"}}
+		]`,
+		commits("moved.js"):     `[{"sha":"c2","html_url":"https://github.com/o/n/commit/c2","commit":{"message":"Move old.js"}}]`,
+		commits("url/new.json"): `[]`,
+	})
+	off := false
+	configPath := saveTestConfig(t, t.TempDir(), &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{
+		{Src: "a.js", Dst: "lib/a.js"},
+		{Src: "old.js"},
+		{Src: "url/*.json"},
+		{Src: "pinned.js", Commit: "c0"},
+		{Src: "off.js", Enabled: &off},
+	}})
+
+	report, err := Changes(context.Background(), configPath, &ChangesOptions{SyncOptions: SyncOptions{APIURL: apiURL}})
+	if err != nil {
+		t.Fatalf("Changes: %v", err)
+	}
+	want := &ChangesReport{From: "c1", To: "c3", Upstream: 6, Complete: true, Files: []FileChange{
+		{Src: "a.js", Dst: "lib/a.js", Status: "modified", Commits: []ChangeCommit{{SHA: "c3", Title: "Fix a.js", URL: "https://github.com/o/n/commit/c3"}}},
+		{Src: "moved.js", Dst: "old.js", Status: "renamed", PreviousSrc: "old.js", Commits: []ChangeCommit{{SHA: "c2", Title: "Move old.js", URL: "https://github.com/o/n/commit/c2"}}},
+		{Src: "url/new.json", Status: "added", Commits: []ChangeCommit{}},
+	}}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v\nwant %+v", report, want)
+	}
+
+	// Comparing a commit with itself asks GitHub nothing more.
+	report, err = Changes(context.Background(), configPath, &ChangesOptions{SyncOptions: SyncOptions{APIURL: apiURL}, To: "c1"})
+	if err != nil || len(report.Files) != 0 {
+		t.Errorf("Changes to the pinned commit = %+v, %v; want no files", report, err)
+	}
+}
@@ -0,0 +1,109 @@
+package wptsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// checkoutGit returns a git command run in the clone at dir.
+func checkoutGit(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	// The clone may belong to another user, such as a shared mirror; naming
+	// it as the source is trust enough.
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "safe.directory=*", "-C", dir}, args...)...)
+	cmd.Env = subprocessEnv()
+	return cmd
+}
+
+// runCheckoutGit runs git in the clone at dir and returns its output. Errors
+// carry what git printed on stderr.
+func runCheckoutGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	out, err := checkoutGit(ctx, dir, args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return nil, fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// stageCheckout checks that the clone at dir holds the commits files are
+// synced at, and returns a copy of opts whose fetches read files from it
+// (see readCheckout) instead of downloading them.
+func stageCheckout(ctx context.Context, dir string, cfg *Config, files []FileSpec, opts *SyncOptions) (*SyncOptions, func(), error) {
+	checked := make(map[string]bool)
+	for _, f := range files {
+		commit := cfg.commitOf(f)
+		if checked[commit] {
+			continue
+		}
+		checked[commit] = true
+		if _, err := runCheckoutGit(ctx, dir, "cat-file", "-e", commit+"^{commit}"); err != nil {
+			return nil, nil, fmt.Errorf("commit %s is not in the clone at %s; fetch it there first: %w", shortSHA(commit), dir, err)
+		}
+	}
+	opts.logf("Reading files from the clone at %s\n", dir)
+
+	cp := *opts
+	cp.checkout = dir
+	return &cp, func() {}, nil
+}
+
+// readCheckout writes src at commit, read from the clone at dir, to dest,
+// replacing dest by rename like download does.
+func readCheckout(ctx context.Context, dir, commit, src, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("create destination directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".wpt-download-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	cmd := checkoutGit(ctx, dir, "cat-file", "blob", commit+":"+src)
+	var stderr bytes.Buffer
+	cmd.Stdout = tmp
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	tmp.Close()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("read %s from the clone at %s: %s", src, dir, msg)
+		}
+		return fmt.Errorf("read %s from the clone at %s: %w", src, dir, err)
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// checkoutFiles lists the regular files below base ("" for the whole tree)
+// at commit in the clone at dir, as paths relative to the repository root,
+// for expanding glob entries without the network.
+func checkoutFiles(ctx context.Context, dir, commit, base string) ([]string, error) {
+	args := []string{"ls-tree", "-r", "-z", "--full-tree", commit}
+	if base != "" {
+		args = append(args, "--", base)
+	}
+	out, err := runCheckoutGit(ctx, dir, args...)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, line := range strings.Split(string(out), "\x00") {
+		// Lines are "<mode> <type> <object>\t<path>"; submodules are
+		// commits, not blobs.
+		info, p, ok := strings.Cut(line, "\t")
+		if !ok || strings.Fields(info)[1] != "blob" {
+			continue
+		}
+		files = append(files, p)
+	}
+	return files, nil
+}
@@ -0,0 +1,119 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newClone creates a git repository under dir/name with a commit per
+// element of commits, each writing the given files, and returns the commit
+// SHAs.
+func newClone(t *testing.T, dir, name string, commits ...map[string]string) []string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not on PATH")
+	}
+	repo := filepath.Join(dir, name)
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com", "GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	git("init", "-q")
+	var shas []string
+	for i, files := range commits {
+		for rel, content := range files {
+			p := filepath.Join(repo, filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		git("add", "-A")
+		git("commit", "-q", "-m", string(rune('A'+i)))
+		shas = append(shas, git("rev-parse", "HEAD"))
+	}
+	return shas
+}
+
+func TestSyncFromClone(t *testing.T) {
+	server, dir, requests := newFixture(t, nil)
+	shas := newClone(t, dir, "wpt-clone",
+		map[string]string{"url/a.js": "a v1\n", "url/resources/one.json": "1\n", "resources/testharness.js": "th v1\n"},
+		map[string]string{"url/a.js": "a v2\n", "url/resources/two.json": "2\n", "resources/testharness.js": "th v2\n"},
+	)
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    shas[1],
+		TargetDir: "wpt",
+		Source:    "git:wpt-clone",
+		Files: []FileSpec{
+			{Src: "url/a.js"},
+			{Src: "url/resources/*.json"},
+			{Src: "resources/testharness.js", Commit: shas[0]},
+		},
+	})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, APIURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := requests(); got != 0 {
+		t.Errorf("made %d requests, want none", got)
+	}
+	for rel, want := range map[string]string{
+		"url/a.js":                 "a v2\n",
+		"url/resources/one.json":   "1\n",
+		"url/resources/two.json":   "2\n",
+		"resources/testharness.js": "th v1\n",
+	} {
+		if got, _ := os.ReadFile(filepath.Join(dir, "wpt", filepath.FromSlash(rel))); string(got) != want {
+			t.Errorf("%s = %q, want %q", rel, got, want)
+		}
+	}
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestSyncSourceOption(t *testing.T) {
+	server, dir, requests := newFixture(t, nil)
+	shas := newClone(t, dir, "mirror", map[string]string{"url/a.js": "a\n"})
+	missing := strings.Repeat("f", 40)
+	configPath := saveTestConfig(t, dir, &Config{Commit: missing, TargetDir: "wpt", Files: []FileSpec{{Src: "url/a.js"}}})
+
+	// The clone lacks the pinned commit.
+	opts := &SyncOptions{BaseURL: server.URL, Source: "git:" + filepath.Join(dir, "mirror")}
+	err := Sync(context.Background(), configPath, opts)
+	if err == nil || !strings.Contains(err.Error(), "fetch it there first") {
+		t.Fatalf("Sync = %v, want the commit reported missing from the clone", err)
+	}
+
+	saveTestConfig(t, dir, &Config{Commit: shas[0], TargetDir: "wpt", Files: []FileSpec{{Src: "url/a.js"}}})
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "url", "a.js")); string(got) != "a\n" {
+		t.Errorf("a.js = %q, want %q", got, "a\n")
+	}
+	if got := requests(); got != 0 {
+		t.Errorf("made %d requests, want none", got)
+	}
+
+	opts.Source = "svn:" + dir
+	if err := Sync(context.Background(), configPath, opts); ExitCode(err) != ExitConfig {
+		t.Errorf("Sync with an unknown source = %v, want a configuration error", err)
+	}
+}
@@ -0,0 +1,148 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// CheckPatchesOptions configures a CheckPatches run. A nil
+// *CheckPatchesOptions is equivalent to its zero value.
+type CheckPatchesOptions struct {
+	SyncOptions
+	// Commit is the upstream commit to check the patches against. Empty
+	// means the pinned commit, unless Latest is set.
+	Commit string
+	// Latest checks against the head of the upstream ref instead, to see
+	// which patches an update would break.
+	Latest bool
+}
+
+// PatchCheck is the outcome of checking one file's patch.
+type PatchCheck struct {
+	Dst   string
+	Patch string
+	// Err is nil when the patch applies cleanly. Otherwise it wraps
+	// ErrPatchFailed.
+	Err error
+	// Conflicts lists the hunks that do not apply, when known (the
+	// built-in applier reports them; git apply does not).
+	Conflicts []HunkConflict
+	// Fuzz lists the hunks of a patch that applies that only did with
+	// fuzz (see Config.PatchFuzz).
+	Fuzz []HunkFuzz
+}
+
+// CheckPatches downloads every enabled, patched file in the configuration at
+// configPath (frozen files aside, as sync never re-patches them) into a
+// temporary directory and tries its patch there, leaving target_dir
+// untouched. It returns the commit checked and one PatchCheck per
+// patch in configuration order; the error wraps ErrPatchFailed when any
+// patch does not apply.
+func CheckPatches(ctx context.Context, configPath string, opts *CheckPatchesOptions) (string, []PatchCheck, error) {
+	if opts == nil {
+		opts = &CheckPatchesOptions{}
+	}
+	if err := opts.SyncOptions.validate(); err != nil {
+		return "", nil, err
+	}
+	root, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return "", nil, fmt.Errorf("determine repo root from config: %w", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return "", nil, err
+	}
+	syncOpts := opts.SyncOptions.forConfig(cfg)
+
+	commit := opts.Commit
+	switch {
+	case commit != "":
+	case opts.Latest:
+		resolveCtx, cancel := withTimeout(ctx, syncOpts.timeouts().Resolve)
+		defer cancel()
+		if commit, err = syncOpts.github().latestCommit(resolveCtx); err != nil {
+			return "", nil, fmt.Errorf("fetch latest commit: %w", err)
+		}
+	default:
+		commit = cfg.Commit
+	}
+
+	var files []FileSpec
+	for _, f := range cfg.Files {
+		if f.IsEnabled() && !f.Frozen && f.Patch != "" {
+			files = append(files, f)
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "wptsync-check-")
+	if err != nil {
+		return "", nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	checks := make([]PatchCheck, len(files))
+	workerOpts := syncOpts.serialized()
+	err = forEachFile(ctx, syncOpts.jobs(), len(files), func(ctx context.Context, i int) error {
+		file := files[i]
+		checks[i] = PatchCheck{Dst: file.Dst, Patch: file.Patch}
+
+		// Each file gets its own root, laid out like the real one, since
+		// patches name their files relative to the config directory.
+		fileRoot := filepath.Join(tmpDir, fmt.Sprint(i))
+		dest := filepath.Join(fileRoot, filepath.FromSlash(path.Join(cfg.TargetDir, file.Dst)))
+		src := strings.TrimLeft(file.Src, "/")
+		downloadCtx, cancel := withTimeout(ctx, syncOpts.timeouts().Download)
+		defer cancel()
+		// Pinned files stay at their pin whatever commit is checked.
+		fileCommit := commit
+		if file.Commit != "" {
+			fileCommit = file.Commit
+		}
+		if err := fetchFile(downloadCtx, fileCommit, src, dest, workerOpts); err != nil {
+			return fmt.Errorf("download %s: %w", src, err)
+		}
+		if err := applyTransforms(dest, file); err != nil {
+			return fmt.Errorf("transform %s: %w", src, err)
+		}
+
+		patchPath := file.Patch
+		if !filepath.IsAbs(patchPath) {
+			patchPath = filepath.Join(root, filepath.FromSlash(patchPath))
+		}
+		patchCtx, cancel := withTimeout(ctx, syncOpts.timeouts().Patch)
+		defer cancel()
+		fuzzed, err := applyPatch(patchCtx, fileRoot, patchPath, syncOpts.UseGit, cfg.patchFuzz())
+		if err != nil && !errors.Is(err, ErrPatchFailed) {
+			return fmt.Errorf("apply patch %s: %w", file.Patch, err)
+		}
+		checks[i].Err, checks[i].Fuzz = err, fuzzed
+		var he *hunkError
+		if errors.As(err, &he) {
+			checks[i].Conflicts = he.conflicts
+		}
+		return nil
+	})
+	if err != nil {
+		return commit, nil, err
+	}
+
+	failed := 0
+	for _, c := range checks {
+		if c.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return commit, checks, fmt.Errorf("%w: %d of %d patch(es) at %s", ErrPatchFailed, failed, len(checks), shortSHA(commit))
+	}
+	return commit, checks, nil
+}
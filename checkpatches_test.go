@@ -0,0 +1,35 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPatchesReportsConflicts(t *testing.T) {
+	server, dir, configPath := newPatchFixture(t)
+	// c2 changed the line the patch rewrites.
+	content := map[string]string{"/c2/patch/target.js": "line1\nline2 upstream\nline3\n"}
+	server2, _, _ := newFixture(t, content)
+
+	_, checks, err := CheckPatches(context.Background(), configPath, &CheckPatchesOptions{SyncOptions: SyncOptions{BaseURL: server.URL}})
+	if err != nil {
+		t.Fatalf("CheckPatches at the pinned commit: %v", err)
+	}
+	if len(checks) != 1 || checks[0].Err != nil {
+		t.Errorf("checks = %+v, want one passing patch", checks)
+	}
+
+	commit, checks, err := CheckPatches(context.Background(), configPath, &CheckPatchesOptions{SyncOptions: SyncOptions{BaseURL: server2.URL}, Commit: "c2"})
+	if !errors.Is(err, ErrPatchFailed) {
+		t.Fatalf("CheckPatches at c2: expected ErrPatchFailed, got %v", err)
+	}
+	if commit != "c2" || len(checks) != 1 || len(checks[0].Conflicts) != 1 || checks[0].Conflicts[0] != (HunkConflict{Hunk: 1, Line: 1}) {
+		t.Errorf("commit %q, checks = %+v, want hunk #1 at line 1 in conflict", commit, checks)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wpt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("CheckPatches wrote into target_dir: %v", err)
+	}
+}
@@ -3,11 +3,23 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"math/rand/v2"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/oleiade/wptsync"
 )
@@ -19,56 +31,172 @@ Usage:
 
 Commands:
   init    Create a new wpt.json configuration file
+  import  Create wpt.json from files already vendored by hand
   add     Add files from a WPT folder to the configuration
+  remove  Remove files or folders from the configuration
+  suite   Add, update, remove, and report on named suites such as url or encoding
+  prune   Delete synced files the configuration no longer lists
   sync    Download WPT files according to the configuration (default)
   update  Bump the pinned commit and re-sync, reporting broken patches
+  watch   Keep the checkout updated on a schedule, running a command after each update
   edit    Restore one file to its synced state (pristine + patch) for editing
   save    Regenerate a file's patch from its on-disk edits
+  diff    Print or save a file's on-disk edits as a patch
+  check-patches
+          Check that every patch applies, at the pinned or latest commit
+  verify  Check that synced files still match the lock file
+  scan    Check synced files for denied licenses, credentials, and URLs
+  validate
+          Check the configuration file for typos and mistakes
+  merge-config
+          Merge two versions of wpt.json entry by entry, as a git merge driver
+  notarize
+          Record the synced tree's provenance in a git note or trailers
+  publish Push the synced tree and its provenance to another repository
+  status  Show local and upstream changes for every file
+  changes List configured files changed upstream between two commits
+  schema  Print the JSON Schema for the configuration file
+  cache   Manage the download cache ('wptsync cache clean' empties it)
+  prefetch
+          Fill the download cache ahead of a sync or update
+  stats   Show trends in sync duration, size, and failures
+  badge   Write a JSON or SVG badge showing how far the pin is behind
+  usage   Show the local usage counters kept with -report-usage
+  runner-config
+          Print each synced test's META timeout, globals, variants, and scripts
 
 Examples:
   wptsync init                   Create wpt.json with the latest WPT commit
+  wptsync import -commit 0123abcd wpt/
+                                 Adopt a hand-vendored wpt/ directory
   wptsync add url/               Add all files from the url/ folder
   wptsync add encoding/          Add all files from encoding/ recursively
+  wptsync add -include '*.json' url/resources/
+                                 Add the JSON resources under url/resources/
+  wptsync add -type testharness dom/
+                                 Add only the testharness tests under dom/
+  wptsync add -split-scopes -scopes window,sharedworker url/
+                                 Add url/ tests once per scope
+  wptsync add -with-deps encoding/
+                                 Add encoding/ and the helper scripts its tests load
+  wptsync remove -purge url/     Untrack url/ and delete its synced files
+  wptsync suite add -preset testharness streams streams/
+                                 Vendor the streams tests and their resources as a suite
+  wptsync suite update streams   Pick up new streams tests and sync the suite
+  wptsync prune -dry-run         List synced files no entry maps to any more
   wptsync                        Sync files using wpt.json
   wptsync sync -dry-run          Preview what would be synced
+  wptsync sync -all              Sync every wpt.json under the current directory
   wptsync update                 Bump to the latest WPT commit and re-sync
+  wptsync update -check          Exit 1 if a newer WPT commit exists
+  wptsync prefetch -latest -background
+                                 Download the next update's files while you work
+  wptsync watch -interval 24h -on-upstream-change -run ./open-pr.sh
+                                 Update nightly when configured files change, then open a PR
   wptsync edit common/sab.js     Restore a file before editing it
   wptsync save common/sab.js     Save on-disk edits as the file's patch
+  wptsync diff -o patches/sab.patch common/sab.js
+                                 Save on-disk edits to a chosen patch file
+  wptsync check-patches -latest  List the patches the next update would break
+  wptsync verify                 Fail if local files drifted from wpt.lock
+  wptsync scan                   Check the synced tree against the scan policy
+  wptsync validate               Report unknown keys and bad entries in wpt.json
+  wptsync status                 List modified, missing, and upstream-changed files
+  wptsync changes                List configured files the next update would change
+  wptsync notarize               Attach provenance to HEAD as a git note
+  wptsync stats -runs            List recent runs and compare them with earlier ones
+  wptsync badge -o wpt-badge.svg Write a badge with the pin's age for the README
+  wptsync schema -o wpt.schema.json
+                                 Write the config JSON Schema for editors
+  wptsync runner-config -o tests.json
+                                 Write test metadata for a harness integration
+
+Exit codes:
+  1  other failure (and update -check when a newer commit exists)
+  2  configuration or usage error
+  3  network error
+  4  patch conflict
+  5  content verification failure
+  6  local files drifted from wpt.lock
+  7  nothing to do (sync, status, changes, and update with -format json only)
+  8  partial failure: some files synced, others failed (-format json only)
+  9  the scan policy flagged synced files (scan, and sync and update in strict mode)
+
+Aliases and the command a bare 'wptsync' runs can be set in the user config,
+~/.config/wptsync/config on Linux, e.g.:
+  {"default_command": "status", "aliases": {"up": "update -check"}}
 
 Run 'wptsync <command> -h' for more information on a command.
 `
 
+// commands maps each command name to the function running it with the
+// arguments that follow the name.
+var commands = map[string]func(args []string){
+	"init":          runInitCommand,
+	"import":        runImportCommand,
+	"add":           runAddCommand,
+	"remove":        runRemoveCommand,
+	"suite":         runSuiteCommand,
+	"prune":         runPruneCommand,
+	"sync":          runSyncCommand,
+	"update":        runUpdateCommand,
+	"watch":         runWatchCommand,
+	"edit":          runEditCommand,
+	"save":          runSaveCommand,
+	"diff":          runDiffCommand,
+	"check-patches": runCheckPatchesCommand,
+	"verify":        runVerifyCommand,
+	"scan":          runScanCommand,
+	"validate":      runValidateCommand,
+	"merge-config":  runMergeConfigCommand,
+	"notarize":      runNotarizeCommand,
+	"publish":       runPublishCommand,
+	"runner-config": runRunnerConfigCommand,
+	"status":        runStatusCommand,
+	"changes":       runChangesCommand,
+	"schema":        runSchemaCommand,
+	"cache":         runCacheCommand,
+	"prefetch":      runPrefetchCommand,
+	"stats":         runStatsCommand,
+	"badge":         runBadgeCommand,
+	"usage":         runUsageCommand,
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		runSyncCommand(os.Args[1:])
-		return
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "--help":
+			fmt.Print(usage)
+			return
+		}
 	}
 
-	switch os.Args[1] {
-	case "init":
-		runInitCommand(os.Args[2:])
-	case "add":
-		runAddCommand(os.Args[2:])
-	case "sync":
-		runSyncCommand(os.Args[2:])
-	case "update":
-		runUpdateCommand(os.Args[2:])
-	case "edit":
-		runEditCommand(os.Args[2:])
-	case "save":
-		runSaveCommand(os.Args[2:])
-	case "help", "-h", "--help":
-		fmt.Print(usage)
-	default:
-		// If the first argument looks like a flag, treat it as sync command
-		if strings.HasPrefix(os.Args[1], "-") {
-			runSyncCommand(os.Args[1:])
-			return
+	userCfg, err := wptsync.LoadUserConfig(wptsync.DefaultUserConfigPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	for name := range userCfg.Aliases {
+		if _, ok := commands[name]; ok {
+			fmt.Fprintf(os.Stderr, "wptsync: user config: alias %q shadows the %s command\n", name, name)
+			os.Exit(wptsync.ExitConfig)
 		}
-		fmt.Fprintf(os.Stderr, "wptsync: unknown command %q\n\n", os.Args[1])
-		fmt.Fprint(os.Stderr, usage)
-		os.Exit(1)
 	}
+	args = userCfg.Expand(args)
+
+	// With no command, or only flags, wptsync syncs.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		runSyncCommand(args)
+		return
+	}
+	if run, ok := commands[args[0]]; ok {
+		run(args[1:])
+		return
+	}
+	fmt.Fprintf(os.Stderr, "wptsync: unknown command %q\n\n", args[0])
+	fmt.Fprint(os.Stderr, usage)
+	os.Exit(wptsync.ExitConfig)
 }
 
 func runInitCommand(args []string) {
@@ -80,17 +208,79 @@ Usage:
   wptsync init [options]
 
 The init command fetches the latest commit SHA from the web-platform-tests
-repository and creates a configuration file with an empty files list.
+repository (or the -repo and -ref given) and creates a configuration file
+with an empty files list.
 
 Options:`)
 		initFlags.PrintDefaults()
 	}
 	configPath := initFlags.String("config", "wpt.json", "path to the configuration file to create")
-	initFlags.Parse(args)
+	opts := &wptsync.InitOptions{SyncOptions: *newOptions()}
+	initFlags.StringVar(&opts.Repo, "repo", "", "upstream repository in owner/name form (default "+wptsync.DefaultRepo+")")
+	initFlags.StringVar(&opts.Ref, "ref", "", "upstream branch or tag to track (default "+wptsync.DefaultRef+")")
+	initFlags.StringVar(&opts.RawBaseURL, "raw-base-url", "", "base URL raw files are downloaded from, for mirrors")
+	initFlags.StringVar(&opts.APIURL, "api-url", "", "GitHub API URL of the repository, for mirrors")
+	addCommonFlags(initFlags, &opts.SyncOptions)
+	parseFlags(initFlags, args)
 
-	if err := wptsync.Init(context.Background(), *configPath); err != nil {
+	if err := wptsync.Init(context.Background(), *configPath, opts); err != nil {
 		fmt.Fprintf(os.Stderr, "wptsync init: %v\n", err)
-		os.Exit(1)
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+func runImportCommand(args []string) {
+	importFlags := flag.NewFlagSet("import", flag.ExitOnError)
+	importFlags.Usage = func() {
+		fmt.Fprintln(importFlags.Output(), `Create a configuration from files already vendored by hand
+
+Usage:
+  wptsync import [options] <dir>
+
+The import command walks <dir>, which becomes target_dir, and matches every
+file against the web-platform-tests tree at -commit (default: the latest
+commit): by content anywhere in the same top-level folders, or else by path,
+where x.js may come from x.any.js. It writes a configuration entry for each
+match, and records the files identical to upstream in wpt.lock so the next
+sync leaves them alone.
+
+Files found upstream with other content are listed as modified: save their
+changes as patches with 'wptsync save <file>' before syncing, or the sync
+overwrites them. Files that match nothing are listed for you to look into.
+
+Options:`)
+		importFlags.PrintDefaults()
+	}
+	configPath := importFlags.String("config", "wpt.json", "path to the configuration file to create")
+	opts := &wptsync.ImportOptions{SyncOptions: *newOptions()}
+	importFlags.StringVar(&opts.Commit, "commit", "", "WPT commit the vendored files were taken from (default: the latest)")
+	addCommonFlags(importFlags, &opts.SyncOptions)
+	parseFlags(importFlags, args)
+
+	if importFlags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "wptsync import: expected exactly one directory argument")
+		importFlags.Usage()
+		os.Exit(wptsync.ExitConfig)
+	}
+
+	result, err := wptsync.Import(context.Background(), *configPath, importFlags.Arg(0), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync import: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	fmt.Printf("Created %s with commit %s: %d files identical to upstream, %d modified, %d unmatched\n",
+		*configPath, result.Commit, len(result.Matched), len(result.Modified), len(result.Unmatched))
+	if len(result.Modified) > 0 {
+		fmt.Println("\nModified (run 'wptsync save <file>' to keep the changes as a patch before syncing):")
+		for _, f := range result.Modified {
+			fmt.Printf("  %s (from %s)\n", f.Dst, f.Src)
+		}
+	}
+	if len(result.Unmatched) > 0 {
+		fmt.Println("\nUnmatched (not found upstream; not added to the configuration):")
+		for _, p := range result.Unmatched {
+			fmt.Printf("  %s\n", p)
+		}
 	}
 }
 
@@ -103,9 +293,42 @@ Usage:
   wptsync add <path> [options]
 
 The add command fetches files from the web-platform-tests repository and adds
-entries to the configuration. You can specify a single .js file or a folder
-(which will be scanned recursively for .js files). Files ending in .any.js
-are mapped to .js in the destination path.
+entries to the configuration. You can specify a single file or a folder
+(which will be scanned recursively for .js files, or for whatever -include
+selects). Files ending in .any.js are mapped to .js in the destination path.
+
+-include and -exclude may be repeated. Patterns without a "/" match the
+file's base name; patterns with one match its whole WPT path.
+
+-since limits the add to files created upstream after a commit or a date
+(2006-01-02 or RFC 3339), up to the pinned commit, to pick up only the newest
+tests in a folder.
+
+An add that would register more than -max-files entries prints how many fall
+in each subdirectory and asks for confirmation, or fails when stdin is not a
+terminal, unless -yes is given.
+
+-i (or -interactive) instead goes through the new files one subdirectory at
+a time, asking whether to add all of them, none, or to pick them one by one.
+Nothing is written until every subdirectory has been answered; quitting
+leaves the configuration as it was. Flags may follow the path, as in
+'wptsync add streams/ -i'.
+
+-manifest lists files from WPT's MANIFEST.json at the pinned commit, as
+published by wpt.fyi, instead of the repository tree, and adds every file
+-include and -exclude let through (default all). -type, which may be
+repeated, keeps only files of a manifest type such as testharness, reftest,
+or support, and implies -manifest.
+
+-with-deps also adds the helper scripts the new files load with
+"// META: script=" directives, such as /common/subset-tests.js, relative
+imports, and importScripts calls, reading them from upstream, and in turn
+the scripts those load.
+
+-scopes records the globals (window, dedicatedworker, sharedworker, ...)
+every added .any.js test runs in, for runner-config. -split-scopes instead
+adds each .any.js file once per scope (default window and dedicatedworker),
+named foo.window.js, foo.worker.js, and so on.
 
 Arguments:
   <path>    Path in the WPT repository (e.g., url/, resources/testharness.js)
@@ -114,18 +337,58 @@ Options:`)
 		addFlags.PrintDefaults()
 	}
 	configPath := addFlags.String("config", "wpt.json", "path to the configuration file")
-	addFlags.Parse(args)
+	opts := &wptsync.AddOptions{SyncOptions: *newOptions()}
+	addFlags.Var((*listFlag)(&opts.Include), "include", "add only files matching this `pattern` (default *.js)")
+	addFlags.Var((*listFlag)(&opts.Exclude), "exclude", "skip files matching this `pattern`")
+	addFlags.StringVar(&opts.Since, "since", "", "add only files added upstream after this `commit or date`")
+	addFlags.IntVar(&opts.MaxFiles, "max-files", wptsync.DefaultMaxFiles, "ask before adding more than this many files (0 for no limit)")
+	addFlags.BoolVar(&opts.Yes, "yes", false, "add any number of files without asking")
+	addFlags.BoolVar(&opts.Manifest, "manifest", false, "discover files through WPT's MANIFEST.json instead of the repository tree")
+	addFlags.Var((*listFlag)(&opts.Types), "type", "add only files of this manifest `type` (testharness, reftest, support, ...); implies -manifest")
+	addFlags.StringVar(&opts.ManifestURL, "wptfyi-url", wptsync.DefaultWPTFyiURL, "wpt.fyi base URL the manifest is downloaded from")
+	addFlags.BoolVar(&opts.WithDeps, "with-deps", false, "also add the scripts the new files load with META script directives, imports, and importScripts")
+	scopes := addFlags.String("scopes", "", "comma-separated `globals` to record for .any.js tests")
+	addFlags.BoolVar(&opts.SplitScopes, "split-scopes", false, "add each .any.js file once per scope, as foo.window.js, foo.worker.js, ...")
+	interactive := addFlags.Bool("interactive", false, "choose the files to add, one subdirectory at a time")
+	addFlags.BoolVar(interactive, "i", false, "shorthand for -interactive")
+	addCommonFlags(addFlags, &opts.SyncOptions)
+	parseFlags(addFlags, args)
+	var wptPath string
+	if addFlags.NArg() > 0 {
+		// Flags may follow the path, as in `wptsync add streams/ -i`.
+		wptPath = addFlags.Arg(0)
+		addFlags.Parse(addFlags.Args()[1:])
+		if addFlags.NArg() > 0 {
+			fmt.Fprintf(os.Stderr, "wptsync add: unexpected argument %q\n", addFlags.Arg(0))
+			os.Exit(wptsync.ExitConfig)
+		}
+	}
+
+	if *scopes != "" {
+		opts.Scopes = strings.Split(*scopes, ",")
+	}
+
+	if opts.MaxFiles == 0 {
+		opts.MaxFiles = -1
+	}
+	opts.Confirm = confirmOnTerminal
+	if *interactive {
+		if !isTerminal(os.Stdin) {
+			fmt.Fprintln(os.Stderr, "wptsync add: -interactive needs a terminal to ask on")
+			os.Exit(wptsync.ExitConfig)
+		}
+		opts.Select = selectOnTerminal
+	}
 
-	if addFlags.NArg() < 1 {
+	if wptPath == "" {
 		fmt.Fprintln(os.Stderr, "wptsync add: missing required path argument")
 		addFlags.Usage()
-		os.Exit(1)
+		os.Exit(wptsync.ExitConfig)
 	}
 
-	wptPath := addFlags.Arg(0)
-	if err := wptsync.Add(context.Background(), *configPath, wptPath); err != nil {
+	if err := wptsync.Add(context.Background(), *configPath, wptPath, opts); err != nil {
 		fmt.Fprintf(os.Stderr, "wptsync add: %v\n", err)
-		os.Exit(1)
+		os.Exit(wptsync.ExitCode(err))
 	}
 }
 
@@ -142,16 +405,190 @@ the configuration, and re-syncs every enabled file. Patches that no longer
 apply are reported at the end instead of aborting the run; fix those files
 and run 'wptsync save <path>' to regenerate their patches.
 
+With -merge, a patch that no longer applies is three-way merged instead
+(base: the file at the previous commit), which needs git. A clean merge
+regenerates the patch; otherwise the file is left with conflict markers to
+resolve before running 'wptsync save <path>'.
+
+With -interactive (which implies -merge), each conflicted file is then opened
+in turn in -merge-tool, or $VISUAL / $EDITOR when it is unset. The tool is a
+shell command run with $BASE, $LOCAL, $REMOTE, and $MERGED set, as for git
+mergetool; once it exits successfully and no conflict markers remain, the
+file's patch is regenerated.
+
+With -tag, the command pins the commit of a WPT release, the
+harness-compatible snapshots tagged merge_pr_<number>: a tag by name, or
+"latest" for the latest release. "track_releases": true in the configuration
+makes plain update (and -check, status, and changes) follow the latest
+release instead of the head of master.
+
+With -check, nothing is modified: the command only reports whether a newer
+commit exists and exits with status 1 if the configuration is outdated.
+
+With -format json, a report of every file (downloaded, skipped, patched, or
+failed, with sizes and durations) is printed to stdout and progress goes to
+stderr. The exit code is then 7 when there was nothing to do and 8 when some
+files were synced before others failed.
+
+With -github-output, as a GitHub Actions step, the outputs changed ("true"
+when the pinned commit moved or a file's content changed), new_commit,
+previous_commit, and files_changed are appended to $GITHUB_OUTPUT, for later
+steps to open a pull request or run tests on, and nothing prompts.
+
+When stdout is a terminal, a progress line (files done, bytes written, and
+an estimate of the time left) stays below the messages while files are
+fetched. The run ends with a summary: files downloaded, skipped, patched, and
+failed, with the bytes written and the time taken. -q prints nothing but
+errors; -v also lists every file with its outcome, size, and duration.
+
+With -select-by-results, the command queries wpt.fyi for recent aligned runs
+and pins the newest commit where the tests in the configured directories
+pass at least -min-pass-rate in every product listed in -products.
+
 Options:`)
 		updateFlags.PrintDefaults()
 	}
 	configPath := updateFlags.String("config", "wpt.json", "path to the configuration file")
-	commit := updateFlags.String("commit", "", "update to this commit SHA instead of the latest")
-	updateFlags.Parse(args)
+	opts := &wptsync.UpdateOptions{SyncOptions: *newOptions()}
+	updateFlags.StringVar(&opts.Commit, "commit", "", "update to this commit SHA instead of the latest")
+	updateFlags.StringVar(&opts.Tag, "tag", "", "update to the commit of this release `tag` (such as merge_pr_45678), or \"latest\" for the latest release")
+	updateFlags.BoolVar(&opts.NoSync, "no-sync", false, "only rewrite the pinned commit; do not re-sync files")
+	updateFlags.BoolVar(&opts.KeepGoing, "keep-going", false, "re-sync every file that can be, then report the ones that failed")
+	updateFlags.BoolVar(&opts.VerifyBlobs, "verify-blobs", false, "check every file fetched against its git blob SHA in the upstream tree before writing it")
+	updateFlags.BoolVar(&opts.RequireAllowlist, "require-allowlist", false, "refuse harness files whose SHA-256 is not listed in harness_checksums, for locked-down builds")
+	updateFlags.BoolVar(&opts.Strict, "strict", false, "fail when a file's validate_cmd fails or the scan policy flags it, leaving it out of the lock file")
+	updateFlags.BoolVar(&opts.Merge, "merge", false, "three-way merge files whose patch no longer applies, writing conflict markers when needed")
+	updateFlags.BoolVar(&opts.Interactive, "interactive", false, "open each merge conflict in -merge-tool and regenerate its patch once resolved (implies -merge)")
+	updateFlags.StringVar(&opts.MergeTool, "merge-tool", "", "shell command resolving a conflict, using $BASE, $LOCAL, $REMOTE, and $MERGED (default: $VISUAL or $EDITOR on $MERGED)")
+	check := updateFlags.Bool("check", false, "only report whether a newer commit exists (exit 1 if outdated)")
+	updateFlags.IntVar(&opts.Jobs, "jobs", wptsync.DefaultJobs, "number of files to download concurrently")
+	updateFlags.StringVar(&opts.Mode, "mode", wptsync.ModeRaw, "how to fetch files: raw (one request per file), archive (one tarball for the commit), or batch (small files in batched GraphQL queries)")
+	updateFlags.BoolVar(&opts.ChangedOnly, "changed-only", false, "only re-sync files that changed upstream since the previous commit")
+	updateFlags.StringVar(&opts.Source, "source", "", "read files from `git:<path>`, a local WPT clone, or bundle:<path>, a tarball, instead of the network (overrides \"source\" in the config)")
+	selectByResults := updateFlags.Bool("select-by-results", false, "pick the newest commit meeting the wpt.fyi result criteria")
+	criteria := &wptsync.ResultCriteria{}
+	products := updateFlags.String("products", "chrome,firefox", "comma-separated wpt.fyi products for -select-by-results")
+	updateFlags.Float64Var(&criteria.MinPassRate, "min-pass-rate", 1, "fraction of subtests (0-1) that must pass for -select-by-results")
+	updateFlags.StringVar(&criteria.URL, "wptfyi-url", wptsync.DefaultWPTFyiURL, "wpt.fyi base URL for -select-by-results")
+	addStatsFlag(updateFlags, &opts.SyncOptions)
+	asJSON := addFormatFlag(updateFlags, &opts.SyncOptions)
+	out := addOutputFlags(updateFlags)
+	githubOutput := addGitHubOutputFlag(updateFlags)
+	addCommonFlags(updateFlags, &opts.SyncOptions)
+	parseFlags(updateFlags, args)
+	if err := out.start(&opts.SyncOptions, *asJSON); err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync update: %v\n", err)
+		os.Exit(wptsync.ExitConfig)
+	}
+	outputPath, err := githubOutput.path()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync update: %v\n", err)
+		os.Exit(wptsync.ExitConfig)
+	}
+	if outputPath != "" && (*check || opts.Interactive) {
+		fmt.Fprintln(os.Stderr, "wptsync update: -github-output cannot be combined with -check or -interactive")
+		os.Exit(wptsync.ExitConfig)
+	}
+
+	if *selectByResults {
+		criteria.Products = strings.Split(*products, ",")
+		opts.SelectByResults = criteria
+	}
+	if *asJSON && opts.Interactive {
+		fmt.Fprintln(os.Stderr, "wptsync update: -interactive cannot be combined with -format json")
+		os.Exit(wptsync.ExitConfig)
+	}
+
+	if *check {
+		latest, outdated, err := wptsync.CheckUpdate(context.Background(), *configPath, &opts.SyncOptions)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wptsync update: %v\n", err)
+			if *asJSON {
+				os.Exit(writeJSON("update", nil, err, wptsync.ExitCode(err)))
+			}
+			os.Exit(wptsync.ExitCode(err))
+		}
+		code := 0
+		if outdated {
+			code = 1
+		}
+		if *asJSON {
+			os.Exit(writeJSON("update", map[string]any{"latest": latest, "outdated": outdated}, nil, code))
+		}
+		if outdated {
+			fmt.Printf("Newer WPT commit available: %s\n", latest)
+			os.Exit(code)
+		}
+		fmt.Printf("Pinned commit %s is the latest.\n", latest)
+		return
+	}
 
-	if err := wptsync.Update(context.Background(), *configPath, *commit); err != nil {
+	opts.Report = &wptsync.SyncReport{}
+	err = wptsync.Update(context.Background(), *configPath, opts)
+	if !*asJSON {
+		out.finish(opts.Report)
+	}
+	writeGitHubOutput("update", outputPath, opts.Report)
+	if err != nil {
+		writeFailures(err)
 		fmt.Fprintf(os.Stderr, "wptsync update: %v\n", err)
-		os.Exit(1)
+	}
+	if *asJSON {
+		os.Exit(writeJSON("update", opts.Report, err, opts.Report.ExitCode(err)))
+	}
+	if err != nil {
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+func runWatchCommand(args []string) {
+	watchFlags := flag.NewFlagSet("watch", flag.ExitOnError)
+	watchFlags.Usage = func() {
+		fmt.Fprintln(watchFlags.Output(), `Keep the checkout in sync with upstream on a schedule
+
+Usage:
+  wptsync watch [options]
+
+The watch command checks for a new upstream commit every -interval and, when
+there is one, runs 'wptsync update' to it: the pin moves and every enabled
+file is re-synced. It runs until interrupted. With -on-upstream-change, it
+only updates when the new commit changes a configured file; commits that
+touch other parts of WPT are skipped.
+
+With -run, the shell command is run in the configuration's directory after
+each update, for example to commit the result and open a pull request. It
+sees WPTSYNC_COMMIT and WPTSYNC_PREVIOUS_COMMIT, and WPTSYNC_CHANGED, the
+configured srcs that changed, one per line.
+
+A failed check or update is reported and tried again at the next check. With
+-once, the command checks a single time and exits with its status, for
+running from cron or a CI schedule instead.
+
+Options:`)
+		watchFlags.PrintDefaults()
+	}
+	configPath := watchFlags.String("config", "wpt.json", "path to the configuration file")
+	opts := &wptsync.WatchOptions{UpdateOptions: wptsync.UpdateOptions{SyncOptions: *newOptions()}}
+	watchFlags.DurationVar(&opts.Interval, "interval", wptsync.DefaultWatchInterval, "time between two checks")
+	watchFlags.BoolVar(&opts.OnUpstreamChange, "on-upstream-change", false, "only update when a configured file changed upstream")
+	watchFlags.StringVar(&opts.Run, "run", "", "shell `command` to run after each update, such as one opening a pull request")
+	watchFlags.BoolVar(&opts.Once, "once", false, "check once and exit instead of running until interrupted")
+	watchFlags.BoolVar(&opts.NoSync, "no-sync", false, "only rewrite the pinned commit; do not re-sync files")
+	watchFlags.BoolVar(&opts.KeepGoing, "keep-going", false, "re-sync every file that can be, then report the ones that failed")
+	watchFlags.BoolVar(&opts.Merge, "merge", false, "three-way merge files whose patch no longer applies, writing conflict markers when needed")
+	watchFlags.BoolVar(&opts.RequireAllowlist, "require-allowlist", false, "refuse harness files whose SHA-256 is not listed in harness_checksums, for locked-down builds")
+	watchFlags.IntVar(&opts.Jobs, "jobs", wptsync.DefaultJobs, "number of files to download concurrently")
+	watchFlags.StringVar(&opts.Mode, "mode", wptsync.ModeRaw, "how to fetch files: raw (one request per file), archive (one tarball for the commit), or batch (small files in batched GraphQL queries)")
+	addCommonFlags(watchFlags, &opts.SyncOptions)
+	parseFlags(watchFlags, args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := wptsync.Watch(ctx, *configPath, opts); err != nil {
+		writeFailures(err)
+		fmt.Fprintf(os.Stderr, "wptsync watch: %v\n", err)
+		stop()
+		os.Exit(wptsync.ExitCode(err))
 	}
 }
 
@@ -174,17 +611,19 @@ Options:`)
 		editFlags.PrintDefaults()
 	}
 	configPath := editFlags.String("config", "wpt.json", "path to the configuration file")
-	editFlags.Parse(args)
+	opts := &wptsync.EditOptions{SyncOptions: *newOptions()}
+	addCommonFlags(editFlags, &opts.SyncOptions)
+	parseFlags(editFlags, args)
 
 	if editFlags.NArg() < 1 {
 		fmt.Fprintln(os.Stderr, "wptsync edit: missing required path argument")
 		editFlags.Usage()
-		os.Exit(1)
+		os.Exit(wptsync.ExitConfig)
 	}
 
-	if err := wptsync.Edit(context.Background(), *configPath, editFlags.Arg(0)); err != nil {
+	if err := wptsync.Edit(context.Background(), *configPath, editFlags.Arg(0), opts); err != nil {
 		fmt.Fprintf(os.Stderr, "wptsync edit: %v\n", err)
-		os.Exit(1)
+		os.Exit(wptsync.ExitCode(err))
 	}
 }
 
@@ -208,51 +647,1729 @@ Options:`)
 		saveFlags.PrintDefaults()
 	}
 	configPath := saveFlags.String("config", "wpt.json", "path to the configuration file")
-	saveFlags.Parse(args)
+	opts := &wptsync.SaveOptions{SyncOptions: *newOptions()}
+	addCommonFlags(saveFlags, &opts.SyncOptions)
+	parseFlags(saveFlags, args)
 
 	if saveFlags.NArg() < 1 {
 		fmt.Fprintln(os.Stderr, "wptsync save: missing required path argument")
 		saveFlags.Usage()
-		os.Exit(1)
+		os.Exit(wptsync.ExitConfig)
 	}
 
-	if err := wptsync.Save(context.Background(), *configPath, saveFlags.Arg(0)); err != nil {
+	if err := wptsync.Save(context.Background(), *configPath, saveFlags.Arg(0), opts); err != nil {
 		fmt.Fprintf(os.Stderr, "wptsync save: %v\n", err)
-		os.Exit(1)
+		os.Exit(wptsync.ExitCode(err))
 	}
 }
 
-func runSyncCommand(args []string) {
-	syncFlags := flag.NewFlagSet("sync", flag.ExitOnError)
-	syncFlags.Usage = func() {
-		fmt.Fprintln(syncFlags.Output(), `Download WPT files according to the configuration
+func runDiffCommand(args []string) {
+	diffFlags := flag.NewFlagSet("diff", flag.ExitOnError)
+	diffFlags.Usage = func() {
+		fmt.Fprintln(diffFlags.Output(), `Diff a file's on-disk edits against pristine upstream
 
 Usage:
-  wptsync sync [options]
-  wptsync [options]
+  wptsync diff [options] <path>
 
-The sync command downloads files from the web-platform-tests repository
-at the commit specified in the configuration file, and optionally applies
-patches to customize them.
+The diff command downloads the pristine file at the pinned commit and diffs
+it against the file on disk. The unified diff is printed, or with -o written
+to the given patch file, which is then wired into the file's configuration
+entry (replacing any previous patch path).
+
+Arguments:
+  <path>    The file's dst (or src) path as listed in the configuration
 
 Options:`)
-		syncFlags.PrintDefaults()
+		diffFlags.PrintDefaults()
 	}
-	configPath := syncFlags.String("config", "wpt.json", "path to the WPT sync configuration file")
-	skipPatching := syncFlags.Bool("skip-patches", false, "download files but do not apply any configured patches")
-	dryRun := syncFlags.Bool("dry-run", false, "print the actions that would be taken without writing files")
-	force := syncFlags.Bool("force", false, "bypass the freshness stamp and force a full sync")
-	syncFlags.Parse(args)
+	configPath := diffFlags.String("config", "wpt.json", "path to the configuration file")
+	output := diffFlags.String("o", "-", "write the patch to this `file` (relative to the config) and register it; - prints it")
+	opts := newOptions()
+	addCommonFlags(diffFlags, opts)
+	parseFlags(diffFlags, args)
 
-	opts := &wptsync.SyncOptions{
-		SkipPatches: *skipPatching,
-		DryRun:      *dryRun,
-		Force:       *force,
-		Logf:        func(format string, args ...any) { fmt.Printf(format, args...) },
+	if diffFlags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "wptsync diff: missing required path argument")
+		diffFlags.Usage()
+		os.Exit(wptsync.ExitConfig)
 	}
 
-	if err := wptsync.Sync(context.Background(), *configPath, opts); err != nil {
-		fmt.Fprintf(os.Stderr, "wptsync sync: %v\n", err)
-		os.Exit(1)
+	if err := wptsync.Diff(context.Background(), *configPath, diffFlags.Arg(0), *output, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync diff: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+func runCheckPatchesCommand(args []string) {
+	checkFlags := flag.NewFlagSet("check-patches", flag.ExitOnError)
+	checkFlags.Usage = func() {
+		fmt.Fprintln(checkFlags.Output(), `Check that every patch applies cleanly
+
+Usage:
+  wptsync check-patches [options]
+
+The check-patches command downloads each patched file into a temporary
+directory, tries its patch there, and prints a pass/fail line per patch with
+the hunks that conflict. Files under target_dir are not touched. It checks
+the pinned commit by default; use -latest (or -commit) before an update to
+see which patches it would break. It exits with status 4 if any patch fails.
+
+Options:`)
+		checkFlags.PrintDefaults()
+	}
+	configPath := checkFlags.String("config", "wpt.json", "path to the configuration file")
+	opts := &wptsync.CheckPatchesOptions{SyncOptions: *newOptions()}
+	checkFlags.StringVar(&opts.Commit, "commit", "", "check against this WPT commit instead of the pinned one")
+	checkFlags.BoolVar(&opts.Latest, "latest", false, "check against the latest upstream commit")
+	checkFlags.IntVar(&opts.Jobs, "jobs", wptsync.DefaultJobs, "number of files to check concurrently")
+	addCommonFlags(checkFlags, &opts.SyncOptions)
+	parseFlags(checkFlags, args)
+
+	commit, checks, err := wptsync.CheckPatches(context.Background(), *configPath, opts)
+	if checks == nil && err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync check-patches: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	fmt.Printf("Checked %d patch(es) at %s\n", len(checks), commit)
+	for _, c := range checks {
+		if c.Err == nil && len(c.Fuzz) > 0 {
+			hunks := make([]string, len(c.Fuzz))
+			for i, h := range c.Fuzz {
+				hunks[i] = h.String()
+			}
+			fmt.Printf("  FUZZ  %s  (%s)  %s\n", c.Dst, c.Patch, strings.Join(hunks, "; "))
+			continue
+		}
+		if c.Err == nil {
+			fmt.Printf("  PASS  %s  (%s)\n", c.Dst, c.Patch)
+			continue
+		}
+		detail := c.Err.Error()
+		if len(c.Conflicts) > 0 {
+			hunks := make([]string, len(c.Conflicts))
+			for i, h := range c.Conflicts {
+				hunks[i] = fmt.Sprintf("#%d at line %d", h.Hunk, h.Line)
+			}
+			detail = "conflicting hunks: " + strings.Join(hunks, ", ")
+		}
+		fmt.Printf("  FAIL  %s  (%s)  %s\n", c.Dst, c.Patch, detail)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync check-patches: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+func runNotarizeCommand(args []string) {
+	notarizeFlags := flag.NewFlagSet("notarize", flag.ExitOnError)
+	notarizeFlags.Usage = func() {
+		fmt.Fprintln(notarizeFlags.Output(), `Record the synced tree's provenance in git history
+
+Usage:
+  wptsync notarize [options]
+
+The notarize command attaches the provenance of the synced tree (upstream
+repository, pinned commit, SHA-256 of wpt.lock, and wptsync version) to a
+commit as a git note under `+wptsync.NotesRef+`. Run it after committing the
+vendored files. With -trailer it prints the same information as git trailers
+instead, for the commit message of the vendoring commit:
+
+  git commit -m "Update WPT" -m "$(wptsync notarize -trailer)"
+
+Options:`)
+		notarizeFlags.PrintDefaults()
+	}
+	configPath := notarizeFlags.String("config", "wpt.json", "path to the configuration file")
+	rev := notarizeFlags.String("rev", "HEAD", "commit to attach the note to")
+	trailer := notarizeFlags.Bool("trailer", false, "print git trailers instead of writing a note")
+	parseFlags(notarizeFlags, args)
+
+	if *trailer {
+		p, err := wptsync.LoadProvenance(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wptsync notarize: %v\n", err)
+			os.Exit(wptsync.ExitCode(err))
+		}
+		fmt.Print(p.Trailers())
+		return
+	}
+	if err := wptsync.Notarize(context.Background(), *configPath, *rev); err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync notarize: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	fmt.Printf("Added provenance note to %s (git notes --ref=%s show %s)\n", *rev, wptsync.NotesRef, *rev)
+}
+
+func runPublishCommand(args []string) {
+	publishFlags := flag.NewFlagSet("publish", flag.ExitOnError)
+	publishFlags.Usage = func() {
+		fmt.Fprintln(publishFlags.Output(), `Push the synced tree to a separate repository
+
+Usage:
+  wptsync publish [options] <remote>
+
+The publish command commits the synced files, laid out as under target_dir,
+plus a `+wptsync.ProvenanceFile+` manifest, on top of -branch of <remote> (a
+URL or a local path) and pushes it, like git subtree split. The commit
+message carries the provenance as git trailers. Files must match wpt.lock;
+nothing is pushed when the tree is unchanged. Your own index and working
+tree are not touched.
+
+Options:`)
+		publishFlags.PrintDefaults()
+	}
+	configPath := publishFlags.String("config", "wpt.json", "path to the configuration file")
+	opts := &wptsync.PublishOptions{}
+	publishFlags.StringVar(&opts.Branch, "branch", wptsync.DefaultPublishBranch, "branch of the remote to push to")
+	parseFlags(publishFlags, args)
+
+	if publishFlags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "wptsync publish: missing required remote argument")
+		publishFlags.Usage()
+		os.Exit(wptsync.ExitConfig)
+	}
+	opts.Remote = publishFlags.Arg(0)
+
+	commit, pushed, err := wptsync.Publish(context.Background(), *configPath, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync publish: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	if !pushed {
+		fmt.Printf("%s %s is already up to date at %s\n", opts.Remote, opts.Branch, commit)
+		return
+	}
+	fmt.Printf("Pushed %s to %s %s\n", commit, opts.Remote, opts.Branch)
+}
+
+func runVerifyCommand(args []string) {
+	verifyFlags := flag.NewFlagSet("verify", flag.ExitOnError)
+	verifyFlags.Usage = func() {
+		fmt.Fprintln(verifyFlags.Output(), `Check that synced files still match the lock file
+
+Usage:
+  wptsync verify [options]
+
+The verify command hashes every enabled file under target_dir and compares
+it against the lock file written by the last sync (wpt.lock next to
+wpt.json). It exits non-zero if any file is missing, modified, or was never
+locked. No network access is needed. With -group, the files of that group
+are checked against its own lock file (wpt.<group>.lock).
+
+With -quick, a file whose size and modification time are those recorded in
+.wptsync-state.json when it was synced is not hashed again, which makes
+checking a large tree near-instant; the others are hashed as usual.
+
+With -verify-blobs, the files are also checked against the git blob SHAs in
+the upstream tree at the pinned commit, which costs one GitHub API request
+per directory but downloads no content: the blob SHA the lock file recorded
+for each, and the content of those synced without a patch, transform, or
+license header. When nothing else is wrong, a mismatch exits with 5.
+
+Options:`)
+		verifyFlags.PrintDefaults()
+	}
+	configPath := verifyFlags.String("config", "wpt.json", "path to the configuration file")
+	opts := newOptions()
+	verifyFlags.StringVar(&opts.Group, "group", "", "operate on the named `group` of the configuration (see \"groups\") instead of its top-level files")
+	verifyFlags.BoolVar(&opts.Quick, "quick", false, "only hash the files whose size or modification time changed since they were synced")
+	verifyFlags.BoolVar(&opts.VerifyBlobs, "verify-blobs", false, "also check the files against the git blob SHAs in the upstream tree (uses the GitHub API)")
+	verifyFlags.StringVar(&opts.Token, "token", "", "GitHub token for the API requests of -verify-blobs (default: $GITHUB_TOKEN)")
+	parseFlags(verifyFlags, args)
+
+	if err := wptsync.Verify(context.Background(), *configPath, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync verify: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+func runScanCommand(args []string) {
+	scanFlags := flag.NewFlagSet("scan", flag.ExitOnError)
+	scanFlags.Usage = func() {
+		fmt.Fprintln(scanFlags.Output(), `Check synced files for denied licenses, credentials, and URLs
+
+Usage:
+  wptsync scan [options]
+
+The scan command checks every enabled file under target_dir against the
+"scan" policy of the configuration: license headers and SPDX tags of denied
+licenses, credentials such as private keys and API tokens, URLs to denied
+hosts, and the policy's own patterns. It lists every finding, with credentials
+cut short, and exits with 9 if there are any, so it can run before a commit.
+sync and update scan the files they write in the same way. No network access
+is needed.
+
+Options:`)
+		scanFlags.PrintDefaults()
+	}
+	configPath := scanFlags.String("config", "wpt.json", "path to the configuration file")
+	opts := newOptions()
+	scanFlags.StringVar(&opts.Group, "group", "", "operate on the named `group` of the configuration (see \"groups\") instead of its top-level files")
+	parseFlags(scanFlags, args)
+
+	if err := wptsync.Scan(context.Background(), *configPath, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync scan: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+func runValidateCommand(args []string) {
+	validateFlags := flag.NewFlagSet("validate", flag.ExitOnError)
+	validateFlags.Usage = func() {
+		fmt.Fprintln(validateFlags.Output(), `Check the configuration file for typos and mistakes
+
+Usage:
+  wptsync validate [options]
+
+The validate command checks wpt.json without syncing anything and lists every
+problem it finds, with the line and key it is on: unknown keys (typos such as
+"taget_dir", which every command rejects), duplicate src or dst entries, dst
+paths escaping target_dir, patch files that don't exist, and commits that
+are not full SHAs. It exits with 2 when there are problems. No network access
+is needed.
+
+Options:`)
+		validateFlags.PrintDefaults()
+	}
+	configPath := validateFlags.String("config", "wpt.json", "path to the configuration file")
+	parseFlags(validateFlags, args)
+
+	problems, err := wptsync.ValidateConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync validate: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", *configPath, p)
+	}
+	if len(problems) > 0 {
+		os.Exit(wptsync.ExitConfig)
+	}
+	fmt.Printf("%s: no problems found\n", *configPath)
+}
+
+func runMergeConfigCommand(args []string) {
+	mergeFlags := flag.NewFlagSet("merge-config", flag.ExitOnError)
+	mergeFlags.Usage = func() {
+		fmt.Fprintln(mergeFlags.Output(), `Merge two versions of wpt.json entry by entry, as a git merge driver
+
+Usage:
+  wptsync merge-config [options] <base> <ours> <theirs>
+
+The merge-config command merges the changes from <base> to <theirs> into
+<ours>, and writes the result to <ours>. Values are merged rather than lines,
+and files entries by src: two branches adding different tests no longer
+conflict, and an entry changed on one branch takes the change. When both
+branches changed a value differently, ours is kept, the conflicts are listed,
+and the command exits with 1.
+
+To have git use it for wpt.json, run:
+  git config merge.wptsync.name "wptsync configuration merge"
+  git config merge.wptsync.driver "wptsync merge-config -name %P %O %A %B"
+and add this line to .gitattributes:
+  wpt.json merge=wptsync
+
+Options:`)
+		mergeFlags.PrintDefaults()
+	}
+	name := mergeFlags.String("name", "", "path of the file in the repository, whose extension says whether it is JSON, YAML, or TOML (default: <ours>)")
+	parseFlags(mergeFlags, args)
+
+	if mergeFlags.NArg() != 3 {
+		fmt.Fprintln(os.Stderr, "wptsync merge-config: expected <base> <ours> <theirs>")
+		mergeFlags.Usage()
+		os.Exit(wptsync.ExitConfig)
+	}
+
+	conflicts, err := wptsync.MergeConfig(mergeFlags.Arg(0), mergeFlags.Arg(1), mergeFlags.Arg(2), *name)
+	for _, c := range conflicts {
+		fmt.Fprintf(os.Stderr, "conflict: %s\n", c)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync merge-config: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+func runStatusCommand(args []string) {
+	statusFlags := flag.NewFlagSet("status", flag.ExitOnError)
+	statusFlags.Usage = func() {
+		fmt.Fprintln(statusFlags.Output(), `Show local and upstream changes for every file
+
+Usage:
+  wptsync status [options]
+
+The status command compares every enabled file with the lock file written by
+the last sync (missing, modified, stale, or never synced) and asks GitHub
+which sources changed between the pinned commit and the head of WPT master.
+Files that are clean and unchanged upstream are only counted.
+
+With -offline, nothing is asked of GitHub, and only the local state of each
+file is reported. -budget caps the GitHub API requests made, for rate-limited
+CI; once it is spent, files that could not be compared are reported as
+"upstream unknown" instead of the command failing. With -quick, only the
+files whose size or modification time changed since they were synced are
+hashed, as for 'wptsync verify -quick'.
+
+With -format json, the report is printed to stdout as JSON (the summary goes
+to stderr) and the command exits with 7 when every file is up to date and
+the pinned commit is the latest.
+
+Options:`)
+		statusFlags.PrintDefaults()
+	}
+	configPath := statusFlags.String("config", "wpt.json", "path to the configuration file")
+	opts := newOptions()
+	statusFlags.StringVar(&opts.Group, "group", "", "operate on the named `group` of the configuration (see \"groups\") instead of its top-level files")
+	statusFlags.BoolVar(&opts.Quick, "quick", false, "only hash the files whose size or modification time changed since they were synced")
+	addBudgetFlags(statusFlags, opts)
+	asJSON := addFormatFlag(statusFlags, opts)
+	addCommonFlags(statusFlags, opts)
+	parseFlags(statusFlags, args)
+
+	report, err := wptsync.Status(context.Background(), *configPath, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync status: %v\n", err)
+		if *asJSON {
+			os.Exit(writeJSON("status", nil, err, wptsync.ExitCode(err)))
+		}
+		os.Exit(wptsync.ExitCode(err))
+	}
+
+	out := os.Stdout
+	if *asJSON {
+		out = os.Stderr
+	}
+	switch report.Latest {
+	case "":
+		fmt.Fprintf(out, "Pinned commit %s; latest not checked.\n", report.Commit)
+	case report.Commit:
+		fmt.Fprintf(out, "Pinned commit %s is the latest.\n", report.Commit)
+	default:
+		fmt.Fprintf(out, "Pinned commit %s; latest is %s.\n", report.Commit, report.Latest)
+	}
+	clean := 0
+	for _, f := range report.Files {
+		if f.UpToDate() {
+			clean++
+			// Pinned and frozen files are listed anyway, as a reminder.
+			if f.Pinned == "" && !f.Frozen {
+				continue
+			}
+		}
+		if report.Limited != "" && f.Local == wptsync.LocalClean && f.Upstream == wptsync.UpstreamUnknown && f.Pinned == "" {
+			// Listing every file as unknown would bury the local changes.
+			continue
+		}
+		var states []string
+		if f.Pinned != "" {
+			states = append(states, "pinned at "+f.Pinned)
+		}
+		if f.Frozen {
+			states = append(states, "frozen")
+		}
+		if f.Local != wptsync.LocalClean {
+			states = append(states, string(f.Local))
+		}
+		switch f.Upstream {
+		case wptsync.UpstreamChanged:
+			if f.LastCommit != "" {
+				states = append(states, "changed upstream in "+f.LastCommit[:min(len(f.LastCommit), 10)])
+				break
+			}
+			states = append(states, "changed upstream")
+		case wptsync.UpstreamUnknown:
+			states = append(states, "upstream unknown")
+		}
+		fmt.Fprintf(out, "  %-28s %s\n", strings.Join(states, ", "), f.Dst)
+	}
+	fmt.Fprintf(out, "%d of %d files up to date.\n", clean, len(report.Files))
+	if report.Limited != "" {
+		fmt.Fprintf(out, "Upstream changes were not fully checked (%s).\n", report.Limited)
+	}
+
+	if *asJSON {
+		code := 0
+		if clean == len(report.Files) && report.Latest == report.Commit {
+			code = wptsync.ExitNothingToDo
+		}
+		os.Exit(writeJSON("status", report, nil, code))
+	}
+}
+
+func runChangesCommand(args []string) {
+	changesFlags := flag.NewFlagSet("changes", flag.ExitOnError)
+	changesFlags.Usage = func() {
+		fmt.Fprintln(changesFlags.Output(), `List configured files changed upstream between two commits
+
+Usage:
+  wptsync changes [options]
+
+The changes command asks GitHub which files changed between -from (default:
+the pinned commit) and -to (default: the head of WPT master), and lists
+those the configuration syncs, added, modified, removed, or renamed, with
+the commits that touched them. Run it before update to see whether a bump
+is worth the churn. Disabled files and files pinned to their own commit are
+left out, as update does not move them. Listing the commits takes a GitHub
+API request per file; with -budget, files beyond it are listed without them.
+
+With -format json, the report is printed to stdout as JSON and the command
+exits with 7 when no configured file changed.
+
+Options:`)
+		changesFlags.PrintDefaults()
+	}
+	configPath := changesFlags.String("config", "wpt.json", "path to the configuration file")
+	opts := &wptsync.ChangesOptions{SyncOptions: *newOptions()}
+	changesFlags.StringVar(&opts.From, "from", "", "commit to compare from (default: the pinned commit)")
+	changesFlags.StringVar(&opts.To, "to", "", "commit to compare to (default: the latest commit)")
+	addBudgetFlags(changesFlags, &opts.SyncOptions)
+	asJSON := addFormatFlag(changesFlags, &opts.SyncOptions)
+	addCommonFlags(changesFlags, &opts.SyncOptions)
+	parseFlags(changesFlags, args)
+
+	report, err := wptsync.Changes(context.Background(), *configPath, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync changes: %v\n", err)
+		if *asJSON {
+			os.Exit(writeJSON("changes", nil, err, wptsync.ExitCode(err)))
+		}
+		os.Exit(wptsync.ExitCode(err))
+	}
+	if *asJSON {
+		code := 0
+		if len(report.Files) == 0 {
+			code = wptsync.ExitNothingToDo
+		}
+		os.Exit(writeJSON("changes", report, nil, code))
+	}
+
+	fmt.Printf("Changes between %s and %s:\n", report.From, report.To)
+	for _, f := range report.Files {
+		name := f.Src
+		if f.PreviousSrc != "" {
+			name = f.PreviousSrc + " -> " + f.Src
+		}
+		fmt.Printf("  %-9s %s\n", f.Status, name)
+		for _, c := range f.Commits {
+			fmt.Printf("            %s %s\n              %s\n", c.SHA[:min(len(c.SHA), 10)], c.Title, c.URL)
+		}
+	}
+	if report.Limited != "" {
+		fmt.Printf("Commits were not listed for every file (%s).\n", report.Limited)
+	}
+	if !report.Complete {
+		fmt.Printf("GitHub listed only the first %d changed files; configured files beyond them are missing.\n", report.Upstream)
+	}
+	switch {
+	case report.Upstream == 0:
+		fmt.Println("No files changed upstream.")
+	case len(report.Files) == 0:
+		fmt.Printf("None of the %d files changed upstream are configured; a bump would only move the pinned commit.\n", report.Upstream)
+	default:
+		fmt.Printf("%d of the %d files changed upstream are configured.\n", len(report.Files), report.Upstream)
+	}
+}
+
+func runSchemaCommand(args []string) {
+	schemaFlags := flag.NewFlagSet("schema", flag.ExitOnError)
+	schemaFlags.Usage = func() {
+		fmt.Fprintln(schemaFlags.Output(), `Print the JSON Schema for the configuration file
+
+Usage:
+  wptsync schema [options]
+
+The schema command prints a JSON Schema describing the wpt.json format. It
+is generated from the tool's own config types, so it always matches the
+running version. Reference it from wpt.json with a "$schema" key (or your
+editor's settings) to get completion and validation.
+
+Options:`)
+		schemaFlags.PrintDefaults()
+	}
+	output := schemaFlags.String("o", "", "write the schema to this `file` instead of stdout")
+	parseFlags(schemaFlags, args)
+
+	data, err := wptsync.Schema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync schema: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	data = append(data, '\n')
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync schema: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+func runRunnerConfigCommand(args []string) {
+	rcFlags := flag.NewFlagSet("runner-config", flag.ExitOnError)
+	rcFlags.Usage = func() {
+		fmt.Fprintln(rcFlags.Output(), `Print test metadata for harness integrations
+
+Usage:
+  wptsync runner-config [options]
+
+The runner-config command reads the "// META:" directives (title, timeout,
+global, variant, script) of every synced test and prints them as JSON, one
+entry per test, so harness integrations don't need their own parser. Tests
+are .any.js, .window.js, and .worker.js files and any other .js file with
+META directives. It reads the synced files, so run it after a sync.
+
+Options:`)
+		rcFlags.PrintDefaults()
+	}
+	configPath := rcFlags.String("config", "wpt.json", "path to the configuration file")
+	output := rcFlags.String("o", "", "write the runner config to this `file` instead of stdout")
+	parseFlags(rcFlags, args)
+
+	rc, err := wptsync.GenerateRunnerConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync runner-config: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	data, err := json.MarshalIndent(rc, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync runner-config: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	data = append(data, '\n')
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync runner-config: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+func runBadgeCommand(args []string) {
+	badgeFlags := flag.NewFlagSet("badge", flag.ExitOnError)
+	badgeFlags.Usage = func() {
+		fmt.Fprintln(badgeFlags.Output(), `Write a badge showing how far the pinned commit is behind
+
+Usage:
+  wptsync badge [options]
+
+The badge command compares the pinned commit with the head of the tracked ref
+and writes a badge reading, for example, "wpt | 1a2b3c4d5e · 12 days behind",
+green up to a week behind, then yellow, orange after 30 days, and red after
+90. Days are counted between the two commits' dates.
+
+The JSON form holds both commits, their dates, and days_behind for
+dashboards, plus the label, message, and color that shields.io dynamic JSON
+badges read. The SVG form can be committed or served as-is.
+
+Options:`)
+		badgeFlags.PrintDefaults()
+	}
+	configPath := badgeFlags.String("config", "wpt.json", "path to the configuration file")
+	output := badgeFlags.String("o", "", "write the badge to this `file` instead of stdout")
+	format := badgeFlags.String("format", "", "badge format, json or svg (default: from the -o extension, else json)")
+	opts := newOptions()
+	addCommonFlags(badgeFlags, opts)
+	parseFlags(badgeFlags, args)
+
+	if *format == "" {
+		*format = "json"
+		if strings.HasSuffix(*output, ".svg") {
+			*format = "svg"
+		}
+	}
+	if *format != "json" && *format != "svg" {
+		fmt.Fprintf(os.Stderr, "wptsync badge: -format must be json or svg, not %q\n", *format)
+		os.Exit(wptsync.ExitConfig)
+	}
+
+	badge, err := wptsync.LoadBadge(context.Background(), *configPath, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync badge: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	data := badge.SVG()
+	if *format == "json" {
+		if data, err = json.MarshalIndent(badge, "", "  "); err != nil {
+			fmt.Fprintf(os.Stderr, "wptsync badge: %v\n", err)
+			os.Exit(wptsync.ExitCode(err))
+		}
+		data = append(data, '\n')
+	}
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync badge: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+func runRemoveCommand(args []string) {
+	removeFlags := flag.NewFlagSet("remove", flag.ExitOnError)
+	removeFlags.Usage = func() {
+		fmt.Fprintln(removeFlags.Output(), `Remove files from the configuration
+
+Usage:
+  wptsync remove <path> [options]
+
+The remove command drops every entry whose src or dst is <path>, or whose src
+lies under the folder <path>, from the configuration. Synced files are left
+on disk unless -purge is given. Patch files are never deleted.
+
+Arguments:
+  <path>    WPT path or folder (e.g., url/, resources/testharness.js)
+
+Options:`)
+		removeFlags.PrintDefaults()
+	}
+	configPath := removeFlags.String("config", "wpt.json", "path to the configuration file")
+	purge := removeFlags.Bool("purge", false, "also delete the synced files under target_dir")
+	parseFlags(removeFlags, args)
+
+	if removeFlags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "wptsync remove: missing required path argument")
+		removeFlags.Usage()
+		os.Exit(wptsync.ExitConfig)
+	}
+
+	if err := wptsync.Remove(context.Background(), *configPath, removeFlags.Arg(0), *purge); err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync remove: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+func runSuiteCommand(args []string) {
+	const suiteUsage = `Add, update, remove, and report on named suites
+
+Usage:
+  wptsync suite add [options] <name> <dir>...
+  wptsync suite update [options] <name>
+  wptsync suite remove [options] <name>
+  wptsync suite status [options] [<name>...]
+
+A suite is a named part of WPT, such as url or encoding: the files of some
+upstream directories that a preset selects, give or take -include and
+-exclude patterns. It is recorded under "suites" in the configuration, and
+the entries added for it are tagged with its name, so they can be kept in
+step with upstream and reported on together, patches included.
+
+'add' defines the suite and adds the files it selects, as 'wptsync add'
+does. The presets are js (every .js file, the default), testharness (the
+.any.js, .window.js, and .worker.js tests and every file under a resources
+directory), and all.
+
+'update' adds the files upstream now has in the suite's directories at the
+pinned commit, drops the entries of files that are gone or no longer
+selected, syncs the suite's files only, and prunes the files dropped.
+
+'remove' drops the suite and its entries, and with -purge deletes their
+synced files. Patch files are never deleted.
+
+'status' counts, for each suite, its files, the patched ones, those
+modified, missing, or stale locally, and those changed upstream.
+
+Options:`
+	if len(args) == 0 || !slices.Contains([]string{"add", "update", "remove", "status"}, args[0]) {
+		fmt.Fprintln(os.Stderr, "wptsync suite: expected the add, update, remove, or status subcommand")
+		fmt.Fprintln(os.Stderr, suiteUsage)
+		os.Exit(wptsync.ExitConfig)
+	}
+	sub := args[0]
+	suiteFlags := flag.NewFlagSet("suite "+sub, flag.ExitOnError)
+	suiteFlags.Usage = func() {
+		fmt.Fprintln(suiteFlags.Output(), suiteUsage)
+		suiteFlags.PrintDefaults()
+	}
+	configPath := suiteFlags.String("config", "wpt.json", "path to the configuration file")
+	opts := &wptsync.AddOptions{SyncOptions: *newOptions()}
+	var suite wptsync.Suite
+	purge := new(bool)
+	asJSON := new(bool)
+	switch sub {
+	case "add":
+		suiteFlags.StringVar(&suite.Preset, "preset", "", "the `preset` selecting the suite's files: js, testharness, or all (default js)")
+		suiteFlags.Var((*listFlag)(&suite.Include), "include", "also select files matching this `pattern`")
+		suiteFlags.Var((*listFlag)(&suite.Exclude), "exclude", "skip files matching this `pattern`")
+	case "remove":
+		purge = suiteFlags.Bool("purge", false, "also delete the synced files under target_dir")
+	case "status":
+		suiteFlags.BoolVar(&opts.Quick, "quick", false, "only hash the files whose size or modification time changed since they were synced")
+		addBudgetFlags(suiteFlags, &opts.SyncOptions)
+		asJSON = addFormatFlag(suiteFlags, &opts.SyncOptions)
+	}
+	if sub == "add" || sub == "update" {
+		suiteFlags.IntVar(&opts.MaxFiles, "max-files", wptsync.DefaultMaxFiles, "ask before adding more than this many files (0 for no limit)")
+		suiteFlags.BoolVar(&opts.Yes, "yes", false, "add any number of files without asking")
+		suiteFlags.BoolVar(&opts.WithDeps, "with-deps", false, "also add the scripts the new files load")
+	}
+	if sub != "remove" {
+		addCommonFlags(suiteFlags, &opts.SyncOptions)
+	}
+	parseFlags(suiteFlags, args[1:])
+	if opts.MaxFiles == 0 {
+		opts.MaxFiles = -1
+	}
+	opts.Confirm = confirmOnTerminal
+
+	names := suiteFlags.Args()
+	want := map[string]bool{"add": len(names) >= 2, "update": len(names) == 1, "remove": len(names) == 1, "status": true}
+	if !want[sub] {
+		fmt.Fprintf(os.Stderr, "wptsync suite %s: wrong number of arguments\n", sub)
+		suiteFlags.Usage()
+		os.Exit(wptsync.ExitConfig)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch sub {
+	case "add":
+		suite.Name, suite.Dirs = names[0], names[1:]
+		err = wptsync.SuiteAdd(ctx, *configPath, suite, opts)
+	case "update":
+		err = wptsync.SuiteUpdate(ctx, *configPath, names[0], opts)
+	case "remove":
+		err = wptsync.SuiteRemove(ctx, *configPath, names[0], *purge)
+	case "status":
+		var statuses []wptsync.SuiteStatus
+		var report *wptsync.StatusReport
+		statuses, report, err = wptsync.SuiteStatuses(ctx, *configPath, names, &opts.SyncOptions)
+		if err != nil {
+			break
+		}
+		out := os.Stdout
+		if *asJSON {
+			out = os.Stderr
+		}
+		for _, st := range statuses {
+			details := []string{fmt.Sprintf("%d patched", st.Patched)}
+			for _, state := range []wptsync.LocalState{wptsync.LocalModified, wptsync.LocalMissing, wptsync.LocalStale, wptsync.LocalUnsynced} {
+				if n := st.Local[state]; n > 0 {
+					details = append(details, fmt.Sprintf("%d %s", n, state))
+				}
+			}
+			details = append(details, fmt.Sprintf("%d changed upstream", st.Changed))
+			if st.Unknown > 0 {
+				details = append(details, fmt.Sprintf("%d upstream unknown", st.Unknown))
+			}
+			fmt.Fprintf(out, "  %-16s %4d files  %s  (%s)\n", st.Name, st.Files, strings.Join(details, ", "), strings.Join(st.Dirs, ", "))
+		}
+		if report.Limited != "" {
+			fmt.Fprintf(out, "Upstream changes were not fully checked (%s).\n", report.Limited)
+		}
+		if *asJSON {
+			os.Exit(writeJSON("suite status", statuses, nil, 0))
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync suite %s: %v\n", sub, err)
+		if *asJSON {
+			os.Exit(writeJSON("suite status", nil, err, wptsync.ExitCode(err)))
+		}
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+func runPruneCommand(args []string) {
+	pruneFlags := flag.NewFlagSet("prune", flag.ExitOnError)
+	pruneFlags.Usage = func() {
+		fmt.Fprintln(pruneFlags.Output(), `Delete synced files the configuration no longer lists
+
+Usage:
+  wptsync prune [options]
+
+The prune command deletes the files under target_dir that an earlier sync
+wrote but that no configuration entry maps to any more, such as the files of
+entries removed from wpt.json by hand. wpt.lock keeps track of them across
+syncs. With -untracked, every other file under target_dir that is not
+configured is deleted too. Preview either with -dry-run.
+
+Options:`)
+		pruneFlags.PrintDefaults()
+	}
+	configPath := pruneFlags.String("config", "wpt.json", "path to the configuration file")
+	opts := &wptsync.PruneOptions{SyncOptions: *newOptions()}
+	pruneFlags.BoolVar(&opts.DryRun, "dry-run", false, "list the files that would be deleted without deleting them")
+	pruneFlags.BoolVar(&opts.Untracked, "untracked", false, "also delete files under target_dir that wptsync never synced")
+	parseFlags(pruneFlags, args)
+
+	if _, err := wptsync.Prune(context.Background(), *configPath, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync prune: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+func runSyncCommand(args []string) {
+	syncFlags := flag.NewFlagSet("sync", flag.ExitOnError)
+	syncFlags.Usage = func() {
+		fmt.Fprintln(syncFlags.Output(), `Download WPT files according to the configuration
+
+Usage:
+  wptsync sync [options]
+  wptsync [options]
+
+The sync command downloads files from the web-platform-tests repository
+at the commit specified in the configuration file, and optionally applies
+patches to customize them.
+
+Transient failures (network errors, 429, and 5xx gateway errors) are retried
+with exponential backoff. If the sync still fails, the files it completed are
+recorded in wpt.lock.partial; rerun with -continue to fetch only the rest.
+With -keep-going, a file that fails (say, one renamed upstream) does not stop
+the others: they are all synced, and the run ends with a report of the files
+that failed and hints on the configuration entries to fix, and a non-zero
+exit code. The failed files are left out of wpt.lock, so the next sync
+retries them.
+
+With -verify-blobs, the git blob SHA of every file to fetch is looked up in
+the upstream tree first (one GitHub API request per directory), and content
+that does not hash to it, such as a truncated download or a corrupt cached
+copy, fails with exit code 5 before it replaces the file on disk. The blob
+SHAs are recorded in wpt.lock, for 'wptsync verify -verify-blobs'.
+
+Harness files listed in harness_checksums are checked against the SHA-256
+hashes it allows before they are written, failing with exit code 5 when
+their hash is not allowed. With -require-allowlist, harness files with no
+hash listed fail too, even when the configuration has no harness_checksums.
+
+Files with a validate_cmd, their own or from the "validators" matching them
+(such as "node --check {{dst}}"), have it run once written and patched; a
+failure is reported as a warning. With -strict, it fails the sync with exit
+code 5 instead, as do scan findings with exit code 9, and the files are left
+out of wpt.lock.
+
+With -format json, a report of every file (downloaded, skipped, patched, or
+failed, with sizes and durations) is printed to stdout and progress goes to
+stderr. The exit code is then 7 when there was nothing to do and 8 when some
+files were synced before others failed.
+
+With -github-output, as a GitHub Actions step, the outputs changed ("true"
+when the pinned commit moved or a file's content changed), new_commit,
+previous_commit, and files_changed are appended to $GITHUB_OUTPUT, for later
+steps to open a pull request or run tests on, and nothing prompts.
+
+When stdout is a terminal, a progress line (files done, bytes written, and
+an estimate of the time left) stays below the messages while files are
+fetched. The run ends with a summary: files downloaded, skipped, patched, and
+failed, with the bytes written and the time taken. -q prints nothing but
+errors; -v also lists every file with its outcome, size, and duration.
+
+With -check-dirty, or "check_dirty": true in the configuration, sync first
+asks git whether any file it is about to overwrite has uncommitted changes
+that it did not write itself, such as local debugging edits. If so, it asks
+for confirmation on a terminal and otherwise fails with exit code 2; -force
+skips the check.
+
+With -only and -skip, sync processes only the entries whose src or dst
+matches one of the -only patterns (if any) and none of the -skip patterns, for
+iterating on a single test area; -dry-run previews the subset. Patterns
+containing a "/" match the whole path, with "**" matching any number of
+directories (-only 'url/**'); others match the base name (-skip '*.html').
+
+With -source git:<path>, or "source": "git:<path>" in the configuration,
+files are read from a local clone of WPT (such as an internal mirror) at the
+pinned commit instead of being downloaded; the clone must have that commit.
+
+With -group <name>, sync operates on one of the configuration's "groups"
+instead of its top-level files: each group has a name, a target_dir, files,
+and optionally a commit of its own, and is locked in wpt.<name>.lock.
+
+With -all, every configuration named like -config under its directory (for
+example, each package's wpt.json in a monorepo) is synced in turn. They share
+the download cache, so files they have in common are fetched once, and a lock
+file in that directory makes concurrent -all runs wait for each other.
+
+Options:`)
+		syncFlags.PrintDefaults()
+	}
+	configPath := syncFlags.String("config", "wpt.json", "path to the WPT sync configuration file")
+	opts := newOptions()
+	syncFlags.BoolVar(&opts.SkipPatches, "skip-patches", false, "download files but do not apply any configured patches")
+	syncFlags.BoolVar(&opts.DryRun, "dry-run", false, "print the actions that would be taken without writing files")
+	syncFlags.BoolVar(&opts.Force, "force", false, "bypass the freshness stamp and lock file and force a full sync")
+	syncFlags.BoolVar(&opts.Continue, "continue", false, "resume a failed sync or update, skipping the files it completed")
+	syncFlags.BoolVar(&opts.KeepGoing, "keep-going", false, "sync every file that can be, then report the ones that failed")
+	syncFlags.BoolVar(&opts.VerifyBlobs, "verify-blobs", false, "check every file fetched against its git blob SHA in the upstream tree before writing it")
+	syncFlags.BoolVar(&opts.RequireAllowlist, "require-allowlist", false, "refuse harness files whose SHA-256 is not listed in harness_checksums, for locked-down builds")
+	syncFlags.BoolVar(&opts.Strict, "strict", false, "fail when a file's validate_cmd fails or the scan policy flags it, leaving it out of the lock file")
+	syncFlags.IntVar(&opts.Jobs, "jobs", wptsync.DefaultJobs, "number of files to download concurrently")
+	syncFlags.StringVar(&opts.Mode, "mode", wptsync.ModeRaw, "how to fetch files: raw (one request per file), archive (one tarball for the commit), or batch (small files in batched GraphQL queries)")
+	syncFlags.BoolVar(&opts.ChangedOnly, "changed-only", false, "only re-sync files that changed upstream since the commit recorded in the lock file")
+	syncFlags.StringVar(&opts.Source, "source", "", "read files from `git:<path>`, a local WPT clone, or bundle:<path>, a tarball, instead of the network (overrides \"source\" in the config)")
+	syncFlags.BoolVar(&opts.WithDeps, "with-deps", false, "add the scripts synced files load with META script directives, imports, and importScripts to the configuration and sync them")
+	syncFlags.BoolVar(&opts.CheckDirty, "check-dirty", false, "refuse to overwrite synced files with uncommitted git changes unless confirmed or -force (also \"check_dirty\" in the config)")
+	syncFlags.Var((*listFlag)(&opts.Only), "only", "sync only the entries whose src or dst matches this `pattern` (\"**\" matches any number of directories; repeatable)")
+	syncFlags.StringVar(&opts.Group, "group", "", "operate on the named `group` of the configuration (see \"groups\") instead of its top-level files")
+	syncFlags.Var((*listFlag)(&opts.Skip), "skip", "leave out the entries whose src or dst matches this `pattern` (repeatable)")
+	all := syncFlags.Bool("all", false, "sync every config named like -config under its directory")
+	prune := syncFlags.Bool("prune", false, "then delete synced files the configuration no longer lists (see 'wptsync prune')")
+	addStatsFlag(syncFlags, opts)
+	asJSON := addFormatFlag(syncFlags, opts)
+	out := addOutputFlags(syncFlags)
+	githubOutput := addGitHubOutputFlag(syncFlags)
+	addCommonFlags(syncFlags, opts)
+	parseFlags(syncFlags, args)
+	if err := out.start(opts, *asJSON); err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync sync: %v\n", err)
+		os.Exit(wptsync.ExitConfig)
+	}
+	outputPath, err := githubOutput.path()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync sync: %v\n", err)
+		os.Exit(wptsync.ExitConfig)
+	}
+	if !*asJSON && outputPath == "" {
+		// Prompts would corrupt the report on stdout, and would hang a
+		// workflow.
+		opts.ConfirmDirty = confirmOverwrite
+	}
+
+	if opts.Group != "" && (*all || *prune) {
+		fmt.Fprintln(os.Stderr, "wptsync sync: -group cannot be combined with -all or -prune")
+		os.Exit(wptsync.ExitConfig)
+	}
+	if *all {
+		if *asJSON || outputPath != "" {
+			fmt.Fprintln(os.Stderr, "wptsync sync: -all cannot be combined with -format json or -github-output")
+			os.Exit(wptsync.ExitConfig)
+		}
+		configs, err := wptsync.SyncAll(context.Background(), filepath.Dir(*configPath), filepath.Base(*configPath), opts)
+		if err == nil && *prune {
+			for _, config := range configs {
+				if _, err = wptsync.Prune(context.Background(), config, &wptsync.PruneOptions{SyncOptions: *opts}); err != nil {
+					break
+				}
+			}
+		}
+		out.finish(nil)
+		if err != nil {
+			writeFailures(err)
+			fmt.Fprintf(os.Stderr, "wptsync sync: %v\n", err)
+			os.Exit(wptsync.ExitCode(err))
+		}
+		return
+	}
+
+	opts.Report = &wptsync.SyncReport{}
+	err = wptsync.Sync(context.Background(), *configPath, opts)
+	if err == nil && *prune {
+		_, err = wptsync.Prune(context.Background(), *configPath, &wptsync.PruneOptions{SyncOptions: *opts})
+	}
+	if !*asJSON {
+		out.finish(opts.Report)
+	}
+	writeGitHubOutput("sync", outputPath, opts.Report)
+	if err != nil {
+		writeFailures(err)
+		fmt.Fprintf(os.Stderr, "wptsync sync: %v\n", err)
+	}
+	if *asJSON {
+		os.Exit(writeJSON("sync", opts.Report, err, opts.Report.ExitCode(err)))
+	}
+	if err != nil {
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+// addFormatFlag registers the -format flag of sync, status, and update on fs
+// and returns whether it selects JSON. JSON sends opts' progress messages to
+// stderr, leaving stdout to the report.
+func addFormatFlag(fs *flag.FlagSet, opts *wptsync.SyncOptions) *bool {
+	asJSON := new(bool)
+	fs.Func("format", "output `format`: text, or json for a report on stdout with progress on stderr", func(v string) error {
+		switch v {
+		case "text":
+			*asJSON = false
+		case "json":
+			*asJSON = true
+			opts.Logf = func(format string, args ...any) { fmt.Fprintf(os.Stderr, format, args...) }
+		default:
+			return fmt.Errorf("want text or json")
+		}
+		return nil
+	})
+	return asJSON
+}
+
+// output is how much sync and update print, as set by -q and -v.
+type output struct {
+	quiet, verbose bool
+	line           *progressLine
+}
+
+// addOutputFlags registers -q and -v on fs.
+func addOutputFlags(fs *flag.FlagSet) *output {
+	out := &output{}
+	fs.BoolVar(&out.quiet, "q", false, "print nothing but errors")
+	fs.BoolVar(&out.verbose, "v", false, "after the run, also list every file with its outcome, size, and duration")
+	return out
+}
+
+// start applies the output level to opts once the flags are parsed: -q
+// drops the progress messages, and otherwise, when stdout is a terminal
+// and gets no JSON, a live progress line is kept below them.
+func (o *output) start(opts *wptsync.SyncOptions, asJSON bool) error {
+	if o.quiet && o.verbose {
+		return errors.New("-q and -v are mutually exclusive")
+	}
+	if o.quiet {
+		opts.Logf = nil
+		return nil
+	}
+	if asJSON || !isTerminal(os.Stdout) {
+		return nil
+	}
+	o.line = &progressLine{out: os.Stdout}
+	logf := opts.Logf
+	opts.Logf = func(format string, args ...any) {
+		o.line.print(func() { logf(format, args...) })
+	}
+	opts.Progress = o.line.update
+	return nil
+}
+
+// finish takes down the progress line and prints the end-of-run summary of
+// report, if any, at the output level.
+func (o *output) finish(report *wptsync.SyncReport) {
+	o.line.clear()
+	if o.quiet || report == nil {
+		return
+	}
+	if o.verbose {
+		report.WriteFiles(os.Stdout)
+	}
+	report.WriteDirectories(os.Stdout)
+	if !report.UpToDate && len(report.Files) > 0 {
+		report.WriteSummary(os.Stdout)
+	}
+}
+
+// progressLine keeps a run's progress, such as "[42/120 files, 1.3 MiB,
+// about 12s left]", on the last line of a terminal, below its messages.
+type progressLine struct {
+	mu    sync.Mutex
+	out   *os.File
+	shown string
+}
+
+// print runs write, which prints a message, with the progress line moved
+// below it.
+func (l *progressLine) print(write func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.erase()
+	write()
+	l.draw()
+}
+
+// update redraws the line for p, and takes it down once every file is
+// done.
+func (l *progressLine) update(p wptsync.SyncProgress) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.erase()
+	l.shown = ""
+	if p.Done < p.Total {
+		l.shown = "[" + p.String() + "]"
+		l.draw()
+	}
+}
+
+// clear takes the line down, if there is one.
+func (l *progressLine) clear() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.erase()
+	l.shown = ""
+}
+
+func (l *progressLine) erase() {
+	if l.shown != "" {
+		fmt.Fprint(l.out, "\r\033[K")
+	}
+}
+
+func (l *progressLine) draw() {
+	if l.shown != "" {
+		fmt.Fprint(l.out, l.shown)
+	}
+}
+
+// writeFailures prints the failure report of a -keep-going run to stderr,
+// if err has one.
+func writeFailures(err error) {
+	var failures *wptsync.FailuresError
+	if errors.As(err, &failures) {
+		fmt.Fprintln(os.Stderr)
+		failures.WriteReport(os.Stderr)
+	}
+}
+
+// addStatsFlag turns on run statistics for sync and update, registering
+// -no-stats on fs to turn them off again.
+func addStatsFlag(fs *flag.FlagSet, opts *wptsync.SyncOptions) {
+	opts.RecordStats = true
+	fs.BoolFunc("no-stats", "do not append this run to the stats file (see 'wptsync stats')", func(string) error {
+		opts.RecordStats = false
+		return nil
+	})
+}
+
+// githubOutputFlag is the -github-output flag of sync and update.
+type githubOutputFlag bool
+
+// addGitHubOutputFlag registers -github-output on fs.
+func addGitHubOutputFlag(fs *flag.FlagSet) *githubOutputFlag {
+	f := new(githubOutputFlag)
+	fs.BoolVar((*bool)(f), "github-output", false, "write changed, new_commit, previous_commit, and files_changed to $GITHUB_OUTPUT, for a GitHub Actions step")
+	return f
+}
+
+// path returns the file $GITHUB_OUTPUT names when the flag is set, and an
+// error if it names none, as outside of GitHub Actions.
+func (f *githubOutputFlag) path() (string, error) {
+	if !*f {
+		return "", nil
+	}
+	p := os.Getenv("GITHUB_OUTPUT")
+	if p == "" {
+		return "", errors.New("-github-output: GITHUB_OUTPUT is not set; run it as a GitHub Actions step")
+	}
+	return p, nil
+}
+
+// writeGitHubOutput appends the outputs of report to the file at path, if
+// any, for the step running command. Failing to only warns, as the run
+// itself is over.
+func writeGitHubOutput(command, path string, report *wptsync.SyncReport) {
+	if path == "" {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err == nil {
+		err = report.WriteGitHubOutput(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync %s: warning: write GitHub Actions outputs: %v\n", command, err)
+	}
+}
+
+// writeJSON prints the -format json result of command to stdout and returns
+// code, the exit code it records.
+func writeJSON(command string, report any, err error, code int) int {
+	out := struct {
+		Command  string `json:"command"`
+		ExitCode int    `json:"exit_code"`
+		Error    string `json:"error,omitempty"`
+		Report   any    `json:"report,omitempty"`
+	}{Command: command, ExitCode: code, Report: report}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync %s: write report: %v\n", command, err)
+		return wptsync.ExitFailure
+	}
+	return code
+}
+
+// newOptions returns the options every command starts from: progress
+// messages go to stdout.
+func newOptions() *wptsync.SyncOptions {
+	return &wptsync.SyncOptions{
+		Logf: func(format string, args ...any) { fmt.Printf(format, args...) },
+	}
+}
+
+// confirmOnTerminal asks on stdin whether to add n files. Without a terminal
+// to ask on, the answer is no.
+func confirmOnTerminal(n int) bool {
+	return askOnTerminal(fmt.Sprintf("Add all %d files?", n))
+}
+
+// confirmOverwrite lists the locally edited files a sync would overwrite and
+// asks on stdin whether to go ahead. Without a terminal, the answer is no.
+func confirmOverwrite(paths []string) bool {
+	if !isTerminal(os.Stdin) {
+		return false
+	}
+	fmt.Println("These files have uncommitted changes that sync would overwrite:")
+	for _, p := range paths {
+		fmt.Printf("  %s\n", p)
+	}
+	return askOnTerminal("Overwrite them?")
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// askOnTerminal asks question on stdin, answered yes or no. Without a
+// terminal to ask on, the answer is no.
+func askOnTerminal(question string) bool {
+	if !isTerminal(os.Stdin) {
+		return false
+	}
+	answer := prompt(question + " [y/N] ")
+	return answer == "y" || answer == "yes"
+}
+
+// stdin reads the answers to prompts, buffered once so that none is lost
+// between them.
+var stdin = bufio.NewReader(os.Stdin)
+
+// prompt prints question and returns the line answered on stdin, trimmed
+// and lowercased; "q" at the end of the input.
+func prompt(question string) string {
+	fmt.Print(question)
+	answer, err := stdin.ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Println()
+		return "q"
+	}
+	return strings.ToLower(strings.TrimSpace(answer))
+}
+
+// errAddQuit is returned by selectOnTerminal when the user quits.
+var errAddQuit = errors.New("quit before adding anything")
+
+// selectOnTerminal asks on stdin which of srcs, the new files under dir, to
+// add: all, none, or each in turn.
+func selectOnTerminal(dir string, srcs []string) ([]string, error) {
+	for {
+		switch prompt(fmt.Sprintf("%s: %d new file(s). Add [a]ll, [n]one, [p]ick, [l]ist, or [q]uit? ", dir, len(srcs))) {
+		case "a", "all":
+			return srcs, nil
+		case "n", "none":
+			return nil, nil
+		case "l", "list":
+			for _, src := range srcs {
+				fmt.Printf("  %s\n", src)
+			}
+		case "q", "quit":
+			return nil, errAddQuit
+		case "p", "pick":
+			var picked []string
+			for i, src := range srcs {
+				switch prompt(fmt.Sprintf("  %s [y/N, a: this and the rest, d: none of the rest, q: quit] ", src)) {
+				case "y", "yes":
+					picked = append(picked, src)
+				case "a":
+					return append(picked, srcs[i:]...), nil
+				case "d":
+					return picked, nil
+				case "q":
+					return nil, errAddQuit
+				}
+			}
+			return picked, nil
+		}
+	}
+}
+
+// parseFlags parses args with fs, after registering -report-usage on it.
+// With -report-usage, or WPTSYNC_REPORT_USAGE=1, the command's name and the
+// names of the flags set are counted in the local usage file.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	report := fs.Bool("report-usage", os.Getenv("WPTSYNC_REPORT_USAGE") == "1", "count this command and the names of its flags in a local file, never sent anywhere (see 'wptsync usage')")
+	fs.Parse(args)
+	findConfig(fs)
+	if !*report {
+		return
+	}
+	var names []string
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "report-usage" {
+			names = append(names, f.Name)
+		}
+	})
+	if p := wptsync.DefaultUsagePath(); p != "" {
+		if err := wptsync.RecordUsage(p, fs.Name(), names); err != nil {
+			fmt.Fprintf(os.Stderr, "wptsync: warning: record usage: %v\n", err)
+		}
+	}
+}
+
+// findConfig points a -config left at its default wpt.json to wpt.yaml,
+// wpt.yml, or wpt.toml, the first of those that exists. Commands that create
+// the file take -config as given.
+func findConfig(fs *flag.FlagSet) {
+	f := fs.Lookup("config")
+	if f == nil || f.Value.String() != "wpt.json" || fs.Name() == "init" || fs.Name() == "import" {
+		return
+	}
+	set := false
+	fs.Visit(func(v *flag.Flag) { set = set || v == f })
+	if set {
+		return
+	}
+	if _, err := os.Stat("wpt.json"); err == nil {
+		return
+	}
+	for _, name := range []string{"wpt.yaml", "wpt.yml", "wpt.toml"} {
+		if _, err := os.Stat(name); err == nil {
+			f.Value.Set(name)
+			return
+		}
+	}
+}
+
+func runUsageCommand(args []string) {
+	usageFlags := flag.NewFlagSet("usage", flag.ExitOnError)
+	usageFlags.Usage = func() {
+		fmt.Fprintln(usageFlags.Output(), `Show the usage counters recorded with -report-usage
+
+Usage:
+  wptsync usage [options]
+
+Counting is off unless a command is run with -report-usage, or with
+WPTSYNC_REPORT_USAGE=1 in the environment. It then adds one to the
+command's count, and to the count of each flag set, in a file under the user
+configuration directory. Flag values, paths, and repositories are never
+recorded, and nothing is sent over the network: the usage command prints the
+file so you can attach it to a bug report if you like.
+
+Options:`)
+		usageFlags.PrintDefaults()
+	}
+	reset := usageFlags.Bool("reset", false, "delete the counters")
+	parseFlags(usageFlags, args)
+
+	p := wptsync.DefaultUsagePath()
+	if p == "" {
+		fmt.Fprintln(os.Stderr, "wptsync usage: no user configuration directory")
+		os.Exit(1)
+	}
+	if *reset {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "wptsync usage: %v\n", err)
+			os.Exit(wptsync.ExitCode(err))
+		}
+		fmt.Printf("Removed %s\n", p)
+		return
+	}
+	u, err := wptsync.LoadUsage(p)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync usage: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	if len(u.Commands) == 0 {
+		fmt.Fprintf(os.Stderr, "No usage recorded in %s; run commands with -report-usage to count them.\n", p)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Usage recorded in %s:\n", p)
+	data, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync usage: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	os.Stdout.Write(append(data, '\n'))
+}
+
+// listFlag is a flag that may be repeated, collecting every value.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// addBudgetFlags registers -offline and -budget, for the commands that
+// enrich their output with upstream data, on fs.
+func addBudgetFlags(fs *flag.FlagSet, opts *wptsync.SyncOptions) {
+	fs.BoolVar(&opts.Offline, "offline", false, "make no GitHub API requests; report from local state only")
+	fs.IntVar(&opts.Budget, "budget", 0, "make at most `n` GitHub API requests, leaving the rest unknown (0: no limit)")
+}
+
+// addCommonFlags registers the flags shared by every command on fs, storing
+// their values in opts once fs is parsed.
+func addCommonFlags(fs *flag.FlagSet, opts *wptsync.SyncOptions) {
+	fs.DurationVar(&opts.Timeouts.Resolve, "resolve-timeout", 0, fmt.Sprintf("deadline for each GitHub API lookup (default %s, or network.timeout in the config)", wptsync.DefaultTimeouts.Resolve))
+	fs.DurationVar(&opts.Timeouts.Download, "download-timeout", 0, fmt.Sprintf("deadline for each file download (default %s, or network.timeout in the config)", wptsync.DefaultTimeouts.Download))
+	fs.DurationVar(&opts.Timeouts.Patch, "patch-timeout", wptsync.DefaultTimeouts.Patch, "deadline for each patch application")
+	fs.BoolVar(&opts.NoTimeout, "no-timeout", false, "disable all per-phase deadlines (useful for very large syncs)")
+	fs.BoolVar(&opts.UseGit, "use-git", false, "apply patches with git apply instead of the built-in applier")
+	fs.StringVar(&opts.Token, "token", "", "GitHub token for API requests (default: a GitHub App set with -app-id, or $GITHUB_TOKEN)")
+	fs.Int64Var(&opts.AppID, "app-id", 0, "authenticate API requests as an installation of this GitHub App `id` (default: $GITHUB_APP_ID)")
+	fs.Func("app-key", "the GitHub App's private key `file` (default: the key in $GITHUB_APP_PRIVATE_KEY)", func(v string) error {
+		data, err := os.ReadFile(v)
+		opts.AppKey = string(data)
+		return err
+	})
+	fs.Int64Var(&opts.AppInstallation, "app-installation", 0, "the GitHub App installation `id` to mint tokens for (default: $GITHUB_APP_INSTALLATION_ID, or the app's installation on the repository owner or its only one)")
+	fs.StringVar(&opts.Network.Proxy, "proxy", "", "send every request through the proxy at this `url` (default: network.proxy in the config, or $HTTPS_PROXY)")
+	fs.Func("ca-bundle", "also trust the CA certificates in this PEM `file`, for a proxy or mirror with an internal CA", func(v string) error {
+		// Unlike network.ca_bundle, the flag is relative to the working
+		// directory.
+		p, err := filepath.Abs(v)
+		opts.Network.CABundle = p
+		return err
+	})
+	fs.BoolVar(&opts.Network.InsecureSkipVerify, "insecure-skip-verify", false, "accept any TLS certificate (for trying out a mirror only)")
+	fs.Func("header", "add the header `'Name: value'` to the requests to the mirror (repeatable; see network.header_hosts)", func(v string) error {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return errors.New("want 'Name: value'")
+		}
+		if opts.Network.Headers == nil {
+			opts.Network.Headers = make(map[string]string)
+		}
+		opts.Network.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		return nil
+	})
+	fs.StringVar(&opts.RecordDir, "record", "", "save every HTTP response under this `dir` for later replay")
+	fs.StringVar(&opts.ReplayDir, "replay", "", "serve HTTP responses recorded with -record from this `dir` instead of the network")
+	fs.Func("retries", "retry transient download failures up to `n` times, 0 to disable (default 3)", func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("want a non-negative count")
+		}
+		opts.Retries = n
+		if n == 0 {
+			opts.Retries = -1
+		}
+		return nil
+	})
+	fs.Func("fault-inject", "developer mode: fail requests at random, per `p=0.1[,seed=N]`, to test retries and partial failures", func(v string) error {
+		f, err := wptsync.ParseFaultInjection(v)
+		if err != nil {
+			return err
+		}
+		if f.Seed == 0 {
+			f.Seed = rand.Uint64()
+		}
+		fmt.Fprintf(os.Stderr, "wptsync: injecting faults into %g%% of requests (seed=%d)\n", f.Probability*100, f.Seed)
+		opts.FaultInjection = f
+		return nil
+	})
+	fs.DurationVar(&opts.RetryDelay, "retry-delay", wptsync.DefaultRetryDelay, "backoff before the first retry; it doubles, with jitter, after each one")
+	fs.StringVar(&opts.CacheDir, "cache-dir", wptsync.DefaultCacheDir(), "keep downloaded files in this `dir`, keyed by commit and path")
+	fs.BoolFunc("no-cache", "neither read nor fill the download cache", func(string) error {
+		opts.CacheDir = ""
+		return nil
+	})
+	fs.Func("cache-size", "trim the download cache to this many `MiB` after a sync (default 1024)", func(v string) error {
+		mib, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || mib <= 0 {
+			return fmt.Errorf("want a positive number of MiB")
+		}
+		opts.CacheSize = mib << 20
+		return nil
+	})
+}
+
+func runStatsCommand(args []string) {
+	statsFlags := flag.NewFlagSet("stats", flag.ExitOnError)
+	statsFlags.Usage = func() {
+		fmt.Fprintln(statsFlags.Output(), `Show how syncs and updates have been performing
+
+Usage:
+  wptsync stats [options]
+
+Every sync and update appends its duration, bytes written, files changed,
+and failures to wpt.stats.jsonl next to the configuration (unless run with
+-no-stats). The stats command compares the last -last runs with the ones
+before them, so a slowdown or a rise in failures stands out. With -runs it
+also lists each of those runs.
+
+Options:`)
+		statsFlags.PrintDefaults()
+	}
+	configPath := statsFlags.String("config", "wpt.json", "path to the configuration file")
+	listRuns := statsFlags.Bool("runs", false, "list every run in the window")
+	last := statsFlags.Int("last", 20, "number of recent `runs` to compare with the ones before them")
+	parseFlags(statsFlags, args)
+
+	if *last <= 0 {
+		fmt.Fprintln(os.Stderr, "wptsync stats: -last must be positive")
+		os.Exit(wptsync.ExitConfig)
+	}
+	runs, err := wptsync.LoadRunStats(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync stats: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs recorded yet.")
+		return
+	}
+
+	recent := runs[max(len(runs)-*last, 0):]
+	earlier := runs[max(len(runs)-2**last, 0) : len(runs)-len(recent)]
+	if *listRuns {
+		fmt.Printf("%-20s %-7s %-8s %9s %8s %10s %7s\n", "TIME", "COMMAND", "COMMIT", "DURATION", "CHANGED", "BYTES", "FAILED")
+		for _, run := range recent {
+			commit := run.Commit
+			if len(commit) > 8 {
+				commit = commit[:8]
+			}
+			failed := strconv.Itoa(run.Failed)
+			if run.Error != "" && run.Failed == 0 {
+				failed = "error"
+			}
+			fmt.Printf("%-20s %-7s %-8s %9s %8d %10d %7s\n", run.Time.Local().Format("2006-01-02 15:04:05"), run.Command, commit,
+				run.Duration.Round(time.Millisecond), run.Changed, run.Bytes, failed)
+		}
+		fmt.Println()
+	}
+
+	now := wptsync.SummarizeRuns(recent)
+	fmt.Printf("Last %d runs: median %v, %d failed (%.0f%%), %d files changed.\n",
+		now.Runs, now.MedianDuration.Round(time.Millisecond), now.Failed, 100*now.FailureRate(), now.Changed)
+	if len(earlier) == 0 {
+		return
+	}
+	before := wptsync.SummarizeRuns(earlier)
+	fmt.Printf("%d runs before: median %v, %d failed (%.0f%%), %d files changed.\n",
+		before.Runs, before.MedianDuration.Round(time.Millisecond), before.Failed, 100*before.FailureRate(), before.Changed)
+	// Flag a slowdown of half again, ignoring jitter on quick runs.
+	if now.MedianDuration > before.MedianDuration*3/2 && now.MedianDuration-before.MedianDuration > time.Second {
+		fmt.Printf("Syncs got slower: the median went up %.0f%%.\n", 100*(float64(now.MedianDuration)/float64(before.MedianDuration)-1))
+	}
+	if now.FailureRate() > before.FailureRate() {
+		fmt.Printf("Runs fail more often than before.\n")
+	}
+}
+
+func runCacheCommand(args []string) {
+	cacheFlags := flag.NewFlagSet("cache", flag.ExitOnError)
+	cacheFlags.Usage = func() {
+		fmt.Fprintln(cacheFlags.Output(), `Manage the download cache
+
+Usage:
+  wptsync cache [options] clean
+
+Every file fetched at a commit SHA is kept in the cache directory, keyed by
+commit and path, so later syncs at that commit (common when iterating with
+-skip-patches or -force) skip the download. The cache is trimmed to
+-cache-size after each sync and update, least recently used files first;
+'clean' deletes it entirely.
+
+Options:`)
+		cacheFlags.PrintDefaults()
+	}
+	dir := cacheFlags.String("cache-dir", wptsync.DefaultCacheDir(), "the cache `dir`")
+	parseFlags(cacheFlags, args)
+
+	if cacheFlags.NArg() != 1 || cacheFlags.Arg(0) != "clean" {
+		fmt.Fprintln(os.Stderr, "wptsync cache: expected the clean subcommand")
+		cacheFlags.Usage()
+		os.Exit(wptsync.ExitConfig)
+	}
+	freed, err := wptsync.CleanCache(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync cache: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+	fmt.Printf("Removed %s (%.1f MiB)\n", *dir, float64(freed)/(1<<20))
+}
+
+// prefetchChildEnv marks the process prefetch -background starts, which
+// does the work.
+const prefetchChildEnv = "WPTSYNC_PREFETCH_CHILD"
+
+func runPrefetchCommand(args []string) {
+	prefetchFlags := flag.NewFlagSet("prefetch", flag.ExitOnError)
+	prefetchFlags.Usage = func() {
+		fmt.Fprintln(prefetchFlags.Output(), `Fill the download cache ahead of a sync or update
+
+Usage:
+  wptsync prefetch [options]
+
+The prefetch command downloads the configured files at the pinned commit
+into the download cache, without touching target_dir or wpt.lock, so that
+the next sync reads them from there instead of the network. With -latest,
+it also downloads them at the commit 'wptsync update' would move to, so the
+update takes seconds even on a slow connection. Files already cached are not
+downloaded again, and a file that fails does not stop the others.
+
+With -background, the work goes on in a separate process after the command
+returns, logging to prefetch.log in the cache directory.
+
+Options:`)
+		prefetchFlags.PrintDefaults()
+	}
+	configPath := prefetchFlags.String("config", "wpt.json", "path to the configuration file")
+	opts := &wptsync.PrefetchOptions{SyncOptions: *newOptions()}
+	prefetchFlags.BoolVar(&opts.Latest, "latest", false, "also prefetch the files at the latest upstream commit, for the next update")
+	background := prefetchFlags.Bool("background", false, "prefetch in a separate process, logging to prefetch.log in the cache directory, and return at once")
+	prefetchFlags.IntVar(&opts.Jobs, "jobs", wptsync.DefaultJobs, "number of files to download concurrently")
+	prefetchFlags.StringVar(&opts.Mode, "mode", wptsync.ModeRaw, "how to fetch files: raw (one request per file), archive (one tarball for the commit), or batch (small files in batched GraphQL queries)")
+	prefetchFlags.StringVar(&opts.Group, "group", "", "prefetch the files of the named `group` of the configuration instead of its top-level files")
+	addCommonFlags(prefetchFlags, &opts.SyncOptions)
+	parseFlags(prefetchFlags, args)
+
+	if *background && os.Getenv(prefetchChildEnv) == "" {
+		if opts.CacheDir == "" {
+			fmt.Fprintln(os.Stderr, "wptsync prefetch: -background needs the download cache, which -no-cache turns off")
+			os.Exit(wptsync.ExitConfig)
+		}
+		pid, logPath, err := startPrefetch(opts.CacheDir, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wptsync prefetch: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Prefetching in the background (pid %d); see %s\n", pid, logPath)
+		return
+	}
+
+	res, err := wptsync.Prefetch(context.Background(), *configPath, opts)
+	if res != nil && len(res.Commits) > 0 {
+		fmt.Printf("Prefetched %d file(s) at %d commit(s); %d were already cached", res.Fetched, len(res.Commits), res.Cached)
+		if res.Failed > 0 {
+			fmt.Printf(", %d failed", res.Failed)
+		}
+		fmt.Println()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wptsync prefetch: %v\n", err)
+		os.Exit(wptsync.ExitCode(err))
+	}
+}
+
+// startPrefetch starts wptsync prefetch with args again, detached, with its
+// output appended to prefetch.log in cacheDir, and returns its pid and the
+// log's path.
+func startPrefetch(cacheDir string, args []string) (int, string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, "", err
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return 0, "", err
+	}
+	logPath := filepath.Join(cacheDir, "prefetch.log")
+	log, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, "", err
+	}
+	defer log.Close()
+	fmt.Fprintf(log, "--- %s: wptsync prefetch %s\n", time.Now().Format(time.RFC3339), strings.Join(args, " "))
+	cmd := exec.Command(exe, append([]string{"prefetch"}, args...)...)
+	cmd.Env = append(os.Environ(), prefetchChildEnv+"=1")
+	cmd.Stdout, cmd.Stderr = log, log
+	if err := cmd.Start(); err != nil {
+		return 0, "", fmt.Errorf("start background prefetch: %w", err)
 	}
+	pid := cmd.Process.Pid
+	return pid, logPath, cmd.Process.Release()
 }
@@ -1,49 +1,73 @@
 package wptsync
 
 import (
+	"bufio"
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 )
 
-const wptGitHubAPIURL = "https://api.github.com/repos/web-platform-tests/wpt/commits/master"
+// InitOptions configures an Init run. A nil *InitOptions is equivalent to
+// its zero value.
+type InitOptions struct {
+	SyncOptions
+	// Repo, Ref, RawBaseURL, and APIURL are written to the new
+	// configuration (see Config) and used to resolve its commit.
+	Repo       string
+	Ref        string
+	RawBaseURL string
+	APIURL     string
+}
 
-const wptGitHubTreesAPI = "https://api.github.com/repos/web-platform-tests/wpt/git/trees"
+// Init fetches the latest commit of the upstream ref and creates a new
+// configuration file at configPath with an empty file list. It returns an
+// error if configPath already exists.
+func Init(ctx context.Context, configPath string, opts *InitOptions) error {
+	if opts == nil {
+		opts = &InitOptions{}
+	}
+	cfg := Config{
+		Repo:       opts.Repo,
+		Ref:        opts.Ref,
+		RawBaseURL: opts.RawBaseURL,
+		APIURL:     opts.APIURL,
+		TargetDir:  "wpt",
+		Files:      []FileSpec{},
+	}
+	if err := opts.SyncOptions.validate(); err != nil {
+		return err
+	}
+	syncOpts := opts.SyncOptions.forConfig(&cfg)
 
-// Init fetches the latest WPT commit and creates a new configuration file at
-// configPath with an empty file list. It returns an error if configPath
-// already exists.
-func Init(ctx context.Context, configPath string) error {
 	// Check if config already exists
 	if _, err := os.Stat(configPath); err == nil {
-		return fmt.Errorf("config file %q already exists", configPath)
+		return invalidConfig(fmt.Errorf("config file %q already exists", configPath))
 	}
 
 	fmt.Printf("Fetching latest WPT commit...\n")
 
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	ctx, cancel := withTimeout(ctx, syncOpts.timeouts().Resolve)
 	defer cancel()
 
-	commit, err := fetchLatestCommit(ctx)
+	commit, err := syncOpts.github().latestCommit(ctx)
 	if err != nil {
 		return fmt.Errorf("fetch latest commit: %w", err)
 	}
 
-	cfg := Config{
-		Commit:    commit,
-		TargetDir: "wpt",
-		Files:     []FileSpec{},
+	cfg.Commit = commit
+	if err := cfg.validate(); err != nil {
+		return err
 	}
-
 	if err := SaveConfig(configPath, &cfg); err != nil {
 		return err
 	}
@@ -52,62 +76,261 @@ func Init(ctx context.Context, configPath string) error {
 	return nil
 }
 
-func fetchLatestCommit(ctx context.Context) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wptGitHubAPIURL, nil)
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
+// AddOptions configures an Add run. A nil *AddOptions is equivalent to its
+// zero value.
+type AddOptions struct {
+	SyncOptions
+	// Include lists the patterns (see matchFilter) a file must match one of
+	// to be added. Empty means "*.js".
+	Include []string
+	// Exclude lists patterns that keep an otherwise included file out.
+	Exclude []string
+	// Since, when set, limits Add to files added upstream after a commit or
+	// a date ("2006-01-02" or RFC 3339) and up to the pinned commit.
+	Since string
+	// MaxFiles is how many new entries Add registers without confirmation.
+	// Zero means DefaultMaxFiles; a negative value removes the limit.
+	MaxFiles int
+	// Yes adds any number of files without confirmation.
+	Yes bool
+	// Confirm, when set, is asked whether to go ahead with an add over
+	// MaxFiles, after a summary by subdirectory has been printed. Nil
+	// means no, so Add fails with ErrTooManyFiles.
+	Confirm func(n int) bool
+	// Select, when set, picks the files to add: it is called once for each
+	// subdirectory of the added path holding new files (see groupByDir), in
+	// order, with those files, and returns the ones to keep. An error, such
+	// as the user quitting, aborts the add before anything is written. What
+	// is selected is added without asking about MaxFiles.
+	Select func(dir string, srcs []string) ([]string, error)
+	// Manifest lists files from WPT's MANIFEST.json at the pinned commit
+	// instead of the repository tree. Include then defaults to every file.
+	Manifest bool
+	// Types keeps only the files of these manifest item types, such as
+	// "testharness", "reftest", or "support". It implies Manifest.
+	Types []string
+	// ManifestURL is the wpt.fyi instance the manifest is downloaded from.
+	// Empty means DefaultWPTFyiURL.
+	ManifestURL string
+	// Scopes is recorded as the scopes of every .any.js file added.
+	Scopes []string
+	// SplitScopes adds every .any.js file as one entry per scope (Scopes,
+	// or window and dedicatedworker), named like foo.window.js and
+	// foo.worker.js.
+	SplitScopes bool
+	// Suite tags the new entries with the suite they are added for (see
+	// Suite). Adding a directory then also drops the suite's entries under
+	// it that are gone upstream or no longer pass the filters.
+	Suite string
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
+// anyScopes returns the entries to add for the .any.js file src named dst,
+// per opts.Scopes and opts.SplitScopes.
+func (o *AddOptions) anyScopes(src, dst string) []FileSpec {
+	if !o.SplitScopes {
+		return []FileSpec{{Src: src, Dst: dst, Scopes: o.Scopes}}
+	}
+	scopes := o.Scopes
+	if len(scopes) == 0 {
+		scopes = testSuffixes[0].globals // .any.js defaults
 	}
-	defer resp.Body.Close()
+	files := make([]FileSpec, len(scopes))
+	for i, scope := range scopes {
+		files[i] = FileSpec{Src: src, Dst: scopeDst(dst, scope), Scopes: []string{scope}}
+	}
+	return files
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("GitHub API returned %s", resp.Status)
+// scopeDst names the copy of the .any.js file at dst that runs in scope,
+// the way WPT names single-scope tests: foo.js becomes foo.window.js for
+// window and foo.worker.js for dedicatedworker.
+func scopeDst(dst, scope string) string {
+	if scope == "dedicatedworker" {
+		scope = "worker"
 	}
+	ext := path.Ext(dst)
+	return strings.TrimSuffix(dst, ext) + "." + scope + ext
+}
+
+// useManifest reports whether files are discovered through MANIFEST.json.
+func (o *AddOptions) useManifest() bool {
+	return o.Manifest || len(o.Types) > 0
+}
 
-	var result struct {
-		SHA string `json:"sha"`
+// DefaultMaxFiles is the AddOptions.MaxFiles used when it is zero.
+const DefaultMaxFiles = 500
+
+// ErrTooManyFiles marks an add that would register more than
+// AddOptions.MaxFiles entries and was not confirmed.
+var ErrTooManyFiles = errors.New("too many files")
+
+// maxFiles returns the effective limit on new entries, or -1 for none.
+func (o *AddOptions) maxFiles() int {
+	switch {
+	case o.Yes || o.MaxFiles < 0:
+		return -1
+	case o.MaxFiles == 0:
+		return DefaultMaxFiles
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("decode response: %w", err)
+	return o.MaxFiles
+}
+
+// listFilesFoldingCase lists the files under *wptPath at commit, as
+// listFiles does. When *wptPath only exists in another case, it lists that
+// path instead and corrects *wptPath.
+func listFilesFoldingCase(ctx context.Context, gh *githubAPI, commit string, wptPath *string) ([]string, error) {
+	for {
+		listed, err := gh.listFiles(ctx, commit, *wptPath)
+		var notFound *pathNotFoundError
+		if !errors.As(err, &notFound) {
+			return listed, err
+		}
+		fix, ok := notFound.caseFix()
+		if !ok {
+			return nil, err
+		}
+		fmt.Printf("%s not found; using %s (WPT paths are case-sensitive)\n", *wptPath, fix)
+		// Each retry corrects one more path segment.
+		*wptPath = fix
 	}
+}
 
-	if result.SHA == "" {
-		return "", errors.New("empty commit SHA in response")
+// included reports whether p passes the include and exclude filters.
+func (o *AddOptions) included(p string) bool {
+	include := o.Include
+	switch {
+	case len(include) > 0:
+	case o.useManifest():
+		include = []string{"*"}
+	default:
+		include = []string{"*.js"}
+	}
+	if !slices.ContainsFunc(include, func(pattern string) bool { return matchFilter(pattern, p) }) {
+		return false
 	}
+	return !slices.ContainsFunc(o.Exclude, func(pattern string) bool { return matchFilter(pattern, p) })
+}
 
-	return result.SHA, nil
+func (o *AddOptions) validate() error {
+	for _, pattern := range slices.Concat(o.Include, o.Exclude) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return invalidConfig(fmt.Errorf("filter %q: %w", pattern, err))
+		}
+	}
+	return o.SyncOptions.validate()
 }
 
-// Add fetches the list of .js files under wptPath in the WPT repository (at
-// the commit pinned in configPath) and registers any not already tracked.
-func Add(ctx context.Context, configPath, wptPath string) error {
+// Add fetches the list of files under wptPath in the WPT repository (at the
+// commit pinned in configPath) that pass opts' include and exclude filters,
+// and registers any not already tracked, naming their dst with the config's
+// dst_script if it has one. A wptPath naming a single file is added
+// regardless of the filters, and one that only exists in another case is
+// corrected. With opts.Since, only files added upstream since then are
+// considered. With opts.Manifest or opts.Types, the files come from WPT's
+// MANIFEST.json instead, and can be filtered by test type. With
+// opts.WithDeps, the scripts the new files load with META directives are
+// added too.
+func Add(ctx context.Context, configPath, wptPath string, opts *AddOptions) error {
+	if opts == nil {
+		opts = &AddOptions{}
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	root, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return fmt.Errorf("determine repo root from config: %w", err)
+	}
+
 	cfg, err := LoadConfig(configPath)
 	if err != nil {
 		return err
 	}
+	addOpts := *opts
+	addOpts.SyncOptions = *opts.SyncOptions.forConfig(cfg)
+	opts = &addOpts
 
 	// Normalize the path: remove leading/trailing slashes
 	wptPath = strings.Trim(wptPath, "/")
 
-	fmt.Printf("Fetching file list from %s...\n", wptPath)
+	var manifest wptManifest
+	if opts.useManifest() {
+		base := opts.ManifestURL
+		if base == "" {
+			base = DefaultWPTFyiURL
+		}
+		fmt.Printf("Fetching MANIFEST.json at %s...\n", shortSHA(cfg.Commit))
+		// The manifest is tens of megabytes, so it gets a download's time.
+		manifestCtx, cancel := withTimeout(ctx, opts.timeouts().Download)
+		manifest, err = fetchManifest(manifestCtx, base, cfg.Commit, &opts.SyncOptions)
+		cancel()
+		if err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Fetching file list from %s...\n", wptPath)
+	}
 
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	ctx, cancel := withTimeout(ctx, opts.timeouts().Resolve)
 	defer cancel()
 
-	files, err := listFilesInPath(ctx, cfg.Commit, wptPath)
-	if err != nil {
+	gh := opts.github()
+	var listed []string
+	if manifest != nil {
+		listed = manifest.under(wptPath)
+	} else if listed, err = listFilesFoldingCase(ctx, gh, cfg.Commit, &wptPath); err != nil {
 		return fmt.Errorf("list files: %w", err)
 	}
 
-	if len(files) == 0 {
-		fmt.Printf("No .js files found in %s\n", wptPath)
+	// Directory listings come from the trees API, which walks a subtree one
+	// directory at a time when its recursive listing is truncated, so found
+	// is every file under the path.
+	found := len(listed)
+	isDir := found != 1 || listed[0] != wptPath
+	files := listed
+	if isDir {
+		files = slices.DeleteFunc(listed, func(p string) bool {
+			return !opts.included(p) || len(opts.Types) > 0 && !slices.Contains(opts.Types, manifest[p].Type)
+		})
+	}
+	dropped := 0
+	if opts.Suite != "" && isDir && opts.Since == "" {
+		dropped = cfg.dropFromSuite(opts.Suite, wptPath, files)
+	}
+	// finish saves the entries dropped when there are none to add.
+	finish := func() error {
+		if dropped == 0 {
+			return nil
+		}
+		if err := SaveConfig(configPath, cfg); err != nil {
+			return err
+		}
+		fmt.Printf("Dropped %d files from suite %s in %s\n", dropped, opts.Suite, configPath)
 		return nil
 	}
+	if opts.Since != "" {
+		fmt.Printf("Fetching files added since %s...\n", opts.Since)
+		added, err := gh.addedSince(ctx, opts.Since, cfg.Commit, wptPath, files)
+		if err != nil {
+			return fmt.Errorf("list added files: %w", err)
+		}
+		files = slices.DeleteFunc(files, func(p string) bool { return !added[p] })
+	}
+	if len(files) == 0 {
+		fmt.Printf("No matching files found in %s (%d files under it)\n", wptPath, found)
+		return finish()
+	}
+	switch {
+	case !isDir:
+	case len(files) != found:
+		fmt.Printf("Found %d files under %s, %d of them matching\n", found, wptPath, len(files))
+	default:
+		fmt.Printf("Found %d files under %s\n", found, wptPath)
+	}
+	if manifest != nil {
+		fmt.Printf("MANIFEST.json lists %s\n", manifest.summarize(files))
+	}
 
 	// Build a set of existing src paths for deduplication
 	existing := make(map[string]bool)
@@ -115,30 +338,96 @@ func Add(ctx context.Context, configPath, wptPath string) error {
 		existing[f.Src] = true
 	}
 
-	// Add new files
-	added := 0
+	var srcs []string
 	for _, src := range files {
-		if existing[src] {
-			continue
+		if !existing[src] {
+			srcs = append(srcs, src)
 		}
-
-		dst := src
-		// Strip .any.js suffix to .js
-		if base, ok := strings.CutSuffix(dst, ".any.js"); ok {
-			dst = base + ".js"
+	}
+	if dup := len(files) - len(srcs); dup > 0 {
+		fmt.Printf("%d of them are already in the config\n", dup)
+	}
+	if opts.Select != nil {
+		if srcs, err = selectByDir(wptPath, srcs, opts.Select); err != nil {
+			return err
+		}
+		if len(srcs) == 0 {
+			fmt.Println("No files selected")
+			return finish()
+		}
+	} else if limit := opts.maxFiles(); limit >= 0 && len(srcs) > limit {
+		fmt.Printf("%s has %d new files to add, more than the limit of %d:\n", wptPath, len(srcs), limit)
+		for _, line := range summarizeByDir(wptPath, srcs, 10) {
+			fmt.Printf("  %s\n", line)
+		}
+		if opts.Confirm == nil || !opts.Confirm(len(srcs)) {
+			return invalidConfig(fmt.Errorf("%w: %d new files under %s (limit %d); narrow the path or filters, raise -max-files, or pass -yes", ErrTooManyFiles, len(srcs), wptPath, limit))
+		}
+	}
+	dsts, err := cfg.nameFiles(ctx, root, srcs)
+	if err != nil {
+		return err
+	}
+	isDep := make(map[string]bool)
+	if opts.WithDeps && len(srcs) > 0 {
+		fmt.Printf("Reading the script dependencies of %d files...\n", len(srcs))
+		files := make([]FileSpec, len(srcs))
+		for i, src := range srcs {
+			files[i] = FileSpec{Src: src, Dst: dsts[i]}
+		}
+		deps, err := upstreamScriptDeps(ctx, cfg, files, &opts.SyncOptions)
+		if err != nil {
+			return fmt.Errorf("resolve script dependencies: %w", err)
+		}
+		if err := cfg.nameDeps(ctx, root, deps); err != nil {
+			return err
 		}
+		for _, dep := range deps {
+			srcs, dsts = append(srcs, dep.Src), append(dsts, dep.Dst)
+			isDep[dep.Src] = true
+		}
+	}
 
-		cfg.Files = append(cfg.Files, FileSpec{
-			Src: src,
-			Dst: dst,
-		})
-		added++
-		fmt.Printf(" + %s\n", src)
+	// Add new files
+	added := 0
+	for i, src := range srcs {
+		entries := []FileSpec{{Src: src, Dst: dsts[i]}}
+		if strings.HasSuffix(src, ".any.js") {
+			entries = opts.anyScopes(src, dsts[i])
+		}
+		for i := range entries {
+			entries[i].Suite = opts.Suite
+		}
+		note := ""
+		if isDep[src] {
+			note = " (script dependency)"
+		}
+		for _, entry := range entries {
+			cfg.Files = append(cfg.Files, entry)
+			added++
+			if len(entry.Scopes) > 0 {
+				note = " [" + strings.Join(entry.Scopes, ", ") + "]"
+			}
+			if entry.Dst != src {
+				fmt.Printf(" + %s -> %s%s\n", src, entry.Dst, note)
+			} else {
+				fmt.Printf(" + %s%s\n", src, note)
+			}
+		}
 	}
 
 	if added == 0 {
 		fmt.Println("No new files to add (all files already in config).")
-		return nil
+		return finish()
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	for _, w := range cfg.pathWarnings() {
+		fmt.Printf("warning: %s\n", w)
+	}
+	for _, w := range cfg.generatedWarnings(srcs) {
+		fmt.Printf("warning: %s\n", w)
 	}
 
 	if err := SaveConfig(configPath, cfg); err != nil {
@@ -146,111 +435,282 @@ func Add(ctx context.Context, configPath, wptPath string) error {
 	}
 
 	fmt.Printf("Added %d files to %s\n", added, configPath)
+	if dropped > 0 {
+		fmt.Printf("Dropped %d files from suite %s\n", dropped, opts.Suite)
+	}
 	return nil
 }
 
-type treeEntry struct {
-	Path string `json:"path"`
-	Type string `json:"type"`
-	SHA  string `json:"sha"`
+// dropFromSuite removes the entries of the suite name under dir whose src is
+// not among files, and returns how many it removed.
+func (c *Config) dropFromSuite(name, dir string, files []string) int {
+	keep := make(map[string]bool, len(files))
+	for _, src := range files {
+		keep[src] = true
+	}
+	n := len(c.Files)
+	c.Files = slices.DeleteFunc(c.Files, func(f FileSpec) bool {
+		if f.Suite != name || !strings.HasPrefix(f.Src, dir+"/") || keep[f.Src] {
+			return false
+		}
+		fmt.Printf(" - %s (no longer in suite %s)\n", f.Src, name)
+		return true
+	})
+	return n - len(c.Files)
 }
 
-type treeResponse struct {
-	Tree      []treeEntry `json:"tree"`
-	Truncated bool        `json:"truncated"`
+// selectByDir asks pick about srcs one subdirectory of dir at a time (see
+// groupByDir) and returns the files it keeps, in their original order.
+func selectByDir(dir string, srcs []string, pick func(dir string, srcs []string) ([]string, error)) ([]string, error) {
+	dirs, groups := groupByDir(dir, srcs)
+	keep := make(map[string]bool)
+	for _, d := range dirs {
+		picked, err := pick(d, groups[d])
+		if err != nil {
+			return nil, err
+		}
+		for _, src := range picked {
+			keep[src] = true
+		}
+	}
+	return slices.DeleteFunc(slices.Clone(srcs), func(src string) bool { return !keep[src] }), nil
 }
 
-func fetchTree(ctx context.Context, sha string, recursive bool) (*treeResponse, error) {
-	url := wptGitHubTreesAPI + "/" + sha
-	if recursive {
-		url += "?recursive=1"
+// groupByDir groups srcs by their first directory below dir, like
+// "css/css-grid/", and returns the directories sorted and the files of each.
+// Files directly in dir are grouped under dir itself.
+func groupByDir(dir string, srcs []string) ([]string, map[string][]string) {
+	groups := make(map[string][]string)
+	for _, src := range srcs {
+		rest := strings.TrimPrefix(src, dir+"/")
+		if dir == "" {
+			rest = src
+		}
+		key := dir + "/"
+		if sub, _, ok := strings.Cut(rest, "/"); ok {
+			key = path.Join(dir, sub) + "/"
+		}
+		groups[key] = append(groups[key], src)
 	}
+	dirs := slices.Sorted(maps.Keys(groups))
+	return dirs, groups
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
+// summarizeByDir counts srcs by their first directory below dir (see
+// groupByDir), largest first, and returns at most limit lines like
+// "1200  css/css-grid/".
+func summarizeByDir(dir string, srcs []string, limit int) []string {
+	_, groups := groupByDir(dir, srcs)
+	counts := make(map[string]int)
+	for d, files := range groups {
+		counts[d] = len(files)
+	}
+	dirs := slices.Collect(maps.Keys(counts))
+	slices.SortFunc(dirs, func(a, b string) int {
+		if c := counts[b] - counts[a]; c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+
+	var lines []string
+	for i, d := range dirs {
+		if i == limit {
+			rest := 0
+			for _, d := range dirs[i:] {
+				rest += counts[d]
+			}
+			lines = append(lines, fmt.Sprintf("%6d  in %d more directories", rest, len(dirs)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%6d  %s", counts[d], d))
 	}
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	return lines
+}
 
-	resp, err := http.DefaultClient.Do(req)
+// Remove drops every entry whose src or dst is wptPath, or whose src lies
+// under the folder wptPath, from the configuration at configPath. With purge
+// it also deletes the synced files under target_dir (and any directories
+// that leaves empty). Patch files are never deleted.
+func Remove(ctx context.Context, configPath, wptPath string, purge bool) error {
+	cfg, err := LoadConfig(configPath)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusForbidden {
-		return nil, errors.New("GitHub API returned 403 (rate limit likely exceeded, try again later)")
+	p := strings.Trim(wptPath, "/")
+	if p == "" {
+		return invalidConfig(errors.New("remove: path must not be empty"))
+	}
+
+	n, err := removeEntries(ctx, configPath, cfg, func(f FileSpec) bool {
+		return f.Src == p || f.Dst == p || strings.HasPrefix(f.Src, p+"/")
+	}, purge)
+	if err != nil {
+		return err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API returned %s", resp.Status)
+	if n == 0 {
+		return invalidConfig(fmt.Errorf("no config entry matches %q (compared against src, dst, and src folders)", p))
 	}
+	fmt.Printf("Removed %d files from %s\n", n, configPath)
+	return nil
+}
 
-	var tree treeResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tree); err != nil {
-		return nil, fmt.Errorf("decode response: %w", err)
+// removeEntries drops the entries of cfg that match from it and from the
+// lock file, saves it to configPath, and returns how many it dropped. With
+// purge it also deletes their synced files. Nothing is written when no entry
+// matches.
+func removeEntries(ctx context.Context, configPath string, cfg *Config, match func(FileSpec) bool, purge bool) (int, error) {
+	root, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return 0, fmt.Errorf("determine repo root from config: %w", err)
 	}
 
-	return &tree, nil
-}
+	var kept, removed []FileSpec
+	for _, f := range cfg.Files {
+		if match(f) {
+			removed = append(removed, f)
+		} else {
+			kept = append(kept, f)
+		}
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
 
-func listFilesInPath(ctx context.Context, commit, pathPrefix string) ([]string, error) {
-	// Walk the path segments to the subtree (or single blob), then list that
-	// subtree with one recursive request instead of one request per directory.
-	sha := commit
-	var segments []string
-	if pathPrefix != "" {
-		segments = strings.Split(pathPrefix, "/")
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		return 0, err
 	}
-	for i, segment := range segments {
-		tree, err := fetchTree(ctx, sha, false)
-		if err != nil {
-			return nil, err
+
+	// The files a removed glob matched are only known from the lock; those
+	// still listed explicitly stay.
+	keptSrcs := explicitSrcs(&Config{Files: kept})
+	var dsts []string
+	for _, f := range removed {
+		fmt.Printf(" - %s\n", f.Src)
+		if f.Patch != "" {
+			fmt.Printf("   kept patch %s\n", f.Patch)
+		}
+		if !isGlob(f.Src) {
+			dsts = append(dsts, f.Dst)
+			continue
 		}
-		var entry *treeEntry
-		for j := range tree.Tree {
-			if tree.Tree[j].Path == segment {
-				entry = &tree.Tree[j]
-				break
+		for dst, entry := range lock.Files {
+			if matchGlob(f.Src, entry.Src) && !keptSrcs[entry.Src] {
+				dsts = append(dsts, dst)
 			}
 		}
-		if entry == nil {
-			return nil, fmt.Errorf("path %q not found in repository", pathPrefix)
+	}
+
+	if purge {
+		if err := checkDstsOnDisk(root, withDsts(cfg, dsts)); err != nil {
+			return 0, err
 		}
-		if entry.Type == "blob" {
-			if i != len(segments)-1 {
-				return nil, fmt.Errorf("%q is a file, not a directory", strings.Join(segments[:i+1], "/"))
-			}
-			if strings.HasSuffix(pathPrefix, ".js") {
-				return []string{pathPrefix}, nil
-			}
-			return nil, nil
+	}
+	targetDir := filepath.Join(root, cfg.TargetDir)
+	for _, dst := range dsts {
+		if err := ctx.Err(); err != nil {
+			return 0, err
 		}
-		sha = entry.SHA
+		if !purge {
+			continue
+		}
+		dest := filepath.Join(targetDir, filepath.FromSlash(dst))
+		if err := os.Remove(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, fmt.Errorf("delete %s: %w", dest, err)
+		}
+		removeEmptyDirs(filepath.Dir(dest), targetDir)
 	}
 
-	tree, err := fetchTree(ctx, sha, true)
-	if err != nil {
-		return nil, err
+	cfg.Files = kept
+	if cfg.Files == nil {
+		cfg.Files = []FileSpec{}
+	}
+	if err := SaveConfig(configPath, cfg); err != nil {
+		return 0, err
+	}
+
+	// Drop the lock entries too, so dedupe and later syncs don't see them.
+	if len(lock.Files) > 0 {
+		for _, dst := range dsts {
+			delete(lock.Files, dst)
+		}
+		if err := saveLock(lockPath(configPath), lock, cfg.CompressState); err != nil {
+			return 0, err
+		}
 	}
-	if tree.Truncated {
-		return nil, fmt.Errorf("GitHub truncated the tree listing for %q; add a more specific path instead", pathPrefix)
+	if purge {
+		recordSynced(root, cfg, lock, nil, dsts, func(format string, args ...any) { fmt.Fprintf(os.Stderr, format, args...) })
 	}
+	return len(removed), nil
+}
 
-	var files []string
-	for _, entry := range tree.Tree {
-		if entry.Type == "blob" && strings.HasSuffix(entry.Path, ".js") {
-			files = append(files, path.Join(pathPrefix, entry.Path))
+// removeEmptyDirs deletes dir and its parents, stopping at stop (which is
+// kept) or at the first directory that is not empty.
+func removeEmptyDirs(dir, stop string) {
+	for dir != stop && strings.HasPrefix(dir, stop+string(filepath.Separator)) {
+		if os.Remove(dir) != nil {
+			return
 		}
+		dir = filepath.Dir(dir)
 	}
-	return files, nil
 }
 
-// Update bumps the pinned commit (to commit, or the latest WPT commit when
-// commit is empty) and re-syncs every enabled file. Patches that no longer
-// apply are reported at the end instead of aborting the run; the returned
-// error wraps ErrPatchFailed information in its message when any patches
-// failed.
-func Update(ctx context.Context, configPath, commit string) error {
+// UpdateOptions configures an Update run. A nil *UpdateOptions is
+// equivalent to its zero value.
+type UpdateOptions struct {
+	SyncOptions
+	// Commit is the commit to pin. Empty means the latest WPT commit.
+	Commit string
+	// Tag is a release tag to pin the commit of, such as "merge_pr_45678",
+	// or LatestRelease. It excludes Commit and SelectByResults, and is
+	// recorded as Config.Tag. Empty means LatestRelease when the
+	// configuration tracks releases.
+	Tag string
+	// NoSync rewrites the pinned commit without re-syncing any files.
+	NoSync bool
+	// SelectByResults, when set and Commit is empty, pins the newest commit
+	// whose wpt.fyi results meet the criteria instead of the latest commit.
+	SelectByResults *ResultCriteria
+	// Merge three-way merges files whose patch no longer applies, using the
+	// upstream file at the previous commit as the base. Clean merges get
+	// their patch regenerated; conflicts are written to the file with
+	// conflict markers. It needs git.
+	Merge bool
+	// Interactive implies Merge and, once every file is synced, opens each
+	// conflicted file in MergeTool in turn, regenerating its patch when the
+	// conflict is resolved. It needs a terminal.
+	Interactive bool
+	// MergeTool is a shell command resolving one conflict, run with $BASE,
+	// $LOCAL, $REMOTE, and $MERGED set as for git mergetool. Empty means
+	// $VISUAL or $EDITOR on $MERGED.
+	MergeTool string
+}
+
+// LatestRelease names the latest release as UpdateOptions.Tag.
+const LatestRelease = "latest"
+
+// Update bumps the pinned commit (to opts.Commit, or that of opts.Tag, or,
+// when both are empty, the commit selected by opts.SelectByResults or the
+// latest WPT commit) and
+// re-syncs every enabled file. Patches that no longer apply are reported at
+// the end instead of aborting the run (after opts.Merge had a go at them);
+// the returned error then wraps ErrPatchFailed.
+func Update(ctx context.Context, configPath string, opts *UpdateOptions) (err error) {
+	if opts == nil {
+		opts = &UpdateOptions{}
+	}
+	syncOpts := &opts.SyncOptions
+	if err := syncOpts.validate(); err != nil {
+		return err
+	}
+	if opts.Tag != "" && (opts.Commit != "" || opts.SelectByResults != nil) {
+		return invalidConfig(errors.New("a tag cannot be combined with a commit or selection by results"))
+	}
+	syncOpts = syncOpts.withStatsReport()
+	defer syncOpts.recordStats(configPath, "update", &err)
+
 	root, err := filepath.Abs(filepath.Dir(configPath))
 	if err != nil {
 		return fmt.Errorf("determine repo root from config: %w", err)
@@ -263,69 +723,378 @@ func Update(ctx context.Context, configPath, commit string) error {
 	if err := cfg.validate(); err != nil {
 		return err
 	}
+	syncOpts = syncOpts.forConfig(cfg)
+	if !syncOpts.DryRun {
+		syncOpts.fuzzLog = fuzzLogPath(lockPath(configPath))
+	}
+	report := syncOpts.Report
+	report.begin(cfg.Commit, syncOpts.DryRun)
+	defer report.finish()
 
+	commit, tag := opts.Commit, opts.Tag
+	if commit == "" && tag == "" && opts.SelectByResults == nil && cfg.TrackReleases {
+		tag = LatestRelease
+	}
+	if tag != "" {
+		if commit, tag, err = resolveTag(ctx, tag, syncOpts); err != nil {
+			return err
+		}
+	}
+	if commit == "" && opts.SelectByResults != nil {
+		syncOpts.logf("Selecting a commit by wpt.fyi results...\n")
+		commit, err = selectCommitByResults(ctx, cfg, opts.SelectByResults, syncOpts)
+		if err != nil {
+			return fmt.Errorf("select commit by results: %w", err)
+		}
+	}
 	if commit == "" {
-		fmt.Println("Fetching latest WPT commit...")
-		fetchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		syncOpts.logf("Fetching latest WPT commit...\n")
+		fetchCtx, cancel := withTimeout(ctx, syncOpts.timeouts().Resolve)
 		defer cancel()
-		commit, err = fetchLatestCommit(fetchCtx)
+		commit, err = syncOpts.github().latestCommit(fetchCtx)
 		if err != nil {
 			return fmt.Errorf("fetch latest commit: %w", err)
 		}
 	}
 
 	if commit == cfg.Commit {
-		fmt.Printf("Already at commit %s; nothing to update.\n", commit)
+		syncOpts.logf("Already at commit %s; nothing to update.\n", commit)
+		report.upToDate()
 		return nil
 	}
 
-	fmt.Printf("Updating commit %s -> %s\n", cfg.Commit, commit)
-	cfg.Commit = commit
+	syncOpts.logf("Updating commit %s -> %s\n", cfg.Commit, commit)
+	prevCommit := cfg.Commit
+	report.moved(prevCommit, commit)
+	cfg.Commit, cfg.Tag = commit, tag
 	// Save before syncing so an aborted run can resume with a plain `sync`.
 	if err := SaveConfig(configPath, cfg); err != nil {
 		return err
 	}
 
-	logf := func(format string, args ...any) { fmt.Printf(format, args...) }
+	if opts.NoSync {
+		syncOpts.logf("Pinned commit %s; run `wptsync sync` to download the files.\n", commit)
+		return nil
+	}
 
-	var failed []string
+	if cfg, err = expandGlobs(ctx, root, cfg, syncOpts); err != nil {
+		return err
+	}
+	if err := checkDstsOnDisk(root, cfg); err != nil {
+		return err
+	}
+
+	prevLock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		return err
+	}
+	var changed map[string]bool
+	if syncOpts.ChangedOnly {
+		if changed, err = upstreamChanges(ctx, cfg, prevLock, syncOpts); err != nil {
+			return err
+		}
+	}
+
+	// Files whose patch failed are left out of the lock so the next sync
+	// retries them.
+	lock := &lockFile{Commit: commit, Files: map[string]lockEntry{}}
+	var pending []FileSpec
 	for _, file := range cfg.Files {
 		if !file.IsEnabled() {
-			fmt.Printf(" - skipping %s (disabled)\n", file.Src)
+			syncOpts.logf(" - skipping %s (disabled)\n", file.Src)
+			report.skip(file, "disabled")
 			continue
 		}
-		err := processFile(ctx, root, cfg, file, false, false, DefaultBaseURL, logf)
-		if errors.Is(err, ErrPatchFailed) {
-			fmt.Fprintf(os.Stderr, "   %v\n", err)
-			failed = append(failed, file.Dst)
+		if file.Frozen {
+			entry, ok, err := frozenEntry(root, cfg, file)
+			if err != nil {
+				return err
+			}
+			if ok {
+				syncOpts.logf(" = %s (frozen)\n", file.Dst)
+				report.skip(file, "frozen")
+				lock.Files[file.Dst] = entry
+				continue
+			}
+		}
+		if file.Commit != "" && prevLock.isFresh(root, cfg, file) {
+			syncOpts.logf(" = %s (pinned at %s)\n", file.Dst, file.Commit)
+			report.skip(file, "pinned")
+			lock.Files[file.Dst] = prevLock.Files[file.Dst]
+			continue
+		}
+		if changed != nil && prevLock.canSkip(root, cfg, file, changed) {
+			syncOpts.logf(" = %s (unchanged upstream)\n", file.Dst)
+			report.skip(file, "unchanged upstream")
+			lock.Files[file.Dst] = prevLock.Files[file.Dst]
 			continue
 		}
+		pending = append(pending, file)
+	}
+
+	entries := make([]lockEntry, len(pending))
+	patchErrs := make([]error, len(pending))
+	fileErrs := make([]error, len(pending))
+	validateErrs := make([]error, len(pending))
+	merges := make([]*fileMerge, len(pending))
+	results := make([]FileResult, len(pending))
+	workerOpts, cleanup, err := stageFiles(ctx, root, cfg, pending, syncOpts)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if workerOpts, err = workerOpts.withBlobs(ctx, cfg, pending); err != nil {
+		return err
+	}
+	if workerOpts, err = workerOpts.withHarnessChecksums(ctx, root, cfg); err != nil {
+		return err
+	}
+
+	warnEdited(root, cfg, prevLock, pending, syncOpts.DryRun, syncOpts.logf)
+	if err := runHooks(ctx, root, cfg, "pre_sync", nil, syncOpts); err != nil {
+		return err
+	}
+
+	workerOpts = workerOpts.serialized()
+	progress := syncOpts.trackProgress(len(pending))
+	err = forEachFile(ctx, syncOpts.jobs(), len(pending), func(ctx context.Context, i int) error {
+		start := time.Now()
+		etag, err := processFile(ctx, root, cfg, pending[i], prevLock.conditionalETag(root, cfg, pending[i]), workerOpts)
+		if err == nil {
+			err = runHooks(ctx, root, cfg, "post_file", &pending[i], workerOpts)
+		}
+		// err ends up as the file's outcome once merging had its say.
+		defer func() {
+			results[i] = syncOpts.fileResult(root, cfg, pending[i], start, err)
+			results[i].Merged = merges[i] != nil
+			progress.done(results[i])
+		}()
+		if errors.Is(err, errNotModified) {
+			syncOpts.logf(" = %s (unchanged upstream)\n", pending[i].Dst)
+			entries[i] = prevLock.Files[pending[i].Dst]
+			return nil
+		}
+		if errors.Is(err, ErrPatchFailed) && (opts.Merge || opts.Interactive) {
+			m, mergeErr := mergePatch(ctx, root, cfg, pending[i], prevCommit, workerOpts)
+			switch {
+			case mergeErr != nil:
+				err = fmt.Errorf("%w; merge failed: %v", err, mergeErr)
+			case m.conflicted:
+				merges[i], err = m, fmt.Errorf("%w; merged with conflicts", err)
+			default:
+				merges[i], err = m, nil
+			}
+		}
+		if errors.Is(err, ErrPatchFailed) {
+			patchErrs[i] = err
+			return nil
+		}
+		if err != nil && syncOpts.KeepGoing && ctx.Err() == nil {
+			syncOpts.logf("   %s: %v\n", pending[i].Dst, err)
+			fileErrs[i] = err
+			return nil
+		}
 		if err != nil {
 			return err
 		}
+		validateErrs[i] = validateFile(ctx, root, cfg, pending[i], workerOpts)
+		entry, err := newLockEntry(root, cfg, pending[i])
+		entry.ETag = etag
+		entry.Blob = workerOpts.expectedBlob(cfg.commitOf(pending[i]), pending[i].Src)
+		entries[i] = entry
+		return err
+	})
+	if err != nil {
+		report.add(results...)
+		if done, perr := saveProgress(lockPath(configPath), lock, pending, entries, cfg.CompressState); perr == nil {
+			fmt.Fprintf(os.Stderr, "Synced %d of %d files before the failure; run `wptsync sync -continue` to resume.\n", done, len(pending))
+		}
+		return err
 	}
 
-	if len(failed) > 0 {
+	if opts.Interactive {
+		in := bufio.NewReader(os.Stdin)
+		for i, file := range pending {
+			if merges[i] == nil || !merges[i].conflicted {
+				continue
+			}
+			resolved, err := resolveConflict(ctx, root, cfg, file, merges[i], opts.MergeTool, in)
+			if err != nil {
+				return err
+			}
+			if !resolved {
+				continue
+			}
+			if entries[i], err = newLockEntry(root, cfg, file); err != nil {
+				return err
+			}
+			merges[i].conflicted, patchErrs[i] = false, nil
+			results[i] = syncOpts.fileResult(root, cfg, file, time.Now().Add(-results[i].Duration), nil)
+			results[i].Merged = true
+		}
+	}
+	report.add(results...)
+
+	var failed []string
+	var failures []FileFailure
+	var written []FileSpec
+	var validateResults []error
+	for i, file := range pending {
+		if fileErrs[i] != nil {
+			failures = append(failures, newFileFailure(file, fileErrs[i]))
+			continue
+		}
+		if patchErrs[i] != nil {
+			fmt.Fprintf(os.Stderr, "   %s: %v\n", file.Dst, patchErrs[i])
+			failed = append(failed, file.Dst)
+			continue
+		}
+		if entries[i].SHA256 == "" {
+			// Never processed; an empty entry would vouch for nothing.
+			continue
+		}
+		if merges[i] != nil {
+			syncOpts.logf("   %s: merged; regenerated %s\n", file.Dst, file.Patch)
+		}
+		lock.Files[file.Dst] = entries[i]
+		written = append(written, file)
+		validateResults = append(validateResults, validateErrs[i])
+	}
+
+	// Flagged files are left out of the lock like failed ones.
+	flagged, scanErr := syncOpts.scanSynced(root, cfg, written)
+	if scanErr != nil && !errors.Is(scanErr, ErrScanFindings) {
+		return scanErr
+	}
+	invalid, validateErr := syncOpts.validated(written, validateResults)
+	for dst := range flagged {
+		delete(lock.Files, dst)
+	}
+	for dst := range invalid {
+		delete(lock.Files, dst)
+	}
+
+	hookErr := runHooks(ctx, root, cfg, "post_sync", nil, syncOpts)
+	lock.retireFrom(lockPath(configPath), root, cfg)
+	report.changed(prevLock, lock)
+	if err := saveLock(lockPath(configPath), lock, cfg.CompressState); err != nil {
+		return err
+	}
+	recordSynced(root, cfg, lock, written, nil, syncOpts.logf)
+	writeNotices(root, cfg, lock, syncOpts.logf)
+	os.Remove(progressPath(lockPath(configPath)))
+	if cfg.Dedupe {
+		dedupeFiles(root, cfg, lock, syncOpts.logf)
+	}
+	if err := syncOpts.trimCache(); err != nil {
+		fmt.Fprintf(os.Stderr, "   warning: trim download cache: %v\n", err)
+	}
+
+	if len(failed) > 0 || len(failures) > 0 || len(flagged) > 0 || len(invalid) > 0 || hookErr != nil {
 		if err := os.Remove(stampPath(root, cfg)); err != nil && !errors.Is(err, os.ErrNotExist) {
 			fmt.Fprintf(os.Stderr, "   warning: remove stale freshness stamp: %v\n", err)
 		}
-		fmt.Fprintf(os.Stderr, "\nPatches that no longer apply (files left pristine):\n")
+	}
+	var errs []error
+	if len(failed) > 0 {
+		if opts.Merge || opts.Interactive {
+			fmt.Fprintf(os.Stderr, "\nPatches that no longer apply (conflict markers written where the merge ran):\n")
+		} else {
+			fmt.Fprintf(os.Stderr, "\nPatches that no longer apply (files left pristine):\n")
+		}
 		for _, dst := range failed {
 			fmt.Fprintf(os.Stderr, " - %s\n", dst)
 		}
-		return fmt.Errorf("%d patch(es) failed to apply; edit the file(s) and run `wptsync save <path>` to regenerate them", len(failed))
+		errs = append(errs, fmt.Errorf("%w: %d file(s); edit them and run `wptsync save <path>` to regenerate their patches", ErrPatchFailed, len(failed)))
+	}
+	if len(failures) > 0 {
+		errs = append(errs, syncOpts.failuresError(ctx, cfg, prevCommit, failures))
+	}
+	if scanErr != nil {
+		errs = append(errs, scanErr)
+	}
+	if validateErr != nil {
+		errs = append(errs, validateErr)
+	}
+	if hookErr != nil {
+		errs = append(errs, hookErr)
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
 	}
 
 	writeStamp(configPath, root, cfg)
 
-	fmt.Printf("Updated to commit %s\n", commit)
+	syncOpts.logf("Updated to commit %s\n", commit)
 	return nil
 }
 
+// resolveTag returns the commit of the release tag, or of the latest
+// release for LatestRelease, and the tag's name.
+func resolveTag(ctx context.Context, tag string, opts *SyncOptions) (commit, name string, err error) {
+	ctx, cancel := withTimeout(ctx, opts.timeouts().Resolve)
+	defer cancel()
+	gh := opts.github()
+	if tag == LatestRelease {
+		opts.logf("Fetching latest WPT release...\n")
+		if tag, err = gh.latestRelease(ctx); err != nil {
+			return "", "", err
+		}
+	}
+	commit, err = gh.resolveRef(ctx, tag)
+	var se *statusError
+	if errors.As(err, &se) && (se.code == http.StatusNotFound || se.code == http.StatusUnprocessableEntity) {
+		return "", "", invalidConfig(fmt.Errorf("tag %q not found upstream", tag))
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("resolve tag %s: %w", tag, err)
+	}
+	opts.logf("Tag %s is commit %s\n", tag, commit)
+	return commit, tag, nil
+}
+
+// CheckUpdate fetches the latest WPT commit and reports whether it differs
+// from the commit pinned in configPath, without modifying anything.
+func CheckUpdate(ctx context.Context, configPath string, opts *SyncOptions) (latest string, outdated bool, err error) {
+	if err := opts.validate(); err != nil {
+		return "", false, err
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return "", false, err
+	}
+	opts = opts.forConfig(cfg)
+
+	ctx, cancel := withTimeout(ctx, opts.timeouts().Resolve)
+	defer cancel()
+
+	latest, err = opts.github().latestCommit(ctx)
+	if err != nil {
+		return "", false, fmt.Errorf("fetch latest commit: %w", err)
+	}
+
+	return latest, latest != cfg.Commit, nil
+}
+
+// EditOptions configures an Edit run. A nil *EditOptions is equivalent to
+// its zero value.
+type EditOptions struct {
+	SyncOptions
+}
+
 // Edit re-downloads a single configured file at the pinned commit and
 // re-applies its patch, restoring it to its synced state so it is ready for
 // editing. filePath is matched against each entry's src or dst.
-func Edit(ctx context.Context, configPath, filePath string) error {
+func Edit(ctx context.Context, configPath, filePath string, editOpts *EditOptions) error {
+	if editOpts == nil {
+		editOpts = &EditOptions{}
+	}
+	opts := &editOpts.SyncOptions
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
 	root, err := filepath.Abs(filepath.Dir(configPath))
 	if err != nil {
 		return fmt.Errorf("determine repo root from config: %w", err)
@@ -338,28 +1107,82 @@ func Edit(ctx context.Context, configPath, filePath string) error {
 	if err := cfg.validate(); err != nil {
 		return err
 	}
+	opts = opts.forConfig(cfg)
 
-	file, err := findFileSpec(cfg, filePath)
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		return err
+	}
+	file, err := resolveFileSpec(cfg, lock, filePath)
 	if err != nil {
 		return err
 	}
 
-	logf := func(format string, args ...any) { fmt.Printf(format, args...) }
-	if err := processFile(ctx, root, cfg, *file, false, false, DefaultBaseURL, logf); err != nil {
+	if err := checkDstsOnDisk(root, withDsts(cfg, []string{file.Dst})); err != nil {
+		return err
+	}
+	if opts, err = opts.withHarnessChecksums(ctx, root, cfg); err != nil {
+		return err
+	}
+	etag, err := processFile(ctx, root, cfg, *file, "", opts)
+	if err != nil {
 		return err
 	}
 
+	// Keep the lock in step with the restored file so the next sync doesn't
+	// re-download it needlessly.
+	if lock.Commit == cfg.Commit {
+		if entry, err := newLockEntry(root, cfg, *file); err == nil {
+			entry.ETag = etag
+			lock.Files[file.Dst] = entry
+			if err := saveLock(lockPath(configPath), lock, cfg.CompressState); err != nil {
+				return err
+			}
+			recordSynced(root, cfg, lock, []FileSpec{*file}, nil, opts.logf)
+		}
+	}
+
 	dest := filepath.Join(root, cfg.TargetDir, filepath.FromSlash(file.Dst))
 	fmt.Printf("Restored %s to its synced state.\nEdit it, then run `wptsync save %s` to update its patch.\n", dest, file.Dst)
 	return nil
 }
 
+// SaveOptions configures a Save run. A nil *SaveOptions is equivalent to
+// its zero value.
+type SaveOptions struct {
+	SyncOptions
+}
+
 // Save downloads the pristine file at the pinned commit, diffs it against
 // the on-disk file at filePath, and writes the result to the file's patch
 // (default: patches/<dst>.patch), registering it in the configuration if
 // needed. If the file no longer differs from pristine, the patch is removed
 // instead. filePath is matched against each entry's src or dst.
-func Save(ctx context.Context, configPath, filePath string) error {
+func Save(ctx context.Context, configPath, filePath string, opts *SaveOptions) error {
+	if opts == nil {
+		opts = &SaveOptions{}
+	}
+	return savePatch(ctx, configPath, filePath, "", &opts.SyncOptions)
+}
+
+// Diff is Save with an explicit destination: the patch is written to output
+// (relative to the configuration's directory, like an entry's patch), which
+// becomes the file's patch in the configuration. With output "" or "-" the
+// patch is printed to standard output instead and nothing is written.
+func Diff(ctx context.Context, configPath, filePath, output string, opts *SyncOptions) error {
+	if output == "" {
+		output = "-"
+	}
+	return savePatch(ctx, configPath, filePath, output, opts)
+}
+
+// savePatch implements Save and Diff; output "" means the file's configured
+// or default patch path.
+func savePatch(ctx context.Context, configPath, filePath, output string, opts *SyncOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
 	root, err := filepath.Abs(filepath.Dir(configPath))
 	if err != nil {
 		return fmt.Errorf("determine repo root from config: %w", err)
@@ -372,8 +1195,13 @@ func Save(ctx context.Context, configPath, filePath string) error {
 	if err := cfg.validate(); err != nil {
 		return err
 	}
+	opts = opts.forConfig(cfg)
 
-	file, err := findFileSpec(cfg, filePath)
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		return err
+	}
+	file, err := resolveFileSpec(cfg, lock, filePath)
 	if err != nil {
 		return err
 	}
@@ -383,8 +1211,7 @@ func Save(ctx context.Context, configPath, filePath string) error {
 		return fmt.Errorf("%s not found on disk; run `wptsync sync` first", dest)
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
+	timeouts := opts.timeouts()
 
 	tmpDir, err := os.MkdirTemp("", "wptsync-save-")
 	if err != nil {
@@ -394,18 +1221,53 @@ func Save(ctx context.Context, configPath, filePath string) error {
 
 	pristine := filepath.Join(tmpDir, "pristine")
 	src := strings.TrimLeft(file.Src, "/")
-	url := fmt.Sprintf("%s/%s/%s", DefaultBaseURL, cfg.Commit, src)
-	if err := download(ctx, url, pristine); err != nil {
+	downloadCtx, cancel := withTimeout(ctx, timeouts.Download)
+	defer cancel()
+	if err := fetchFile(downloadCtx, cfg.commitOf(*file), src, pristine, opts); err != nil {
 		return fmt.Errorf("download pristine %s: %w", src, err)
 	}
+	// Patches apply to the transformed file.
+	if err := applyTransforms(pristine, *file); err != nil {
+		return fmt.Errorf("transform pristine %s: %w", src, err)
+	}
+
+	// Diff without the injected license and provenance headers so they never
+	// end up in the patch, which applies before the header is added.
+	local := dest
+	header, err := cfg.fileHeader(*file, dest)
+	if err != nil {
+		return err
+	}
+	if header != "" {
+		content, err := os.ReadFile(dest)
+		if err != nil {
+			return err
+		}
+		local = filepath.Join(tmpDir, "local")
+		if err := os.WriteFile(local, bytes.TrimPrefix(content, []byte(header)), 0o644); err != nil {
+			return fmt.Errorf("write temp file: %w", err)
+		}
+	}
 
-	diff, err := gitDiffNoIndex(ctx, pristine, dest)
+	diffCtx, cancel := withTimeout(ctx, timeouts.Patch)
+	defer cancel()
+	diff, err := gitDiffNoIndex(diffCtx, pristine, local)
 	if err != nil {
 		return err
 	}
+	rel := path.Join(cfg.TargetDir, file.Dst)
+	if output == "-" {
+		if len(diff) > 0 {
+			os.Stdout.Write(rewritePatchPaths(diff, rel))
+		}
+		return nil
+	}
 
 	patchRel := file.Patch
-	if patchRel == "" {
+	switch {
+	case output != "":
+		patchRel = filepath.ToSlash(output)
+	case patchRel == "":
 		patchRel = path.Join("patches", file.Dst+".patch")
 	}
 	patchAbs := patchRel
@@ -429,7 +1291,6 @@ func Save(ctx context.Context, configPath, filePath string) error {
 		return nil
 	}
 
-	rel := path.Join(cfg.TargetDir, file.Dst)
 	patched := rewritePatchPaths(diff, rel)
 
 	if err := os.MkdirAll(filepath.Dir(patchAbs), 0o755); err != nil {
@@ -439,7 +1300,10 @@ func Save(ctx context.Context, configPath, filePath string) error {
 		return fmt.Errorf("write patch: %w", err)
 	}
 
-	if file.Patch == "" {
+	if file.Patch != patchRel {
+		if file.Patch != "" {
+			fmt.Printf("note: %s is no longer referenced by %s\n", file.Patch, file.Dst)
+		}
 		file.Patch = patchRel
 		if err := SaveConfig(configPath, cfg); err != nil {
 			return err
@@ -456,6 +1320,7 @@ func gitDiffNoIndex(ctx context.Context, a, b string) ([]byte, error) {
 	// --no-ext-diff and --no-color keep the output a plain unified diff even
 	// when the user's git config sets an external diff tool or forced colors.
 	cmd := exec.CommandContext(ctx, "git", "diff", "--no-ext-diff", "--no-color", "--no-index", "--", a, b)
+	cmd.Env = subprocessEnv()
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = os.Stderr
@@ -1,6 +1,14 @@
 package wptsync
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -102,3 +110,427 @@ func TestFindFileSpec(t *testing.T) {
 		t.Error("findFileSpec did not return a pointer into cfg.Files")
 	}
 }
+
+func TestUpdateResyncsAtNewCommit(t *testing.T) {
+	content := map[string]string{
+		"/c1/a/foo.js": "content A v1\n",
+		"/c2/a/foo.js": "content A v2\n",
+	}
+	server, dir, _ := newFixture(t, content)
+
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a/foo.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	opts := &UpdateOptions{SyncOptions: SyncOptions{BaseURL: server.URL}, Commit: "c2"}
+	if err := Update(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	loaded, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if loaded.Commit != "c2" {
+		t.Errorf("commit = %q, want %q", loaded.Commit, "c2")
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "wpt", "a", "foo.js"))
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if string(got) != content["/c2/a/foo.js"] {
+		t.Errorf("content = %q, want %q", got, content["/c2/a/foo.js"])
+	}
+}
+
+func TestPinnedFileStaysAtItsCommit(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/a.js": "a v1\n",
+		"/c2/a.js": "a v2\n",
+		"/c0/b.js": "b v0\n",
+		"/c2/b.js": "b v2\n",
+	})
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a.js"}, {Src: "b.js", Commit: "c0"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, APIURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	report := &SyncReport{}
+	opts := &UpdateOptions{SyncOptions: SyncOptions{BaseURL: server.URL, APIURL: server.URL, Report: report}, Commit: "c2"}
+	if err := Update(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	for dst, want := range map[string]string{"a.js": "a v2\n", "b.js": "b v0\n"} {
+		if got, _ := os.ReadFile(filepath.Join(dir, "wpt", dst)); string(got) != want {
+			t.Errorf("%s = %q, want %q", dst, got, want)
+		}
+	}
+	if report.Summary.Downloaded != 1 || report.Summary.Skipped != 1 {
+		t.Errorf("summary = %+v, want the pinned file skipped", report.Summary)
+	}
+
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/commits/master":  `{"sha":"c2"}`,
+		"/repos/o/n/compare/c0...c2": `{"files":[{"filename":"b.js"}]}`,
+	})
+	status, err := Status(context.Background(), configPath, &SyncOptions{APIURL: apiURL})
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	want := []FileStatus{
+		{Src: "a.js", Dst: "a.js", Local: LocalClean, Upstream: UpstreamUnchanged},
+		{Src: "b.js", Dst: "b.js", Local: LocalClean, Upstream: UpstreamChanged, Pinned: "c0"},
+	}
+	if !slices.Equal(status.Files, want) {
+		t.Errorf("status files = %+v, want %+v", status.Files, want)
+	}
+
+	cfg.Files = append(cfg.Files, FileSpec{Src: "dir/*.js", Commit: "c0"})
+	if err := cfg.validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("validate with a pinned pattern = %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestUpdateNoSyncOnlyRewritesCommit(t *testing.T) {
+	server, dir, requestCount := newFixture(t, map[string]string{"/c2/a/foo.js": "content A\n"})
+
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a/foo.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	opts := &UpdateOptions{SyncOptions: SyncOptions{BaseURL: server.URL}, Commit: "c2", NoSync: true}
+	if err := Update(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	loaded, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if loaded.Commit != "c2" {
+		t.Errorf("commit = %q, want %q", loaded.Commit, "c2")
+	}
+	if requestCount() != 0 {
+		t.Errorf("NoSync: expected no downloads, got %d requests", requestCount())
+	}
+}
+
+func TestUpdatePinsReleaseTags(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/commits/merge_pr_100": `{"sha":"c2"}`,
+		"/repos/o/n/commits/merge_pr_101": `{"sha":"c3"}`,
+		"/repos/o/n/releases/latest":      `{"tag_name":"merge_pr_101"}`,
+	})
+	dir := t.TempDir()
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt"})
+	update := func(opts *UpdateOptions) *Config {
+		t.Helper()
+		opts.SyncOptions = SyncOptions{APIURL: apiURL}
+		opts.NoSync = true
+		if err := Update(context.Background(), configPath, opts); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		cfg, err := LoadConfig(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	if cfg := update(&UpdateOptions{Tag: "merge_pr_100"}); cfg.Commit != "c2" || cfg.Tag != "merge_pr_100" {
+		t.Errorf("after -tag merge_pr_100: commit %s, tag %q; want c2, merge_pr_100", cfg.Commit, cfg.Tag)
+	}
+	if cfg := update(&UpdateOptions{Tag: LatestRelease}); cfg.Commit != "c3" || cfg.Tag != "merge_pr_101" {
+		t.Errorf("after -tag latest: commit %s, tag %q; want c3, merge_pr_101", cfg.Commit, cfg.Tag)
+	}
+	if cfg := update(&UpdateOptions{Commit: "c1"}); cfg.Tag != "" {
+		t.Errorf("after -commit: tag %q, want it cleared", cfg.Tag)
+	}
+
+	cfg, _ := LoadConfig(configPath)
+	cfg.TrackReleases = true
+	saveTestConfig(t, dir, cfg)
+	if latest, outdated, err := CheckUpdate(context.Background(), configPath, &SyncOptions{APIURL: apiURL}); err != nil || latest != "c3" || !outdated {
+		t.Errorf("CheckUpdate = %s, %v, %v; want c3, outdated", latest, outdated, err)
+	}
+	if cfg := update(&UpdateOptions{}); cfg.Commit != "c3" || cfg.Tag != "merge_pr_101" {
+		t.Errorf("tracking releases: commit %s, tag %q; want c3, merge_pr_101", cfg.Commit, cfg.Tag)
+	}
+
+	err := Update(context.Background(), configPath, &UpdateOptions{SyncOptions: SyncOptions{APIURL: apiURL}, Tag: "merge_pr_1"})
+	if ExitCode(err) != ExitConfig {
+		t.Errorf("Update to a missing tag = %v, want a configuration error", err)
+	}
+}
+
+func TestInitAndSyncFollowConfiguredUpstream(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/commits/feature/x": `{"sha":"c9"}`,
+	})
+	server, dir, _ := newFixture(t, map[string]string{"/mirror/c9/a/foo.js": "mirrored\n"})
+	configPath := filepath.Join(dir, "wpt.json")
+
+	opts := &InitOptions{Repo: "o/n", Ref: "feature/x", RawBaseURL: server.URL + "/mirror", APIURL: apiURL}
+	if err := Init(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Commit != "c9" || cfg.Repo != "o/n" || cfg.Ref != "feature/x" || cfg.RawBaseURL != server.URL+"/mirror" {
+		t.Fatalf("config = %+v, want commit c9 from o/n feature/x via the mirror", cfg)
+	}
+
+	cfg.Files = []FileSpec{{Src: "a/foo.js"}}
+	saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, nil); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "wpt", "a", "foo.js"))
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if string(got) != "mirrored\n" {
+		t.Errorf("content = %q, want %q", got, "mirrored\n")
+	}
+}
+
+func TestAddStopsAboveMaxFiles(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1": `{"tree":[{"path":"css","type":"tree","sha":"t-css"}]}`,
+		"/repos/o/n/git/trees/t-css?recursive=1": `{"tree":[
+			{"path":"a.js","type":"blob"},
+			{"path":"grid/b.js","type":"blob"},
+			{"path":"grid/sub/c.js","type":"blob"},
+			{"path":"flex/d.js","type":"blob"}]}`,
+	})
+	dir := t.TempDir()
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt"})
+
+	var asked int
+	opts := &AddOptions{SyncOptions: SyncOptions{APIURL: apiURL}, MaxFiles: 3, Confirm: func(n int) bool {
+		asked = n
+		return false
+	}}
+	err := Add(context.Background(), configPath, "css/", opts)
+	if !errors.Is(err, ErrTooManyFiles) || ExitCode(err) != ExitConfig {
+		t.Fatalf("Add error = %v, want ErrTooManyFiles with ExitConfig", err)
+	}
+	if asked != 4 {
+		t.Errorf("Confirm asked about %d files, want 4", asked)
+	}
+	if cfg, _ := LoadConfig(configPath); len(cfg.Files) != 0 {
+		t.Errorf("refused add still registered %d files", len(cfg.Files))
+	}
+
+	opts.Yes = true
+	if err := Add(context.Background(), configPath, "css/", opts); err != nil {
+		t.Fatalf("Add with Yes: %v", err)
+	}
+	if cfg, _ := LoadConfig(configPath); len(cfg.Files) != 4 {
+		t.Errorf("Add with Yes registered %d files, want 4", len(cfg.Files))
+	}
+}
+
+func TestAddSelectByDir(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1": `{"tree":[{"path":"css","type":"tree","sha":"t-css"}]}`,
+		"/repos/o/n/git/trees/t-css?recursive=1": `{"tree":[
+			{"path":"a.js","type":"blob"},
+			{"path":"grid/b.js","type":"blob"},
+			{"path":"grid/sub/c.js","type":"blob"},
+			{"path":"flex/d.js","type":"blob"}]}`,
+	})
+	configPath := saveTestConfig(t, t.TempDir(), &Config{Commit: "c1", TargetDir: "wpt"})
+
+	var asked []string
+	opts := &AddOptions{SyncOptions: SyncOptions{APIURL: apiURL}, MaxFiles: 1, Select: func(dir string, srcs []string) ([]string, error) {
+		asked = append(asked, fmt.Sprintf("%s%v", dir, srcs))
+		if dir == "css/grid/" {
+			return srcs[1:], nil
+		}
+		if dir == "css/flex/" {
+			return nil, nil
+		}
+		return srcs, nil
+	}}
+	if err := Add(context.Background(), configPath, "css", opts); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	want := []string{"css/[css/a.js]", "css/flex/[css/flex/d.js]", "css/grid/[css/grid/b.js css/grid/sub/c.js]"}
+	if !slices.Equal(asked, want) {
+		t.Errorf("Select asked about %q, want %q", asked, want)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := []FileSpec{{Src: "css/a.js", Dst: "css/a.js"}, {Src: "css/grid/sub/c.js", Dst: "css/grid/sub/c.js"}}; !reflect.DeepEqual(cfg.Files, got) {
+		t.Errorf("files = %+v, want %+v", cfg.Files, got)
+	}
+
+	// Quitting leaves the configuration alone.
+	configPath = saveTestConfig(t, t.TempDir(), &Config{Commit: "c1", TargetDir: "wpt"})
+	quit := errors.New("quit")
+	opts.Select = func(dir string, srcs []string) ([]string, error) {
+		if dir == "css/grid/" {
+			return nil, quit
+		}
+		return srcs, nil
+	}
+	if err := Add(context.Background(), configPath, "css", opts); !errors.Is(err, quit) {
+		t.Fatalf("Add error = %v, want %v", err, quit)
+	}
+	if cfg, _ := LoadConfig(configPath); len(cfg.Files) != 0 {
+		t.Errorf("quit add still registered %d files", len(cfg.Files))
+	}
+}
+
+func TestAddSplitScopes(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1":                `{"tree":[{"path":"url","type":"tree","sha":"t-url"}]}`,
+		"/repos/o/n/git/trees/t-url?recursive=1": `{"tree":[{"path":"a.any.js","type":"blob"},{"path":"b.js","type":"blob"}]}`,
+	})
+	configPath := saveTestConfig(t, t.TempDir(), &Config{Commit: "c1", TargetDir: "wpt"})
+	opts := &AddOptions{SyncOptions: SyncOptions{APIURL: apiURL}, SplitScopes: true}
+	if err := Add(context.Background(), configPath, "url", opts); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FileSpec{
+		{Src: "url/a.any.js", Dst: "url/a.window.js", Scopes: []string{"window"}},
+		{Src: "url/a.any.js", Dst: "url/a.worker.js", Scopes: []string{"dedicatedworker"}},
+		{Src: "url/b.js", Dst: "url/b.js"},
+	}
+	if !reflect.DeepEqual(cfg.Files, want) {
+		t.Errorf("files = %+v, want %+v", cfg.Files, want)
+	}
+}
+
+func TestSummarizeByDir(t *testing.T) {
+	srcs := []string{"css/a.js", "css/grid/b.js", "css/grid/sub/c.js", "css/flex/d.js", "css/zoom/e.js"}
+	got := summarizeByDir("css", srcs, 3)
+	want := []string{
+		"     2  css/grid/",
+		"     1  css/",
+		"     1  css/flex/",
+		"     1  in 1 more directories",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("summarizeByDir = %q, want %q", got, want)
+	}
+}
+
+func TestRemoveFolderWithPurge(t *testing.T) {
+	content := map[string]string{
+		"/c1/url/a.any.js":        "a\n",
+		"/c1/url/sub/b.js":        "b\n",
+		"/c1/urlpattern/c.js":     "c\n",
+		"/c1/resources/helper.js": "h\n",
+	}
+	server, dir, _ := newFixture(t, content)
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files: []FileSpec{
+			{Src: "url/a.any.js", Dst: "url/a.js"},
+			{Src: "url/sub/b.js"},
+			{Src: "urlpattern/c.js"},
+			{Src: "resources/helper.js"},
+		},
+	})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if err := Remove(context.Background(), configPath, "url/", true); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range cfg.Files {
+		got = append(got, f.Src)
+	}
+	if want := []string{"urlpattern/c.js", "resources/helper.js"}; !slices.Equal(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wpt", "url")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("wpt/url still exists after purge: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wpt", "urlpattern", "c.js")); err != nil {
+		t.Errorf("unrelated file was deleted: %v", err)
+	}
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify after remove: %v", err)
+	}
+
+	// An exact dst match removes a single entry and leaves the file alone.
+	if err := Remove(context.Background(), configPath, "resources/helper.js", false); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wpt", "resources", "helper.js")); err != nil {
+		t.Errorf("file deleted without -purge: %v", err)
+	}
+	if err := Remove(context.Background(), configPath, "nope/", false); err == nil {
+		t.Error("Remove of an untracked path succeeded")
+	}
+}
+
+func TestDiffWritesChosenPatchAndRegistersIt(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not on PATH")
+	}
+
+	server, dir, _ := newFixture(t, map[string]string{"/c1/url/a.js": "one\ntwo\n"})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "url/a.js"}}})
+	opts := &SyncOptions{BaseURL: server.URL}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	dest := filepath.Join(dir, "wpt", "url", "a.js")
+	if err := os.WriteFile(dest, []byte("one\n2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Diff(context.Background(), configPath, "url/a.js", "custom/a.patch", opts); err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	patch, err := os.ReadFile(filepath.Join(dir, "custom", "a.patch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(patch), "+++ b/wpt/url/a.js") || !strings.Contains(string(patch), "+2") {
+		t.Errorf("patch = %q, want a diff of wpt/url/a.js", patch)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Files[0].Patch != "custom/a.patch" {
+		t.Errorf("patch = %q, want custom/a.patch", cfg.Files[0].Patch)
+	}
+
+	// The patch reproduces the edit on a fresh sync.
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Force: true}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "one\n2\n" {
+		t.Errorf("resynced file = %q, want the edit reapplied", got)
+	}
+}
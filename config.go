@@ -5,24 +5,191 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
 
+// DefaultRepo is the upstream repository ("owner/name") synced from when the
+// configuration names none.
+const DefaultRepo = "web-platform-tests/wpt"
+
+// DefaultRef is the branch init and update follow when the configuration
+// names none.
+const DefaultRef = "master"
+
 // Config is the on-disk wpt.json configuration: the pinned WPT commit, the
 // local directory files are synced into, and the list of tracked files.
 type Config struct {
-	Commit    string     `json:"commit"`
-	TargetDir string     `json:"target_dir"`
+	// Schema is the optional "$schema" reference editors use to find the
+	// JSON Schema emitted by `wptsync schema`.
+	Schema string `json:"$schema,omitempty"`
+	// Repo is the upstream GitHub repository as "owner/name", for syncing
+	// from a fork or mirror. Empty means DefaultRepo.
+	Repo string `json:"repo,omitempty"`
+	// Ref is the branch or tag init and update resolve to a commit. Empty
+	// means DefaultRef.
+	Ref string `json:"ref,omitempty"`
+	// TrackReleases makes update, and the commands that compare the pin
+	// with the latest commit, follow the latest release of Repo (a
+	// harness-compatible snapshot, which WPT tags merge_pr_<number>)
+	// instead of the head of Ref.
+	TrackReleases bool `json:"track_releases,omitempty"`
+	// Tag is the release tag update pinned Commit from, for reference. It
+	// is cleared when Commit is pinned any other way.
+	Tag string `json:"tag,omitempty"`
+	// RawBaseURL replaces the raw file host (files are fetched from
+	// <raw_base_url>/<commit>/<src>), for example a GitHub Enterprise
+	// mirror. Empty means raw.githubusercontent.com for Repo.
+	RawBaseURL string `json:"raw_base_url,omitempty"`
+	// APIURL is the REST API base for Repo, for GitHub Enterprise
+	// (https://host/api/v3/repos/owner/name). Empty means api.github.com.
+	APIURL    string     `json:"api_url,omitempty"`
+	Commit    string     `json:"commit" wptsync:"required"`
+	TargetDir string     `json:"target_dir" wptsync:"required"`
 	Files     []FileSpec `json:"files"`
+	// Dedupe hard-links byte-identical synced files to a single inode.
+	Dedupe bool `json:"dedupe,omitempty"`
+	// CompressState writes the lock file and the state file compressed,
+	// with a checksum that detects corruption. Plain lock files are still
+	// read, and converted on the next sync.
+	CompressState bool `json:"compress_state,omitempty"`
+	// LicenseHeaders maps a dst extension (".js") to a text/template that
+	// is prepended to every synced file with that extension. Templates see
+	// .Src, .Dst, and .Commit.
+	LicenseHeaders map[string]string `json:"license_headers,omitempty"`
+	// DstScript is an executable (relative to the config's directory) that
+	// computes dst paths for files registered by add, replacing the
+	// built-in .any.js -> .js mapping. See Config.nameFiles.
+	DstScript string `json:"dst_script,omitempty"`
+	// DstScriptEnv sets environment variables for DstScript, on top of the
+	// isolated environment it runs in (see subprocessEnv). Values may refer
+	// to wptsync's own environment as ${VAR}, expanded when the script runs,
+	// to pass it a credential explicitly.
+	DstScriptEnv map[string]string `json:"dst_script_env,omitempty"`
+	// GeneratedAreas replaces DefaultGeneratedAreas as the parts of WPT add
+	// warns about vendoring because a script generates them.
+	GeneratedAreas []GeneratedArea `json:"generated_areas,omitempty"`
+	// WarnGenerated, when explicitly false, turns those warnings off.
+	WarnGenerated *bool `json:"warn_generated,omitempty"`
+	// CheckDirty makes sync refuse to overwrite synced files with
+	// uncommitted changes, as SyncOptions.CheckDirty does.
+	CheckDirty bool `json:"check_dirty,omitempty"`
+	// ProvenanceHeaders puts a comment recording each synced file's source,
+	// commit, and sync date at its top, after any license header. See
+	// Config.provenanceHeader.
+	ProvenanceHeaders bool `json:"provenance_headers,omitempty"`
+	// Source, when set, is where files come from instead of the network:
+	// "bundle:<path>" names a tarball of the repository at Commit, such as
+	// GitHub's archive of the commit, and "git:<path>" a clone of the
+	// repository (bare or not) holding Commit, relative to the
+	// configuration's directory. SyncOptions.Source overrides it.
+	Source string `json:"source,omitempty"`
+	// Groups are further sets of files, each synced into a target
+	// directory of its own when named with SyncOptions.Group.
+	Groups []SyncGroup `json:"groups,omitempty"`
+	// Suites are named parts of WPT, such as url or encoding, whose files
+	// the suite commands add, update, and remove together. See Suite.
+	Suites []Suite `json:"suites,omitempty"`
+	// GroupDuplicates says what to make of a src that more than one group,
+	// or a group and the top-level files, list: GroupDuplicatesAllow it
+	// (the default), GroupDuplicatesWarn about it when syncing either, or
+	// reject the configuration with GroupDuplicatesError.
+	GroupDuplicates string `json:"group_duplicates,omitempty"`
+	// PatchFuzz loosens how the built-in applier matches hunks that
+	// drifted. Nil allows the defaults: any offset and two context lines.
+	PatchFuzz *PatchFuzz `json:"patch_fuzz,omitempty"`
+	// Scan, when set, has sync and update scan the files they write for
+	// denied licenses, credentials, and URLs, per the policy. See Scan.
+	Scan *ScanPolicy `json:"scan,omitempty"`
+	// Mapping names the dst of the entries that leave it out: each rule,
+	// in order, rewrites the path starting from the src. Without rules such
+	// an entry mirrors its src. With rules, add and other commands that
+	// write the configuration leave out the dsts the rules give anyway.
+	Mapping []DstRule `json:"mapping,omitempty"`
+	// UpstreamDocs, when set, also syncs the README.md, OWNERS, and
+	// META.yml files of the upstream directories files come from into a
+	// folder of target_dir. See UpstreamDocs.
+	UpstreamDocs *UpstreamDocs `json:"upstream_docs,omitempty"`
+	// Hooks are commands run before and after a sync, and after each file
+	// it writes. See Hooks.
+	Hooks *Hooks `json:"hooks,omitempty"`
+	// HarnessChecksums, when set, has the harness files checked against
+	// an allowlist of hashes before they are written. See
+	// HarnessChecksums.
+	HarnessChecksums *HarnessChecksums `json:"harness_checksums,omitempty"`
+	// Network, when set, configures a proxy, CA certificates, extra
+	// headers, and a request timeout. See Network.
+	Network *Network `json:"network,omitempty"`
+	// Notices, when set, also syncs the upstream LICENSE.md into
+	// target_dir and writes a list of the synced files and their commits
+	// next to it. See Notices.
+	Notices *Notices `json:"notices,omitempty"`
+	// VariantNames, when set, has runner-config name a file for each
+	// variant of a test. See VariantNames.
+	VariantNames *VariantNames `json:"variant_names,omitempty"`
+	// Validators run a command on each file sync and update write that
+	// they match, such as a syntax check. See Validator.
+	Validators []Validator `json:"validators,omitempty"`
+
+	// interpolated holds the fields LoadConfig expanded ${VAR} references
+	// in; see Config.expandEnv.
+	interpolated map[string]interpolated
+	// dir is the directory LoadConfig read the configuration from, which
+	// Network's CABundle is relative to.
+	dir string
+	// groupWarnings describes the srcs this set of files shares with other
+	// groups, when GroupDuplicates warns about them; see loadGroup.
+	groupWarnings []string
 }
 
-// FileSpec describes a single file tracked from the WPT repository.
+// FileSpec describes a single file tracked from the WPT repository, or, when
+// Src is a path.Match pattern such as "url/resources/*.json", every file it
+// matches at the pinned commit. A pattern's Dst, if set, is the directory
+// its matches are placed in; pattern entries cannot have a Patch or Commit.
 type FileSpec struct {
-	Src     string `json:"src"`
-	Dst     string `json:"dst"`
+	Src     string `json:"src" wptsync:"required"`
+	Dst     string `json:"dst,omitempty"`
 	Enabled *bool  `json:"enabled,omitempty"`
 	Patch   string `json:"patch,omitempty"`
+	// Commit holds this file at another commit than the configuration's,
+	// for example before an upstream regression. update leaves it alone.
+	Commit string `json:"commit,omitempty"`
+	// Scopes lists the globals the test runs in (window, dedicatedworker,
+	// sharedworker, ...), overriding its META global directives for
+	// runner-config. An .any.js file can be split into one entry per scope,
+	// each with its own dst.
+	Scopes []string `json:"scopes,omitempty"`
+	// Variants lists the query strings ("?1-10") or fragments the test runs
+	// with, overriding its META variant directives for runner-config.
+	Variants []string `json:"variants,omitempty"`
+	// Transforms rewrite the file after download, before Patch applies to
+	// the result. A pattern entry's transforms apply to every match.
+	Transforms []Transform `json:"transforms,omitempty"`
+	// Provenance, when set, overrides the configuration's
+	// ProvenanceHeaders for this file.
+	Provenance *bool `json:"provenance,omitempty"`
+	// Frozen keeps the file as it is on disk, local edits included: sync
+	// and update only download it when it is missing, and prune keeps it.
+	// Unlike a disabled file it stays in the vendored set, for manifests
+	// and runner-config.
+	Frozen bool `json:"frozen,omitempty"`
+	// Suite names the suite (see Config.Suites) the entry was added for,
+	// which the suite commands keep in step with upstream.
+	Suite string `json:"suite,omitempty"`
+	// ValidateCmd is a command checking the file once written and
+	// patched, such as "node --check {{dst}}", in place of the matching
+	// Validators. A pattern entry's applies to every match.
+	ValidateCmd string `json:"validate_cmd,omitempty"`
+}
+
+// commitOf returns the commit file is synced at: its own pin, if it has one,
+// otherwise the configuration's.
+func (c *Config) commitOf(file FileSpec) string {
+	if file.Commit != "" {
+		return file.Commit
+	}
+	return c.Commit
 }
 
 // IsEnabled reports whether the file should be synced. Files are enabled by
@@ -31,35 +198,76 @@ func (f FileSpec) IsEnabled() bool {
 	return f.Enabled == nil || *f.Enabled
 }
 
-// LoadConfig reads and decodes the configuration file at path. Any FileSpec
-// with an empty Dst is normalized to use Src as its destination.
+// LoadConfig reads and decodes the configuration file at path, which is
+// JSON unless its extension says YAML or TOML (see configFormat), rejecting
+// keys the format doesn't know, which are typically typos. Any FileSpec
+// with an empty Dst is normalized to use Src as its destination, and ${VAR}
+// references in target_dir, the upstream settings, and patch paths are
+// expanded from the environment (see Config.expandEnv).
 func LoadConfig(path string) (*Config, error) {
-	file, err := os.Open(path)
+	data, lines, err := readConfig(path)
+	var syntax *configSyntaxError
+	if errors.As(err, &syntax) {
+		return nil, invalidConfig(fmt.Errorf("decode config %q: %w", path, err))
+	}
 	if err != nil {
-		return nil, fmt.Errorf("open config %q: %w", path, err)
+		return nil, invalidConfig(fmt.Errorf("open config %q: %w", path, err))
 	}
-	defer file.Close()
 
 	var cfg Config
-	if err := json.NewDecoder(file).Decode(&cfg); err != nil {
-		return nil, fmt.Errorf("decode config %q: %w", path, err)
+	if err := decodeConfig(data, lines, &cfg); err != nil {
+		return nil, invalidConfig(fmt.Errorf("decode config %q: %w", path, err))
 	}
-
-	for i := range cfg.Files {
-		if cfg.Files[i].Dst == "" {
-			cfg.Files[i].Dst = cfg.Files[i].Src
-		}
+	if err := cfg.normalize(); err != nil {
+		return nil, invalidConfig(fmt.Errorf("config %q: %w", path, err))
 	}
+	cfg.dir = filepath.Dir(path)
 
 	return &cfg, nil
 }
 
-// SaveConfig writes cfg to path as indented JSON.
+// normalize gives each FileSpec with an empty Dst the destination Mapping
+// names, its Src without rules, and expands ${VAR} references (see
+// Config.expandEnv). Patterns keep their Src as Dst, which expandGlobs then
+// names each match by.
+func (c *Config) normalize() error {
+	dst := func(src string) string {
+		if isGlob(src) {
+			return src
+		}
+		return c.mappedDst(src)
+	}
+	for i := range c.Files {
+		if c.Files[i].Dst == "" {
+			c.Files[i].Dst = dst(c.Files[i].Src)
+		}
+	}
+	for i := range c.Groups {
+		for j, f := range c.Groups[i].Files {
+			if f.Dst == "" {
+				c.Groups[i].Files[j].Dst = dst(f.Src)
+			}
+		}
+	}
+	return c.expandEnv()
+}
+
+// SaveConfig writes cfg to path as indented JSON, or as YAML or TOML when
+// path's extension says so, keeping the comments of the file it replaces.
+// Values LoadConfig expanded ${VAR} references in are written back as the
+// references, unless they were changed since, and dsts Mapping names anyway
+// are left out.
 func SaveConfig(path string, cfg *Config) error {
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	data, err := json.MarshalIndent(cfg.unexpanded().withoutMappedDsts(), "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal config: %w", err)
 	}
+	if format := configFormat(path); format != formatJSON {
+		prev, _ := os.ReadFile(path)
+		if data, err = encodeConfig(data, format, prev); err != nil {
+			return fmt.Errorf("marshal config: %w", err)
+		}
+	}
 
 	if err := os.WriteFile(path, data, 0o644); err != nil {
 		return fmt.Errorf("write config: %w", err)
@@ -68,27 +276,139 @@ func SaveConfig(path string, cfg *Config) error {
 	return nil
 }
 
+// validate reports the first problem with c as an ErrInvalidConfig.
 func (c *Config) validate() error {
+	return invalidConfig(c.check())
+}
+
+func (c *Config) check() error {
 	if c.Commit == "" {
 		return errors.New("config: commit hash must be provided")
 	}
 	if c.TargetDir == "" {
 		return errors.New("config: target_dir must be provided")
 	}
-	seen := make(map[string]string, len(c.Files))
+	if err := checkSource(c.Source); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if err := c.patchFuzz().check(); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if c.Scan != nil {
+		if _, err := c.Scan.compile(); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	if c.UpstreamDocs != nil {
+		if err := c.UpstreamDocs.check(); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	if c.Network != nil {
+		if err := c.Network.check(); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	if c.Notices != nil {
+		if err := c.Notices.check(); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	if c.VariantNames != nil {
+		if err := c.VariantNames.check(); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	if c.HarnessChecksums != nil {
+		if err := c.HarnessChecksums.check(); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	if c.Hooks != nil {
+		if err := c.Hooks.check(); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	for i, r := range c.Mapping {
+		if err := r.check(); err != nil {
+			return fmt.Errorf("config: mapping[%d]: %w", i, err)
+		}
+	}
+	for _, v := range c.Validators {
+		if err := v.check(); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	if c.Repo != "" {
+		owner, name, ok := strings.Cut(c.Repo, "/")
+		if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("config: repo %q must be of the form owner/name", c.Repo)
+		}
+	}
 	for _, f := range c.Files {
 		if f.Src == "" {
 			return fmt.Errorf("config: file entries must set src (src=%q)", f.Src)
 		}
-		if !filepath.IsLocal(filepath.FromSlash(f.Dst)) {
-			return fmt.Errorf("config: dst %q escapes the target directory", f.Dst)
+	}
+	if problems := dstProblems(c.Files); len(problems) > 0 {
+		return fmt.Errorf("config: %s", strings.Join(problems, "; "))
+	}
+	for _, f := range c.Files {
+		if isGlob(f.Src) {
+			if _, err := path.Match(f.Src, ""); err != nil {
+				return fmt.Errorf("config: src pattern %q: %w", f.Src, err)
+			}
+			if f.Patch != "" {
+				return fmt.Errorf("config: src pattern %q cannot have a patch; list the file explicitly", f.Src)
+			}
+			if f.Commit != "" {
+				return fmt.Errorf("config: src pattern %q cannot have a commit; list the file explicitly", f.Src)
+			}
+			if f.Frozen {
+				return fmt.Errorf("config: src pattern %q cannot be frozen; list the file explicitly", f.Src)
+			}
+			if len(f.Scopes) > 0 || len(f.Variants) > 0 {
+				return fmt.Errorf("config: src pattern %q cannot have scopes or variants; list the file explicitly", f.Src)
+			}
+		}
+		for _, scope := range f.Scopes {
+			if scope == "" || strings.ContainsAny(scope, ", \t") {
+				return fmt.Errorf("config: %s: scope %q must be a single global name such as \"window\"", f.Src, scope)
+			}
+		}
+		for i, t := range f.Transforms {
+			if _, err := t.compile(); err != nil {
+				return fmt.Errorf("config: %s: transform %d: %w", f.Src, i+1, err)
+			}
+		}
+		for _, v := range f.Variants {
+			if !strings.HasPrefix(v, "?") && !strings.HasPrefix(v, "#") {
+				return fmt.Errorf("config: %s: variant %q must start with \"?\" or \"#\"", f.Src, v)
+			}
 		}
-		if prev, ok := seen[f.Dst]; ok {
-			return fmt.Errorf("config: dst %q used by both %q and %q", f.Dst, prev, f.Src)
+		if f.ValidateCmd != "" {
+			if err := checkValidateCmd(f.ValidateCmd); err != nil {
+				return fmt.Errorf("config: %s: %w", f.Src, err)
+			}
 		}
-		seen[f.Dst] = f.Src
 	}
-	return nil
+	for name := range c.DstScriptEnv {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("config: dst_script_env: invalid variable name %q", name)
+		}
+	}
+	for ext := range c.LicenseHeaders {
+		if !strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("config: license_headers key %q must be an extension such as \".js\"", ext)
+		}
+		if _, err := c.licenseHeader(FileSpec{Dst: "x" + ext}); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	if err := c.checkSuites(); err != nil {
+		return err
+	}
+	return c.checkGroups()
 }
 
 // findFileSpec returns a pointer into cfg.Files for the entry whose Src or
@@ -100,5 +420,5 @@ func findFileSpec(cfg *Config, filePath string) (*FileSpec, error) {
 			return &cfg.Files[i], nil
 		}
 	}
-	return nil, fmt.Errorf("no config entry matches %q (compared against src and dst)", p)
+	return nil, invalidConfig(fmt.Errorf("no config entry matches %q (compared against src and dst)", p))
 }
@@ -0,0 +1,337 @@
+package wptsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Configuration files are JSON unless their extension says YAML (.yaml,
+// .yml) or TOML (.toml). Both are read by converting them to the JSON the
+// rest of the package decodes, keeping track of the lines things came from,
+// so that errors point into the file as written.
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// configFormat returns the format of the configuration file at path, by its
+// extension.
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	}
+	return formatJSON
+}
+
+// readConfig reads the configuration file at path as JSON, converting it
+// from YAML or TOML if need be. lines then maps each line of the JSON
+// (lines[0] being the first) to the line of the file it came from; it is
+// nil for JSON files.
+func readConfig(path string) (data []byte, lines []int, err error) {
+	return readConfigAs(path, configFormat(path))
+}
+
+// readConfigAs is readConfig for a file in format whatever its name, such
+// as the temporary files git hands merge drivers.
+func readConfigAs(path, format string) (data []byte, lines []int, err error) {
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var root *cfgNode
+	switch format {
+	case formatYAML:
+		root, err = parseYAML(data)
+	case formatTOML:
+		root, err = parseTOML(data)
+	default:
+		return data, nil, nil
+	}
+	if err != nil {
+		return nil, nil, &configSyntaxError{err: err}
+	}
+	w := &jsonWriter{lines: []int{root.line}}
+	w.value(root, reflect.TypeFor[Config]())
+	return w.buf.Bytes(), w.lines, nil
+}
+
+// configSyntaxError is a YAML or TOML file that could not be parsed.
+type configSyntaxError struct{ err error }
+
+func (e *configSyntaxError) Error() string { return e.err.Error() }
+func (e *configSyntaxError) Unwrap() error { return e.err }
+
+// problem returns the error as a ConfigProblem, on the line its message
+// starts with.
+func (e *configSyntaxError) problem() ConfigProblem {
+	msg := e.Error()
+	var line int
+	if _, err := fmt.Sscanf(msg, "line %d:", &line); err == nil {
+		_, msg, _ = strings.Cut(msg, ": ")
+	}
+	return ConfigProblem{Line: line, Message: msg}
+}
+
+// encodeConfig converts data, a configuration as indented JSON, to format,
+// keeping the comments of prev, the file it replaces, where they still
+// apply.
+func encodeConfig(data []byte, format string, prev []byte) ([]byte, error) {
+	root, err := jsonToNode(data)
+	if err != nil {
+		return nil, err
+	}
+	comments := make(map[string]*cfgNode)
+	if len(prev) > 0 {
+		var old *cfgNode
+		switch format {
+		case formatYAML:
+			old, err = parseYAML(prev)
+		case formatTOML:
+			old, err = parseTOML(prev)
+		}
+		if err == nil {
+			old.collectComments("", comments)
+		}
+	}
+	if format == formatTOML {
+		return emitTOML(root, comments), nil
+	}
+	return emitYAML(root, comments), nil
+}
+
+// cfgNode is a value of a YAML or TOML configuration: a scalar, a mapping
+// with its keys in order, or a list.
+type cfgNode struct {
+	kind nodeKind
+	// value is a scalar's text. plain is set when it was not quoted, so
+	// that it is a number, boolean, or null when the field it decodes into
+	// is not a string (see jsonWriter.scalar).
+	value string
+	plain bool
+	// keys and vals are a mapping's entries, in order; vals also holds a
+	// list's items.
+	keys []string
+	vals []*cfgNode
+	// line is the line the value starts on: that of its key, or of its
+	// "-" in a YAML list.
+	line int
+	// comments are the comment lines right above the value's key (or list
+	// item), and inline the comment at the end of its line, if any; both
+	// without their "#". For the root, comments are those at the top of the
+	// file and inline those at its end, joined by newlines.
+	comments []string
+	inline   string
+}
+
+type nodeKind int
+
+const (
+	scalarNode nodeKind = iota
+	mapNode
+	listNode
+)
+
+// get returns the value of key in mapping n, or nil.
+func (n *cfgNode) get(key string) *cfgNode {
+	for i, k := range n.keys {
+		if k == key {
+			return n.vals[i]
+		}
+	}
+	return nil
+}
+
+// set adds key with value v to mapping n, which must not have it yet.
+func (n *cfgNode) set(key string, v *cfgNode) error {
+	if n.get(key) != nil {
+		return fmt.Errorf("line %d: duplicate key %q", v.line, key)
+	}
+	n.keys = append(n.keys, key)
+	n.vals = append(n.vals, v)
+	return nil
+}
+
+// itemPath returns the path comments of the i-th item of the list at path
+// are kept under. Entries with a name (groups) or src (files) are identified
+// by it, so their comments follow them as entries are added or removed.
+func (n *cfgNode) itemPath(path string, i int) string {
+	if item := n.vals[i]; item.kind == mapNode {
+		for _, key := range []string{"name", "src"} {
+			if v := item.get(key); v != nil && v.kind == scalarNode {
+				return fmt.Sprintf("%s[%s=%s]", path, key, v.value)
+			}
+		}
+	}
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// collectComments records in out every node under n, at path, that has
+// comments, by path.
+func (n *cfgNode) collectComments(path string, out map[string]*cfgNode) {
+	if len(n.comments) > 0 || n.inline != "" {
+		out[path] = n
+	}
+	for i, v := range n.vals {
+		switch n.kind {
+		case mapNode:
+			v.collectComments(path+"."+n.keys[i], out)
+		case listNode:
+			v.collectComments(n.itemPath(path, i), out)
+		}
+	}
+}
+
+// jsonWriter writes a cfgNode as JSON, one key or list item per line,
+// recording the line of the configuration file each line came from.
+type jsonWriter struct {
+	buf   bytes.Buffer
+	lines []int
+}
+
+func (w *jsonWriter) newline(line int) {
+	w.buf.WriteByte('\n')
+	w.lines = append(w.lines, line)
+}
+
+// value writes n, which decodes into t; a nil t is a value nothing decodes
+// into, such as that of an unknown key.
+func (w *jsonWriter) value(n *cfgNode, t reflect.Type) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch n.kind {
+	case mapNode:
+		var fields map[string]reflect.Type
+		if t != nil && t.Kind() == reflect.Struct {
+			fields = make(map[string]reflect.Type)
+			jsonFields(t, fields)
+		}
+		w.buf.WriteByte('{')
+		for i, key := range n.keys {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			w.newline(n.vals[i].line)
+			k, _ := json.Marshal(key)
+			w.buf.Write(k)
+			w.buf.WriteString(": ")
+			var ft reflect.Type
+			switch {
+			case fields != nil:
+				ft, _ = lookupField(fields, key)
+			case t != nil && t.Kind() == reflect.Map:
+				ft = t.Elem()
+			}
+			w.value(n.vals[i], ft)
+		}
+		w.newline(w.lines[len(w.lines)-1])
+		w.buf.WriteByte('}')
+	case listNode:
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		w.buf.WriteByte('[')
+		for i, item := range n.vals {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			w.newline(item.line)
+			w.value(item, elem)
+		}
+		w.newline(w.lines[len(w.lines)-1])
+		w.buf.WriteByte(']')
+	default:
+		w.buf.WriteString(scalarJSON(n, t))
+	}
+}
+
+// scalarJSON returns scalar n as JSON. A plain scalar is a string when t is
+// one, and otherwise whatever it reads as: null, a boolean, or a number.
+func scalarJSON(n *cfgNode, t reflect.Type) string {
+	quoted, _ := json.Marshal(n.value)
+	if !n.plain {
+		return string(quoted)
+	}
+	if t != nil && t.Kind() == reflect.String {
+		return string(quoted)
+	}
+	switch {
+	case n.value == "" || n.value == "null" || n.value == "~":
+		return "null"
+	case n.value == "true" || n.value == "false":
+		return n.value
+	case isJSONNumber(n.value):
+		return n.value
+	}
+	return string(quoted)
+}
+
+// isJSONNumber reports whether s is a number as JSON writes them.
+func isJSONNumber(s string) bool {
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return false
+	}
+	return json.Valid([]byte(s))
+}
+
+// jsonToNode reads the JSON data into a cfgNode tree, keeping the order of
+// its keys.
+func jsonToNode(data []byte) (*cfgNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var read func() (*cfgNode, error)
+	read = func() (*cfgNode, error) {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case json.Delim:
+			n := &cfgNode{kind: mapNode}
+			if tok == '[' {
+				n.kind = listNode
+			}
+			for dec.More() {
+				var key string
+				if n.kind == mapNode {
+					k, err := dec.Token()
+					if err != nil {
+						return nil, err
+					}
+					key, _ = k.(string)
+				}
+				v, err := read()
+				if err != nil {
+					return nil, err
+				}
+				if n.kind == mapNode {
+					n.keys = append(n.keys, key)
+				}
+				n.vals = append(n.vals, v)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return n, nil
+		case string:
+			return &cfgNode{value: tok}, nil
+		case json.Number:
+			return &cfgNode{value: tok.String(), plain: true}, nil
+		case bool:
+			return &cfgNode{value: strconv.FormatBool(tok), plain: true}, nil
+		}
+		return &cfgNode{value: "null", plain: true}, nil
+	}
+	return read()
+}
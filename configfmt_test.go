@@ -0,0 +1,175 @@
+package wptsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const formatTestJSON = `{
+  "$schema": "./wpt.schema.json",
+  "commit": "0123456789abcdef0123456789abcdef01234567",
+  "target_dir": "wpt",
+  "license_headers": { ".js": "// Copyright\n// BSD\n" },
+  "patch_fuzz": { "context": 1, "ignore_whitespace": true },
+  "files": [
+    { "src": "url/a.any.js", "scopes": ["window", "worker"], "patch": "patches/a.patch" },
+    { "src": "url/123", "dst": "url/true", "enabled": false }
+  ],
+  "groups": [
+    { "name": "dom", "target_dir": "dom", "files": [{ "src": "dom/a.js" }] }
+  ]
+}
+`
+
+const formatTestYAML = `# Header comment.
+
+$schema: ./wpt.schema.json
+# Pinned for the URL work.
+commit: 0123456789abcdef0123456789abcdef01234567 # keep in sync
+target_dir: wpt
+license_headers:
+  .js: |
+    // Copyright
+    // BSD
+patch_fuzz: {context: 1, ignore_whitespace: true}
+files:
+# The constructor tests.
+- src: url/a.any.js
+  scopes: [window, worker]
+  patch: 'patches/a.patch'
+- src: url/123
+  dst: "url/true"
+  enabled: false
+groups:
+  - name: dom
+    target_dir: dom
+    files:
+      - src: dom/a.js
+`
+
+const formatTestTOML = `# Header comment.
+
+"$schema" = "./wpt.schema.json"
+# Pinned for the URL work.
+commit = "0123456789abcdef0123456789abcdef01234567" # keep in sync
+target_dir = "wpt"
+license_headers = { ".js" = """
+// Copyright
+// BSD
+""" }
+patch_fuzz.context = 0x1
+patch_fuzz.ignore_whitespace = true
+
+# The constructor tests.
+[[files]]
+src = "url/a.any.js"
+scopes = [
+  "window", # the default
+  "worker",
+]
+patch = 'patches/a.patch'
+
+[[files]]
+src = "url/123"
+dst = "url/true"
+enabled = false
+
+[[groups]]
+name = "dom"
+target_dir = "dom"
+
+[[groups.files]]
+src = "dom/a.js"
+`
+
+func TestLoadConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	load := func(name, content string) *Config {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(p)
+		if err != nil {
+			t.Fatalf("LoadConfig(%s): %v", name, err)
+		}
+		return cfg
+	}
+	want := load("wpt.json", formatTestJSON)
+	for name, content := range map[string]string{"wpt.yaml": formatTestYAML, "wpt.toml": formatTestTOML} {
+		if got := load(name, content); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s decodes to %+v, want %+v", name, got, want)
+		}
+	}
+}
+
+func TestConfigFormatErrorLines(t *testing.T) {
+	dir := t.TempDir()
+	for name, tc := range map[string]struct{ content, want string }{
+		"unknown.yaml":   {"commit: 0123456789abcdef0123456789abcdef01234567\ntarget_dir: wpt\nfiles:\n  - src: a.js\n    pacth: a.patch\n", `line 5: files[0].pacth: unknown key "pacth"; did you mean "patch"?`},
+		"type.yaml":      {"commit: 0123456789abcdef0123456789abcdef01234567\ntarget_dir: wpt\nfiles:\n  - src: a.js\n    enabled: maybe\n", "line 5"},
+		"syntax.yaml":    {"commit: 0123456789abcdef0123456789abcdef01234567\ntarget_dir: wpt\n  files: []\n", "line 3: unexpected indentation"},
+		"anchor.yaml":    {"commit: &c abc\n", "line 1: "},
+		"unknown.toml":   {"commit = \"0123456789abcdef0123456789abcdef01234567\"\ntarget_dir = \"wpt\"\n\n[[files]]\nsrc = \"a.js\"\npacth = \"a.patch\"\n", `line 6: files[0].pacth: unknown key "pacth"`},
+		"duplicate.toml": {"commit = \"abc\"\ncommit = \"def\"\n", `line 2: duplicate key "commit"`},
+		"date.toml":      {"commit = 1979-05-27\n", "line 1: dates and times are not supported"},
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(tc.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadConfig(p)
+		if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("LoadConfig(%s) = %v, want an invalid config error containing %q", name, err, tc.want)
+		}
+		problems, err := ValidateConfig(p)
+		if err != nil || len(problems) == 0 || !strings.Contains(problems[0].String(), strings.SplitN(tc.want, ":", 2)[0]) {
+			t.Errorf("ValidateConfig(%s) = %v, %v, want a problem on %q", name, problems, err, strings.SplitN(tc.want, ":", 2)[0])
+		}
+	}
+}
+
+func TestSaveConfigKeepsComments(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"wpt.yaml": formatTestYAML, "wpt.toml": formatTestTOML} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The commented entry moves to second place.
+		cfg.Files = append([]FileSpec{{Src: "url/new.js", Dst: "url/new.js"}}, cfg.Files...)
+		cfg.Commit = "89abcdef0123456789abcdef0123456789abcdef"
+		if err := SaveConfig(p, cfg); err != nil {
+			t.Fatalf("SaveConfig(%s): %v", name, err)
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved := string(data)
+		for _, comment := range []string{"# Header comment.\n\n", "# Pinned for the URL work.\n", "# keep in sync\n", "# The constructor tests.\n"} {
+			if !strings.Contains(saved, comment) {
+				t.Errorf("%s lost comment %q:\n%s", name, comment, saved)
+			}
+		}
+		if strings.Index(saved, "The constructor tests") < strings.Index(saved, "url/new.js") {
+			t.Errorf("%s: the comment of url/a.any.js did not follow it:\n%s", name, saved)
+		}
+		got, err := LoadConfig(p)
+		if err != nil {
+			t.Fatalf("LoadConfig(%s) after SaveConfig: %v\n%s", name, err, saved)
+		}
+		if !reflect.DeepEqual(got, cfg) {
+			t.Errorf("%s round-trips to %+v, want %+v", name, got, cfg)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package wptsync
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// dedupeFiles hard-links byte-identical synced files (as recorded in lock)
+// to a single inode. Within each group of identical files the first dst in
+// sorted order is kept and the others are replaced by links to it. Frozen
+// files are left out, as their local edits must not reach their twins.
+//
+// Linking is an optimization, so any failure (cross-device targets,
+// filesystems without hard links) leaves that copy in place and is only
+// logged. Replacing a linked file later is safe: downloads always rename a
+// fresh file into place rather than writing through the shared inode.
+func dedupeFiles(root string, cfg *Config, lock *lockFile, logf func(format string, args ...any)) {
+	frozen := make(map[string]bool)
+	for _, f := range cfg.Files {
+		if f.Frozen {
+			frozen[f.Dst] = true
+		}
+	}
+	groups := make(map[string][]string)
+	for dst, entry := range lock.Files {
+		if frozen[dst] {
+			continue
+		}
+		groups[entry.SHA256] = append(groups[entry.SHA256], dst)
+	}
+
+	linked := 0
+	for _, dsts := range groups {
+		if len(dsts) < 2 {
+			continue
+		}
+		sort.Strings(dsts)
+		keep := filepath.Join(root, cfg.TargetDir, filepath.FromSlash(dsts[0]))
+		keepInfo, err := os.Stat(keep)
+		if err != nil {
+			continue
+		}
+		for _, dst := range dsts[1:] {
+			dest := filepath.Join(root, cfg.TargetDir, filepath.FromSlash(dst))
+			if info, err := os.Stat(dest); err == nil && os.SameFile(keepInfo, info) {
+				continue
+			}
+			if err := linkInto(keep, dest); err != nil {
+				logf("   warning: could not hard-link %s to %s: %v\n", dst, dsts[0], err)
+				continue
+			}
+			linked++
+		}
+	}
+
+	if linked > 0 {
+		logf("Hard-linked %d duplicate file(s)\n", linked)
+	}
+}
+
+// linkInto atomically replaces dest with a hard link to src by linking to a
+// temporary name in dest's directory and renaming it over dest.
+func linkInto(src, dest string) error {
+	tmp := filepath.Join(filepath.Dir(dest), ".wpt-link-"+filepath.Base(dest))
+	_ = os.Remove(tmp)
+	if err := os.Link(src, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
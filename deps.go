@@ -0,0 +1,257 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// scriptDeps returns the scripts the enabled JavaScript files among files
+// load that no entry of cfg lists, sorted by src: their META script
+// directives, and the relative imports and importScripts calls in their
+// content, which fetch/ and streams/ tests use to load helpers several
+// directories up. refs returns the URLs one of files loads (see
+// readScriptRefs). A dependency loaded by relative URL from a file whose dst
+// is not in the folder of its src is placed relative to that dst, where the
+// URL finds it; the others are left without a dst, for nameFiles.
+func scriptDeps(cfg *Config, files []FileSpec, refs func(file FileSpec) ([]string, error)) ([]FileSpec, error) {
+	configured := make(map[string]bool, len(cfg.Files))
+	var globs []string
+	for _, f := range cfg.Files {
+		src := strings.TrimLeft(f.Src, "/")
+		if isGlob(src) {
+			globs = append(globs, src)
+			continue
+		}
+		configured[src] = true
+	}
+
+	var deps []FileSpec
+	for _, f := range files {
+		if !f.IsEnabled() || isGlob(f.Src) || path.Ext(f.Src) != ".js" {
+			continue
+		}
+		urls, err := refs(f)
+		if err != nil {
+			return nil, fmt.Errorf("read script dependencies of %s: %w", f.Src, err)
+		}
+		src := strings.TrimLeft(f.Src, "/")
+		for _, u := range urls {
+			// Scripts reaching above the repository root are not files of it.
+			dep := scriptSrc(src, u)
+			if !fs.ValidPath(dep) || configured[dep] || slices.ContainsFunc(globs, func(pattern string) bool { return matchGlob(pattern, dep) }) {
+				continue
+			}
+			configured[dep] = true
+			entry := FileSpec{Src: dep}
+			if !strings.HasPrefix(u, "/") && f.Dst != "" && path.Dir(f.Dst) != path.Dir(src) {
+				if dst := scriptSrc(f.Dst, u); filepath.IsLocal(filepath.FromSlash(dst)) {
+					entry.Dst = dst
+				}
+			}
+			deps = append(deps, entry)
+		}
+	}
+	slices.SortFunc(deps, func(a, b FileSpec) int { return strings.Compare(a.Src, b.Src) })
+	return deps, nil
+}
+
+// readScriptRefs returns the URLs of the scripts the JavaScript file at p
+// loads: those of its META script directives, then the relative or
+// root-relative specifiers of its import statements, dynamic imports, and
+// importScripts calls, in order.
+func readScriptRefs(p string) ([]string, error) {
+	meta, err := readMeta(p)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, m := range meta {
+		if m.key == "script" {
+			urls = append(urls, m.value)
+		}
+	}
+	content, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range scriptImports(string(content)) {
+		if !slices.Contains(urls, u) {
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
+
+var (
+	// importRE matches the module specifier of a static import or export
+	// ("import x from '../a.js'", "import '../a.js'", "export * from
+	// '../a.js'") or a dynamic import at the start of a statement.
+	importRE = regexp.MustCompile(`(?m)^\s*(?:import|export)\b[^'"\x60;]*?(?:\bfrom\s*)?['"]([^'"\n]+)['"]|\bimport\(\s*['"]([^'"\n]+)['"]\s*\)`)
+	// importScriptsRE matches an importScripts call, whose string
+	// arguments stringRE then picks out.
+	importScriptsRE = regexp.MustCompile(`\bimportScripts\(([^)]*)\)`)
+	stringRE        = regexp.MustCompile(`['"]([^'"\n]+)['"]`)
+)
+
+// scriptImports returns the specifiers of the scripts the JavaScript source
+// imports, loads with importScripts, or re-exports, keeping only the
+// relative ("./", "../") and root-relative ("/common/...") ones that name
+// JavaScript files of the repository: bare module names and full URLs are
+// not.
+func scriptImports(source string) []string {
+	var specs []string
+	for _, m := range importRE.FindAllStringSubmatch(source, -1) {
+		specs = append(specs, m[1]+m[2])
+	}
+	for _, m := range importScriptsRE.FindAllStringSubmatch(source, -1) {
+		for _, arg := range stringRE.FindAllStringSubmatch(m[1], -1) {
+			specs = append(specs, arg[1])
+		}
+	}
+	var urls []string
+	for _, spec := range specs {
+		relative := strings.HasPrefix(spec, "./") || strings.HasPrefix(spec, "../") || strings.HasPrefix(spec, "/") && !strings.HasPrefix(spec, "//")
+		name, _, _ := strings.Cut(spec, "?")
+		name, _, _ = strings.Cut(name, "#")
+		if !relative || path.Ext(name) != ".js" && path.Ext(name) != ".mjs" || slices.Contains(urls, spec) {
+			continue
+		}
+		urls = append(urls, spec)
+	}
+	return urls
+}
+
+// upstreamScriptDeps returns the script dependencies, as scriptDeps does, of
+// files about to be added to cfg, and in turn theirs. It downloads them at
+// cfg's commit to read what they load; a dependency missing upstream is
+// still returned, for the sync to report.
+func upstreamScriptDeps(ctx context.Context, cfg *Config, files []FileSpec, opts *SyncOptions) ([]FileSpec, error) {
+	tmp, err := os.MkdirTemp("", "wptsync-deps-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	probe := *cfg
+	probe.Files = append(slices.Clone(cfg.Files), files...)
+	var all []FileSpec
+	for round := 0; len(files) > 0; round++ {
+		files = slices.DeleteFunc(slices.Clone(files), func(f FileSpec) bool { return path.Ext(f.Src) != ".js" })
+		var mu sync.Mutex
+		missing := make(map[string]bool)
+		err = forEachFile(ctx, opts.jobs(), len(files), func(ctx context.Context, i int) error {
+			downloadCtx, cancel := withTimeout(ctx, opts.timeouts().Download)
+			defer cancel()
+			err := fetchFile(downloadCtx, cfg.Commit, files[i].Src, filepath.Join(tmp, filepath.FromSlash(files[i].Src)), opts)
+			var se *statusError
+			if round > 0 && errors.As(err, &se) && se.code == http.StatusNotFound {
+				mu.Lock()
+				missing[files[i].Src] = true
+				mu.Unlock()
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		deps, err := scriptDeps(&probe, files, func(file FileSpec) ([]string, error) {
+			if missing[file.Src] {
+				return nil, nil
+			}
+			return readScriptRefs(filepath.Join(tmp, filepath.FromSlash(file.Src)))
+		})
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, deps...)
+		probe.Files = append(probe.Files, deps...)
+		files = deps
+	}
+	slices.SortFunc(all, func(a, b FileSpec) int { return strings.Compare(a.Src, b.Src) })
+	return all, nil
+}
+
+// nameDeps gives the dependencies scriptDeps left without a dst the one
+// nameFiles names them.
+func (c *Config) nameDeps(ctx context.Context, root string, deps []FileSpec) error {
+	var srcs []string
+	for _, d := range deps {
+		if d.Dst == "" {
+			srcs = append(srcs, d.Src)
+		}
+	}
+	if len(srcs) == 0 {
+		return nil
+	}
+	dsts, err := c.nameFiles(ctx, root, srcs)
+	if err != nil {
+		return err
+	}
+	for i := range deps {
+		if deps[i].Dst == "" {
+			deps[i].Dst, dsts = dsts[0], dsts[1:]
+		}
+	}
+	return nil
+}
+
+// addScriptDeps adds to the configuration at configPath, or to the group
+// opts names in it, an entry for every script dependency of the files synced
+// for cfg (its expanded form) that it lacks, reading what they load from
+// disk. It returns the entries added, which still need syncing.
+func addScriptDeps(ctx context.Context, configPath, root string, cfg *Config, opts *SyncOptions) ([]FileSpec, error) {
+	deps, err := scriptDeps(cfg, cfg.Files, func(file FileSpec) ([]string, error) {
+		urls, err := readScriptRefs(filepath.Join(root, cfg.TargetDir, filepath.FromSlash(file.Dst)))
+		if errors.Is(err, fs.ErrNotExist) {
+			// The sync reports the files it failed to write.
+			return nil, nil
+		}
+		return urls, err
+	})
+	if err != nil || len(deps) == 0 {
+		return nil, err
+	}
+
+	saved, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	group, err := saved.group(opts.Group)
+	if err != nil {
+		return nil, err
+	}
+	if err := saved.nameDeps(ctx, root, deps); err != nil {
+		return nil, err
+	}
+	srcs := make([]string, len(deps))
+	for i, dep := range deps {
+		srcs[i] = dep.Src
+		if dep.Dst != dep.Src {
+			opts.logf(" + %s -> %s (script dependency)\n", dep.Src, dep.Dst)
+		} else {
+			opts.logf(" + %s (script dependency)\n", dep.Src)
+		}
+	}
+	saved.setGroupFiles(opts.Group, append(group.Files, deps...))
+	if err := saved.validate(); err != nil {
+		return nil, err
+	}
+	for _, w := range saved.generatedWarnings(srcs) {
+		opts.logf("warning: %s\n", w)
+	}
+	if err := SaveConfig(configPath, saved); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
@@ -0,0 +1,129 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const metaTest = "// META: title=x\n// META: script=/common/subset-tests.js\n// META: script=resources/helper.js\n" +
+	"// META: script=../../../outside.js\n\ntest(() => {});\n"
+
+func TestAddWithDepsAddsMetaScripts(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	server, dir, _ := newFixture(t, map[string]string{"/c1/url/a.any.js": metaTest})
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1":                `{"tree":[{"path":"url","type":"tree","sha":"t-url"}]}`,
+		"/repos/o/n/git/trees/t-url?recursive=1": `{"tree":[{"path":"a.any.js","type":"blob"}]}`,
+	})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "common/subset-tests.js"}}})
+
+	opts := &AddOptions{SyncOptions: SyncOptions{BaseURL: server.URL, APIURL: apiURL, WithDeps: true}}
+	if err := Add(context.Background(), configPath, "url", opts); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var srcs []string
+	for _, f := range cfg.Files {
+		srcs = append(srcs, f.Src)
+	}
+	want := []string{"common/subset-tests.js", "url/a.any.js", "url/resources/helper.js"}
+	if !slices.Equal(srcs, want) {
+		t.Errorf("srcs = %q, want %q", srcs, want)
+	}
+}
+
+func TestSyncWithDepsFetchesMissingScripts(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/url/a.any.js":            metaTest,
+		"/c1/common/subset-tests.js":  "subset\n",
+		"/c1/url/resources/helper.js": "helper\n",
+	})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "url/a.any.js"}}})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, APIURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// The stamp is fresh, but dependencies are still looked for.
+	report := &SyncReport{}
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, APIURL: server.URL, WithDeps: true, Report: report}); err != nil {
+		t.Fatalf("Sync with deps: %v", err)
+	}
+	if report.Summary.Downloaded != 2 {
+		t.Errorf("downloaded %d files, want the 2 dependencies", report.Summary.Downloaded)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "url", "resources", "helper.js")); string(got) != "helper\n" {
+		t.Errorf("helper.js = %q", got)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Files) != 3 {
+		t.Fatalf("config has %d files, want the 2 dependencies added", len(cfg.Files))
+	}
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lock.Files["common/subset-tests.js"]; !ok || len(lock.Files) != 3 {
+		t.Errorf("lock files = %v, want all 3", lock.Files)
+	}
+}
+
+func TestSyncWithDepsFollowsRelativeImports(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/fetch/api/basic/a.js": "import { check } from '../resources/utils.js';\nimport 'https://example.com/x.js';\n" +
+			"const m = await import(\"/common/get-host-info.sub.js\");\n",
+		"/c1/fetch/api/resources/utils.js":     "importScripts('./keepalive.js', \"../../../../outside.js\");\nexport function check() {}\n",
+		"/c1/fetch/api/resources/keepalive.js": "// keepalive\n",
+		"/c1/common/get-host-info.sub.js":      "// host info\n",
+	})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "fetch/api/basic/a.js", Dst: "fetch-basic/a.js"}}})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, APIURL: server.URL, WithDeps: true}); err != nil {
+		t.Fatalf("Sync with deps: %v", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range cfg.Files {
+		got = append(got, f.Src+" -> "+f.Dst)
+	}
+	// The helpers land where the relative imports of the moved test find
+	// them; a root-relative import keeps the upstream layout.
+	want := []string{
+		"fetch/api/basic/a.js -> fetch-basic/a.js",
+		"common/get-host-info.sub.js -> common/get-host-info.sub.js",
+		"fetch/api/resources/utils.js -> resources/utils.js",
+		"fetch/api/resources/keepalive.js -> resources/keepalive.js",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "resources", "keepalive.js")); string(got) != "// keepalive\n" {
+		t.Errorf("resources/keepalive.js = %q", got)
+	}
+}
+
+func TestScriptImports(t *testing.T) {
+	source := `import {a} from "./a.js";
+export * from '../b.mjs';
+import "/common/c.js?pipe=sub";
+import x from "lodash";
+  import(` + "`../dynamic-${x}.js`" + `);
+self.importScripts("../d.js", '/resources/e.js');
+fetch("../data.json");
+`
+	want := []string{"./a.js", "../b.mjs", "/common/c.js?pipe=sub", "../d.js", "/resources/e.js"}
+	if got := scriptImports(source); !slices.Equal(got, want) {
+		t.Errorf("scriptImports = %q, want %q", got, want)
+	}
+}
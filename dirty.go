@@ -0,0 +1,70 @@
+package wptsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrDirty marks a sync refused because it would overwrite synced files with
+// uncommitted changes.
+var ErrDirty = errors.New("synced files have uncommitted changes")
+
+// gitDirtyPaths returns the files under the target directory dir (relative to
+// root) that differ from HEAD, staged or not, in the git work tree containing
+// root. Paths are relative to root and slash-separated. It returns nil when
+// git is not installed or root is not in a work tree with a commit.
+func gitDirtyPaths(ctx context.Context, root, dir string) (map[string]bool, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, nil
+	}
+	if err := exec.CommandContext(ctx, "git", "-C", root, "rev-parse", "--verify", "-q", "HEAD").Run(); err != nil {
+		return nil, nil
+	}
+	cmd := exec.CommandContext(ctx, "git", "-C", root, "diff", "--name-only", "-z", "--relative", "--no-renames", "HEAD", "--", dir)
+	out, err := cmd.Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return nil, fmt.Errorf("git diff: %s", bytes.TrimSpace(ee.Stderr))
+		}
+		return nil, fmt.Errorf("git diff: %w", err)
+	}
+	dirty := make(map[string]bool)
+	for p := range strings.SplitSeq(string(out), "\x00") {
+		if p != "" {
+			dirty[p] = true
+		}
+	}
+	return dirty, nil
+}
+
+// checkDirty returns an error wrapping ErrDirty when syncing pending would
+// overwrite local edits: files git reports as changed since HEAD whose
+// content is not what the last sync (per lock) wrote. opts.ConfirmDirty may
+// allow it anyway.
+func checkDirty(ctx context.Context, root string, cfg *Config, lock *lockFile, pending []FileSpec, opts *SyncOptions) error {
+	dirty, err := gitDirtyPaths(ctx, root, filepath.FromSlash(cfg.TargetDir))
+	if err != nil {
+		return fmt.Errorf("check for uncommitted changes: %w", err)
+	}
+	if len(dirty) == 0 {
+		return nil
+	}
+	var edited []string
+	for _, file := range pending {
+		p := path.Join(filepath.ToSlash(cfg.TargetDir), file.Dst)
+		if dirty[p] && !lock.matchesDisk(root, cfg, file) {
+			edited = append(edited, p)
+		}
+	}
+	if len(edited) == 0 || opts.ConfirmDirty != nil && opts.ConfirmDirty(edited) {
+		return nil
+	}
+	return invalidConfig(fmt.Errorf("%w, which sync would overwrite: %s; commit or stash them, or pass -force", ErrDirty, strings.Join(edited, ", ")))
+}
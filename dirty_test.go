@@ -0,0 +1,83 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSyncRefusesToOverwriteUncommittedEdits(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not on PATH")
+	}
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/a.js": "a v1\n",
+		"/c1/b.js": "b v1\n",
+		"/c2/a.js": "a v2\n",
+		"/c2/b.js": "b v2\n",
+		"/c3/a.js": "a v3\n",
+		"/c3/b.js": "b v3\n",
+	})
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", CheckDirty: true, Files: []FileSpec{{Src: "a.js"}, {Src: "b.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+	opts := &SyncOptions{BaseURL: server.URL}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(v, "t")
+	}
+	for _, v := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(v, "t@example.com")
+	}
+	for _, args := range [][]string{{"init", "-q"}, {"add", "-A"}, {"commit", "-q", "-m", "Vendor WPT"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	// Uncommitted changes a sync made are not edits.
+	cfg.Commit = "c2"
+	saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync over its own uncommitted changes: %v", err)
+	}
+
+	aPath := filepath.Join(dir, "wpt", "a.js")
+	if err := os.WriteFile(aPath, []byte("a v2\ndebugger;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Commit = "c3"
+	saveTestConfig(t, dir, cfg)
+
+	var asked []string
+	opts.ConfirmDirty = func(paths []string) bool {
+		asked = paths
+		return false
+	}
+	err := Sync(context.Background(), configPath, opts)
+	if !errors.Is(err, ErrDirty) || ExitCode(err) != ExitConfig {
+		t.Fatalf("Sync over an edit = %v, want ErrDirty with exit code %d", err, ExitConfig)
+	}
+	if want := []string{"wpt/a.js"}; !slices.Equal(asked, want) {
+		t.Errorf("asked about %q, want %q", asked, want)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "a v2\ndebugger;\n" {
+		t.Errorf("a.js = %q, want the edit kept", got)
+	}
+
+	opts.Force = true
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync -force: %v", err)
+	}
+	if got, _ := os.ReadFile(aPath); string(got) != "a v3\n" {
+		t.Errorf("a.js after -force = %q, want %q", got, "a v3\n")
+	}
+}
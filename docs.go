@@ -0,0 +1,163 @@
+package wptsync
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DefaultDocsDir is the folder of target_dir upstream documentation is
+// synced into when UpstreamDocs.Dir is empty.
+const DefaultDocsDir = "_upstream"
+
+// defaultDocNames are the files UpstreamDocs syncs when Names is empty: what
+// WPT keeps in its directories to describe them and name their owners.
+var defaultDocNames = []string{"README.md", "OWNERS", "META.yml"}
+
+// UpstreamDocs has the upstream files describing the directories synced
+// files come from (their README.md, OWNERS, or META.yml, which lists
+// reviewers) synced along with them, so that developers can see who owns a
+// test and why it is there without leaving the repository.
+type UpstreamDocs struct {
+	// Dir is the folder of target_dir the files are placed in, under their
+	// upstream path: _upstream/url/META.yml. Empty means DefaultDocsDir.
+	Dir string `json:"dir,omitempty"`
+	// Names lists the file names to look for in every directory holding a
+	// synced file, and in its parents. Empty means README.md, OWNERS, and
+	// META.yml.
+	Names []string `json:"names,omitempty"`
+}
+
+func (d *UpstreamDocs) dir() string {
+	if d.Dir == "" {
+		return DefaultDocsDir
+	}
+	return strings.Trim(d.Dir, "/")
+}
+
+func (d *UpstreamDocs) names() []string {
+	if len(d.Names) == 0 {
+		return defaultDocNames
+	}
+	return d.Names
+}
+
+func (d *UpstreamDocs) check() error {
+	if !filepath.IsLocal(filepath.FromSlash(d.dir())) {
+		return fmt.Errorf("upstream_docs: dir %q escapes the target directory", d.Dir)
+	}
+	for _, name := range d.Names {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("upstream_docs: %q is not a file name", name)
+		}
+	}
+	return nil
+}
+
+// docCandidates returns the paths upstream documentation of files would be
+// at: every name in each directory holding one of the files and in its
+// parents, but for the repository's root, sorted. Documentation itself
+// among files is left out.
+func (d *UpstreamDocs) docCandidates(files []FileSpec) []string {
+	dirs := make(map[string]bool)
+	for _, f := range files {
+		if isGlob(f.Src) || d.isDoc(f.Dst, f.Src) {
+			continue
+		}
+		for dir := path.Dir(strings.TrimLeft(f.Src, "/")); dir != "." && dir != "/" && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	var candidates []string
+	for dir := range dirs {
+		for _, name := range d.names() {
+			candidates = append(candidates, dir+"/"+name)
+		}
+	}
+	slices.Sort(candidates)
+	return candidates
+}
+
+// docEntry is the entry that syncs the upstream documentation file src.
+// It is written as upstream has it, without a provenance header.
+func (d *UpstreamDocs) docEntry(src string) FileSpec {
+	off := false
+	return FileSpec{Src: src, Dst: path.Join(d.dir(), src), Provenance: &off}
+}
+
+// isDoc reports whether the lock entry of dst is upstream documentation.
+func (d *UpstreamDocs) isDoc(dst, src string) bool {
+	return dst == path.Join(d.dir(), src) && slices.Contains(d.names(), path.Base(src))
+}
+
+// expandDocs returns a copy of cfg with an entry for every upstream
+// documentation file (see UpstreamDocs) that exists at cfg.Commit, looking
+// them up in the bundle or clone the sync reads, or else with one GitHub
+// API request per directory. Files listed explicitly are not repeated.
+func expandDocs(ctx context.Context, root string, cfg *Config, opts *SyncOptions) (*Config, error) {
+	if cfg.UpstreamDocs == nil {
+		return cfg, nil
+	}
+	candidates := cfg.UpstreamDocs.docCandidates(cfg.Files)
+	if len(candidates) == 0 {
+		return cfg, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, opts.timeouts().Resolve)
+	defer cancel()
+	exists := make(map[string]bool)
+	var listed []string
+	var err error
+	switch bundle, checkout := opts.bundlePath(root, cfg), opts.sourcePath(root, cfg, gitScheme); {
+	case bundle != "":
+		listed, err = bundleFiles(ctx, bundle)
+	case checkout != "":
+		listed, err = checkoutFiles(ctx, checkout, cfg.Commit, "")
+	default:
+		var shas map[string]string
+		shas, err = opts.github().blobSHAs(ctx, cfg.Commit, candidates)
+		for p := range shas {
+			listed = append(listed, p)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("look up upstream docs: %w", err)
+	}
+	for _, p := range listed {
+		exists[p] = true
+	}
+
+	seen := explicitSrcs(cfg)
+	out := *cfg
+	out.Files = slices.Clone(cfg.Files)
+	for _, src := range candidates {
+		if exists[src] && !seen[src] {
+			out.Files = append(out.Files, cfg.UpstreamDocs.docEntry(src))
+		}
+	}
+	return &out, nil
+}
+
+// docsFromLock is the offline counterpart of expandDocs: the documentation
+// files the last sync recorded in lock are added to cfg. Like
+// expandGlobsFromLock, it only applies when the lock was written for cfg's
+// commit.
+func docsFromLock(cfg *Config, lock *lockFile) *Config {
+	if cfg.UpstreamDocs == nil || lock.Commit != cfg.Commit {
+		return cfg
+	}
+	seen := explicitSrcs(cfg)
+	out := *cfg
+	out.Files = slices.Clone(cfg.Files)
+	for _, dst := range slices.Sorted(maps.Keys(lock.Files)) {
+		src := lock.Files[dst].Src
+		if cfg.UpstreamDocs.isDoc(dst, src) && !seen[src] {
+			out.Files = append(out.Files, cfg.UpstreamDocs.docEntry(src))
+		}
+	}
+	return &out
+}
@@ -0,0 +1,59 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSyncUpstreamDocs(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/url/a.js":        "a\n",
+		"/c1/url/sub/b.js":    "b\n",
+		"/c1/url/META.yml":    "spec: https://url.spec.whatwg.org/\n",
+		"/c1/url/sub/OWNERS":  "@someone\n",
+		"/c1/fetch/c.js":      "c\n",
+		"/c1/fetch/README.md": "# fetch\n",
+	})
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1":    `{"tree":[{"path":"url","type":"tree","sha":"t-url"},{"path":"fetch","type":"tree","sha":"t-fetch"}]}`,
+		"/repos/o/n/git/trees/t-url": `{"tree":[{"path":"a.js","type":"blob","sha":"s1"},{"path":"META.yml","type":"blob","sha":"s2"},{"path":"sub","type":"tree","sha":"t-sub"}]}`,
+		"/repos/o/n/git/trees/t-sub": `{"tree":[{"path":"b.js","type":"blob","sha":"s3"},{"path":"OWNERS","type":"blob","sha":"s4"}]}`,
+	})
+	cfg := &Config{
+		Commit:       "c1",
+		TargetDir:    "wpt",
+		UpstreamDocs: &UpstreamDocs{},
+		Files:        []FileSpec{{Src: "url/a.js"}, {Src: "url/sub/b.js"}},
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+	opts := &SyncOptions{BaseURL: server.URL, APIURL: apiURL}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	for dst, want := range map[string]string{
+		"_upstream/url/META.yml":   "spec: https://url.spec.whatwg.org/\n",
+		"_upstream/url/sub/OWNERS": "@someone\n",
+	} {
+		if got, err := os.ReadFile(filepath.Join(dir, "wpt", filepath.FromSlash(dst))); string(got) != want {
+			t.Errorf("%s = %q (%v), want %q", dst, got, err, want)
+		}
+	}
+	if err := Verify(context.Background(), configPath, opts); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if pruned, err := Prune(context.Background(), configPath, &PruneOptions{SyncOptions: *opts}); err != nil || len(pruned) != 0 {
+		t.Errorf("Prune = %v, %v, want the docs kept", pruned, err)
+	}
+
+	// Docs of directories no longer synced from are pruned with them.
+	cfg.Files = []FileSpec{{Src: "url/a.js"}}
+	saveTestConfig(t, dir, cfg)
+	pruned, err := Prune(context.Background(), configPath, &PruneOptions{SyncOptions: *opts})
+	if want := []string{"_upstream/url/sub/OWNERS", "url/sub/b.js"}; err != nil || !slices.Equal(pruned, want) {
+		t.Errorf("Prune = %v, %v, want %v", pruned, err, want)
+	}
+}
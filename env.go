@@ -0,0 +1,61 @@
+package wptsync
+
+import (
+	"os"
+	"strings"
+)
+
+// credentialSuffixes end the names of environment variables that likely
+// hold credentials, which subprocesses never see.
+var credentialSuffixes = []string{"_TOKEN", "_SECRET", "_PASSWORD", "_PASSWD", "_KEY"}
+
+// scrubbedEnv lists variables removed from the subprocess environment by
+// name: credential helpers, and variables that point git at a repository,
+// index, or configuration other than the one it would find itself, as git
+// sets them when wptsync runs inside a git hook.
+var scrubbedEnv = map[string]bool{
+	"GIT_ASKPASS":                      true,
+	"SSH_ASKPASS":                      true,
+	"SSH_AUTH_SOCK":                    true,
+	"GIT_DIR":                          true,
+	"GIT_WORK_TREE":                    true,
+	"GIT_INDEX_FILE":                   true,
+	"GIT_PREFIX":                       true,
+	"GIT_OBJECT_DIRECTORY":             true,
+	"GIT_ALTERNATE_OBJECT_DIRECTORIES": true,
+	"GIT_EXTERNAL_DIFF":                true,
+	"LANG":                             true,
+	"LANGUAGE":                         true,
+}
+
+// isScrubbedEnv reports whether the environment variable name is kept from
+// subprocesses.
+func isScrubbedEnv(name string) bool {
+	upper := strings.ToUpper(name)
+	if scrubbedEnv[upper] || strings.HasPrefix(upper, "GIT_CONFIG") || strings.HasPrefix(upper, "LC_") || strings.Contains(upper, "CREDENTIAL") {
+		return true
+	}
+	for _, suffix := range credentialSuffixes {
+		if strings.HasSuffix(upper, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// subprocessEnv returns the environment git and dst_script run with, so that
+// they behave the same on every developer machine and in CI: the user's,
+// without credentials or variables that redirect git (see isScrubbedEnv),
+// with git's system and global configuration ignored and the C locale.
+// extra ("NAME=value") entries are added last, and win.
+func subprocessEnv(extra ...string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if !isScrubbedEnv(name) {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, "GIT_CONFIG_NOSYSTEM=1", "GIT_CONFIG_GLOBAL="+os.DevNull, "LC_ALL=C")
+	return append(env, extra...)
+}
@@ -0,0 +1,77 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestUpdateRevalidatesUnchangedFiles(t *testing.T) {
+	content := map[string]string{
+		"/c1/same.js": "same\n", "/c2/same.js": "same\n",
+		"/c1/moved.js": "v1\n", "/c2/moved.js": "v2\n",
+	}
+	server, dir, _ := newFixture(t, content)
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "same.js"}, {Src: "moved.js"}}})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	same := filepath.Join(dir, "wpt", "same.js")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(same, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs []string
+	opts := &UpdateOptions{SyncOptions: SyncOptions{BaseURL: server.URL, Logf: func(format string, args ...any) {
+		logs = append(logs, format)
+	}}, Commit: "c2"}
+	if err := Update(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	if info, err := os.Stat(same); err != nil || !info.ModTime().Equal(old) {
+		t.Errorf("same.js was rewritten (mtime %v, want %v): %v", info.ModTime(), old, err)
+	}
+	if !slices.Contains(logs, " = %s (unchanged upstream)\n") {
+		t.Errorf("logs = %q, want same.js revalidated rather than downloaded", logs)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "moved.js")); string(got) != "v2\n" {
+		t.Errorf("moved.js = %q, want v2", got)
+	}
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Commit != "c2" || lock.Files["same.js"].ETag == "" {
+		t.Errorf("lock = %+v, want commit c2 with same.js's ETag kept", lock)
+	}
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestForcedSyncKeepsModTimeOfIdenticalFiles(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{"/c1/a.js": "a\n"})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a.js"}}})
+	opts := &SyncOptions{BaseURL: server.URL, Force: true}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	dest := filepath.Join(dir, "wpt", "a.js")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(dest, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if info, err := os.Stat(dest); err != nil || !info.ModTime().Equal(old) {
+		t.Errorf("a.js mtime changed to %v, want %v kept: %v", info.ModTime(), old, err)
+	}
+}
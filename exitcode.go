@@ -0,0 +1,86 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"net/url"
+)
+
+// Exit codes reported by the wptsync command, one per failure class, so CI
+// pipelines can branch on the kind of failure. ExitCode maps an error
+// returned by this package to one of them.
+const (
+	// ExitFailure is any failure not covered by a more specific code.
+	ExitFailure = 1
+	// ExitConfig means the configuration file, options, or command line
+	// could not be used.
+	ExitConfig = 2
+	// ExitNetwork means a request failed, timed out, was rate limited, or
+	// got an unexpected HTTP status.
+	ExitNetwork = 3
+	// ExitPatchConflict means a patch no longer applies.
+	ExitPatchConflict = 4
+	// ExitVerification means downloaded content failed an integrity check.
+	ExitVerification = 5
+	// ExitDrift means local files no longer match the lock file.
+	ExitDrift = 6
+	// ExitNothingToDo means a run succeeded without having to write
+	// anything. Only SyncReport.ExitCode returns it, since most callers
+	// treat it as success.
+	ExitNothingToDo = 7
+	// ExitPartialFailure means a run wrote some files before others
+	// failed. Only SyncReport.ExitCode returns it; ExitCode reports the
+	// class of the failure instead.
+	ExitPartialFailure = 8
+	// ExitScan means the scan of synced files found files violating the
+	// configuration's scan policy.
+	ExitScan = 9
+)
+
+// ErrInvalidConfig marks errors in the configuration file or options.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// ErrVerification marks downloaded content that does not match what
+// upstream says it should be, such as a Git LFS object whose size or hash
+// differs from its pointer.
+var ErrVerification = errors.New("content verification failed")
+
+// configError marks err as an ErrInvalidConfig without changing its message.
+type configError struct{ err error }
+
+func (e *configError) Error() string   { return e.err.Error() }
+func (e *configError) Unwrap() []error { return []error{ErrInvalidConfig, e.err} }
+
+// invalidConfig wraps a non-nil err as a configError.
+func invalidConfig(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &configError{err: err}
+}
+
+// ExitCode returns the exit code for err: 0 for nil, one of the Exit*
+// constants otherwise. When err joins failures of several classes, the most
+// specific one wins, in the order config, drift, verification, scan, patch
+// conflict, network.
+func ExitCode(err error) int {
+	var se *statusError
+	var ue *url.Error
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrInvalidConfig):
+		return ExitConfig
+	case errors.Is(err, ErrDrift):
+		return ExitDrift
+	case errors.Is(err, ErrVerification):
+		return ExitVerification
+	case errors.Is(err, ErrScanFindings):
+		return ExitScan
+	case errors.Is(err, ErrPatchFailed):
+		return ExitPatchConflict
+	case errors.As(err, &se), errors.As(err, &ue), errors.Is(err, ErrRateLimited), errors.Is(err, ErrNoAPICalls), errors.Is(err, context.DeadlineExceeded):
+		return ExitNetwork
+	}
+	return ExitFailure
+}
@@ -0,0 +1,43 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a/missing.js"}}})
+
+	_, loadErr := LoadConfig(filepath.Join(dir, "nope.json"))
+	syncErr := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, APIURL: server.URL})
+	optsErr := Sync(context.Background(), configPath, &SyncOptions{Jobs: -1})
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, 0},
+		{"missing config", loadErr, ExitConfig},
+		{"bad options", optsErr, ExitConfig},
+		{"invalid config", (&Config{}).validate(), ExitConfig},
+		{"download 404", syncErr, ExitNetwork},
+		{"rate limited", fmt.Errorf("fetch latest commit: %w", ErrRateLimited), ExitNetwork},
+		{"timeout", fmt.Errorf("download: %w", context.DeadlineExceeded), ExitNetwork},
+		{"patch", fmt.Errorf("apply patch: %w", ErrPatchFailed), ExitPatchConflict},
+		{"verification", fmt.Errorf("download: %w", ErrVerification), ExitVerification},
+		{"drift", fmt.Errorf("%w: 1 file(s)", ErrDrift), ExitDrift},
+		{"scan", fmt.Errorf("%w: 1 finding(s)", ErrScanFindings), ExitScan},
+		{"joined patch and network", errors.Join(syncErr, ErrPatchFailed), ExitPatchConflict},
+		{"other", errors.New("disk full"), ExitFailure},
+	}
+	for _, tt := range tests {
+		if got := ExitCode(tt.err); got != tt.want {
+			t.Errorf("%s: ExitCode(%v) = %d, want %d", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
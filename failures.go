@@ -0,0 +1,126 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// FileFailure is a file a Sync or Update with SyncOptions.KeepGoing could
+// not sync.
+type FileFailure struct {
+	Src string
+	Dst string
+	Err error
+	// Hint suggests how to fix the file's configuration entry, when the
+	// failure points at it, such as a source renamed upstream.
+	Hint string
+
+	file FileSpec
+}
+
+// newFileFailure returns the failure of file with err.
+func newFileFailure(file FileSpec, err error) FileFailure {
+	return FileFailure{Src: file.Src, Dst: file.Dst, Err: err, file: file}
+}
+
+// FailuresError is returned by a Sync or Update with SyncOptions.KeepGoing
+// that synced every file it could, but not all of them. It wraps the error
+// of each failed file, so ExitCode reports their class.
+type FailuresError struct {
+	Failures []FileFailure
+}
+
+func (e *FailuresError) Error() string {
+	dsts := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		dsts[i] = f.Dst
+	}
+	return fmt.Sprintf("%d file(s) failed to sync: %s", len(e.Failures), strings.Join(dsts, ", "))
+}
+
+func (e *FailuresError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// WriteReport writes each failure to w with its error and, when there is
+// one, the hint on what to change in the configuration.
+func (e *FailuresError) WriteReport(w io.Writer) error {
+	fmt.Fprintf(w, "Failed to sync %d file(s):\n", len(e.Failures))
+	for _, f := range e.Failures {
+		fmt.Fprintf(w, " - %s: %v\n", f.Dst, f.Err)
+		if f.Hint != "" {
+			fmt.Fprintf(w, "   hint: %s\n", f.Hint)
+		}
+	}
+	_, err := fmt.Fprintln(w, "The other files were synced. Fix these entries and sync again to retry them.")
+	return err
+}
+
+// failuresError returns the FailuresError of failures, the files a run at
+// cfg's commit could not sync, with hints on the entries to update. Sources
+// that were not found are looked up in the upstream changes since from, the
+// commit the files were last synced at, to tell renames from removals; when
+// that fails, the hints are only less specific.
+func (o *SyncOptions) failuresError(ctx context.Context, cfg *Config, from string, failures []FileFailure) *FailuresError {
+	var moved map[string]compareFile
+	lookup := func() {
+		if moved != nil {
+			return
+		}
+		moved = make(map[string]compareFile)
+		if from == "" || sameCommit(from, cfg.Commit) {
+			return
+		}
+		ctx, cancel := withTimeout(ctx, o.timeouts().Resolve)
+		defer cancel()
+		files, _, err := o.github().compare(ctx, from, cfg.Commit)
+		if err != nil {
+			return
+		}
+		for _, f := range files {
+			switch f.Status {
+			case "renamed":
+				moved[f.PreviousFilename] = f
+			case "removed":
+				moved[f.Filename] = f
+			}
+		}
+	}
+	for i, f := range failures {
+		src := strings.TrimLeft(f.Src, "/")
+		switch {
+		case errors.Is(f.Err, ErrPatchFailed):
+			failures[i].Hint = "its patch no longer applies; fix it with `wptsync edit` and `wptsync save`, or see `wptsync check-patches`"
+		case isNotFound(f.Err):
+			var c compareFile
+			ok := false
+			if f.file.Commit == "" {
+				lookup()
+				c, ok = moved[src]
+			}
+			switch {
+			case ok && c.Status == "renamed":
+				failures[i].Hint = fmt.Sprintf("%s was renamed upstream to %s; change the entry's src to it", src, c.Filename)
+			case ok:
+				failures[i].Hint = fmt.Sprintf("%s was removed upstream; remove the entry with `wptsync remove %s`", src, src)
+			default:
+				failures[i].Hint = fmt.Sprintf("%s does not exist at %s; it was probably renamed or removed upstream, so update or remove the entry", src, shortSHA(cfg.commitOf(f.file)))
+			}
+		}
+	}
+	return &FailuresError{Failures: failures}
+}
+
+// isNotFound reports whether err says a file does not exist upstream.
+func isNotFound(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.code == http.StatusNotFound || errors.Is(err, errNotStaged)
+}
@@ -0,0 +1,78 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncKeepGoing(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c0/url/a.js":    "a at c0\n",
+		"/c0/url/old.js":  "old\n",
+		"/c0/url/gone.js": "gone\n",
+		"/c1/url/a.js":    "a at c1\n",
+		"/c1/url/new.js":  "new\n",
+	})
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/compare/c0...c1": `{"files":[
+			{"filename":"url/new.js","previous_filename":"url/old.js","status":"renamed"},
+			{"filename":"url/gone.js","status":"removed"}
+		]}`,
+	})
+	cfg := &Config{
+		Commit:    "c0",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "url/old.js"}, {Src: "url/a.js"}, {Src: "url/gone.js"}},
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+	opts := &SyncOptions{BaseURL: server.URL, APIURL: apiURL, KeepGoing: true}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync at c0: %v", err)
+	}
+
+	cfg.Commit = "c1"
+	saveTestConfig(t, dir, cfg)
+	err := Sync(context.Background(), configPath, opts)
+	var failures *FailuresError
+	if !errors.As(err, &failures) || len(failures.Failures) != 2 {
+		t.Fatalf("Sync at c1 = %v, want the two missing files reported", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "url", "a.js")); string(got) != "a at c1\n" {
+		t.Errorf("url/a.js = %q, want it synced past the failures", got)
+	}
+	if ExitCode(err) != ExitNetwork {
+		t.Errorf("ExitCode = %d, want %d", ExitCode(err), ExitNetwork)
+	}
+
+	var report strings.Builder
+	if err := failures.WriteReport(&report); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"url/old.js was renamed upstream to url/new.js; change the entry's src to it",
+		"url/gone.js was removed upstream; remove the entry with `wptsync remove url/gone.js`",
+	} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, report.String())
+		}
+	}
+
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lock.Files["url/old.js"]; ok || lock.Files["url/a.js"].SHA256 == "" {
+		t.Errorf("lock = %+v, want url/a.js recorded and the failed files left out", lock.Files)
+	}
+
+	// Without -keep-going the first failure stops the sync.
+	err = Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, APIURL: apiURL})
+	if err == nil || errors.As(err, &failures) {
+		t.Errorf("Sync without KeepGoing = %v, want a plain failure", err)
+	}
+}
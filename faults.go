@@ -0,0 +1,158 @@
+package wptsync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrInjectedFault marks the failures FaultInjection causes, so that tests
+// can tell them from real ones.
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultInjection makes a run fail some of its HTTP requests at random, to
+// check that retries, partial-failure handling (KeepGoing), and the staging
+// of downloads hold up. It works with ReplayDir, so a recorded run can be
+// replayed under faults without touching the network. It is a testing aid,
+// not something to turn on in production.
+//
+// A request picked for a fault fails in one of three ways: with a network
+// error, with a 503 response, or with its response cut short part-way
+// through, so that writing the download fails after some of it landed in
+// its staging file. The first two are retried like real failures; the last
+// fails the file.
+type FaultInjection struct {
+	// Probability is the chance, from 0 to 1, that a request fails.
+	Probability float64
+	// Seed seeds the random choices, so that a run can be repeated with
+	// the same faults. Zero picks a seed at random.
+	Seed uint64
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	injected int
+}
+
+// ParseFaultInjection parses a fault injection spec, "p=0.1" or
+// "p=0.1,seed=42", as the -fault-inject flag takes it.
+func ParseFaultInjection(spec string) (*FaultInjection, error) {
+	f := &FaultInjection{}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		var err error
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("fault injection %q: want key=value, found %q", spec, part)
+		case key == "p":
+			f.Probability, err = strconv.ParseFloat(value, 64)
+		case key == "seed":
+			f.Seed, err = strconv.ParseUint(value, 10, 64)
+		default:
+			return nil, fmt.Errorf("fault injection %q: unknown key %q (want p or seed)", spec, key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fault injection %q: bad %s %q", spec, key, value)
+		}
+	}
+	return f, f.validate()
+}
+
+func (f *FaultInjection) validate() error {
+	if f != nil && (f.Probability < 0 || f.Probability > 1) {
+		return fmt.Errorf("fault injection probability must be between 0 and 1 (got %g)", f.Probability)
+	}
+	return nil
+}
+
+// Injected returns how many faults have been injected so far.
+func (f *FaultInjection) Injected() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.injected
+}
+
+type faultKind int
+
+const (
+	noFault faultKind = iota
+	faultNetwork
+	faultStatus
+	faultTruncate
+)
+
+// roll picks the fault, if any, to inject into the next request, and how
+// many bytes of its response a truncation lets through.
+func (f *FaultInjection) roll() (faultKind, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rng == nil {
+		seed := f.Seed
+		if seed == 0 {
+			seed = rand.Uint64()
+		}
+		f.rng = rand.New(rand.NewPCG(seed, seed))
+	}
+	if f.rng.Float64() >= f.Probability {
+		return noFault, 0
+	}
+	f.injected++
+	return faultKind(1 + f.rng.IntN(3)), f.rng.IntN(512)
+}
+
+// faultTransport fails the requests FaultInjection picks, before they reach
+// next or, for truncations, while their response is read.
+type faultTransport struct {
+	next   http.RoundTripper
+	faults *FaultInjection
+	logf   func(format string, args ...any)
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	kind, keep := t.faults.roll()
+	switch kind {
+	case faultNetwork:
+		t.logf("   injecting a network error into %s\n", req.URL.Redacted())
+		return nil, fmt.Errorf("%w: connection reset", ErrInjectedFault)
+	case faultStatus:
+		t.logf("   injecting a 503 into %s\n", req.URL.Redacted())
+		return &http.Response{
+			Status:     "503 Service Unavailable (injected fault)",
+			StatusCode: http.StatusServiceUnavailable,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || kind != faultTruncate {
+		return resp, err
+	}
+	t.logf("   injecting a cut after %d bytes into %s\n", keep, req.URL.Redacted())
+	resp.Body = &truncatedBody{r: io.LimitReader(resp.Body, int64(keep)), c: resp.Body}
+	return resp, nil
+}
+
+// truncatedBody is a response body that fails once r is exhausted, as if the
+// connection dropped.
+type truncatedBody struct {
+	r io.Reader
+	c io.Closer
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		err = fmt.Errorf("%w: connection lost mid-response", ErrInjectedFault)
+	}
+	return n, err
+}
+
+func (b *truncatedBody) Close() error { return b.c.Close() }
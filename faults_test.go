@@ -0,0 +1,86 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFaultInjection(t *testing.T) {
+	f, err := ParseFaultInjection("p=0.25,seed=42")
+	if err != nil || f.Probability != 0.25 || f.Seed != 42 {
+		t.Errorf("ParseFaultInjection = %+v, %v, want p=0.25 seed=42", f, err)
+	}
+	for _, bad := range []string{"0.1", "p=2", "p=x", "q=0.1", "p=0.1,seed=-1"} {
+		if _, err := ParseFaultInjection(bad); err == nil {
+			t.Errorf("ParseFaultInjection(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestSyncUnderFaultsLeavesNoPartialFiles(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	content := map[string]string{}
+	var files []FileSpec
+	for i := range 20 {
+		src := fmt.Sprintf("url/%02d.js", i)
+		content["/c1/"+src] = strings.Repeat(src+"\n", 200)
+		files = append(files, FileSpec{Src: src})
+	}
+	server, dir, _ := newFixture(t, content)
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: files})
+	recordDir := t.TempDir()
+
+	// Every request failing, without retries, fails the sync before it
+	// writes anything.
+	faults := &FaultInjection{Probability: 1, Seed: 1}
+	err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Retries: -1, FaultInjection: faults})
+	if !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("Sync = %v, want an injected fault", err)
+	}
+	if _, err := os.Stat(lockPath(configPath)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("failed sync wrote the lock file (%v)", err)
+	}
+
+	// Record a clean run, then replay it under faults into a fresh tree:
+	// retries absorb some faults, and the files whose download is cut short
+	// are reported, never left half-written.
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, RecordDir: recordDir}); err != nil {
+		t.Fatalf("recording Sync: %v", err)
+	}
+	os.RemoveAll(filepath.Join(dir, "wpt"))
+	os.Remove(lockPath(configPath))
+	faults = &FaultInjection{Probability: 0.3, Seed: 7}
+	opts := &SyncOptions{BaseURL: server.URL, ReplayDir: recordDir, KeepGoing: true, RetryDelay: time.Millisecond, FaultInjection: faults}
+	err = Sync(context.Background(), configPath, opts)
+	if faults.Injected() == 0 {
+		t.Fatal("no fault was injected")
+	}
+	failed := map[string]bool{}
+	var failures *FailuresError
+	if errors.As(err, &failures) {
+		for _, f := range failures.Failures {
+			failed[f.Dst] = true
+		}
+	} else if err != nil {
+		t.Fatalf("Sync under faults = %v, want nil or a FailuresError", err)
+	}
+	for _, f := range files {
+		got, err := os.ReadFile(filepath.Join(dir, "wpt", filepath.FromSlash(f.Src)))
+		switch {
+		case failed[f.Src] && !errors.Is(err, os.ErrNotExist):
+			t.Errorf("%s failed but exists (%d bytes)", f.Src, len(got))
+		case !failed[f.Src] && string(got) != content["/c1/"+f.Src]:
+			t.Errorf("%s = %d bytes, want the whole file (%v)", f.Src, len(got), err)
+		}
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "wpt", "url", ".wpt-*"))
+	if len(leftovers) > 0 {
+		t.Errorf("staging files left behind: %v", leftovers)
+	}
+}
@@ -0,0 +1,144 @@
+package wptsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PatchFuzz loosens how the built-in patch applier matches hunks that no
+// longer match the file exactly where the patch says (see Config.PatchFuzz).
+// Every hunk that needed any of it is reported as a HunkFuzz.
+type PatchFuzz struct {
+	// Context is how many leading and trailing context lines a hunk may
+	// drop to apply. Nil means 2, as GNU patch does by default.
+	Context *int `json:"context,omitempty"`
+	// MaxOffset is how many lines away from the line it records a hunk may
+	// apply. Zero means any distance.
+	MaxOffset int `json:"max_offset,omitempty"`
+	// IgnoreWhitespace lets lines that differ only in whitespace match.
+	// Context lines then keep the file's whitespace.
+	IgnoreWhitespace bool `json:"ignore_whitespace,omitempty"`
+}
+
+// context returns how many context lines a hunk may drop.
+func (f PatchFuzz) context() int {
+	if f.Context == nil {
+		return maxFuzz
+	}
+	return *f.Context
+}
+
+func (f PatchFuzz) check() error {
+	if f.Context != nil && *f.Context < 0 {
+		return fmt.Errorf("patch_fuzz: context must not be negative (got %d)", *f.Context)
+	}
+	if f.MaxOffset < 0 {
+		return fmt.Errorf("patch_fuzz: max_offset must not be negative (got %d)", f.MaxOffset)
+	}
+	return nil
+}
+
+// patchFuzz returns the fuzz c's patches apply with.
+func (c *Config) patchFuzz() PatchFuzz {
+	if c.PatchFuzz == nil {
+		return PatchFuzz{}
+	}
+	return *c.PatchFuzz
+}
+
+// HunkFuzz records what it took for a hunk to apply when it did not match
+// exactly where its patch said.
+type HunkFuzz struct {
+	// File is the file the hunk patches, as the patch names it.
+	File string `json:"file"`
+	// Hunk is the 1-based index of the hunk within its file's diff, and
+	// Line the line it expected to start at.
+	Hunk int `json:"hunk"`
+	Line int `json:"line"`
+	// Offset is how many lines after Line (before, when negative) the
+	// hunk applied.
+	Offset int `json:"offset,omitempty"`
+	// Context is how many context lines it dropped.
+	Context int `json:"context,omitempty"`
+	// Whitespace is set when its lines only matched ignoring whitespace.
+	Whitespace bool `json:"whitespace,omitempty"`
+}
+
+func (f HunkFuzz) String() string {
+	parts := []string{fmt.Sprintf("hunk #%d at line %d of %s", f.Hunk, f.Line, f.File)}
+	if f.Offset != 0 {
+		parts = append(parts, fmt.Sprintf("offset %+d", f.Offset))
+	}
+	if f.Context > 0 {
+		parts = append(parts, fmt.Sprintf("%d context line(s) dropped", f.Context))
+	}
+	if f.Whitespace {
+		parts = append(parts, "whitespace ignored")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FuzzRecord is one line of the fuzz audit log: a patch that only applied
+// with fuzz, and what each of its fuzzed hunks needed.
+type FuzzRecord struct {
+	Time   time.Time  `json:"time"`
+	Commit string     `json:"commit"`
+	Dst    string     `json:"dst"`
+	Patch  string     `json:"patch"`
+	Hunks  []HunkFuzz `json:"hunks"`
+}
+
+// fuzzLogPath returns the fuzz audit log kept next to the lock file at
+// lockName: wpt.lock -> wpt.fuzz.jsonl.
+func fuzzLogPath(lockName string) string {
+	return strings.TrimSuffix(lockName, ".lock") + ".fuzz.jsonl"
+}
+
+// fuzzLogMu serializes appends to fuzz audit logs from concurrent workers.
+var fuzzLogMu sync.Mutex
+
+// recordFuzz reports that file's patch only applied with fuzz, and appends
+// a FuzzRecord to the audit log of the run, if it keeps one.
+func (o *SyncOptions) recordFuzz(cfg *Config, file FileSpec, hunks []HunkFuzz) {
+	descs := make([]string, len(hunks))
+	for i, h := range hunks {
+		descs[i] = h.String()
+	}
+	o.logf(" ~ %s: patch applied with fuzz (%s)\n", file.Dst, strings.Join(descs, "; "))
+	if o == nil || o.fuzzLog == "" {
+		return
+	}
+	rec := FuzzRecord{
+		Time:   time.Now().UTC().Truncate(time.Second),
+		Commit: cfg.commitOf(file),
+		Dst:    file.Dst,
+		Patch:  file.Patch,
+		Hunks:  hunks,
+	}
+	if err := appendFuzz(o.fuzzLog, rec); err != nil {
+		o.logf("warning: record patch fuzz: %v\n", err)
+	}
+}
+
+// appendFuzz appends rec to the audit log at path as one JSON line.
+func appendFuzz(path string, rec FuzzRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	fuzzLogMu.Lock()
+	defer fuzzLogMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
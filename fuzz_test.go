@@ -0,0 +1,107 @@
+package wptsync
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyHunksFuzz(t *testing.T) {
+	// Replaces "c" in "a b c d e", with two lines of context each side.
+	h := hunk{oldStart: 1, lines: []hunkLine{
+		{' ', "a\n"}, {' ', "b\n"}, {'-', "c\n"}, {'+', "C\n"}, {' ', "d\n"}, {' ', "e\n"},
+	}}
+	zero := 0
+	for _, tc := range []struct {
+		name, content string
+		fuzz          PatchFuzz
+		want          string
+		fuzzed        []HunkFuzz
+	}{
+		{"exact", "a\nb\nc\nd\ne\n", PatchFuzz{}, "a\nb\nC\nd\ne\n", nil},
+		{"moved", "x\nx\na\nb\nc\nd\ne\n", PatchFuzz{}, "x\nx\na\nb\nC\nd\ne\n", []HunkFuzz{{Hunk: 1, Line: 1, Offset: 2}}},
+		{"moved too far", "x\nx\na\nb\nc\nd\ne\n", PatchFuzz{MaxOffset: 1}, "", nil},
+		{"context changed", "A\nb\nc\nd\nE\n", PatchFuzz{}, "A\nb\nC\nd\nE\n", []HunkFuzz{{Hunk: 1, Line: 1, Context: 2}}},
+		{"context required", "A\nb\nc\nd\nE\n", PatchFuzz{Context: &zero}, "", nil},
+		{"whitespace", "a\n  b\nc \nd\ne\n", PatchFuzz{IgnoreWhitespace: true}, "a\n  b\nC\nd\ne\n", []HunkFuzz{{Hunk: 1, Line: 1, Whitespace: true}}},
+		{"whitespace not ignored", "a\n  b\nc \nd\ne\n", PatchFuzz{Context: &zero}, "", nil},
+	} {
+		got, fuzzed, err := applyHunks(tc.content, []hunk{h}, tc.fuzz)
+		if tc.want == "" {
+			var he *hunkError
+			if !errors.As(err, &he) {
+				t.Errorf("%s: applyHunks = %q, %v, want the hunk to conflict", tc.name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s: applyHunks = %q, %v, want %q", tc.name, got, err, tc.want)
+			continue
+		}
+		if len(fuzzed) != len(tc.fuzzed) || len(fuzzed) > 0 && fuzzed[0] != tc.fuzzed[0] {
+			t.Errorf("%s: fuzz = %+v, want %+v", tc.name, fuzzed, tc.fuzzed)
+		}
+	}
+}
+
+func TestSyncRecordsPatchFuzz(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{"/c1/a.js": "new first line\none\ntwo\nthree\n"})
+	patch := "--- a/wpt/a.js\n+++ b/wpt/a.js\n@@ -1,3 +1,3 @@\n one\n-two\n+TWO\n three\n"
+	if err := os.MkdirAll(filepath.Join(dir, "patches"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "patches", "a.patch"), []byte(patch), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "a.js", Patch: "patches/a.patch"}},
+	})
+
+	var logs strings.Builder
+	opts := &SyncOptions{BaseURL: server.URL, Logf: func(format string, args ...any) { fmt.Fprintf(&logs, format, args...) }}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "a.js")); string(got) != "new first line\none\nTWO\nthree\n" {
+		t.Errorf("a.js = %q, want the patch applied one line down", got)
+	}
+	if !strings.Contains(logs.String(), "a.js: patch applied with fuzz (hunk #1 at line 1 of wpt/a.js, offset +1)") {
+		t.Errorf("logs do not mention the fuzz:\n%s", logs.String())
+	}
+
+	f, err := os.Open(filepath.Join(dir, "wpt.fuzz.jsonl"))
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	defer f.Close()
+	var records []FuzzRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec FuzzRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	want := HunkFuzz{File: "wpt/a.js", Hunk: 1, Line: 1, Offset: 1}
+	if len(records) != 1 || records[0].Dst != "a.js" || records[0].Commit != "c1" || len(records[0].Hunks) != 1 || records[0].Hunks[0] != want {
+		t.Errorf("audit log = %+v, want one record of %+v", records, want)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.PatchFuzz = &PatchFuzz{MaxOffset: -1}
+	if err := cfg.check(); err == nil || !strings.Contains(err.Error(), "max_offset") {
+		t.Errorf("check = %v, want a negative max_offset rejected", err)
+	}
+}
@@ -0,0 +1,77 @@
+package wptsync
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// GeneratedArea describes part of WPT that a script generates from smaller
+// inputs. Such files are often numerous and churn on every regeneration, so
+// add warns before vendoring them.
+type GeneratedArea struct {
+	// Pattern is a path.Match pattern compared against every run of whole
+	// path segments of a src, so "gen" matches any directory named gen and
+	// "html/canvas/element" that directory wherever it appears.
+	Pattern string `json:"pattern" wptsync:"required"`
+	// Hint names the generator or its inputs, to vendor instead.
+	Hint string `json:"hint,omitempty"`
+}
+
+// DefaultGeneratedAreas are the generated areas add warns about when the
+// configuration sets no generated_areas.
+var DefaultGeneratedAreas = []GeneratedArea{
+	{Pattern: "resources/generated"},
+	{Pattern: "gen", Hint: "generated from spec.src.json by common/security-features/tools/generate.py"},
+	{Pattern: "fetch/metadata/generated", Hint: "generated from fetch/metadata/tools/templates by fetch/metadata/tools/generate.py"},
+	{Pattern: "html/canvas/element", Hint: "generated from html/canvas/tools/yaml by html/canvas/tools/gentest.py"},
+	{Pattern: "html/canvas/offscreen", Hint: "generated from html/canvas/tools/yaml by html/canvas/tools/gentest.py"},
+}
+
+// generatedAreas returns the areas c warns about: its own, the defaults, or
+// none when warn_generated is false.
+func (c *Config) generatedAreas() []GeneratedArea {
+	switch {
+	case c.WarnGenerated != nil && !*c.WarnGenerated:
+		return nil
+	case len(c.GeneratedAreas) > 0:
+		return c.GeneratedAreas
+	}
+	return DefaultGeneratedAreas
+}
+
+// match reports whether src lies in the area.
+func (a GeneratedArea) match(src string) bool {
+	segments := strings.Split(src, "/")
+	n := strings.Count(a.Pattern, "/") + 1
+	for i := 0; i+n <= len(segments); i++ {
+		if ok, _ := path.Match(a.Pattern, strings.Join(segments[i:i+n], "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// generatedWarnings returns one message per generated area that srcs (the
+// files being added) reach into, in area order, counting the files and
+// naming the first.
+func (c *Config) generatedWarnings(srcs []string) []string {
+	var warnings []string
+	for _, area := range c.generatedAreas() {
+		var matched []string
+		for _, src := range srcs {
+			if area.match(src) {
+				matched = append(matched, src)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		w := fmt.Sprintf("%d added file(s), such as %s, look machine-generated (generated area %q)", len(matched), matched[0], area.Pattern)
+		if area.Hint != "" {
+			w += "; " + area.Hint
+		}
+		warnings = append(warnings, w+"; consider vendoring the generator's inputs instead")
+	}
+	return warnings
+}
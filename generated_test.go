@@ -0,0 +1,41 @@
+package wptsync
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratedWarnings(t *testing.T) {
+	srcs := []string{
+		"mixed-content/gen/top.http-rp/opt-in/fetch.https.html",
+		"mixed-content/gen/top.meta/unset/img-tag.https.html",
+		"html/canvas/element/path-objects/2d.path.arc.empty.html",
+		"url/resources/generated/setters.json",
+		"url/url-constructor.any.js",
+		"general/x.js",
+	}
+
+	got := (&Config{}).generatedWarnings(srcs)
+	if len(got) != 3 {
+		t.Fatalf("default warnings = %q, want resources/generated, gen, and html/canvas/element", got)
+	}
+	for i, want := range []string{
+		`1 added file(s), such as url/resources/generated/setters.json, look machine-generated (generated area "resources/generated")`,
+		`2 added file(s), such as mixed-content/gen/top.http-rp/opt-in/fetch.https.html, look machine-generated (generated area "gen"); generated from spec.src.json`,
+		`(generated area "html/canvas/element"); generated from html/canvas/tools/yaml`,
+	} {
+		if !strings.Contains(got[i], want) {
+			t.Errorf("warning %d = %q, want it to contain %q", i, got[i], want)
+		}
+	}
+
+	custom := &Config{GeneratedAreas: []GeneratedArea{{Pattern: "url/*.any.js", Hint: "see url/README"}}}
+	if got := custom.generatedWarnings(srcs); len(got) != 1 || !strings.Contains(got[0], "url/url-constructor.any.js") {
+		t.Errorf("custom warnings = %q, want only the configured area", got)
+	}
+
+	off := false
+	if got := (&Config{WarnGenerated: &off}).generatedWarnings(srcs); len(got) != 0 {
+		t.Errorf("warn_generated false: got %q", got)
+	}
+}
@@ -0,0 +1,687 @@
+package wptsync
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAPIURL is the default GitHub REST API base for the
+// web-platform-tests repository.
+const DefaultAPIURL = "https://api.github.com/repos/web-platform-tests/wpt"
+
+// compareFileLimit is the maximum number of files the compare API lists;
+// a response with this many files may have been cut short.
+const compareFileLimit = 300
+
+// ErrRateLimited marks GitHub API responses rejected by a rate limit.
+var ErrRateLimited = errors.New("GitHub API rate limit exceeded")
+
+// githubAPI issues REST requests against a single GitHub repository.
+type githubAPI struct {
+	client  *http.Client
+	baseURL string
+	token   string
+	repo    string
+	ref     string
+	// releases makes latestCommit follow the latest release; see
+	// Config.TrackReleases.
+	releases bool
+	budget   *apiBudget
+	// app, when set, mints the token requests are authenticated with; see
+	// SyncOptions.AppID. authErr is why its credentials are unusable.
+	app     *appTokens
+	authErr error
+}
+
+// token returns the token GitHub API requests are authenticated with:
+// Token, or the GITHUB_TOKEN environment variable when that is empty.
+func (o *SyncOptions) token() string {
+	if o != nil && o.Token != "" {
+		return o.Token
+	}
+	return os.Getenv("GITHUB_TOKEN")
+}
+
+// github returns the REST client the options describe. A GitHub App (see
+// SyncOptions.AppID) takes precedence over GITHUB_TOKEN, but not over Token.
+func (o *SyncOptions) github() *githubAPI {
+	g := &githubAPI{client: o.httpClient(), baseURL: DefaultAPIURL, repo: DefaultRepo, ref: DefaultRef}
+	if o == nil || o.Token == "" {
+		if o != nil {
+			g.app = o.app
+		}
+		if g.app == nil {
+			g.app, g.authErr = newAppTokens(o)
+		}
+	}
+	if g.app == nil && g.authErr == nil {
+		g.token = o.token()
+	}
+	if o != nil {
+		if o.APIURL != "" {
+			g.baseURL = o.APIURL
+		}
+		if o.repo != "" {
+			g.repo = o.repo
+		}
+		if o.ref != "" {
+			g.ref = o.ref
+		}
+		g.releases = o.releases
+		if g.budget = o.budget; g.budget == nil {
+			g.budget = newAPIBudget(o)
+		}
+	}
+	return g
+}
+
+// authToken returns the token g's requests are authenticated with, minting
+// an installation token when g authenticates as a GitHub App, or "" for
+// anonymous requests.
+func (g *githubAPI) authToken(ctx context.Context) (string, error) {
+	switch {
+	case g.authErr != nil:
+		return "", g.authErr
+	case g.app != nil:
+		return g.appToken(ctx)
+	}
+	return g.token, nil
+}
+
+// graphQL returns a GraphQL client for g's repository, sharing its budget,
+// or nil when GraphQL is unavailable (see newGraphQLClient).
+func (g *githubAPI) graphQL(ctx context.Context) *graphQLClient {
+	// An authentication error resurfaces from the REST requests made
+	// instead.
+	token, _ := g.authToken(ctx)
+	gql := newGraphQLClient(g.client, graphQLEndpoint(g.baseURL), token, g.repo)
+	if gql != nil {
+		gql.budget = g.budget
+	}
+	return gql
+}
+
+// rateLimitError returns an error wrapping ErrRateLimited, with the time the
+// limit resets, if resp was rejected by a primary or secondary rate limit.
+// Other 403s are reported as plain errors. authenticated selects the hint
+// appended to the message.
+func rateLimitError(resp *http.Response, authenticated bool) error {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+
+	hint := ""
+	if !authenticated {
+		hint = "; set GITHUB_TOKEN or pass -token to raise the limit"
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			at := time.Unix(reset, 0)
+			wait := time.Until(at).Round(time.Second)
+			return fmt.Errorf("%w: resets at %s (in %s)%s", ErrRateLimited, at.Format(time.DateTime), max(wait, 0), hint)
+		}
+		return fmt.Errorf("%w%s", ErrRateLimited, hint)
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return fmt.Errorf("%w: secondary limit, retry after %s%s", ErrRateLimited, time.Duration(secs)*time.Second, hint)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("%w%s", ErrRateLimited, hint)
+	}
+	return fmt.Errorf("%w%s", &statusError{code: resp.StatusCode, status: resp.Status, service: "GitHub API"}, hint)
+}
+
+// get fetches endpoint (relative to the repository API base) and decodes the
+// JSON response into out.
+func (g *githubAPI) get(ctx context.Context, endpoint string, out any) error {
+	return g.getStream(ctx, endpoint, func(dec *json.Decoder) error { return dec.Decode(out) })
+}
+
+// getStream fetches endpoint like get, but hands the response to decode as
+// it arrives, for responses too large to hold whole.
+func (g *githubAPI) getStream(ctx context.Context, endpoint string, decode func(*json.Decoder) error) error {
+	if err := g.budget.spend(); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.baseURL+"/"+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	token, err := g.authToken(ctx)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := rateLimitError(resp, token != ""); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode, status: resp.Status, service: "GitHub API"}
+	}
+
+	if err := decode(json.NewDecoder(resp.Body)); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// latestCommit returns the SHA of the head of the tracked ref, or of the
+// latest release's tag when the configuration tracks releases.
+func (g *githubAPI) latestCommit(ctx context.Context) (string, error) {
+	if g.releases {
+		tag, err := g.latestRelease(ctx)
+		if err != nil {
+			return "", err
+		}
+		return g.resolveRef(ctx, tag)
+	}
+	return g.resolveRef(ctx, g.ref)
+}
+
+// resolveRef returns the SHA of the commit ref (a branch, tag, or commit)
+// names.
+func (g *githubAPI) resolveRef(ctx context.Context, ref string) (string, error) {
+	var result struct {
+		SHA string `json:"sha"`
+	}
+	if err := g.get(ctx, "commits/"+escapePath(ref), &result); err != nil {
+		return "", err
+	}
+
+	if result.SHA == "" {
+		return "", errors.New("empty commit SHA in response")
+	}
+
+	return result.SHA, nil
+}
+
+// latestRelease returns the tag of the repository's latest release. WPT
+// publishes one, named merge_pr_<number>, for every merged pull request.
+func (g *githubAPI) latestRelease(ctx context.Context) (string, error) {
+	var result struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := g.get(ctx, "releases/latest", &result); err != nil {
+		return "", fmt.Errorf("fetch latest release: %w", err)
+	}
+	if result.TagName == "" {
+		return "", errors.New("latest release: no tag name in response")
+	}
+	return result.TagName, nil
+}
+
+// commitDate returns when sha was committed.
+func (g *githubAPI) commitDate(ctx context.Context, sha string) (time.Time, error) {
+	var result struct {
+		Commit struct {
+			Committer struct {
+				Date time.Time `json:"date"`
+			} `json:"committer"`
+		} `json:"commit"`
+	}
+	if err := g.get(ctx, "commits/"+escapePath(sha), &result); err != nil {
+		return time.Time{}, fmt.Errorf("fetch commit %s: %w", shortSHA(sha), err)
+	}
+	if result.Commit.Committer.Date.IsZero() {
+		return time.Time{}, fmt.Errorf("commit %s: no commit date in response", shortSHA(sha))
+	}
+	return result.Commit.Committer.Date, nil
+}
+
+type treeEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	SHA  string `json:"sha"`
+}
+
+type treeResponse struct {
+	Tree      []treeEntry `json:"tree"`
+	Truncated bool        `json:"truncated"`
+}
+
+// tree lists the tree sha, recursively or not, keeping only the entries keep
+// accepts (all of them when keep is nil). The response is decoded one entry
+// at a time, so that a recursive listing of a large directory, which can run
+// to tens of megabytes, takes only the memory of the entries kept.
+func (g *githubAPI) tree(ctx context.Context, sha string, recursive bool, keep func(treeEntry) bool) (*treeResponse, error) {
+	endpoint := "git/trees/" + sha
+	if recursive {
+		endpoint += "?recursive=1"
+	}
+
+	tree := &treeResponse{Tree: []treeEntry{}}
+	err := g.getStream(ctx, endpoint, func(dec *json.Decoder) error {
+		return tree.decode(dec, keep)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// decode reads a tree response from dec into t, keeping the entries keep
+// accepts. Keys other than "tree" and "truncated" are skipped.
+func (t *treeResponse) decode(dec *json.Decoder, keep func(treeEntry) bool) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "tree":
+			if err := expectDelim(dec, '['); err != nil {
+				return err
+			}
+			for dec.More() {
+				var entry treeEntry
+				if err := dec.Decode(&entry); err != nil {
+					return err
+				}
+				if keep == nil || keep(entry) {
+					t.Tree = append(t.Tree, entry)
+				}
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return err
+			}
+		case "truncated":
+			if err := dec.Decode(&t.Truncated); err != nil {
+				return err
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// expectDelim reads the next token of dec, which must be the delimiter d.
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != d {
+		return fmt.Errorf("expected %v, found %v", d, tok)
+	}
+	return nil
+}
+
+// isBlob keeps the file entries of a tree listing.
+func isBlob(entry treeEntry) bool {
+	return entry.Type == "blob"
+}
+
+// listFiles returns every blob under pathPrefix at commit (or pathPrefix
+// itself when it names a blob), as paths relative to the repository root.
+func (g *githubAPI) listFiles(ctx context.Context, commit, pathPrefix string) ([]string, error) {
+	blobs, err := g.listBlobs(ctx, commit, pathPrefix)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, len(blobs))
+	for i, b := range blobs {
+		files[i] = b.Path
+	}
+	return files, nil
+}
+
+// listBlobs is listFiles, returning the tree entry of each blob with its
+// path relative to the repository root.
+func (g *githubAPI) listBlobs(ctx context.Context, commit, pathPrefix string) ([]treeEntry, error) {
+	// Walk the path segments to the subtree (or single blob), then list that
+	// subtree with one recursive request instead of one request per directory.
+	// With a token, GraphQL resolves the whole path in a single request.
+	sha := commit
+	var segments []string
+	if pathPrefix != "" {
+		segments = strings.Split(pathPrefix, "/")
+	}
+	if gql := g.graphQL(ctx); gql != nil && len(segments) > 0 {
+		entry, err := gql.resolvePath(ctx, commit, pathPrefix)
+		var notFound *pathNotFoundError
+		switch {
+		case errors.As(err, &notFound):
+			// Walk the tree after all, to find what the path was meant to be.
+		case err != nil:
+			return nil, err
+		case entry.Type == "blob":
+			return []treeEntry{{Path: pathPrefix, Type: "blob", SHA: entry.SHA}}, nil
+		default:
+			sha = entry.SHA
+			segments = nil
+		}
+	}
+	for i, segment := range segments {
+		tree, err := g.tree(ctx, sha, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		var entry *treeEntry
+		for j := range tree.Tree {
+			if tree.Tree[j].Path == segment {
+				entry = &tree.Tree[j]
+				break
+			}
+		}
+		if entry == nil {
+			return nil, &pathNotFoundError{Path: pathPrefix, Suggestions: suggestPaths(tree.Tree, segments, i)}
+		}
+		if entry.Type == "commit" {
+			return nil, fmt.Errorf("%w: %s points into another repository", ErrSubmodule, strings.Join(segments[:i+1], "/"))
+		}
+		if entry.Type == "blob" {
+			if i != len(segments)-1 {
+				return nil, fmt.Errorf("%q is a file, not a directory", strings.Join(segments[:i+1], "/"))
+			}
+			return []treeEntry{{Path: pathPrefix, Type: "blob", SHA: entry.SHA}}, nil
+		}
+		sha = entry.SHA
+	}
+
+	tree, err := g.tree(ctx, sha, true, isBlob)
+	if err != nil {
+		return nil, err
+	}
+	if tree.Truncated {
+		// The recursive listing is capped; walk the subtree one directory
+		// at a time instead. Slower, but complete.
+		return g.walkTree(ctx, sha, pathPrefix)
+	}
+
+	for i := range tree.Tree {
+		tree.Tree[i].Path = path.Join(pathPrefix, tree.Tree[i].Path)
+	}
+	return tree.Tree, nil
+}
+
+// pathNotFoundError reports a path missing from the repository, with the
+// paths it may have been meant as.
+type pathNotFoundError struct {
+	Path string
+	// Suggestions replace the first missing path segment with similarly
+	// named entries of its parent directory, closest first.
+	Suggestions []string
+}
+
+func (e *pathNotFoundError) Error() string {
+	msg := fmt.Sprintf("path %q not found in repository", e.Path)
+	if len(e.Suggestions) > 0 {
+		msg += "; did you mean " + strings.Join(e.Suggestions, " or ") + "?"
+	}
+	return msg
+}
+
+// caseFix returns the suggestion that differs from the missing path only in
+// case, if there is exactly one. WPT's directory names are cased
+// inconsistently (FileAPI, html, IndexedDB), so such a typo is common.
+func (e *pathNotFoundError) caseFix() (string, bool) {
+	var fix string
+	for _, s := range e.Suggestions {
+		if strings.EqualFold(strings.TrimSuffix(s, "/"), e.Path) {
+			if fix != "" {
+				return "", false
+			}
+			fix = strings.TrimSuffix(s, "/")
+		}
+	}
+	return fix, fix != ""
+}
+
+// maxSuggestions caps the near matches a path-not-found error lists.
+const maxSuggestions = 3
+
+// suggestPaths returns segments with segments[i], which the directory
+// listing entries lacks, replaced by each entry named like it: the same name
+// in another case, or one a few edits away. Directories end in "/".
+func suggestPaths(entries []treeEntry, segments []string, i int) []string {
+	want := strings.ToLower(segments[i])
+	type match struct {
+		name string
+		dist int
+	}
+	var matches []match
+	for _, e := range entries {
+		d := editDistance(want, strings.ToLower(e.Path))
+		prefix := len(want) >= 3 && strings.HasPrefix(strings.ToLower(e.Path), want)
+		if d > max(len(want)/4, 1) && !prefix {
+			continue
+		}
+		name := e.Path
+		if e.Type == "tree" && i == len(segments)-1 {
+			name += "/"
+		}
+		matches = append(matches, match{name, d})
+	}
+	slices.SortStableFunc(matches, func(a, b match) int { return cmp.Compare(a.dist, b.dist) })
+
+	var out []string
+	for _, m := range matches[:min(len(matches), maxSuggestions)] {
+		p := slices.Clone(segments)
+		p[i] = m.name
+		out = append(out, strings.Join(p, "/"))
+	}
+	return out
+}
+
+// editDistance returns the Levenshtein distance between a and b, in bytes.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// walkTree lists the blobs under the tree sha (located at dir) with one
+// non-recursive request per directory.
+func (g *githubAPI) walkTree(ctx context.Context, sha, dir string) ([]treeEntry, error) {
+	tree, err := g.tree(ctx, sha, false, func(entry treeEntry) bool { return entry.Type != "commit" })
+	if err != nil {
+		return nil, err
+	}
+	if tree.Truncated {
+		return nil, fmt.Errorf("GitHub truncated the tree listing for %q even without recursion", dir)
+	}
+
+	var blobs []treeEntry
+	for _, entry := range tree.Tree {
+		entry.Path = path.Join(dir, entry.Path)
+		switch {
+		case entry.Type == "tree":
+			sub, err := g.walkTree(ctx, entry.SHA, entry.Path)
+			if err != nil {
+				return nil, err
+			}
+			blobs = append(blobs, sub...)
+		case entry.Type == "blob":
+			blobs = append(blobs, entry)
+		}
+	}
+	return blobs, nil
+}
+
+// contentType returns the type the contents API reports for p at commit:
+// "file", "dir", "symlink", or "submodule".
+func (g *githubAPI) contentType(ctx context.Context, commit, p string) (string, error) {
+	var raw json.RawMessage
+	if err := g.get(ctx, "contents/"+escapePath(p)+"?ref="+url.QueryEscape(commit), &raw); err != nil {
+		return "", err
+	}
+	// Directories are listed as an array of their entries.
+	var entry struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return "dir", nil
+	}
+	return entry.Type, nil
+}
+
+// changedPaths returns the set of paths added, modified, removed, or renamed
+// (under either name) between base and head, from compare. complete is false
+// when the compare API may have truncated the file list, in which case
+// callers must not rely on a path's absence.
+func (g *githubAPI) changedPaths(ctx context.Context, base, head string) (changed map[string]bool, complete bool, err error) {
+	files, complete, err := g.compare(ctx, base, head)
+	if err != nil {
+		return nil, false, err
+	}
+	changed = make(map[string]bool, len(files))
+	for _, f := range files {
+		changed[f.Filename] = true
+		if f.PreviousFilename != "" {
+			changed[f.PreviousFilename] = true
+		}
+	}
+	return changed, complete, nil
+}
+
+// compareFile is a file the compare API lists as changed between two
+// commits.
+type compareFile struct {
+	Filename         string `json:"filename"`
+	PreviousFilename string `json:"previous_filename"`
+	// Status is "added", "modified", "removed", "renamed", or more rarely
+	// "copied" or "changed".
+	Status string `json:"status"`
+}
+
+// compare lists the files changed between base and head, and whether the
+// list is complete.
+func (g *githubAPI) compare(ctx context.Context, base, head string) (files []compareFile, complete bool, err error) {
+	var result struct {
+		Files []compareFile `json:"files"`
+	}
+	if err := g.get(ctx, "compare/"+base+"..."+head, &result); err != nil {
+		return nil, false, fmt.Errorf("compare %s...%s: %w", shortSHA(base), shortSHA(head), err)
+	}
+	return result.Files, len(result.Files) < compareFileLimit, nil
+}
+
+// pathCommit is a commit in the history of a path.
+type pathCommit struct {
+	SHA     string `json:"sha"`
+	HTMLURL string `json:"html_url"`
+	Commit  struct {
+		Message string `json:"message"`
+	} `json:"commit"`
+}
+
+// pathCommits returns the commits on head's history, newest first, that
+// touched p since t (up to 100 of them).
+func (g *githubAPI) pathCommits(ctx context.Context, head, p string, t time.Time) ([]pathCommit, error) {
+	query := url.Values{
+		"sha":      {head},
+		"path":     {p},
+		"since":    {t.UTC().Format(time.RFC3339)},
+		"per_page": {"100"},
+	}
+	var commits []pathCommit
+	if err := g.get(ctx, "commits?"+query.Encode(), &commits); err != nil {
+		return nil, fmt.Errorf("list commits touching %s: %w", p, err)
+	}
+	return commits, nil
+}
+
+// addedSince returns the set of the files listed, under wptPath at head,
+// that were not there at since: a commit, or a date ("2006-01-02" or RFC
+// 3339) standing for the last commit before it on head's history. It reads
+// the tree of wptPath at that commit, so it takes the same requests however
+// much of the rest of the repository changed since; a path that did not
+// exist then makes every listed file new.
+func (g *githubAPI) addedSince(ctx context.Context, since, head, wptPath string, listed []string) (map[string]bool, error) {
+	base := since
+	if t, ok := parseSinceDate(since); ok {
+		var err error
+		if base, err = g.commitBefore(ctx, head, t); err != nil {
+			return nil, err
+		}
+	}
+
+	before, err := g.listFiles(ctx, base, wptPath)
+	var notFound *pathNotFoundError
+	if err != nil && !errors.As(err, &notFound) {
+		return nil, fmt.Errorf("list %s at %s: %w", wptPath, shortSHA(base), err)
+	}
+	existed := make(map[string]bool, len(before))
+	for _, p := range before {
+		existed[p] = true
+	}
+	added := make(map[string]bool)
+	for _, p := range listed {
+		if !existed[p] {
+			added[p] = true
+		}
+	}
+	return added, nil
+}
+
+// commitBefore returns the newest commit on head's history made before t.
+func (g *githubAPI) commitBefore(ctx context.Context, head string, t time.Time) (string, error) {
+	query := url.Values{
+		"sha":      {head},
+		"until":    {t.UTC().Format(time.RFC3339)},
+		"per_page": {"1"},
+	}
+	var commits []struct {
+		SHA string `json:"sha"`
+	}
+	if err := g.get(ctx, "commits?"+query.Encode(), &commits); err != nil {
+		return "", fmt.Errorf("find commit before %s: %w", t.Format(time.RFC3339), err)
+	}
+	if len(commits) == 0 || commits[0].SHA == "" {
+		return "", fmt.Errorf("no commit before %s", t.Format(time.RFC3339))
+	}
+	return commits[0].SHA, nil
+}
+
+// parseSinceDate parses s as a date or an RFC 3339 timestamp.
+func parseSinceDate(s string) (time.Time, bool) {
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
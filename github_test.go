@@ -0,0 +1,202 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAPIFixture starts a server answering GitHub REST requests from
+// responses, keyed by request URI (path plus query) relative to the
+// returned API base URL.
+func newAPIFixture(t *testing.T, responses map[string]string) (apiURL string, requestCount func() int) {
+	t.Helper()
+
+	count := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		body, ok := responses[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL + "/repos/o/n", func() int { return count }
+}
+
+func TestAddFallsBackWhenTreeIsTruncated(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1":                `{"tree":[{"path":"url","type":"tree","sha":"t-url"}]}`,
+		"/repos/o/n/git/trees/t-url?recursive=1": `{"tree":[],"truncated":true}`,
+		"/repos/o/n/git/trees/t-url":             `{"tree":[{"path":"a.any.js","type":"blob"},{"path":"data.json","type":"blob"},{"path":"resources","type":"tree","sha":"t-res"}]}`,
+		"/repos/o/n/git/trees/t-res":             `{"tree":[{"path":"helper.js","type":"blob"}]}`,
+	})
+
+	dir := t.TempDir()
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt"})
+
+	if err := Add(context.Background(), configPath, "url/", &AddOptions{SyncOptions: SyncOptions{APIURL: apiURL}}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range cfg.Files {
+		got = append(got, f.Src+" -> "+f.Dst)
+	}
+	want := []string{
+		"url/a.any.js -> url/a.js",
+		"url/resources/helper.js -> url/resources/helper.js",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
+
+func TestListFilesStreamsLargeTree(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	var b strings.Builder
+	b.WriteString(`{"sha":"t-url","url":"https://api.github.com/x","tree":[`)
+	for i := range 5000 {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		typ := "blob"
+		switch i % 5 {
+		case 3:
+			typ = "tree"
+		case 4:
+			typ = "commit"
+		}
+		fmt.Fprintf(&b, `{"path":"d%d/f%d.js","mode":"100644","type":%q,"sha":"s%d","size":12,"url":"https://api.github.com/x"}`, i/100, i, typ, i)
+	}
+	b.WriteString(`],"truncated":false}`)
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1":                `{"sha":"c1","tree":[{"path":"url","type":"tree","sha":"t-url"}],"truncated":false}`,
+		"/repos/o/n/git/trees/t-url?recursive=1": b.String(),
+		"/repos/o/n/git/trees/c2":                `{"sha":"c2","tree":[{"path":"url","type":"tree","sha":"t-bad"}]}`,
+		"/repos/o/n/git/trees/t-bad?recursive=1": `{"tree":[{"path":"a.js","type":"blob"},{"path":`,
+	})
+	gh := (&SyncOptions{APIURL: apiURL}).github()
+
+	files, err := gh.listFiles(context.Background(), "c1", "url")
+	if err != nil {
+		t.Fatalf("listFiles: %v", err)
+	}
+	if len(files) != 3000 || files[0] != "url/d0/f0.js" || files[2] != "url/d0/f2.js" || files[3] != "url/d0/f5.js" {
+		t.Errorf("listFiles returned %d files starting %v, want the 3000 blobs", len(files), files[:min(len(files), 4)])
+	}
+
+	if _, err := gh.listFiles(context.Background(), "c2", "url"); err == nil || !strings.Contains(err.Error(), "decode response") {
+		t.Errorf("listFiles of a cut-off listing = %v, want a decode error", err)
+	}
+}
+
+func TestGitHubAPIReportsRateLimitReset(t *testing.T) {
+	var auth string
+	reset := time.Now().Add(10 * time.Minute).Unix()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(srv.Close)
+
+	configPath := saveTestConfig(t, t.TempDir(), &Config{Commit: "c1", TargetDir: "wpt"})
+	_, _, err := CheckUpdate(context.Background(), configPath, &SyncOptions{APIURL: srv.URL, Token: "secret"})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+	if want := time.Unix(reset, 0).Format(time.DateTime); !strings.Contains(err.Error(), want) {
+		t.Errorf("err = %q, want reset time %s", err, want)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q, want the -token value", auth)
+	}
+}
+
+func TestAddSinceDateOnlyAddsNewFiles(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c2":                                             `{"tree":[{"path":"url","type":"tree","sha":"t-url"},{"path":"css","type":"tree","sha":"t-css"}]}`,
+		"/repos/o/n/git/trees/t-url?recursive=1":                              `{"tree":[{"path":"old.js","type":"blob"},{"path":"new.js","type":"blob"},{"path":"edited.js","type":"blob"}]}`,
+		"/repos/o/n/git/trees/t-css?recursive=1":                              `{"tree":[{"path":"other.js","type":"blob"}]}`,
+		"/repos/o/n/commits?per_page=1&sha=c2&until=2024-05-01T00%3A00%3A00Z": `[{"sha":"c1"}]`,
+		// At c1, url held old.js and edited.js, and css did not exist yet.
+		"/repos/o/n/git/trees/c1":                   `{"tree":[{"path":"url","type":"tree","sha":"t-url-c1"}]}`,
+		"/repos/o/n/git/trees/t-url-c1?recursive=1": `{"tree":[{"path":"old.js","type":"blob"},{"path":"edited.js","type":"blob"}]}`,
+	})
+
+	dir := t.TempDir()
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c2", TargetDir: "wpt"})
+
+	opts := &AddOptions{SyncOptions: SyncOptions{APIURL: apiURL}, Since: "2024-05-01"}
+	if err := Add(context.Background(), configPath, "url", opts); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Files) != 1 || cfg.Files[0].Src != "url/new.js" {
+		t.Errorf("files = %+v, want only url/new.js", cfg.Files)
+	}
+
+	if err := Add(context.Background(), configPath, "css", opts); err != nil {
+		t.Fatalf("Add of a directory new since: %v", err)
+	}
+	if cfg, err = LoadConfig(configPath); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Files) != 2 || cfg.Files[1].Src != "css/other.js" {
+		t.Errorf("files = %+v, want css/other.js added too", cfg.Files)
+	}
+}
+
+func TestAddFoldsPathCaseAndSuggestsNearMatches(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1":                 `{"tree":[{"path":"FileAPI","type":"tree","sha":"t-file"},{"path":"encoding","type":"tree","sha":"t-enc"},{"path":"fetch","type":"tree","sha":"t-fetch"}]}`,
+		"/repos/o/n/git/trees/t-file":             `{"tree":[{"path":"blob","type":"tree","sha":"t-blob"}]}`,
+		"/repos/o/n/git/trees/t-blob?recursive=1": `{"tree":[{"path":"Blob-slice.any.js","type":"blob"}]}`,
+	})
+	dir := t.TempDir()
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt"})
+	opts := &AddOptions{SyncOptions: SyncOptions{APIURL: apiURL}}
+
+	if err := Add(context.Background(), configPath, "fileapi/blob", opts); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Files) != 1 || cfg.Files[0].Src != "FileAPI/blob/Blob-slice.any.js" {
+		t.Errorf("files = %+v, want FileAPI/blob/Blob-slice.any.js", cfg.Files)
+	}
+
+	err = Add(context.Background(), configPath, "encodng", opts)
+	if err == nil || !strings.Contains(err.Error(), "did you mean encoding/?") {
+		t.Errorf("Add(encodng) = %v, want a suggestion of encoding/", err)
+	}
+}
@@ -0,0 +1,222 @@
+package wptsync
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// isGlob reports whether src is a pattern (in path.Match syntax) rather than
+// a single path.
+func isGlob(src string) bool {
+	return strings.ContainsAny(src, "*?[")
+}
+
+// globBase returns the leading directories of pattern that contain no
+// pattern characters: the directory that has to be listed to expand it.
+func globBase(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, s := range segments {
+		if isGlob(s) {
+			return strings.Join(segments[:i], "/")
+		}
+	}
+	return path.Dir(pattern)
+}
+
+// matchGlob reports whether src matches pattern. Like path.Match, a "*"
+// never crosses a "/".
+func matchGlob(pattern, src string) bool {
+	ok, _ := path.Match(pattern, src)
+	return ok
+}
+
+// matchFilter reports whether p matches pattern. Patterns containing a "/"
+// are matched against the whole path, where a "**" segment matches any
+// number of directories, so "url/**" matches everything below url; others
+// are matched against its base name only, so "*.json" matches at any depth.
+func matchFilter(pattern, p string) bool {
+	if strings.Contains(pattern, "/") {
+		return matchSegments(strings.Split(pattern, "/"), strings.Split(p, "/"))
+	}
+	return matchGlob(pattern, path.Base(p))
+}
+
+// matchSegments matches the segments of a path against those of a pattern,
+// a "**" segment standing for zero or more of them.
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(segments); i >= 0; i-- {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 || !matchGlob(pattern[0], segments[0]) {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+func (c *Config) hasGlobs() bool {
+	for _, f := range c.Files {
+		if isGlob(f.Src) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandGlobs returns a copy of cfg whose glob entries are replaced by one
+// entry per file at cfg.Commit that they match, listing the repository
+// through the GitHub API, or the bundle or clone the sync reads. Matches
+// are named by dst_script (or the default naming) when the entry has no dst
+// of its own; otherwise its dst is the directory they are placed in,
+// keeping their path below the pattern's base.
+// Paths listed explicitly, or matched by an earlier glob, are not repeated.
+// The upstream documentation of the files (see UpstreamDocs) is added last.
+func expandGlobs(ctx context.Context, root string, cfg *Config, opts *SyncOptions) (*Config, error) {
+	cfg = withLicense(cfg)
+	if !cfg.hasGlobs() {
+		return expandDocs(ctx, root, cfg, opts)
+	}
+
+	ctx, cancel := withTimeout(ctx, opts.timeouts().Resolve)
+	defer cancel()
+
+	gh := opts.github()
+	bundle := opts.bundlePath(root, cfg)
+	checkout := opts.sourcePath(root, cfg, gitScheme)
+	listed := make(map[string][]string)
+	seen := explicitSrcs(cfg)
+	out := *cfg
+	out.Files = nil
+	for _, spec := range cfg.Files {
+		if !isGlob(spec.Src) {
+			out.Files = append(out.Files, spec)
+			continue
+		}
+
+		base := globBase(spec.Src)
+		if bundle != "" {
+			// One listing of the whole bundle serves every pattern.
+			base = ""
+		}
+		files, ok := listed[base]
+		if !ok {
+			var err error
+			switch {
+			case bundle != "":
+				files, err = bundleFiles(ctx, bundle)
+			case checkout != "":
+				files, err = checkoutFiles(ctx, checkout, cfg.Commit, base)
+			default:
+				files, err = gh.listFiles(ctx, cfg.Commit, base)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("expand %s: %w", spec.Src, err)
+			}
+			listed[base] = files
+		}
+
+		var srcs []string
+		for _, f := range files {
+			if matchGlob(spec.Src, f) && !seen[f] {
+				seen[f] = true
+				srcs = append(srcs, f)
+			}
+		}
+
+		var dsts []string
+		if spec.Dst == spec.Src {
+			var err error
+			if dsts, err = cfg.nameFiles(ctx, root, srcs); err != nil {
+				return nil, err
+			}
+		} else {
+			for _, src := range srcs {
+				rel := strings.TrimPrefix(src, base+"/")
+				dsts = append(dsts, path.Join(spec.Dst, rel))
+			}
+		}
+		for i, src := range srcs {
+			out.Files = append(out.Files, FileSpec{Src: src, Dst: dsts[i], Enabled: spec.Enabled, Transforms: spec.Transforms, Provenance: spec.Provenance, ValidateCmd: spec.ValidateCmd})
+		}
+	}
+
+	if err := out.validate(); err != nil {
+		return nil, err
+	}
+	return expandDocs(ctx, root, &out, opts)
+}
+
+// expandGlobsFromLock is the offline counterpart of expandGlobs: glob
+// entries are replaced by the files the last sync recorded for them in lock.
+// It only applies when the lock was written for cfg's commit; otherwise cfg
+// is returned as is. Upstream documentation is added the same way (see
+// docsFromLock), and so is the license of Notices.
+func expandGlobsFromLock(cfg *Config, lock *lockFile) *Config {
+	cfg = withLicense(cfg)
+	if !cfg.hasGlobs() || lock.Commit != cfg.Commit {
+		return docsFromLock(cfg, lock)
+	}
+
+	dsts := make([]string, 0, len(lock.Files))
+	for dst := range lock.Files {
+		dsts = append(dsts, dst)
+	}
+	sort.Strings(dsts)
+
+	seen := explicitSrcs(cfg)
+	out := *cfg
+	out.Files = nil
+	for _, spec := range cfg.Files {
+		if !isGlob(spec.Src) {
+			out.Files = append(out.Files, spec)
+			continue
+		}
+		for _, dst := range dsts {
+			src := lock.Files[dst].Src
+			if matchGlob(spec.Src, src) && !seen[src] {
+				seen[src] = true
+				out.Files = append(out.Files, FileSpec{Src: src, Dst: dst, Enabled: spec.Enabled, Transforms: spec.Transforms, Provenance: spec.Provenance, ValidateCmd: spec.ValidateCmd})
+			}
+		}
+	}
+	return docsFromLock(&out, lock)
+}
+
+// explicitSrcs returns the set of srcs cfg lists without a pattern.
+func explicitSrcs(cfg *Config) map[string]bool {
+	srcs := make(map[string]bool, len(cfg.Files))
+	for _, f := range cfg.Files {
+		if !isGlob(f.Src) {
+			srcs[f.Src] = true
+		}
+	}
+	return srcs
+}
+
+// resolveFileSpec is findFileSpec extended to files matched by a glob entry
+// (as recorded in lock). Such a file is appended to cfg.Files as an explicit
+// entry, which then takes precedence over the glob, so that it can carry a
+// patch of its own.
+func resolveFileSpec(cfg *Config, lock *lockFile, filePath string) (*FileSpec, error) {
+	file, err := findFileSpec(cfg, filePath)
+	if err == nil || !cfg.hasGlobs() {
+		return file, err
+	}
+	expanded := expandGlobsFromLock(cfg, lock)
+	match, lerr := findFileSpec(expanded, filePath)
+	if lerr != nil {
+		return nil, err
+	}
+	cfg.Files = append(cfg.Files, *match)
+	return &cfg.Files[len(cfg.Files)-1], nil
+}
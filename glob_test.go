@@ -0,0 +1,103 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSyncExpandsGlobEntries(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	content := map[string]string{
+		"/c1/url/resources/urltestdata.json": "[]\n",
+		"/c1/url/resources/setters.json":     "{}\n",
+	}
+	server, dir, downloads := newFixture(t, content)
+	apiURL, apiRequests := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1":                `{"tree":[{"path":"url","type":"tree","sha":"t-url"}]}`,
+		"/repos/o/n/git/trees/t-url":             `{"tree":[{"path":"resources","type":"tree","sha":"t-res"}]}`,
+		"/repos/o/n/git/trees/t-res?recursive=1": `{"tree":[{"path":"urltestdata.json","type":"blob"},{"path":"setters.json","type":"blob"},{"path":"helper.js","type":"blob"}]}`,
+	})
+
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files: []FileSpec{
+			{Src: "url/resources/setters.json", Dst: "setters.json"},
+			{Src: "url/resources/*.json", Dst: "data"},
+		},
+	})
+	opts := &SyncOptions{BaseURL: server.URL, APIURL: apiURL}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	for _, rel := range []string{"setters.json", "data/urltestdata.json"} {
+		if _, err := os.Stat(filepath.Join(dir, "wpt", rel)); err != nil {
+			t.Errorf("%s not synced: %v", rel, err)
+		}
+	}
+	// The explicit entry wins over the glob; helper.js doesn't match.
+	for _, rel := range []string{"data/setters.json", "data/helper.js"} {
+		if _, err := os.Stat(filepath.Join(dir, "wpt", rel)); err == nil {
+			t.Errorf("%s synced, want it left out", rel)
+		}
+	}
+
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	// A repeat sync is answered by the stamp, without expanding again.
+	before, beforeAPI := downloads(), apiRequests()
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if downloads() != before || apiRequests() != beforeAPI {
+		t.Errorf("second sync made %d downloads and %d API requests, want none", downloads()-before, apiRequests()-beforeAPI)
+	}
+}
+
+func TestAddIncludeExclude(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1":                `{"tree":[{"path":"url","type":"tree","sha":"t-url"}]}`,
+		"/repos/o/n/git/trees/t-url?recursive=1": `{"tree":[{"path":"a.any.js","type":"blob"},{"path":"resources/urltestdata.json","type":"blob"},{"path":"resources/a-expected.json","type":"blob"},{"path":"resources/b.sub.js","type":"blob"}]}`,
+	})
+
+	dir := t.TempDir()
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt"})
+
+	opts := &AddOptions{
+		SyncOptions: SyncOptions{APIURL: apiURL},
+		Include:     []string{"*.json", "url/resources/*.sub.js"},
+		Exclude:     []string{"*-expected*"},
+	}
+	if err := Add(context.Background(), configPath, "url", opts); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range cfg.Files {
+		got = append(got, f.Src)
+	}
+	want := []string{"url/resources/urltestdata.json", "url/resources/b.sub.js"}
+	if !slices.Equal(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
+
+func TestConfigRejectsPatchOnGlob(t *testing.T) {
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "url/*.js", Dst: "url/*.js", Patch: "p.patch"}}}
+	if err := cfg.validate(); err == nil {
+		t.Error("validate accepted a patch on a glob entry")
+	}
+}
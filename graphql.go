@@ -0,0 +1,235 @@
+package wptsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// graphQLBatchSize caps how many aliased lookups go into a single query.
+// GitHub bounds query cost per request, and blob texts can be large, so
+// batches stay well below the documented node limit.
+const graphQLBatchSize = 50
+
+// graphQLClient issues batched queries against the GitHub GraphQL API. GitHub
+// rejects anonymous GraphQL requests, so callers fall back to the REST API
+// when no token is available.
+type graphQLClient struct {
+	http     *http.Client
+	endpoint string
+	token    string
+	owner    string
+	name     string
+	budget   *apiBudget
+}
+
+// newGraphQLClient returns a client for repo ("owner/name") at endpoint that
+// sends its requests through client, or nil when token is empty.
+func newGraphQLClient(client *http.Client, endpoint, token, repo string) *graphQLClient {
+	if token == "" {
+		return nil
+	}
+	owner, name, _ := strings.Cut(repo, "/")
+	return &graphQLClient{
+		http:     client,
+		endpoint: endpoint,
+		token:    token,
+		owner:    owner,
+		name:     name,
+	}
+}
+
+// graphQLEndpoint derives the GraphQL endpoint from a REST repository API
+// URL: https://api.github.com/repos/o/n becomes https://api.github.com/graphql
+// and GitHub Enterprise's https://host/api/v3/repos/o/n becomes
+// https://host/api/graphql.
+func graphQLEndpoint(apiURL string) string {
+	base, _, _ := strings.Cut(apiURL, "/repos/")
+	base = strings.TrimSuffix(base, "/v3")
+	return base + "/graphql"
+}
+
+// query runs a single GraphQL request and decodes its data field into out.
+func (c *graphQLClient) query(ctx context.Context, query string, vars map[string]any, out any) error {
+	if err := c.budget.spend(); err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]any{"query": query, "variables": vars})
+	if err != nil {
+		return fmt.Errorf("encode query: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "bearer "+c.token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := rateLimitError(resp, true); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode, status: resp.Status, service: "GitHub GraphQL API"}
+	}
+
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		msgs := make([]string, len(result.Errors))
+		for i, e := range result.Errors {
+			msgs[i] = e.Message
+		}
+		return fmt.Errorf("GitHub GraphQL API: %s", strings.Join(msgs, "; "))
+	}
+	if len(result.Data) == 0 {
+		return errors.New("empty data in GraphQL response")
+	}
+
+	return json.Unmarshal(result.Data, out)
+}
+
+// graphQLObject is the subset of a GitObject the batched queries select.
+type graphQLObject struct {
+	Typename string `json:"__typename"`
+	OID      string `json:"oid"`
+	ByteSize int64  `json:"byteSize"`
+	IsBinary bool   `json:"isBinary"`
+	Text     string `json:"text"`
+	Entries  []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		OID  string `json:"oid"`
+	} `json:"entries"`
+}
+
+// resolvePath looks up the tree entry for p at commit in a single request,
+// replacing the one-request-per-path-segment walk the REST API needs.
+func (c *graphQLClient) resolvePath(ctx context.Context, commit, p string) (*treeEntry, error) {
+	const q = `query($owner: String!, $name: String!, $expr: String!) {
+  repository(owner: $owner, name: $name) {
+    object(expression: $expr) { __typename oid }
+  }
+}`
+	var data struct {
+		Repository struct {
+			Object *graphQLObject `json:"object"`
+		} `json:"repository"`
+	}
+	vars := map[string]any{"owner": c.owner, "name": c.name, "expr": commit + ":" + p}
+	if err := c.query(ctx, q, vars, &data); err != nil {
+		return nil, err
+	}
+
+	obj := data.Repository.Object
+	if obj == nil {
+		return nil, &pathNotFoundError{Path: p}
+	}
+	return &treeEntry{Path: p, Type: strings.ToLower(obj.Typename), SHA: obj.OID}, nil
+}
+
+// blobInfo is the content and metadata of a single blob fetched by
+// fetchBlobs. Text is empty for binary blobs or blobs GitHub considers too
+// large to inline.
+type blobInfo struct {
+	OID      string
+	Size     int64
+	IsBinary bool
+	Text     string
+}
+
+// fetchBlobs fetches the blobs at paths (relative to the repository root) at
+// commit, batching up to graphQLBatchSize paths per request. Paths that do
+// not exist or are not blobs are omitted from the result.
+func (c *graphQLClient) fetchBlobs(ctx context.Context, commit string, paths []string) (map[string]blobInfo, error) {
+	result := make(map[string]blobInfo, len(paths))
+	for start := 0; start < len(paths); start += graphQLBatchSize {
+		batch := paths[start:min(start+graphQLBatchSize, len(paths))]
+
+		var q strings.Builder
+		q.WriteString("query($owner: String!, $name: String!) {\n  repository(owner: $owner, name: $name) {\n")
+		for i, p := range batch {
+			fmt.Fprintf(&q, "    f%d: object(expression: %s) { __typename oid ... on Blob { byteSize isBinary text } }\n", i, graphQLString(commit+":"+p))
+		}
+		q.WriteString("  }\n}")
+
+		var data struct {
+			Repository map[string]*graphQLObject `json:"repository"`
+		}
+		if err := c.query(ctx, q.String(), map[string]any{"owner": c.owner, "name": c.name}, &data); err != nil {
+			return nil, err
+		}
+
+		for i, p := range batch {
+			obj := data.Repository[fmt.Sprintf("f%d", i)]
+			if obj == nil || obj.Typename != "Blob" {
+				continue
+			}
+			result[p] = blobInfo{OID: obj.OID, Size: obj.ByteSize, IsBinary: obj.IsBinary, Text: obj.Text}
+		}
+	}
+	return result, nil
+}
+
+// lastCommits returns, for each path, the SHA of the most recent commit
+// reachable from commit that touched it. Paths with no history are omitted.
+func (c *graphQLClient) lastCommits(ctx context.Context, commit string, paths []string) (map[string]string, error) {
+	result := make(map[string]string, len(paths))
+	for start := 0; start < len(paths); start += graphQLBatchSize {
+		batch := paths[start:min(start+graphQLBatchSize, len(paths))]
+
+		var q strings.Builder
+		q.WriteString("query($owner: String!, $name: String!, $commit: String!) {\n  repository(owner: $owner, name: $name) {\n    object(expression: $commit) {\n      ... on Commit {\n")
+		for i, p := range batch {
+			fmt.Fprintf(&q, "        h%d: history(first: 1, path: %s) { nodes { oid } }\n", i, graphQLString(p))
+		}
+		q.WriteString("      }\n    }\n  }\n}")
+
+		var data struct {
+			Repository struct {
+				Object map[string]struct {
+					Nodes []struct {
+						OID string `json:"oid"`
+					} `json:"nodes"`
+				} `json:"object"`
+			} `json:"repository"`
+		}
+		vars := map[string]any{"owner": c.owner, "name": c.name, "commit": commit}
+		if err := c.query(ctx, q.String(), vars, &data); err != nil {
+			return nil, err
+		}
+
+		for i, p := range batch {
+			h, ok := data.Repository.Object[fmt.Sprintf("h%d", i)]
+			if !ok || len(h.Nodes) == 0 {
+				continue
+			}
+			result[p] = h.Nodes[0].OID
+		}
+	}
+	return result, nil
+}
+
+// graphQLString quotes s as a GraphQL string literal. JSON string escaping is
+// a valid subset of GraphQL's, so encoding/json does the work.
+func graphQLString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
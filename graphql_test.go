@@ -0,0 +1,88 @@
+package wptsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// newGraphQLFixture starts a server that answers batched blob queries by
+// echoing each aliased expression back as the blob text, and returns a client
+// pointed at it plus a function reporting how many requests it has handled.
+func newGraphQLFixture(t *testing.T) (*graphQLClient, func() int) {
+	t.Helper()
+
+	aliasRe := regexp.MustCompile(`(f\d+): object\(expression: "([^"]*)"\)`)
+	count := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		if got := r.Header.Get("Authorization"); got != "bearer tok" {
+			t.Errorf("Authorization = %q, want bearer token", got)
+		}
+		var req struct {
+			Query string `json:"query"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+
+		repo := map[string]any{}
+		for _, m := range aliasRe.FindAllStringSubmatch(req.Query, -1) {
+			if strings.HasSuffix(m[2], "missing.js") {
+				repo[m[1]] = nil
+				continue
+			}
+			repo[m[1]] = map[string]any{"__typename": "Blob", "oid": "oid-" + m[2], "byteSize": len(m[2]), "text": m[2]}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"repository": repo}})
+	}))
+	t.Cleanup(srv.Close)
+
+	return &graphQLClient{http: srv.Client(), endpoint: srv.URL, token: "tok", owner: "o", name: "n"}, func() int { return count }
+}
+
+func TestGraphQLFetchBlobsBatches(t *testing.T) {
+	client, requestCount := newGraphQLFixture(t)
+
+	var paths []string
+	for i := range graphQLBatchSize + 5 {
+		paths = append(paths, fmt.Sprintf("dir/file%d.js", i))
+	}
+	paths = append(paths, "dir/missing.js")
+
+	blobs, err := client.fetchBlobs(context.Background(), "c1", paths)
+	if err != nil {
+		t.Fatalf("fetchBlobs: %v", err)
+	}
+
+	if requestCount() != 2 {
+		t.Errorf("expected 2 batched requests, got %d", requestCount())
+	}
+	if len(blobs) != graphQLBatchSize+5 {
+		t.Errorf("got %d blobs, want %d", len(blobs), graphQLBatchSize+5)
+	}
+	if got := blobs["dir/file3.js"].Text; got != "c1:dir/file3.js" {
+		t.Errorf("blob text = %q, want %q", got, "c1:dir/file3.js")
+	}
+	if _, ok := blobs["dir/missing.js"]; ok {
+		t.Error("missing path should be omitted from the result")
+	}
+}
+
+func TestGraphQLReportsErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"errors":[{"message":"bad credentials"}]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	client := &graphQLClient{http: srv.Client(), endpoint: srv.URL, token: "tok", owner: "o", name: "n"}
+	_, err := client.resolvePath(context.Background(), "c1", "url")
+	if err == nil || !strings.Contains(err.Error(), "bad credentials") {
+		t.Errorf("expected GraphQL error message, got %v", err)
+	}
+}
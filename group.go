@@ -0,0 +1,260 @@
+package wptsync
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SyncGroup is a named set of files that a configuration syncs into a target
+// directory of its own, such as the tests of one runtime in a repository
+// that vendors WPT for several. Sync, Verify, and Status operate on a group
+// when SyncOptions.Group names it, and leave the groups alone otherwise.
+type SyncGroup struct {
+	Name      string `json:"name" wptsync:"required"`
+	TargetDir string `json:"target_dir" wptsync:"required"`
+	// Commit pins the group at another commit than the configuration's.
+	// Empty means the configuration's, so that update moves the group too.
+	Commit string     `json:"commit,omitempty"`
+	Files  []FileSpec `json:"files"`
+	// Namespace syncs the group into a subdirectory of TargetDir named
+	// after it, so that groups vendoring the same files, each for a
+	// runtime of its own, can share a target_dir.
+	Namespace bool `json:"namespace,omitempty"`
+}
+
+// targetDir returns the directory the group syncs into.
+func (g *SyncGroup) targetDir() string {
+	if g.Namespace {
+		return path.Join(filepath.ToSlash(g.TargetDir), g.Name)
+	}
+	return g.TargetDir
+}
+
+// The values of Config.GroupDuplicates.
+const (
+	GroupDuplicatesAllow = "allow"
+	GroupDuplicatesWarn  = "warn"
+	GroupDuplicatesError = "error"
+)
+
+// group returns the configuration the group name syncs: c with the group's
+// target_dir and files, and its commit if it pins one. An empty name
+// returns c itself.
+func (c *Config) group(name string) (*Config, error) {
+	if name == "" {
+		return c, nil
+	}
+	i := slices.IndexFunc(c.Groups, func(g SyncGroup) bool { return g.Name == name })
+	if i < 0 {
+		names := make([]string, len(c.Groups))
+		for i, g := range c.Groups {
+			names[i] = g.Name
+		}
+		if len(names) == 0 {
+			return nil, invalidConfig(fmt.Errorf("config has no groups, so no group %q", name))
+		}
+		return nil, invalidConfig(fmt.Errorf("config has no group %q (groups: %s)", name, strings.Join(names, ", ")))
+	}
+	g := c.Groups[i]
+	view := *c
+	view.TargetDir, view.Files, view.Groups = g.targetDir(), slices.Clone(g.Files), nil
+	if g.Commit != "" {
+		view.Commit, view.Tag = g.Commit, ""
+	}
+	return &view, nil
+}
+
+// setGroupFiles replaces the files of the group name, or of c itself when
+// name is empty, with files.
+func (c *Config) setGroupFiles(name string, files []FileSpec) {
+	if name == "" {
+		c.Files = files
+		return
+	}
+	for i := range c.Groups {
+		if c.Groups[i].Name == name {
+			c.Groups[i].Files = files
+		}
+	}
+}
+
+// checkGroups checks each group as the configuration it syncs, that no two
+// of them, or a group and c, share a target directory: the stamp sync leaves
+// there belongs to one of them, and that no file of one is synced into the
+// directory of another. Unless c.GroupDuplicates allows them, it also
+// rejects srcs that more than one of them list.
+func (c *Config) checkGroups() error {
+	switch c.GroupDuplicates {
+	case "", GroupDuplicatesAllow, GroupDuplicatesWarn, GroupDuplicatesError:
+	default:
+		return fmt.Errorf("config: group_duplicates must be %q, %q, or %q, not %q", GroupDuplicatesAllow, GroupDuplicatesWarn, GroupDuplicatesError, c.GroupDuplicates)
+	}
+	dirs := map[string]string{filepath.Clean(filepath.FromSlash(c.TargetDir)): "the configuration"}
+	for _, g := range c.Groups {
+		if !validGroupName(g.Name) {
+			return fmt.Errorf("config: group name %q must be letters, digits, '-', and '_'", g.Name)
+		}
+		view, err := c.group(g.Name)
+		if err != nil {
+			return err
+		}
+		if err := view.check(); err != nil {
+			return fmt.Errorf("group %q: %w", g.Name, err)
+		}
+		dir := filepath.Clean(filepath.FromSlash(view.TargetDir))
+		if other, ok := dirs[dir]; ok {
+			hint := ""
+			if !g.Namespace {
+				hint = " (set namespace to sync it into a subdirectory named after it)"
+			}
+			return fmt.Errorf("config: group %q uses the target_dir of %s%s", g.Name, other, hint)
+		}
+		dirs[dir] = fmt.Sprintf("group %q", g.Name)
+	}
+	if err := c.checkNestedDsts(dirs); err != nil {
+		return err
+	}
+	if c.GroupDuplicates == GroupDuplicatesError {
+		if shared := c.sharedSrcs(""); len(shared) > 0 {
+			return fmt.Errorf("config: %s; set group_duplicates to %q or %q to vendor a file more than once", shared[0], GroupDuplicatesAllow, GroupDuplicatesWarn)
+		}
+		for _, g := range c.Groups {
+			if shared := c.sharedSrcs(g.Name); len(shared) > 0 {
+				return fmt.Errorf("config: group %q: %s; set group_duplicates to %q or %q to vendor a file more than once", g.Name, shared[0], GroupDuplicatesAllow, GroupDuplicatesWarn)
+			}
+		}
+	}
+	return nil
+}
+
+// checkNestedDsts returns an error if a file of c or of a group is synced
+// into the target directory of another, of dirs, nested in its own, where
+// the two would overwrite each other's files.
+func (c *Config) checkNestedDsts(dirs map[string]string) error {
+	sets := []string{""}
+	for _, g := range c.Groups {
+		sets = append(sets, g.Name)
+	}
+	for _, name := range sets {
+		view, err := c.group(name)
+		if err != nil {
+			return err
+		}
+		dir := filepath.Clean(filepath.FromSlash(view.TargetDir))
+		for _, f := range view.Files {
+			dest := filepath.Join(dir, filepath.FromSlash(f.Dst))
+			for inner, owner := range dirs {
+				if rel, err := filepath.Rel(dir, inner); err != nil || !filepath.IsLocal(rel) || inner == dir {
+					continue
+				}
+				if rel, err := filepath.Rel(inner, dest); err == nil && filepath.IsLocal(rel) {
+					return fmt.Errorf("config: %s: dst %q is in the target_dir of %s", setName(name), f.Dst, owner)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sharedSrcs describes the srcs of the enabled files of the group name, or
+// of c itself when name is empty, that c or another of its groups lists too.
+func (c *Config) sharedSrcs(name string) []string {
+	listers := make(map[string][]string)
+	list := func(set string, files []FileSpec) {
+		for _, f := range files {
+			if f.IsEnabled() && !slices.Contains(listers[f.Src], set) {
+				listers[f.Src] = append(listers[f.Src], set)
+			}
+		}
+	}
+	list("", c.Files)
+	for _, g := range c.Groups {
+		list(g.Name, g.Files)
+	}
+	var shared []string
+	for src, sets := range listers {
+		if len(sets) < 2 || !slices.Contains(sets, name) {
+			continue
+		}
+		var others []string
+		for _, set := range sets {
+			if set != name {
+				others = append(others, setName(set))
+			}
+		}
+		shared = append(shared, fmt.Sprintf("%s is also listed by %s", src, strings.Join(others, " and ")))
+	}
+	slices.Sort(shared)
+	return shared
+}
+
+// setName names the group name, or the configuration's own files when name
+// is empty, in messages.
+func setName(name string) string {
+	if name == "" {
+		return "the top-level files"
+	}
+	return fmt.Sprintf("group %q", name)
+}
+
+// groupDirs returns the directories the groups of c sync into, relative to
+// the configuration's directory.
+func (c *Config) groupDirs() []string {
+	dirs := make([]string, len(c.Groups))
+	for i := range c.Groups {
+		dirs[i] = filepath.Clean(filepath.FromSlash(c.Groups[i].targetDir()))
+	}
+	return dirs
+}
+
+// validGroupName reports whether name can name a group, which also names its
+// lock file (see groupLockPath).
+func validGroupName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// groupLockPath returns the lock file of the group name in the configuration
+// at configPath: wpt.<name>.lock, next to the configuration's own lock, which
+// an empty name returns.
+func groupLockPath(configPath, name string) string {
+	if name == "" {
+		return lockPath(configPath)
+	}
+	return strings.TrimSuffix(configPath, filepath.Ext(configPath)) + "." + name + ".lock"
+}
+
+// loadGroup returns the configuration at configPath, validated, as the
+// group opts names syncs it, with the lock file of that group.
+func (o *SyncOptions) loadGroup(configPath string) (*Config, string, error) {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, "", err
+	}
+	var name string
+	if o != nil {
+		name = o.Group
+	}
+	var shared []string
+	if cfg.GroupDuplicates == GroupDuplicatesWarn {
+		shared = cfg.sharedSrcs(name)
+	}
+	if cfg, err = cfg.group(name); err != nil {
+		return nil, "", err
+	}
+	cfg.groupWarnings = shared
+	return cfg, groupLockPath(configPath, name), nil
+}
@@ -0,0 +1,133 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncGroup(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/url/a.js":     "a\n",
+		"/c2/fetch/b.js":   "b at c2\n",
+		"/c1/console/c.js": "c\n",
+	})
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "url/a.js"}},
+		Groups: []SyncGroup{
+			{Name: "runtime", TargetDir: "runtime/wpt", Commit: "c2", Files: []FileSpec{{Src: "fetch/b.js"}}},
+			{Name: "console", TargetDir: "console/wpt", Files: []FileSpec{{Src: "console/c.js", Dst: "c.js"}}},
+		},
+	})
+	opts := &SyncOptions{BaseURL: server.URL, Group: "runtime"}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "runtime", "wpt", "fetch", "b.js")); string(got) != "b at c2\n" {
+		t.Errorf("runtime/wpt/fetch/b.js = %q, want the group's commit", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wpt", "url", "a.js")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("top-level file synced with -group runtime: %v", err)
+	}
+	lock, err := loadLock(filepath.Join(dir, "wpt.runtime.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lock.Files["fetch/b.js"]; !ok || lock.Commit != "c2" {
+		t.Errorf("wpt.runtime.lock = %+v, want fetch/b.js at c2", lock)
+	}
+	if _, err := os.Stat(lockPath(configPath)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("wpt.lock written by a group sync: %v", err)
+	}
+	if err := Verify(context.Background(), configPath, &SyncOptions{Group: "runtime"}); err != nil {
+		t.Errorf("Verify -group runtime: %v", err)
+	}
+	if err := Verify(context.Background(), configPath, &SyncOptions{Group: "console"}); !errors.Is(err, ErrDrift) {
+		t.Errorf("Verify -group console = %v, want drift before it is synced", err)
+	}
+
+	// A group without a commit of its own follows the configuration's.
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Group: "console"}); err != nil {
+		t.Fatalf("Sync -group console: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "console", "wpt", "c.js")); string(got) != "c\n" {
+		t.Errorf("console/wpt/c.js = %q, want %q", got, "c\n")
+	}
+
+	err = Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Group: "nope"})
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "groups: runtime, console") {
+		t.Errorf("Sync -group nope = %v, want an invalid config listing the groups", err)
+	}
+}
+
+func TestConfigGroupsCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		groups []SyncGroup
+		want   string
+	}{
+		{"bad name", []SyncGroup{{Name: "a/b", TargetDir: "x"}}, "group name"},
+		{"no target_dir", []SyncGroup{{Name: "a"}}, "target_dir must be provided"},
+		{"shared target_dir", []SyncGroup{{Name: "a", TargetDir: "wpt/"}}, "uses the target_dir of the configuration"},
+		{"bad file", []SyncGroup{{Name: "a", TargetDir: "x", Files: []FileSpec{{Src: "a.js", Dst: "../a.js"}}}}, "escapes the target directory"},
+		{"shared namespace", []SyncGroup{{Name: "a", TargetDir: "x", Namespace: true}, {Name: "b", TargetDir: "x/a"}}, `group "b" uses the target_dir of group "a"`},
+		{"nested dst", []SyncGroup{{Name: "a", TargetDir: "x", Files: []FileSpec{{Src: "b/c.js", Dst: "b/c.js"}}}, {Name: "b", TargetDir: "x", Namespace: true}}, `group "a": dst "b/c.js" is in the target_dir of group "b"`},
+	} {
+		cfg := &Config{Commit: "c1", TargetDir: "wpt", Groups: tc.groups}
+		if err := cfg.check(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: check = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestSyncNamespacedGroups(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/resources/testharness.js": "harness\n",
+	})
+	harness := []FileSpec{{Src: "resources/testharness.js"}}
+	cfg := &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     harness,
+		Groups: []SyncGroup{
+			{Name: "node", TargetDir: "wpt", Namespace: true, Files: harness},
+			{Name: "deno", TargetDir: "wpt", Namespace: true, Files: harness},
+		},
+		GroupDuplicates: GroupDuplicatesWarn,
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+	var logs strings.Builder
+	for _, group := range []string{"", "node", "deno"} {
+		opts := &SyncOptions{BaseURL: server.URL, Group: group, Logf: func(format string, args ...any) { fmt.Fprintf(&logs, format, args...) }}
+		if err := Sync(context.Background(), configPath, opts); err != nil {
+			t.Fatalf("Sync -group %q: %v", group, err)
+		}
+	}
+	for _, p := range []string{"wpt/resources/testharness.js", "wpt/node/resources/testharness.js", "wpt/deno/resources/testharness.js"} {
+		if got, _ := os.ReadFile(filepath.Join(dir, filepath.FromSlash(p))); string(got) != "harness\n" {
+			t.Errorf("%s = %q, want the synced file", p, got)
+		}
+	}
+	if want := `warning: resources/testharness.js is also listed by group "node" and group "deno"`; !strings.Contains(logs.String(), want) {
+		t.Errorf("log = %q, want %q", logs.String(), want)
+	}
+
+	// The groups' directories are not untracked files of the top level.
+	pruned, err := Prune(context.Background(), configPath, &PruneOptions{Untracked: true})
+	if err != nil || len(pruned) != 0 {
+		t.Errorf("Prune -untracked = %v, %v, want nothing pruned", pruned, err)
+	}
+
+	cfg.GroupDuplicates = GroupDuplicatesError
+	saveTestConfig(t, dir, cfg)
+	err = Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Group: "node"})
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "resources/testharness.js is also listed by") {
+		t.Errorf("Sync with group_duplicates error = %v, want the shared src reported", err)
+	}
+}
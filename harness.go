@@ -0,0 +1,206 @@
+package wptsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultHarnessFiles are the files HarnessChecksums checks when its Files
+// is empty: the test harness and the helpers that drive the browser, which
+// run with the privileges of every test loading them.
+var DefaultHarnessFiles = []string{
+	"resources/testharness.js",
+	"resources/testharnessreport.js",
+	"resources/testdriver.js",
+	"resources/testdriver-vendor.js",
+	"resources/testdriver-actions.js",
+	"resources/idlharness.js",
+	"resources/WebIDLParser.js",
+}
+
+// HarnessChecksums has the harness files checked against an allowlist of
+// SHA-256 hashes before a sync writes them, so that only reviewed versions
+// of the code every test runs make it into the tree. The hashes are of the
+// content upstream, before patches, transforms, and license headers.
+type HarnessChecksums struct {
+	// Files lists the srcs to check, as patterns like those of -only.
+	// Empty means DefaultHarnessFiles.
+	Files []string `json:"files,omitempty"`
+	// SHA256 lists, for each src, the hashes its content may have.
+	SHA256 map[string][]string `json:"sha256,omitempty"`
+	// Lists name more of them, in the format sha256sum prints ("<hash>
+	// <path>" lines, such as published with a snapshot of WPT): files
+	// relative to the repository root, or https:// URLs.
+	Lists []string `json:"lists,omitempty"`
+	// Require fails the files to check that no hash is listed for, as
+	// SyncOptions.RequireAllowlist does, instead of letting them through.
+	Require bool `json:"require,omitempty"`
+}
+
+func (h *HarnessChecksums) check() error {
+	for _, pattern := range h.Files {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("harness_checksums: files: %q: %w", pattern, err)
+		}
+	}
+	for src, sums := range h.SHA256 {
+		for _, sum := range sums {
+			if !isSHA256(sum) {
+				return fmt.Errorf("harness_checksums: sha256 of %s: %q is not a SHA-256 hash", src, sum)
+			}
+		}
+	}
+	for _, list := range h.Lists {
+		// The lists vouch for code every test runs, so they are never
+		// fetched where the network could rewrite them.
+		if strings.Contains(list, "://") && !strings.HasPrefix(list, "https://") {
+			return fmt.Errorf("harness_checksums: lists: %q is neither a file nor an https:// URL", list)
+		}
+	}
+	return nil
+}
+
+// harnessAllowlist is HarnessChecksums loaded for a run.
+type harnessAllowlist struct {
+	files   []string
+	sums    map[string]map[string]bool
+	require bool
+}
+
+// withHarnessChecksums returns a copy of o that checks the harness files it
+// downloads against cfg's HarnessChecksums, loading their lists, when cfg
+// has them or o.RequireAllowlist asks for it.
+func (o *SyncOptions) withHarnessChecksums(ctx context.Context, root string, cfg *Config) (*SyncOptions, error) {
+	h := cfg.HarnessChecksums
+	require := o != nil && o.RequireAllowlist
+	if h == nil && !require || o != nil && o.DryRun {
+		return o, nil
+	}
+	if h == nil {
+		h = &HarnessChecksums{}
+	}
+	a := &harnessAllowlist{files: h.Files, sums: make(map[string]map[string]bool), require: require || h.Require}
+	if len(a.files) == 0 {
+		a.files = DefaultHarnessFiles
+	}
+	add := func(src, sum string) {
+		src = strings.TrimPrefix(strings.TrimLeft(src, "/"), "./")
+		if a.sums[src] == nil {
+			a.sums[src] = make(map[string]bool)
+		}
+		a.sums[src][strings.ToLower(sum)] = true
+	}
+	for src, sums := range h.SHA256 {
+		for _, sum := range sums {
+			add(src, sum)
+		}
+	}
+	for _, list := range h.Lists {
+		data, err := o.readChecksumList(ctx, root, list)
+		if err != nil {
+			return nil, fmt.Errorf("read harness checksums %s: %w", list, err)
+		}
+		if err := parseChecksumList(data, add); err != nil {
+			return nil, invalidConfig(fmt.Errorf("harness checksums %s: %w", list, err))
+		}
+	}
+	var cp SyncOptions
+	if o != nil {
+		cp = *o
+	}
+	cp.harness = a
+	return &cp, nil
+}
+
+// readChecksumList reads the checksum list at list, an https:// URL or a
+// file relative to root.
+func (o *SyncOptions) readChecksumList(ctx context.Context, root, list string) ([]byte, error) {
+	if !strings.HasPrefix(list, "https://") {
+		return os.ReadFile(filepath.Join(root, filepath.FromSlash(list)))
+	}
+	ctx, cancel := withTimeout(ctx, o.timeouts().Resolve)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, list, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseChecksumList calls add with the path and hash of each line of data,
+// in the format of sha256sum: the hash, a space, and the path, which a "*"
+// marks as read in binary mode. Blank lines and "#" comments are skipped.
+func parseChecksumList(data []byte, add func(src, sum string)) error {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sum, p, ok := strings.Cut(line, " ")
+		p = strings.TrimPrefix(strings.TrimLeft(p, " "), "*")
+		if !ok || !isSHA256(sum) || p == "" {
+			return fmt.Errorf("line %d: want a SHA-256 hash and a path", n)
+		}
+		add(p, sum)
+	}
+	return sc.Err()
+}
+
+// checksHarness reports whether the download of src is checked against the
+// harness allowlist.
+func (o *SyncOptions) checksHarness(src string) bool {
+	if o == nil || o.harness == nil {
+		return false
+	}
+	for _, pattern := range o.harness.files {
+		if matchFilter(pattern, src) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHarness returns an error wrapping ErrVerification unless the content
+// of src fetched to p hashes to one of the SHA-256s the allowlist has for
+// it, or the allowlist has none and does not require them.
+func (o *SyncOptions) checkHarness(p, src string) error {
+	if !o.checksHarness(src) {
+		return nil
+	}
+	allowed := o.harness.sums[src]
+	if len(allowed) == 0 {
+		if o.harness.require {
+			return fmt.Errorf("%w: %s is a harness file with no hash in the allowlist (see harness_checksums)", ErrVerification, src)
+		}
+		return nil
+	}
+	got, err := hashFile(p)
+	if err != nil {
+		return fmt.Errorf("hash %s: %w", src, err)
+	}
+	if !allowed[got] {
+		return fmt.Errorf("%w: %s hashes to sha256 %s, which the harness allowlist does not list; review the upstream change and add the hash to harness_checksums", ErrVerification, src, got)
+	}
+	return nil
+}
+
+// isSHA256 reports whether s is a hexadecimal SHA-256 hash.
+func isSHA256(s string) bool {
+	return len(s) == 64 && strings.Trim(strings.ToLower(s), "0123456789abcdef") == ""
+}
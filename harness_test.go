@@ -0,0 +1,96 @@
+package wptsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestSyncChecksHarnessFiles(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	const harness = "// testharness.js, reviewed\n"
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/resources/testharness.js": harness,
+		"/c1/resources/testdriver.js":  "// testdriver.js\n",
+		"/c1/url/a.js":                 "a\n",
+	})
+	list := sha256Hex("// testdriver.js, an older version\n") + "  ./resources/testdriver.js\n"
+	if err := os.WriteFile(filepath.Join(dir, "harness.sha256"), []byte("# reviewed\n"+list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "resources/testharness.js"}, {Src: "url/a.js"}},
+		HarnessChecksums: &HarnessChecksums{
+			SHA256: map[string][]string{"resources/testharness.js": {sha256Hex(harness)}},
+			Lists:  []string{"harness.sha256"},
+		},
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync with allowed harness: %v", err)
+	}
+
+	// testdriver.js upstream is not the reviewed version: it must not be
+	// written.
+	cfg.Files = append(cfg.Files, FileSpec{Src: "resources/testdriver.js"})
+	saveTestConfig(t, dir, cfg)
+	err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL})
+	if !errors.Is(err, ErrVerification) || !strings.Contains(err.Error(), sha256Hex("// testdriver.js\n")) {
+		t.Fatalf("Sync = %v, want a verification error naming the hash", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wpt", "resources", "testdriver.js")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("refused harness file was written (%v)", err)
+	}
+
+	// Without an entry, a harness file passes unless the allowlist is
+	// required.
+	cfg.HarnessChecksums.Lists = nil
+	saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync with an unlisted harness file: %v", err)
+	}
+	os.Remove(filepath.Join(dir, "wpt", "resources", "testdriver.js"))
+	err = Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, RequireAllowlist: true})
+	if !errors.Is(err, ErrVerification) || !strings.Contains(err.Error(), "resources/testdriver.js is a harness file with no hash") {
+		t.Errorf("Sync -require-allowlist = %v, want the unlisted file refused", err)
+	}
+}
+
+func TestParseChecksumList(t *testing.T) {
+	sum := sha256Hex("x")
+	got := map[string]string{}
+	err := parseChecksumList([]byte(sum+"  resources/a.js\n\n"+strings.ToUpper(sum)+" *resources/b.js\n"), func(src, s string) { got[src] = s })
+	if err != nil || got["resources/a.js"] != sum || got["resources/b.js"] != strings.ToUpper(sum) {
+		t.Errorf("parseChecksumList = %v, %v", got, err)
+	}
+	if err := parseChecksumList([]byte("abc resources/a.js\n"), func(string, string) {}); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("parseChecksumList(bad hash) = %v, want line 1 reported", err)
+	}
+}
+
+func TestHarnessChecksumListsMustUseHTTPS(t *testing.T) {
+	for list, ok := range map[string]bool{
+		"checksums/harness.sha256":               true,
+		"https://example.com/wpt/harness.sha256": true,
+		"http://example.com/wpt/harness.sha256":  false,
+		"ftp://example.com/wpt/harness.sha256":   false,
+	} {
+		cfg := &Config{Commit: "c1", TargetDir: "wpt", HarnessChecksums: &HarnessChecksums{Lists: []string{list}}}
+		err := cfg.check()
+		if ok && err != nil || !ok && (err == nil || !strings.Contains(err.Error(), "https://")) {
+			t.Errorf("check with list %q = %v, want ok = %v", list, err, ok)
+		}
+	}
+}
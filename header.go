@@ -0,0 +1,174 @@
+package wptsync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// headerData is what license header templates are executed against.
+type headerData struct {
+	Src    string
+	Dst    string
+	Commit string
+}
+
+// licenseHeader renders the license header configured for file's dst
+// extension, always ending in a newline. It returns "" when none applies.
+func (c *Config) licenseHeader(file FileSpec) (string, error) {
+	text, ok := c.LicenseHeaders[path.Ext(file.Dst)]
+	if !ok || text == "" {
+		return "", nil
+	}
+	tmpl, err := template.New(path.Ext(file.Dst)).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("license header for %s: %w", path.Ext(file.Dst), err)
+	}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, headerData{Src: file.Src, Dst: file.Dst, Commit: c.commitOf(file)}); err != nil {
+		return "", fmt.Errorf("license header for %s: %w", file.Dst, err)
+	}
+	header := buf.String()
+	if !strings.HasSuffix(header, "\n") {
+		header += "\n"
+	}
+	return header, nil
+}
+
+// commentStyle is how a file type spells a multi-line comment: an opening
+// line, a prefix for each line inside, and a closing line.
+type commentStyle struct {
+	open, line, close string
+}
+
+// commentStyles maps a dst extension to its comment syntax. Formats without
+// comments (JSON) or whose first line is significant (an XML declaration)
+// are missing, so their files get no provenance header.
+var commentStyles = map[string]commentStyle{
+	".js":   {line: "// "},
+	".mjs":  {line: "// "},
+	".cjs":  {line: "// "},
+	".ts":   {line: "// "},
+	".css":  {open: "/*", line: " * ", close: " */"},
+	".html": {open: "<!--", line: "  ", close: "-->"},
+	".htm":  {open: "<!--", line: "  ", close: "-->"},
+	".py":   {line: "# "},
+}
+
+// provenanceOf reports whether file gets a provenance header: its own
+// Provenance setting, or else the configuration's ProvenanceHeaders.
+func (c *Config) provenanceOf(file FileSpec) bool {
+	if file.Provenance != nil {
+		return *file.Provenance
+	}
+	return c.ProvenanceHeaders
+}
+
+// provenanceHeader renders the comment recording where file came from and
+// when it was synced (date, as YYYY-MM-DD), in the comment syntax of its dst
+// extension. It returns "" when file gets none.
+func (c *Config) provenanceHeader(file FileSpec, date string) string {
+	style, ok := commentStyles[path.Ext(file.Dst)]
+	if !ok || !c.provenanceOf(file) {
+		return ""
+	}
+	repo := c.Repo
+	if repo == "" {
+		repo = DefaultRepo
+	}
+	var b strings.Builder
+	if style.open != "" {
+		b.WriteString(style.open + "\n")
+	}
+	for _, line := range []string{
+		"Vendored from " + repo + " by wptsync on " + date + ".",
+		"Source: " + file.Src,
+		"Commit: " + c.commitOf(file),
+		"DO NOT EDIT. Record local changes as a patch (see wpt.json) instead.",
+	} {
+		b.WriteString(style.line + line + "\n")
+	}
+	if style.close != "" {
+		b.WriteString(style.close + "\n")
+	}
+	return b.String()
+}
+
+// fileHeader returns the header sync puts at the top of file, as
+// textHeader does, or "" when file is binary at dest, as sync adds none to
+// binary files.
+func (c *Config) fileHeader(file FileSpec, dest string) (string, error) {
+	binary, err := isBinaryFile(dest, file.Dst)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if binary {
+		return "", nil
+	}
+	return c.textHeader(file, dest)
+}
+
+// textHeader returns the header sync puts at the top of file when it is
+// text: its license header, then its provenance header. The provenance
+// header keeps the date of the one already at the top of dest when it is
+// otherwise the same, so that syncing the same file again, or checking it,
+// does not change it. Any other provenance header is dated today.
+func (c *Config) textHeader(file FileSpec, dest string) (string, error) {
+	license, err := c.licenseHeader(file)
+	if err != nil {
+		return "", err
+	}
+	const placeholder = "YYYY-MM-DD"
+	provenance := c.provenanceHeader(file, placeholder)
+	if provenance == "" {
+		return license, nil
+	}
+
+	date := time.Now().UTC().Format(time.DateOnly)
+	if content, err := os.ReadFile(dest); err == nil {
+		before, after, _ := strings.Cut(provenance, placeholder)
+		rest, ok := bytes.CutPrefix(content, []byte(license+before))
+		if ok && len(rest) >= len(placeholder) && bytes.HasPrefix(rest[len(placeholder):], []byte(after)) {
+			if _, err := time.Parse(time.DateOnly, string(rest[:len(placeholder)])); err == nil {
+				date = string(rest[:len(placeholder)])
+			}
+		}
+	}
+	return license + strings.Replace(provenance, placeholder, date, 1), nil
+}
+
+// injectHeader prepends header to the file at dest unless it already starts
+// with it, so running it twice leaves a single copy. The file keeps its mode
+// and is replaced by rename, never rewritten in place, so hard links made by
+// dedupe are not modified through the shared inode.
+func injectHeader(dest, header string) error {
+	if header == "" {
+		return nil
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(dest)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(content, []byte(header)) {
+		return nil
+	}
+	return writeFileAtomic(dest, append([]byte(header), content...), info.Mode().Perm())
+}
+
+// hasHeader reports whether the file at dest starts with header.
+func hasHeader(dest, header string) (bool, error) {
+	content, err := os.ReadFile(dest)
+	if err != nil {
+		return false, err
+	}
+	return bytes.HasPrefix(content, []byte(header)), nil
+}
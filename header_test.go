@@ -0,0 +1,171 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncInjectsLicenseHeader(t *testing.T) {
+	content := map[string]string{
+		"/c1/a/foo.js":   "content A\n",
+		"/c1/a/data.txt": "plain\n",
+	}
+	server, dir, _ := newFixture(t, content)
+
+	cfg := &Config{
+		Commit:         "c1",
+		TargetDir:      "wpt",
+		Files:          []FileSpec{{Src: "a/foo.js"}, {Src: "a/data.txt"}},
+		LicenseHeaders: map[string]string{".js": "// Vendored from WPT {{.Src}} @ {{.Commit}}"},
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+	opts := &SyncOptions{BaseURL: server.URL}
+
+	read := func(rel string) string {
+		t.Helper()
+		got, err := os.ReadFile(filepath.Join(dir, "wpt", filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(got)
+	}
+
+	// Syncing twice must not stack headers.
+	for range 2 {
+		if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Force: true}); err != nil {
+			t.Fatalf("Sync: %v", err)
+		}
+	}
+	if got, want := read("a/foo.js"), "// Vendored from WPT a/foo.js @ c1\ncontent A\n"; got != want {
+		t.Errorf("foo.js = %q, want %q", got, want)
+	}
+	if got := read("a/data.txt"); got != "plain\n" {
+		t.Errorf("data.txt = %q, want no header", got)
+	}
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify after sync: %v", err)
+	}
+
+	// A new template leaves the synced file stale until the next sync,
+	// which refreshes it even though the lock would otherwise skip it.
+	cfg.LicenseHeaders[".js"] = "// SPDX-License-Identifier: BSD-3-Clause"
+	saveTestConfig(t, dir, cfg)
+	if err := Verify(context.Background(), configPath, nil); !errors.Is(err, ErrDrift) {
+		t.Errorf("Verify with new template: expected ErrDrift, got %v", err)
+	}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got, want := read("a/foo.js"), "// SPDX-License-Identifier: BSD-3-Clause\ncontent A\n"; got != want {
+		t.Errorf("foo.js after template change = %q, want %q", got, want)
+	}
+}
+
+func TestConfigRejectsBadLicenseHeader(t *testing.T) {
+	for name, headers := range map[string]map[string]string{
+		"not an extension": {"js": "// header"},
+		"bad template":     {".js": "// {{.Src"},
+		"unknown field":    {".js": "// {{.Author}}"},
+	} {
+		cfg := &Config{Commit: "c1", TargetDir: "wpt", LicenseHeaders: headers}
+		if err := cfg.validate(); err == nil {
+			t.Errorf("%s: validate accepted %v", name, headers)
+		}
+	}
+}
+
+func TestSyncInjectsProvenanceHeaders(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/a/foo.js":    "content A\n",
+		"/c1/a/page.html": "<!doctype html>\n",
+		"/c1/a/data.json": "{}\n",
+		"/c1/a/bar.js":    "content B\n",
+	})
+	off := false
+	cfg := &Config{
+		Commit:            "c1",
+		TargetDir:         "wpt",
+		ProvenanceHeaders: true,
+		LicenseHeaders:    map[string]string{".js": "// SPDX-License-Identifier: BSD-3-Clause"},
+		Files: []FileSpec{
+			{Src: "a/foo.js"}, {Src: "a/page.html"}, {Src: "a/data.json"},
+			{Src: "a/bar.js", Provenance: &off},
+		},
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+	opts := &SyncOptions{BaseURL: server.URL, Force: true}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	read := func(rel string) string {
+		t.Helper()
+		got, err := os.ReadFile(filepath.Join(dir, "wpt", filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(got)
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	want := "// SPDX-License-Identifier: BSD-3-Clause\n" +
+		"// Vendored from web-platform-tests/wpt by wptsync on " + today + ".\n" +
+		"// Source: a/foo.js\n" +
+		"// Commit: c1\n" +
+		"// DO NOT EDIT. Record local changes as a patch (see wpt.json) instead.\n" +
+		"content A\n"
+	if got := read("a/foo.js"); got != want {
+		t.Errorf("foo.js = %q, want %q", got, want)
+	}
+	if got := read("a/page.html"); !strings.HasPrefix(got, "<!--\n  Vendored from ") || !strings.HasSuffix(got, "-->\n<!doctype html>\n") {
+		t.Errorf("page.html = %q, want an HTML comment header", got)
+	}
+	for rel, want := range map[string]string{"a/data.json": "{}\n", "a/bar.js": "// SPDX-License-Identifier: BSD-3-Clause\ncontent B\n"} {
+		if got := read(rel); got != want {
+			t.Errorf("%s = %q, want %q", rel, got, want)
+		}
+	}
+
+	// Syncing the same file again keeps the date it was first synced on.
+	foo := filepath.Join(dir, "wpt", "a", "foo.js")
+	old := strings.Replace(want, today, "2020-01-02", 1)
+	if err := os.WriteFile(foo, []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if got := read("a/foo.js"); got != old {
+		t.Errorf("foo.js after resync = %q, want %q", got, old)
+	}
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestInjectHeaderKeepsMode(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "run.sh")
+	if err := os.WriteFile(dest, []byte("echo hi\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dest, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := injectHeader(dest, "# LICENSE\n"); err != nil {
+		t.Fatalf("injectHeader: %v", err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "# LICENSE\necho hi\n" {
+		t.Errorf("content = %q, want the header prepended", got)
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o755 {
+		t.Errorf("mode = %v, want 0755 kept", info.Mode())
+	}
+}
@@ -0,0 +1,135 @@
+package wptsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// ErrHookFailed marks a hook command that exited with an error.
+var ErrHookFailed = errors.New("hook failed")
+
+// Hooks lists the commands sync and update run around the files they
+// write, such as a formatter or a script regenerating an index. Each runs
+// with sh -c, from the repository root, in the user's environment plus
+// WPTSYNC_HOOK (the stage), WPTSYNC_COMMIT, and WPTSYNC_TARGET_DIR. Dry
+// runs only print them.
+type Hooks struct {
+	// PreSync runs once the files to download are known, before the first
+	// one is.
+	PreSync []Hook `json:"pre_sync,omitempty"`
+	// PostSync runs once every file was written, even when some failed
+	// with -keep-going. Changes it makes to synced files count as local
+	// edits; format them with PostFile instead.
+	PostSync []Hook `json:"post_sync,omitempty"`
+	// PostFile runs after each file is written and patched, before its
+	// content is recorded in the lock file, so that a formatter's changes
+	// do not count as local edits. The file's path from the repository
+	// root is the command's $1 and WPTSYNC_FILE, its upstream path
+	// WPTSYNC_SRC. With -jobs above 1, it can run for several files at
+	// once.
+	PostFile []Hook `json:"post_file,omitempty"`
+}
+
+// Hook is a command of Hooks.
+type Hook struct {
+	// Run is the shell command.
+	Run string `json:"run"`
+	// Optional makes a failure a warning instead of failing the sync.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// hookStages are the stages of Hooks, in the order they run.
+var hookStages = []string{"pre_sync", "post_file", "post_sync"}
+
+// stage returns the hooks of the named stage.
+func (h *Hooks) stage(name string) []Hook {
+	switch name {
+	case "pre_sync":
+		return h.PreSync
+	case "post_file":
+		return h.PostFile
+	case "post_sync":
+		return h.PostSync
+	}
+	return nil
+}
+
+func (h *Hooks) check() error {
+	for _, stage := range hookStages {
+		for i, hook := range h.stage(stage) {
+			if strings.TrimSpace(hook.Run) == "" {
+				return fmt.Errorf("hooks: %s[%d]: run must be set", stage, i)
+			}
+		}
+	}
+	return nil
+}
+
+// runHooks runs the hooks of cfg for stage ("pre_sync", "post_sync", or
+// "post_file") in order, stopping at the first that fails and is not
+// optional. file is the file a post_file hook runs for, nil otherwise.
+func runHooks(ctx context.Context, root string, cfg *Config, stage string, file *FileSpec, opts *SyncOptions) error {
+	if cfg.Hooks == nil {
+		return nil
+	}
+	hooks := cfg.Hooks.stage(stage)
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	env := append(os.Environ(), "WPTSYNC_HOOK="+stage, "WPTSYNC_COMMIT="+cfg.Commit, "WPTSYNC_TARGET_DIR="+cfg.TargetDir)
+	var args []string
+	if file != nil {
+		p := path.Join(cfg.TargetDir, file.Dst)
+		env = append(env, "WPTSYNC_FILE="+p, "WPTSYNC_SRC="+file.Src)
+		args = []string{p}
+	}
+	for _, hook := range hooks {
+		if opts != nil && opts.DryRun {
+			opts.logf(" - would run %s hook: %s\n", stage, hook.Run)
+			continue
+		}
+		if file == nil {
+			opts.logf("Running %s hook: %s\n", stage, hook.Run)
+		}
+		if err := runHook(ctx, root, stage, hook.Run, env, args); err != nil {
+			if ctx.Err() != nil || !hook.Optional {
+				return err
+			}
+			opts.logf("warning: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// runHook runs the shell command run of a stage hook in root, with env and
+// the positional parameters args, returning an error wrapping ErrHookFailed
+// with its output if it fails.
+func runHook(ctx context.Context, root, stage, run string, env, args []string) error {
+	return runShell(ctx, root, stage+" hook", run, ErrHookFailed, env, args)
+}
+
+// runShell runs the shell command run, which what describes, in root, with
+// env and the positional parameters args, returning an error wrapping
+// sentinel with its output if it fails.
+func runShell(ctx context.Context, root, what, run string, sentinel error, env, args []string) error {
+	cmd := exec.CommandContext(ctx, "sh", append([]string{"-c", run, "sh"}, args...)...)
+	cmd.Dir = root
+	cmd.Env = env
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		err = fmt.Errorf("%s %q: %w: %w", what, run, sentinel, err)
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			err = fmt.Errorf("%w\n%s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
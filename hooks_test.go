@@ -0,0 +1,83 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncRunsHooks(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/url/a.js": "a  \n",
+		"/c1/url/b.js": "b  \n",
+	})
+	// post_file strips trailing blanks, as a formatter would; the others
+	// record what they saw.
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "url/a.js"}, {Src: "url/b.js"}},
+		Hooks: &Hooks{
+			PreSync:  []Hook{{Run: `echo "$WPTSYNC_HOOK $WPTSYNC_COMMIT" >> hooks.log`}},
+			PostFile: []Hook{{Run: `sed 's/ *$//' "$1" > "$1.tmp" && mv "$1.tmp" "$1" && echo "$WPTSYNC_SRC" >> files.log`}},
+			PostSync: []Hook{{Run: "exit 1", Optional: true}, {Run: `echo "$WPTSYNC_HOOK $WPTSYNC_TARGET_DIR" >> hooks.log`}},
+		},
+	})
+
+	var logged strings.Builder
+	dryRun := &SyncOptions{BaseURL: server.URL, DryRun: true, Logf: func(format string, args ...any) { fmt.Fprintf(&logged, format, args...) }}
+	if err := Sync(context.Background(), configPath, dryRun); err != nil {
+		t.Fatalf("dry run Sync: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "hooks.log")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("dry run ran a hook (%v)", err)
+	}
+	if !strings.Contains(logged.String(), "would run post_sync hook: exit 1") {
+		t.Errorf("dry run log = %q, want the hooks listed", logged.String())
+	}
+
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "hooks.log")); string(got) != "pre_sync c1\npost_sync wpt\n" {
+		t.Errorf("hooks.log = %q", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "files.log")); len(strings.Fields(string(got))) != 2 {
+		t.Errorf("files.log = %q, want one line per file", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "url", "a.js")); string(got) != "a\n" {
+		t.Errorf("a.js = %q, want it formatted", got)
+	}
+	// The lock recorded the formatted files.
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify after hooks: %v", err)
+	}
+}
+
+func TestSyncFailsOnHookError(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, dir, _ := newFixture(t, map[string]string{"/c1/url/a.js": "a\n"})
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "url/a.js"}},
+		Hooks:     &Hooks{PostSync: []Hook{{Run: "echo index is stale >&2; exit 3"}}},
+	})
+	err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL})
+	if !errors.Is(err, ErrHookFailed) || !strings.Contains(err.Error(), "index is stale") {
+		t.Fatalf("Sync = %v, want the hook's failure and output", err)
+	}
+	if _, err := os.Stat(stampPath(dir, &Config{TargetDir: "wpt"})); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("failed sync left a freshness stamp (%v)", err)
+	}
+
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Hooks: &Hooks{PostFile: []Hook{{}}}}
+	if err := cfg.check(); err == nil || !strings.Contains(err.Error(), "post_file[0]") {
+		t.Errorf("check = %v, want the empty hook reported", err)
+	}
+}
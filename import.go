@@ -0,0 +1,223 @@
+package wptsync
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ImportOptions configures an Import run. A nil *ImportOptions is equivalent
+// to its zero value.
+type ImportOptions struct {
+	SyncOptions
+	// Commit is the WPT commit the vendored files are matched against.
+	// Empty means the latest commit of the upstream ref.
+	Commit string
+}
+
+// ImportResult describes how Import matched the files it found.
+type ImportResult struct {
+	// Commit is the commit the files were matched against.
+	Commit string
+	// Matched lists the entries for files identical to a file upstream.
+	Matched []FileSpec
+	// Modified lists the entries for files found upstream at the same path
+	// (or its .any.js source) with other content: local edits, or a copy
+	// from another commit.
+	Modified []FileSpec
+	// Unmatched lists the files, relative to the imported directory, that
+	// match nothing upstream.
+	Unmatched []string
+}
+
+// Import creates a configuration at configPath for WPT files vendored by
+// hand under dir, which becomes its target directory and must be below the
+// configuration's directory. Each file is matched against the WPT tree at
+// opts.Commit: by content anywhere in the top-level folders the files are
+// in, or else by path (where x.js may come from x.any.js). Files identical to upstream are
+// also recorded in the lock, so that the next sync leaves them alone. It
+// returns an error if configPath already exists.
+func Import(ctx context.Context, configPath, dir string, opts *ImportOptions) (*ImportResult, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	if err := opts.SyncOptions.validate(); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(configPath); err == nil {
+		return nil, invalidConfig(fmt.Errorf("config file %q already exists", configPath))
+	}
+
+	root, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return nil, fmt.Errorf("determine repo root from config: %w", err)
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	targetDir, err := filepath.Rel(root, absDir)
+	if err != nil || !filepath.IsLocal(targetDir) {
+		return nil, invalidConfig(fmt.Errorf("%s is not below the configuration's directory %s", dir, root))
+	}
+	cfg := Config{TargetDir: filepath.ToSlash(targetDir), Files: []FileSpec{}}
+	syncOpts := opts.SyncOptions.forConfig(&cfg)
+
+	local, err := vendoredFiles(absDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(local) == 0 {
+		return nil, invalidConfig(fmt.Errorf("no files found under %s", dir))
+	}
+
+	resolveCtx, cancel := withTimeout(ctx, syncOpts.timeouts().Resolve)
+	defer cancel()
+	gh := syncOpts.github()
+	cfg.Commit = opts.Commit
+	if cfg.Commit == "" {
+		fmt.Printf("Fetching latest WPT commit...\n")
+		if cfg.Commit, err = gh.latestCommit(resolveCtx); err != nil {
+			return nil, fmt.Errorf("fetch latest commit: %w", err)
+		}
+	}
+
+	// List each top-level folder the vendored files are in once, with the
+	// blob hashes to match their content against.
+	byPath := make(map[string]string)
+	bySHA := make(map[string][]string)
+	listed := make(map[string]bool)
+	for _, p := range local {
+		top, _, _ := strings.Cut(p, "/")
+		if listed[top] {
+			continue
+		}
+		listed[top] = true
+		fmt.Printf("Fetching file list from %s...\n", top)
+		blobs, err := gh.listBlobs(resolveCtx, cfg.Commit, top)
+		var notFound *pathNotFoundError
+		if errors.As(err, &notFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list files: %w", err)
+		}
+		for _, b := range blobs {
+			byPath[b.Path] = b.SHA
+			bySHA[b.SHA] = append(bySHA[b.SHA], b.Path)
+		}
+	}
+
+	result := &ImportResult{Commit: cfg.Commit}
+	for _, p := range local {
+		content, err := os.ReadFile(filepath.Join(absDir, filepath.FromSlash(p)))
+		if err != nil {
+			return nil, err
+		}
+		sha := gitBlobSHA(string(content))
+		candidates := []string{p}
+		if strings.HasSuffix(p, ".js") && !strings.HasSuffix(p, ".any.js") {
+			candidates = append(candidates, strings.TrimSuffix(p, ".js")+".any.js")
+		}
+		srcs := bySHA[sha]
+		if len(content) == 0 {
+			// Empty files are too common to match by content alone.
+			srcs = slices.DeleteFunc(slices.Clone(srcs), func(src string) bool { return !slices.Contains(candidates, src) })
+		}
+		if src, ok := matchContent(candidates, srcs); ok {
+			result.Matched = append(result.Matched, FileSpec{Src: src, Dst: p})
+			continue
+		}
+		i := slices.IndexFunc(candidates, func(c string) bool { return byPath[c] != "" })
+		if i >= 0 {
+			result.Modified = append(result.Modified, FileSpec{Src: candidates[i], Dst: p})
+			continue
+		}
+		result.Unmatched = append(result.Unmatched, p)
+	}
+
+	cfg.Files = append(slices.Clone(result.Matched), result.Modified...)
+	slices.SortFunc(cfg.Files, func(a, b FileSpec) int { return cmp.Compare(a.Dst, b.Dst) })
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if err := SaveConfig(configPath, &cfg); err != nil {
+		return nil, err
+	}
+
+	lock := &lockFile{Commit: cfg.Commit, Files: map[string]lockEntry{}}
+	for _, file := range result.Matched {
+		entry, err := newLockEntry(root, &cfg, file)
+		if err != nil {
+			return nil, err
+		}
+		lock.Files[file.Dst] = entry
+	}
+	if err := saveLock(lockPath(configPath), lock, cfg.CompressState); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// matchContent picks the source of a vendored file among srcs, the upstream
+// files with its content: one of candidates, the paths it would have if
+// vendored in place, or else the only one, or the only one with its base
+// name.
+func matchContent(candidates, srcs []string) (string, bool) {
+	for _, c := range candidates {
+		if slices.Contains(srcs, c) {
+			return c, true
+		}
+	}
+	if len(srcs) == 1 {
+		return srcs[0], true
+	}
+	var named []string
+	for _, src := range srcs {
+		if path.Base(src) == path.Base(candidates[0]) {
+			named = append(named, src)
+		}
+	}
+	if len(named) == 1 {
+		return named[0], true
+	}
+	return "", false
+}
+
+// vendoredFiles returns the regular files below dir, as sorted slash paths
+// relative to it, skipping hidden files and directories.
+func vendoredFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", dir, err)
+	}
+	slices.Sort(files)
+	return files, nil
+}
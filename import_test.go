@@ -0,0 +1,80 @@
+package wptsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestImportMatchesVendoredFiles(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	blob := func(p, content string) string {
+		return fmt.Sprintf(`{"path":%q,"type":"blob","sha":%q}`, p, gitBlobSHA(content))
+	}
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1": `{"tree":[{"path":"url","type":"tree","sha":"t-url"}]}`,
+		"/repos/o/n/git/trees/t-url?recursive=1": `{"tree":[` +
+			blob("a.any.js", "a\n") + `,` +
+			blob("b.js", "b\n") + `,` +
+			blob("resources/helper.js", "helper\n") + `]}`,
+	})
+
+	dir := t.TempDir()
+	for rel, content := range map[string]string{
+		"url/a.js":       "a\n",           // .any.js renamed, as add does
+		"url/b.js":       "b\nlocal();\n", // edited in place
+		"url/helper.js":  "helper\n",      // moved
+		"url/ours.js":    "ours\n",        // not from WPT
+		"url/.gitignore": "*\n",           // hidden
+	} {
+		p := filepath.Join(dir, "wpt", filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	configPath := filepath.Join(dir, "wpt.json")
+	result, err := Import(context.Background(), configPath, filepath.Join(dir, "wpt"), &ImportOptions{SyncOptions: SyncOptions{APIURL: apiURL}, Commit: "c1"})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	wantMatched := []FileSpec{{Src: "url/a.any.js", Dst: "url/a.js"}, {Src: "url/resources/helper.js", Dst: "url/helper.js"}}
+	if !slices.EqualFunc(result.Matched, wantMatched, func(a, b FileSpec) bool { return a.Src == b.Src && a.Dst == b.Dst }) {
+		t.Errorf("Matched = %+v, want %+v", result.Matched, wantMatched)
+	}
+	if len(result.Modified) != 1 || result.Modified[0].Src != "url/b.js" {
+		t.Errorf("Modified = %+v, want url/b.js", result.Modified)
+	}
+	if want := []string{"url/ours.js"}; !slices.Equal(result.Unmatched, want) {
+		t.Errorf("Unmatched = %q, want %q", result.Unmatched, want)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Commit != "c1" || cfg.TargetDir != "wpt" || len(cfg.Files) != 3 {
+		t.Errorf("config = %+v, want commit c1, target_dir wpt, and 3 files", cfg)
+	}
+	// Identical files are locked as synced; the modified one is not.
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range cfg.Files {
+		if got, want := lock.isFresh(dir, cfg, f), f.Dst != "url/b.js"; got != want {
+			t.Errorf("%s fresh in lock = %v, want %v", f.Dst, got, want)
+		}
+	}
+
+	if _, err := Import(context.Background(), configPath, filepath.Join(dir, "wpt"), nil); ExitCode(err) != ExitConfig {
+		t.Errorf("Import over an existing config = %v, want a configuration error", err)
+	}
+}
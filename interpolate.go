@@ -0,0 +1,157 @@
+package wptsync
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// interpolated records a config value that was written with ${VAR}
+// references: raw is the text on disk, value what it expanded to.
+type interpolated struct {
+	raw, value string
+}
+
+// interpolatedFields returns pointers to the config values that may use
+// ${VAR} references, keyed by how errors and SaveConfig name them. A file's
+// patch is keyed by its dst, which check keeps unique.
+func (c *Config) interpolatedFields() map[string]*string {
+	fields := map[string]*string{
+		"repo":         &c.Repo,
+		"ref":          &c.Ref,
+		"raw_base_url": &c.RawBaseURL,
+		"api_url":      &c.APIURL,
+		"target_dir":   &c.TargetDir,
+		"dst_script":   &c.DstScript,
+		"source":       &c.Source,
+	}
+	for i := range c.Files {
+		fields["patch of "+c.Files[i].Dst] = &c.Files[i].Patch
+	}
+	for i := range c.Groups {
+		g := &c.Groups[i]
+		fields["target_dir of group "+g.Name] = &g.TargetDir
+		for j := range g.Files {
+			fields["patch of "+g.Files[j].Dst+" in group "+g.Name] = &g.Files[j].Patch
+		}
+	}
+	return fields
+}
+
+// expandEnv replaces the ${VAR} references in c's interpolated fields with
+// the environment, remembering the raw text so SaveConfig writes it back.
+// Every unset variable is reported, not just the first.
+func (c *Config) expandEnv() error {
+	var errs []error
+	fields := c.interpolatedFields()
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		p := fields[key]
+		if !strings.Contains(*p, "$") {
+			continue
+		}
+		value, err := interpolate(*p, os.LookupEnv)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		if value == *p {
+			continue
+		}
+		if c.interpolated == nil {
+			c.interpolated = make(map[string]interpolated)
+		}
+		c.interpolated[key] = interpolated{raw: *p, value: value}
+		*p = value
+	}
+	return errors.Join(errs...)
+}
+
+// unexpanded returns a copy of c with every interpolated field that still
+// holds its expanded value put back to the ${VAR} text it was loaded from.
+// Fields changed since loading are written as they are.
+func (c *Config) unexpanded() *Config {
+	if len(c.interpolated) == 0 {
+		return c
+	}
+	out := *c
+	out.Files = append([]FileSpec(nil), c.Files...)
+	out.Groups = append([]SyncGroup(nil), c.Groups...)
+	for i := range out.Groups {
+		out.Groups[i].Files = append([]FileSpec(nil), c.Groups[i].Files...)
+	}
+	for key, p := range out.interpolatedFields() {
+		if t, ok := c.interpolated[key]; ok && *p == t.value {
+			*p = t.raw
+		}
+	}
+	return &out
+}
+
+// interpolate expands the ${VAR} and ${VAR:-default} references in s using
+// lookup; "$$" stands for a literal "$" and any other "$" is kept as is. A
+// reference to an unset variable without a default is an error naming it.
+func interpolate(s string, lookup func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	var unset []string
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 || i == len(s)-1 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			s = s[i+2:]
+			continue
+		case '{':
+		default:
+			b.WriteByte('$')
+			s = s[i+1:]
+			continue
+		}
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated %q", s[i:])
+		}
+		ref := s[i+2 : i+end]
+		s = s[i+end+1:]
+		name, def, hasDef := strings.Cut(ref, ":-")
+		if !validEnvName(name) {
+			return "", fmt.Errorf("invalid variable reference ${%s}", ref)
+		}
+		if v, ok := lookup(name); ok && (v != "" || !hasDef) {
+			b.WriteString(v)
+		} else if hasDef {
+			b.WriteString(def)
+		} else {
+			unset = append(unset, name)
+		}
+	}
+	switch len(unset) {
+	case 0:
+		return b.String(), nil
+	case 1:
+		return "", fmt.Errorf("environment variable %s is not set", unset[0])
+	default:
+		return "", fmt.Errorf("environment variables %s are not set", strings.Join(unset, ", "))
+	}
+}
+
+// validEnvName reports whether name is a shell variable name.
+func validEnvName(name string) bool {
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
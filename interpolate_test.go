@@ -0,0 +1,102 @@
+package wptsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{"HOME": "/home/dev", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	for _, tt := range []struct {
+		in, want, err string
+	}{
+		{in: "${HOME}/wpt", want: "/home/dev/wpt"},
+		{in: "plain/$path", want: "plain/$path"},
+		{in: "cost$$${HOME}", want: "cost$/home/dev"},
+		{in: "${MIRROR:-https://raw.example}/x", want: "https://raw.example/x"},
+		{in: "${EMPTY:-fallback}", want: "fallback"},
+		{in: "a${EMPTY}b", want: "ab"},
+		{in: "${MISSING}", err: "environment variable MISSING is not set"},
+		{in: "${A}/${B}", err: "environment variables A, B are not set"},
+		{in: "${HOME", err: "unterminated"},
+		{in: "${1X}", err: "invalid variable reference"},
+	} {
+		got, err := interpolate(tt.in, lookup)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("interpolate(%q) error = %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("interpolate(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigExpandsEnv(t *testing.T) {
+	t.Setenv("WPT_TARGET", "third_party/wpt")
+	t.Setenv("WPT_MIRROR", "https://mirror.example/wpt")
+	dir := t.TempDir()
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:     "c1",
+		TargetDir:  "${WPT_TARGET}",
+		RawBaseURL: "${WPT_MIRROR}/raw",
+		Files:      []FileSpec{{Src: "a.js", Patch: "${WPT_PATCHES:-patches}/a.js.patch"}},
+	})
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.TargetDir != "third_party/wpt" || cfg.RawBaseURL != "https://mirror.example/wpt/raw" || cfg.Files[0].Patch != "patches/a.js.patch" {
+		t.Fatalf("expanded config = %+v", cfg)
+	}
+
+	// Saving keeps the references, except where the value was changed.
+	cfg.RawBaseURL = "https://other.example"
+	cfg.Files = append(cfg.Files, FileSpec{Src: "b.js", Dst: "b.js"})
+	if err := SaveConfig(configPath, cfg); err != nil {
+		t.Fatalf("SaveConfig: %v", err)
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"target_dir": "${WPT_TARGET}"`, `"raw_base_url": "https://other.example"`, `"patch": "${WPT_PATCHES:-patches}/a.js.patch"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("saved config lacks %s:\n%s", want, data)
+		}
+	}
+	if cfg.TargetDir != "third_party/wpt" {
+		t.Errorf("SaveConfig changed the loaded config: target_dir = %q", cfg.TargetDir)
+	}
+}
+
+func TestLoadConfigUnsetEnv(t *testing.T) {
+	configPath := saveTestConfig(t, t.TempDir(), &Config{
+		Commit:    "c1",
+		TargetDir: "${WPTSYNC_TEST_UNSET_DIR}",
+		APIURL:    "${WPTSYNC_TEST_UNSET_API}",
+	})
+	_, err := LoadConfig(configPath)
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("LoadConfig error = %v, want ErrInvalidConfig", err)
+	}
+	for _, want := range []string{
+		filepath.Base(configPath),
+		"api_url: environment variable WPTSYNC_TEST_UNSET_API is not set",
+		"target_dir: environment variable WPTSYNC_TEST_UNSET_DIR is not set",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package wptsync
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultMediaURL is the default base URL Git LFS objects are downloaded
+// from: GitHub's media host for the web-platform-tests repository.
+const DefaultMediaURL = "https://media.githubusercontent.com/media/web-platform-tests/wpt"
+
+// lfsPointerVersion is the first line of every Git LFS pointer file.
+const lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+
+// lfsPointerMaxSize bounds the files inspected for LFS pointers; real
+// pointers are well under 200 bytes.
+const lfsPointerMaxSize = 1024
+
+// ErrSubmodule marks configured paths that are git submodule entries, whose
+// contents live in another repository and cannot be synced.
+var ErrSubmodule = errors.New("path is a git submodule")
+
+// statusError is returned by download and the API clients for non-200
+// responses.
+type statusError struct {
+	code   int
+	status string
+	// service names the API that answered, if not a plain download.
+	service string
+}
+
+func (e *statusError) Error() string {
+	if e.service != "" {
+		return e.service + " returned " + e.status
+	}
+	return "unexpected status " + e.status
+}
+
+func (o *SyncOptions) mediaURL() string {
+	if o == nil || o.MediaURL == "" {
+		return DefaultMediaURL
+	}
+	return o.MediaURL
+}
+
+// fetchFile downloads src at commit to dest, or copies it from the staging
+// directory in archive and batch mode (batch mode downloads whatever was not
+// staged). A Git LFS pointer is replaced by the object it points to, and a
+// 404 caused by src being a submodule is reported as ErrSubmodule rather
+// than a bare status. With a cache directory, fetched files are kept there
+// and served from it next time.
+func fetchFile(ctx context.Context, commit, src, dest string, opts *SyncOptions) error {
+	_, err := fetchFileIfNoneMatch(ctx, commit, src, dest, "", "", opts)
+	return err
+}
+
+// fetchFileIfNoneMatch is fetchFile with a conditional request: when src is
+// downloaded, etag is sent as If-None-Match and the response's ETag is
+// returned, or errNotModified if src still matches etag. Staged and cached
+// copies never report an ETag. When blob is set, the content fetched must
+// hash to that git blob SHA, before any Git LFS object it points to is
+// fetched; otherwise dest is removed and the error wraps ErrVerification.
+func fetchFileIfNoneMatch(ctx context.Context, commit, src, dest, etag, blob string, opts *SyncOptions) (string, error) {
+	if opts != nil && opts.checkout != "" {
+		// A local clone is as fast as the cache, and never changes a commit.
+		return "", readCheckout(ctx, opts.checkout, commit, src, dest)
+	}
+	cached := opts.cachePath(commit, src)
+	if cached != "" && loadCached(cached, dest) {
+		if blob == "" || checkBlob(dest, src, blob) == nil {
+			opts.logf("   %s from cache\n", src)
+			return "", nil
+		}
+		opts.logf("   cached copy of %s is corrupt; downloading it again\n", src)
+		os.Remove(cached)
+	}
+
+	client := opts.httpClient()
+	var err error
+	var newETag string
+	if opts != nil && opts.staged != "" {
+		err = copyStaged(opts.staged, src, dest)
+		if opts.Mode == ModeBatch && errors.Is(err, errNotStaged) {
+			newETag, err = downloadIfNoneMatch(ctx, client, fileURL(opts.baseURL(), commit, src), dest, etag)
+		}
+	} else {
+		newETag, err = downloadIfNoneMatch(ctx, client, fileURL(opts.baseURL(), commit, src), dest, etag)
+	}
+	var se *statusError
+	if errors.As(err, &se) && se.code == http.StatusNotFound {
+		if typ, cerr := opts.github().contentType(ctx, commit, src); cerr == nil && typ == "submodule" {
+			return "", fmt.Errorf("%w: %s is a submodule in WPT; wptsync cannot sync submodule contents", ErrSubmodule, src)
+		}
+	}
+	if err != nil {
+		return newETag, err
+	}
+	if blob != "" {
+		if err := checkBlob(dest, src, blob); err != nil {
+			os.Remove(dest)
+			return "", err
+		}
+	}
+
+	oid, size, ok := readLFSPointer(dest)
+	if !ok {
+		if cached != "" {
+			storeCached(cached, dest)
+		}
+		return newETag, nil
+	}
+	opts.logf("   %s is a Git LFS pointer; fetching the object\n", src)
+	if err := download(ctx, client, fileURL(opts.mediaURL(), commit, src), dest); err != nil {
+		return "", fmt.Errorf("fetch LFS object: %w", err)
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		return "", err
+	}
+	sum, err := hashFile(dest)
+	if err != nil {
+		return "", err
+	}
+	if sum != oid || info.Size() != size {
+		os.Remove(dest)
+		return "", fmt.Errorf("%w: LFS object for %s does not match its pointer (oid %s, size %d)", ErrVerification, src, oid, size)
+	}
+	if cached != "" {
+		storeCached(cached, dest)
+	}
+	return newETag, nil
+}
+
+// readLFSPointer reports whether the file at path is a Git LFS pointer and,
+// if so, returns the SHA-256 oid and size of the object it points to.
+func readLFSPointer(path string) (oid string, size int64, ok bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, false
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.Size() > lfsPointerMaxSize {
+		return "", 0, false
+	}
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() || scanner.Text() != lfsPointerVersion {
+		return "", 0, false
+	}
+	size = -1
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "oid":
+			if hash, found := strings.CutPrefix(value, "sha256:"); found {
+				oid = hash
+			}
+		case "size":
+			if size, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return "", 0, false
+			}
+		}
+	}
+	return oid, size, oid != "" && size >= 0
+}
@@ -0,0 +1,62 @@
+package wptsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncResolvesLFSPointers(t *testing.T) {
+	object := "binary fixture contents\n"
+	sum := sha256.Sum256([]byte(object))
+	pointer := fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, hex.EncodeToString(sum[:]), len(object))
+
+	content := map[string]string{
+		"/c1/fonts/a.woff":       pointer,
+		"/media/c1/fonts/a.woff": object,
+		"/c1/fonts/b.woff":       pointer,
+		"/media/c1/fonts/b.woff": "truncated",
+	}
+	server, dir, _ := newFixture(t, content)
+	opts := &SyncOptions{BaseURL: server.URL, MediaURL: server.URL + "/media"}
+
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "fonts/a.woff"}}})
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "wpt", "fonts", "a.woff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != object {
+		t.Errorf("a.woff = %q, want the LFS object", got)
+	}
+
+	configPath = saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "fonts/b.woff"}}})
+	if err := Sync(context.Background(), configPath, opts); !errors.Is(err, ErrVerification) {
+		t.Errorf("Sync with an LFS object that does not match its pointer: expected ErrVerification, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wpt", "fonts", "b.woff")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("mismatched LFS object left on disk: %v", err)
+	}
+}
+
+func TestSyncReportsSubmodules(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	server, dir, _ := newFixture(t, nil)
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/contents/tools/third_party/lib?ref=c1": `{"type":"submodule","submodule_git_url":"https://example.com/lib.git"}`,
+	})
+
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "tools/third_party/lib"}}})
+	err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, APIURL: apiURL})
+	if !errors.Is(err, ErrSubmodule) {
+		t.Errorf("Sync of a submodule: expected ErrSubmodule, got %v", err)
+	}
+}
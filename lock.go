@@ -0,0 +1,340 @@
+package wptsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrDrift marks verification failures: local files that no longer match
+// what the lock file recorded.
+var ErrDrift = errors.New("local files drifted from lock")
+
+// lockFile is the on-disk wpt.lock written next to the configuration. It
+// records the commit the files were synced at and the SHA-256 of every
+// synced file as written to disk (after patching), keyed by dst. Retired
+// lists the files earlier syncs wrote that the configuration dropped since,
+// for Prune.
+type lockFile struct {
+	Commit  string               `json:"commit"`
+	Files   map[string]lockEntry `json:"files"`
+	Retired []string             `json:"retired,omitempty"`
+}
+
+// lockEntry records a single synced file. PatchSHA256 and HeaderSHA256 are
+// the hashes of the patch applied and the license header injected when the
+// file was written, so editing either (or the transforms) invalidates the
+// entry even if the file on disk still matches. ETag is the upstream file's
+// ETag when it was downloaded, sent as If-None-Match by the next sync that
+// cannot skip it.
+type lockEntry struct {
+	Src          string `json:"src"`
+	SHA256       string `json:"sha256"`
+	PatchSHA256  string `json:"patch_sha256,omitempty"`
+	HeaderSHA256 string `json:"header_sha256,omitempty"`
+	// TransformSHA256 is the hash of the entry's transforms.
+	TransformSHA256 string `json:"transform_sha256,omitempty"`
+	ETag            string `json:"etag,omitempty"`
+	// Commit is the file's own pin (FileSpec.Commit), if it has one.
+	Commit string `json:"commit,omitempty"`
+	// Blob is the SHA of the upstream git blob the download was checked
+	// against (see SyncOptions.VerifyBlobs), if it was.
+	Blob string `json:"blob,omitempty"`
+}
+
+// sameFile reports whether e and o describe the same synced file, ignoring
+// the ETag and blob SHA, which newLockEntry cannot know.
+func (e lockEntry) sameFile(o lockEntry) bool {
+	e.ETag, e.Blob = o.ETag, o.Blob
+	return e == o
+}
+
+// conditionalETag returns the ETag to revalidate file's upstream content
+// with, or "" when the lock entry does not describe what is on disk (a 304
+// would then leave the wrong content in place).
+func (l *lockFile) conditionalETag(root string, cfg *Config, file FileSpec) string {
+	entry, ok := l.Files[file.Dst]
+	if !ok || entry.ETag == "" || !l.matchesDisk(root, cfg, file) {
+		return ""
+	}
+	return entry.ETag
+}
+
+// lockPath returns the lock file path for configPath: the config's name with
+// its extension replaced by .lock (wpt.json -> wpt.lock).
+func lockPath(configPath string) string {
+	return strings.TrimSuffix(configPath, filepath.Ext(configPath)) + ".lock"
+}
+
+// progressPath returns where a sync that failed part-way records the files
+// it did complete, for a later `sync -continue`, next to the lock file at
+// lockName (wpt.lock.partial). Keeping them out of the lock itself means
+// wpt.lock only ever describes a complete sync.
+func progressPath(lockName string) string {
+	return lockName + ".partial"
+}
+
+// saveProgress writes lock, plus the entries of the files in pending that
+// completed (those with a hash in entries), to the progress journal of the
+// lock file at lockName, compressed like the lock (see saveLock). It
+// returns how many of pending completed.
+func saveProgress(lockName string, lock *lockFile, pending []FileSpec, entries []lockEntry, compress bool) (int, error) {
+	done := 0
+	for i, file := range pending {
+		if entries[i].SHA256 != "" {
+			lock.Files[file.Dst] = entries[i]
+			done++
+		}
+	}
+	return done, saveLock(progressPath(lockName), lock, compress)
+}
+
+// loadLock reads the lock file at path, plain or compressed. A missing file
+// yields an empty lock.
+func loadLock(path string) (*lockFile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &lockFile{Files: map[string]lockEntry{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read lock %q: %w", path, err)
+	}
+	if data, err = decodeState(data); err != nil {
+		return nil, fmt.Errorf("read lock %q: %w", path, err)
+	}
+
+	var lock lockFile
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("decode lock %q: %w", path, err)
+	}
+	if lock.Files == nil {
+		lock.Files = map[string]lockEntry{}
+	}
+	return &lock, nil
+}
+
+// saveLock writes lock to path as indented JSON, framed and compressed
+// with compress (see encodeState), replacing the file by rename so that a
+// crash never leaves a torn lock. encoding/json sorts map keys, and gzip
+// runs in-process, so the output is stable across runs and machines.
+func saveLock(path string, lock *lockFile, compress bool) error {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal lock: %w", err)
+	}
+	data = append(data, '\n')
+	if compress {
+		if data, err = encodeState(data, true); err != nil {
+			return fmt.Errorf("compress lock: %w", err)
+		}
+	}
+	if err := writeFileAtomic(path, data, 0o644); err != nil {
+		return fmt.Errorf("write lock: %w", err)
+	}
+	return nil
+}
+
+// hashFile returns the hex SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// patchHash returns the hash of file's patch, or "" when it has none.
+func patchHash(root string, file FileSpec) (string, error) {
+	if file.Patch == "" {
+		return "", nil
+	}
+	patchAbs := file.Patch
+	if !filepath.IsAbs(patchAbs) {
+		patchAbs = filepath.Join(root, filepath.FromSlash(file.Patch))
+	}
+	return hashFile(patchAbs)
+}
+
+// newLockEntry hashes the synced file for file as it currently sits on disk.
+func newLockEntry(root string, cfg *Config, file FileSpec) (lockEntry, error) {
+	return (&localHasher{root: root, cfg: cfg}).lockEntry(file)
+}
+
+// lockEntry is newLockEntry hashing file with h.
+func (h *localHasher) lockEntry(file FileSpec) (lockEntry, error) {
+	root, cfg := h.root, h.cfg
+	dest := filepath.Join(root, cfg.TargetDir, filepath.FromSlash(file.Dst))
+	sum, err := h.hash(file.Dst)
+	if err != nil {
+		return lockEntry{}, fmt.Errorf("hash %s: %w", file.Dst, err)
+	}
+	patchSum, err := patchHash(root, file)
+	if err != nil {
+		return lockEntry{}, fmt.Errorf("hash patch %s: %w", file.Patch, err)
+	}
+	header, err := cfg.fileHeader(file, dest)
+	if err != nil {
+		return lockEntry{}, err
+	}
+	var headerSum string
+	if header != "" {
+		h := sha256.Sum256([]byte(header))
+		headerSum = hex.EncodeToString(h[:])
+	}
+	return lockEntry{Src: file.Src, SHA256: sum, PatchSHA256: patchSum, HeaderSHA256: headerSum, TransformSHA256: transformsHash(file), Commit: file.Commit}, nil
+}
+
+// frozenEntry returns the lock entry of frozen file as it is on disk, or
+// false when it is missing and has to be downloaded after all.
+func frozenEntry(root string, cfg *Config, file FileSpec) (lockEntry, bool, error) {
+	entry, err := newLockEntry(root, cfg, file)
+	if errors.Is(err, os.ErrNotExist) {
+		return lockEntry{}, false, nil
+	}
+	return entry, err == nil, err
+}
+
+// isFresh reports whether file is already on disk exactly as the lock
+// recorded it for cfg's commit, so it can be skipped. A pinned file only
+// depends on its own pin, which its entry records.
+func (l *lockFile) isFresh(root string, cfg *Config, file FileSpec) bool {
+	return (l.Commit == cfg.Commit || file.Commit != "") && l.matchesDisk(root, cfg, file)
+}
+
+// canSkip reports whether file can be left alone: either it is fresh at
+// cfg's commit, or changed (from upstreamChanges) says its source did not
+// change upstream and the lock still matches disk. changed is keyed by
+// paths without a leading slash, as the compare API gives them.
+func (l *lockFile) canSkip(root string, cfg *Config, file FileSpec, changed map[string]bool) bool {
+	if l.isFresh(root, cfg, file) {
+		return true
+	}
+	return changed != nil && !changed[strings.TrimLeft(file.Src, "/")] && l.matchesDisk(root, cfg, file)
+}
+
+// matchesDisk reports whether file's lock entry (source, patch, and content
+// hash) still describes what is on disk, whatever commit it was synced at.
+func (l *lockFile) matchesDisk(root string, cfg *Config, file FileSpec) bool {
+	entry, ok := l.Files[file.Dst]
+	if !ok || entry.Src != file.Src {
+		return false
+	}
+	current, err := newLockEntry(root, cfg, file)
+	if err != nil {
+		return false
+	}
+	return current.sameFile(entry)
+}
+
+// Verify checks every enabled file in the configuration at configPath
+// against the lock file written by the last sync, without touching the
+// network. It reports each drifted file and returns an error wrapping
+// ErrDrift if any file is missing, modified, absent from the lock, or lacks
+// its configured license header. With opts.VerifyBlobs, it also checks the
+// files against the upstream tree (see upstreamMismatches), over the
+// network, and returns an error wrapping ErrVerification if that is all
+// that failed.
+func Verify(ctx context.Context, configPath string, opts *SyncOptions) error {
+	root, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return fmt.Errorf("determine repo root from config: %w", err)
+	}
+
+	cfg, lockName, err := opts.loadGroup(configPath)
+	if err != nil {
+		return err
+	}
+
+	lock, err := loadLock(lockName)
+	if err != nil {
+		return err
+	}
+	if lock.Commit != cfg.Commit {
+		return fmt.Errorf("%w: lock records commit %q but config pins %q; run `wptsync sync`", ErrDrift, lock.Commit, cfg.Commit)
+	}
+	cfg = expandGlobsFromLock(cfg, lock)
+	hasher := newLocalHasher(root, cfg, opts != nil && opts.Quick, opts.logf)
+
+	var drifted []string
+	var enabled []FileSpec
+	for _, file := range cfg.Files {
+		if !file.IsEnabled() {
+			continue
+		}
+		enabled = append(enabled, file)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry, ok := lock.Files[file.Dst]
+		if !ok {
+			drifted = append(drifted, file.Dst+" (not in lock)")
+			continue
+		}
+		dest := filepath.Join(root, cfg.TargetDir, filepath.FromSlash(file.Dst))
+		sum, err := hasher.hash(file.Dst)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			drifted = append(drifted, file.Dst+" (missing)")
+		case err != nil:
+			return fmt.Errorf("hash %s: %w", file.Dst, err)
+		case sum != entry.SHA256:
+			drifted = append(drifted, file.Dst+" (modified)")
+		default:
+			header, err := cfg.fileHeader(file, dest)
+			if err != nil {
+				return err
+			}
+			if header == "" {
+				continue
+			}
+			ok, err := hasHeader(dest, header)
+			if err != nil {
+				return fmt.Errorf("read %s: %w", file.Dst, err)
+			}
+			if !ok {
+				drifted = append(drifted, file.Dst+" (header missing)")
+			}
+		}
+	}
+
+	var mismatched []string
+	if opts != nil && opts.VerifyBlobs {
+		if mismatched, err = opts.forConfig(cfg).upstreamMismatches(ctx, root, cfg, lock, enabled); err != nil {
+			return err
+		}
+	}
+
+	if len(drifted) > 0 || len(mismatched) > 0 {
+		sort.Strings(drifted)
+		sort.Strings(mismatched)
+		for _, d := range append(drifted, mismatched...) {
+			opts.logf(" ! %s\n", d)
+		}
+		if len(drifted) == 0 {
+			return fmt.Errorf("%w: %d file(s) do not match upstream", ErrVerification, len(mismatched))
+		}
+		return fmt.Errorf("%w: %d file(s)", ErrDrift, len(drifted)+len(mismatched))
+	}
+
+	if opts != nil && opts.VerifyBlobs {
+		opts.logf("All files match %s and the upstream tree\n", lockName)
+		return nil
+	}
+	opts.logf("All files match %s\n", lockName)
+	return nil
+}
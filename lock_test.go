@@ -0,0 +1,175 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncWritesLockAndSkipsUnchangedFiles(t *testing.T) {
+	content := map[string]string{
+		"/c1/a/foo.js": "content A\n",
+		"/c1/b/bar.js": "content B\n",
+	}
+	server, dir, requestCount := newFixture(t, content)
+
+	cfg := &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "a/foo.js"}, {Src: "b/bar.js"}},
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("first Sync: %v", err)
+	}
+
+	lock, err := loadLock(filepath.Join(dir, "wpt.lock"))
+	if err != nil {
+		t.Fatalf("loadLock: %v", err)
+	}
+	if lock.Commit != "c1" || len(lock.Files) != 2 {
+		t.Fatalf("lock = %+v, want commit c1 with 2 files", lock)
+	}
+
+	// Drop the stamp so Sync walks the files, and edit one of them: only
+	// the edited file should be fetched again.
+	if err := os.Remove(filepath.Join(dir, "wpt", stampFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "wpt", "a", "foo.js"), []byte("local edit\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	firstCount := requestCount()
+
+	var log strings.Builder
+	opts := &SyncOptions{
+		BaseURL: server.URL,
+		Logf:    func(format string, args ...any) { fmt.Fprintf(&log, format, args...) },
+	}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+
+	if got := requestCount() - firstCount; got != 1 {
+		t.Errorf("expected 1 re-download, got %d", got)
+	}
+	if !strings.Contains(log.String(), "b/bar.js (unchanged)") {
+		t.Errorf("expected unchanged file to be skipped, log = %q", log.String())
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "wpt", "a", "foo.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content["/c1/a/foo.js"] {
+		t.Errorf("edited file not restored: %q", got)
+	}
+}
+
+func TestVerifyDetectsDrift(t *testing.T) {
+	content := map[string]string{"/c1/a/foo.js": "content A\n"}
+	server, dir, _ := newFixture(t, content)
+
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a/foo.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	if err := Verify(context.Background(), configPath, nil); !errors.Is(err, ErrDrift) {
+		t.Errorf("Verify before any sync: expected ErrDrift, got %v", err)
+	}
+
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify after sync: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "wpt", "a", "foo.js"), []byte("drifted\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(context.Background(), configPath, nil); !errors.Is(err, ErrDrift) {
+		t.Errorf("Verify after edit: expected ErrDrift, got %v", err)
+	}
+}
+
+func TestSyncChangedOnlySkipsUnchangedUpstream(t *testing.T) {
+	content := map[string]string{
+		"/c1/a/foo.js":         "content A v1\n",
+		"/c1/b/bar.js":         "content B\n",
+		"/c2/a/foo.js":         "content A v2\n",
+		"/c2/b/bar.js":         "content B\n",
+		"/api/compare/c1...c2": `{"files":[{"filename":"a/foo.js"}]}`,
+	}
+	server, dir, requestCount := newFixture(t, content)
+
+	cfg := &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "a/foo.js"}, {Src: "b/bar.js"}},
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	opts := &SyncOptions{BaseURL: server.URL, APIURL: server.URL + "/api", ChangedOnly: true}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("first Sync: %v", err)
+	}
+
+	cfg.Commit = "c2"
+	if err := SaveConfig(configPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+	firstCount := requestCount()
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+
+	// One compare request plus one download for the changed file.
+	if got := requestCount() - firstCount; got != 2 {
+		t.Errorf("expected 2 requests, got %d", got)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "wpt", "a", "foo.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content["/c2/a/foo.js"] {
+		t.Errorf("changed file = %q, want %q", got, content["/c2/a/foo.js"])
+	}
+
+	lock, err := loadLock(filepath.Join(dir, "wpt.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Commit != "c2" || len(lock.Files) != 2 {
+		t.Errorf("lock = %+v, want commit c2 with both files", lock)
+	}
+}
+
+func TestSyncChangedOnlyLeadingSlashSrc(t *testing.T) {
+	content := map[string]string{
+		"/c1/common/x.js":      "x v1\n",
+		"/c2/common/x.js":      "x v2\n",
+		"/api/compare/c1...c2": `{"files":[{"filename":"common/x.js"}]}`,
+	}
+	server, dir, _ := newFixture(t, content)
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "/common/x.js", Dst: "common/x.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	opts := &SyncOptions{BaseURL: server.URL, APIURL: server.URL + "/api", ChangedOnly: true}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("first Sync: %v", err)
+	}
+	cfg.Commit = "c2"
+	if err := SaveConfig(configPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "common", "x.js")); string(got) != "x v2\n" {
+		t.Errorf("x.js = %q, want the content changed upstream", got)
+	}
+}
@@ -0,0 +1,107 @@
+package wptsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// manifestVersion is the WPT MANIFEST.json format version wptsync reads.
+const manifestVersion = 8
+
+// manifestFile is a file listed in a WPT manifest.
+type manifestFile struct {
+	// Type is the manifest item type: testharness, reftest, support, ...
+	Type string
+	// Tests counts the test URLs the file expands to: one per global and
+	// variant for an .any.js test, for example.
+	Tests int
+}
+
+// wptManifest maps repository paths to what WPT's manifest says they are.
+type wptManifest map[string]manifestFile
+
+// fetchManifest downloads the MANIFEST.json generated for commit from the
+// wpt.fyi instance at base.
+func fetchManifest(ctx context.Context, base, commit string, opts *SyncOptions) (wptManifest, error) {
+	var raw struct {
+		Version int                                   `json:"version"`
+		Items   map[string]map[string]json.RawMessage `json:"items"`
+	}
+	if err := getJSON(ctx, opts.httpClient(), base+"/api/manifest?sha="+url.QueryEscape(commit), &raw); err != nil {
+		return nil, fmt.Errorf("fetch MANIFEST.json at %s: %w", shortSHA(commit), err)
+	}
+	if raw.Version != manifestVersion {
+		return nil, fmt.Errorf("MANIFEST.json at %s has version %d; only version %d is supported", shortSHA(commit), raw.Version, manifestVersion)
+	}
+
+	m := make(wptManifest)
+	for typ, tree := range raw.Items {
+		if err := m.addTree(typ, "", tree); err != nil {
+			return nil, fmt.Errorf("decode MANIFEST.json: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// addTree adds the files of one manifest item type below dir. Directories
+// are objects keyed by name; files are arrays of a hash and then one entry
+// per test URL.
+func (m wptManifest) addTree(typ, dir string, tree map[string]json.RawMessage) error {
+	for name, raw := range tree {
+		p := path.Join(dir, name)
+		if strings.HasPrefix(strings.TrimSpace(string(raw)), "{") {
+			var sub map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &sub); err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			if err := m.addTree(typ, p, sub); err != nil {
+				return err
+			}
+			continue
+		}
+		var entry []json.RawMessage
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		m[p] = manifestFile{Type: typ, Tests: max(len(entry)-1, 0)}
+	}
+	return nil
+}
+
+// under returns the paths of the files at or below wptPath, sorted.
+func (m wptManifest) under(wptPath string) []string {
+	var paths []string
+	for p := range m {
+		if wptPath == "" || p == wptPath || strings.HasPrefix(p, wptPath+"/") {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// summarize describes the files among paths by type, such as
+// "12 testharness (31 tests), 3 support".
+func (m wptManifest) summarize(paths []string) string {
+	files, tests := make(map[string]int), make(map[string]int)
+	for _, p := range paths {
+		f := m[p]
+		files[f.Type]++
+		tests[f.Type] += f.Tests
+	}
+	var parts []string
+	for _, typ := range slices.Sorted(maps.Keys(files)) {
+		part := fmt.Sprintf("%d %s", files[typ], typ)
+		if typ != "support" && tests[typ] != files[typ] {
+			part += fmt.Sprintf(" (%d tests)", tests[typ])
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,68 @@
+package wptsync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+const testManifest = `{"version": 8, "url_base": "/", "items": {
+	"testharness": {"url": {
+		"a.any.js": ["h1", ["url/a.any.html?1-10", {}], ["url/a.any.html?11-last", {}], ["url/a.any.worker.html", {}]],
+		"b.html": ["h2", [null, {}]]
+	}},
+	"reftest": {"url": {"c.html": ["h3", [null, [["/url/c-ref.html", "=="]], {}]]}},
+	"support": {"url": {"c-ref.html": ["h4", [null, {}]], "resources": {"helper.js": ["h5", [null, {}]]}}}
+}}`
+
+func TestAddFromManifestByType(t *testing.T) {
+	fyi := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/manifest" || r.URL.Query().Get("sha") != "c1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testManifest))
+	}))
+	t.Cleanup(fyi.Close)
+
+	add := func(opts *AddOptions) []string {
+		t.Helper()
+		configPath := saveTestConfig(t, t.TempDir(), &Config{Commit: "c1", TargetDir: "wpt"})
+		opts.ManifestURL = fyi.URL
+		if err := Add(context.Background(), configPath, "url", opts); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		cfg, err := LoadConfig(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var srcs []string
+		for _, f := range cfg.Files {
+			srcs = append(srcs, f.Src)
+		}
+		return srcs
+	}
+
+	if got, want := add(&AddOptions{Types: []string{"testharness"}}), []string{"url/a.any.js", "url/b.html"}; !slices.Equal(got, want) {
+		t.Errorf("-type testharness added %q, want %q", got, want)
+	}
+	if got, want := add(&AddOptions{Manifest: true, Include: []string{"*.js"}}), []string{"url/a.any.js", "url/resources/helper.js"}; !slices.Equal(got, want) {
+		t.Errorf("-manifest -include *.js added %q, want %q", got, want)
+	}
+	if got := add(&AddOptions{Manifest: true}); len(got) != 5 {
+		t.Errorf("-manifest added %q, want all 5 files", got)
+	}
+}
+
+func TestManifestSummarize(t *testing.T) {
+	m := wptManifest{
+		"url/a.any.js":   {Type: "testharness", Tests: 3},
+		"url/b.html":     {Type: "testharness", Tests: 1},
+		"url/c-ref.html": {Type: "support", Tests: 1},
+	}
+	if got, want := m.summarize(m.under("url")), "1 support, 2 testharness (4 tests)"; got != want {
+		t.Errorf("summarize = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+package wptsync
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DstRule is a rule of Config.Mapping, which names the files of the entries
+// that leave out their dst. The parts of a rule apply in the order of its
+// fields.
+type DstRule struct {
+	// Match limits the rule to the srcs matching this pattern, as -only
+	// matches them: "url/**" for everything below url/, "*.any.js" for
+	// .any.js files at any depth. Empty matches every src.
+	Match string `json:"match,omitempty"`
+	// StripPrefix removes this leading directory, such as "css/", from
+	// paths that start with it.
+	StripPrefix string `json:"strip_prefix,omitempty"`
+	// Flatten drops every directory, keeping the file name only.
+	Flatten bool `json:"flatten,omitempty"`
+	// Ext renames file name endings, such as {".any.js": ".js"}; the
+	// longest one that matches wins.
+	Ext map[string]string `json:"ext,omitempty"`
+	// Prefix puts the path under this directory.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// check reports a rule that cannot apply.
+func (r DstRule) check() error {
+	if r.Match != "" {
+		if _, err := path.Match(r.Match, ""); err != nil {
+			return fmt.Errorf("match %q: %w", r.Match, err)
+		}
+	}
+	for _, dir := range []string{r.StripPrefix, r.Prefix} {
+		if dir != "" && !filepath.IsLocal(filepath.FromSlash(dir)) {
+			return fmt.Errorf("%q must be a relative directory", dir)
+		}
+	}
+	for from := range r.Ext {
+		if from == "" {
+			return errors.New("ext: endings to rename must not be empty")
+		}
+	}
+	return nil
+}
+
+// apply rewrites p, the dst named so far for src, if the rule matches src.
+func (r DstRule) apply(src, p string) string {
+	if r.Match != "" && !matchFilter(r.Match, src) {
+		return p
+	}
+	if r.StripPrefix != "" {
+		if rest, ok := strings.CutPrefix(p, strings.TrimSuffix(r.StripPrefix, "/")+"/"); ok {
+			p = rest
+		}
+	}
+	if r.Flatten {
+		p = path.Base(p)
+	}
+	longest := ""
+	for from := range r.Ext {
+		if strings.HasSuffix(p, from) && len(from) > len(longest) {
+			longest = from
+		}
+	}
+	if longest != "" {
+		p = strings.TrimSuffix(p, longest) + r.Ext[longest]
+	}
+	if r.Prefix != "" {
+		p = path.Join(r.Prefix, p)
+	}
+	return p
+}
+
+// mappedDst returns the dst an entry for src without one gets: src rewritten
+// by each rule of Mapping in turn, or src itself without rules.
+func (c *Config) mappedDst(src string) string {
+	p := strings.TrimLeft(src, "/")
+	for _, r := range c.Mapping {
+		p = r.apply(src, p)
+	}
+	return p
+}
+
+// withoutMappedDsts returns a copy of c whose entries leave out the dsts
+// Mapping gives them anyway, for SaveConfig. Without rules it returns c: a
+// dst of the built-in naming (defaultDst) differs from the src and is kept.
+func (c *Config) withoutMappedDsts() *Config {
+	if len(c.Mapping) == 0 {
+		return c
+	}
+	strip := func(files []FileSpec) []FileSpec {
+		out := make([]FileSpec, len(files))
+		for i, f := range files {
+			if isGlob(f.Src) && f.Dst == f.Src || !isGlob(f.Src) && f.Dst == c.mappedDst(f.Src) {
+				f.Dst = ""
+			}
+			out[i] = f
+		}
+		return out
+	}
+	out := *c
+	out.Files = strip(c.Files)
+	out.Groups = append([]SyncGroup(nil), c.Groups...)
+	for i := range out.Groups {
+		out.Groups[i].Files = strip(c.Groups[i].Files)
+	}
+	return &out
+}
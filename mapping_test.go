@@ -0,0 +1,86 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMappedDst(t *testing.T) {
+	cfg := &Config{Mapping: []DstRule{
+		{Ext: map[string]string{".js": ".mjs", ".any.js": ".js"}},
+		{Match: "css/**", StripPrefix: "css/", Prefix: "styles"},
+		{Match: "*/resources/**", Flatten: true, Prefix: "resources"},
+	}}
+	for src, want := range map[string]string{
+		"url/a.any.js":            "url/a.js",
+		"url/b.js":                "url/b.mjs",
+		"css/grid/c.html":         "styles/grid/c.html",
+		"url/resources/data.json": "resources/data.json",
+		"fetch/api/d.html":        "fetch/api/d.html",
+	} {
+		if got := cfg.mappedDst(src); got != want {
+			t.Errorf("mappedDst(%q) = %q, want %q", src, got, want)
+		}
+	}
+	if got := (&Config{}).mappedDst("url/a.any.js"); got != "url/a.any.js" {
+		t.Errorf("mappedDst without rules = %q, want the src", got)
+	}
+}
+
+func TestAddLeavesOutMappedDsts(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1":                `{"tree":[{"path":"url","type":"tree","sha":"t-url"}]}`,
+		"/repos/o/n/git/trees/t-url?recursive=1": `{"tree":[{"path":"a.any.js","type":"blob"},{"path":"b.js","type":"blob"}]}`,
+	})
+	configPath := saveTestConfig(t, t.TempDir(), &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Mapping:   []DstRule{{StripPrefix: "url", Ext: map[string]string{".any.js": ".js"}}},
+		Files:     []FileSpec{{Src: "url/old.js", Dst: "legacy/old.js"}},
+	})
+	if err := Add(context.Background(), configPath, "url", &AddOptions{SyncOptions: SyncOptions{APIURL: apiURL}}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), `"dst"`); n != 1 {
+		t.Errorf("config has %d dsts, want only the explicit one:\n%s", n, data)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FileSpec{
+		{Src: "url/old.js", Dst: "legacy/old.js"},
+		{Src: "url/a.any.js", Dst: "a.js"},
+		{Src: "url/b.js", Dst: "b.js"},
+	}
+	if !reflect.DeepEqual(cfg.Files, want) {
+		t.Errorf("files = %+v, want %+v", cfg.Files, want)
+	}
+}
+
+func TestMappingCollisionsAreRejected(t *testing.T) {
+	cfg := &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Mapping:   []DstRule{{Flatten: true}},
+		Files:     []FileSpec{{Src: "url/a.js"}, {Src: "fetch/a.js"}},
+	}
+	if err := cfg.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.check(); err == nil || !strings.Contains(err.Error(), `dst "a.js" is written by url/a.js and fetch/a.js`) {
+		t.Errorf("check = %v, want the flattened collision reported", err)
+	}
+	cfg.Mapping = []DstRule{{Prefix: "../out"}}
+	if err := cfg.check(); err == nil || !strings.Contains(err.Error(), "mapping[0]") {
+		t.Errorf("check = %v, want the escaping prefix reported", err)
+	}
+}
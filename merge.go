@@ -0,0 +1,168 @@
+package wptsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fileMerge holds the three inputs of a merge run by mergePatch and its
+// result.
+type fileMerge struct {
+	base, ours, theirs []byte
+	merged             []byte
+	conflicted         bool
+}
+
+// mergePatch rescues a patch that no longer applies after an update by
+// three-way merging the file: the patched file at oldCommit is one side, the
+// new upstream file (left pristine at dest by the failed processFile) the
+// other, and the upstream file at oldCommit their base. A clean merge is
+// finished with finishMerge; otherwise dest gets the merge with conflict
+// markers and the patch is left alone.
+func mergePatch(ctx context.Context, root string, cfg *Config, file FileSpec, oldCommit string, opts *SyncOptions) (*fileMerge, error) {
+	src := strings.TrimLeft(file.Src, "/")
+	dest := filepath.Join(root, cfg.TargetDir, filepath.FromSlash(file.Dst))
+	rel := path.Join(cfg.TargetDir, file.Dst)
+
+	tmpDir, err := os.MkdirTemp("", "wptsync-merge-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Staged copies are of the new commit, so the base is always downloaded
+	// (or read from the clone the sync reads).
+	fetchOpts := opts.unstaged()
+	timeouts := opts.timeouts()
+	base := filepath.Join(tmpDir, "base")
+	downloadCtx, cancel := withTimeout(ctx, timeouts.Download)
+	defer cancel()
+	if err := fetchFile(downloadCtx, oldCommit, src, base, fetchOpts); err != nil {
+		return nil, fmt.Errorf("download %s at %s: %w", src, shortSHA(oldCommit), err)
+	}
+	if err := applyTransforms(base, file); err != nil {
+		return nil, fmt.Errorf("transform %s: %w", src, err)
+	}
+	m := &fileMerge{}
+	if m.base, err = os.ReadFile(base); err != nil {
+		return nil, err
+	}
+
+	// The patch names its file relative to the config directory, so the
+	// old patched side is rebuilt under a root laid out like the real one.
+	oursRoot := filepath.Join(tmpDir, "ours")
+	ours := filepath.Join(oursRoot, filepath.FromSlash(rel))
+	if err := writeFileAtomic(ours, m.base, 0o644); err != nil {
+		return nil, fmt.Errorf("write temp file: %w", err)
+	}
+	patchCtx, cancel := withTimeout(ctx, timeouts.Patch)
+	defer cancel()
+	if _, err := applyPatch(patchCtx, oursRoot, patchAbsPath(root, file), opts != nil && opts.UseGit, cfg.patchFuzz()); err != nil {
+		return nil, fmt.Errorf("patch %s does not apply at %s either: %w", file.Patch, shortSHA(oldCommit), err)
+	}
+	if m.ours, err = os.ReadFile(ours); err != nil {
+		return nil, err
+	}
+
+	if m.theirs, err = os.ReadFile(dest); err != nil {
+		return nil, err
+	}
+	theirs := filepath.Join(tmpDir, "theirs")
+	if err := os.WriteFile(theirs, m.theirs, 0o644); err != nil {
+		return nil, fmt.Errorf("write temp file: %w", err)
+	}
+
+	mergeCtx, cancel := withTimeout(ctx, timeouts.Patch)
+	defer cancel()
+	m.merged, m.conflicted, err = gitMergeFile(mergeCtx, ours, base, theirs, [3]string{
+		file.Dst + " (patched)",
+		"wpt " + shortSHA(oldCommit),
+		"wpt " + shortSHA(cfg.Commit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if m.conflicted {
+		if err := writeFileAtomic(dest, m.merged, 0o644); err != nil {
+			return nil, fmt.Errorf("write merged file: %w", err)
+		}
+		return m, nil
+	}
+	return m, finishMerge(mergeCtx, root, cfg, file, m.theirs, m.merged)
+}
+
+// finishMerge writes resolved, a merged file without conflict markers, to
+// the file's dst, regenerates its patch against theirs (the new upstream
+// content), and injects its license header.
+func finishMerge(ctx context.Context, root string, cfg *Config, file FileSpec, theirs, resolved []byte) error {
+	dest := filepath.Join(root, cfg.TargetDir, filepath.FromSlash(file.Dst))
+	if err := writeFileAtomic(dest, resolved, 0o644); err != nil {
+		return fmt.Errorf("write merged file: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "wptsync-merge-")
+	if err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	pristine := filepath.Join(tmpDir, "pristine")
+	if err := os.WriteFile(pristine, theirs, 0o644); err != nil {
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	diff, err := gitDiffNoIndex(ctx, pristine, dest)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(patchAbsPath(root, file), rewritePatchPaths(diff, path.Join(cfg.TargetDir, file.Dst)), 0o644); err != nil {
+		return fmt.Errorf("write patch: %w", err)
+	}
+
+	header, err := cfg.fileHeader(file, dest)
+	if err != nil {
+		return err
+	}
+	if err := injectHeader(dest, header); err != nil {
+		return fmt.Errorf("inject license header into %s: %w", file.Dst, err)
+	}
+	return nil
+}
+
+// patchAbsPath returns the absolute path of file's patch.
+func patchAbsPath(root string, file FileSpec) string {
+	if filepath.IsAbs(file.Patch) {
+		return file.Patch
+	}
+	return filepath.Join(root, filepath.FromSlash(file.Patch))
+}
+
+// gitMergeFile three-way merges ours and theirs against base with git
+// merge-file and returns the result, which carries conflict markers (labelled
+// with labels, in ours, base, theirs order) when conflicted is true.
+func gitMergeFile(ctx context.Context, ours, base, theirs string, labels [3]string) (merged []byte, conflicted bool, err error) {
+	cmd := exec.CommandContext(ctx, "git", "merge-file", "-p",
+		"-L", labels[0], "-L", labels[1], "-L", labels[2],
+		"--", ours, base, theirs)
+	cmd.Env = subprocessEnv()
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if err == nil {
+		return out.Bytes(), false, nil
+	}
+	// git merge-file exits with the number of conflicts (capped at 127);
+	// a negative status, seen as 255, means it failed.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 && exitErr.ExitCode() < 128 {
+		return out.Bytes(), true, nil
+	}
+	return nil, false, fmt.Errorf("git merge-file: %w", err)
+}
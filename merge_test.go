@@ -0,0 +1,106 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdateMergeRescuesBrokenPatches(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	// The patch rewrites line c with four lines of context; c2 edits the
+	// context close enough that the patch no longer applies, but not the
+	// patched line itself. d.js changes the patched line, which conflicts.
+	content := map[string]string{
+		"/c1/m.js": "1\n2\n3\n4\nc\n6\n7\n8\n9\n",
+		"/c2/m.js": "1\n2\nthree\n4\nc\n6\nseven\n8\n9\n",
+		"/c1/d.js": "a\nb\nc\n",
+		"/c2/d.js": "a\nb upstream\nc\n",
+	}
+	server, dir, _ := newFixture(t, content)
+	patches := map[string]string{
+		"patches/m.js.patch": "--- a/wpt/m.js\n+++ b/wpt/m.js\n@@ -1,9 +1,9 @@\n 1\n 2\n 3\n 4\n-c\n+C\n 6\n 7\n 8\n 9\n",
+		"patches/d.js.patch": "--- a/wpt/d.js\n+++ b/wpt/d.js\n@@ -1,3 +1,3 @@\n a\n-b\n+b local\n c\n",
+	}
+	for name, patch := range patches {
+		if err := os.MkdirAll(filepath.Join(dir, "patches"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(patch), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{
+		{Src: "m.js", Patch: "patches/m.js.patch"},
+		{Src: "d.js", Patch: "patches/d.js.patch"},
+	}}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	opts := &UpdateOptions{SyncOptions: SyncOptions{BaseURL: server.URL}, Commit: "c2", Merge: true}
+	err := Update(context.Background(), configPath, opts)
+	if !errors.Is(err, ErrPatchFailed) || !strings.Contains(err.Error(), "1 file(s)") {
+		t.Fatalf("Update -merge: expected ErrPatchFailed for d.js only, got %v", err)
+	}
+
+	got, _ := os.ReadFile(filepath.Join(dir, "wpt", "m.js"))
+	if want := "1\n2\nthree\n4\nC\n6\nseven\n8\n9\n"; string(got) != want {
+		t.Errorf("m.js = %q, want the clean merge %q", got, want)
+	}
+	got, _ = os.ReadFile(filepath.Join(dir, "wpt", "d.js"))
+	for _, want := range []string{"<<<<<<< d.js (patched)\nb local\n", "=======\nb upstream\n>>>>>>> wpt c2\n"} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("d.js = %q, want conflict markers containing %q", got, want)
+		}
+	}
+
+	// The regenerated patch must apply to the new upstream on its own.
+	_, checks, err := CheckPatches(context.Background(), configPath, &CheckPatchesOptions{SyncOptions: SyncOptions{BaseURL: server.URL}})
+	if !errors.Is(err, ErrPatchFailed) || len(checks) != 2 {
+		t.Fatalf("CheckPatches after merge: expected only d.js to fail, got %v", err)
+	}
+	if checks[0].Err != nil {
+		t.Errorf("regenerated m.js patch: %v", checks[0].Err)
+	}
+}
+
+func TestUpdateInteractiveRunsMergeTool(t *testing.T) {
+	for _, tool := range []string{"git", "sh"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+
+	content := map[string]string{"/c1/d.js": "a\nb\nc\n", "/c2/d.js": "a\nb upstream\nc\n"}
+	server, dir, _ := newFixture(t, content)
+	if err := os.MkdirAll(filepath.Join(dir, "patches"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	patch := "--- a/wpt/d.js\n+++ b/wpt/d.js\n@@ -1,3 +1,3 @@\n a\n-b\n+b local\n c\n"
+	if err := os.WriteFile(filepath.Join(dir, "patches", "d.js.patch"), []byte(patch), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "d.js", Patch: "patches/d.js.patch"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	// The "tool" checks it was handed all three versions, then resolves.
+	tool := `grep -q "b local" "$LOCAL" && grep -q "b upstream" "$REMOTE" && grep -qx b "$BASE" && printf 'a\nb upstream local\nc\n' > "$MERGED"`
+	opts := &UpdateOptions{SyncOptions: SyncOptions{BaseURL: server.URL}, Commit: "c2", Interactive: true, MergeTool: tool}
+	if err := Update(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Update -interactive: %v", err)
+	}
+
+	_, checks, err := CheckPatches(context.Background(), configPath, &CheckPatchesOptions{SyncOptions: SyncOptions{BaseURL: server.URL}})
+	if err != nil || len(checks) != 1 {
+		t.Fatalf("CheckPatches after resolving: %v", err)
+	}
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify after resolving: %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package wptsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+// ErrMergeConflict marks a MergeConfig run that left conflicts to resolve.
+var ErrMergeConflict = errors.New("merge conflict")
+
+// MergeConflict is a value of the configuration that both sides of a merge
+// changed, differently.
+type MergeConflict struct {
+	// Path locates the value, with file entries named by their src:
+	// "commit", "files[url/a.js].patch", "groups[dom].files[dom/b.js]".
+	Path string
+	// Ours and Theirs are the two versions, as JSON; empty for a side that
+	// deleted the value.
+	Ours, Theirs string
+}
+
+func (c MergeConflict) String() string {
+	side := func(v string) string {
+		if v == "" {
+			return "deleted"
+		}
+		return v
+	}
+	return fmt.Sprintf("%s: ours %s, theirs %s", c.Path, side(c.Ours), side(c.Theirs))
+}
+
+// MergeConfig merges the configuration files at theirsPath into the one at
+// oursPath, given their common ancestor at basePath, the way git merge
+// drivers are run: `wptsync merge-config %O %A %B`. Rather than lines, it
+// merges values, and the files arrays by src: entries added on both sides
+// are all kept, and an entry changed on one side and not the other takes
+// the change. name is the path of the file in the repository (git's %P),
+// which decides whether it is JSON, YAML, or TOML; empty means oursPath.
+//
+// Values changed on both sides, differently, are conflicts: ours is kept,
+// and the conflicts are returned along with an error wrapping
+// ErrMergeConflict. Either way the merged configuration is written to
+// oursPath, as SaveConfig would.
+func MergeConfig(basePath, oursPath, theirsPath, name string) ([]MergeConflict, error) {
+	if name == "" {
+		name = oursPath
+	}
+	format := configFormat(name)
+	var versions [3]any
+	for i, p := range []string{basePath, oursPath, theirsPath} {
+		data, _, err := readConfigAs(p, format)
+		if err != nil {
+			return nil, invalidConfig(fmt.Errorf("read %s: %w", p, err))
+		}
+		// git passes an empty ancestor when both sides added the file.
+		if len(bytes.TrimSpace(data)) == 0 {
+			data = []byte("{}")
+		}
+		if err := json.Unmarshal(data, &versions[i]); err != nil {
+			return nil, invalidConfig(fmt.Errorf("decode %s: %w", p, err))
+		}
+	}
+
+	m := &configMerge{}
+	merged := m.value("", versions[0], versions[1], versions[2])
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("marshal merged config: %w", err)
+	}
+	var cfg Config
+	if err := decodeConfig(data, nil, &cfg); err != nil {
+		return m.conflicts, invalidConfig(fmt.Errorf("merged config: %w", err))
+	}
+	if data, err = json.MarshalIndent(&cfg, "", "  "); err != nil {
+		return nil, fmt.Errorf("marshal merged config: %w", err)
+	}
+	if format != formatJSON {
+		prev, _ := os.ReadFile(oursPath)
+		if data, err = encodeConfig(data, format, prev); err != nil {
+			return nil, fmt.Errorf("marshal merged config: %w", err)
+		}
+	}
+	if err := os.WriteFile(oursPath, data, 0o644); err != nil {
+		return nil, fmt.Errorf("write merged config: %w", err)
+	}
+	if len(m.conflicts) > 0 {
+		return m.conflicts, fmt.Errorf("%w: %d value(s) changed on both sides; kept ours", ErrMergeConflict, len(m.conflicts))
+	}
+	return nil, nil
+}
+
+// absent stands for a value a version of the configuration does not have.
+type absent struct{}
+
+// keyedArrays names the arrays MergeConfig merges entry by entry, and the
+// key identifying their entries.
+var keyedArrays = map[string]string{"files": "src", "groups": "name"}
+
+// configMerge three-way merges configurations decoded from JSON.
+type configMerge struct {
+	conflicts []MergeConflict
+}
+
+// value merges one value of base, ours, and theirs, any of which may be
+// absent{}; the result is absent{} when the value goes away.
+func (m *configMerge) value(path string, base, ours, theirs any) any {
+	switch {
+	case reflect.DeepEqual(ours, theirs), reflect.DeepEqual(base, theirs):
+		return ours
+	case reflect.DeepEqual(base, ours):
+		return theirs
+	}
+	if o, ok := ours.(map[string]any); ok {
+		if t, ok := theirs.(map[string]any); ok {
+			b, _ := base.(map[string]any)
+			return m.object(path, b, o, t)
+		}
+	}
+	if o, ok := ours.([]any); ok {
+		if t, ok := theirs.([]any); ok {
+			if key := keyedArrays[lastKey(path)]; key != "" {
+				b, _ := base.([]any)
+				if out, ok := m.keyed(path, key, b, o, t); ok {
+					return out
+				}
+			}
+		}
+	}
+	m.conflict(path, ours, theirs)
+	return ours
+}
+
+// object merges the keys of an object.
+func (m *configMerge) object(path string, base, ours, theirs map[string]any) map[string]any {
+	out := make(map[string]any)
+	for _, k := range unionKeys(ours, theirs, base) {
+		v := m.value(joinPath(path, k), get(base, k), get(ours, k), get(theirs, k))
+		if _, gone := v.(absent); !gone {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// keyed merges an array whose entries are objects identified by key, such
+// as files by src, keeping the order of ours and putting the entries only
+// theirs has after the one preceding them there. It returns false when an
+// entry has no key, for value to treat the array as a whole.
+func (m *configMerge) keyed(path, key string, base, ours, theirs []any) ([]any, bool) {
+	b, bKeys, ok1 := indexEntries(base, key)
+	o, oKeys, ok2 := indexEntries(ours, key)
+	t, tKeys, ok3 := indexEntries(theirs, key)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, false
+	}
+
+	merged := make(map[string]any)
+	for _, k := range unionKeys(o, t, b) {
+		v := m.value(fmt.Sprintf("%s[%s]", path, k), get(b, k), get(o, k), get(t, k))
+		if _, gone := v.(absent); !gone {
+			merged[k] = v
+		}
+	}
+
+	order := slices.Clone(oKeys)
+	for i, k := range tKeys {
+		if slices.Contains(order, k) {
+			continue
+		}
+		at := 0
+		for j := i - 1; j >= 0; j-- {
+			if n := slices.Index(order, tKeys[j]); n >= 0 {
+				at = n + 1
+				break
+			}
+		}
+		order = slices.Insert(order, at, k)
+	}
+	order = append(order, bKeys...)
+
+	out := []any{}
+	seen := make(map[string]bool)
+	for _, k := range order {
+		if v, ok := merged[k]; ok && !seen[k] {
+			seen[k] = true
+			out = append(out, v)
+		}
+	}
+	return out, true
+}
+
+func (m *configMerge) conflict(path string, ours, theirs any) {
+	encode := func(v any) string {
+		if _, ok := v.(absent); ok {
+			return ""
+		}
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	m.conflicts = append(m.conflicts, MergeConflict{Path: path, Ours: encode(ours), Theirs: encode(theirs)})
+}
+
+// indexEntries maps the entries of arr by their key, the second and later
+// entries of a repeated key being told apart by a "#n" suffix. It also
+// returns the keys in order.
+func indexEntries(arr []any, key string) (map[string]any, []string, bool) {
+	index := make(map[string]any, len(arr))
+	var keys []string
+	for _, e := range arr {
+		obj, ok := e.(map[string]any)
+		if !ok {
+			return nil, nil, false
+		}
+		k, ok := obj[key].(string)
+		if !ok {
+			return nil, nil, false
+		}
+		for n := 2; index[k] != nil; n++ {
+			k = obj[key].(string) + "#" + strconv.Itoa(n)
+		}
+		index[k] = e
+		keys = append(keys, k)
+	}
+	return index, keys, true
+}
+
+// unionKeys returns the keys of objs, those of the first one first.
+func unionKeys(objs ...map[string]any) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, m := range objs {
+		for _, k := range slices.Sorted(maps.Keys(m)) {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
+
+func get(m map[string]any, k string) any {
+	if v, ok := m[k]; ok {
+		return v
+	}
+	return absent{}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// lastKey returns the object key path ends with: "files" for
+// "groups[dom].files".
+func lastKey(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		switch path[i] {
+		case '.':
+			return path[i+1:]
+		case ']':
+			return ""
+		}
+	}
+	return path
+}
@@ -0,0 +1,93 @@
+package wptsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeMergeVersions(t *testing.T, ext string, base, ours, theirs string) (string, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for i, content := range []string{base, ours, theirs} {
+		p := filepath.Join(dir, string(rune('a'+i))+ext)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	return paths[0], paths[1], paths[2]
+}
+
+func TestMergeConfigMergesFilesBySrc(t *testing.T) {
+	base, ours, theirs := writeMergeVersions(t, "",
+		`{"commit": "c1", "target_dir": "wpt", "files": [
+			{"src": "url/a.js"},
+			{"src": "url/b.js", "dst": "b.js"},
+			{"src": "url/c.js"}
+		]}`,
+		`{"commit": "c1", "target_dir": "wpt", "files": [
+			{"src": "url/a.js"},
+			{"src": "url/a2.js"},
+			{"src": "url/b.js", "dst": "b.js", "patch": "patches/b.patch"},
+			{"src": "url/c.js"}
+		]}`,
+		`{"commit": "c2", "target_dir": "wpt", "files": [
+			{"src": "url/a.js"},
+			{"src": "url/b.js", "dst": "b.js", "enabled": false},
+			{"src": "url/b2.js"}
+		]}`)
+	conflicts, err := MergeConfig(base, ours, theirs, "wpt.json")
+	if err != nil || len(conflicts) > 0 {
+		t.Fatalf("MergeConfig = %v, %v, want a clean merge", conflicts, err)
+	}
+	data, err := os.ReadFile(ours)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := decodeConfig(data, nil, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	off := false
+	want := []FileSpec{
+		{Src: "url/a.js"},
+		{Src: "url/a2.js"},
+		{Src: "url/b.js", Dst: "b.js", Patch: "patches/b.patch", Enabled: &off},
+		{Src: "url/b2.js"},
+	}
+	if cfg.Commit != "c2" || !reflect.DeepEqual(cfg.Files, want) {
+		t.Errorf("merged commit %s, files %+v, want c2 and %+v", cfg.Commit, cfg.Files, want)
+	}
+}
+
+func TestMergeConfigReportsConflicts(t *testing.T) {
+	base, ours, theirs := writeMergeVersions(t, ".yaml",
+		"commit: c1\ntarget_dir: wpt\nfiles:\n  - src: url/a.js\n  - src: url/b.js\n",
+		"# pinned for the release\ncommit: c2\ntarget_dir: wpt\nfiles:\n  - src: url/a.js\n    patch: patches/a.patch\n",
+		"commit: c3\ntarget_dir: wpt\nfiles:\n  - src: url/a.js\n  - src: url/b.js\n    dst: renamed.js\n")
+	conflicts, err := MergeConfig(base, ours, theirs, "")
+	if !errors.Is(err, ErrMergeConflict) {
+		t.Fatalf("MergeConfig = %v, want ErrMergeConflict", err)
+	}
+	want := []MergeConflict{
+		{Path: "commit", Ours: `"c2"`, Theirs: `"c3"`},
+		{Path: "files[url/b.js]", Theirs: `{"dst":"renamed.js","src":"url/b.js"}`},
+	}
+	if !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("conflicts = %+v, want %+v", conflicts, want)
+	}
+	cfg, err := LoadConfig(ours)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Commit != "c2" || len(cfg.Files) != 1 || cfg.Files[0].Patch != "patches/a.patch" {
+		t.Errorf("merged config = %+v, want ours kept where both changed", cfg)
+	}
+	if data, _ := os.ReadFile(ours); string(data[:len("# pinned")]) != "# pinned" {
+		t.Errorf("merged YAML lost its comment:\n%s", data)
+	}
+}
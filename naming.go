@@ -0,0 +1,98 @@
+package wptsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// defaultDst is the built-in naming policy: dst mirrors src, with .any.js
+// mapped to .js.
+func defaultDst(src string) string {
+	if base, ok := strings.CutSuffix(src, ".any.js"); ok {
+		return base + ".js"
+	}
+	return src
+}
+
+// nameFiles computes the dst for each of srcs. Without a dst_script this is
+// what the configuration's mapping rules name (see Config.mappedDst), or
+// defaultDst without rules. With one, the script is run once from root with every src on
+// its own line on stdin and must print exactly one dst per line, in order;
+// an empty line keeps the default for that src. The script runs in the
+// isolated environment of subprocessEnv, plus dst_script_env, and also sees
+// WPTSYNC_COMMIT and WPTSYNC_TARGET_DIR.
+func (c *Config) nameFiles(ctx context.Context, root string, srcs []string) ([]string, error) {
+	dsts := make([]string, len(srcs))
+	for i, src := range srcs {
+		if len(c.Mapping) > 0 {
+			dsts[i] = c.mappedDst(src)
+		} else {
+			dsts[i] = defaultDst(src)
+		}
+	}
+	if c.DstScript == "" || len(srcs) == 0 {
+		return dsts, nil
+	}
+
+	script := c.DstScript
+	if !filepath.IsAbs(script) {
+		script = filepath.Join(root, filepath.FromSlash(script))
+	}
+	env, err := c.dstScriptEnv()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Dir = root
+	cmd.Env = env
+	cmd.Stdin = strings.NewReader(strings.Join(srcs, "\n") + "\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("dst_script %s: %w: %s", c.DstScript, err, msg)
+		}
+		return nil, fmt.Errorf("dst_script %s: %w", c.DstScript, err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if len(lines) != len(srcs) {
+		return nil, fmt.Errorf("dst_script %s: printed %d lines for %d paths", c.DstScript, len(lines), len(srcs))
+	}
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		dst := path.Clean(strings.Trim(line, "/"))
+		if !filepath.IsLocal(filepath.FromSlash(dst)) {
+			return nil, fmt.Errorf("dst_script %s: dst %q for %s escapes the target directory", c.DstScript, line, srcs[i])
+		}
+		dsts[i] = dst
+	}
+	return dsts, nil
+}
+
+// dstScriptEnv returns the environment dst_script runs in, expanding the
+// ${VAR} references in dst_script_env.
+func (c *Config) dstScriptEnv() ([]string, error) {
+	var extra []string
+	for _, name := range slices.Sorted(maps.Keys(c.DstScriptEnv)) {
+		value, err := interpolate(c.DstScriptEnv[name], os.LookupEnv)
+		if err != nil {
+			return nil, fmt.Errorf("dst_script_env: %s: %w", name, err)
+		}
+		extra = append(extra, name+"="+value)
+	}
+	extra = append(extra, "WPTSYNC_COMMIT="+c.Commit, "WPTSYNC_TARGET_DIR="+c.TargetDir)
+	return subprocessEnv(extra...), nil
+}
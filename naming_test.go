@@ -0,0 +1,96 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestAddNamesFilesWithDstScript(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	t.Setenv("GITHUB_TOKEN", "")
+
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/git/trees/c1":                `{"tree":[{"path":"url","type":"tree","sha":"t-url"}]}`,
+		"/repos/o/n/git/trees/t-url?recursive=1": `{"tree":[{"path":"a.any.js","type":"blob"},{"path":"resources/helper.js","type":"blob"}]}`,
+	})
+
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" +
+		"while read -r src; do\n" +
+		"  case \"$src\" in\n" +
+		"    */resources/*) echo ;;\n" +
+		"    *) echo \"$WPTSYNC_COMMIT/$(basename \"$src\" .any.js).test.js\" ;;\n" +
+		"  esac\n" +
+		"done\n"
+	if err := os.WriteFile(filepath.Join(dir, "name.sh"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", DstScript: "name.sh"})
+
+	if err := Add(context.Background(), configPath, "url", &AddOptions{SyncOptions: SyncOptions{APIURL: apiURL}}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range cfg.Files {
+		got = append(got, f.Src+" -> "+f.Dst)
+	}
+	want := []string{
+		"url/a.any.js -> c1/a.test.js",
+		"url/resources/helper.js -> url/resources/helper.js",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
+
+func TestDstScriptMustNameEveryFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "name.sh"), []byte("#!/bin/sh\necho only-one\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", DstScript: "name.sh"}
+	if _, err := cfg.nameFiles(context.Background(), dir, []string{"a.js", "b.js"}); err == nil {
+		t.Error("nameFiles accepted one line for two paths")
+	}
+}
+
+func TestDstScriptRunsInIsolatedEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	t.Setenv("MIRROR_TOKEN", "secret")
+	t.Setenv("GIT_DIR", "/elsewhere/.git")
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+	t.Setenv("PREFIX_SOURCE", "vendor")
+
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" +
+		"while read -r src; do\n" +
+		"  echo \"$PREFIX/${MIRROR_TOKEN:-none}-${GIT_DIR:-none}-$LC_ALL-$GIT_CONFIG_NOSYSTEM/$src\"\n" +
+		"done\n"
+	if err := os.WriteFile(filepath.Join(dir, "name.sh"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", DstScript: "name.sh", DstScriptEnv: map[string]string{"PREFIX": "${PREFIX_SOURCE}"}}
+	dsts, err := cfg.nameFiles(context.Background(), dir, []string{"a.js"})
+	if err != nil {
+		t.Fatalf("nameFiles: %v", err)
+	}
+	if want := []string{"vendor/none-none-C-1/a.js"}; !slices.Equal(dsts, want) {
+		t.Errorf("dsts = %q, want %q", dsts, want)
+	}
+}
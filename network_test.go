@@ -0,0 +1,115 @@
+package wptsync
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncThroughConfiguredNetwork(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("MIRROR_TOKEN", "s3cret")
+	var gotHeader string
+	mirror := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get("X-Mirror-Token")
+		if r.URL.Path != "/c1/url/a.js" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("a\n"))
+	}))
+	t.Cleanup(mirror.Close)
+
+	dir := t.TempDir()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: mirror.Certificate().Raw})
+	if err := os.WriteFile(filepath.Join(dir, "corp-ca.pem"), ca, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		Commit:     "c1",
+		TargetDir:  "wpt",
+		RawBaseURL: mirror.URL,
+		Files:      []FileSpec{{Src: "url/a.js"}},
+		Network:    &Network{Headers: map[string]string{"X-Mirror-Token": "${MIRROR_TOKEN}"}},
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, &SyncOptions{Retries: -1}); err == nil {
+		t.Fatal("Sync trusted a certificate of an unknown CA")
+	}
+
+	cfg.Network.CABundle = "corp-ca.pem"
+	saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, &SyncOptions{}); err != nil {
+		t.Fatalf("Sync with the CA bundle: %v", err)
+	}
+	if gotHeader != "s3cret" {
+		t.Errorf("X-Mirror-Token = %q, want the expanded header", gotHeader)
+	}
+
+	cfg.Network.CABundle = "missing.pem"
+	saveTestConfig(t, dir, cfg)
+	err := Sync(context.Background(), configPath, &SyncOptions{Force: true})
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "ca_bundle") {
+		t.Errorf("Sync with a missing CA bundle = %v, want an invalid config naming it", err)
+	}
+}
+
+func TestSyncThroughProxy(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	// The fixture serves the absolute URLs a proxy receives by their path.
+	proxy, dir, count := newFixture(t, map[string]string{"/c1/url/a.js": "a\n"})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "url/a.js"}}})
+	opts := &SyncOptions{BaseURL: "http://wpt.invalid", Network: Network{Proxy: proxy.URL}}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync through the proxy: %v", err)
+	}
+	if count() != 1 {
+		t.Errorf("proxy saw %d requests, want 1", count())
+	}
+
+	if err := (&Config{Commit: "c1", TargetDir: "wpt", Network: &Network{Timeout: "30"}}).check(); err == nil || !strings.Contains(err.Error(), "network: timeout") {
+		t.Errorf("check with a timeout lacking a unit = %v", err)
+	}
+}
+
+func TestNetworkHeadersOnlyReachTheirHosts(t *testing.T) {
+	t.Setenv("MIRROR_TOKEN", "s3cret")
+	got := make(map[string]string)
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got[name] = r.Header.Get("Authorization") })
+	}
+	mirror := httptest.NewServer(handler("mirror"))
+	t.Cleanup(mirror.Close)
+	other := httptest.NewServer(handler("other"))
+	t.Cleanup(other.Close)
+
+	n := Network{Headers: map[string]string{"Authorization": "Bearer ${MIRROR_TOKEN}"}}
+	for _, tc := range []struct {
+		hosts []string
+		want  map[string]string
+	}{
+		{nil, map[string]string{"mirror": "Bearer s3cret", "other": ""}},
+		{[]string{strings.TrimPrefix(other.URL, "http://")}, map[string]string{"mirror": "", "other": "Bearer s3cret"}},
+	} {
+		n.HeaderHosts = tc.hosts
+		client := networkClient(n, t.TempDir(), mirror.URL+"/wpt")
+		for _, u := range []string{mirror.URL, other.URL} {
+			resp, err := client.Get(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+		for name, want := range tc.want {
+			if got[name] != want {
+				t.Errorf("header_hosts %q: %s got Authorization %q, want %q", tc.hosts, name, got[name], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package wptsync
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DefaultLicenseDst and DefaultNoticesFile are where Notices places the
+// upstream license and the attribution list in target_dir when its License
+// and File are empty.
+const (
+	DefaultLicenseDst  = "LICENSE.md"
+	DefaultNoticesFile = "THIRD_PARTY_NOTICES"
+)
+
+// licenseSrc is the upstream path of WPT's license.
+const licenseSrc = "LICENSE.md"
+
+// Notices ships what redistributing the synced files requires: the upstream
+// LICENSE.md at the pinned commit, synced like any other file, and a list of
+// every synced upstream path and the commit it came from, written again on
+// every sync.
+type Notices struct {
+	// License is the dst of the upstream LICENSE.md in target_dir. Empty
+	// means DefaultLicenseDst.
+	License string `json:"license,omitempty"`
+	// File is the attribution list in target_dir. Empty means
+	// DefaultNoticesFile.
+	File string `json:"file,omitempty"`
+}
+
+func (n *Notices) license() string {
+	if n.License == "" {
+		return DefaultLicenseDst
+	}
+	return strings.Trim(n.License, "/")
+}
+
+func (n *Notices) file() string {
+	if n.File == "" {
+		return DefaultNoticesFile
+	}
+	return strings.Trim(n.File, "/")
+}
+
+func (n *Notices) check() error {
+	for key, p := range map[string]string{"license": n.license(), "file": n.file()} {
+		if !filepath.IsLocal(filepath.FromSlash(p)) {
+			return fmt.Errorf("notices: %s %q escapes the target directory", key, p)
+		}
+	}
+	if n.license() == n.file() {
+		return fmt.Errorf("notices: license and file are both %q", n.file())
+	}
+	return nil
+}
+
+// licenseEntry is the entry that syncs the upstream license, as upstream
+// has it.
+func (n *Notices) licenseEntry() FileSpec {
+	off := false
+	return FileSpec{Src: licenseSrc, Dst: n.license(), Provenance: &off}
+}
+
+// withLicense returns cfg with the entry syncing the upstream license when
+// it has Notices and does not list LICENSE.md itself.
+func withLicense(cfg *Config) *Config {
+	if cfg.Notices == nil || explicitSrcs(cfg)[licenseSrc] {
+		return cfg
+	}
+	out := *cfg
+	out.Files = append(slices.Clone(cfg.Files), cfg.Notices.licenseEntry())
+	return &out
+}
+
+// isNotices reports whether dst is the attribution list Notices writes.
+func (c *Config) isNotices(dst string) bool {
+	return c.Notices != nil && dst == c.Notices.file()
+}
+
+// writeNotices writes the attribution list of cfg's Notices: the upstream
+// repository and license, then every file lock records, sorted by src,
+// with the commit it came from and its dst when that differs. Failing to
+// write it only warns, as the files and the lock are already in place.
+func writeNotices(root string, cfg *Config, lock *lockFile, logf func(format string, args ...any)) {
+	if cfg.Notices == nil {
+		return
+	}
+	repo := cfg.Repo
+	if repo == "" {
+		repo = DefaultRepo
+	}
+	var lines []string
+	for dst, entry := range lock.Files {
+		line := entry.Src + " @ " + cmp.Or(entry.Commit, lock.Commit)
+		if dst != entry.Src {
+			line += " -> " + dst
+		}
+		lines = append(lines, line)
+	}
+	slices.Sort(lines)
+	license, err := filepath.Rel(filepath.Dir(filepath.FromSlash(cfg.Notices.file())), filepath.FromSlash(cfg.Notices.license()))
+	if err != nil {
+		license = cfg.Notices.license()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "This directory holds files from %s (https://github.com/%s).\n", path.Base(repo), repo)
+	fmt.Fprintf(&b, "They are distributed under its license, a copy of which is in %s.\n", filepath.ToSlash(license))
+	b.WriteString("wptsync writes this list on every sync; do not edit it.\n\n")
+	fmt.Fprintf(&b, "%d files, as upstream path @ commit (-> path here):\n\n", len(lines))
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+	p := filepath.Join(root, cfg.TargetDir, filepath.FromSlash(cfg.Notices.file()))
+	if err := writeFileAtomic(p, []byte(b.String()), 0o644); err != nil {
+		logf("warning: write %s: %v\n", cfg.Notices.file(), err)
+	}
+}
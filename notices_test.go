@@ -0,0 +1,75 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncWritesNotices(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/LICENSE.md":   "# The 3-Clause BSD License\n",
+		"/c1/url/a.js":     "a\n",
+		"/c2/url/b.js":     "b\n",
+		"/c1/dom/c.html":   "c\n",
+		"/c1/unused/d.txt": "d\n",
+		"/c3/LICENSE.md":   "# The 3-Clause BSD License\n",
+		"/c3/url/a.js":     "a\n",
+		"/c3/dom/c.html":   "c\n",
+	})
+	cfg := &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "url/a.js"}, {Src: "url/b.js", Commit: "c2"}, {Src: "dom/c.html", Dst: "dom/renamed.html"}},
+		Notices:   &Notices{File: "meta/NOTICES"},
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	license, err := os.ReadFile(filepath.Join(dir, "wpt", "LICENSE.md"))
+	if err != nil || string(license) != "# The 3-Clause BSD License\n" {
+		t.Errorf("LICENSE.md = %q, %v; want upstream's, without a header", license, err)
+	}
+	notices, err := os.ReadFile(filepath.Join(dir, "wpt", "meta", "NOTICES"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"files from wpt (https://github.com/web-platform-tests/wpt)",
+		"a copy of which is in ../LICENSE.md",
+		"4 files",
+		"LICENSE.md @ c1\ndom/c.html @ c1 -> dom/renamed.html\nurl/a.js @ c1\nurl/b.js @ c2\n",
+	} {
+		if !strings.Contains(string(notices), want) {
+			t.Errorf("notices = %q, want %q in it", notices, want)
+		}
+	}
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	// update writes the list again for the new commit.
+	if err := Update(context.Background(), configPath, &UpdateOptions{SyncOptions: SyncOptions{BaseURL: server.URL}, Commit: "c3"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if notices, err = os.ReadFile(filepath.Join(dir, "wpt", "meta", "NOTICES")); err != nil || !strings.Contains(string(notices), "url/a.js @ c3\n") {
+		t.Errorf("notices after update = %q, %v; want url/a.js at c3", notices, err)
+	}
+
+	// A file dropped from the configuration is dropped from the list by
+	// prune, which leaves the list and the license alone.
+	cfg.Commit, cfg.Files = "c3", cfg.Files[:2]
+	saveTestConfig(t, dir, cfg)
+	pruned, err := Prune(context.Background(), configPath, &PruneOptions{Untracked: true})
+	if err != nil || len(pruned) != 1 || pruned[0] != "dom/renamed.html" {
+		t.Fatalf("Prune = %v, %v; want only dom/renamed.html pruned", pruned, err)
+	}
+	if notices, err = os.ReadFile(filepath.Join(dir, "wpt", "meta", "NOTICES")); err != nil || strings.Contains(string(notices), "dom/c.html") {
+		t.Errorf("notices after prune = %q, %v; want dom/c.html gone", notices, err)
+	}
+}
@@ -0,0 +1,93 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultJobs is the number of files processed concurrently when
+// SyncOptions.Jobs is zero. Downloads are network-bound, so this is not tied
+// to the CPU count.
+const DefaultJobs = 8
+
+// forEachFile calls fn for every index in [0, n) with at most jobs calls in
+// flight. The first error cancels the context passed to the remaining calls
+// and stops new ones from starting. All errors are returned joined in index
+// order, except the cancellations that the first error itself caused. When
+// ctx is canceled before every call started, its error is returned even if
+// none failed.
+func forEachFile(ctx context.Context, jobs, n int, fn func(ctx context.Context, i int) error) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make([]error, n)
+	sem := make(chan struct{}, max(jobs, 1))
+	var wg sync.WaitGroup
+
+	for i := range n {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(ctx, i); err != nil {
+				errs[i] = err
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Drop errors that are only the echo of our own cancellation, unless
+	// the parent context was canceled, in which case that is the story.
+	var failed []error
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 0 {
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		// No call failed, but those the caller's cancellation kept from
+		// starting did not run either.
+		return parent.Err()
+	}
+	return errors.Join(failed...)
+}
+
+// serialized returns a copy of o whose Logf is safe to call from several
+// goroutines at once.
+func (o *SyncOptions) serialized() *SyncOptions {
+	if o == nil || o.Logf == nil {
+		return o
+	}
+	logf := o.Logf
+	var mu sync.Mutex
+	cp := *o
+	cp.Logf = func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		logf(format, args...)
+	}
+	return &cp
+}
+
+func (o *SyncOptions) jobs() int {
+	if o == nil || o.Jobs == 0 {
+		return DefaultJobs
+	}
+	return o.Jobs
+}
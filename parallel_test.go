@@ -0,0 +1,123 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForEachFileLimitsConcurrency(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	err := forEachFile(context.Background(), 3, 20, func(ctx context.Context, i int) error {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("forEachFile: %v", err)
+	}
+	if got := peak.Load(); got > 3 {
+		t.Errorf("peak concurrency = %d, want <= 3", got)
+	}
+}
+
+func TestForEachFileCancelsAndJoinsErrors(t *testing.T) {
+	errBoom := errors.New("boom")
+	var started atomic.Int32
+	err := forEachFile(context.Background(), 2, 50, func(ctx context.Context, i int) error {
+		started.Add(1)
+		if i == 0 {
+			return fmt.Errorf("file %d: %w", i, errBoom)
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("expected joined error to wrap errBoom, got %v", err)
+	}
+	if errors.Is(err, context.Canceled) {
+		t.Errorf("self-inflicted cancellations should not be reported, got %v", err)
+	}
+	if got := started.Load(); got >= 50 {
+		t.Errorf("expected the failure to stop new work, but all %d calls started", got)
+	}
+}
+
+func TestSyncCanceledWritesNoEntries(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, dir, _ := newFixture(t, map[string]string{"/c1/a/foo.js": "foo\n"})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a/foo.js"}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := forEachFile(ctx, 2, 3, func(context.Context, int) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("forEachFile with a canceled ctx = %v, want context.Canceled", err)
+	}
+	if err := Sync(ctx, configPath, &SyncOptions{BaseURL: server.URL}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Sync with a canceled ctx = %v, want context.Canceled", err)
+	}
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lock.Files) != 0 {
+		t.Errorf("lock = %+v, want no entries for files never synced", lock.Files)
+	}
+}
+
+func TestSyncDownloadsConcurrently(t *testing.T) {
+	const jobs = 4
+
+	// Every request blocks until jobs requests are in flight at once, so the
+	// sync only completes if downloads really overlap.
+	var mu sync.Mutex
+	arrived := 0
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrived++
+		if arrived == jobs {
+			close(release)
+		}
+		mu.Unlock()
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+			http.Error(w, "downloads did not overlap", http.StatusGatewayTimeout)
+			return
+		}
+		_, _ = w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/c1/")))
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	cfg := &Config{Commit: "c1", TargetDir: "wpt"}
+	for i := range jobs {
+		cfg.Files = append(cfg.Files, FileSpec{Src: fmt.Sprintf("f%d.js", i)})
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	var log strings.Builder
+	opts := &SyncOptions{
+		BaseURL: srv.URL,
+		Jobs:    jobs,
+		Logf:    func(format string, args ...any) { fmt.Fprintf(&log, format, args...) },
+	}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+}
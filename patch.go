@@ -0,0 +1,550 @@
+package wptsync
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxFuzz is how many leading and trailing context lines a hunk may drop
+// by default when it does not apply with its full context, as GNU patch
+// does. PatchFuzz.Context changes it.
+const maxFuzz = 2
+
+// fileDiff is one file's section of a unified diff.
+type fileDiff struct {
+	// oldPath and newPath are relative to the patch root, with the leading
+	// "a/" and "b/" stripped. created and deleted mark /dev/null sides.
+	oldPath, newPath string
+	created, deleted bool
+	binary           bool
+	hunks            []hunk
+}
+
+// hunk is one "@@ -oldStart,n +newStart,m @@" section of a fileDiff.
+type hunk struct {
+	oldStart int
+	lines    []hunkLine
+}
+
+// hunkLine is a context (' '), removed ('-'), or added ('+') line. text keeps
+// its line terminator unless the patch marks it "\ No newline at end of file".
+type hunkLine struct {
+	op   byte
+	text string
+}
+
+// sides returns the lines the hunk expects and produces, after dropping
+// lead leading and trail trailing context lines.
+func (h hunk) sides(lead, trail int) (before, after []string) {
+	for _, l := range h.lines[lead : len(h.lines)-trail] {
+		if l.op != '+' {
+			before = append(before, l.text)
+		}
+		if l.op != '-' {
+			after = append(after, l.text)
+		}
+	}
+	return before, after
+}
+
+// apply returns what the hunk, less lead leading and trail trailing context
+// lines, turns matched, the file's lines it matched, into. Context lines are
+// kept as matched, which only differs from the patch's when whitespace was
+// ignored.
+func (h hunk) apply(matched []string, lead, trail int) []string {
+	var out []string
+	k := 0
+	for _, l := range h.lines[lead : len(h.lines)-trail] {
+		switch l.op {
+		case ' ':
+			out = append(out, matched[k])
+			k++
+		case '-':
+			k++
+		case '+':
+			out = append(out, l.text)
+		}
+	}
+	return out
+}
+
+// context returns how many context lines the hunk starts and ends with.
+func (h hunk) context() (lead, trail int) {
+	for lead < len(h.lines) && h.lines[lead].op == ' ' {
+		lead++
+	}
+	for trail < len(h.lines)-lead && h.lines[len(h.lines)-1-trail].op == ' ' {
+		trail++
+	}
+	return lead, trail
+}
+
+// applyUnifiedDiff applies the unified diff at patchPath (as produced by git
+// diff or diff -u) to the files below root, without shelling out to git. Like
+// `git apply --allow-empty`, a patch that changes nothing succeeds, and like
+// git apply, either every file is patched or none is. Hunks that moved are
+// found by searching outwards from their recorded line; hunks whose context
+// changed apply with some context lines dropped at either end, as fuzz
+// allows. It returns what each hunk that did not apply exactly took.
+func applyUnifiedDiff(root, patchPath string, fuzz PatchFuzz) ([]HunkFuzz, error) {
+	data, err := os.ReadFile(patchPath)
+	if err != nil {
+		return nil, fmt.Errorf("read patch: %w", err)
+	}
+	diffs, err := parseUnifiedDiff(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// Every result is computed before anything is written.
+	type result struct {
+		content []byte
+		mode    fs.FileMode
+		removed bool
+	}
+	results := make(map[string]*result)
+	var order []string
+	read := func(name string) (*result, error) {
+		if r, ok := results[name]; ok {
+			if r.removed {
+				return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+			}
+			return r, nil
+		}
+		p := filepath.Join(root, filepath.FromSlash(name))
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		return &result{content: content, mode: info.Mode().Perm()}, nil
+	}
+	set := func(name string, r *result) {
+		if _, ok := results[name]; !ok {
+			order = append(order, name)
+		}
+		results[name] = r
+	}
+
+	var fuzzed []HunkFuzz
+	for _, d := range diffs {
+		name := d.newPath
+		if d.deleted {
+			name = d.oldPath
+		}
+		if d.binary {
+			return nil, fmt.Errorf("%s: binary patches are not supported; apply this patch with git (-use-git)", name)
+		}
+		for _, p := range []string{d.oldPath, d.newPath} {
+			if p != "" && !filepath.IsLocal(filepath.FromSlash(p)) {
+				return nil, fmt.Errorf("%s: path escapes the patch root", p)
+			}
+		}
+
+		if len(d.hunks) == 0 && !d.created && !d.deleted && d.oldPath == d.newPath {
+			// A mode change; file modes are left alone.
+			continue
+		}
+
+		current := &result{mode: 0o644}
+		if d.created {
+			if _, err := read(name); err == nil {
+				return nil, fmt.Errorf("%s: already exists", name)
+			}
+		} else {
+			if current, err = read(d.oldPath); err != nil {
+				return nil, fmt.Errorf("%s: %w", d.oldPath, err)
+			}
+		}
+
+		content, hunkFuzz, err := applyHunks(string(current.content), d.hunks, fuzz)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for i := range hunkFuzz {
+			hunkFuzz[i].File = name
+		}
+		fuzzed = append(fuzzed, hunkFuzz...)
+
+		if d.deleted {
+			if content != "" {
+				return nil, fmt.Errorf("%s: file to delete has content the patch does not remove", name)
+			}
+			set(name, &result{removed: true})
+			continue
+		}
+		if d.oldPath != "" && d.oldPath != d.newPath {
+			set(d.oldPath, &result{removed: true})
+		}
+		set(name, &result{content: []byte(content), mode: current.mode})
+	}
+
+	for _, name := range order {
+		r := results[name]
+		dest := filepath.Join(root, filepath.FromSlash(name))
+		if r.removed {
+			if err := os.Remove(dest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			continue
+		}
+		if err := writeFileAtomic(dest, r.content, r.mode); err != nil {
+			return nil, fmt.Errorf("write %s: %w", name, err)
+		}
+	}
+	return fuzzed, nil
+}
+
+// HunkConflict identifies a hunk of a patch that does not apply.
+type HunkConflict struct {
+	// Hunk is the 1-based index of the hunk within its file's diff.
+	Hunk int
+	// Line is the line the hunk expected to start at.
+	Line int
+}
+
+// hunkError lists the hunks of one file that do not apply.
+type hunkError struct {
+	conflicts []HunkConflict
+}
+
+func (e *hunkError) Error() string {
+	parts := make([]string, len(e.conflicts))
+	for i, c := range e.conflicts {
+		parts[i] = fmt.Sprintf("#%d at line %d", c.Hunk, c.Line)
+	}
+	if len(parts) == 1 {
+		return "hunk " + parts[0] + " does not apply"
+	}
+	return "hunks " + strings.Join(parts, ", ") + " do not apply"
+}
+
+// applyHunks applies hunks, in order, to content, with the leeway fuzz
+// allows, and returns what each hunk that did not apply exactly took. Every
+// hunk is tried, so the returned *hunkError lists all of those that do not
+// apply.
+func applyHunks(content string, hunks []hunk, fuzz PatchFuzz) (string, []HunkFuzz, error) {
+	src := strings.SplitAfter(content, "\n")
+	if src[len(src)-1] == "" {
+		src = src[:len(src)-1]
+	}
+	spacings := []bool{false}
+	if fuzz.IgnoreWhitespace {
+		spacings = append(spacings, true)
+	}
+
+	var out []string
+	var conflicts []HunkConflict
+	var fuzzed []HunkFuzz
+	pos, offset := 0, 0
+	for i, h := range hunks {
+		lead, trail := h.context()
+		at, want, l, t := -1, 0, 0, 0
+		loose := false
+		var before []string
+	search:
+		for level := 0; level <= fuzz.context(); level++ {
+			l, t = min(level, lead), min(level, trail)
+			if level > 0 && l+t == 0 {
+				break
+			}
+			before, _ = h.sides(l, t)
+			want = h.oldStart - 1 + l
+			if level == 0 && len(before) == 0 {
+				// A pure insertion: "-n,0" means after line n.
+				want = h.oldStart
+			}
+			for _, loose = range spacings {
+				at = findLines(src, pos, want+offset, before, loose)
+				if at >= 0 && fuzz.MaxOffset > 0 && abs(at-want) > fuzz.MaxOffset {
+					at = -1
+				}
+				if at >= 0 {
+					break search
+				}
+			}
+		}
+		if at < 0 {
+			conflicts = append(conflicts, HunkConflict{Hunk: i + 1, Line: h.oldStart})
+			continue
+		}
+		if at != want || l+t > 0 || loose {
+			fuzzed = append(fuzzed, HunkFuzz{Hunk: i + 1, Line: h.oldStart, Offset: at - want, Context: l + t, Whitespace: loose})
+		}
+		out = append(out, src[pos:at]...)
+		out = append(out, h.apply(src[at:at+len(before)], l, t)...)
+		offset = at - want
+		pos = at + len(before)
+	}
+	if conflicts != nil {
+		return "", nil, &hunkError{conflicts: conflicts}
+	}
+	out = append(out, src[pos:]...)
+	return strings.Join(out, ""), fuzzed, nil
+}
+
+// findLines returns the index at or after from where lines occur in src,
+// preferring the match closest to want, or -1. An empty lines matches at
+// want itself. With loose set, lines only need to match ignoring
+// whitespace.
+func findLines(src []string, from, want int, lines []string, loose bool) int {
+	want = max(want, from)
+	if len(lines) == 0 {
+		if want <= len(src) {
+			return want
+		}
+		return -1
+	}
+	same := func(a, b string) bool { return a == b }
+	if loose {
+		same = func(a, b string) bool {
+			return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+		}
+	}
+	matches := func(at int) bool {
+		if at < from || at+len(lines) > len(src) {
+			return false
+		}
+		for i, l := range lines {
+			if !same(src[at+i], l) {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; want-d >= from || want+d+len(lines) <= len(src); d++ {
+		if matches(want - d) {
+			return want - d
+		}
+		if matches(want + d) {
+			return want + d
+		}
+	}
+	return -1
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// parseUnifiedDiff splits a unified diff into its file sections. Lines
+// outside of them, such as a commit message, are ignored.
+func parseUnifiedDiff(patch string) ([]fileDiff, error) {
+	lines := strings.SplitAfter(patch, "\n")
+	var diffs []fileDiff
+	var cur *fileDiff
+	start := func() {
+		diffs = append(diffs, fileDiff{})
+		cur = &diffs[len(diffs)-1]
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			start()
+			cur.oldPath, cur.newPath = parseGitDiffHeader(strings.TrimPrefix(line, "diff --git "))
+		case cur != nil && strings.HasPrefix(line, "new file mode "):
+			cur.created = true
+		case cur != nil && strings.HasPrefix(line, "deleted file mode "):
+			cur.deleted = true
+		case cur != nil && strings.HasPrefix(line, "rename from "):
+			cur.oldPath = parsePatchPath(strings.TrimPrefix(line, "rename from "), false)
+		case cur != nil && strings.HasPrefix(line, "rename to "):
+			cur.newPath = parsePatchPath(strings.TrimPrefix(line, "rename to "), false)
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			if cur == nil || len(cur.hunks) > 0 {
+				start()
+			}
+			oldPath := parsePatchPath(strings.TrimPrefix(line, "--- "), true)
+			newPath := parsePatchPath(strings.TrimPrefix(strings.TrimRight(lines[i+1], "\r\n"), "+++ "), true)
+			cur.created, cur.deleted = oldPath == "", newPath == ""
+			if oldPath != "" {
+				cur.oldPath = oldPath
+			}
+			if newPath != "" {
+				cur.newPath = newPath
+			}
+			i++
+		case cur != nil && (strings.HasPrefix(line, "GIT binary patch") || strings.HasPrefix(line, "Binary files ")):
+			cur.binary = true
+		case strings.HasPrefix(line, "@@ "):
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: hunk outside of a file diff", i+1)
+			}
+			h, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			cur.hunks = append(cur.hunks, h)
+			i = next - 1
+		}
+	}
+
+	for i := range diffs {
+		d := &diffs[i]
+		if d.created {
+			d.oldPath = ""
+		}
+		if d.newPath == "" {
+			d.newPath = d.oldPath
+		}
+		if d.oldPath == "" && !d.created {
+			d.oldPath = d.newPath
+		}
+	}
+	return diffs, nil
+}
+
+// parseHunk parses the hunk whose header is lines[i] and returns it with the
+// index of the first line after it.
+func parseHunk(lines []string, i int) (hunk, int, error) {
+	header := strings.TrimRight(lines[i], "\r\n")
+	var h hunk
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return h, 0, fmt.Errorf("line %d: malformed hunk header %q", i+1, header)
+	}
+	oldStart, oldCount, err1 := parseRange(fields[1][1:])
+	_, newCount, err2 := parseRange(fields[2][1:])
+	if err := errors.Join(err1, err2); err != nil {
+		return h, 0, fmt.Errorf("line %d: malformed hunk header %q: %w", i+1, header, err)
+	}
+	h.oldStart = oldStart
+
+	i++
+	for ; oldCount > 0 || newCount > 0; i++ {
+		if i >= len(lines) || lines[i] == "" {
+			return h, 0, fmt.Errorf("hunk %q is truncated", header)
+		}
+		line := lines[i]
+		switch op := line[0]; op {
+		case '\\':
+			markNoNewline(&h)
+			continue
+		case ' ', '-', '+':
+			h.lines = append(h.lines, hunkLine{op: op, text: line[1:]})
+		case '\r', '\n':
+			// Some editors strip the space off empty context lines.
+			h.lines = append(h.lines, hunkLine{op: ' ', text: line})
+		default:
+			return h, 0, fmt.Errorf("line %d: unexpected line in hunk %q", i+1, header)
+		}
+		switch h.lines[len(h.lines)-1].op {
+		case ' ':
+			oldCount--
+			newCount--
+		case '-':
+			oldCount--
+		case '+':
+			newCount--
+		}
+		if oldCount < 0 || newCount < 0 {
+			return h, 0, fmt.Errorf("hunk %q has more lines than its header says", header)
+		}
+	}
+	if i < len(lines) && strings.HasPrefix(lines[i], "\\") {
+		markNoNewline(&h)
+		i++
+	}
+	return h, i, nil
+}
+
+// markNoNewline strips the line terminator off h's last line, for a
+// "\ No newline at end of file" marker.
+func markNoNewline(h *hunk) {
+	if len(h.lines) > 0 {
+		l := &h.lines[len(h.lines)-1]
+		l.text = strings.TrimSuffix(l.text, "\n")
+	}
+}
+
+// parseRange parses the "start[,count]" of a hunk header.
+func parseRange(s string) (start, count int, err error) {
+	startStr, countStr, ok := strings.Cut(s, ",")
+	if start, err = strconv.Atoi(startStr); err != nil {
+		return 0, 0, err
+	}
+	count = 1
+	if ok {
+		if count, err = strconv.Atoi(countStr); err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, count, nil
+}
+
+// parseGitDiffHeader returns the paths of a "diff --git a/x b/x" header,
+// used when a section has no ---/+++ lines (renames, empty files).
+func parseGitDiffHeader(s string) (oldPath, newPath string) {
+	if strings.HasPrefix(s, `"`) {
+		if q, err := strconv.QuotedPrefix(s); err == nil {
+			return parsePatchPath(q, true), parsePatchPath(strings.TrimSpace(s[len(q):]), true)
+		}
+	}
+	// Without quoting the split is ambiguous; both sides name the same
+	// file unless the section is a rename, whose lines override this.
+	if i := strings.Index(s, " b/"); i >= 0 {
+		return parsePatchPath(s[:i], true), parsePatchPath(s[i+1:], true)
+	}
+	return "", ""
+}
+
+// parsePatchPath decodes a path from a patch header, C-unquoting it if
+// needed and, when prefixed, stripping its first component ("a/" or "b/")
+// like git apply -p1. It returns "" for /dev/null.
+func parsePatchPath(s string, prefixed bool) string {
+	if strings.HasPrefix(s, `"`) {
+		if q, err := strconv.QuotedPrefix(s); err == nil {
+			s, _ = strconv.Unquote(q)
+		}
+	} else if i := strings.IndexByte(s, '\t'); i >= 0 {
+		// diff -u appends a timestamp after a tab.
+		s = s[:i]
+	}
+	if s == "/dev/null" {
+		return ""
+	}
+	if prefixed {
+		if _, rest, ok := strings.Cut(s, "/"); ok {
+			return rest
+		}
+	}
+	return s
+}
+
+// writeFileAtomic replaces dest with content by rename, creating its
+// directory if needed.
+func writeFileAtomic(dest string, content []byte, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".wpt-patch-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
@@ -0,0 +1,129 @@
+package wptsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		patch string
+		want  map[string]string // "" means the file must not exist
+	}{
+		{
+			name:  "moved hunk applies at an offset",
+			files: map[string]string{"f.js": "new1\nnew2\na\nb\nc\n"},
+			patch: "--- a/f.js\n+++ b/f.js\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+			want:  map[string]string{"f.js": "new1\nnew2\na\nB\nc\n"},
+		},
+		{
+			name:  "changed context applies with fuzz",
+			files: map[string]string{"f.js": "x\na\nb\nc\nd\ny\n"},
+			patch: "--- a/f.js\n+++ b/f.js\n@@ -1,6 +1,6 @@\n old\n a\n b\n-c\n+C\n d\n old\n",
+			want:  map[string]string{"f.js": "x\na\nb\nC\nd\ny\n"},
+		},
+		{
+			name:  "missing final newline",
+			files: map[string]string{"f.js": "a\nb"},
+			patch: "--- a/f.js\n+++ b/f.js\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+			want:  map[string]string{"f.js": "a\nb\n"},
+		},
+		{
+			name:  "pure insertion",
+			files: map[string]string{"f.js": "a\nb\n"},
+			patch: "--- a/f.js\n+++ b/f.js\n@@ -1,0 +2 @@\n+inserted\n",
+			want:  map[string]string{"f.js": "a\ninserted\nb\n"},
+		},
+		{
+			name:  "create, delete, and rename",
+			files: map[string]string{"gone.js": "bye\n", "old.js": "same\n"},
+			patch: strings.Join([]string{
+				"diff --git a/new.js b/new.js",
+				"new file mode 100644",
+				"--- /dev/null",
+				"+++ b/new.js",
+				"@@ -0,0 +1 @@",
+				"+hello",
+				"diff --git a/gone.js b/gone.js",
+				"deleted file mode 100644",
+				"--- a/gone.js",
+				"+++ /dev/null",
+				"@@ -1 +0,0 @@",
+				"-bye",
+				"diff --git a/old.js b/moved.js",
+				"similarity index 100%",
+				"rename from old.js",
+				"rename to moved.js",
+				"",
+			}, "\n"),
+			want: map[string]string{"new.js": "hello\n", "gone.js": "", "old.js": "", "moved.js": "same\n"},
+		},
+		{
+			name:  "empty patch",
+			files: map[string]string{"f.js": "a\n"},
+			patch: "",
+			want:  map[string]string{"f.js": "a\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			patchPath := filepath.Join(t.TempDir(), "p.patch")
+			if err := os.WriteFile(patchPath, []byte(tt.patch), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := applyUnifiedDiff(dir, patchPath, PatchFuzz{}); err != nil {
+				t.Fatalf("applyUnifiedDiff: %v", err)
+			}
+			for name, want := range tt.want {
+				got, err := os.ReadFile(filepath.Join(dir, name))
+				if want == "" {
+					if !errors.Is(err, os.ErrNotExist) {
+						t.Errorf("%s still exists", name)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyUnifiedDiffIsAllOrNothing(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.js": "a\n", "b.js": "b\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	patch := "--- a/a.js\n+++ b/a.js\n@@ -1 +1 @@\n-a\n+A\n--- a/b.js\n+++ b/b.js\n@@ -1 +1 @@\n-nope\n+B\n"
+	patchPath := filepath.Join(t.TempDir(), "p.patch")
+	if err := os.WriteFile(patchPath, []byte(patch), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := applyUnifiedDiff(dir, patchPath, PatchFuzz{})
+	if err == nil || !strings.Contains(err.Error(), "b.js: hunk #1") {
+		t.Fatalf("applyUnifiedDiff = %v, want a failing hunk in b.js", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "a.js")); string(got) != "a\n" {
+		t.Errorf("a.js = %q, want it untouched after the patch failed", got)
+	}
+}
@@ -0,0 +1,221 @@
+package wptsync
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// escapePath percent-encodes each segment of the slash-separated path p for
+// use in a URL path, so spaces, "#", "?", "%", and non-ASCII characters in
+// WPT paths reach the server intact.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// fileURL returns the URL of p at commit below base.
+func fileURL(base, commit, p string) string {
+	return base + "/" + url.PathEscape(commit) + "/" + escapePath(p)
+}
+
+// windowsReserved are the device names Windows refuses as file names, with
+// or without an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// pathWarnings returns a message for every enabled dst that cannot be
+// written, or would collide with another dst, on some common filesystem:
+// characters Windows rejects, reserved device names, trailing dots or
+// spaces, control characters, and dsts that differ only in case (which
+// clobber each other on macOS and Windows defaults). These are warnings, not
+// errors, since the files sync fine on the current machine.
+func (c *Config) pathWarnings() []string {
+	var warnings []string
+	byFold := make(map[string][]string)
+	for _, f := range c.Files {
+		if !f.IsEnabled() || isGlob(f.Src) {
+			continue
+		}
+		if problem := portabilityProblem(f.Dst); problem != "" {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", f.Dst, problem))
+		}
+		key := strings.ToLower(f.Dst)
+		byFold[key] = append(byFold[key], f.Dst)
+	}
+
+	var collisions []string
+	for _, dsts := range byFold {
+		if len(dsts) > 1 {
+			sort.Strings(dsts)
+			collisions = append(collisions, fmt.Sprintf("%s: differ only in case, which collides on case-insensitive filesystems", strings.Join(dsts, ", ")))
+		}
+	}
+	sort.Strings(collisions)
+	return append(warnings, collisions...)
+}
+
+// portabilityProblem describes why the slash-separated path p is not
+// portable, or returns "" if it is.
+func portabilityProblem(p string) string {
+	for _, seg := range strings.Split(p, "/") {
+		if i := strings.IndexAny(seg, `<>:"\|?*`); i >= 0 {
+			return fmt.Sprintf("%q is not allowed on Windows", seg[i])
+		}
+		for _, r := range seg {
+			if unicode.IsControl(r) {
+				return fmt.Sprintf("contains control character %U", r)
+			}
+		}
+		if strings.HasSuffix(seg, ".") || strings.HasSuffix(seg, " ") {
+			return fmt.Sprintf("%q ends in a dot or space, which Windows strips", seg)
+		}
+		if base := strings.TrimSuffix(seg, path.Ext(seg)); windowsReserved[strings.ToUpper(base)] {
+			return fmt.Sprintf("%q is a reserved device name on Windows", seg)
+		}
+	}
+	return ""
+}
+
+// dstProblems returns a message for every entry of files whose dst escapes
+// the target directory, every dst several entries write, and every dst that
+// another entry needs as a directory, naming the entries involved by src.
+// Glob entries, whose dst is the directory their matches go in, are only
+// checked for escaping; their expansion is checked like any other entries.
+func dstProblems(files []FileSpec) []string {
+	var problems []string
+	writers := make(map[string][]string)
+	var dsts []string
+	for _, f := range files {
+		if !filepath.IsLocal(filepath.FromSlash(f.Dst)) {
+			problems = append(problems, fmt.Sprintf("dst %q of %s escapes the target directory", f.Dst, f.Src))
+			continue
+		}
+		if isGlob(f.Src) {
+			continue
+		}
+		dst := path.Clean(f.Dst)
+		if _, ok := writers[dst]; !ok {
+			dsts = append(dsts, dst)
+		}
+		writers[dst] = append(writers[dst], f.Src)
+	}
+	for _, dst := range dsts {
+		if srcs := writers[dst]; len(srcs) > 1 {
+			problems = append(problems, fmt.Sprintf("dst %q is written by %s", dst, joinAnd(srcs)))
+		}
+		for dir := path.Dir(dst); dir != "."; dir = path.Dir(dir) {
+			if srcs, ok := writers[dir]; ok {
+				problems = append(problems, fmt.Sprintf("dst %q of %s is the directory dst %q of %s is in", dir, joinAnd(srcs), dst, joinAnd(writers[dst])))
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// joinAnd lists items as "a", "a and b", or "a, b, and c".
+func joinAnd(items []string) string {
+	switch len(items) {
+	case 1:
+		return items[0]
+	case 2:
+		return items[0] + " and " + items[1]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + ", and " + items[len(items)-1]
+}
+
+// checkDstsOnDisk returns an error wrapping ErrInvalidConfig, listing the
+// offending files, if the dst of any enabled file of cfg resolves outside
+// target_dir on disk: through a symlink on its way, or by being one.
+// Config.check keeps dsts inside target_dir as written; this catches what
+// the filesystem adds, before anything is written or deleted.
+func checkDstsOnDisk(root string, cfg *Config) error {
+	targetDir := filepath.Join(root, cfg.TargetDir)
+	realTarget, err := filepath.EvalSymlinks(targetDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("resolve target directory: %w", err)
+	}
+
+	// verdicts caches, per path below target_dir, why it is unsafe, or ""
+	// when it is safe or doesn't exist.
+	verdicts := make(map[string]string)
+	verdict := func(rel string) string {
+		if v, ok := verdicts[rel]; ok {
+			return v
+		}
+		v := ""
+		p := filepath.Join(targetDir, rel)
+		if info, err := os.Lstat(p); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			resolved, err := filepath.EvalSymlinks(p)
+			if errors.Is(err, fs.ErrNotExist) {
+				// A dangling link may still be written through; judge it by
+				// where it points.
+				if resolved, err = os.Readlink(p); err == nil && !filepath.IsAbs(resolved) {
+					resolved = filepath.Join(filepath.Dir(p), resolved)
+				}
+			}
+			switch {
+			case err != nil:
+				v = err.Error()
+			case !isWithin(realTarget, resolved) && !isWithin(targetDir, resolved):
+				v = fmt.Sprintf("%s is a symlink to %s, outside target_dir", filepath.ToSlash(rel), resolved)
+			}
+		}
+		verdicts[rel] = v
+		return v
+	}
+
+	var problems []string
+	for _, f := range cfg.Files {
+		if !f.IsEnabled() || isGlob(f.Src) {
+			continue
+		}
+		segments := strings.Split(path.Clean(f.Dst), "/")
+		for i := range segments {
+			if v := verdict(filepath.Join(segments[:i+1]...)); v != "" {
+				problems = append(problems, fmt.Sprintf("%s: %s", f.Dst, v))
+				break
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return invalidConfig(fmt.Errorf("refusing to write outside %s: %s", cfg.TargetDir, strings.Join(problems, "; ")))
+	}
+	return nil
+}
+
+// withDsts returns a copy of cfg with one enabled file per dst, so that
+// checkDstsOnDisk can vet files outside its configured entries, such as
+// those deleted once no entry maps to them.
+func withDsts(cfg *Config, dsts []string) *Config {
+	out := &Config{TargetDir: cfg.TargetDir}
+	for _, dst := range dsts {
+		out.Files = append(out.Files, FileSpec{Src: dst, Dst: dst})
+	}
+	return out
+}
+
+// isWithin reports whether path p is dir or below it.
+func isWithin(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && (rel == "." || filepath.IsLocal(rel))
+}
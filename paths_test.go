@@ -0,0 +1,111 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSyncEscapesUnusualPaths(t *testing.T) {
+	src := "encoding/legacy mb/résumé #1%.js"
+	content := map[string]string{"/c1/" + src: "ok\n"}
+	server, dir, _ := newFixture(t, content)
+
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: src, Dst: "encoding/legacy mb/résumé #1.js"}}})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "wpt", "encoding", "legacy mb", "résumé #1.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ok\n" {
+		t.Errorf("content = %q, want %q", got, "ok\n")
+	}
+}
+
+func TestFileURL(t *testing.T) {
+	got := fileURL("https://raw.example/wpt", "c1", "a b/é%/x#y?.js")
+	want := "https://raw.example/wpt/c1/a%20b/%C3%A9%25/x%23y%3F.js"
+	if got != want {
+		t.Errorf("fileURL = %s, want %s", got, want)
+	}
+}
+
+func TestPathWarnings(t *testing.T) {
+	cfg := &Config{Files: []FileSpec{
+		{Src: "a.js", Dst: "ok/a.js"},
+		{Src: "b.js", Dst: "x/what?.js"},
+		{Src: "c.js", Dst: "aux.js"},
+		{Src: "d.js", Dst: "dir./d.js"},
+		{Src: "e.js", Dst: "Case/e.js"},
+		{Src: "f.js", Dst: "case/E.js"},
+	}}
+	got := cfg.pathWarnings()
+	want := []string{
+		`x/what?.js: '?' is not allowed on Windows`,
+		`aux.js: "aux.js" is a reserved device name on Windows`,
+		`dir./d.js: "dir." ends in a dot or space, which Windows strips`,
+		`Case/e.js, case/E.js: differ only in case, which collides on case-insensitive filesystems`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("warnings:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestConfigListsConflictingDsts(t *testing.T) {
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{
+		{Src: "url/a.any.js", Dst: "url/a.js"},
+		{Src: "url/a.js", Dst: "url/a.js"},
+		{Src: "url/b.js", Dst: "../../etc/b.js"},
+		{Src: "url/resources", Dst: "url/resources"},
+		{Src: "url/resources/x.js", Dst: "url/resources/x.js"},
+	}}
+	err := cfg.validate()
+	want := `config: dst "../../etc/b.js" of url/b.js escapes the target directory; ` +
+		`dst "url/a.js" is written by url/a.any.js and url/a.js; ` +
+		`dst "url/resources" of url/resources is the directory dst "url/resources/x.js" of url/resources/x.js is in`
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("validate = %v, want %q", err, want)
+	}
+}
+
+func TestSyncRefusesDstsThroughSymlinks(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{"/c1/url/a.js": "a\n", "/c1/url/b.js": "b\n", "/c1/css/c.js": "c\n"})
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "wpt", "css"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "wpt", "url")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	// Links inside target_dir are fine.
+	if err := os.Symlink("../css", filepath.Join(dir, "wpt", "css", "self")); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{
+		{Src: "url/a.js"}, {Src: "url/b.js"}, {Src: "css/c.js", Dst: "css/self/c.js"},
+	}})
+	err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL})
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "url/a.js: url is a symlink to "+outside) || !strings.Contains(err.Error(), "url/b.js: url is a symlink") {
+		t.Errorf("Sync = %v, want both files through the url symlink refused", err)
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) > 0 {
+		t.Errorf("Sync wrote %d files outside target_dir", len(entries))
+	}
+
+	if err := os.Remove(filepath.Join(dir, "wpt", "url")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync without the symlink: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "css", "c.js")); string(got) != "c\n" {
+		t.Errorf("css/c.js = %q, want it written through the link inside target_dir", got)
+	}
+}
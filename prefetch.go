@@ -0,0 +1,160 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PrefetchOptions configures Prefetch.
+type PrefetchOptions struct {
+	SyncOptions
+	// Latest also prefetches the files at the commit update would move
+	// to: the head of the configuration's ref, or its latest release when
+	// it tracks releases.
+	Latest bool
+}
+
+// PrefetchResult is what Prefetch did.
+type PrefetchResult struct {
+	// Commits are the commits prefetched, the pinned one first.
+	Commits []string
+	// Fetched counts the files downloaded into the cache, Cached those it
+	// already held, and Failed those that could not be downloaded.
+	Fetched, Cached, Failed int
+}
+
+// Prefetch fills the download cache (see SyncOptions.CacheDir) with the
+// files of the configuration at configPath at its pinned commit, and with
+// opts.Latest at the latest one too, so that the next sync or update reads
+// them from the cache instead of the network. It writes neither target_dir
+// nor the lock file, and files already cached are not downloaded again. A
+// file that fails to download, such as one gone from the latest commit,
+// does not stop the others; Prefetch returns an error once they are done.
+func Prefetch(ctx context.Context, configPath string, opts *PrefetchOptions) (*PrefetchResult, error) {
+	if opts == nil {
+		opts = &PrefetchOptions{}
+	}
+	syncOpts := &opts.SyncOptions
+	if err := syncOpts.validate(); err != nil {
+		return nil, err
+	}
+	if syncOpts.CacheDir == "" {
+		return nil, invalidConfig(errors.New("prefetch fills the download cache, which is off; set a cache directory"))
+	}
+	root, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return nil, fmt.Errorf("determine repo root from config: %w", err)
+	}
+	cfg, _, err := syncOpts.loadGroup(configPath)
+	if err != nil {
+		return nil, err
+	}
+	syncOpts = syncOpts.forConfig(cfg)
+
+	result := &PrefetchResult{}
+	if src := syncOpts.source(cfg); strings.HasPrefix(src, gitScheme) || strings.HasPrefix(src, bundleScheme) {
+		syncOpts.logf("Files are read from %s, not downloaded; nothing to prefetch.\n", src)
+		return result, nil
+	}
+	result.Commits = []string{cfg.Commit}
+	if opts.Latest {
+		syncOpts.logf("Fetching latest WPT commit...\n")
+		resolveCtx, cancel := withTimeout(ctx, syncOpts.timeouts().Resolve)
+		latest, err := syncOpts.github().latestCommit(resolveCtx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("fetch latest commit: %w", err)
+		}
+		if latest != cfg.Commit {
+			result.Commits = append(result.Commits, latest)
+		}
+	}
+
+	for _, commit := range result.Commits {
+		at := *cfg
+		at.Commit = commit
+		expanded, err := expandGlobs(ctx, root, &at, syncOpts)
+		if err != nil {
+			return result, err
+		}
+		if err := prefetchFiles(ctx, root, expanded, syncOpts, result); err != nil {
+			return result, err
+		}
+	}
+	if err := syncOpts.trimCache(); err != nil {
+		syncOpts.logf("warning: trim download cache: %v\n", err)
+	}
+	if result.Failed > 0 {
+		return result, fmt.Errorf("prefetch: %d file(s) could not be downloaded; sync or update will report why", result.Failed)
+	}
+	return result, nil
+}
+
+// prefetchFiles downloads the enabled files of cfg the cache does not hold
+// yet into it, counting them in result. Frozen files are left out, as sync
+// only downloads them when they are missing.
+func prefetchFiles(ctx context.Context, root string, cfg *Config, opts *SyncOptions, result *PrefetchResult) error {
+	if !isFullSHA(cfg.Commit) {
+		opts.logf("warning: %s is not a full commit SHA, which the cache does not keep; skipping it\n", cfg.Commit)
+		return nil
+	}
+	var missing []FileSpec
+	seen := make(map[string]bool)
+	for _, file := range cfg.Files {
+		if !file.IsEnabled() || file.Frozen {
+			continue
+		}
+		p := opts.cachePath(cfg.commitOf(file), strings.TrimLeft(file.Src, "/"))
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		if _, err := os.Stat(p); err == nil {
+			result.Cached++
+			continue
+		}
+		missing = append(missing, file)
+	}
+	if len(missing) == 0 {
+		opts.logf("All %d file(s) at %s are cached.\n", len(seen), shortSHA(cfg.Commit))
+		return nil
+	}
+	opts.logf("Prefetching %d of %d file(s) at %s\n", len(missing), len(seen), shortSHA(cfg.Commit))
+
+	workerOpts, cleanup, err := stageFiles(ctx, root, cfg, missing, opts)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	tmp, err := os.MkdirTemp("", "wptsync-prefetch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	var mu sync.Mutex
+	return forEachFile(ctx, opts.jobs(), len(missing), func(ctx context.Context, i int) error {
+		file := missing[i]
+		dest := filepath.Join(tmp, strconv.Itoa(i))
+		err := fetchFile(ctx, cfg.commitOf(file), strings.TrimLeft(file.Src, "/"), dest, workerOpts)
+		os.Remove(dest)
+		if err != nil && ctx.Err() != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			opts.logf("   %s: %v\n", file.Src, err)
+			result.Failed++
+			return nil
+		}
+		result.Fetched++
+		return nil
+	})
+}
@@ -0,0 +1,58 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrefetchFillsCache(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	c1, c2 := strings.Repeat("1", 40), strings.Repeat("2", 40)
+	server, dir, count := newFixture(t, map[string]string{
+		"/" + c1 + "/url/a.js": "a1\n",
+		"/" + c1 + "/url/b.js": "b1\n",
+		"/" + c2 + "/url/a.js": "a2\n",
+	})
+	apiURL, _ := newAPIFixture(t, map[string]string{"/repos/o/n/commits/master": `{"sha":"` + c2 + `"}`})
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    c1,
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "url/a.js"}, {Src: "url/b.js"}},
+	})
+	cacheDir := t.TempDir()
+	opts := &PrefetchOptions{SyncOptions: SyncOptions{BaseURL: server.URL, APIURL: apiURL, CacheDir: cacheDir}, Latest: true}
+
+	// b.js is gone at c2, which fails only that file.
+	res, err := Prefetch(context.Background(), configPath, opts)
+	if err == nil || res.Fetched != 3 || res.Failed != 1 || len(res.Commits) != 2 {
+		t.Fatalf("Prefetch = %+v, %v; want 3 fetched and 1 failed at 2 commits", res, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wpt")); !os.IsNotExist(err) {
+		t.Errorf("Prefetch wrote target_dir (%v)", err)
+	}
+
+	opts.Latest = false
+	if res, err = Prefetch(context.Background(), configPath, opts); err != nil || res.Fetched != 0 || res.Cached != 2 {
+		t.Fatalf("second Prefetch = %+v, %v; want everything cached", res, err)
+	}
+
+	// The sync is served from the cache.
+	before := count()
+	if err := Sync(context.Background(), configPath, &opts.SyncOptions); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if count() != before {
+		t.Errorf("Sync made %d requests after Prefetch, want none", count()-before)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "url", "a.js")); string(got) != "a1\n" {
+		t.Errorf("a.js = %q", got)
+	}
+
+	opts.CacheDir = ""
+	if _, err := Prefetch(context.Background(), configPath, opts); ExitCode(err) != ExitConfig {
+		t.Errorf("Prefetch without a cache = %v, want a configuration error", err)
+	}
+}
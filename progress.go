@@ -0,0 +1,93 @@
+package wptsync
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SyncProgress is how far a Sync or Update got fetching its files, as passed
+// to SyncOptions.Progress.
+type SyncProgress struct {
+	// Done counts the files processed so far, Failed included, out of the
+	// Total to fetch. Files needing no work are not counted.
+	Done   int
+	Total  int
+	Failed int
+	// Bytes is the size of the files written so far.
+	Bytes int64
+	// Elapsed is the time since the first file was started.
+	Elapsed time.Duration
+}
+
+// Remaining estimates the time left at the pace so far, or returns 0 before
+// any file is done.
+func (p SyncProgress) Remaining() time.Duration {
+	if p.Done == 0 || p.Done >= p.Total {
+		return 0
+	}
+	return p.Elapsed / time.Duration(p.Done) * time.Duration(p.Total-p.Done)
+}
+
+// String describes p on one line, such as "42/120 files, 1.3 MiB, 3 failed,
+// about 12s left".
+func (p SyncProgress) String() string {
+	s := fmt.Sprintf("%d/%d files, %s", p.Done, p.Total, formatBytes(p.Bytes))
+	if p.Failed > 0 {
+		s += fmt.Sprintf(", %d failed", p.Failed)
+	}
+	if left := p.Remaining(); left > 0 {
+		s += fmt.Sprintf(", about %s left", left.Round(time.Second))
+	}
+	return s
+}
+
+// progressTracker totals the files of a batch as they complete and reports
+// to SyncOptions.Progress. A nil *progressTracker tracks nothing.
+type progressTracker struct {
+	mu     sync.Mutex
+	report func(SyncProgress)
+	start  time.Time
+	p      SyncProgress
+}
+
+// trackProgress returns a tracker for a batch of total files, or nil when
+// o has no Progress callback or there is nothing to fetch.
+func (o *SyncOptions) trackProgress(total int) *progressTracker {
+	if o == nil || o.Progress == nil || total == 0 {
+		return nil
+	}
+	t := &progressTracker{report: o.Progress, start: time.Now(), p: SyncProgress{Total: total}}
+	t.report(t.p)
+	return t
+}
+
+// done records res, one of the batch's files, and reports the new totals.
+func (t *progressTracker) done(res FileResult) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Done++
+	if res.Outcome == FileFailed {
+		t.p.Failed++
+	}
+	t.p.Bytes += res.Bytes
+	t.p.Elapsed = time.Since(t.start)
+	t.report(t.p)
+}
+
+// formatBytes formats n bytes for people, such as "512 B" or "1.3 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
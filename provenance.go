@@ -0,0 +1,109 @@
+package wptsync
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+)
+
+// NotesRef is the git notes ref Notarize writes provenance notes under.
+const NotesRef = "refs/notes/wptsync"
+
+// Provenance records where the synced tree came from, for attaching to the
+// commit that vendors it.
+type Provenance struct {
+	// Repo is the upstream repository ("owner/name").
+	Repo string `json:"repo"`
+	// Commit is the pinned upstream commit the files were synced at.
+	Commit string `json:"commit"`
+	// LockSHA256 is the SHA-256 of the lock file, which in turn records the
+	// hash of every synced file.
+	LockSHA256 string `json:"lock_sha256"`
+	// Version is the wptsync module version that produced the tree.
+	Version string `json:"wptsync_version"`
+}
+
+// Trailers formats p as git trailer lines, ready for `git commit --trailer`,
+// `git interpret-trailers`, or a note.
+func (p *Provenance) Trailers() string {
+	return fmt.Sprintf("WPT-Repo: %s\nWPT-Commit: %s\nWPT-Lock-SHA256: %s\nWptsync-Version: %s\n",
+		p.Repo, p.Commit, p.LockSHA256, p.Version)
+}
+
+// LoadProvenance returns the provenance of the tree synced for the
+// configuration at configPath. It fails with ErrDrift if the lock file was
+// not written for the pinned commit, since the tree then cannot be
+// attributed to it.
+func LoadProvenance(configPath string) (*Provenance, error) {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		return nil, err
+	}
+	if lock.Commit != cfg.Commit {
+		return nil, fmt.Errorf("%w: lock records commit %q but config pins %q; run `wptsync sync`", ErrDrift, lock.Commit, cfg.Commit)
+	}
+	sum, err := hashFile(lockPath(configPath))
+	if err != nil {
+		return nil, fmt.Errorf("hash lock: %w", err)
+	}
+
+	repo := cfg.Repo
+	if repo == "" {
+		repo = DefaultRepo
+	}
+	return &Provenance{Repo: repo, Commit: cfg.Commit, LockSHA256: sum, Version: moduleVersion()}, nil
+}
+
+// Notarize attaches the provenance of the synced tree (see LoadProvenance)
+// as a git note under NotesRef to rev, usually the commit that vendored it,
+// in the git repository containing configPath. An existing note is
+// replaced.
+func Notarize(ctx context.Context, configPath, rev string) error {
+	p, err := LoadProvenance(configPath)
+	if err != nil {
+		return err
+	}
+	root, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return fmt.Errorf("determine repo root from config: %w", err)
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "notes", "--ref="+NotesRef, "add", "-f", "-m", p.Trailers(), rev)
+	cmd.Dir = root
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git notes add: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// moduleVersion returns the version of this module in the running binary,
+// or "(devel)" when it was not built from a tagged release.
+func moduleVersion() string {
+	const path = "github.com/oleiade/wptsync"
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if info.Main.Path == path && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			return dep.Version
+		}
+	}
+	return "(devel)"
+}
@@ -0,0 +1,55 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestNotarizeWritesGitNote(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not on PATH")
+	}
+
+	server, dir, _ := newFixture(t, map[string]string{"/c1/a/foo.js": "foo\n"})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a/foo.js"}}})
+
+	if _, err := LoadProvenance(configPath); !errors.Is(err, ErrDrift) {
+		t.Errorf("LoadProvenance before sync: expected ErrDrift, got %v", err)
+	}
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(v, "t")
+	}
+	for _, v := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(v, "t@example.com")
+	}
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return string(out)
+	}
+	git("init", "-q")
+	git("add", "-A")
+	git("commit", "-q", "-m", "Vendor WPT")
+
+	if err := Notarize(context.Background(), configPath, ""); err != nil {
+		t.Fatalf("Notarize: %v", err)
+	}
+	note := git("notes", "--ref="+NotesRef, "show", "HEAD")
+	for _, want := range []string{"WPT-Repo: web-platform-tests/wpt", "WPT-Commit: c1", "WPT-Lock-SHA256: "} {
+		if !strings.Contains(note, want) {
+			t.Errorf("note = %q, want it to contain %q", note, want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// PruneOptions configures a Prune run. A nil *PruneOptions is equivalent to
+// its zero value.
+type PruneOptions struct {
+	SyncOptions
+	// Untracked also deletes files under target_dir that wptsync never
+	// synced, such as files copied in by hand.
+	Untracked bool
+}
+
+// Prune deletes the files under target_dir that a sync wrote but that no
+// entry in the configuration at configPath maps to any more, such as the
+// files of entries removed from wpt.json since. They are known from the lock
+// file: its files no longer configured, and the ones a sync already dropped
+// from it (see lockFile.retire). With opts.Untracked, every other file under
+// target_dir that is not configured goes too. With opts.DryRun nothing is
+// deleted. It returns the dst paths pruned, or that would be.
+func Prune(ctx context.Context, configPath string, opts *PruneOptions) ([]string, error) {
+	if opts == nil {
+		opts = &PruneOptions{}
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	root, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return nil, fmt.Errorf("determine repo root from config: %w", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		return nil, err
+	}
+	configured := configuredDsts(cfg, lock)
+
+	orphans := slices.Clone(lock.Retired)
+	for dst := range lock.Files {
+		if !configured(dst) {
+			orphans = append(orphans, dst)
+		}
+	}
+	targetDir := filepath.Join(root, cfg.TargetDir)
+	if opts.Untracked {
+		// The configuration, its lock, and patches are never synced files.
+		if rel, err := filepath.Rel(targetDir, root); err == nil && filepath.IsLocal(rel) {
+			return nil, invalidConfig(fmt.Errorf("target_dir %q holds the configuration; refusing to prune untracked files", cfg.TargetDir))
+		}
+		patches := make(map[string]bool)
+		for _, f := range cfg.Files {
+			if f.Patch != "" {
+				patches[patchAbsPath(root, f)] = true
+			}
+		}
+		// Groups synced into a subdirectory have files of their own there.
+		groupDirs := make(map[string]bool)
+		for _, dir := range cfg.groupDirs() {
+			groupDirs[filepath.Join(root, dir)] = true
+		}
+		err := filepath.WalkDir(targetDir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return fs.SkipAll
+				}
+				return err
+			}
+			if d.IsDir() && groupDirs[p] {
+				return fs.SkipDir
+			}
+			if d.IsDir() || patches[p] {
+				return nil
+			}
+			rel, err := filepath.Rel(targetDir, p)
+			if err != nil {
+				return err
+			}
+			if dst := filepath.ToSlash(rel); dst != stampFileName && dst != StateFileName && !cfg.isNotices(dst) && !configured(dst) {
+				orphans = append(orphans, dst)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walk %s: %w", targetDir, err)
+		}
+	}
+	slices.Sort(orphans)
+	orphans = slices.Compact(orphans)
+
+	if err := checkDstsOnDisk(root, withDsts(cfg, orphans)); err != nil {
+		return nil, err
+	}
+
+	var pruned []string
+	for _, dst := range orphans {
+		if err := ctx.Err(); err != nil {
+			return pruned, err
+		}
+		dest := filepath.Join(targetDir, filepath.FromSlash(dst))
+		if _, err := os.Lstat(dest); err != nil {
+			continue
+		}
+		pruned = append(pruned, dst)
+		if opts.DryRun {
+			opts.logf(" - would remove %s\n", dst)
+			continue
+		}
+		opts.logf(" - %s\n", dst)
+		if err := os.Remove(dest); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return pruned, fmt.Errorf("delete %s: %w", dest, err)
+		}
+		removeEmptyDirs(filepath.Dir(dest), targetDir)
+	}
+
+	switch {
+	case len(pruned) == 0:
+		opts.logf("No files to prune.\n")
+	case opts.DryRun:
+		opts.logf("Would prune %d files from %s\n", len(pruned), cfg.TargetDir)
+	default:
+		opts.logf("Pruned %d files from %s\n", len(pruned), cfg.TargetDir)
+	}
+	if opts.DryRun || len(orphans) == 0 {
+		return pruned, nil
+	}
+
+	// Orphans are gone now, whether pruned here or deleted before.
+	for _, dst := range orphans {
+		delete(lock.Files, dst)
+	}
+	lock.Retired = nil
+	if err := saveLock(lockPath(configPath), lock, cfg.CompressState); err != nil {
+		return pruned, err
+	}
+	recordSynced(root, cfg, lock, nil, orphans, opts.logf)
+	writeNotices(root, cfg, lock, opts.logf)
+	return pruned, nil
+}
+
+// retire records in l.Retired the files old lists, as synced or already
+// retired, that l does not and that no entry of cfg maps to, as long as
+// they are still on disk. This is how Prune finds, after a sync rewrote the
+// lock, the files of entries removed from the configuration.
+func (l *lockFile) retire(old *lockFile, root string, cfg *Config) {
+	configured := configuredDsts(cfg, old)
+	candidates := slices.Clone(old.Retired)
+	for dst := range old.Files {
+		candidates = append(candidates, dst)
+	}
+	for _, dst := range candidates {
+		if _, ok := l.Files[dst]; ok || configured(dst) || slices.Contains(l.Retired, dst) {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(root, cfg.TargetDir, filepath.FromSlash(dst))); err == nil {
+			l.Retired = append(l.Retired, dst)
+		}
+	}
+	slices.Sort(l.Retired)
+}
+
+// retireFrom runs retire against the lock currently saved at lockName, just
+// before it is replaced by l.
+func (l *lockFile) retireFrom(lockName, root string, cfg *Config) {
+	if old, err := loadLock(lockName); err == nil {
+		l.retire(old, root, cfg)
+	}
+}
+
+// configuredDsts returns a predicate reporting whether some entry of cfg,
+// enabled or not, maps to dst. Glob entries claim the files lock recorded
+// for a src they match, so an unexpanded cfg still covers them, and
+// UpstreamDocs the documentation of the directories cfg still syncs from.
+// The license of Notices is configured too.
+func configuredDsts(cfg *Config, lock *lockFile) func(dst string) bool {
+	dsts := make(map[string]bool, len(cfg.Files))
+	var globs []string
+	for _, f := range withLicense(cfg).Files {
+		if isGlob(f.Src) {
+			globs = append(globs, f.Src)
+			continue
+		}
+		dsts[f.Dst] = true
+	}
+	docs := make(map[string]bool)
+	if cfg.UpstreamDocs != nil {
+		for _, src := range cfg.UpstreamDocs.docCandidates(expandGlobsFromLock(cfg, lock).Files) {
+			docs[src] = true
+		}
+	}
+	return func(dst string) bool {
+		if dsts[dst] {
+			return true
+		}
+		entry, ok := lock.Files[dst]
+		if ok && docs[entry.Src] && cfg.UpstreamDocs.isDoc(dst, entry.Src) {
+			return true
+		}
+		return ok && slices.ContainsFunc(globs, func(pattern string) bool { return matchGlob(pattern, entry.Src) })
+	}
+}
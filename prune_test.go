@@ -0,0 +1,122 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPruneRemovesFilesDroppedFromConfig(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/a.js":     "a\n",
+		"/c1/old/b.js": "b\n",
+	})
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a.js"}, {Src: "old/b.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+	opts := &SyncOptions{BaseURL: server.URL, APIURL: server.URL}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// Dropping the entry by hand and syncing again rewrites the lock
+	// without it, but remembers the file for prune.
+	cfg.Files = cfg.Files[:1]
+	saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(lock.Retired, []string{"old/b.js"}) {
+		t.Fatalf("lock retired = %q, want old/b.js", lock.Retired)
+	}
+
+	stale := filepath.Join(dir, "wpt", "old", "b.js")
+	pruned, err := Prune(context.Background(), configPath, &PruneOptions{SyncOptions: SyncOptions{DryRun: true}})
+	if err != nil || !slices.Equal(pruned, []string{"old/b.js"}) {
+		t.Fatalf("dry-run Prune = %q, %v, want old/b.js", pruned, err)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Fatalf("dry run deleted the file: %v", err)
+	}
+
+	if _, err := Prune(context.Background(), configPath, nil); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(stale)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stale file's directory still exists: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wpt", "a.js")); err != nil {
+		t.Errorf("configured file was pruned: %v", err)
+	}
+	if lock, _ := loadLock(lockPath(configPath)); len(lock.Retired) != 0 {
+		t.Errorf("lock still retires %q after prune", lock.Retired)
+	}
+}
+
+func TestPruneRefusesDstsThroughSymlinks(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{"/c1/a.js": "a\n", "/c1/old/b.js": "b\n"})
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a.js"}, {Src: "old/b.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+	opts := &SyncOptions{BaseURL: server.URL, APIURL: server.URL}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	cfg.Files = cfg.Files[:1]
+	saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+
+	// Swap old for a link to a directory outside target_dir holding a b.js.
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "b.js"), []byte("mine\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "wpt", "old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "wpt", "old")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+
+	if _, err := Prune(context.Background(), configPath, nil); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Prune = %v, want ErrInvalidConfig for old/b.js through the symlink", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "b.js")); err != nil {
+		t.Errorf("Prune deleted a file outside target_dir: %v", err)
+	}
+}
+
+func TestPruneUntracked(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{"/c1/a.js": "a\n"})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a.js"}}})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, APIURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	extra := filepath.Join(dir, "wpt", "copied", "extra.js")
+	if err := writeFileAtomic(extra, []byte("x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if pruned, err := Prune(context.Background(), configPath, nil); err != nil || len(pruned) != 0 {
+		t.Fatalf("Prune without Untracked = %q, %v, want nothing pruned", pruned, err)
+	}
+	pruned, err := Prune(context.Background(), configPath, &PruneOptions{Untracked: true})
+	if err != nil || !slices.Equal(pruned, []string{"copied/extra.js"}) {
+		t.Fatalf("Prune with Untracked = %q, %v, want copied/extra.js", pruned, err)
+	}
+	if _, err := os.Stat(stampPath(dir, &Config{TargetDir: "wpt"})); err != nil {
+		t.Errorf("stamp was pruned: %v", err)
+	}
+
+	inPlace := saveTestConfig(t, t.TempDir(), &Config{Commit: "c1", TargetDir: "."})
+	if _, err := Prune(context.Background(), inPlace, &PruneOptions{Untracked: true}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Prune with target_dir \".\" error = %v, want ErrInvalidConfig", err)
+	}
+}
@@ -0,0 +1,167 @@
+package wptsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultPublishBranch is the branch Publish pushes to when
+// PublishOptions.Branch is empty.
+const DefaultPublishBranch = "main"
+
+// ProvenanceFile is the name of the provenance manifest Publish adds at the
+// root of the published tree.
+const ProvenanceFile = "wpt-provenance.json"
+
+// PublishOptions configures a Publish run.
+type PublishOptions struct {
+	// Remote is the repository to push to: a URL or a local path.
+	Remote string
+	// Branch is the branch to push to. Empty means DefaultPublishBranch.
+	Branch string
+}
+
+// Publish commits the synced files of the configuration at configPath,
+// laid out as under target_dir, plus a ProvenanceFile manifest, on top of
+// opts.Branch of opts.Remote and pushes the result, much like git subtree
+// split. The tree must verify cleanly against the lock first. It works in a
+// scratch repository, so neither the caller's index nor its working tree is
+// touched. It returns the branch's new head and whether anything was
+// pushed: when the tree is unchanged, the existing head is returned.
+func Publish(ctx context.Context, configPath string, opts *PublishOptions) (string, bool, error) {
+	if opts == nil || opts.Remote == "" {
+		return "", false, invalidConfig(errors.New("publish needs a remote"))
+	}
+	branch := opts.Branch
+	if branch == "" {
+		branch = DefaultPublishBranch
+	}
+	remote := opts.Remote
+	if _, err := os.Stat(remote); err == nil {
+		if remote, err = filepath.Abs(remote); err != nil {
+			return "", false, err
+		}
+	}
+
+	if err := Verify(ctx, configPath, nil); err != nil {
+		return "", false, err
+	}
+	p, err := LoadProvenance(configPath)
+	if err != nil {
+		return "", false, err
+	}
+	manifest, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return "", false, fmt.Errorf("marshal provenance: %w", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return "", false, err
+	}
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		return "", false, err
+	}
+	root, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return "", false, fmt.Errorf("determine repo root from config: %w", err)
+	}
+	targetDir := filepath.Join(root, cfg.TargetDir)
+
+	tmpDir, err := os.MkdirTemp("", "wptsync-publish-")
+	if err != nil {
+		return "", false, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	g := &scratchRepo{
+		gitDir:   filepath.Join(tmpDir, "repo.git"),
+		index:    filepath.Join(tmpDir, "index"),
+		workTree: targetDir,
+	}
+	if out, err := exec.CommandContext(ctx, "git", "init", "-q", "--bare", g.gitDir).CombinedOutput(); err != nil {
+		return "", false, fmt.Errorf("git init: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	ref := "refs/heads/" + branch
+	heads, err := g.run(ctx, nil, "ls-remote", "--", remote, ref)
+	if err != nil {
+		return "", false, err
+	}
+	var parent string
+	if fields := strings.Fields(heads); len(fields) > 0 {
+		parent = fields[0]
+		if _, err := g.run(ctx, nil, "fetch", "-q", "--depth=1", "--", remote, ref); err != nil {
+			return "", false, err
+		}
+	}
+
+	// Only what the lock vouches for is published: no stamps or strays.
+	dsts := make([]string, 0, len(lock.Files))
+	for dst := range lock.Files {
+		dsts = append(dsts, dst)
+	}
+	sort.Strings(dsts)
+	if _, err := g.run(ctx, strings.NewReader(strings.Join(dsts, "\x00")), "update-index", "--add", "-z", "--stdin"); err != nil {
+		return "", false, err
+	}
+	blob, err := g.run(ctx, bytes.NewReader(append(manifest, '\n')), "hash-object", "-w", "--stdin")
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := g.run(ctx, nil, "update-index", "--add", "--cacheinfo", "100644,"+blob+","+ProvenanceFile); err != nil {
+		return "", false, err
+	}
+	tree, err := g.run(ctx, nil, "write-tree")
+	if err != nil {
+		return "", false, err
+	}
+
+	args := []string{"commit-tree", tree}
+	if parent != "" {
+		parentTree, err := g.run(ctx, nil, "rev-parse", parent+"^{tree}")
+		if err != nil {
+			return "", false, err
+		}
+		if parentTree == tree {
+			return parent, false, nil
+		}
+		args = append(args, "-p", parent)
+	}
+	message := fmt.Sprintf("Sync WPT at %s\n\n%s", shortSHA(p.Commit), p.Trailers())
+	commit, err := g.run(ctx, strings.NewReader(message), append(args, "-F", "-")...)
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := g.run(ctx, nil, "push", "-q", "--", remote, commit+":"+ref); err != nil {
+		return "", false, err
+	}
+	return commit, true, nil
+}
+
+// scratchRepo runs git against the throwaway repository and index Publish
+// builds its commit in, with target_dir as the work tree.
+type scratchRepo struct {
+	gitDir, index, workTree string
+}
+
+// run runs git with args and returns its trimmed standard output.
+func (g *scratchRepo) run(ctx context.Context, stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir, cmd.Stdin = g.workTree, stdin
+	cmd.Env = append(os.Environ(), "GIT_DIR="+g.gitDir, "GIT_INDEX_FILE="+g.index, "GIT_WORK_TREE="+g.workTree)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
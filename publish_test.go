@@ -0,0 +1,73 @@
+package wptsync
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPublishPushesSyncedTreeWithManifest(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not on PATH")
+	}
+	for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(v, "t")
+	}
+	for _, v := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(v, "t@example.com")
+	}
+
+	content := map[string]string{"/c1/a/foo.js": "foo\n", "/c2/a/foo.js": "foo v2\n"}
+	server, dir, _ := newFixture(t, content)
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a/foo.js"}}})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	remote := filepath.Join(t.TempDir(), "bundle.git")
+	if out, err := exec.Command("git", "init", "-q", "--bare", remote).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"--git-dir=" + remote}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	opts := &PublishOptions{Remote: remote, Branch: "wpt"}
+	first, pushed, err := Publish(context.Background(), configPath, opts)
+	if err != nil || !pushed {
+		t.Fatalf("Publish = %q, %v, %v; want a push", first, pushed, err)
+	}
+	if files := git("ls-tree", "-r", "--name-only", "wpt"); files != "a/foo.js\n"+ProvenanceFile {
+		t.Errorf("published files = %q", files)
+	}
+	var p Provenance
+	if err := json.Unmarshal([]byte(git("show", "wpt:"+ProvenanceFile)), &p); err != nil || p.Commit != "c1" {
+		t.Errorf("manifest = %+v, %v; want commit c1", p, err)
+	}
+
+	if head, pushed, err := Publish(context.Background(), configPath, opts); err != nil || pushed || head != first {
+		t.Errorf("republishing an unchanged tree = %q, %v, %v; want %q without a push", head, pushed, err, first)
+	}
+
+	if err := Update(context.Background(), configPath, &UpdateOptions{SyncOptions: SyncOptions{BaseURL: server.URL}, Commit: "c2"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	second, pushed, err := Publish(context.Background(), configPath, opts)
+	if err != nil || !pushed {
+		t.Fatalf("Publish after update = %q, %v, %v; want a push", second, pushed, err)
+	}
+	if parent := git("rev-parse", second+"^"); parent != first {
+		t.Errorf("parent of the new bundle commit = %s, want %s", parent, first)
+	}
+	if msg := git("log", "-1", "--format=%B", "wpt"); !strings.Contains(msg, "WPT-Commit: c2") {
+		t.Errorf("commit message = %q, want provenance trailers", msg)
+	}
+}
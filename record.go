@@ -0,0 +1,166 @@
+package wptsync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// recording is the on-disk form of a single recorded HTTP exchange. Only the
+// request line is kept (never its headers), so tokens sent in Authorization
+// headers are not written to disk, and the installation tokens GitHub App
+// runs mint are redacted from the responses (see redactToken).
+type recording struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body"`
+}
+
+// recordingKey names the recording for req. The request body is part of the
+// key so distinct GraphQL queries to the same endpoint don't collide.
+func recordingKey(method, url string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", method, url)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)) + ".json"
+}
+
+// readRequestBody drains and restores req.Body so it can be both hashed and
+// sent.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// transportOf returns the RoundTripper client uses.
+func transportOf(client *http.Client) http.RoundTripper {
+	if client.Transport != nil {
+		return client.Transport
+	}
+	return http.DefaultTransport
+}
+
+// recordTransport forwards requests to next and saves every response under
+// dir for replayTransport.
+type recordTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, fmt.Errorf("record: read request body: %w", err)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("record: read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	rec := recording{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+		Header: resp.Header,
+		Body:   redactToken(req.URL.Path, respBody),
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("record: encode %s: %w", rec.URL, err)
+	}
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("record: create directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(t.dir, recordingKey(req.Method, rec.URL, body)), data, 0o644); err != nil {
+		return nil, fmt.Errorf("record: write %s: %w", rec.URL, err)
+	}
+
+	return resp, nil
+}
+
+// redactToken returns body, the response to a request for urlPath, with the
+// token of a GitHub App installation token response replaced, so that no
+// live credential is recorded. Replays mint a token that is never used for
+// real requests.
+func redactToken(urlPath string, body []byte) []byte {
+	if !strings.HasSuffix(urlPath, "/access_tokens") {
+		return body
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	if _, ok := fields["token"]; !ok {
+		return body
+	}
+	fields["token"] = "redacted"
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+// replayTransport serves responses saved by recordTransport and never
+// touches the network.
+type replayTransport struct {
+	dir string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, fmt.Errorf("replay: read request body: %w", err)
+	}
+
+	url := req.URL.String()
+	data, err := os.ReadFile(filepath.Join(t.dir, recordingKey(req.Method, url, body)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("replay: no recording for %s %s in %s", req.Method, url, t.dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("replay: %w", err)
+	}
+
+	var rec recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("replay: decode recording for %s: %w", url, err)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.Status, http.StatusText(rec.Status)),
+		StatusCode:    rec.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.Header,
+		Body:          io.NopCloser(bytes.NewReader(rec.Body)),
+		ContentLength: int64(len(rec.Body)),
+		Request:       req,
+	}, nil
+}
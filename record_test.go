@@ -0,0 +1,51 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordThenReplay(t *testing.T) {
+	content := map[string]string{"/c1/a/foo.js": "content A\n"}
+	server, dir, _ := newFixture(t, content)
+
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a/foo.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+	recordDir := filepath.Join(dir, "recordings")
+
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, RecordDir: recordDir}); err != nil {
+		t.Fatalf("recording Sync: %v", err)
+	}
+
+	// Replay must not need the network: shut the server down and wipe the
+	// synced tree so the stamp can't short-circuit the run.
+	server.Close()
+	if err := os.RemoveAll(filepath.Join(dir, "wpt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, ReplayDir: recordDir}); err != nil {
+		t.Fatalf("replaying Sync: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "wpt", "a", "foo.js"))
+	if err != nil {
+		t.Fatalf("read replayed file: %v", err)
+	}
+	if string(got) != content["/c1/a/foo.js"] {
+		t.Errorf("replayed content = %q, want %q", got, content["/c1/a/foo.js"])
+	}
+}
+
+func TestReplayMissingRecording(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a/foo.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: "http://example.invalid", ReplayDir: filepath.Join(dir, "empty")})
+	if err == nil || !strings.Contains(err.Error(), "no recording") {
+		t.Errorf("expected missing-recording error, got %v", err)
+	}
+}
@@ -0,0 +1,341 @@
+package wptsync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// FileOutcome is what a Sync or Update did with one file.
+type FileOutcome string
+
+const (
+	// FileDownloaded means the file was fetched and written (patched, if
+	// it has a patch and patches were not skipped).
+	FileDownloaded FileOutcome = "downloaded"
+	// FileSkipped means the file needed no work: it is disabled, unchanged
+	// since the last sync, or was not modified upstream.
+	FileSkipped FileOutcome = "skipped"
+	// FileFailed means the file could not be synced; see FileResult.Error.
+	FileFailed FileOutcome = "failed"
+)
+
+// FileResult is the outcome of one file in a SyncReport.
+type FileResult struct {
+	Src     string      `json:"src"`
+	Dst     string      `json:"dst"`
+	Outcome FileOutcome `json:"outcome"`
+	// Reason says why a file was skipped.
+	Reason string `json:"reason,omitempty"`
+	// Patched is set when the file's patch was applied, Merged when
+	// update had to three-way merge it instead.
+	Patched bool `json:"patched,omitempty"`
+	Merged  bool `json:"merged,omitempty"`
+	// Bytes is the size of the file written.
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// SyncSummary totals the files of a SyncReport by outcome.
+type SyncSummary struct {
+	Downloaded int   `json:"downloaded"`
+	Skipped    int   `json:"skipped"`
+	Patched    int   `json:"patched"`
+	Failed     int   `json:"failed"`
+	Bytes      int64 `json:"bytes"`
+}
+
+// SyncReport is filled by Sync and Update when passed as
+// SyncOptions.Report, for callers that need more than an error back, such as
+// the CLI's -format json. It is safe to read once the call has returned.
+type SyncReport struct {
+	// Commit is the commit synced; PreviousCommit the one update moved
+	// away from.
+	Commit         string `json:"commit"`
+	PreviousCommit string `json:"previous_commit,omitempty"`
+	// UpToDate is set when the freshness stamp or an update to the
+	// already pinned commit made the whole run a no-op.
+	UpToDate bool        `json:"up_to_date"`
+	DryRun   bool        `json:"dry_run,omitempty"`
+	Summary  SyncSummary `json:"summary"`
+	// Directories totals the files by the top-level WPT directory of
+	// their src ("." for files at the root), such as "url" or "fetch".
+	Directories map[string]SyncSummary `json:"directories,omitempty"`
+	Files       []FileResult           `json:"files"`
+	// FilesChanged counts the files whose content, as the lock file records
+	// it, differs from the last sync: new ones included, those downloaded
+	// again as they were not. Runs that write no lock file, such as dry
+	// runs, leave it zero.
+	FilesChanged int           `json:"files_changed"`
+	Duration     time.Duration `json:"duration_ns"`
+
+	mu    sync.Mutex
+	start time.Time
+}
+
+// NothingToDo reports whether the run neither wrote nor failed any file.
+func (r *SyncReport) NothingToDo() bool {
+	return r.Summary.Downloaded == 0 && r.Summary.Failed == 0
+}
+
+// ExitCode is ExitCode for the run that filled r and returned err, refined
+// with ExitNothingToDo when nothing needed doing and ExitPartialFailure
+// when some files were written before others failed. Configuration errors
+// keep ExitConfig.
+func (r *SyncReport) ExitCode(err error) int {
+	switch {
+	case err == nil && r.NothingToDo():
+		return ExitNothingToDo
+	case err == nil, errors.Is(err, ErrInvalidConfig):
+		return ExitCode(err)
+	case r.Summary.Downloaded > 0 && r.Summary.Failed > 0:
+		return ExitPartialFailure
+	}
+	return ExitCode(err)
+}
+
+// begin resets r for a run at commit.
+func (r *SyncReport) begin(commit string, dryRun bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Commit, r.PreviousCommit, r.UpToDate, r.DryRun = commit, "", false, dryRun
+	r.Summary, r.Directories, r.Files, r.FilesChanged, r.Duration = SyncSummary{}, nil, []FileResult{}, 0, 0
+	r.start = time.Now()
+}
+
+// moved records that the run updates the pinned commit from prev to commit.
+func (r *SyncReport) moved(prev, commit string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.PreviousCommit, r.Commit = prev, commit
+}
+
+// upToDate records that the run had nothing to do.
+func (r *SyncReport) upToDate() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.UpToDate = true
+}
+
+// changed records the files next, the lock file a run is about to save,
+// has with other content than prev, the one it replaces.
+func (r *SyncReport) changed(prev, next *lockFile) {
+	if r == nil {
+		return
+	}
+	n := 0
+	for dst, entry := range next.Files {
+		if old, ok := prev.Files[dst]; !ok || old.SHA256 != entry.SHA256 {
+			n++
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FilesChanged = n
+}
+
+// Changed reports whether the run changed the vendored tree: it moved the
+// pinned commit or changed the content of a file.
+func (r *SyncReport) Changed() bool {
+	return !r.DryRun && (r.FilesChanged > 0 || r.PreviousCommit != "" && r.PreviousCommit != r.Commit)
+}
+
+// WriteGitHubOutput writes the outputs of r for a GitHub Actions step to
+// w, in the format of the file $GITHUB_OUTPUT names: changed ("true" or
+// "false", see Changed), new_commit, the commit now pinned,
+// previous_commit, the one update moved away from if any, and
+// files_changed (see FilesChanged).
+func (r *SyncReport) WriteGitHubOutput(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "changed=%t\nnew_commit=%s\nprevious_commit=%s\nfiles_changed=%d\n", r.Changed(), r.Commit, r.PreviousCommit, r.FilesChanged)
+	return err
+}
+
+// add records results, in the order given. Results never filled in, for
+// files a failed run did not reach, are left out.
+func (r *SyncReport) add(results ...FileResult) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, res := range results {
+		if res.Outcome == "" {
+			continue
+		}
+		r.Summary.count(res)
+		if r.Directories == nil {
+			r.Directories = make(map[string]SyncSummary)
+		}
+		dir := topDir(res.Src)
+		group := r.Directories[dir]
+		group.count(res)
+		r.Directories[dir] = group
+		r.Files = append(r.Files, res)
+	}
+}
+
+// count adds res to s.
+func (s *SyncSummary) count(res FileResult) {
+	switch res.Outcome {
+	case FileDownloaded:
+		s.Downloaded++
+		if res.Patched {
+			s.Patched++
+		}
+	case FileSkipped:
+		s.Skipped++
+	case FileFailed:
+		s.Failed++
+	}
+	s.Bytes += res.Bytes
+}
+
+// topDir returns the top-level directory of the WPT path src, or "." for a
+// file at the root.
+func topDir(src string) string {
+	dir, _, ok := strings.Cut(strings.TrimLeft(src, "/"), "/")
+	if !ok {
+		return "."
+	}
+	return dir
+}
+
+// WriteDirectories writes r's totals by directory to w, one line each in
+// directory order, such as "url: 42 updated (3 patched), 1 skipped", so
+// that runs spanning many WPT areas are easy to skim. It writes nothing when
+// the files all come from a single directory, as the per-file progress
+// already says it all.
+func (r *SyncReport) WriteDirectories(w io.Writer) error {
+	if len(r.Directories) < 2 {
+		return nil
+	}
+	updated := "updated"
+	if r.DryRun {
+		updated = "to update"
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "Summary by directory:\n")
+	for _, dir := range slices.Sorted(maps.Keys(r.Directories)) {
+		s := r.Directories[dir]
+		var parts []string
+		if s.Downloaded > 0 {
+			part := fmt.Sprintf("%d %s", s.Downloaded, updated)
+			if s.Patched > 0 {
+				part += fmt.Sprintf(" (%d patched)", s.Patched)
+			}
+			parts = append(parts, part)
+		}
+		if s.Failed > 0 {
+			parts = append(parts, fmt.Sprintf("%d failed", s.Failed))
+		}
+		if len(parts) == 0 {
+			parts = append(parts, "0 changes")
+		}
+		if s.Skipped > 0 {
+			parts = append(parts, fmt.Sprintf("%d skipped", s.Skipped))
+		}
+		fmt.Fprintf(tw, "  %s:\t%s\n", dir, strings.Join(parts, ", "))
+	}
+	return tw.Flush()
+}
+
+// WriteSummary writes a line totalling r's files to w, such as "Downloaded
+// 12 files (3 patched), skipped 40, failed 1: 1.2 MiB in 3.4s".
+func (r *SyncReport) WriteSummary(w io.Writer) error {
+	s := r.Summary
+	verb := "Downloaded"
+	if r.DryRun {
+		verb = "Would download"
+	}
+	line := fmt.Sprintf("%s %d file(s)", verb, s.Downloaded)
+	if s.Patched > 0 {
+		line += fmt.Sprintf(" (%d patched)", s.Patched)
+	}
+	line += fmt.Sprintf(", skipped %d", s.Skipped)
+	if s.Failed > 0 {
+		line += fmt.Sprintf(", failed %d", s.Failed)
+	}
+	_, err := fmt.Fprintf(w, "%s: %s in %s\n", line, formatBytes(s.Bytes), r.Duration.Round(time.Millisecond))
+	return err
+}
+
+// WriteFiles writes one line per file of r to w, in the order they were
+// recorded, with what happened to it, its size, and how long it took.
+func (r *SyncReport) WriteFiles(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, f := range r.Files {
+		outcome := string(f.Outcome)
+		switch {
+		case f.Merged:
+			outcome += " (merged)"
+		case f.Patched:
+			outcome += " (patched)"
+		case f.Reason != "":
+			outcome += " (" + f.Reason + ")"
+		}
+		detail := ""
+		switch {
+		case f.Error != "":
+			detail = f.Error
+		case f.Outcome == FileDownloaded:
+			detail = fmt.Sprintf("%s in %s", formatBytes(f.Bytes), f.Duration.Round(time.Millisecond))
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", outcome, f.Dst, detail)
+	}
+	return tw.Flush()
+}
+
+// skip records file as skipped for reason.
+func (r *SyncReport) skip(file FileSpec, reason string) {
+	r.add(FileResult{Src: file.Src, Dst: file.Dst, Outcome: FileSkipped, Reason: reason})
+}
+
+// finish stamps r with the run's total duration.
+func (r *SyncReport) finish() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Duration = time.Since(r.start)
+}
+
+// fileResult describes processing file, started at start, that ended with
+// err: errNotModified is a skip, any other error a failure.
+func (o *SyncOptions) fileResult(root string, cfg *Config, file FileSpec, start time.Time, err error) FileResult {
+	res := FileResult{Src: file.Src, Dst: file.Dst, Duration: time.Since(start)}
+	switch {
+	case errors.Is(err, errNotModified):
+		res.Outcome, res.Reason = FileSkipped, "not modified upstream"
+	case err != nil:
+		res.Outcome, res.Error = FileFailed, err.Error()
+	default:
+		res.Outcome = FileDownloaded
+		res.Patched = file.Patch != "" && (o == nil || !o.SkipPatches)
+		if o != nil && o.DryRun {
+			break
+		}
+		if info, err := os.Stat(filepath.Join(root, cfg.TargetDir, filepath.FromSlash(file.Dst))); err == nil {
+			res.Bytes = info.Size()
+		}
+	}
+	return res
+}
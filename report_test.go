@@ -0,0 +1,194 @@
+package wptsync
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncReport(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/a.js": "aaaa\n",
+		"/c1/b.js": "bb\n",
+	})
+	off := false
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "a.js"}, {Src: "b.js"}, {Src: "c.js", Enabled: &off}},
+	})
+
+	report := &SyncReport{}
+	opts := &SyncOptions{BaseURL: server.URL, APIURL: server.URL, Report: report}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	want := SyncSummary{Downloaded: 2, Skipped: 1, Bytes: 8}
+	if report.Commit != "c1" || report.Summary != want || len(report.Files) != 3 {
+		t.Fatalf("report = %+v, want commit c1 and summary %+v", report, want)
+	}
+	if got := report.ExitCode(nil); got != 0 {
+		t.Errorf("ExitCode after downloading = %d, want 0", got)
+	}
+
+	// The stamp is fresh now, so a second run has nothing to do.
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if !report.UpToDate || len(report.Files) != 0 || report.ExitCode(nil) != ExitNothingToDo {
+		t.Errorf("second run report = %+v, want up to date with exit code %d", report, ExitNothingToDo)
+	}
+}
+
+func TestSyncReportPatchedAndPartialFailure(t *testing.T) {
+	server, _, configPath := newPatchFixture(t)
+	report := &SyncReport{}
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, APIURL: server.URL, Report: report}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if report.Summary.Patched != 1 || !report.Files[0].Patched {
+		t.Errorf("patched file report = %+v, want it counted as patched", report)
+	}
+
+	server, dir, _ := newFixture(t, map[string]string{"/c1/a.js": "a\n"})
+	configPath = saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "a.js"}, {Src: "missing.js"}},
+	})
+	err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, APIURL: server.URL, Jobs: 1, Report: report})
+	if err == nil {
+		t.Fatal("Sync succeeded despite a missing file")
+	}
+	if report.Summary.Downloaded != 1 || report.Summary.Failed != 1 {
+		t.Fatalf("summary = %+v, want 1 downloaded and 1 failed", report.Summary)
+	}
+	if got := report.ExitCode(err); got != ExitPartialFailure {
+		t.Errorf("ExitCode = %d, want ExitPartialFailure (%d); plain ExitCode gives %d", got, ExitPartialFailure, ExitCode(err))
+	}
+	if f := report.Files[1]; f.Dst != "missing.js" || f.Outcome != FileFailed || f.Error == "" {
+		t.Errorf("failed file = %+v", f)
+	}
+}
+
+func TestSyncReportDirectories(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/url/a.js":       "a\n",
+		"/c1/url/b.js":       "b\n",
+		"/c1/fetch/c.js":     "c\n",
+		"/c1/testharness.js": "t\n",
+	})
+	off := false
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files: []FileSpec{
+			{Src: "url/a.js"}, {Src: "url/b.js"}, {Src: "fetch/c.js"}, {Src: "fetch/missing.js"},
+			{Src: "streams/d.js", Enabled: &off}, {Src: "testharness.js"},
+		},
+	})
+	report := &SyncReport{}
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Report: report}); err == nil {
+		t.Fatal("Sync succeeded despite a missing file")
+	}
+	if got := report.Directories["url"]; got.Downloaded != 2 {
+		t.Errorf("url totals = %+v, want 2 downloaded", got)
+	}
+
+	var buf strings.Builder
+	if err := report.WriteDirectories(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `Summary by directory:
+  .:       1 updated
+  fetch:   1 updated, 1 failed
+  streams: 0 changes, 1 skipped
+  url:     2 updated
+`
+	if buf.String() != want {
+		t.Errorf("WriteDirectories wrote\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestSyncProgressAndSummary(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/a.js": "aaaa\n",
+		"/c1/b.js": "bb\n",
+	})
+	off := false
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "a.js"}, {Src: "b.js"}, {Src: "c.js", Enabled: &off}},
+	})
+
+	var seen []SyncProgress
+	report := &SyncReport{}
+	opts := &SyncOptions{BaseURL: server.URL, Report: report, Progress: func(p SyncProgress) { seen = append(seen, p) }}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(seen) != 3 || seen[0].Done != 0 {
+		t.Fatalf("progress = %+v, want a start and one call per downloaded file", seen)
+	}
+	if last := seen[2]; last.Done != 2 || last.Total != 2 || last.Bytes != 8 || last.Remaining() != 0 {
+		t.Errorf("last progress = %+v, want 2/2 files and 8 bytes", last)
+	}
+
+	report.Duration = 1500 * time.Millisecond
+	var buf strings.Builder
+	if err := report.WriteSummary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Downloaded 2 file(s), skipped 1: 8 B in 1.5s\n"; buf.String() != want {
+		t.Errorf("WriteSummary wrote %q, want %q", buf.String(), want)
+	}
+
+	p := SyncProgress{Done: 1, Total: 4, Bytes: 3 << 19, Elapsed: 10 * time.Second}
+	if got, want := p.String(), "1/4 files, 1.5 MiB, about 30s left"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+}
+
+func TestSyncReportFilesChanged(t *testing.T) {
+	content := map[string]string{
+		"/c1/a.js": "a\n",
+		"/c1/b.js": "b\n",
+	}
+	server, dir, _ := newFixture(t, content)
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "a.js"}, {Src: "b.js"}},
+	})
+	report := &SyncReport{}
+	opts := &SyncOptions{BaseURL: server.URL, APIURL: server.URL, Report: report}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if report.FilesChanged != 2 || !report.Changed() {
+		t.Errorf("first sync: files_changed = %d, changed = %t, want 2 and true", report.FilesChanged, report.Changed())
+	}
+
+	// Downloading the same content again changes nothing.
+	opts.Force = true
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("forced Sync: %v", err)
+	}
+	if report.Summary.Downloaded != 2 || report.FilesChanged != 0 || report.Changed() {
+		t.Errorf("forced sync: downloaded %d, files_changed = %d, changed = %t, want 2, 0, and false", report.Summary.Downloaded, report.FilesChanged, report.Changed())
+	}
+
+	content["/c1/b.js"] = "b2\n"
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync after upstream change: %v", err)
+	}
+	var out strings.Builder
+	if err := report.WriteGitHubOutput(&out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "changed=true\nnew_commit=c1\nprevious_commit=\nfiles_changed=1\n"; out.String() != want {
+		t.Errorf("GitHub output = %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,116 @@
+package wptsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// mergeToolCommand returns the shell command that resolves a conflicted file:
+// tool when set, otherwise the user's $VISUAL or $EDITOR (falling back to
+// vi) opened on the file with the conflict markers.
+func mergeToolCommand(tool string) string {
+	if tool != "" {
+		return tool
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	return editor + ` "$MERGED"`
+}
+
+// resolveConflict runs the merge tool on a file mergePatch left with
+// conflict markers, the way git mergetool does: the tool runs in a shell
+// with $BASE, $LOCAL (the patched file at the previous commit), $REMOTE (the
+// new upstream file), and $MERGED (the file on disk) set, and is reopened
+// for as long as the user wants while the tool fails or markers remain.
+// Once the file is clean its patch is regenerated. It reports whether the
+// conflict was resolved; answers are read from in.
+func resolveConflict(ctx context.Context, root string, cfg *Config, file FileSpec, m *fileMerge, tool string, in *bufio.Reader) (bool, error) {
+	dest := filepath.Join(root, cfg.TargetDir, filepath.FromSlash(file.Dst))
+
+	tmpDir, err := os.MkdirTemp("", "wptsync-resolve-")
+	if err != nil {
+		return false, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Keep the extension so editors pick the right syntax highlighting.
+	ext := path.Ext(file.Dst)
+	stem := strings.TrimSuffix(path.Base(file.Dst), ext)
+	env := append(os.Environ(), "MERGED="+dest)
+	for name, content := range map[string][]byte{"BASE": m.base, "LOCAL": m.ours, "REMOTE": m.theirs} {
+		p := filepath.Join(tmpDir, stem+"."+name+ext)
+		if err := os.WriteFile(p, content, 0o644); err != nil {
+			return false, fmt.Errorf("write temp file: %w", err)
+		}
+		env = append(env, name+"="+p)
+	}
+
+	command := mergeToolCommand(tool)
+	for {
+		fmt.Printf("Resolving %s with: %s\n", file.Dst, command)
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Env = env
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		runErr := cmd.Run()
+		var exitErr *exec.ExitError
+		if runErr != nil && !errors.As(runErr, &exitErr) {
+			return false, fmt.Errorf("run merge tool: %w", runErr)
+		}
+
+		content, err := os.ReadFile(dest)
+		if err != nil {
+			return false, err
+		}
+		var problem string
+		switch {
+		case runErr != nil:
+			problem = fmt.Sprintf("merge tool failed (%v)", runErr)
+		case hasConflictMarkers(content):
+			problem = "conflict markers remain"
+		default:
+			if err := finishMerge(ctx, root, cfg, file, m.theirs, content); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+
+		fmt.Printf("%s: %s. Try again? [Y/n] ", file.Dst, problem)
+		answer, err := in.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return false, err
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if errors.Is(err, io.EOF) && answer == "" {
+			fmt.Println()
+			return false, nil
+		}
+		if answer == "n" || answer == "no" {
+			return false, nil
+		}
+	}
+}
+
+// hasConflictMarkers reports whether content still has a line opening or
+// closing a conflict written by git merge-file.
+func hasConflictMarkers(content []byte) bool {
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("<<<<<<< ")) || bytes.HasPrefix(line, []byte(">>>>>>> ")) {
+			return true
+		}
+	}
+	return false
+}
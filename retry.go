@@ -0,0 +1,118 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRetries is how many times a request that failed transiently is
+// retried when SyncOptions.Retries is zero.
+const DefaultRetries = 3
+
+// DefaultRetryDelay is the backoff before the first retry when
+// SyncOptions.RetryDelay is zero. It doubles after every attempt.
+const DefaultRetryDelay = 500 * time.Millisecond
+
+// maxRetryAfter caps how long a server's Retry-After may make us wait; a
+// longer one is returned to the caller instead of slept through.
+const maxRetryAfter = time.Minute
+
+// retryTransport retries GET and HEAD requests that failed transiently (a
+// network error, 429, or a 5xx gateway error) with exponential backoff and
+// jitter, honoring Retry-After. Other methods are sent once, since their
+// bodies cannot be replayed.
+type retryTransport struct {
+	next    http.RoundTripper
+	retries int
+	delay   time.Duration
+	logf    func(format string, args ...any)
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.next.RoundTrip(req)
+	}
+	delay := t.delay
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt == t.retries || req.Context().Err() != nil || !isTransient(resp, err) {
+			return resp, err
+		}
+
+		// Equal jitter: half the backoff is fixed, half random, so
+		// parallel workers hitting the same outage spread out.
+		wait := delay/2 + rand.N(delay/2+1)
+		reason := "network error"
+		if resp != nil {
+			reason = resp.Status
+			if after, ok := retryAfter(resp); ok {
+				if after > maxRetryAfter {
+					return resp, nil
+				}
+				wait = max(wait, after)
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+		}
+		t.logf("   retrying %s in %v (%s)\n", req.URL.Redacted(), wait.Round(time.Millisecond), reason)
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		delay *= 2
+	}
+}
+
+// isTransient reports whether a request that produced resp or err is worth
+// retrying as is.
+func isTransient(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrInvalidConfig)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses resp's Retry-After header, in seconds or as a date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// retryPolicy returns the retry count and first backoff o asks for.
+func (o *SyncOptions) retryPolicy() (retries int, delay time.Duration) {
+	retries, delay = DefaultRetries, DefaultRetryDelay
+	if o == nil {
+		return retries, delay
+	}
+	switch {
+	case o.Retries < 0:
+		retries = 0
+	case o.Retries > 0:
+		retries = o.Retries
+	}
+	if o.RetryDelay > 0 {
+		delay = o.RetryDelay
+	}
+	return retries, delay
+}
@@ -0,0 +1,79 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSyncRetriesTransientFailures(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch requests.Add(1) {
+		case 1:
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		default:
+			w.Write([]byte("ok\n"))
+		}
+	}))
+	t.Cleanup(server.Close)
+	dir := t.TempDir()
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a.js"}}})
+
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, RetryDelay: time.Millisecond, Retries: 1}); err == nil {
+		t.Fatal("Sync with one retry: expected the second failure to surface")
+	}
+	requests.Store(0)
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, RetryDelay: time.Millisecond}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("requests = %d, want two retries", got)
+	}
+	requests.Store(0)
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Retries: -1, Force: true}); err == nil || requests.Load() != 1 {
+		t.Errorf("Sync with retries disabled = %v after %d requests, want the first failure", err, requests.Load())
+	}
+}
+
+func TestSyncContinueSkipsCompletedFiles(t *testing.T) {
+	content := map[string]string{"/c1/a.js": "a\n"}
+	server, dir, requestCount := newFixture(t, content)
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a.js"}, {Src: "b.js"}}})
+	opts := &SyncOptions{BaseURL: server.URL, APIURL: server.URL, Jobs: 1}
+
+	if err := Sync(context.Background(), configPath, opts); err == nil {
+		t.Fatal("Sync: expected b.js to fail")
+	}
+	if _, err := os.Stat(lockPath(configPath)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a failed sync wrote wpt.lock: %v", err)
+	}
+
+	content["/c1/b.js"] = "b\n"
+	before := requestCount()
+	opts.Continue = true
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync -continue: %v", err)
+	}
+	if got := requestCount() - before; got != 1 {
+		t.Errorf("Sync -continue made %d requests, want only b.js fetched", got)
+	}
+	if _, err := os.Stat(progressPath(lockPath(configPath))); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("progress journal survived a complete sync: %v", err)
+	}
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "b.js")); string(got) != "b\n" {
+		t.Errorf("b.js = %q", got)
+	}
+}
@@ -0,0 +1,201 @@
+package wptsync
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// RunnerConfig describes the synced tests for a harness integration: what
+// each test needs to run, taken from its "// META:" directives, so that
+// integrations don't each parse them.
+type RunnerConfig struct {
+	// Commit is the pinned WPT commit the tests come from.
+	Commit string `json:"commit"`
+	// TargetDir is the directory Dst paths are relative to.
+	TargetDir string      `json:"target_dir"`
+	Tests     []TestEntry `json:"tests"`
+}
+
+// TestEntry is one test in a RunnerConfig.
+type TestEntry struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+	// Title is the META title, if any.
+	Title string `json:"title,omitempty"`
+	// Timeout is "long" or "normal".
+	Timeout string `json:"timeout"`
+	// Globals lists the scopes the test runs in (window,
+	// dedicatedworker, ...), from META global or the file name.
+	Globals []string `json:"globals,omitempty"`
+	// Variants lists the query strings the test runs with, in order.
+	Variants []string `json:"variants,omitempty"`
+	// VariantDsts names a file for each of Variants, in order, when the
+	// configuration has VariantNames.
+	VariantDsts []string `json:"variant_dsts,omitempty"`
+	// Scripts lists the META script dependencies, in load order.
+	Scripts []ScriptDependency `json:"scripts,omitempty"`
+}
+
+// ScriptDependency is a script a test loads before running.
+type ScriptDependency struct {
+	// Src is the script's path in the WPT repository.
+	Src string `json:"src"`
+	// Dst is where it was synced, or "" if the configuration does not
+	// track it.
+	Dst string `json:"dst,omitempty"`
+}
+
+// testSuffixes maps the file name suffixes WPT generates test wrappers for
+// to the globals they run in when no META global says otherwise.
+var testSuffixes = []struct {
+	suffix  string
+	globals []string
+}{
+	{".any.js", []string{"window", "dedicatedworker"}},
+	{".window.js", []string{"window"}},
+	{".worker.js", []string{"dedicatedworker"}},
+}
+
+// GenerateRunnerConfig reads the "// META:" directives of every enabled,
+// synced test in the configuration at configPath. Tests are .any.js,
+// .window.js, and .worker.js files, plus any other file carrying META
+// directives. It reads the files under target_dir, so run it after a sync.
+func GenerateRunnerConfig(configPath string) (*RunnerConfig, error) {
+	root, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return nil, fmt.Errorf("determine repo root from config: %w", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		return nil, err
+	}
+	cfg = expandGlobsFromLock(cfg, lock)
+
+	dsts := make(map[string]string, len(cfg.Files))
+	for _, f := range cfg.Files {
+		if f.IsEnabled() {
+			dsts[strings.TrimLeft(f.Src, "/")] = f.Dst
+		}
+	}
+
+	rc := &RunnerConfig{Commit: cfg.Commit, TargetDir: cfg.TargetDir, Tests: []TestEntry{}}
+	for _, f := range cfg.Files {
+		if !f.IsEnabled() || isGlob(f.Src) {
+			continue
+		}
+		src := strings.TrimLeft(f.Src, "/")
+		var defaultGlobals []string
+		isTest := false
+		for _, s := range testSuffixes {
+			if strings.HasSuffix(src, s.suffix) {
+				defaultGlobals, isTest = s.globals, true
+				break
+			}
+		}
+		if !isTest && path.Ext(src) != ".js" {
+			continue
+		}
+
+		dest := filepath.Join(root, cfg.TargetDir, filepath.FromSlash(f.Dst))
+		meta, err := readMeta(dest)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s not found on disk; run `wptsync sync` first", dest)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", f.Dst, err)
+		}
+		if !isTest && len(meta) == 0 {
+			continue
+		}
+
+		test := TestEntry{Src: src, Dst: f.Dst, Timeout: "normal", Globals: defaultGlobals}
+		for _, m := range meta {
+			switch m.key {
+			case "title":
+				test.Title = m.value
+			case "timeout":
+				test.Timeout = m.value
+			case "global":
+				test.Globals = nil
+				for _, g := range strings.Split(m.value, ",") {
+					if g = strings.TrimSpace(g); g != "" {
+						test.Globals = append(test.Globals, g)
+					}
+				}
+			case "variant":
+				test.Variants = append(test.Variants, m.value)
+			case "script":
+				dep := scriptSrc(src, m.value)
+				test.Scripts = append(test.Scripts, ScriptDependency{Src: dep, Dst: dsts[dep]})
+			}
+		}
+		// The entry's own scopes and variants win over the file's META.
+		if len(f.Scopes) > 0 {
+			test.Globals = f.Scopes
+		}
+		if len(f.Variants) > 0 {
+			test.Variants = f.Variants
+		}
+		if cfg.VariantNames != nil && len(test.Variants) > 0 {
+			test.VariantDsts = cfg.VariantNames.dsts(test.Dst, test.Variants)
+		}
+		rc.Tests = append(rc.Tests, test)
+	}
+	return rc, nil
+}
+
+// metaDirective is one "// META: key=value" line.
+type metaDirective struct {
+	key, value string
+}
+
+// readMeta returns the META directives in the leading comment block of the
+// file at p, which may also hold a license header.
+func readMeta(p string) ([]metaDirective, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var meta []metaDirective
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		rest, ok := strings.CutPrefix(line, "// META:")
+		if !ok {
+			continue
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(rest), "=")
+		meta = append(meta, metaDirective{key: strings.TrimSpace(key), value: strings.TrimSpace(value)})
+	}
+	return meta, scanner.Err()
+}
+
+// scriptSrc resolves a META script URL against the test at src: absolute
+// URLs are relative to the repository root, others to the test's folder.
+func scriptSrc(src, script string) string {
+	script, _, _ = strings.Cut(script, "?")
+	if strings.HasPrefix(script, "/") {
+		return strings.TrimPrefix(path.Clean(script), "/")
+	}
+	return path.Join(path.Dir(src), script)
+}
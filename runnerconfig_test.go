@@ -0,0 +1,138 @@
+package wptsync
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateRunnerConfig(t *testing.T) {
+	content := map[string]string{
+		"/c1/url/a.any.js": "// META: title=URL parsing\n// META: global=window,worker\n// META: timeout=long\n" +
+			"// META: script=/common/subset-tests.js\n// META: script=resources/helper.js\n" +
+			"// META: variant=?1-100\n// META: variant=?101-last\n\ntest(() => {});\n",
+		"/c1/url/b.window.js":         "test(() => {});\n",
+		"/c1/url/resources/helper.js": "// not a test\nfunction helper() {}\n",
+	}
+	server, dir, _ := newFixture(t, content)
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:         "c1",
+		TargetDir:      "wpt",
+		LicenseHeaders: map[string]string{".js": "// Vendored from {{.Src}}"},
+		Files: []FileSpec{
+			{Src: "url/a.any.js", Dst: "url/a.js"},
+			{Src: "url/b.window.js"},
+			{Src: "url/resources/helper.js"},
+		},
+	})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	rc, err := GenerateRunnerConfig(configPath)
+	if err != nil {
+		t.Fatalf("GenerateRunnerConfig: %v", err)
+	}
+	want := []TestEntry{
+		{
+			Src: "url/a.any.js", Dst: "url/a.js", Title: "URL parsing", Timeout: "long",
+			Globals:  []string{"window", "worker"},
+			Variants: []string{"?1-100", "?101-last"},
+			Scripts: []ScriptDependency{
+				{Src: "common/subset-tests.js"},
+				{Src: "url/resources/helper.js", Dst: "url/resources/helper.js"},
+			},
+		},
+		{Src: "url/b.window.js", Dst: "url/b.window.js", Timeout: "normal", Globals: []string{"window"}},
+	}
+	if !reflect.DeepEqual(rc.Tests, want) {
+		t.Errorf("tests =\n%+v\nwant\n%+v", rc.Tests, want)
+	}
+}
+
+func TestRunnerConfigUsesEntryScopesAndVariants(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/url/a.any.js": "// META: global=window,worker\n// META: variant=?1-100\n\ntest(() => {});\n",
+	})
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files: []FileSpec{
+			{Src: "url/a.any.js", Dst: "url/a.window.js", Scopes: []string{"window"}, Variants: []string{"?1-10", "?11-last"}},
+			{Src: "url/a.any.js", Dst: "url/a.worker.js", Scopes: []string{"dedicatedworker"}},
+		},
+	})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	rc, err := GenerateRunnerConfig(configPath)
+	if err != nil {
+		t.Fatalf("GenerateRunnerConfig: %v", err)
+	}
+	want := []TestEntry{
+		{Src: "url/a.any.js", Dst: "url/a.window.js", Timeout: "normal", Globals: []string{"window"}, Variants: []string{"?1-10", "?11-last"}},
+		{Src: "url/a.any.js", Dst: "url/a.worker.js", Timeout: "normal", Globals: []string{"dedicatedworker"}, Variants: []string{"?1-100"}},
+	}
+	if !reflect.DeepEqual(rc.Tests, want) {
+		t.Errorf("tests =\n%+v\nwant\n%+v", rc.Tests, want)
+	}
+
+	cfg, _ := LoadConfig(configPath)
+	cfg.Files[1].Variants = []string{"1-10"}
+	if err := cfg.validate(); err == nil {
+		t.Error("validate accepted a variant without a leading ? or #")
+	}
+}
+
+func TestRunnerConfigNamesVariants(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/url/urlencoded.any.js": "// META: variant=?exclude=x\n// META: variant=?exclude=(file|javascript)\n\ntest(() => {});\n",
+	})
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:       "c1",
+		TargetDir:    "wpt",
+		Files:        []FileSpec{{Src: "url/urlencoded.any.js", Dst: "url/urlencoded.js"}},
+		VariantNames: &VariantNames{},
+	})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	rc, err := GenerateRunnerConfig(configPath)
+	if err != nil {
+		t.Fatalf("GenerateRunnerConfig: %v", err)
+	}
+	want := []string{"url/urlencoded.exclude-x.js", "url/urlencoded.exclude-file-javascript.js"}
+	if len(rc.Tests) != 1 || !reflect.DeepEqual(rc.Tests[0].VariantDsts, want) {
+		t.Errorf("tests = %+v, want variant dsts %q", rc.Tests, want)
+	}
+}
+
+func TestVariantNames(t *testing.T) {
+	variants := []string{"?a=b", "?a-b", "?A-B", "?", "#x:y"}
+	for _, tt := range []struct {
+		names *VariantNames
+		want  []string
+	}{
+		{&VariantNames{}, []string{"t.a-b.js", "t.a-b-" + variantHash("?a-b") + ".js", "t.A-B-" + variantHash("?A-B") + ".js", "t." + variantHash("?") + ".js", "t.x-y.js"}},
+		{&VariantNames{Scheme: VariantSchemeWindows, Separator: "_"}, []string{"t_a=b.js", "t_a-b.js", "t_A-B-" + variantHash("?A-B") + ".js", "t_" + variantHash("?") + ".js", "t_x-y.js"}},
+		{&VariantNames{Scheme: VariantSchemePOSIX}, []string{"t.a=b.js", "t.a-b.js", "t.A-B-" + variantHash("?A-B") + ".js", "t." + variantHash("?") + ".js", "t.x:y.js"}},
+	} {
+		if err := tt.names.check(); err != nil {
+			t.Fatalf("check(%+v) = %v", tt.names, err)
+		}
+		if got := tt.names.dsts("t.js", variants); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dsts with %+v = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+
+	long := &VariantNames{MaxLength: 20}
+	got := long.dsts("t.js", []string{"?include=file&exclude=javascript"})
+	if want := "t.include-fil-" + variantHash("?include=file&exclude=javascript") + ".js"; got[0] != want {
+		t.Errorf("dsts with max_length 20 = %q, want %q", got[0], want)
+	}
+	for _, bad := range []*VariantNames{{Scheme: "dos"}, {Separator: "?"}, {MaxLength: 8}} {
+		if err := bad.check(); err == nil {
+			t.Errorf("check(%+v) accepted it", bad)
+		}
+	}
+}
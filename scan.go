@@ -0,0 +1,341 @@
+package wptsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ErrScanFindings marks a scan that found files violating the
+// configuration's scan policy (see Config.Scan).
+var ErrScanFindings = errors.New("scan found policy violations")
+
+// ScanPolicy is what the scan of synced files looks for (see Config.Scan):
+// license headers the project cannot take, credentials, and URLs of hosts
+// that must not appear. Sync and Update scan the files they write; Scan
+// checks the whole synced tree.
+type ScanPolicy struct {
+	// Strict makes findings fail Sync and Update with an error wrapping
+	// ErrScanFindings, and leaves the flagged files out of the lock file so
+	// that verify fails until they are dealt with. Otherwise findings are
+	// only warnings.
+	Strict bool `json:"strict,omitempty"`
+	// DenyLicenses lists the licenses synced files must not declare, as
+	// SPDX identifiers. "GPL" covers every GPL version ("GPL-2.0-only",
+	// "GPL-3.0-or-later", ...) but not LGPL. Licenses are recognized in
+	// SPDX-License-Identifier tags, and by the usual wording of their
+	// headers near the top of a file, which names no version, so that
+	// denying any GPL version flags every GPL header.
+	DenyLicenses []string `json:"deny_licenses,omitempty"`
+	// Secrets, unless explicitly false, flags credentials: private keys,
+	// and the tokens of GitHub, AWS, Slack, and Google APIs.
+	Secrets *bool `json:"secrets,omitempty"`
+	// DenyURLs lists host patterns (path.Match syntax, such as
+	// "*.corp.example.com") that URLs in synced files must not point to.
+	DenyURLs []string `json:"deny_urls,omitempty"`
+	// Patterns are rules of the project's own: each name maps to a
+	// regular expression flagging the lines it matches.
+	Patterns map[string]string `json:"patterns,omitempty"`
+	// Allow exempts the files whose dst matches one of these patterns, as
+	// -only matches them ("tools/certs/**"), such as the test keys and
+	// certificates WPT ships.
+	Allow []string `json:"allow,omitempty"`
+}
+
+// ScanFinding is a line of a synced file that violates the scan policy.
+type ScanFinding struct {
+	Dst  string `json:"dst"`
+	Line int    `json:"line"`
+	// Rule names what the line violates, such as "license GPL-3.0-only",
+	// "GitHub token", or "URL to internal.example.com".
+	Rule string `json:"rule"`
+	// Match is the offending text; credentials are cut short, so that the
+	// report does not spread them further.
+	Match string `json:"match"`
+}
+
+func (f ScanFinding) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", f.Dst, f.Line, f.Rule, f.Match)
+}
+
+// scanRule flags the matches of re on a line, named name. Secret rules
+// redact what they match.
+type scanRule struct {
+	name   string
+	re     *regexp.Regexp
+	secret bool
+}
+
+// secretRules are the credentials ScanPolicy.Secrets flags. They are kept to
+// formats specific enough not to trip on test data.
+var secretRules = []scanRule{
+	{"private key", regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----`), false},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{60,})\b`), true},
+	{"AWS access key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), true},
+	{"Slack token", regexp.MustCompile(`\bxox[abeoprs]-[A-Za-z0-9-]{10,}`), true},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`), true},
+}
+
+var (
+	spdxTag = regexp.MustCompile(`SPDX-License-Identifier:\s*([^*\n]+)`)
+	urlHost = regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://(?:[^/\s@"'<>]*@)?([a-zA-Z0-9.-]+)`)
+)
+
+// licenseWording recognizes license headers by their wording, each as the
+// SPDX family it belongs to. Longer names come first, so that an LGPL
+// header is not taken for a GPL one.
+var licenseWording = []struct {
+	phrase, family string
+}{
+	{"GNU AFFERO GENERAL PUBLIC LICENSE", "AGPL"},
+	{"GNU LESSER GENERAL PUBLIC LICENSE", "LGPL"},
+	{"GNU LIBRARY GENERAL PUBLIC LICENSE", "LGPL"},
+	{"GNU GENERAL PUBLIC LICENSE", "GPL"},
+	{"MOZILLA PUBLIC LICENSE", "MPL"},
+	{"EUROPEAN UNION PUBLIC LICEN", "EUPL"},
+	{"CREATIVE COMMONS ATTRIBUTION-SHAREALIKE", "CC-BY-SA"},
+	{"CREATIVE COMMONS ATTRIBUTION-NONCOMMERCIAL", "CC-BY-NC"},
+}
+
+// licenseHeaderLines is how far into a file license wording is looked for:
+// a header sits at the top, and wording further down is usually quoted.
+const licenseHeaderLines = 50
+
+// scanner is a compiled ScanPolicy.
+type scanner struct {
+	policy ScanPolicy
+	rules  []scanRule
+}
+
+// compile checks p and compiles its rules.
+func (p ScanPolicy) compile() (*scanner, error) {
+	s := &scanner{policy: p}
+	if p.Secrets == nil || *p.Secrets {
+		s.rules = append(s.rules, secretRules...)
+	}
+	names := make([]string, 0, len(p.Patterns))
+	for name := range p.Patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		re, err := regexp.Compile(p.Patterns[name])
+		if err != nil {
+			return nil, fmt.Errorf("scan: pattern %q: %w", name, err)
+		}
+		s.rules = append(s.rules, scanRule{name: name, re: re})
+	}
+	for _, host := range p.DenyURLs {
+		if _, err := path.Match(host, ""); err != nil {
+			return nil, fmt.Errorf("scan: deny_urls pattern %q: %w", host, err)
+		}
+	}
+	for _, id := range p.DenyLicenses {
+		if id == "" || strings.ContainsAny(id, " \t()") {
+			return nil, fmt.Errorf("scan: deny_licenses entry %q must be a single SPDX identifier", id)
+		}
+	}
+	return s, nil
+}
+
+// allows reports whether the policy exempts the file at dst.
+func (s *scanner) allows(dst string) bool {
+	return slices.ContainsFunc(s.policy.Allow, func(pattern string) bool { return matchFilter(pattern, dst) })
+}
+
+// deniedLicense returns the entry of DenyLicenses that id, an SPDX
+// identifier or a family of them, falls under, if any.
+func (s *scanner) deniedLicense(id string, family bool) (string, bool) {
+	for _, deny := range s.policy.DenyLicenses {
+		switch {
+		case strings.EqualFold(id, deny), hasLicensePrefix(id, deny):
+			return deny, true
+		case family && hasLicensePrefix(deny, id):
+			return deny, true
+		}
+	}
+	return "", false
+}
+
+// hasLicensePrefix reports whether the SPDX identifier id is a version of
+// the license family, such as GPL-3.0-only or GPL-2.0+ of GPL.
+func hasLicensePrefix(id, family string) bool {
+	if len(id) <= len(family) || !strings.EqualFold(id[:len(family)], family) {
+		return false
+	}
+	return id[len(family)] == '-' || id[len(family)] == '+'
+}
+
+// scanFile scans the file at p, synced to dst, and returns its findings.
+// Binary files are skipped.
+func (s *scanner) scanFile(p, dst string) ([]ScanFinding, error) {
+	content, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	if looksBinary(content) {
+		return nil, nil
+	}
+
+	var findings []ScanFinding
+	add := func(line int, rule, match string) {
+		findings = append(findings, ScanFinding{Dst: dst, Line: line, Rule: rule, Match: match})
+	}
+	lines := bufio.NewScanner(bytes.NewReader(content))
+	lines.Buffer(nil, len(content)+1)
+	for n := 1; lines.Scan(); n++ {
+		line := lines.Text()
+		if len(s.policy.DenyLicenses) > 0 {
+			if m := spdxTag.FindStringSubmatch(line); m != nil {
+				for _, id := range strings.FieldsFunc(m[1], func(r rune) bool { return r == ' ' || r == '(' || r == ')' }) {
+					if deny, ok := s.deniedLicense(id, false); ok {
+						add(n, "license "+deny, "SPDX-License-Identifier: "+strings.TrimSpace(m[1]))
+					}
+				}
+			}
+			if n <= licenseHeaderLines {
+				upper := strings.ToUpper(line)
+				for _, w := range licenseWording {
+					if strings.Contains(upper, w.phrase) {
+						if deny, ok := s.deniedLicense(w.family, true); ok {
+							add(n, "license "+deny, strings.TrimSpace(line))
+						}
+						break
+					}
+				}
+			}
+		}
+		for _, r := range s.rules {
+			for _, m := range r.re.FindAllString(line, -1) {
+				if r.secret {
+					m = redact(m)
+				}
+				add(n, r.name, m)
+			}
+		}
+		if len(s.policy.DenyURLs) > 0 {
+			for _, m := range urlHost.FindAllStringSubmatch(line, -1) {
+				host := strings.ToLower(strings.TrimSuffix(m[1], "."))
+				for _, pattern := range s.policy.DenyURLs {
+					if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+						add(n, "URL to "+host, m[0])
+						break
+					}
+				}
+			}
+		}
+	}
+	return findings, lines.Err()
+}
+
+// redact keeps the start of a credential, enough to recognize it by.
+func redact(secret string) string {
+	if len(secret) <= 8 {
+		return "…"
+	}
+	return secret[:8] + "…"
+}
+
+// scanFiles scans the files of cfg that are on disk per its scan policy.
+func scanFiles(root string, cfg *Config, files []FileSpec) ([]ScanFinding, error) {
+	s, err := cfg.Scan.compile()
+	if err != nil {
+		return nil, invalidConfig(fmt.Errorf("config: %w", err))
+	}
+	var findings []ScanFinding
+	for _, file := range files {
+		if s.allows(file.Dst) {
+			continue
+		}
+		found, err := s.scanFile(filepath.Join(root, cfg.TargetDir, filepath.FromSlash(file.Dst)), file.Dst)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("scan %s: %w", file.Dst, err)
+		}
+		findings = append(findings, found...)
+	}
+	return findings, nil
+}
+
+// scanSynced scans files, just written by a sync of cfg, when cfg has a scan
+// policy, and reports the findings. In strict mode, set by the policy or
+// by o.Strict, it returns the dsts of the flagged files and an error
+// wrapping ErrScanFindings.
+func (o *SyncOptions) scanSynced(root string, cfg *Config, files []FileSpec) (map[string]bool, error) {
+	if cfg.Scan == nil || len(files) == 0 {
+		return nil, nil
+	}
+	findings, err := scanFiles(root, cfg, files)
+	if err != nil || len(findings) == 0 {
+		return nil, err
+	}
+	if !cfg.Scan.Strict && (o == nil || !o.Strict) {
+		for _, f := range findings {
+			o.logf("warning: scan: %s\n", f)
+		}
+		return nil, nil
+	}
+	flagged := make(map[string]bool)
+	for _, f := range findings {
+		o.logf(" ! %s\n", f)
+		flagged[f.Dst] = true
+	}
+	return flagged, fmt.Errorf("%w: %d file(s) flagged in strict mode and left out of the lock file; fix their entries, or exempt them with \"allow\"", ErrScanFindings, len(flagged))
+}
+
+// Scan checks every enabled file the last sync of the configuration at
+// configPath wrote against its scan policy, without touching the network,
+// for use before committing the synced tree. It reports each finding and
+// returns an error wrapping ErrScanFindings if there are any, whether or
+// not the policy is strict.
+func Scan(ctx context.Context, configPath string, opts *SyncOptions) error {
+	root, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return fmt.Errorf("determine repo root from config: %w", err)
+	}
+	cfg, lockName, err := opts.loadGroup(configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.Scan == nil {
+		return invalidConfig(errors.New("config: no scan policy; add a \"scan\" object to the configuration"))
+	}
+	lock, err := loadLock(lockName)
+	if err != nil {
+		return err
+	}
+	cfg = expandGlobsFromLock(cfg, lock)
+
+	var files []FileSpec
+	for _, file := range cfg.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if file.IsEnabled() && !isGlob(file.Src) {
+			files = append(files, file)
+		}
+	}
+	findings, err := scanFiles(root, cfg, files)
+	if err != nil {
+		return err
+	}
+	if len(findings) > 0 {
+		for _, f := range findings {
+			opts.logf(" ! %s\n", f)
+		}
+		return fmt.Errorf("%w: %d finding(s)", ErrScanFindings, len(findings))
+	}
+	opts.logf("Scanned %d file(s): no findings\n", len(files))
+	return nil
+}
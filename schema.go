@@ -0,0 +1,93 @@
+package wptsync
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// schemaURI is the JSON Schema dialect Schema emits.
+const schemaURI = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchemaer is implemented by config types whose JSON form doesn't follow
+// from their Go kind (for example, a duration encoded as a string).
+type jsonSchemaer interface {
+	jsonSchema() map[string]any
+}
+
+// Schema returns a JSON Schema describing the configuration file format.
+// It is derived from the Config struct by reflection, so it always matches
+// what LoadConfig accepts. Point an editor at it (or add a "$schema" key to
+// wpt.json) to get completion and validation.
+func Schema() ([]byte, error) {
+	s := schemaFor(reflect.TypeFor[Config]())
+	s["$schema"] = schemaURI
+	s["title"] = "wptsync configuration"
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func schemaFor(t reflect.Type) map[string]any {
+	if s, ok := reflect.Zero(t).Interface().(jsonSchemaer); ok {
+		return s.jsonSchema()
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		var required []string
+		addStructFields(t, props, &required)
+		s := map[string]any{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	return map[string]any{}
+}
+
+// addStructFields adds the JSON properties of struct type t to props,
+// flattening embedded structs the way encoding/json does. Fields tagged
+// `wptsync:"required"` are listed in required.
+func addStructFields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addStructFields(f.Type, props, required)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaFor(f.Type)
+		if f.Tag.Get("wptsync") == "required" {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package wptsync
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestSchemaCoversConfig(t *testing.T) {
+	data, err := Schema()
+	if err != nil {
+		t.Fatalf("Schema: %v", err)
+	}
+
+	var s struct {
+		Schema               string         `json:"$schema"`
+		Properties           map[string]any `json:"properties"`
+		Required             []string       `json:"required"`
+		AdditionalProperties bool           `json:"additionalProperties"`
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatalf("decode schema: %v", err)
+	}
+	if s.Schema != schemaURI {
+		t.Errorf("$schema = %q, want %q", s.Schema, schemaURI)
+	}
+	if s.AdditionalProperties {
+		t.Error("top-level schema should reject unknown keys")
+	}
+	for _, want := range []string{"commit", "target_dir"} {
+		found := false
+		for _, r := range s.Required {
+			found = found || r == want
+		}
+		if !found {
+			t.Errorf("required = %v, missing %q", s.Required, want)
+		}
+	}
+
+	// Every key used by the example config must be described.
+	example, err := os.ReadFile("examples/wpt.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg map[string]json.RawMessage
+	if err := json.Unmarshal(example, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	for key := range cfg {
+		if _, ok := s.Properties[key]; !ok {
+			t.Errorf("schema has no property for config key %q", key)
+		}
+	}
+
+	files := s.Properties["files"].(map[string]any)
+	items := files["items"].(map[string]any)
+	props := items["properties"].(map[string]any)
+	for _, key := range []string{"src", "dst", "enabled", "patch"} {
+		if _, ok := props[key]; !ok {
+			t.Errorf("file entry schema has no property %q", key)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package wptsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// StateFileName is the file, next to target_dir, recording when each file
+// was last synced, and what from. Unlike the lock file, it is local to a
+// checkout: it changes on every sync, so it belongs in .gitignore.
+const StateFileName = ".wptsync-state.json"
+
+// syncState is the content of the state file.
+type syncState struct {
+	// Files holds an entry per synced file, keyed by its path from the
+	// state file's directory (wpt/url/a.js), so that configurations whose
+	// target directories are side by side can share one.
+	Files map[string]stateEntry `json:"files"`
+}
+
+// stateEntry records the last time a file was synced.
+type stateEntry struct {
+	Src    string `json:"src"`
+	Commit string `json:"commit"`
+	// SHA256 is the hash of the file as synced, after its patch, header,
+	// and post_file hooks.
+	SHA256   string    `json:"sha256"`
+	SyncedAt time.Time `json:"synced_at,omitzero"`
+	// Size and ModTime are those of the file once synced, which
+	// SyncOptions.Quick compares to tell whether it may have changed.
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mtime,omitzero"`
+}
+
+// statePath returns where the state file of cfg's target directory is.
+func statePath(root string, cfg *Config) string {
+	return filepath.Join(root, filepath.Dir(filepath.Clean(filepath.FromSlash(cfg.TargetDir))), StateFileName)
+}
+
+// stateKey returns the key of dst in the state file of cfg.
+func stateKey(cfg *Config, dst string) string {
+	return path.Join(path.Base(path.Clean(filepath.ToSlash(cfg.TargetDir))), dst)
+}
+
+// loadState reads the state file of cfg's target directory. A missing file
+// yields an empty state.
+func loadState(root string, cfg *Config) (*syncState, error) {
+	p := statePath(root, cfg)
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return &syncState{Files: map[string]stateEntry{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state %q: %w", p, err)
+	}
+	if data, err = decodeState(data); err != nil {
+		return nil, fmt.Errorf("read state %q: %w; delete it to start over", p, err)
+	}
+	var s syncState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("decode state %q: %w; delete it to start over", p, err)
+	}
+	if s.Files == nil {
+		s.Files = map[string]stateEntry{}
+	}
+	return &s, nil
+}
+
+// saveState writes s as the state file of cfg's target directory, framed
+// (see encodeState) and, with compress_state, compressed.
+func saveState(root string, cfg *Config, s *syncState) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+	if data, err = encodeState(append(data, '\n'), cfg.CompressState); err != nil {
+		return fmt.Errorf("encode state: %w", err)
+	}
+	if err := writeFileAtomic(statePath(root, cfg), data, 0o644); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	return nil
+}
+
+// entry returns the state entry of dst.
+func (s *syncState) entry(cfg *Config, dst string) (stateEntry, bool) {
+	e, ok := s.Files[stateKey(cfg, dst)]
+	return e, ok
+}
+
+// recordSynced updates the state file of cfg's target directory: the files
+// of written were synced now, as lock records them, and the files removed
+// are gone. Failing to record the state only warns, as the files and the
+// lock are already in place.
+func recordSynced(root string, cfg *Config, lock *lockFile, written []FileSpec, removed []string, logf func(format string, args ...any)) {
+	s, err := loadState(root, cfg)
+	if err != nil {
+		logf("warning: %v\n", err)
+		return
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, file := range written {
+		entry, ok := lock.Files[file.Dst]
+		if !ok {
+			continue
+		}
+		e := stateEntry{Src: file.Src, Commit: cfg.commitOf(file), SHA256: entry.SHA256, SyncedAt: now}
+		if fi, err := os.Stat(filepath.Join(root, cfg.TargetDir, filepath.FromSlash(file.Dst))); err == nil {
+			e.Size, e.ModTime = fi.Size(), fi.ModTime().UTC()
+		}
+		s.Files[stateKey(cfg, file.Dst)] = e
+	}
+	for _, dst := range removed {
+		delete(s.Files, stateKey(cfg, dst))
+	}
+	if err := saveState(root, cfg, s); err != nil {
+		logf("warning: %v\n", err)
+	}
+}
+
+// warnEdited warns about the files of pending edited since they were last
+// synced, as the state file (or else lock) records them, which syncing them
+// is about to overwrite.
+func warnEdited(root string, cfg *Config, lock *lockFile, pending []FileSpec, dryRun bool, logf func(format string, args ...any)) {
+	s, err := loadState(root, cfg)
+	if err != nil {
+		logf("warning: %v\n", err)
+		s = &syncState{}
+	}
+	for _, file := range pending {
+		want, when := lock.Files[file.Dst].SHA256, ""
+		if e, ok := s.entry(cfg, file.Dst); ok {
+			want = e.SHA256
+			if !e.SyncedAt.IsZero() {
+				when = " on " + e.SyncedAt.Local().Format("2006-01-02 15:04")
+			}
+		}
+		if want == "" {
+			continue
+		}
+		got, err := hashFile(filepath.Join(root, cfg.TargetDir, filepath.FromSlash(file.Dst)))
+		if err != nil || got == want {
+			continue
+		}
+		verb := "overwrites"
+		if dryRun {
+			verb = "would overwrite"
+		}
+		logf("warning: %s was edited since it was synced%s; this sync %s the edits (run `wptsync save %s` first to keep them as a patch)\n", file.Dst, when, verb, file.Dst)
+	}
+}
+
+// localHasher hashes the synced files of a configuration. With a state,
+// it returns the hash the state file recorded for a file whose size and
+// modification time are still those it recorded, without reading it, and
+// hashes the others.
+type localHasher struct {
+	root  string
+	cfg   *Config
+	state *syncState
+}
+
+// newLocalHasher returns the hasher of cfg's files, which trusts the state
+// file when quick is set. A state file that cannot be read only warns, and
+// every file is hashed.
+func newLocalHasher(root string, cfg *Config, quick bool, logf func(format string, args ...any)) *localHasher {
+	h := &localHasher{root: root, cfg: cfg}
+	if !quick {
+		return h
+	}
+	s, err := loadState(root, cfg)
+	if err != nil {
+		logf("warning: %v; hashing every file\n", err)
+		return h
+	}
+	h.state = s
+	return h
+}
+
+// hash returns the SHA-256 of dst under cfg's target directory.
+func (h *localHasher) hash(dst string) (string, error) {
+	p := filepath.Join(h.root, h.cfg.TargetDir, filepath.FromSlash(dst))
+	if h.state != nil {
+		if e, ok := h.state.entry(h.cfg, dst); ok && e.SHA256 != "" && !e.ModTime.IsZero() {
+			fi, err := os.Stat(p)
+			if err != nil {
+				return "", err
+			}
+			if fi.Size() == e.Size && fi.ModTime().Equal(e.ModTime) {
+				return e.SHA256, nil
+			}
+		}
+	}
+	return hashFile(p)
+}
@@ -0,0 +1,146 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncRecordsStateAndWarnsBeforeOverwriting(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/url/a.js": "a\n",
+		"/c1/url/b.js": "b\n",
+	})
+	cfg := &Config{Commit: "c1", TargetDir: "third_party/wpt", Files: []FileSpec{{Src: "url/a.js"}, {Src: "url/b.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+	var logs strings.Builder
+	opts := &SyncOptions{BaseURL: server.URL, Logf: func(format string, args ...any) { fmt.Fprintf(&logs, format, args...) }}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "third_party", StateFileName)); err != nil {
+		t.Fatalf("state file not next to target_dir: %v", err)
+	}
+	state, err := loadState(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := state.Files["wpt/url/a.js"]
+	if !ok || e.Src != "url/a.js" || e.Commit != "c1" || e.SyncedAt.IsZero() {
+		t.Errorf("state entry of url/a.js = %+v, %v", e, ok)
+	}
+
+	a := filepath.Join(dir, "third_party", "wpt", "url", "a.js")
+	if err := os.WriteFile(a, []byte("edited\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	logs.Reset()
+	opts.Force = true
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if !strings.Contains(logs.String(), "warning: url/a.js was edited since it was synced on ") || strings.Contains(logs.String(), "url/b.js was edited") {
+		t.Errorf("log = %q, want a warning about url/a.js only", logs.String())
+	}
+
+	cfg.Files = cfg.Files[:1]
+	saveTestConfig(t, dir, cfg)
+	if _, err := Prune(context.Background(), configPath, &PruneOptions{}); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if state, err = loadState(dir, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := state.Files["wpt/url/b.js"]; ok || len(state.Files) != 1 {
+		t.Errorf("state after prune = %+v, want url/b.js dropped", state.Files)
+	}
+}
+
+func TestStateFileIsFramed(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{Commit: "c1", TargetDir: "wpt"}
+	s := &syncState{Files: map[string]stateEntry{}}
+	for i := range 20 {
+		s.Files[fmt.Sprintf("wpt/url/f%d.js", i)] = stateEntry{Src: fmt.Sprintf("url/f%d.js", i), Commit: "c1", SHA256: strings.Repeat("0", 64)}
+	}
+	for _, tc := range []struct {
+		compress bool
+		codec    byte
+	}{{false, codecNone}, {true, codecGzip}} {
+		cfg.CompressState = tc.compress
+		if err := saveState(dir, cfg, s); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(statePath(dir, cfg))
+		if err != nil || !isFramedState(data) || data[len(stateMagic)+1] != tc.codec {
+			t.Fatalf("compress_state %v: state file not framed with codec %q (%v)", tc.compress, tc.codec, err)
+		}
+		got, err := loadState(dir, cfg)
+		if err != nil {
+			t.Fatalf("compress_state %v: loadState: %v", tc.compress, err)
+		}
+		if len(got.Files) != 20 {
+			t.Errorf("compress_state %v: loadState = %d files, want all 20 back", tc.compress, len(got.Files))
+		}
+
+		data[stateHeader] ^= 0xff
+		if err := os.WriteFile(statePath(dir, cfg), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadState(dir, cfg); !errors.Is(err, ErrCorruptState) {
+			t.Errorf("compress_state %v: loadState of a damaged file = %v, want ErrCorruptState", tc.compress, err)
+		}
+	}
+}
+
+func TestVerifyQuickTrustsUnchangedStat(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, dir, _ := newFixture(t, map[string]string{"/c1/url/a.js": "a\n"})
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "url/a.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// Same size, same modification time: -quick does not read the file.
+	a := filepath.Join(dir, "wpt", "url", "a.js")
+	fi, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(a, []byte("b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(a, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(context.Background(), configPath, &SyncOptions{Quick: true}); err != nil {
+		t.Errorf("Verify -quick = %v, want the recorded hash trusted", err)
+	}
+	if err := Verify(context.Background(), configPath, nil); !errors.Is(err, ErrDrift) {
+		t.Errorf("Verify = %v, want ErrDrift", err)
+	}
+
+	// A new modification time has it hashed.
+	later := fi.ModTime().Add(time.Minute)
+	if err := os.Chtimes(a, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(context.Background(), configPath, &SyncOptions{Quick: true}); !errors.Is(err, ErrDrift) {
+		t.Errorf("Verify -quick after touching = %v, want ErrDrift", err)
+	}
+	report, err := Status(context.Background(), configPath, &SyncOptions{Quick: true, Offline: true})
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(report.Files) != 1 || report.Files[0].Local != LocalModified {
+		t.Errorf("Status -quick = %+v, want url/a.js modified", report.Files)
+	}
+}
@@ -0,0 +1,152 @@
+package wptsync
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// ErrCorruptState marks a state or cache file whose content does not match
+// its integrity footer, such as one cut short by a crash or a full disk.
+var ErrCorruptState = errors.New("corrupt state file")
+
+// The state file (see StateFileName), the download cache and, with
+// compress_state, the lock file and its progress journal, are framed so
+// that corruption is detected and the format can change later:
+//
+//	"WPTS" | version | codec | payload | SHA-256 of the content | content length (8 bytes, big endian) | "WPTS"
+//
+// Files without the frame are read as they are, so state written by older
+// versions keeps working and is converted the next time it is written.
+const (
+	stateMagic   = "WPTS"
+	stateVersion = 1
+	stateHeader  = len(stateMagic) + 2
+	stateFooter  = sha256.Size + 8 + len(stateMagic)
+)
+
+// Codecs of framed state files. They are written with gzip, which runs
+// in-process and gives the same bytes on every machine, so that a committed
+// lock reads anywhere. Zstandard, which the zstd command decompresses, is
+// only read, for state written by earlier versions. Content too small to
+// gain from compression is stored as is.
+const (
+	codecNone = 'n'
+	codecGzip = 'g'
+	codecZstd = 'z'
+)
+
+// minCompressSize is the content size below which compression is skipped.
+const minCompressSize = 512
+
+// zstdCommand is the command Zstandard state is compressed with.
+var zstdCommand = "zstd"
+
+// isFramedState reports whether data starts with the state file frame.
+func isFramedState(data []byte) bool {
+	return len(data) >= stateHeader && string(data[:len(stateMagic)]) == stateMagic
+}
+
+// encodeState frames content, compressing it with gzip when compress is
+// set.
+func encodeState(content []byte, compress bool) ([]byte, error) {
+	codec, payload := byte(codecNone), content
+	if compress && len(content) >= minCompressSize {
+		var err error
+		codec = codecGzip
+		if payload, err = gzipBytes(content); err != nil {
+			return nil, err
+		}
+	}
+	sum := sha256.Sum256(content)
+	out := make([]byte, 0, stateHeader+len(payload)+stateFooter)
+	out = append(out, stateMagic...)
+	out = append(out, stateVersion, codec)
+	out = append(out, payload...)
+	out = append(out, sum[:]...)
+	out = binary.BigEndian.AppendUint64(out, uint64(len(content)))
+	return append(out, stateMagic...), nil
+}
+
+// decodeState returns the content of a framed state file, checked against
+// its footer, or data itself when it is not framed.
+func decodeState(data []byte) ([]byte, error) {
+	if !isFramedState(data) {
+		return data, nil
+	}
+	if len(data) < stateHeader+stateFooter || string(data[len(data)-len(stateMagic):]) != stateMagic {
+		return nil, fmt.Errorf("%w: truncated", ErrCorruptState)
+	}
+	if v := data[len(stateMagic)]; v != stateVersion {
+		return nil, fmt.Errorf("state file version %d is newer than this wptsync understands; upgrade it", v)
+	}
+	codec := data[len(stateMagic)+1]
+	payload := data[stateHeader : len(data)-stateFooter]
+	footer := data[len(data)-stateFooter:]
+
+	var content []byte
+	var err error
+	switch codec {
+	case codecNone:
+		content = payload
+	case codecGzip:
+		content, err = gunzipBytes(payload)
+	case codecZstd:
+		if _, lookErr := exec.LookPath(zstdCommand); lookErr != nil {
+			return nil, errors.New("reading zstd-compressed state requires the zstd command; install it")
+		}
+		content, err = runZstd(payload, "-d", "-q", "-c")
+	default:
+		return nil, fmt.Errorf("%w: unknown codec %q", ErrCorruptState, codec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptState, err)
+	}
+	sum := sha256.Sum256(content)
+	if uint64(len(content)) != binary.BigEndian.Uint64(footer[sha256.Size:]) || !bytes.Equal(sum[:], footer[:sha256.Size]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorruptState)
+	}
+	return content, nil
+}
+
+// runZstd pipes data through the zstd command run with args.
+func runZstd(data []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command(zstdCommand, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return nil, fmt.Errorf("run zstd: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("run zstd: %w", err)
+	}
+	return out, nil
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipBytes(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
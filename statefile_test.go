@@ -0,0 +1,136 @@
+package wptsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	large := []byte(strings.Repeat(`"url/a.js": {"sha256": "0123456789abcdef"},`+"\n", 100))
+	framings := map[string]func() ([]byte, error){"gzip": func() ([]byte, error) { return encodeState(large, true) }}
+	if _, err := exec.LookPath("zstd"); err == nil {
+		// Earlier versions wrote Zstandard, which is still read.
+		framings["zstd"] = func() ([]byte, error) { return frameZstd(large) }
+	}
+	for name, frame := range framings {
+		t.Run(name, func(t *testing.T) {
+			framed, err := frame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(framed) >= len(large) {
+				t.Errorf("%d bytes from %d, want them smaller", len(framed), len(large))
+			}
+			got, err := decodeState(framed)
+			if err != nil || !bytes.Equal(got, large) {
+				t.Fatalf("decodeState = %d bytes, %v, want the content back", len(got), err)
+			}
+
+			corrupt := bytes.Clone(framed)
+			corrupt[stateHeader+len(corrupt[stateHeader:])/3] ^= 0xff
+			if _, err := decodeState(corrupt); !errors.Is(err, ErrCorruptState) {
+				t.Errorf("decodeState(corrupted) = %v, want ErrCorruptState", err)
+			}
+			if _, err := decodeState(framed[:len(framed)-10]); !errors.Is(err, ErrCorruptState) {
+				t.Errorf("decodeState(truncated) = %v, want ErrCorruptState", err)
+			}
+		})
+	}
+
+	// The same content always encodes to the same bytes, with gzip, whether
+	// or not zstd is installed.
+	first, err := encodeState(large, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first[len(stateMagic)+1] != codecGzip {
+		t.Errorf("encoded with codec %q, want gzip", first[len(stateMagic)+1])
+	}
+	if again, _ := encodeState(large, true); !bytes.Equal(again, first) {
+		t.Error("encoding the same content twice gave different bytes")
+	}
+
+	small, err := encodeState([]byte("{}\n"), true)
+	if err != nil || small[len(stateMagic)+1] != codecNone {
+		t.Errorf("small content framed with codec %q (%v), want none", small[len(stateMagic)+1], err)
+	}
+	if got, err := decodeState([]byte("{\"commit\": \"c1\"}\n")); err != nil || string(got) != "{\"commit\": \"c1\"}\n" {
+		t.Errorf("decodeState(plain) = %q, %v, want it unchanged", got, err)
+	}
+}
+
+// frameZstd frames content compressed with the zstd command, as earlier
+// versions wrote state.
+func frameZstd(content []byte) ([]byte, error) {
+	payload, err := runZstd(content, "-q", "-c", "--no-progress")
+	if err != nil {
+		return nil, err
+	}
+	framed, err := encodeState(content, true)
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(stateMagic), stateVersion, codecZstd)
+	out = append(out, payload...)
+	return append(out, framed[len(framed)-stateFooter:]...), nil
+}
+
+func TestCompressedLockMigratesAndCacheRecovers(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	commit := strings.Repeat("c", 40)
+	content := strings.Repeat("test();\n", 200)
+	server, dir, requests := newFixture(t, map[string]string{"/" + commit + "/url/a.js": content})
+	cfg := &Config{Commit: commit, TargetDir: "wpt", Files: []FileSpec{{Src: "url/a.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+	cacheDir := t.TempDir()
+	opts := func() *SyncOptions { return &SyncOptions{BaseURL: server.URL, CacheDir: cacheDir, Force: true} }
+
+	// A plain lock from an earlier sync is read, then rewritten compressed.
+	if err := Sync(context.Background(), configPath, opts()); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if data, _ := os.ReadFile(lockPath(configPath)); isFramedState(data) {
+		t.Fatal("lock compressed without compress_state")
+	}
+	cfg.CompressState = true
+	saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, opts()); err != nil {
+		t.Fatalf("Sync with compress_state: %v", err)
+	}
+	if data, _ := os.ReadFile(lockPath(configPath)); !isFramedState(data) {
+		t.Error("lock not compressed with compress_state")
+	}
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify with a compressed lock: %v", err)
+	}
+
+	// The second sync was served from the cache. Once the cached copy is
+	// damaged, it is dropped and downloaded again.
+	if n := requests(); n != 1 {
+		t.Fatalf("%d requests, want 1 and a cache hit", n)
+	}
+	cached := filepath.Join(cacheDir, commit, "url", "a.js")
+	data, err := os.ReadFile(cached)
+	if err != nil || !isFramedState(data) {
+		t.Fatalf("cached copy not framed (%v)", err)
+	}
+	data[stateHeader] ^= 0xff
+	if err := os.WriteFile(cached, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Sync(context.Background(), configPath, opts()); err != nil {
+		t.Fatalf("Sync with a corrupt cache: %v", err)
+	}
+	if n := requests(); n != 2 {
+		t.Errorf("%d requests, want the corrupt copy downloaded again", n)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "url", "a.js")); string(got) != content {
+		t.Errorf("a.js = %d bytes, want the upstream content", len(got))
+	}
+}
@@ -0,0 +1,160 @@
+package wptsync
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RunStats is one line of the stats file: the outcome of a sync or update.
+type RunStats struct {
+	Time     time.Time     `json:"time"`
+	Command  string        `json:"command"`
+	Commit   string        `json:"commit"`
+	UpToDate bool          `json:"up_to_date,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	Bytes    int64         `json:"bytes"`
+	// Changed counts the files downloaded, Skipped the ones that needed no
+	// work, and Failed the ones that could not be synced.
+	Changed int    `json:"changed"`
+	Skipped int    `json:"skipped"`
+	Failed  int    `json:"failed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// statsPath returns the stats file for configPath: the config's name with
+// its extension replaced by .stats.jsonl (wpt.json -> wpt.stats.jsonl).
+func statsPath(configPath string) string {
+	return strings.TrimSuffix(configPath, filepath.Ext(configPath)) + ".stats.jsonl"
+}
+
+// withStatsReport returns o, or a copy of it with a Report of its own when
+// o records stats without one, since stats are taken from the report.
+func (o *SyncOptions) withStatsReport() *SyncOptions {
+	if o == nil || !o.RecordStats || o.Report != nil {
+		return o
+	}
+	cp := *o
+	cp.Report = &SyncReport{}
+	return &cp
+}
+
+// recordStats appends the run command just finished with *errp, as
+// described by o.Report, to the stats file of configPath. Dry runs and runs
+// that failed before reaching the files (a broken config, say) are not
+// recorded, and failing to record never fails the run.
+func (o *SyncOptions) recordStats(configPath, command string, errp *error) {
+	if o == nil || !o.RecordStats || o.DryRun || o.Report == nil || o.Report.start.IsZero() {
+		return
+	}
+	r := o.Report
+	run := RunStats{
+		Time:     r.start.UTC().Truncate(time.Second),
+		Command:  command,
+		Commit:   r.Commit,
+		UpToDate: r.UpToDate,
+		Duration: r.Duration,
+		Bytes:    r.Summary.Bytes,
+		Changed:  r.Summary.Downloaded,
+		Skipped:  r.Summary.Skipped,
+		Failed:   r.Summary.Failed,
+	}
+	if *errp != nil {
+		run.Error = (*errp).Error()
+	}
+	if err := appendStats(statsPath(configPath), run); err != nil {
+		o.logf("warning: record run stats: %v\n", err)
+	}
+}
+
+// appendStats appends run to the stats file at path as one JSON line.
+func appendStats(path string, run RunStats) error {
+	line, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadRunStats reads the runs recorded for the configuration at configPath,
+// oldest first. A missing stats file yields no runs; lines that do not
+// decode, such as one cut short by a crash, are skipped.
+func LoadRunStats(configPath string) ([]RunStats, error) {
+	f, err := os.Open(statsPath(configPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open stats: %w", err)
+	}
+	defer f.Close()
+
+	var runs []RunStats
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var run RunStats
+		if json.Unmarshal(scanner.Bytes(), &run) == nil {
+			runs = append(runs, run)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read stats: %w", err)
+	}
+	return runs, nil
+}
+
+// RunTrend summarizes a window of runs.
+type RunTrend struct {
+	Runs int
+	// Failed counts the runs that returned an error.
+	Failed int
+	// MedianDuration is over the runs that did work: up-to-date runs take
+	// no time and would hide a slowdown.
+	MedianDuration time.Duration
+	// Changed and Bytes are totals over the window.
+	Changed int
+	Bytes   int64
+}
+
+// FailureRate is the fraction of t's runs that failed.
+func (t RunTrend) FailureRate() float64 {
+	if t.Runs == 0 {
+		return 0
+	}
+	return float64(t.Failed) / float64(t.Runs)
+}
+
+// SummarizeRuns returns the trend of runs.
+func SummarizeRuns(runs []RunStats) RunTrend {
+	t := RunTrend{Runs: len(runs)}
+	var durations []time.Duration
+	for _, run := range runs {
+		if run.Error != "" {
+			t.Failed++
+		}
+		if !run.UpToDate {
+			durations = append(durations, run.Duration)
+		}
+		t.Changed += run.Changed
+		t.Bytes += run.Bytes
+	}
+	if len(durations) > 0 {
+		slices.Sort(durations)
+		t.MedianDuration = durations[len(durations)/2]
+	}
+	return t
+}
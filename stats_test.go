@@ -0,0 +1,66 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSyncRecordsRunStats(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{"/c1/a.js": "aaaa\n"})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a.js"}}})
+
+	opts := &SyncOptions{BaseURL: server.URL, APIURL: server.URL, RecordStats: true}
+	for range 2 {
+		if err := Sync(context.Background(), configPath, opts); err != nil {
+			t.Fatalf("Sync: %v", err)
+		}
+	}
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, RecordStats: true, DryRun: true}); err != nil {
+		t.Fatalf("dry-run Sync: %v", err)
+	}
+	if opts.Report != nil {
+		t.Error("Sync set the caller's Report")
+	}
+
+	runs, err := LoadRunStats(configPath)
+	if err != nil {
+		t.Fatalf("LoadRunStats: %v", err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("recorded %d runs, want 2 (dry runs are not recorded)", len(runs))
+	}
+	if first := runs[0]; first.Command != "sync" || first.Commit != "c1" || first.Changed != 1 || first.Bytes != 5 || first.UpToDate {
+		t.Errorf("first run = %+v, want 1 file changed", first)
+	}
+	if !runs[1].UpToDate || runs[1].Changed != 0 {
+		t.Errorf("second run = %+v, want up to date", runs[1])
+	}
+
+	// A torn final line, as left by a crash, is skipped.
+	f, err := os.OpenFile(statsPath(configPath), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2024-`)
+	f.Close()
+	if runs, err := LoadRunStats(configPath); err != nil || len(runs) != 2 {
+		t.Errorf("LoadRunStats after a torn line = %d runs, %v", len(runs), err)
+	}
+}
+
+func TestSummarizeRuns(t *testing.T) {
+	trend := SummarizeRuns([]RunStats{
+		{Duration: 3 * time.Second, Changed: 2, Bytes: 10},
+		{Duration: time.Second, Changed: 1, Bytes: 5},
+		{UpToDate: true},
+		{Duration: 2 * time.Second, Error: "download a.js: unexpected status 502 Bad Gateway"},
+	})
+	if trend.Runs != 4 || trend.Failed != 1 || trend.MedianDuration != 2*time.Second || trend.Changed != 3 || trend.Bytes != 15 {
+		t.Errorf("SummarizeRuns = %+v", trend)
+	}
+	if got := trend.FailureRate(); got != 0.25 {
+		t.Errorf("FailureRate = %v, want 0.25", got)
+	}
+}
@@ -0,0 +1,241 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// LocalState describes a synced file on disk relative to the lock file.
+type LocalState string
+
+const (
+	// LocalClean means the file matches what the last sync wrote.
+	LocalClean LocalState = "clean"
+	// LocalMissing means the file is not on disk.
+	LocalMissing LocalState = "missing"
+	// LocalModified means the file was edited since the last sync.
+	LocalModified LocalState = "modified"
+	// LocalStale means the file is as synced, but the pinned commit, its
+	// src, patch, or license header changed since; a sync will rewrite it.
+	LocalStale LocalState = "stale"
+	// LocalUnsynced means the lock has no record of the file.
+	LocalUnsynced LocalState = "unsynced"
+)
+
+// UpstreamState describes a file's source upstream relative to the pinned
+// commit.
+type UpstreamState string
+
+const (
+	// UpstreamUnchanged means the source is the same at the latest commit.
+	UpstreamUnchanged UpstreamState = "unchanged"
+	// UpstreamChanged means the source changed after the pinned commit.
+	UpstreamChanged UpstreamState = "changed"
+	// UpstreamUnknown means GitHub's change list was truncated and did not
+	// mention the source, so it may or may not have changed.
+	UpstreamUnknown UpstreamState = "unknown"
+)
+
+// FileStatus is the state of one enabled file in a StatusReport.
+type FileStatus struct {
+	Src      string        `json:"src"`
+	Dst      string        `json:"dst"`
+	Local    LocalState    `json:"local"`
+	Upstream UpstreamState `json:"upstream"`
+	// Pinned is the file's own commit, if it has one; Upstream then
+	// compares that commit with the latest.
+	Pinned string `json:"pinned,omitempty"`
+	// Frozen reports that the file is kept as it is on disk, so that only
+	// its going missing needs attention.
+	Frozen bool `json:"frozen,omitempty"`
+	// LastCommit is the latest upstream commit that touched the source of
+	// a file changed upstream. GitHub is only asked for it, in batches,
+	// with a token.
+	LastCommit string `json:"last_commit,omitempty"`
+}
+
+// UpToDate reports whether the file needs no attention.
+func (f FileStatus) UpToDate() bool {
+	if f.Frozen {
+		return f.Local != LocalMissing
+	}
+	return f.Local == LocalClean && f.Upstream == UpstreamUnchanged
+}
+
+// StatusReport is the result of Status.
+type StatusReport struct {
+	// Commit is the commit pinned in the configuration.
+	Commit string `json:"commit"`
+	// Latest is the head of WPT master, or empty when it was not asked.
+	Latest string `json:"latest"`
+	// Limited says why upstream data is missing, when SyncOptions.Offline
+	// or SyncOptions.Budget kept Status from asking GitHub everything it
+	// needed; the files it could not compare are UpstreamUnknown.
+	Limited string `json:"limited,omitempty"`
+	// Files lists every enabled file in configuration order.
+	Files []FileStatus `json:"files"`
+}
+
+// Status reports, for every enabled file in the configuration at configPath,
+// whether it is missing or modified locally (against the lock file written
+// by the last sync) and whether its source changed upstream between the
+// pinned commit and the head of WPT master. With opts.Offline, or once
+// opts.Budget is spent, it reports what it knows instead of failing.
+func Status(ctx context.Context, configPath string, opts *SyncOptions) (*StatusReport, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	root, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return nil, fmt.Errorf("determine repo root from config: %w", err)
+	}
+
+	cfg, lockName, err := opts.loadGroup(configPath)
+	if err != nil {
+		return nil, err
+	}
+	opts = opts.forConfig(cfg)
+
+	lock, err := loadLock(lockName)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, opts.timeouts().Resolve)
+	defer cancel()
+
+	gh := opts.github()
+	report := &StatusReport{Commit: cfg.Commit}
+	// limited records why GitHub was not asked, and reports whether err
+	// says so.
+	limited := func(err error) bool {
+		if errors.Is(err, ErrNoAPICalls) && report.Limited == "" {
+			report.Limited = err.Error()
+		}
+		return errors.Is(err, ErrNoAPICalls)
+	}
+	latest, err := gh.latestCommit(ctx)
+	if err != nil && !limited(err) {
+		return nil, fmt.Errorf("fetch latest commit: %w", err)
+	}
+	report.Latest = latest
+	expanded := cfg
+	if latest != "" {
+		expanded, err = expandGlobs(ctx, root, cfg, opts)
+		if err != nil && !limited(err) {
+			return nil, err
+		}
+	}
+	if report.Limited != "" {
+		// The last sync recorded what the globs matched, if it was at the
+		// pinned commit.
+		expanded = expandGlobsFromLock(cfg, lock)
+		expanded.Files = slices.DeleteFunc(slices.Clone(expanded.Files), func(f FileSpec) bool { return isGlob(f.Src) })
+	}
+	cfg = expanded
+
+	// Pinned files are compared from their own pin, so there is one
+	// comparison per distinct commit.
+	type comparison struct {
+		changed  map[string]bool
+		complete bool
+	}
+	comparisons := map[string]comparison{latest: {changed: map[string]bool{}, complete: true}}
+	for _, file := range cfg.Files {
+		base := cfg.commitOf(file)
+		if _, ok := comparisons[base]; ok || !file.IsEnabled() || latest == "" {
+			continue
+		}
+		changed, complete, err := gh.changedPaths(ctx, base, latest)
+		if limited(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		comparisons[base] = comparison{changed, complete}
+	}
+
+	hasher := newLocalHasher(root, cfg, opts.Quick, opts.logf)
+	for _, file := range cfg.Files {
+		if !file.IsEnabled() {
+			continue
+		}
+		local, err := localState(hasher, lock, file)
+		if err != nil {
+			return nil, err
+		}
+		cmp := comparisons[cfg.commitOf(file)]
+		upstream := UpstreamUnchanged
+		switch {
+		case cmp.changed[strings.TrimLeft(file.Src, "/")]:
+			upstream = UpstreamChanged
+		case !cmp.complete:
+			upstream = UpstreamUnknown
+		}
+		report.Files = append(report.Files, FileStatus{Src: file.Src, Dst: file.Dst, Local: local, Upstream: upstream, Pinned: file.Commit, Frozen: file.Frozen})
+	}
+	if err := lastCommits(ctx, gh, latest, report.Files); err != nil && !limited(err) {
+		return nil, fmt.Errorf("find last upstream commits: %w", err)
+	}
+	return report, nil
+}
+
+// lastCommits sets the LastCommit of the files changed upstream, at latest,
+// with one GraphQL query per batch of them. Without a token, which GraphQL
+// requires, it leaves them unset.
+func lastCommits(ctx context.Context, gh *githubAPI, latest string, files []FileStatus) error {
+	var changed []string
+	for _, f := range files {
+		if f.Upstream == UpstreamChanged {
+			changed = append(changed, strings.TrimLeft(f.Src, "/"))
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	gql := gh.graphQL(ctx)
+	if gql == nil {
+		return nil
+	}
+	commits, err := gql.lastCommits(ctx, latest, changed)
+	if err != nil {
+		return err
+	}
+	for i, f := range files {
+		if f.Upstream == UpstreamChanged {
+			files[i].LastCommit = commits[strings.TrimLeft(f.Src, "/")]
+		}
+	}
+	return nil
+}
+
+// localState compares file on disk, hashed with h, with its lock entry.
+func localState(h *localHasher, lock *lockFile, file FileSpec) (LocalState, error) {
+	cfg := h.cfg
+	dest := filepath.Join(h.root, cfg.TargetDir, filepath.FromSlash(file.Dst))
+	if _, err := os.Stat(dest); errors.Is(err, os.ErrNotExist) {
+		return LocalMissing, nil
+	}
+	entry, ok := lock.Files[file.Dst]
+	if !ok {
+		return LocalUnsynced, nil
+	}
+	current, err := h.lockEntry(file)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case current.SHA256 != entry.SHA256:
+		return LocalModified, nil
+	case !current.sameFile(entry) || lock.Commit != cfg.Commit && file.Commit == "" && !file.Frozen:
+		return LocalStale, nil
+	}
+	return LocalClean, nil
+}
@@ -0,0 +1,156 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStatusReportsLocalAndUpstreamChanges(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	content := map[string]string{
+		"/c1/a.js": "a\n",
+		"/c1/b.js": "b\n",
+		"/c1/c.js": "c\n",
+	}
+	server, dir, _ := newFixture(t, content)
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/commits/master":  `{"sha":"c2"}`,
+		"/repos/o/n/compare/c1...c2": `{"files":[{"filename":"a.js"},{"filename":"new.js","previous_filename":"b.js"}]}`,
+	})
+
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a.js"}, {Src: "b.js"}, {Src: "c.js"}}}
+	configPath := saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "wpt", "b.js"), []byte("edited\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "wpt", "c.js")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "wpt", "d.js"), []byte("d\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Files = append(cfg.Files, FileSpec{Src: "d.js", Dst: "d.js"})
+	saveTestConfig(t, dir, cfg)
+
+	report, err := Status(context.Background(), configPath, &SyncOptions{APIURL: apiURL})
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if report.Commit != "c1" || report.Latest != "c2" {
+		t.Errorf("commits = %s, %s; want c1, c2", report.Commit, report.Latest)
+	}
+	want := []FileStatus{
+		{Src: "a.js", Dst: "a.js", Local: LocalClean, Upstream: UpstreamChanged},
+		{Src: "b.js", Dst: "b.js", Local: LocalModified, Upstream: UpstreamChanged},
+		{Src: "c.js", Dst: "c.js", Local: LocalMissing, Upstream: UpstreamUnchanged},
+		{Src: "d.js", Dst: "d.js", Local: LocalUnsynced, Upstream: UpstreamUnchanged},
+	}
+	if len(report.Files) != len(want) {
+		t.Fatalf("got %d files, want %d", len(report.Files), len(want))
+	}
+	for i, got := range report.Files {
+		if got != want[i] {
+			t.Errorf("file %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestStatusLeadingSlashSrc(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, dir, _ := newFixture(t, map[string]string{"/c1/common/x.js": "x\n"})
+	apiURL, _ := newAPIFixture(t, map[string]string{
+		"/repos/o/n/commits/master":  `{"sha":"c2"}`,
+		"/repos/o/n/compare/c1...c2": `{"files":[{"filename":"common/x.js"}]}`,
+	})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "/common/x.js", Dst: "common/x.js"}}})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	report, err := Status(context.Background(), configPath, &SyncOptions{APIURL: apiURL})
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(report.Files) != 1 || report.Files[0].Upstream != UpstreamChanged {
+		t.Errorf("files = %+v, want /common/x.js changed upstream", report.Files)
+	}
+}
+
+func TestStatusReportsLastUpstreamCommit(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, dir, _ := newFixture(t, map[string]string{"/c1/a.js": "a\n", "/c1/b.js": "b\n"})
+	apiURL, requests := newAPIFixture(t, map[string]string{
+		"/repos/o/n/commits/master":  `{"sha":"c3"}`,
+		"/repos/o/n/compare/c1...c3": `{"files":[{"filename":"a.js"}]}`,
+		"/graphql":                   `{"data":{"repository":{"object":{"h0":{"nodes":[{"oid":"c2"}]}}}}}`,
+	})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a.js"}, {Src: "b.js"}}})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	report, err := Status(context.Background(), configPath, &SyncOptions{APIURL: apiURL, Token: "tok"})
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(report.Files) != 2 || report.Files[0].LastCommit != "c2" || report.Files[1].LastCommit != "" {
+		t.Errorf("files = %+v, want a.js last changed in c2 and b.js unchanged", report.Files)
+	}
+	if n := requests(); n != 3 {
+		t.Errorf("%d API requests, want the latest commit, the comparison, and one GraphQL query", n)
+	}
+
+	// Without a token there is no GraphQL to ask.
+	if report, err = Status(context.Background(), configPath, &SyncOptions{APIURL: apiURL}); err != nil || report.Files[0].LastCommit != "" {
+		t.Errorf("Status without a token = %+v, %v, want no last commit", report, err)
+	}
+}
+
+func TestStatusOfflineAndBudget(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+
+	server, dir, _ := newFixture(t, map[string]string{"/c1/a.js": "a\n", "/c1/b.js": "b\n"})
+	apiURL, requests := newAPIFixture(t, map[string]string{
+		"/repos/o/n/commits/master":  `{"sha":"c2"}`,
+		"/repos/o/n/compare/c1...c2": `{"files":[{"filename":"a.js"}]}`,
+	})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a.js"}, {Src: "b.js"}}})
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "wpt", "b.js"), []byte("edited\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Status(context.Background(), configPath, &SyncOptions{APIURL: apiURL, Offline: true})
+	if err != nil {
+		t.Fatalf("Status -offline: %v", err)
+	}
+	if requests() != 0 {
+		t.Errorf("Status -offline made %d requests, want none", requests())
+	}
+	want := []FileStatus{
+		{Src: "a.js", Dst: "a.js", Local: LocalClean, Upstream: UpstreamUnknown},
+		{Src: "b.js", Dst: "b.js", Local: LocalModified, Upstream: UpstreamUnknown},
+	}
+	if report.Latest != "" || !strings.Contains(report.Limited, "offline") || !slices.Equal(report.Files, want) {
+		t.Errorf("offline report = %+v, want local state only with files %+v", report, want)
+	}
+
+	// One request finds the latest commit, and none is left to compare.
+	report, err = Status(context.Background(), configPath, &SyncOptions{APIURL: apiURL, Budget: 1})
+	if err != nil {
+		t.Fatalf("Status -budget 1: %v", err)
+	}
+	if requests() != 1 || report.Latest != "c2" || report.Limited == "" || !slices.Equal(report.Files, want) {
+		t.Errorf("report with a budget of 1 = %+v after %d requests, want latest c2 and upstream unknown", report, requests())
+	}
+}
@@ -0,0 +1,319 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Suite is a named part of WPT a configuration vendors, such as url or
+// encoding: the files of some upstream directories that a preset and
+// patterns select. The suite commands add its files as entries tagged with
+// its name (see FileSpec.Suite), keep them in step with upstream, and report
+// on them together, patches included.
+type Suite struct {
+	Name string `json:"name" wptsync:"required"`
+	// Dirs are the upstream directories the suite vendors, such as "url"
+	// or "encoding/streams".
+	Dirs []string `json:"dirs" wptsync:"required"`
+	// Preset is the selection of files the suite starts from: "js", every
+	// .js file, "testharness", the .any.js, .window.js, and .worker.js
+	// tests and every file under a resources directory, or "all". Empty
+	// means "js".
+	Preset string `json:"preset,omitempty"`
+	// Include lists more patterns (see matchFilter) selecting files, on top
+	// of those of the preset.
+	Include []string `json:"include,omitempty"`
+	// Exclude lists patterns keeping otherwise selected files out.
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// DefaultSuitePreset is the preset of a suite that names none.
+const DefaultSuitePreset = "js"
+
+// suitePresets are the patterns of each Suite.Preset.
+var suitePresets = map[string][]string{
+	"js":          {"*.js"},
+	"testharness": {"*.any.js", "*.window.js", "*.worker.js", "**/resources/**"},
+	"all":         {"*"},
+}
+
+func (s *Suite) check() error {
+	if !validGroupName(s.Name) {
+		return fmt.Errorf("suite name %q must be letters, digits, '-', and '_'", s.Name)
+	}
+	if len(s.Dirs) == 0 {
+		return fmt.Errorf("suite %q lists no dirs", s.Name)
+	}
+	for _, dir := range s.Dirs {
+		if d := strings.Trim(dir, "/"); d == "" || !isLocalSlash(d) {
+			return fmt.Errorf("suite %q: %q is not a directory of the repository", s.Name, dir)
+		}
+	}
+	if _, ok := suitePresets[s.preset()]; !ok {
+		return fmt.Errorf("suite %q: preset %q is not one of %s", s.Name, s.Preset, strings.Join(slices.Sorted(maps.Keys(suitePresets)), ", "))
+	}
+	for _, pattern := range slices.Concat(s.Include, s.Exclude) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("suite %q: pattern %q: %w", s.Name, pattern, err)
+		}
+	}
+	return nil
+}
+
+func (s *Suite) preset() string {
+	if s.Preset == "" {
+		return DefaultSuitePreset
+	}
+	return s.Preset
+}
+
+// isLocalSlash reports whether the slash-separated p stays inside the
+// directory it is relative to.
+func isLocalSlash(p string) bool {
+	return !path.IsAbs(p) && p == path.Clean(p) && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// suite returns the suite of c named name.
+func (c *Config) suite(name string) (*Suite, error) {
+	i := slices.IndexFunc(c.Suites, func(s Suite) bool { return s.Name == name })
+	if i < 0 {
+		names := make([]string, len(c.Suites))
+		for i, s := range c.Suites {
+			names[i] = s.Name
+		}
+		if len(names) == 0 {
+			return nil, invalidConfig(fmt.Errorf("config has no suites, so no suite %q", name))
+		}
+		return nil, invalidConfig(fmt.Errorf("config has no suite %q (suites: %s)", name, strings.Join(names, ", ")))
+	}
+	return &c.Suites[i], nil
+}
+
+// checkSuites checks each suite, that no two share a name, and that every
+// entry tagged with a suite names one of them.
+func (c *Config) checkSuites() error {
+	names := make(map[string]bool, len(c.Suites))
+	for _, s := range c.Suites {
+		if err := s.check(); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("config: two suites are named %q", s.Name)
+		}
+		names[s.Name] = true
+	}
+	for _, f := range c.Files {
+		if f.Suite != "" && !names[f.Suite] {
+			return fmt.Errorf("config: %s is in suite %q, which the config does not define", f.Src, f.Suite)
+		}
+	}
+	return nil
+}
+
+// suiteFiles returns the entries of c in the suite name.
+func (c *Config) suiteFiles(name string) []FileSpec {
+	var files []FileSpec
+	for _, f := range c.Files {
+		if f.Suite == name {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// addOptions returns opts set to add the files of s.
+func (s *Suite) addOptions(opts *AddOptions) *AddOptions {
+	var cp AddOptions
+	if opts != nil {
+		cp = *opts
+	}
+	cp.Include = slices.Concat(suitePresets[s.preset()], s.Include)
+	cp.Exclude = s.Exclude
+	cp.Suite = s.Name
+	cp.Manifest, cp.Types, cp.Since = false, nil, ""
+	return &cp
+}
+
+// SuiteAdd defines the suite s in the configuration at configPath and adds
+// the files of its directories that it selects, as Add does, tagged with
+// its name. Run a sync, or SuiteUpdate, to download them.
+func SuiteAdd(ctx context.Context, configPath string, s Suite, opts *AddOptions) error {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if _, err := cfg.suite(s.Name); err == nil {
+		return invalidConfig(fmt.Errorf("config already has a suite %q; use `wptsync suite update %s` to refresh it", s.Name, s.Name))
+	}
+	for i, dir := range s.Dirs {
+		s.Dirs[i] = strings.Trim(dir, "/")
+	}
+	cfg.Suites = append(cfg.Suites, s)
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	if err := SaveConfig(configPath, cfg); err != nil {
+		return err
+	}
+	return addSuiteFiles(ctx, configPath, &s, opts)
+}
+
+// addSuiteFiles adds the files of each directory of s, dropping those of
+// its entries that no longer qualify.
+func addSuiteFiles(ctx context.Context, configPath string, s *Suite, opts *AddOptions) error {
+	for _, dir := range s.Dirs {
+		fmt.Printf("Suite %s: %s\n", s.Name, dir)
+		if err := Add(ctx, configPath, dir, s.addOptions(opts)); err != nil {
+			return fmt.Errorf("suite %s: %w", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// SuiteRemove drops the suite name and its entries from the configuration
+// at configPath. With purge, it also deletes their synced files, as Remove
+// does. Patch files are never deleted.
+func SuiteRemove(ctx context.Context, configPath, name string, purge bool) error {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if _, err := cfg.suite(name); err != nil {
+		return err
+	}
+	cfg.Suites = slices.DeleteFunc(cfg.Suites, func(s Suite) bool { return s.Name == name })
+	n, err := removeEntries(ctx, configPath, cfg, func(f FileSpec) bool { return f.Suite == name }, purge)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if err := SaveConfig(configPath, cfg); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Removed suite %s and its %d files from %s\n", name, n, configPath)
+	return nil
+}
+
+// SuiteUpdate brings the suite name in step with upstream at the pinned
+// commit: it adds the files of its directories it now selects, drops the
+// entries that are gone or no longer selected, and syncs the suite's files
+// only. Files of dropped entries are then pruned.
+func SuiteUpdate(ctx context.Context, configPath, name string, opts *AddOptions) error {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	s, err := cfg.suite(name)
+	if err != nil {
+		return err
+	}
+	before := cfg.suiteFiles(name)
+	if err := addSuiteFiles(ctx, configPath, s, opts); err != nil {
+		return err
+	}
+	if cfg, err = LoadConfig(configPath); err != nil {
+		return err
+	}
+	files := cfg.suiteFiles(name)
+
+	var syncOpts SyncOptions
+	if opts != nil {
+		syncOpts = opts.SyncOptions
+	}
+	syncOpts.Only = nil
+	for _, f := range files {
+		syncOpts.Only = append(syncOpts.Only, f.Src)
+	}
+	if len(files) > 0 {
+		if err := Sync(ctx, configPath, &syncOpts); err != nil {
+			return err
+		}
+	}
+	kept := explicitSrcs(&Config{Files: files})
+	if !slices.ContainsFunc(before, func(f FileSpec) bool { return !kept[f.Src] }) || syncOpts.DryRun {
+		return nil
+	}
+	_, err = Prune(ctx, configPath, &PruneOptions{SyncOptions: syncOpts})
+	return err
+}
+
+// SuiteStatus is the state of the files of a suite, as Status reports them.
+type SuiteStatus struct {
+	Name  string   `json:"name"`
+	Dirs  []string `json:"dirs"`
+	Files int      `json:"files"`
+	// Patched counts the files synced with a patch.
+	Patched int `json:"patched"`
+	// Local counts the files in each local state but clean.
+	Local map[LocalState]int `json:"local,omitempty"`
+	// Changed counts the files changed upstream since the pinned commit,
+	// and Unknown those Status could not compare.
+	Changed int `json:"changed_upstream"`
+	Unknown int `json:"unknown_upstream,omitempty"`
+}
+
+// SuiteStatuses reports on the suites of the configuration at configPath,
+// or only on those named, from a single Status.
+func SuiteStatuses(ctx context.Context, configPath string, names []string, opts *SyncOptions) ([]SuiteStatus, *StatusReport, error) {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range names {
+		if _, err := cfg.suite(name); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(cfg.Suites) == 0 {
+		return nil, nil, invalidConfig(errors.New("config has no suites; define one with `wptsync suite add`"))
+	}
+	report, err := Status(ctx, configPath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bySuite := make(map[string]*SuiteStatus)
+	var statuses []SuiteStatus
+	for _, s := range cfg.Suites {
+		if len(names) == 0 || slices.Contains(names, s.Name) {
+			statuses = append(statuses, SuiteStatus{Name: s.Name, Dirs: s.Dirs})
+		}
+	}
+	for i := range statuses {
+		bySuite[statuses[i].Name] = &statuses[i]
+	}
+	suiteOf := make(map[string]FileSpec, len(cfg.Files))
+	for _, f := range cfg.Files {
+		suiteOf[f.Dst] = f
+	}
+	for _, fs := range report.Files {
+		f := suiteOf[fs.Dst]
+		st, ok := bySuite[f.Suite]
+		if !ok {
+			continue
+		}
+		st.Files++
+		if f.Patch != "" {
+			st.Patched++
+		}
+		if fs.Local != LocalClean {
+			if st.Local == nil {
+				st.Local = make(map[LocalState]int)
+			}
+			st.Local[fs.Local]++
+		}
+		switch fs.Upstream {
+		case UpstreamChanged:
+			st.Changed++
+		case UpstreamUnknown:
+			st.Unknown++
+		}
+	}
+	return statuses, report, nil
+}
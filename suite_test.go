@@ -0,0 +1,105 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSuiteLifecycle(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	tree := map[string]string{
+		"/repos/o/n/git/trees/c1": `{"tree":[{"path":"url","type":"tree","sha":"t-url"}]}`,
+		"/repos/o/n/git/trees/t-url?recursive=1": `{"tree":[
+			{"path":"a.any.js","type":"blob"},
+			{"path":"b.html","type":"blob"},
+			{"path":"c.js","type":"blob"},
+			{"path":"resources/helper.js","type":"blob"},
+			{"path":"resources/data.json","type":"blob"}]}`,
+	}
+	apiURL, _ := newAPIFixture(t, tree)
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/url/a.any.js":            "a\n",
+		"/c1/url/d.window.js":         "d\n",
+		"/c1/url/resources/helper.js": "helper\n",
+		"/c1/url/resources/data.json": "{}\n",
+	})
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "url/c.js"}}})
+	opts := &AddOptions{SyncOptions: SyncOptions{APIURL: apiURL, BaseURL: server.URL}}
+
+	if err := SuiteAdd(context.Background(), configPath, Suite{Name: "url", Dirs: []string{"url/"}, Preset: "testharness"}, opts); err != nil {
+		t.Fatalf("SuiteAdd: %v", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range cfg.Files {
+		got = append(got, f.Suite+":"+f.Dst)
+	}
+	want := []string{":url/c.js", "url:url/a.js", "url:url/resources/helper.js", "url:url/resources/data.json"}
+	if !reflect.DeepEqual(got, want) || len(cfg.Suites) != 1 || cfg.Suites[0].Dirs[0] != "url" {
+		t.Fatalf("entries after SuiteAdd = %q, suites %+v; want %q", got, cfg.Suites, want)
+	}
+	if err := SuiteAdd(context.Background(), configPath, Suite{Name: "url", Dirs: []string{"url"}}, opts); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("SuiteAdd of an existing suite = %v, want ErrInvalidConfig", err)
+	}
+
+	// Upstream drops data.json and adds d.window.js: update follows, syncing
+	// the suite's files only and pruning the one dropped.
+	tree["/repos/o/n/git/trees/t-url?recursive=1"] = `{"tree":[
+		{"path":"a.any.js","type":"blob"},
+		{"path":"d.window.js","type":"blob"},
+		{"path":"resources/helper.js","type":"blob"}]}`
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Only: []string{"url/resources/data.json"}}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := SuiteUpdate(context.Background(), configPath, "url", opts); err != nil {
+		t.Fatalf("SuiteUpdate: %v", err)
+	}
+	for dst, wantOnDisk := range map[string]bool{"url/a.js": true, "url/d.window.js": true, "url/resources/helper.js": true, "url/resources/data.json": false, "url/c.js": false} {
+		_, err := os.Stat(filepath.Join(dir, "wpt", filepath.FromSlash(dst)))
+		if onDisk := err == nil; onDisk != wantOnDisk {
+			t.Errorf("%s on disk = %v, want %v", dst, onDisk, wantOnDisk)
+		}
+	}
+
+	statuses, _, err := SuiteStatuses(context.Background(), configPath, nil, &SyncOptions{Offline: true})
+	if err != nil {
+		t.Fatalf("SuiteStatuses: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].Files != 3 || len(statuses[0].Local) != 0 {
+		t.Errorf("SuiteStatuses = %+v, want 3 clean files", statuses)
+	}
+
+	if err := SuiteRemove(context.Background(), configPath, "url", true); err != nil {
+		t.Fatalf("SuiteRemove: %v", err)
+	}
+	if cfg, _ = LoadConfig(configPath); len(cfg.Suites) != 0 || len(cfg.Files) != 1 || cfg.Files[0].Src != "url/c.js" {
+		t.Errorf("config after SuiteRemove = %+v, suites %+v", cfg.Files, cfg.Suites)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wpt", "url", "a.js")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("url/a.js after SuiteRemove -purge: %v", err)
+	}
+}
+
+func TestCheckSuites(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cfg  Config
+	}{
+		{"unknown preset", Config{Suites: []Suite{{Name: "url", Dirs: []string{"url"}, Preset: "tests"}}}},
+		{"no dirs", Config{Suites: []Suite{{Name: "url"}}}},
+		{"escaping dir", Config{Suites: []Suite{{Name: "url", Dirs: []string{"../url"}}}}},
+		{"duplicate", Config{Suites: []Suite{{Name: "url", Dirs: []string{"url"}}, {Name: "url", Dirs: []string{"encoding"}}}}},
+		{"undefined suite", Config{Files: []FileSpec{{Src: "url/a.js", Suite: "url"}}}},
+	} {
+		if err := tt.cfg.checkSuites(); err == nil {
+			t.Errorf("%s: checkSuites accepted it", tt.name)
+		}
+	}
+}
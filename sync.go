@@ -7,6 +7,7 @@ package wptsync
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -14,8 +15,11 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,20 +27,253 @@ import (
 // content host for the web-platform-tests repository.
 const DefaultBaseURL = "https://raw.githubusercontent.com/web-platform-tests/wpt"
 
-// SyncOptions configures a Sync run. A nil *SyncOptions is equivalent to its
-// zero value.
+// SyncOptions configures a Sync run. The other commands (Init, Add, Update,
+// Edit, Save) accept it too and honor the fields that apply to them. A nil
+// *SyncOptions is equivalent to its zero value.
 type SyncOptions struct {
 	// SkipPatches downloads files but does not apply any configured patches.
 	SkipPatches bool
+	// UseGit applies patches with `git apply` instead of the built-in
+	// applier, for patches it cannot handle, such as binary ones.
+	UseGit bool
 	// DryRun prints the actions that would be taken without writing files.
 	DryRun bool
-	// Force bypasses the freshness stamp, forcing a full sync even when the
-	// stamp indicates the local files are already up to date.
+	// Force bypasses the freshness stamp and the lock file, forcing a full
+	// sync even when they indicate the local files are already up to date.
 	Force bool
+	// Continue resumes a sync or update that failed part-way: files it
+	// completed at the pinned commit (recorded in wpt.lock.partial) are not
+	// fetched again if they are still on disk as written.
+	Continue bool
+	// Jobs is the number of files processed concurrently. Zero means
+	// DefaultJobs.
+	Jobs int
+	// ChangedOnly skips files whose upstream source did not change between
+	// the commit recorded in the lock file and the pinned commit (per the
+	// GitHub compare API) and whose patch and local content are unchanged.
+	ChangedOnly bool
+	// Mode selects how files are fetched: ModeRaw (the default when empty),
+	// ModeArchive, or ModeBatch.
+	Mode string
 	// BaseURL is the raw file base URL. Empty means DefaultBaseURL.
 	BaseURL string
+	// ArchiveURL is the base URL commit tarballs are fetched from in
+	// ModeArchive. Empty means DefaultArchiveURL.
+	ArchiveURL string
+	// MediaURL is the base URL Git LFS objects are fetched from. Empty means
+	// DefaultMediaURL.
+	MediaURL string
+	// APIURL is the GitHub REST API base for the repository. Empty means
+	// DefaultAPIURL.
+	APIURL string
+	// Token authenticates GitHub API requests (never raw file downloads).
+	// Empty means the GitHub App below, if any, or else the GITHUB_TOKEN
+	// environment variable.
+	Token string
+	// AppID authenticates GitHub API requests as an installation of this
+	// GitHub App instead of with a long-lived token: the run signs in with
+	// AppKey, the app's PEM-encoded private key, and mints short-lived
+	// installation tokens as it needs them. AppInstallation picks the
+	// installation; zero means the one on the upstream repository's owner,
+	// or the app's only one. Zero values fall back to the GITHUB_APP_ID,
+	// GITHUB_APP_PRIVATE_KEY, and GITHUB_APP_INSTALLATION_ID environment
+	// variables.
+	AppID           int64
+	AppKey          string
+	AppInstallation int64
 	// Logf receives progress messages. Nil means no output.
 	Logf func(format string, args ...any)
+	// Progress, when set, is called as Sync and Update start fetching files
+	// and after each one, to drive a progress display. Calls do not overlap.
+	Progress func(SyncProgress)
+	// Timeouts bounds each phase of the run. Zero fields use DefaultTimeouts.
+	Timeouts Timeouts
+	// NoTimeout disables every per-phase deadline; only ctx bounds the run.
+	NoTimeout bool
+	// HTTPClient sends every request. Nil means a client set up as Network
+	// and the configuration's network section say, or http.DefaultClient.
+	HTTPClient *http.Client
+	// Network overrides the configuration's network section (see Config.Network)
+	// field by field, adding to its headers. Its Timeout is not used; see
+	// Timeouts.
+	Network Network
+	// RecordDir, when set, saves every HTTP response under this directory
+	// so that a later run can replay it with ReplayDir.
+	RecordDir string
+	// ReplayDir, when set, serves HTTP responses previously saved with
+	// RecordDir instead of touching the network. Requests that were never
+	// recorded fail.
+	ReplayDir string
+	// FaultInjection, when set, fails some HTTP requests at random, for
+	// testing how the run copes (see FaultInjection).
+	FaultInjection *FaultInjection
+	// Retries is how many times a GET that failed with a network error,
+	// 429, or 5xx gateway error is retried, with exponential backoff and
+	// jitter. Zero means DefaultRetries; a negative value disables retries.
+	Retries int
+	// RetryDelay is the backoff before the first retry. Zero means
+	// DefaultRetryDelay.
+	RetryDelay time.Duration
+	// CacheDir keeps every file fetched at a full commit SHA under
+	// CacheDir/<commit>/<path> and serves later fetches of it from there.
+	// Empty disables the cache; the CLI defaults it to DefaultCacheDir.
+	CacheDir string
+	// CacheSize is the size in bytes CacheDir is trimmed to, least recently
+	// used files first, after a sync or update. Zero means DefaultCacheSize.
+	CacheSize int64
+	// Report, when set, is filled with the outcome of every file by Sync
+	// and Update.
+	Report *SyncReport
+	// RecordStats appends a summary of every Sync and Update that is not a
+	// dry run to the stats file next to the configuration (wpt.stats.jsonl),
+	// for LoadRunStats.
+	RecordStats bool
+	// WithDeps adds the scripts that synced JavaScript files load, with
+	// "// META: script=", relative imports, or importScripts, to the
+	// configuration, when it lacks them, and syncs them too, and in turn
+	// the scripts those load. For Add, it adds the scripts the added files
+	// load.
+	WithDeps bool
+	// CheckDirty makes Sync refuse to overwrite synced files that have
+	// uncommitted changes in git (see Config.CheckDirty), unless Force is
+	// set or ConfirmDirty allows it.
+	CheckDirty bool
+	// ConfirmDirty, when set, is asked whether to overwrite the listed
+	// files (relative to the configuration's directory) anyway.
+	ConfirmDirty func(paths []string) bool
+	// Source overrides the configuration's source (see Config.Source),
+	// such as "git:/srv/mirrors/wpt" to read files from a local clone.
+	Source string
+	// Offline refuses every GitHub API request, with an error wrapping
+	// ErrNoAPICalls. Status then reports from local state only.
+	Offline bool
+	// Budget is the most GitHub API requests the run may make; later ones
+	// fail with an error wrapping ErrNoAPICalls, and Status leaves what it
+	// could not ask unknown. Zero means no limit.
+	Budget int
+	// KeepGoing makes Sync and Update carry on past files that fail,
+	// syncing all the others, and then return a *FailuresError listing
+	// the failures with hints on the entries to fix. The failed files are
+	// left out of the lock file, so the next run retries them.
+	KeepGoing bool
+	// VerifyBlobs makes Sync and Update look up the git blob SHA of every
+	// file to fetch in the upstream tree, and refuse content that does not
+	// hash to it, with an error wrapping ErrVerification, before it replaces
+	// the file on disk. This catches truncated downloads and corrupted
+	// copies, at the cost of one GitHub API request per directory. The
+	// SHAs are recorded in the lock file. For Verify, it checks the lock
+	// file and the unmodified files against the upstream tree.
+	VerifyBlobs bool
+	// Quick makes Verify and Status hash only the files whose size or
+	// modification time changed since the state file (see StateFileName)
+	// recorded them, and trust its hash of the others, so that checking a
+	// large tree takes a stat per file. An edit that keeps both, such as
+	// one restoring the modification time, goes unnoticed; a file the
+	// state file does not record is hashed.
+	Quick bool
+	// Strict makes Sync and Update fail, with an error wrapping
+	// ErrVerification, when a file's validate_cmd fails, and with one
+	// wrapping ErrScanFindings on scan findings, as Scan.Strict does. The
+	// files concerned are left out of the lock file. Otherwise both are
+	// warnings.
+	Strict bool
+	// RequireAllowlist makes Sync and Update refuse every harness file
+	// (see HarnessChecksums) whose hash the configuration does not list,
+	// with an error wrapping ErrVerification, including when it lists none.
+	RequireAllowlist bool
+	// Only limits Sync to the entries whose src or dst matches one of these
+	// patterns (see matchFilter; "url/**" matches everything below url).
+	// Empty means every entry.
+	Only []string
+	// Skip leaves out the entries whose src or dst matches one of these
+	// patterns.
+	Skip []string
+	// Group names the group of the configuration (see Config.Groups) that
+	// Sync, Verify, and Status operate on, each with its own lock file.
+	// Empty means the configuration's own files.
+	Group string
+
+	// staged is the directory stageFiles fetched files into; fetchFile
+	// copies from it instead of downloading.
+	staged string
+	// checkout is the clone stageFiles found the sync reads from; fetchFile
+	// reads files at any commit from it instead of downloading.
+	checkout string
+	// repo and ref are the configuration's upstream, and releases whether
+	// it tracks releases, set by forConfig.
+	repo, ref string
+	releases  bool
+	// budget is shared by the copies of the options forConfig returned, so
+	// that Budget bounds the whole run.
+	budget *apiBudget
+	// app is shared the same way, so that a run mints one GitHub App
+	// installation token rather than one per request.
+	app *appTokens
+	// fuzzLog is the audit log patches that only applied with fuzz are
+	// recorded in, if the run keeps one (see recordFuzz).
+	fuzzLog string
+	// blobs holds the blob SHAs downloads are checked against, when the
+	// run checks them (see withBlobs).
+	blobs blobIndex
+	// harness holds the hashes harness files are checked against, when
+	// the run checks them (see withHarnessChecksums).
+	harness *harnessAllowlist
+	// network is the client Network and the configuration set up, if they
+	// change anything, shared by the copies forConfig returned.
+	network *http.Client
+}
+
+// forConfig returns a copy of o that targets cfg's upstream repository: URLs
+// o leaves empty are taken from cfg's raw_base_url and api_url, or derived
+// from its repo, so explicit options still win.
+func (o *SyncOptions) forConfig(cfg *Config) *SyncOptions {
+	var cp SyncOptions
+	if o != nil {
+		cp = *o
+	}
+	cp.repo, cp.ref, cp.releases = cfg.Repo, cfg.Ref, cfg.TrackReleases
+	if cp.budget == nil {
+		cp.budget = newAPIBudget(&cp)
+	}
+	if cp.app == nil {
+		// An error resurfaces from github().
+		cp.app, _ = newAppTokens(&cp)
+	}
+	var network Network
+	if cfg.Network != nil {
+		network = *cfg.Network
+		if d := cfg.Network.timeout(); d > 0 {
+			cp.Timeouts.Resolve = cmp.Or(cp.Timeouts.Resolve, d)
+			cp.Timeouts.Download = cmp.Or(cp.Timeouts.Download, d)
+		}
+	}
+	if cp.BaseURL == "" {
+		cp.BaseURL = cfg.RawBaseURL
+	}
+	if cp.APIURL == "" {
+		cp.APIURL = cfg.APIURL
+	}
+	if cp.network == nil {
+		// Headers go to the hosts set explicitly, never to the defaults
+		// derived below. An error resurfaces from the first request.
+		cp.network = networkClient(network.merged(cp.Network), cfg.dir, cp.BaseURL, cp.APIURL)
+	}
+	if cfg.Repo == "" || cfg.Repo == DefaultRepo {
+		return &cp
+	}
+	if cp.BaseURL == "" {
+		cp.BaseURL = "https://raw.githubusercontent.com/" + cfg.Repo
+	}
+	if cp.APIURL == "" {
+		cp.APIURL = "https://api.github.com/repos/" + cfg.Repo
+	}
+	if cp.MediaURL == "" {
+		cp.MediaURL = "https://media.githubusercontent.com/media/" + cfg.Repo
+	}
+	if cp.ArchiveURL == "" {
+		cp.ArchiveURL = "https://codeload.github.com/" + cfg.Repo + "/tar.gz"
+	}
+	return &cp
 }
 
 func (o *SyncOptions) logf(format string, args ...any) {
@@ -46,6 +283,17 @@ func (o *SyncOptions) logf(format string, args ...any) {
 	o.Logf(format, args...)
 }
 
+// unstaged returns o without the staged copies of stageFiles, for fetches
+// at another commit than they were staged for.
+func (o *SyncOptions) unstaged() *SyncOptions {
+	if o == nil || o.staged == "" {
+		return o
+	}
+	cp := *o
+	cp.staged = ""
+	return &cp
+}
+
 func (o *SyncOptions) baseURL() string {
 	if o == nil || o.BaseURL == "" {
 		return DefaultBaseURL
@@ -53,116 +301,607 @@ func (o *SyncOptions) baseURL() string {
 	return o.BaseURL
 }
 
+// timeouts returns the effective per-phase deadlines, all zero when
+// NoTimeout is set.
+func (o *SyncOptions) timeouts() Timeouts {
+	if o == nil {
+		return DefaultTimeouts
+	}
+	if o.NoTimeout {
+		return Timeouts{}
+	}
+	return o.Timeouts.withDefaults()
+}
+
+// validate reports the first problem with o as an ErrInvalidConfig.
+func (o *SyncOptions) validate() error {
+	return invalidConfig(o.check())
+}
+
+func (o *SyncOptions) check() error {
+	if o == nil {
+		return nil
+	}
+	if o.Budget < 0 {
+		return fmt.Errorf("budget must not be negative (got %d)", o.Budget)
+	}
+	if o.AppKey != "" {
+		if _, err := parseAppKey(o.AppKey); err != nil {
+			return fmt.Errorf("GitHub App private key: %w", err)
+		}
+	}
+	if o.Jobs < 0 {
+		return fmt.Errorf("jobs must not be negative (got %d)", o.Jobs)
+	}
+	if o.RecordDir != "" && o.ReplayDir != "" {
+		return errors.New("record and replay modes are mutually exclusive")
+	}
+	if err := o.FaultInjection.validate(); err != nil {
+		return err
+	}
+	if o.Mode != "" && o.Mode != ModeRaw && o.Mode != ModeArchive && o.Mode != ModeBatch {
+		return fmt.Errorf("unknown mode %q (want %q, %q, or %q)", o.Mode, ModeRaw, ModeArchive, ModeBatch)
+	}
+	if err := checkSource(o.Source); err != nil {
+		return err
+	}
+	if err := o.Network.check(); err != nil {
+		return err
+	}
+	for _, pattern := range slices.Concat(o.Only, o.Skip) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("filter %q: %w", pattern, err)
+		}
+	}
+	return o.Timeouts.validate()
+}
+
+// filtered reports whether Only and Skip restrict the files synced.
+func (o *SyncOptions) filtered() bool {
+	return o != nil && (len(o.Only) > 0 || len(o.Skip) > 0)
+}
+
+// selects reports whether file passes the Only and Skip filters.
+func (o *SyncOptions) selects(file FileSpec) bool {
+	if !o.filtered() {
+		return true
+	}
+	matches := func(pattern string) bool {
+		return matchFilter(pattern, strings.TrimLeft(file.Src, "/")) || matchFilter(pattern, file.Dst)
+	}
+	if len(o.Only) > 0 && !slices.ContainsFunc(o.Only, matches) {
+		return false
+	}
+	return !slices.ContainsFunc(o.Skip, matches)
+}
+
+// httpClient returns the client every request goes through, wrapped for
+// recording or replay when configured, for fault injection, and for
+// retrying transient failures unless replaying without faults.
+func (o *SyncOptions) httpClient() *http.Client {
+	client := http.DefaultClient
+	switch {
+	case o == nil:
+	case o.HTTPClient != nil:
+		client = o.HTTPClient
+	case o.network != nil:
+		client = o.network
+	}
+	replaying := o != nil && o.ReplayDir != ""
+	var next http.RoundTripper
+	if replaying {
+		next = &replayTransport{dir: o.ReplayDir}
+	} else {
+		next = transportOf(client)
+		if o != nil && o.RecordDir != "" {
+			next = &recordTransport{dir: o.RecordDir, next: next}
+		}
+	}
+	faulty := o != nil && o.FaultInjection != nil
+	if faulty {
+		next = &faultTransport{next: next, faults: o.FaultInjection, logf: o.logf}
+	}
+	retries, delay := o.retryPolicy()
+	if retries > 0 && (!replaying || faulty) {
+		next = &retryTransport{next: next, retries: retries, delay: delay, logf: o.logf}
+	}
+	wrapped := *client
+	wrapped.Transport = next
+	return &wrapped
+}
+
 // Sync downloads the files listed in the configuration at configPath (at the
 // commit pinned in that configuration) and applies their configured patches.
-func Sync(ctx context.Context, configPath string, opts *SyncOptions) error {
+func Sync(ctx context.Context, configPath string, opts *SyncOptions) (err error) {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	opts = opts.withStatsReport()
+	defer opts.recordStats(configPath, "sync", &err)
+
 	root, err := filepath.Abs(filepath.Dir(configPath))
 	if err != nil {
 		return fmt.Errorf("determine repo root from config: %w", err)
 	}
 
-	cfg, err := LoadConfig(configPath)
+	cfg, lockName, err := opts.loadGroup(configPath)
 	if err != nil {
 		return err
 	}
-
-	if err := cfg.validate(); err != nil {
-		return err
-	}
+	opts = opts.forConfig(cfg)
 
 	logf := opts.logf
 	baseURL := opts.baseURL()
 	skipPatching := opts != nil && opts.SkipPatches
 	dryRun := opts != nil && opts.DryRun
 	force := opts != nil && opts.Force
+	report := opts.Report
+	report.begin(cfg.Commit, dryRun)
+	defer report.finish()
 
 	if len(cfg.Files) == 0 {
 		logf("No files configured to sync.\n")
+		report.upToDate()
 		return nil
 	}
 
 	// ponytail: no cross-process locking; two packages syncing the same config concurrently can race on first population. Add a lock file if that ever happens.
-	if !dryRun && !force && !skipPatching {
+	// The stamp says nothing about dependencies missing from the config.
+	if !dryRun && !force && !skipPatching && !opts.WithDeps {
+		// A fresh stamp means the config is unchanged since the last full
+		// sync, so the lock records exactly what its globs expand to.
+		stampCfg := cfg
+		if cfg.hasGlobs() {
+			if lock, err := loadLock(lockName); err == nil {
+				stampCfg = expandGlobsFromLock(cfg, lock)
+			}
+		}
 		stampFile := stampPath(root, cfg)
-		if hash, err := computeStamp(configPath, root, cfg); err == nil && stampIsFresh(stampFile, hash, root, cfg) {
+		if hash, err := computeStamp(configPath, root, cfg); err == nil && stampIsFresh(stampFile, hash, root, stampCfg) {
 			logf("wpt files up to date (stamp match); skipping sync\n")
+			report.upToDate()
 			return nil
 		}
 	}
 
+	if cfg, err = expandGlobs(ctx, root, cfg, opts); err != nil {
+		return err
+	}
+	for _, w := range append(cfg.pathWarnings(), cfg.groupWarnings...) {
+		logf("warning: %s\n", w)
+	}
+	if err := checkDstsOnDisk(root, cfg); err != nil {
+		return err
+	}
+
 	logf("Syncing %d WPT files from %s at commit %s\n", len(cfg.Files), baseURL, cfg.Commit)
 
+	// The lock only describes patched content, so skip-patches runs neither
+	// trust nor rewrite it.
+	useLock := !dryRun && !skipPatching
+	if useLock {
+		opts.fuzzLog = fuzzLogPath(lockName)
+	}
+	lock := &lockFile{Files: map[string]lockEntry{}}
+	if useLock && !force {
+		if lock, err = loadLock(lockName); err != nil {
+			return err
+		}
+	}
+	newLock := &lockFile{Commit: cfg.Commit, Files: map[string]lockEntry{}}
+
+	var resume *lockFile
+	if useLock && opts.Continue {
+		if resume, err = loadLock(progressPath(lockName)); err != nil {
+			return err
+		}
+	}
+
+	var changed map[string]bool
+	if useLock && opts.ChangedOnly {
+		if changed, err = upstreamChanges(ctx, cfg, lock, opts); err != nil {
+			return err
+		}
+	}
+
+	// Entries left out by Only and Skip keep what the last sync recorded, if
+	// it was at the same commit: the lock records a single one.
+	kept := lock
+	if useLock && force && opts.filtered() {
+		if kept, err = loadLock(lockName); err != nil {
+			return err
+		}
+	}
+
+	var pending []FileSpec
+	selected := 0
 	for _, file := range cfg.Files {
+		if !opts.selects(file) {
+			report.skip(file, "filtered")
+			if entry, ok := kept.Files[file.Dst]; ok && (kept.Commit == cfg.Commit || entry.Commit != "" || file.Frozen) {
+				newLock.Files[file.Dst] = entry
+			}
+			continue
+		}
+		selected++
 		if !file.IsEnabled() {
 			logf(" - skipping %s (disabled)\n", file.Src)
+			report.skip(file, "disabled")
+			continue
+		}
+		if file.Frozen {
+			entry, ok, err := frozenEntry(root, cfg, file)
+			if err != nil {
+				return err
+			}
+			if ok {
+				logf(" = %s (frozen)\n", file.Dst)
+				report.skip(file, "frozen")
+				newLock.Files[file.Dst] = entry
+				continue
+			}
+		}
+		if useLock && lock.canSkip(root, cfg, file, changed) {
+			logf(" = %s (unchanged)\n", file.Dst)
+			report.skip(file, "unchanged")
+			newLock.Files[file.Dst] = lock.Files[file.Dst]
+			continue
+		}
+		if resume != nil && resume.isFresh(root, cfg, file) {
+			logf(" = %s (synced before the interruption)\n", file.Dst)
+			report.skip(file, "synced before the interruption")
+			newLock.Files[file.Dst] = resume.Files[file.Dst]
 			continue
 		}
-		if err := processFile(ctx, root, cfg, file, skipPatching, dryRun, baseURL, logf); err != nil {
+		pending = append(pending, file)
+	}
+	if opts.filtered() {
+		logf("%d of %d files selected by the -only and -skip filters\n", selected, len(cfg.Files))
+	}
+
+	// Without force, lock holds what the last sync wrote.
+	if !dryRun && !force && (opts.CheckDirty || cfg.CheckDirty) {
+		if err := checkDirty(ctx, root, cfg, lock, pending, opts); err != nil {
 			return err
 		}
 	}
 
-	if !dryRun && !skipPatching {
-		writeStamp(configPath, root, cfg)
+	// syncFiles fetches and patches pending, adding them to newLock and
+	// written. With KeepGoing, the files that fail are added to failures
+	// instead.
+	var failures []FileFailure
+	var written []FileSpec
+	var validateResults []error
+	syncFiles := func(pending []FileSpec) error {
+		workerOpts, cleanup, err := stageFiles(ctx, root, cfg, pending, opts)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		if workerOpts, err = workerOpts.withBlobs(ctx, cfg, pending); err != nil {
+			return err
+		}
+		if workerOpts, err = workerOpts.withHarnessChecksums(ctx, root, cfg); err != nil {
+			return err
+		}
+
+		entries := make([]lockEntry, len(pending))
+		fileErrs := make([]error, len(pending))
+		validateErrs := make([]error, len(pending))
+		workerOpts = workerOpts.serialized()
+		progress := opts.trackProgress(len(pending))
+		err = forEachFile(ctx, opts.jobs(), len(pending), func(ctx context.Context, i int) error {
+			file := pending[i]
+			var etag string
+			if useLock {
+				etag = lock.conditionalETag(root, cfg, file)
+			}
+			start := time.Now()
+			etag, err := processFile(ctx, root, cfg, file, etag, workerOpts)
+			if err == nil {
+				err = runHooks(ctx, root, cfg, "post_file", &file, workerOpts)
+			}
+			if err == nil {
+				validateErrs[i] = validateFile(ctx, root, cfg, file, workerOpts)
+			}
+			res := opts.fileResult(root, cfg, file, start, err)
+			report.add(res)
+			progress.done(res)
+			if errors.Is(err, errNotModified) {
+				logf(" = %s (unchanged upstream)\n", file.Dst)
+				entries[i] = lock.Files[file.Dst]
+				return nil
+			}
+			if err != nil && opts.KeepGoing && ctx.Err() == nil {
+				logf("   %s: %v\n", file.Dst, err)
+				fileErrs[i] = err
+				return nil
+			}
+			if err != nil || !useLock {
+				return err
+			}
+			entry, err := newLockEntry(root, cfg, file)
+			entry.ETag = etag
+			entry.Blob = workerOpts.expectedBlob(cfg.commitOf(file), file.Src)
+			entries[i] = entry
+			return err
+		})
+		if err != nil {
+			if useLock {
+				if done, perr := saveProgress(lockName, newLock, pending, entries, cfg.CompressState); perr == nil {
+					logf("Synced %d of %d files before the failure; run `wptsync sync -continue` to resume.\n", done, len(pending))
+				}
+			}
+			return err
+		}
+		for i, file := range pending {
+			switch {
+			case fileErrs[i] != nil:
+				// Left out of the lock, so the next sync retries it.
+				failures = append(failures, newFileFailure(file, fileErrs[i]))
+			case useLock && entries[i].SHA256 == "":
+				// Never processed; an empty entry would vouch for nothing.
+			default:
+				if useLock {
+					newLock.Files[file.Dst] = entries[i]
+				}
+				if !dryRun {
+					written = append(written, file)
+					validateResults = append(validateResults, validateErrs[i])
+				}
+			}
+		}
+		return nil
+	}
+	warnEdited(root, cfg, lock, pending, dryRun, logf)
+	if err := runHooks(ctx, root, cfg, "pre_sync", nil, opts); err != nil {
+		return err
+	}
+	if err := syncFiles(pending); err != nil {
+		return err
 	}
 
-	return nil
+	// Dependencies are read from the synced files, so a dry run has none.
+	// Each round syncs the dependencies of the files the last one added.
+	for opts.WithDeps && !dryRun {
+		deps, err := addScriptDeps(ctx, configPath, root, cfg, opts)
+		if err != nil {
+			return err
+		}
+		if len(deps) == 0 {
+			break
+		}
+		cfg.Files = append(cfg.Files, deps...)
+		if err := syncFiles(deps); err != nil {
+			return err
+		}
+	}
+
+	// Flagged files are left out of the lock like failed ones.
+	flagged, scanErr := opts.scanSynced(root, cfg, written)
+	if scanErr != nil && !errors.Is(scanErr, ErrScanFindings) {
+		return scanErr
+	}
+	invalid, validateErr := opts.validated(written, validateResults)
+	for dst := range flagged {
+		delete(newLock.Files, dst)
+	}
+	for dst := range invalid {
+		delete(newLock.Files, dst)
+	}
+
+	hookErr := runHooks(ctx, root, cfg, "post_sync", nil, opts)
+	if useLock {
+		newLock.retireFrom(lockName, root, cfg)
+		if report != nil {
+			// lock is empty when forced, so read again the one on disk.
+			if prev, err := loadLock(lockName); err == nil {
+				report.changed(prev, newLock)
+			}
+		}
+		if err := saveLock(lockName, newLock, cfg.CompressState); err != nil {
+			return err
+		}
+		recordSynced(root, cfg, newLock, written, nil, logf)
+		writeNotices(root, cfg, newLock, logf)
+		if cfg.Dedupe {
+			dedupeFiles(root, cfg, newLock, logf)
+		}
+		// The stamp vouches for every file, not just those selected.
+		switch {
+		case len(failures) > 0 || len(flagged) > 0 || len(invalid) > 0 || hookErr != nil:
+			if err := os.Remove(stampPath(root, cfg)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				logf("warning: remove stale freshness stamp: %v\n", err)
+			}
+		case !opts.filtered():
+			writeStamp(configPath, root, cfg)
+		}
+		os.Remove(progressPath(lockName))
+	}
+	if err := opts.trimCache(); err != nil {
+		logf("warning: trim download cache: %v\n", err)
+	}
+
+	if len(failures) > 0 {
+		return errors.Join(opts.failuresError(ctx, cfg, lock.Commit, failures), scanErr, validateErr, hookErr)
+	}
+	return errors.Join(scanErr, validateErr, hookErr)
 }
 
-// processFile downloads a single configured file and applies its patch (if
-// any). It is the shared per-file step used by Sync, Update, and Edit.
-func processFile(ctx context.Context, root string, cfg *Config, file FileSpec, skipPatching, dryRun bool, baseURL string, logf func(format string, args ...any)) error {
-	// Per-file timeout so a long file list never starves later downloads.
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+// upstreamChanges returns the set of paths that changed upstream between
+// the lock's commit and cfg.Commit, for use with lockFile.canSkip. It
+// returns nil (treat everything as changed) when there is no earlier commit
+// to compare against or the compare listing may be incomplete.
+func upstreamChanges(ctx context.Context, cfg *Config, lock *lockFile, opts *SyncOptions) (map[string]bool, error) {
+	if lock.Commit == "" || lock.Commit == cfg.Commit {
+		return nil, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, opts.timeouts().Resolve)
 	defer cancel()
 
+	changed, complete, err := opts.github().changedPaths(ctx, lock.Commit, cfg.Commit)
+	if err != nil {
+		return nil, err
+	}
+	if !complete {
+		opts.logf("Upstream change list may be truncated; syncing every file\n")
+		return nil, nil
+	}
+	return changed, nil
+}
+
+// processFile downloads a single configured file, applies its patch (if
+// any), and injects its license header (if configured). It is the shared
+// per-file step used by Sync, Update, and Edit. A non-empty etag makes the
+// download conditional: processFile then returns errNotModified, without
+// touching the file, when upstream still matches it. Otherwise it returns
+// the download's ETag, if any. A file that comes out byte-for-byte as it
+// was keeps its modification time, so incremental builds see no change.
+func processFile(ctx context.Context, root string, cfg *Config, file FileSpec, etag string, opts *SyncOptions) (string, error) {
 	src := strings.TrimLeft(file.Src, "/")
-	url := fmt.Sprintf("%s/%s/%s", baseURL, cfg.Commit, src)
 	dest := filepath.Join(root, cfg.TargetDir, filepath.FromSlash(file.Dst))
 
-	logf(" - %s -> %s\n", src, dest)
-	if dryRun {
-		return nil
+	opts.logf(" - %s -> %s\n", src, dest)
+	if opts != nil && opts.DryRun {
+		return "", nil
 	}
 
-	if err := download(ctx, url, dest); err != nil {
-		return fmt.Errorf("download %s: %w", src, err)
+	var prevSum string
+	prev, err := os.Stat(dest)
+	if err == nil {
+		prevSum, _ = hashFile(dest)
+	}
+	// Read before the download replaces dest, to keep its provenance date;
+	// whether the new content is binary is only known after it.
+	header, err := cfg.textHeader(file, dest)
+	if err != nil {
+		return "", err
 	}
 
-	if skipPatching || file.Patch == "" {
-		return nil
+	// Per-file, per-phase timeouts so a long file list never starves later
+	// downloads and a slow download doesn't eat into the patch budget.
+	timeouts := opts.timeouts()
+	downloadCtx, cancel := withTimeout(ctx, timeouts.Download)
+	defer cancel()
+	fetchOpts := opts
+	if file.Commit != "" {
+		// Staged copies are of the configuration's commit.
+		fetchOpts = opts.unstaged()
+	}
+	// Content checked against its blob SHA or the harness allowlist is
+	// fetched next to dest, so that content failing the check never
+	// replaces the file.
+	blob := opts.expectedBlob(cfg.commitOf(file), src)
+	fetchDest := dest
+	if blob != "" || opts.checksHarness(src) {
+		fetchDest = filepath.Join(filepath.Dir(dest), ".wpt-verify-"+filepath.Base(dest))
+		defer os.Remove(fetchDest)
+	}
+	etag, err = fetchFileIfNoneMatch(downloadCtx, cfg.commitOf(file), src, fetchDest, etag, blob, fetchOpts)
+	if errors.Is(err, errNotModified) {
+		return etag, err
+	}
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", src, err)
+	}
+	if err := opts.checkHarness(fetchDest, src); err != nil {
+		return "", err
+	}
+	if fetchDest != dest {
+		if err := os.Rename(fetchDest, dest); err != nil {
+			return "", fmt.Errorf("move %s into place: %w", src, err)
+		}
+	}
+
+	// Transforms, the built-in patch applier, and headers work on lines of
+	// text, and would corrupt binary content, so they are skipped for it.
+	patching := (opts == nil || !opts.SkipPatches) && file.Patch != ""
+	binary := false
+	if len(file.Transforms) > 0 || patching || header != "" {
+		if binary, err = isBinaryFile(dest, file.Dst); err != nil {
+			return "", err
+		}
+	}
+	if binary && len(file.Transforms) > 0 {
+		opts.logf("warning: %s is binary; skipping its transforms\n", file.Dst)
+	} else if err := applyTransforms(dest, file); err != nil {
+		return "", fmt.Errorf("transform %s: %w", file.Dst, err)
+	}
+	if binary && patching && (opts == nil || !opts.UseGit) {
+		opts.logf("warning: %s is binary; skipping its patch, which only -use-git can apply\n", file.Dst)
+		patching = false
 	}
 
-	if err := applyPatch(ctx, root, file.Patch); err != nil {
-		return fmt.Errorf("apply patch %s: %w", file.Patch, err)
+	if patching {
+		patchCtx, cancel := withTimeout(ctx, timeouts.Patch)
+		defer cancel()
+		fuzzed, err := applyPatch(patchCtx, root, file.Patch, opts != nil && opts.UseGit, cfg.patchFuzz())
+		if err != nil {
+			return "", fmt.Errorf("apply patch %s: %w", file.Patch, err)
+		}
+		if len(fuzzed) > 0 {
+			opts.recordFuzz(cfg, file, fuzzed)
+		}
 	}
 
-	return nil
+	// The header goes on last so patches keep applying to upstream content.
+	if binary && header != "" {
+		opts.logf("warning: %s is binary; not adding its header\n", file.Dst)
+	} else if err := injectHeader(dest, header); err != nil {
+		return "", fmt.Errorf("inject license header into %s: %w", file.Dst, err)
+	}
+
+	if prevSum != "" {
+		if sum, err := hashFile(dest); err == nil && sum == prevSum {
+			os.Chtimes(dest, time.Time{}, prev.ModTime())
+			opts.logf(" = %s (unchanged)\n", file.Dst)
+		}
+	}
+	return etag, nil
 }
 
-func download(ctx context.Context, url, dest string) error {
+func download(ctx context.Context, client *http.Client, url, dest string) error {
+	_, err := downloadIfNoneMatch(ctx, client, url, dest, "")
+	return err
+}
+
+// errNotModified reports that a conditional download found the upstream
+// content unchanged; dest was left alone.
+var errNotModified = errors.New("not modified")
+
+// downloadIfNoneMatch is download with an If-None-Match of etag when etag is
+// set. It returns the response's ETag, or errNotModified when the server
+// answers that its content still matches etag.
+func downloadIfNoneMatch(ctx context.Context, client *http.Client, url, dest, etag string) (string, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
+	if etag != "" && resp.StatusCode == http.StatusNotModified {
+		return etag, errNotModified
+	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status %s", resp.Status)
+		return "", &statusError{code: resp.StatusCode, status: resp.Status}
 	}
 
 	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
-		return fmt.Errorf("create destination directory: %w", err)
+		return "", fmt.Errorf("create destination directory: %w", err)
 	}
 
 	tmpFile, err := os.CreateTemp(filepath.Dir(dest), ".wpt-download-*")
 	if err != nil {
-		return fmt.Errorf("create temp file: %w", err)
+		return "", fmt.Errorf("create temp file: %w", err)
 	}
 	defer func() {
 		tmpFile.Close()
@@ -170,51 +909,117 @@ func download(ctx context.Context, url, dest string) error {
 	}()
 
 	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
-		return fmt.Errorf("write temp file: %w", err)
+		return "", fmt.Errorf("write temp file: %w", err)
 	}
 
 	if err := tmpFile.Sync(); err != nil {
-		return fmt.Errorf("sync temp file: %w", err)
+		return "", fmt.Errorf("sync temp file: %w", err)
 	}
 
 	if err := os.Rename(tmpFile.Name(), dest); err != nil {
-		return fmt.Errorf("move file into place: %w", err)
+		return "", fmt.Errorf("move file into place: %w", err)
 	}
 
-	return nil
+	return resp.Header.Get("ETag"), nil
 }
 
-// ErrPatchFailed marks git apply failures so update can keep going and report
-// them all at the end instead of aborting on the first one.
-var ErrPatchFailed = errors.New("git apply failed")
+// ErrPatchFailed marks patches that fail to apply so update can keep going
+// and report them all at the end instead of aborting on the first one.
+var ErrPatchFailed = errors.New("patch does not apply")
 
-func applyPatch(ctx context.Context, root, patchPath string) error {
+// applyPatch applies the patch at patchPath (relative to root) with the
+// built-in applier, with the leeway fuzz allows, or with git apply when
+// useGit is set. It returns the fuzz the built-in applier needed.
+func applyPatch(ctx context.Context, root, patchPath string, useGit bool, fuzz PatchFuzz) ([]HunkFuzz, error) {
 	absPatch := patchPath
 	if !filepath.IsAbs(patchPath) {
 		absPatch = filepath.Join(root, patchPath)
 	}
 
 	if _, err := os.Stat(absPatch); err != nil {
-		return fmt.Errorf("stat patch: %w", err)
+		return nil, fmt.Errorf("stat patch: %w", err)
 	}
 
 	if err := ensureSupportedPatchFormat(absPatch); err != nil {
-		return err
+		return nil, err
 	}
 
-	cmd := exec.CommandContext(ctx, "git", "apply", "--allow-empty", "--whitespace=nowarn", absPatch)
-	cmd.Dir = root
+	// Workers share one working tree, so applies are queued per root. A
+	// git process outside wptsync can still hold a lock in the surrounding
+	// repository; that is retried with backoff rather than reported as a
+	// broken patch.
+	mu := applyQueue(root)
+	mu.Lock()
+	defer mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !useGit {
+		fuzzed, err := applyUnifiedDiff(root, absPatch, fuzz)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrPatchFailed, err)
+		}
+		return fuzzed, nil
+	}
 
-	output, err := cmd.CombinedOutput()
+	var output []byte
+	var err error
+	delay := applyRetryDelay
+	for attempt := 1; ; attempt++ {
+		output, err = runGitApply(ctx, root, absPatch)
+		if err == nil || attempt == applyAttempts || !isLockContention(output) {
+			break
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
 	if err != nil {
 		out := strings.TrimRight(string(output), " \t\r\n")
 		if out == "" {
-			return fmt.Errorf("%w: %v", ErrPatchFailed, err)
+			return nil, fmt.Errorf("%w: %v", ErrPatchFailed, err)
 		}
-		return fmt.Errorf("%w: %v: %s", ErrPatchFailed, err, out)
+		return nil, fmt.Errorf("%w: %v: %s", ErrPatchFailed, err, out)
 	}
 
-	return nil
+	return nil, nil
+}
+
+// applyAttempts and applyRetryDelay bound the retries of a git apply that
+// failed on lock contention; the delay doubles after each attempt.
+const (
+	applyAttempts   = 5
+	applyRetryDelay = 50 * time.Millisecond
+)
+
+var applyQueues sync.Map // root -> *sync.Mutex
+
+// applyQueue returns the mutex serializing patch application under root.
+func applyQueue(root string) *sync.Mutex {
+	mu, _ := applyQueues.LoadOrStore(root, new(sync.Mutex))
+	return mu.(*sync.Mutex)
+}
+
+// runGitApply runs git apply for patch in dir, returning its combined
+// output. It is a variable so tests can simulate lock contention.
+var runGitApply = func(ctx context.Context, dir, patch string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", "apply", "--allow-empty", "--whitespace=nowarn", patch)
+	cmd.Dir = dir
+	cmd.Env = subprocessEnv()
+	return cmd.CombinedOutput()
+}
+
+// isLockContention reports whether git output says another process holds a
+// lock file (usually .git/index.lock).
+func isLockContention(output []byte) bool {
+	out := string(output)
+	return strings.Contains(out, ".lock': File exists") ||
+		strings.Contains(out, "index.lock") ||
+		strings.Contains(out, "Another git process seems to be running")
 }
 
 func ensureSupportedPatchFormat(path string) error {
@@ -231,7 +1036,7 @@ func ensureSupportedPatchFormat(path string) error {
 			continue
 		}
 		if strings.HasPrefix(line, "*** Begin Patch") {
-			return fmt.Errorf("patch %s looks like apply_patch format; regenerate it with `git diff > %s` so git apply can read it", path, path)
+			return fmt.Errorf("patch %s looks like apply_patch format; regenerate it with `git diff > %s` so it can be applied", path, path)
 		}
 		break
 	}
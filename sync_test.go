@@ -2,6 +2,8 @@ package wptsync
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +13,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 // newFixture starts an httptest.Server that serves content keyed by request
@@ -36,6 +39,14 @@ func newFixture(t *testing.T, content map[string]string) (server *httptest.Serve
 			http.NotFound(w, r)
 			return
 		}
+		// Like raw.githubusercontent.com, the ETag is derived from the
+		// content, so the same file at another commit revalidates.
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(body)))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 		_, _ = w.Write([]byte(body))
 	}))
 	t.Cleanup(srv.Close)
@@ -334,6 +345,58 @@ func TestSyncSkipsDisabledEntry(t *testing.T) {
 	}
 }
 
+func TestSyncKeepsFrozenFiles(t *testing.T) {
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/a/foo.js": "foo at c1\n",
+		"/c2/a/foo.js": "foo at c2\n",
+		"/c2/b/bar.js": "bar at c2\n",
+	})
+	cfg := &Config{
+		Commit:    "c2",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "a/foo.js", Frozen: true}, {Src: "b/bar.js", Frozen: true}},
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+	foo := filepath.Join(dir, "wpt", "a", "foo.js")
+	if err := os.MkdirAll(filepath.Dir(foo), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(foo, []byte("foo, diverged\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, force := range []bool{false, true} {
+		if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Force: force}); err != nil {
+			t.Fatalf("Sync (force %v): %v", force, err)
+		}
+		if got, _ := os.ReadFile(foo); string(got) != "foo, diverged\n" {
+			t.Errorf("frozen a/foo.js = %q after sync (force %v), want the local content", got, force)
+		}
+	}
+	// A frozen file that is missing is downloaded.
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "b", "bar.js")); string(got) != "bar at c2\n" {
+		t.Errorf("missing frozen b/bar.js = %q, want it downloaded", got)
+	}
+	if err := Verify(context.Background(), configPath, nil); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	report, err := Status(context.Background(), configPath, &SyncOptions{Offline: true})
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, f := range report.Files {
+		if !f.Frozen || !f.UpToDate() {
+			t.Errorf("status of %s = %+v, want frozen and up to date", f.Dst, f)
+		}
+	}
+
+	cfg.Files = []FileSpec{{Src: "a/*.js", Dst: "a", Frozen: true}}
+	if err := cfg.check(); err == nil || !strings.Contains(err.Error(), "cannot be frozen") {
+		t.Errorf("check of a frozen glob = %v, want it rejected", err)
+	}
+}
+
 func TestSyncErrorNamesFailingFile(t *testing.T) {
 	server, dir, _ := newFixture(t, map[string]string{}) // every path 404s
 
@@ -344,7 +407,7 @@ func TestSyncErrorNamesFailingFile(t *testing.T) {
 	}
 	configPath := saveTestConfig(t, dir, cfg)
 
-	err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL})
+	err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, APIURL: server.URL})
 	if err == nil {
 		t.Fatal("expected an error for a 404 response")
 	}
@@ -370,3 +433,206 @@ func TestLoadConfigDefaultsDst(t *testing.T) {
 		t.Errorf("Dst = %q, want %q (defaulted from Src)", loaded.Files[0].Dst, "a/foo.js")
 	}
 }
+
+func TestSyncRejectsMisconfiguredTimeouts(t *testing.T) {
+	server, dir, requestCount := newFixture(t, map[string]string{"/c1/a/foo.js": "content A\n"})
+
+	cfg := &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files:     []FileSpec{{Src: "a/foo.js"}},
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	for _, timeouts := range []Timeouts{
+		{Download: -time.Second},
+		{Patch: 30 * time.Nanosecond},
+	} {
+		err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Timeouts: timeouts})
+		if err == nil {
+			t.Errorf("Timeouts %+v: expected validation error", timeouts)
+		}
+	}
+	if requestCount() != 0 {
+		t.Errorf("expected no requests before validation passes, got %d", requestCount())
+	}
+
+	opts := &SyncOptions{BaseURL: server.URL, NoTimeout: true, Timeouts: Timeouts{Download: time.Minute}}
+	if got := opts.timeouts(); got != (Timeouts{}) {
+		t.Errorf("NoTimeout: timeouts() = %+v, want all zero", got)
+	}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync with NoTimeout: %v", err)
+	}
+}
+
+func TestSyncDedupeHardLinksIdenticalFiles(t *testing.T) {
+	content := map[string]string{
+		"/c1/a/helper.js": "shared helper\n",
+		"/c1/b/helper.js": "shared helper\n",
+		"/c1/c/other.js":  "something else\n",
+	}
+	server, dir, _ := newFixture(t, content)
+
+	cfg := &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Dedupe:    true,
+		Files:     []FileSpec{{Src: "a/helper.js"}, {Src: "b/helper.js"}, {Src: "c/other.js"}},
+	}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	stat := func(rel string) os.FileInfo {
+		t.Helper()
+		info, err := os.Stat(filepath.Join(dir, "wpt", filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	if !os.SameFile(stat("a/helper.js"), stat("b/helper.js")) {
+		t.Error("identical files were not hard-linked")
+	}
+	if os.SameFile(stat("a/helper.js"), stat("c/other.js")) {
+		t.Error("different files must not be linked")
+	}
+}
+
+func TestApplyPatchRetriesLockContention(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not on PATH")
+	}
+
+	server, dir, configPath := newPatchFixture(t)
+
+	realApply := runGitApply
+	t.Cleanup(func() { runGitApply = realApply })
+	calls := 0
+	runGitApply = func(ctx context.Context, dir, patch string) ([]byte, error) {
+		calls++
+		if calls < 3 {
+			return []byte("fatal: Unable to create '/repo/.git/index.lock': File exists.\n"), errors.New("exit status 128")
+		}
+		return realApply(ctx, dir, patch)
+	}
+
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, UseGit: true}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("git apply ran %d times, want 3", calls)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "wpt", "patch", "target.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "line2-patched") {
+		t.Errorf("patch not applied after retries: %q", got)
+	}
+
+	// Other failures are not retried.
+	calls = 0
+	runGitApply = func(ctx context.Context, dir, patch string) ([]byte, error) {
+		calls++
+		return []byte("error: patch failed: wpt/patch/target.js:1\n"), errors.New("exit status 1")
+	}
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL, Force: true, UseGit: true}); !errors.Is(err, ErrPatchFailed) {
+		t.Errorf("Sync with a broken patch: expected ErrPatchFailed, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("broken patch applied %d times, want 1", calls)
+	}
+}
+
+func TestSyncOnlyAndSkip(t *testing.T) {
+	content := map[string]string{}
+	for _, src := range []string{"url/a.js", "url/resources/b.js", "url/c.html", "encoding/d.js"} {
+		content["/c1/"+src] = src + " v1\n"
+		content["/c2/"+src] = src + " v2\n"
+	}
+	server, dir, _ := newFixture(t, content)
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{
+		{Src: "url/a.js"}, {Src: "url/resources/b.js"}, {Src: "url/c.html"}, {Src: "encoding/d.js"},
+	}}
+	configPath := saveTestConfig(t, dir, cfg)
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	cfg.Commit = "c2"
+	saveTestConfig(t, dir, cfg)
+	opts := &SyncOptions{BaseURL: server.URL, Only: []string{"url/**"}, Skip: []string{"*.html"}, DryRun: true}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync -dry-run: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "url", "a.js")); string(got) != "url/a.js v1\n" {
+		t.Errorf("a.js after a dry run = %q, want it untouched", got)
+	}
+
+	opts.DryRun = false
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	for src, want := range map[string]string{
+		"url/a.js":           "url/a.js v2\n",
+		"url/resources/b.js": "url/resources/b.js v2\n",
+		"url/c.html":         "url/c.html v1\n",
+		"encoding/d.js":      "encoding/d.js v1\n",
+	} {
+		if got, _ := os.ReadFile(filepath.Join(dir, "wpt", filepath.FromSlash(src))); string(got) != want {
+			t.Errorf("%s = %q, want %q", src, got, want)
+		}
+	}
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files left out are still at c1, which the lock cannot record.
+	if _, ok := lock.Files["encoding/d.js"]; ok || len(lock.Files) != 2 {
+		t.Errorf("lock has %d entries, want only the 2 synced at c2", len(lock.Files))
+	}
+
+	// The files left out are synced by the next full sync.
+	if err := Sync(context.Background(), configPath, &SyncOptions{BaseURL: server.URL}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "encoding", "d.js")); string(got) != "encoding/d.js v2\n" {
+		t.Errorf("d.js after a full sync = %q, want v2", got)
+	}
+
+	// At an unchanged commit, the entries left out are kept.
+	opts.Only = []string{"encoding/d.js"}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if lock, err = loadLock(lockPath(configPath)); err != nil {
+		t.Fatal(err)
+	}
+	if len(lock.Files) != 4 {
+		t.Errorf("lock has %d entries, want 4", len(lock.Files))
+	}
+}
+
+func TestMatchFilterDoubleStar(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"url/**", "url/a.js", true},
+		{"url/**", "url/resources/b.js", true},
+		{"url/**", "urlpattern/a.js", false},
+		{"**/resources/*.js", "url/resources/b.js", true},
+		{"**/resources/*.js", "resources/b.js", true},
+		{"url/**/b.js", "url/b.js", true},
+		{"url/*", "url/resources/b.js", false},
+		{"*.js", "url/resources/b.js", true},
+	} {
+		if got := matchFilter(tc.pattern, tc.path); got != tc.want {
+			t.Errorf("matchFilter(%q, %q) = %v, want %v", tc.pattern, tc.path, got, tc.want)
+		}
+	}
+}
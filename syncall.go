@@ -0,0 +1,160 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RunLockName is the file SyncAll holds under its root while it runs, so
+// that concurrent runs in the same repository take turns.
+const RunLockName = ".wptsync-run.lock"
+
+// runLockStale is how long a run lock may go without its holder refreshing
+// it before it is considered abandoned by a crashed run.
+const runLockStale = time.Minute
+
+// FindConfigs returns the configuration files named name (such as
+// "wpt.json") under root, sorted. Hidden directories and node_modules are
+// not searched.
+func FindConfigs(root, name string) ([]string, error) {
+	var configs []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && (strings.HasPrefix(d.Name(), ".") || d.Name() == "node_modules") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Name() == name {
+			configs = append(configs, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find configs under %s: %w", root, err)
+	}
+	return configs, nil
+}
+
+// SyncAll syncs every configuration named name under root (see FindConfigs)
+// one after another, as Sync does, and returns their paths. The configs share
+// one download cache, so a file several of them use at the same commit is
+// fetched once: opts.CacheDir, or a temporary directory for this run when it
+// is empty. A run lock under root (RunLockName) makes concurrent SyncAll
+// runs wait for each other. A config that fails to sync does not stop the
+// others; the failures are returned together. opts.Report, if set, describes
+// the last config synced.
+func SyncAll(ctx context.Context, root, name string, opts *SyncOptions) ([]string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	configs, err := FindConfigs(root, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(configs) == 0 {
+		return nil, invalidConfig(fmt.Errorf("no %s found under %s", name, root))
+	}
+
+	var cp SyncOptions
+	if opts != nil {
+		cp = *opts
+	}
+	opts = &cp
+	if opts.CacheDir == "" && !opts.DryRun {
+		if opts.CacheDir, err = os.MkdirTemp("", "wptsync-cache-"); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(opts.CacheDir)
+	}
+
+	if !opts.DryRun {
+		unlock, err := acquireRunLock(ctx, filepath.Join(root, RunLockName), opts)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	var errs []error
+	for _, configPath := range configs {
+		if err := ctx.Err(); err != nil {
+			return configs, err
+		}
+		rel, err := filepath.Rel(root, configPath)
+		if err != nil {
+			rel = configPath
+		}
+		opts.logf("== %s\n", rel)
+		if err := Sync(ctx, configPath, opts); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", rel, err))
+		}
+	}
+	return configs, errors.Join(errs...)
+}
+
+// acquireRunLock creates the lock file at p, waiting while another run holds
+// it, and returns a function that releases it. The holder refreshes the
+// file's modification time while it runs, so a lock left behind by a crash
+// is taken over once it is runLockStale old.
+func acquireRunLock(ctx context.Context, p string, opts *SyncOptions) (func(), error) {
+	waited := false
+	for {
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("create run lock: %w", err)
+		}
+		if info, err := os.Stat(p); err == nil && time.Since(info.ModTime()) > runLockStale {
+			opts.logf("Removing stale run lock %s\n", p)
+			os.Remove(p)
+			continue
+		}
+		if !waited {
+			holder, _ := os.ReadFile(p)
+			opts.logf("Waiting for another wptsync run (pid %s) to finish...\n", strings.TrimSpace(string(holder)))
+			waited = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(runLockStale / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(p, now, now)
+			}
+		}
+	}()
+	pid := strconv.Itoa(os.Getpid())
+	return func() {
+		close(done)
+		// Only remove the lock if it is still ours.
+		if holder, err := os.ReadFile(p); err == nil && strings.TrimSpace(string(holder)) == pid {
+			os.Remove(p)
+		}
+	}, nil
+}
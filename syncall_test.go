@@ -0,0 +1,79 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncAllFetchesSharedFilesOnce(t *testing.T) {
+	sha := strings.Repeat("a", 40)
+	server, root, requestCount := newFixture(t, map[string]string{
+		"/" + sha + "/common/shared.js": "shared\n",
+		"/" + sha + "/url/a.js":         "a\n",
+	})
+	for _, pkg := range []string{"pkg1", "pkg2", ".git"} {
+		if err := os.MkdirAll(filepath.Join(root, pkg), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pkg := range []string{"pkg1", "pkg2"} {
+		files := []FileSpec{{Src: "common/shared.js"}}
+		if pkg == "pkg1" {
+			files = append(files, FileSpec{Src: "url/a.js"})
+		}
+		saveTestConfig(t, filepath.Join(root, pkg), &Config{Commit: sha, TargetDir: "wpt", Files: files})
+	}
+	// Hidden directories are not searched.
+	saveTestConfig(t, filepath.Join(root, ".git"), &Config{Commit: sha, TargetDir: "wpt"})
+
+	configs, err := SyncAll(context.Background(), root, "wpt.json", &SyncOptions{BaseURL: server.URL, APIURL: server.URL})
+	if err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+	want := []string{filepath.Join(root, "pkg1", "wpt.json"), filepath.Join(root, "pkg2", "wpt.json")}
+	if !slices.Equal(configs, want) {
+		t.Errorf("configs = %q, want %q", configs, want)
+	}
+	if got := requestCount(); got != 2 {
+		t.Errorf("made %d requests, want 2 (one per unique file)", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(root, "pkg2", "wpt", "common", "shared.js")); string(got) != "shared\n" {
+		t.Errorf("pkg2 shared.js = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, RunLockName)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("run lock left behind: %v", err)
+	}
+}
+
+func TestRunLockWaitsForHolder(t *testing.T) {
+	p := filepath.Join(t.TempDir(), RunLockName)
+	unlock, err := acquireRunLock(context.Background(), p, nil)
+	if err != nil {
+		t.Fatalf("acquireRunLock: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := acquireRunLock(ctx, p, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("second acquireRunLock = %v, want it to wait until the deadline", err)
+	}
+	unlock()
+
+	// A lock nobody refreshed for a while was left by a crashed run.
+	if err := os.WriteFile(p, []byte("1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * runLockStale)
+	os.Chtimes(p, old, old)
+	unlock, err = acquireRunLock(context.Background(), p, nil)
+	if err != nil {
+		t.Fatalf("acquireRunLock over a stale lock: %v", err)
+	}
+	unlock()
+}
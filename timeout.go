@@ -0,0 +1,75 @@
+package wptsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Timeouts bounds each phase of a run. Every phase deadline is relative to
+// the moment the phase starts, so a long sync that keeps making progress is
+// never killed by an earlier phase's budget.
+type Timeouts struct {
+	// Resolve bounds each GitHub API lookup (latest commit, tree listings).
+	Resolve time.Duration
+	// Download bounds each individual file download.
+	Download time.Duration
+	// Patch bounds each patch application or diff.
+	Patch time.Duration
+}
+
+// DefaultTimeouts are the per-phase deadlines used for any Timeouts field
+// left at zero.
+var DefaultTimeouts = Timeouts{
+	Resolve:  30 * time.Second,
+	Download: 30 * time.Second,
+	Patch:    30 * time.Second,
+}
+
+// minTimeout is the smallest accepted phase deadline. Anything shorter is
+// almost certainly a unit mistake (e.g. "30ns" instead of "30s") and would
+// fail every request before it could complete.
+const minTimeout = time.Second
+
+func (t Timeouts) validate() error {
+	for _, p := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"resolve", t.Resolve},
+		{"download", t.Download},
+		{"patch", t.Patch},
+	} {
+		if p.d < 0 {
+			return fmt.Errorf("%s timeout must not be negative (got %s)", p.name, p.d)
+		}
+		if p.d != 0 && p.d < minTimeout {
+			return fmt.Errorf("%s timeout %s is shorter than %s; did you forget a unit?", p.name, p.d, minTimeout)
+		}
+	}
+	return nil
+}
+
+// withDefaults fills zero fields from DefaultTimeouts.
+func (t Timeouts) withDefaults() Timeouts {
+	if t.Resolve == 0 {
+		t.Resolve = DefaultTimeouts.Resolve
+	}
+	if t.Download == 0 {
+		t.Download = DefaultTimeouts.Download
+	}
+	if t.Patch == 0 {
+		t.Patch = DefaultTimeouts.Patch
+	}
+	return t
+}
+
+// withTimeout derives a context bounded by d, or a plain cancelable context
+// when d is zero (no timeout). context.WithTimeout measures d against the
+// monotonic clock, so wall-clock adjustments mid-run cannot fire it early.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
@@ -0,0 +1,675 @@
+package wptsync
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The TOML support covers TOML 1.0 but for dates and times, which no
+// configuration field takes, and the floats inf and nan, which JSON cannot
+// represent. Comments inside arrays and inline tables are dropped.
+
+type tomlParser struct {
+	s    string
+	pos  int
+	line int
+	// pending holds the comments read since the last key or table header,
+	// for the next one; header those at the top of the file, separated from
+	// the first key or table by a blank line.
+	pending []string
+	header  []string
+	started bool
+	// defined holds the tables with a header or an inline definition, which
+	// cannot be defined again.
+	defined map[*cfgNode]bool
+}
+
+// parseTOML parses a configuration file in TOML.
+func parseTOML(data []byte) (*cfgNode, error) {
+	p := &tomlParser{s: strings.ReplaceAll(string(data), "\r\n", "\n"), line: 1, defined: map[*cfgNode]bool{}}
+	root := &cfgNode{kind: mapNode, line: 1}
+	table := root
+	for {
+		blank := true
+		p.space()
+		for p.pos < len(p.s) && p.s[p.pos] != '\n' {
+			blank = false
+			var err error
+			switch p.s[p.pos] {
+			case '#':
+				p.pending = append(p.pending, p.comment())
+				continue
+			case '[':
+				table, err = p.tableHeader(root)
+			default:
+				err = p.keyValue(table)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", p.line, err)
+			}
+			p.space()
+			if p.pos < len(p.s) && p.s[p.pos] != '\n' && p.s[p.pos] != '#' {
+				return nil, fmt.Errorf("line %d: unexpected %q", p.line, p.rest())
+			}
+		}
+		if blank && !p.started {
+			p.header = append(p.header, p.pending...)
+			p.pending = nil
+		}
+		if p.pos >= len(p.s) {
+			break
+		}
+		p.pos++
+		p.line++
+	}
+	root.comments = p.header
+	root.inline = strings.Join(p.pending, "\n")
+	return root, nil
+}
+
+func (p *tomlParser) space() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// rest returns the rest of the current line.
+func (p *tomlParser) rest() string {
+	end := strings.IndexByte(p.s[p.pos:], '\n')
+	if end < 0 {
+		return p.s[p.pos:]
+	}
+	return p.s[p.pos : p.pos+end]
+}
+
+// comment reads a comment up to the end of its line, and returns its text
+// without the "#".
+func (p *tomlParser) comment() string {
+	c := p.rest()
+	p.pos += len(c)
+	return c[1:]
+}
+
+// trailing reads the comment at the end of the current line, if any.
+func (p *tomlParser) trailing() string {
+	p.space()
+	if p.pos < len(p.s) && p.s[p.pos] == '#' {
+		return p.comment()
+	}
+	return ""
+}
+
+// comments returns the comments collected for the key or table about to be
+// read.
+func (p *tomlParser) comments() []string {
+	p.started = true
+	c := p.pending
+	p.pending = nil
+	return c
+}
+
+// tableHeader reads a [table] or [[array.of.tables]] header, and returns the
+// table the keys that follow go into.
+func (p *tomlParser) tableHeader(root *cfgNode) (*cfgNode, error) {
+	line := p.line
+	array := strings.HasPrefix(p.s[p.pos:], "[[")
+	p.pos++
+	if array {
+		p.pos++
+	}
+	keys, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	if !strings.HasPrefix(p.s[p.pos:], closing) {
+		return nil, fmt.Errorf("expected %q after the table name", closing)
+	}
+	p.pos += len(closing)
+
+	parent := root
+	for _, key := range keys[:len(keys)-1] {
+		if parent, err = p.descend(parent, key, line); err != nil {
+			return nil, err
+		}
+	}
+	last := keys[len(keys)-1]
+	existing := parent.get(last)
+	node := &cfgNode{kind: mapNode, line: line, comments: p.comments()}
+	switch {
+	case array && existing == nil:
+		if err := parent.set(last, &cfgNode{kind: listNode, line: line, vals: []*cfgNode{node}}); err != nil {
+			return nil, err
+		}
+		p.defined[parent.get(last)] = true
+	case array:
+		if existing.kind != listNode || !p.defined[existing] {
+			return nil, fmt.Errorf("%q is not an array of tables", strings.Join(keys, "."))
+		}
+		existing.vals = append(existing.vals, node)
+	case existing == nil:
+		if err := parent.set(last, node); err != nil {
+			return nil, err
+		}
+	default:
+		if existing.kind != mapNode || p.defined[existing] {
+			return nil, fmt.Errorf("table %q is defined twice", strings.Join(keys, "."))
+		}
+		existing.line, existing.comments = line, node.comments
+		node = existing
+	}
+	p.defined[node] = true
+	node.inline = p.trailing()
+	return node, nil
+}
+
+// descend returns the table key names in parent, creating it if need be;
+// in an array of tables, that is its last table.
+func (p *tomlParser) descend(parent *cfgNode, key string, line int) (*cfgNode, error) {
+	v := parent.get(key)
+	switch {
+	case v == nil:
+		v = &cfgNode{kind: mapNode, line: line}
+		parent.set(key, v)
+		return v, nil
+	case v.kind == mapNode:
+		return v, nil
+	case v.kind == listNode && p.defined[v] && len(v.vals) > 0:
+		return v.vals[len(v.vals)-1], nil
+	}
+	return nil, fmt.Errorf("key %q is not a table", key)
+}
+
+// keyValue reads "key = value" into table.
+func (p *tomlParser) keyValue(table *cfgNode) error {
+	line := p.line
+	comments := p.comments()
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if p.pos >= len(p.s) || p.s[p.pos] != '=' {
+		return fmt.Errorf("expected \"=\" after %q", strings.Join(keys, "."))
+	}
+	p.pos++
+	p.space()
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	v.line, v.comments = line, comments
+	for _, key := range keys[:len(keys)-1] {
+		if table, err = p.descend(table, key, line); err != nil {
+			return err
+		}
+		if p.defined[table] && table.line != line {
+			return fmt.Errorf("table %q is already defined", key)
+		}
+	}
+	if err := table.set(keys[len(keys)-1], v); err != nil {
+		return fmt.Errorf("duplicate key %q", strings.Join(keys, "."))
+	}
+	v.inline = p.trailing()
+	return nil
+}
+
+var tomlBareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+`)
+
+// key reads a key, dotted or not, and returns its parts.
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.space()
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("expected a key")
+		}
+		switch c := p.s[p.pos]; {
+		case c == '"' || c == '\'':
+			if strings.HasPrefix(p.s[p.pos:], `"""`) || strings.HasPrefix(p.s[p.pos:], "'''") {
+				return nil, fmt.Errorf("keys cannot be multi-line strings")
+			}
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, s)
+		default:
+			bare := tomlBareKey.FindString(p.s[p.pos:])
+			if bare == "" {
+				return nil, fmt.Errorf("expected a key, found %q", p.rest())
+			}
+			keys = append(keys, bare)
+			p.pos += len(bare)
+		}
+		p.space()
+		if p.pos >= len(p.s) || p.s[p.pos] != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+// value reads a value, which may span lines (multi-line strings and
+// arrays).
+func (p *tomlParser) value() (*cfgNode, error) {
+	if p.pos >= len(p.s) || p.s[p.pos] == '\n' {
+		return nil, fmt.Errorf("expected a value")
+	}
+	switch c := p.s[p.pos]; c {
+	case '"', '\'':
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return &cfgNode{value: s}, nil
+	case '[':
+		p.pos++
+		n := &cfgNode{kind: listNode}
+		for {
+			p.skipInArray()
+			if p.pos >= len(p.s) {
+				return nil, fmt.Errorf("unterminated array")
+			}
+			if p.s[p.pos] == ']' {
+				p.pos++
+				return n, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			v.line = p.line
+			n.vals = append(n.vals, v)
+			p.skipInArray()
+			switch {
+			case p.pos < len(p.s) && p.s[p.pos] == ',':
+				p.pos++
+			case p.pos < len(p.s) && p.s[p.pos] == ']':
+			default:
+				return nil, fmt.Errorf("expected \",\" or \"]\" in array")
+			}
+		}
+	case '{':
+		p.pos++
+		n := &cfgNode{kind: mapNode}
+		p.defined[n] = true
+		p.space()
+		if p.pos < len(p.s) && p.s[p.pos] == '}' {
+			p.pos++
+			return n, nil
+		}
+		for {
+			keys, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			if p.pos >= len(p.s) || p.s[p.pos] != '=' {
+				return nil, fmt.Errorf("expected \"=\" after %q", strings.Join(keys, "."))
+			}
+			p.pos++
+			p.space()
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			v.line = p.line
+			table := n
+			for _, key := range keys[:len(keys)-1] {
+				if table, err = p.descend(table, key, p.line); err != nil {
+					return nil, err
+				}
+			}
+			if err := table.set(keys[len(keys)-1], v); err != nil {
+				return nil, fmt.Errorf("duplicate key %q", strings.Join(keys, "."))
+			}
+			p.space()
+			if p.pos < len(p.s) && p.s[p.pos] == '}' {
+				p.pos++
+				return n, nil
+			}
+			if p.pos >= len(p.s) || p.s[p.pos] != ',' {
+				return nil, fmt.Errorf("expected \",\" or \"}\" in inline table (which must fit on one line)")
+			}
+			p.pos++
+		}
+	}
+
+	end := p.pos
+	for end < len(p.s) && strings.IndexByte("0123456789abcdefABCDEFoxinftrulsTZ_+-.:", p.s[end]) >= 0 {
+		end++
+	}
+	word := p.s[p.pos:end]
+	p.pos = end
+	switch word {
+	case "true", "false":
+		return &cfgNode{value: word, plain: true}, nil
+	case "inf", "+inf", "-inf", "nan", "+nan", "-nan":
+		return nil, fmt.Errorf("%s cannot be represented in JSON", word)
+	case "":
+		return nil, fmt.Errorf("unexpected %q", p.rest())
+	}
+	if tomlDate.MatchString(word) {
+		return nil, fmt.Errorf("dates and times are not supported")
+	}
+	if n, ok := tomlNumber(word); ok {
+		return &cfgNode{value: n, plain: true}, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", word)
+}
+
+var tomlDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}|^\d{2}:\d{2}`)
+
+// tomlNumber returns TOML integer or float s as JSON writes it.
+func tomlNumber(s string) (string, bool) {
+	if strings.HasPrefix(s, "_") || strings.HasSuffix(s, "_") || strings.Contains(s, "__") {
+		return "", false
+	}
+	s = strings.ReplaceAll(s, "_", "")
+	for prefix, base := range map[string]int{"0x": 16, "0o": 8, "0b": 2} {
+		if digits, ok := strings.CutPrefix(s, prefix); ok {
+			n, err := strconv.ParseUint(digits, base, 64)
+			return strconv.FormatUint(n, 10), err == nil
+		}
+	}
+	s = strings.TrimPrefix(s, "+")
+	digits := strings.TrimPrefix(s, "-")
+	if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' && digits[1] != 'e' && digits[1] != 'E' {
+		return "", false
+	}
+	return s, isJSONNumber(s)
+}
+
+// skipInArray skips the whitespace, newlines, and comments between the
+// values of an array.
+func (p *tomlParser) skipInArray() {
+	for p.pos < len(p.s) {
+		switch p.s[p.pos] {
+		case ' ', '\t':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			p.comment()
+		default:
+			return
+		}
+	}
+}
+
+// str reads a basic or literal string, single- or multi-line.
+func (p *tomlParser) str() (string, error) {
+	quote := p.s[p.pos]
+	delim := string(quote)
+	multi := strings.HasPrefix(p.s[p.pos:], strings.Repeat(delim, 3))
+	if multi {
+		delim = strings.Repeat(delim, 3)
+	}
+	p.pos += len(delim)
+	if multi && p.pos < len(p.s) && p.s[p.pos] == '\n' {
+		p.pos++
+		p.line++
+	}
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		if strings.HasPrefix(p.s[p.pos:], delim) {
+			p.pos += len(delim)
+			// A multi-line string may end with up to two quotes of its own.
+			for i := 0; multi && i < 2 && p.pos < len(p.s) && p.s[p.pos] == quote; i++ {
+				b.WriteByte(quote)
+				p.pos++
+			}
+			return b.String(), nil
+		}
+		c := p.s[p.pos]
+		switch {
+		case c == '\n' && !multi:
+			return "", fmt.Errorf("unterminated string")
+		case c == '\n':
+			p.line++
+		case c == '\\' && quote == '"':
+			p.pos++
+			if err := p.escape(&b, multi); err != nil {
+				return "", err
+			}
+			continue
+		}
+		b.WriteByte(c)
+		p.pos++
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// escape reads the escape sequence after a backslash in a basic string.
+func (p *tomlParser) escape(b *strings.Builder, multi bool) error {
+	if p.pos >= len(p.s) {
+		return fmt.Errorf("unterminated string")
+	}
+	c := p.s[p.pos]
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case 'e':
+		b.WriteByte(0x1b)
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if p.pos+size > len(p.s) {
+			return fmt.Errorf("truncated escape \\%c", c)
+		}
+		r, err := strconv.ParseUint(p.s[p.pos:p.pos+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return fmt.Errorf("bad escape \\%c%s", c, p.s[p.pos:p.pos+size])
+		}
+		b.WriteRune(rune(r))
+		p.pos += size
+	case ' ', '\t', '\n':
+		// A line-ending backslash in a multi-line string drops the newline
+		// and the whitespace that follows.
+		if !multi {
+			return fmt.Errorf("unknown escape \\%c", c)
+		}
+		p.pos--
+		for p.pos < len(p.s) && strings.IndexByte(" \t\n", p.s[p.pos]) >= 0 {
+			if p.s[p.pos] == '\n' {
+				p.line++
+			}
+			p.pos++
+		}
+	default:
+		return fmt.Errorf("unknown escape \\%c", c)
+	}
+	return nil
+}
+
+// emitTOML writes root, a configuration, as TOML, with the comments of the
+// file it replaces at the paths they were found at (see collectComments).
+func emitTOML(root *cfgNode, comments map[string]*cfgNode) []byte {
+	e := &tomlEmitter{comments: comments}
+	if c := comments[""]; c != nil && len(c.comments) > 0 {
+		e.comment(c.comments)
+		e.buf.WriteByte('\n')
+	}
+	e.table(root, "", nil)
+	if c := comments[""]; c != nil && c.inline != "" {
+		e.comment(strings.Split(c.inline, "\n"))
+	}
+	return e.buf.Bytes()
+}
+
+type tomlEmitter struct {
+	buf      bytes.Buffer
+	comments map[string]*cfgNode
+}
+
+func (e *tomlEmitter) comment(lines []string) {
+	for _, c := range lines {
+		fmt.Fprintf(&e.buf, "#%s\n", c)
+	}
+}
+
+// head writes the comments kept for path, if any, and returns the comment
+// for the end of its line.
+func (e *tomlEmitter) head(path string) string {
+	c := e.comments[path]
+	if c == nil {
+		return ""
+	}
+	e.comment(c.comments)
+	if c.inline != "" {
+		return " #" + c.inline
+	}
+	return ""
+}
+
+// table writes the keys of table n, whose name is names: first those whose
+// values fit on a line, then its tables and arrays of tables.
+func (e *tomlEmitter) table(n *cfgNode, path string, names []string) {
+	for i, key := range n.keys {
+		v := n.vals[i]
+		if isTOMLTable(v) || isTOMLTableArray(v) || v.kind == scalarNode && v.plain && v.value == "null" {
+			continue
+		}
+		p := path + "." + key
+		inline := e.head(p)
+		fmt.Fprintf(&e.buf, "%s = %s%s\n", tomlKey(key), tomlValue(v), inline)
+	}
+	for i, key := range n.keys {
+		v := n.vals[i]
+		p := path + "." + key
+		name := tomlName(append(names[:len(names):len(names)], key))
+		switch {
+		case isTOMLTable(v):
+			e.buf.WriteByte('\n')
+			inline := e.head(p)
+			fmt.Fprintf(&e.buf, "[%s]%s\n", name, inline)
+			e.table(v, p, append(names[:len(names):len(names)], key))
+		case isTOMLTableArray(v):
+			for j, item := range v.vals {
+				ip := v.itemPath(p, j)
+				e.buf.WriteByte('\n')
+				inline := e.head(ip)
+				fmt.Fprintf(&e.buf, "[[%s]]%s\n", name, inline)
+				e.table(item, ip, append(names[:len(names):len(names)], key))
+			}
+		}
+	}
+}
+
+func isTOMLTable(v *cfgNode) bool {
+	return v.kind == mapNode && len(v.keys) > 0
+}
+
+// isTOMLTableArray reports whether v is a list of tables, such as the
+// files of a configuration, written as [[files]].
+func isTOMLTableArray(v *cfgNode) bool {
+	if v.kind != listNode || len(v.vals) == 0 {
+		return false
+	}
+	for _, item := range v.vals {
+		if item.kind != mapNode {
+			return false
+		}
+	}
+	return true
+}
+
+func tomlName(keys []string) string {
+	quoted := make([]string, len(keys))
+	for i, k := range keys {
+		quoted[i] = tomlKey(k)
+	}
+	return strings.Join(quoted, ".")
+}
+
+func tomlKey(key string) string {
+	if tomlBareKey.FindString(key) == key && key != "" {
+		return key
+	}
+	return tomlQuote(key)
+}
+
+// tomlValue writes v on a line, arrays of more than a few short items over
+// several.
+func tomlValue(v *cfgNode) string {
+	switch v.kind {
+	case mapNode:
+		parts := make([]string, len(v.keys))
+		for i, key := range v.keys {
+			parts[i] = tomlKey(key) + " = " + tomlValue(v.vals[i])
+		}
+		if len(parts) == 0 {
+			return "{}"
+		}
+		return "{ " + strings.Join(parts, ", ") + " }"
+	case listNode:
+		parts := make([]string, len(v.vals))
+		for i, item := range v.vals {
+			parts[i] = tomlValue(item)
+		}
+		line := "[" + strings.Join(parts, ", ") + "]"
+		if len(line) <= 80 {
+			return line
+		}
+		return "[\n  " + strings.Join(parts, ",\n  ") + ",\n]"
+	}
+	if v.plain {
+		return v.value
+	}
+	if strings.Contains(v.value, "\n") && !strings.Contains(v.value, "'''") && !strings.HasSuffix(v.value, "'") &&
+		yamlPrintable(strings.ReplaceAll(v.value, "\n", "")) {
+		return "'''\n" + v.value + "'''"
+	}
+	return tomlQuote(v.value)
+}
+
+// tomlQuote writes s as a basic string.
+func tomlQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < ' ' || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
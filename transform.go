@@ -0,0 +1,86 @@
+package wptsync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// Transform is a deterministic text rewrite applied to a file right after
+// it is downloaded, before its patch, so that trivial edits need no patch.
+// Exactly one of Replace and DeleteLines is set.
+type Transform struct {
+	// Replace is a regular expression (Go RE2 syntax) whose every match is
+	// replaced with With, in which $1 or ${name} stand for submatches.
+	Replace string `json:"replace,omitempty"`
+	With    string `json:"with,omitempty"`
+	// DeleteLines is a regular expression; every line it matches is
+	// removed, line ending included.
+	DeleteLines string `json:"delete_lines,omitempty"`
+}
+
+// compile returns t's regular expression, checking that t sets exactly one
+// rule.
+func (t Transform) compile() (*regexp.Regexp, error) {
+	switch {
+	case t.Replace != "" && t.DeleteLines != "":
+		return nil, errors.New("transform sets both replace and delete_lines")
+	case t.Replace != "":
+		return regexp.Compile(t.Replace)
+	case t.DeleteLines != "":
+		if t.With != "" {
+			return nil, errors.New("transform sets with without replace")
+		}
+		return regexp.Compile(t.DeleteLines)
+	}
+	return nil, errors.New("transform sets neither replace nor delete_lines")
+}
+
+// apply returns content rewritten by t, whose regular expression is re.
+func (t Transform) apply(re *regexp.Regexp, content []byte) []byte {
+	if t.Replace != "" {
+		return re.ReplaceAll(content, []byte(t.With))
+	}
+	var out []byte
+	for line := range bytes.Lines(content) {
+		if !re.Match(bytes.TrimRight(line, "\r\n")) {
+			out = append(out, line...)
+		}
+	}
+	return out
+}
+
+// applyTransforms rewrites the file at p with file's transforms, in order.
+func applyTransforms(p string, file FileSpec) error {
+	if len(file.Transforms) == 0 {
+		return nil
+	}
+	content, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	for i, t := range file.Transforms {
+		re, err := t.compile()
+		if err != nil {
+			return fmt.Errorf("transform %d: %w", i+1, err)
+		}
+		content = t.apply(re, content)
+	}
+	return writeFileAtomic(p, content, 0o644)
+}
+
+// transformsHash returns the SHA-256 of file's transforms, or "" if it has
+// none, so that the lock notices when they change.
+func transformsHash(file FileSpec) string {
+	if len(file.Transforms) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(file.Transforms)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,60 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncAppliesTransforms(t *testing.T) {
+	server, dir, requestCount := newFixture(t, map[string]string{
+		"/c1/a.js": "// META: timeout=long\nimport '/resources/x.js';\r\nfoo();\n",
+	})
+	cfg := &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{
+		Src: "a.js",
+		Transforms: []Transform{
+			{DeleteLines: `^// META:`},
+			{Replace: `'/resources/(\w+)\.js'`, With: `'./resources/$1.js'`},
+		},
+	}}}
+	configPath := saveTestConfig(t, dir, cfg)
+
+	opts := &SyncOptions{BaseURL: server.URL}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	want := "import './resources/x.js';\r\nfoo();\n"
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "a.js")); string(got) != want {
+		t.Errorf("a.js = %q, want %q", got, want)
+	}
+
+	// Changing the transforms invalidates the lock entry.
+	cfg.Files[0].Transforms = cfg.Files[0].Transforms[:1]
+	saveTestConfig(t, dir, cfg)
+	before := requestCount()
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if requestCount() == before {
+		t.Error("editing transforms did not resync the file")
+	}
+	want = "import '/resources/x.js';\r\nfoo();\n"
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "a.js")); string(got) != want {
+		t.Errorf("a.js after edit = %q, want %q", got, want)
+	}
+}
+
+func TestConfigRejectsInvalidTransforms(t *testing.T) {
+	for _, tr := range []Transform{
+		{},
+		{Replace: "a", DeleteLines: "b"},
+		{DeleteLines: "a", With: "b"},
+		{Replace: "("},
+	} {
+		cfg := &Config{Commit: "c1", Files: []FileSpec{{Src: "a.js", Dst: "a.js", Transforms: []Transform{tr}}}}
+		if err := cfg.validate(); err == nil {
+			t.Errorf("transform %+v: expected validation error", tr)
+		}
+	}
+}
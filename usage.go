@@ -0,0 +1,84 @@
+package wptsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Usage holds local counters of how wptsync is used, kept only when the
+// user opts in and never sent anywhere. Only command and flag names are
+// counted, never flag values, paths, or repositories.
+type Usage struct {
+	// Since is when counting started.
+	Since time.Time `json:"since"`
+	// Version is the wptsync version that last recorded a run.
+	Version string `json:"wptsync_version"`
+	// Commands counts runs of each command.
+	Commands map[string]int `json:"commands"`
+	// Flags counts, per command, the runs that set each flag, keyed
+	// "command -flag".
+	Flags map[string]int `json:"flags"`
+}
+
+// DefaultUsagePath returns where usage counters are kept: usage.json under
+// the user's configuration directory (e.g. ~/.config/wptsync on Linux), or
+// "" if there is none.
+func DefaultUsagePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "wptsync", "usage.json")
+}
+
+// LoadUsage reads the usage counters at path. A missing file yields empty
+// counters.
+func LoadUsage(path string) (*Usage, error) {
+	u := &Usage{Commands: map[string]int{}, Flags: map[string]int{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read usage: %w", err)
+	}
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, fmt.Errorf("decode usage %s: %w", path, err)
+	}
+	if u.Commands == nil {
+		u.Commands = map[string]int{}
+	}
+	if u.Flags == nil {
+		u.Flags = map[string]int{}
+	}
+	return u, nil
+}
+
+// RecordUsage counts one run of command with flags set in the usage file at
+// path.
+func RecordUsage(path, command string, flags []string) error {
+	u, err := LoadUsage(path)
+	if err != nil {
+		return err
+	}
+	if u.Since.IsZero() {
+		u.Since = time.Now().UTC().Truncate(time.Second)
+	}
+	u.Version = moduleVersion()
+	u.Commands[command]++
+	for _, name := range flags {
+		u.Flags[command+" -"+name]++
+	}
+	data, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write usage: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package wptsync
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordUsage(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "wptsync", "usage.json")
+	if err := RecordUsage(p, "sync", []string{"dry-run"}); err != nil {
+		t.Fatalf("RecordUsage: %v", err)
+	}
+	if err := RecordUsage(p, "sync", nil); err != nil {
+		t.Fatalf("second RecordUsage: %v", err)
+	}
+	u, err := LoadUsage(p)
+	if err != nil {
+		t.Fatalf("LoadUsage: %v", err)
+	}
+	if u.Commands["sync"] != 2 || u.Flags["sync -dry-run"] != 1 || u.Since.IsZero() {
+		t.Errorf("usage = %+v, want 2 syncs, 1 with -dry-run", u)
+	}
+}
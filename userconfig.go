@@ -0,0 +1,84 @@
+package wptsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// UserConfig is the user-level configuration of the wptsync command, shared
+// by every project: command aliases and what a bare `wptsync` runs. It is
+// not to be confused with a project's wpt.json.
+type UserConfig struct {
+	// DefaultCommand is the command, with any arguments, run when wptsync
+	// is given no command, such as "status" for teams that would rather
+	// not sync by accident. It may name an alias. Empty means "sync".
+	DefaultCommand string `json:"default_command,omitempty"`
+	// Aliases maps a name to the command line, split on whitespace, it
+	// stands for, such as "up": "update -check". An alias cannot refer to
+	// another alias.
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+// DefaultUserConfigPath returns where the user configuration is read from:
+// config under the user's configuration directory (e.g. ~/.config/wptsync
+// on Linux), or "" if there is none.
+func DefaultUserConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "wptsync", "config")
+}
+
+// LoadUserConfig reads the user configuration at path, which is JSON. A
+// missing file yields the zero configuration; unknown keys are rejected as
+// they are in wpt.json.
+func LoadUserConfig(path string) (*UserConfig, error) {
+	c := &UserConfig{}
+	if path == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, invalidConfig(fmt.Errorf("read user config %q: %w", path, err))
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, invalidConfig(fmt.Errorf("decode user config %q: %w", path, err))
+	}
+	for name, line := range c.Aliases {
+		if name == "" || strings.HasPrefix(name, "-") || strings.ContainsFunc(name, unicode.IsSpace) {
+			return nil, invalidConfig(fmt.Errorf("user config %q: alias %q must be a single word not starting with -", path, name))
+		}
+		if len(strings.Fields(line)) == 0 {
+			return nil, invalidConfig(fmt.Errorf("user config %q: alias %q is empty", path, name))
+		}
+	}
+	return c, nil
+}
+
+// Expand returns the command line that args, the arguments after the program
+// name, stand for: DefaultCommand is put in front of args that name no
+// command (none, or only flags), then an alias in first place is replaced by
+// its command line.
+func (c *UserConfig) Expand(args []string) []string {
+	if (len(args) == 0 || strings.HasPrefix(args[0], "-")) && c.DefaultCommand != "" {
+		args = append(strings.Fields(c.DefaultCommand), args...)
+	}
+	if len(args) > 0 {
+		if line, ok := c.Aliases[args[0]]; ok {
+			args = append(strings.Fields(line), args[1:]...)
+		}
+	}
+	return args
+}
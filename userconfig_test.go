@@ -0,0 +1,42 @@
+package wptsync
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestUserConfigExpand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(`{"default_command": "st", "aliases": {"st": "status -format json", "up": "update -check", "loop": "up"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadUserConfig(path)
+	if err != nil {
+		t.Fatalf("LoadUserConfig: %v", err)
+	}
+	for _, tc := range []struct {
+		args, want []string
+	}{
+		{nil, []string{"status", "-format", "json"}},
+		{[]string{"-q"}, []string{"status", "-format", "json", "-q"}},
+		{[]string{"up", "-v"}, []string{"update", "-check", "-v"}},
+		{[]string{"loop"}, []string{"up"}},
+		{[]string{"sync", "-dry-run"}, []string{"sync", "-dry-run"}},
+	} {
+		if got := c.Expand(tc.args); !slices.Equal(got, tc.want) {
+			t.Errorf("Expand(%q) = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+
+	if c, err := LoadUserConfig(filepath.Join(t.TempDir(), "missing")); err != nil || c.Expand(nil) != nil {
+		t.Errorf("LoadUserConfig(missing) = %+v, %v, want an empty config", c, err)
+	}
+	if err := os.WriteFile(path, []byte(`{"aliases": {"-x": "sync"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadUserConfig(path); ExitCode(err) != ExitConfig {
+		t.Errorf("LoadUserConfig(flag alias) = %v, want a config error", err)
+	}
+}
@@ -0,0 +1,348 @@
+package wptsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ConfigProblem is something wrong with a configuration file, as reported by
+// ValidateConfig.
+type ConfigProblem struct {
+	// Line is the line of the file the problem is on, or 0 when it is not
+	// tied to one.
+	Line int `json:"line,omitempty"`
+	// Field is the path of the offending key, such as "files[3].dst", or
+	// empty for the file as a whole.
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p ConfigProblem) String() string {
+	var b strings.Builder
+	if p.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", p.Line)
+	}
+	if p.Field != "" {
+		b.WriteString(p.Field + ": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// ValidateConfig checks the configuration file at path without syncing
+// anything, and returns every problem it finds rather than the first: keys
+// the format doesn't know (typically typos, which LoadConfig rejects too),
+// duplicate src or dst entries, dsts escaping the target directory, patch
+// files that don't exist, and commits that aren't full SHAs. The remaining
+// checks LoadConfig's callers make are reported once these pass. The error
+// is only for a file that can't be read.
+func ValidateConfig(path string) ([]ConfigProblem, error) {
+	data, lines, err := readConfig(path)
+	var syntax *configSyntaxError
+	if errors.As(err, &syntax) {
+		return []ConfigProblem{syntax.problem()}, nil
+	}
+	if err != nil {
+		return nil, invalidConfig(fmt.Errorf("open config %q: %w", path, err))
+	}
+	root, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("determine repo root from config: %w", err)
+	}
+
+	w := newConfigWalker(data, lines)
+	if err := w.value(reflect.TypeFor[Config](), ""); err != nil {
+		return []ConfigProblem{w.problem(err)}, nil
+	}
+	problems := w.unknown
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return append(problems, w.problem(err)), nil
+	}
+	if err := cfg.normalize(); err != nil {
+		return append(problems, ConfigProblem{Message: err.Error()}), nil
+	}
+
+	report := func(field, format string, args ...any) {
+		problems = append(problems, ConfigProblem{Line: w.lines[field], Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	commit := func(field, sha string) {
+		if sha != "" && !isFullSHA(sha) {
+			report(field, "commit %q is not a full 40-character hexadecimal SHA", sha)
+		}
+	}
+	checkFiles := func(prefix string, files []FileSpec) {
+		srcs := make(map[string]int)
+		dsts := make(map[string]int)
+		for i, f := range files {
+			field := fmt.Sprintf("%sfiles[%d]", prefix, i)
+			dstField := field + ".dst"
+			if _, ok := w.lines[dstField]; !ok {
+				dstField = field + ".src"
+			}
+			if !filepath.IsLocal(filepath.FromSlash(f.Dst)) {
+				report(dstField, "dst %q escapes target_dir", f.Dst)
+			}
+			if j, ok := dsts[f.Dst]; ok {
+				report(dstField, "dst %q is also used by %sfiles[%d]", f.Dst, prefix, j)
+			} else {
+				dsts[f.Dst] = i
+			}
+			// An .any.js test may be listed once per scope.
+			if j, ok := srcs[f.Src]; ok && (len(f.Scopes) == 0 || len(files[j].Scopes) == 0) {
+				report(field+".src", "src %q is also listed by %sfiles[%d]", f.Src, prefix, j)
+			} else if !ok {
+				srcs[f.Src] = i
+			}
+			if f.Patch != "" {
+				if _, err := os.Stat(patchAbsPath(root, f)); err != nil {
+					report(field+".patch", "patch %q does not exist", f.Patch)
+				}
+			}
+			commit(field+".commit", f.Commit)
+			if len(f.Transforms) > 0 && isBinaryExt(f.Dst) {
+				report(field+".transforms", "%s is binary, so its transforms never apply", f.Dst)
+			}
+		}
+	}
+	commit("commit", cfg.Commit)
+	checkFiles("", cfg.Files)
+	for i, g := range cfg.Groups {
+		prefix := fmt.Sprintf("groups[%d].", i)
+		commit(prefix+"commit", g.Commit)
+		checkFiles(prefix, g.Files)
+	}
+
+	if len(problems) == 0 {
+		if err := cfg.check(); err != nil {
+			problems = append(problems, ConfigProblem{Message: err.Error()})
+		}
+	}
+	slices.SortStableFunc(problems, func(a, b ConfigProblem) int { return a.Line - b.Line })
+	return problems, nil
+}
+
+// decodeConfig decodes the configuration file data into cfg, rejecting keys
+// Config lacks. Errors say on which line the problem is; lines maps those of
+// data to those of the file it was converted from, if any (see readConfig).
+func decodeConfig(data []byte, lines []int, cfg *Config) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(cfg)
+	if err == nil {
+		return nil
+	}
+	w := newConfigWalker(data, lines)
+	if werr := w.value(reflect.TypeFor[Config](), ""); werr == nil && len(w.unknown) > 0 {
+		return errors.New(w.unknown[0].String())
+	}
+	return errors.New(w.problem(err).String())
+}
+
+// configWalker reads a configuration file token by token, recording the
+// line each key is on and the keys the format doesn't know.
+type configWalker struct {
+	data []byte
+	dec  *json.Decoder
+	// newlines holds the offsets of data's newlines, to find lines by.
+	newlines []int
+	// source maps data's lines to those of the YAML or TOML file it was
+	// converted from, if any.
+	source []int
+	// lines maps each key's path ("files[3].dst") to its line.
+	lines   map[string]int
+	unknown []ConfigProblem
+}
+
+func newConfigWalker(data []byte, source []int) *configWalker {
+	w := &configWalker{data: data, dec: json.NewDecoder(bytes.NewReader(data)), source: source, lines: make(map[string]int)}
+	for i, c := range data {
+		if c == '\n' {
+			w.newlines = append(w.newlines, i)
+		}
+	}
+	return w
+}
+
+// lineAt returns the line of data at offset, or of the file data was
+// converted from.
+func (w *configWalker) lineAt(offset int64) int {
+	line := sort.SearchInts(w.newlines, int(offset)) + 1
+	if w.source != nil {
+		return w.source[min(line, len(w.source))-1]
+	}
+	return line
+}
+
+// next returns the line of the next token.
+func (w *configWalker) next() int {
+	i := int(w.dec.InputOffset())
+	for i < len(w.data) && strings.IndexByte(" \t\r\n,:", w.data[i]) >= 0 {
+		i++
+	}
+	return w.lineAt(int64(i))
+}
+
+// value walks the value at path, which decodes into t; a nil t is a value
+// nothing decodes into, such as that of an unknown key.
+func (w *configWalker) value(t reflect.Type, path string) error {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		var fields map[string]reflect.Type
+		if t != nil && t.Kind() == reflect.Struct {
+			fields = make(map[string]reflect.Type)
+			jsonFields(t, fields)
+		}
+		for w.dec.More() {
+			line := w.next()
+			tok, err := w.dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := tok.(string)
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			w.lines[field] = line
+			var ft reflect.Type
+			switch {
+			case fields != nil:
+				var ok bool
+				if ft, ok = lookupField(fields, key); !ok {
+					w.unknown = append(w.unknown, ConfigProblem{Line: line, Field: field, Message: unknownKeyMessage(key, fields)})
+				}
+			case t != nil && t.Kind() == reflect.Map:
+				ft = t.Elem()
+			}
+			if err := w.value(ft, field); err != nil {
+				return err
+			}
+		}
+		_, err = w.dec.Token()
+		return err
+	case json.Delim('['):
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		for i := 0; w.dec.More(); i++ {
+			field := fmt.Sprintf("%s[%d]", path, i)
+			w.lines[field] = w.next()
+			if err := w.value(elem, field); err != nil {
+				return err
+			}
+		}
+		_, err = w.dec.Token()
+		return err
+	}
+	return nil
+}
+
+// problem describes a decoding error, on the line it happened.
+func (w *configWalker) problem(err error) ConfigProblem {
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntax):
+		return ConfigProblem{Line: w.lineAt(syntax.Offset), Message: strings.TrimPrefix(syntax.Error(), "json: ")}
+	case errors.As(err, &typ):
+		return ConfigProblem{Line: w.lineAt(typ.Offset), Field: typ.Field, Message: fmt.Sprintf("expected %s, found %s", jsonKind(typ.Type), typ.Value)}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return ConfigProblem{Line: len(w.newlines) + 1, Message: "unexpected end of file"}
+	}
+	return ConfigProblem{Message: strings.TrimPrefix(err.Error(), "json: ")}
+}
+
+// lookupField returns the type of the field key decodes into, which, as
+// encoding/json matches keys, is the one named key or else any named like it
+// in another case.
+func lookupField(fields map[string]reflect.Type, key string) (reflect.Type, bool) {
+	if t, ok := fields[key]; ok {
+		return t, true
+	}
+	for name, t := range fields {
+		if strings.EqualFold(name, key) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// unknownKeyMessage describes key, which fields lacks, suggesting the field
+// it is probably a typo of.
+func unknownKeyMessage(key string, fields map[string]reflect.Type) string {
+	limit := 2
+	if len(key) < 5 {
+		limit = 1
+	}
+	best, bestDist := "", limit+1
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		if d := editDistance(strings.ToLower(key), name); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf("unknown key %q; did you mean %q?", key, best)
+	}
+	return fmt.Sprintf("unknown key %q", key)
+}
+
+// jsonFields adds the JSON keys of struct type t to fields, with the types
+// they decode into, flattening embedded structs as addStructFields does.
+func jsonFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			jsonFields(f.Type, fields)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+}
+
+// jsonKind names the JSON type values of t are written as.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Pointer:
+		return jsonKind(t.Elem())
+	}
+	return "a number"
+}
@@ -0,0 +1,84 @@
+package wptsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const validateTestConfig = `{
+  "commit": "0123abcd",
+  "taget_dir": "wpt",
+  "files": [
+    { "src": "url/a.js", "pacth": "patches/a.patch" },
+    { "src": "url/b.js", "dst": "../b.js" },
+    { "src": "url/a.js", "dst": "url/a2.js", "patch": "patches/missing.patch" },
+    { "src": "url/c.js", "dst": "url/a.js", "commit": "4567cdef4567cdef4567cdef4567cdef4567cdef" },
+    { "src": "url/d.png", "transforms": [{ "replace": "a", "with": "b" }] }
+  ]
+}
+`
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "wpt.json")
+	if err := os.WriteFile(configPath, []byte(validateTestConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := ValidateConfig(configPath)
+	if err != nil {
+		t.Fatalf("ValidateConfig: %v", err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		`line 2: commit: commit "0123abcd" is not a full 40-character hexadecimal SHA`,
+		`line 3: taget_dir: unknown key "taget_dir"; did you mean "target_dir"?`,
+		`line 5: files[0].pacth: unknown key "pacth"; did you mean "patch"?`,
+		`line 6: files[1].dst: dst "../b.js" escapes target_dir`,
+		`line 7: files[2].src: src "url/a.js" is also listed by files[0]`,
+		`line 7: files[2].patch: patch "patches/missing.patch" does not exist`,
+		`line 8: files[3].dst: dst "url/a.js" is also used by files[0]`,
+		`line 9: files[4].transforms: url/d.png is binary, so its transforms never apply`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Every command rejects the unknown keys.
+	_, err = LoadConfig(configPath)
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), `line 3: taget_dir: unknown key "taget_dir"; did you mean "target_dir"?`) {
+		t.Errorf("LoadConfig = %v, want the unknown key on line 3", err)
+	}
+}
+
+func TestLoadConfigReportsLines(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "wpt.json")
+	for _, tc := range []struct {
+		data, want string
+	}{
+		{"{\n  \"commit\": \"c1\",\n  \"target_dir\": 3\n}\n", "line 3: target_dir: expected a string, found number"},
+		{"{\n  \"commit\": \"c1\",\n  \"files\": [\n    { \"src\": \"a.js\" }\n    { \"src\": \"b.js\" }\n  ]\n}\n", "line 5: invalid character '{' after array element"},
+		{"{\n  \"Target_Dir\": \"wpt\",\n  \"commit\": \"c1\"\n}\n", ""},
+	} {
+		if err := os.WriteFile(configPath, []byte(tc.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadConfig(configPath)
+		if tc.want == "" {
+			if err != nil {
+				t.Errorf("LoadConfig(%q) = %v, want keys in another case accepted", tc.data, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("LoadConfig(%q) = %v, want %q", tc.data, err, tc.want)
+		}
+	}
+}
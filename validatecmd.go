@@ -0,0 +1,119 @@
+package wptsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Validator runs a command on every synced file a pattern matches, such as
+// "node --check {{dst}}", to make sure vendored content still loads in the
+// runtime it is vendored for. FileSpec.ValidateCmd replaces the validators
+// for a file.
+type Validator struct {
+	// Match limits the validator to the files whose src or dst matches
+	// this pattern, with the syntax of -only. Empty means every file.
+	Match string `json:"match,omitempty"`
+	// ValidateCmd is the shell command (see validateCmds).
+	ValidateCmd string `json:"validate_cmd"`
+}
+
+// placeholderRE matches the {{name}} placeholders of a validate_cmd.
+var placeholderRE = regexp.MustCompile(`\{\{\s*(\w*)\s*\}\}`)
+
+// checkValidateCmd reports an empty cmd or one with an unknown placeholder.
+func checkValidateCmd(cmd string) error {
+	if strings.TrimSpace(cmd) == "" {
+		return fmt.Errorf("validate_cmd must be set")
+	}
+	for _, m := range placeholderRE.FindAllStringSubmatch(cmd, -1) {
+		if m[1] != "dst" && m[1] != "src" {
+			return fmt.Errorf("validate_cmd %q: unknown placeholder %s (want {{dst}} or {{src}})", cmd, m[0])
+		}
+	}
+	return nil
+}
+
+func (v *Validator) check() error {
+	if _, err := path.Match(v.Match, ""); err != nil {
+		return fmt.Errorf("validators: match %q: %w", v.Match, err)
+	}
+	if err := checkValidateCmd(v.ValidateCmd); err != nil {
+		return fmt.Errorf("validators: %w", err)
+	}
+	return nil
+}
+
+// validateCmds returns the commands validating file: its own ValidateCmd,
+// or else those of the validators matching it, in order.
+func (c *Config) validateCmds(file FileSpec) []string {
+	if file.ValidateCmd != "" {
+		return []string{file.ValidateCmd}
+	}
+	var cmds []string
+	for _, v := range c.Validators {
+		if v.Match == "" || matchFilter(v.Match, strings.TrimLeft(file.Src, "/")) || matchFilter(v.Match, file.Dst) {
+			cmds = append(cmds, v.ValidateCmd)
+		}
+	}
+	return cmds
+}
+
+// shellQuote quotes s as a single word for sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// validateFile runs the validate commands of file, just written by a sync
+// of cfg, each with sh -c from the repository root: {{dst}} stands for the
+// file's path from there, which is also $1 and WPTSYNC_FILE, and {{src}} for
+// its upstream path, also WPTSYNC_SRC, both quoted for the shell. It returns
+// an error wrapping ErrVerification, with the command's output, for the
+// first that fails. Dry runs validate nothing.
+func validateFile(ctx context.Context, root string, cfg *Config, file FileSpec, opts *SyncOptions) error {
+	cmds := cfg.validateCmds(file)
+	if len(cmds) == 0 || opts != nil && opts.DryRun {
+		return nil
+	}
+	p := path.Join(cfg.TargetDir, file.Dst)
+	env := append(os.Environ(), "WPTSYNC_COMMIT="+cfg.Commit, "WPTSYNC_TARGET_DIR="+cfg.TargetDir, "WPTSYNC_FILE="+p, "WPTSYNC_SRC="+file.Src)
+	for _, cmd := range cmds {
+		run := placeholderRE.ReplaceAllStringFunc(cmd, func(m string) string {
+			if placeholderRE.FindStringSubmatch(m)[1] == "src" {
+				return shellQuote(file.Src)
+			}
+			return shellQuote(p)
+		})
+		if err := runShell(ctx, root, "validate_cmd", run, ErrVerification, env, []string{p}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validated sorts the results of validateFile for the files of a sync: the
+// failures are reported, as errors with opts.Strict and as warnings
+// otherwise. With opts.Strict, it returns the dsts of the failed files,
+// which are left out of the lock file, and an error wrapping
+// ErrVerification.
+func (o *SyncOptions) validated(files []FileSpec, errs []error) (map[string]bool, error) {
+	failed := make(map[string]bool)
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if o == nil || !o.Strict {
+			o.logf("warning: %s: %v\n", files[i].Dst, err)
+			continue
+		}
+		o.logf(" ! %s: %v\n", files[i].Dst, err)
+		failed[files[i].Dst] = true
+	}
+	if len(failed) == 0 {
+		return nil, nil
+	}
+	return failed, fmt.Errorf("%w: validate_cmd failed for %d file(s) in strict mode, which were left out of the lock file", ErrVerification, len(failed))
+}
@@ -0,0 +1,77 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSyncRunsValidateCmd(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, dir, _ := newFixture(t, map[string]string{
+		"/c1/url/a.js":    "good\n",
+		"/c1/url/b.js":    "bad\n",
+		"/c1/url/c.json":  "bad\n",
+		"/c1/url/d.js":    "bad\n",
+		"/c1/url/it's.js": "good\n",
+	})
+	// d.js's own command replaces the validator, and c.json matches none.
+	configPath := saveTestConfig(t, dir, &Config{
+		Commit:    "c1",
+		TargetDir: "wpt",
+		Files: []FileSpec{
+			{Src: "url/a.js"}, {Src: "url/b.js"}, {Src: "url/c.json"},
+			{Src: "url/d.js", ValidateCmd: `test "$WPTSYNC_SRC" = {{src}}`},
+			{Src: "url/it's.js"},
+		},
+		Validators: []Validator{{Match: "*.js", ValidateCmd: `grep -q good {{ dst }} && test {{dst}} = "$1"`}},
+	})
+
+	var logged strings.Builder
+	opts := &SyncOptions{BaseURL: server.URL, Logf: func(format string, args ...any) { fmt.Fprintf(&logged, format, args...) }}
+	if err := Sync(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if !strings.Contains(logged.String(), "warning: url/b.js: validate_cmd") || strings.Count(logged.String(), "validate_cmd") != 1 {
+		t.Errorf("log = %q, want a warning for b.js only", logged.String())
+	}
+	lock, err := loadLock(lockPath(configPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lock.Files) != 5 {
+		t.Errorf("lock has %d files, want all 5 without -strict", len(lock.Files))
+	}
+
+	opts.Strict, opts.Force = true, true
+	err = Sync(context.Background(), configPath, opts)
+	if !errors.Is(err, ErrVerification) || ExitCode(err) != ExitVerification {
+		t.Fatalf("strict Sync = %v, want ErrVerification", err)
+	}
+	if lock, err = loadLock(lockPath(configPath)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lock.Files["url/b.js"]; ok || len(lock.Files) != 4 {
+		t.Errorf("strict lock has %d files (b.js: %v), want b.js left out", len(lock.Files), ok)
+	}
+}
+
+func TestCheckValidateCmd(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{Validators: []Validator{{Match: "*.js"}}}, "validate_cmd must be set"},
+		{Config{Validators: []Validator{{Match: "[", ValidateCmd: "true"}}}, `match "["`},
+		{Config{Files: []FileSpec{{Src: "a.js", Dst: "a.js", ValidateCmd: "node --check {{path}}"}}}, "unknown placeholder {{path}}"},
+		{Config{Files: []FileSpec{{Src: "a.js", Dst: "a.js", ValidateCmd: "node --check {{dst}}"}}, Validators: []Validator{{ValidateCmd: "true {{src}}"}}}, ""},
+	} {
+		tc.cfg.Commit, tc.cfg.TargetDir = "c1", "wpt"
+		err := tc.cfg.check()
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("check = %v, want %q", err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package wptsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// Variant naming schemes, the characters of a variant's query string or
+// fragment VariantNames keeps in a file name.
+const (
+	// VariantSchemePortable keeps letters, digits, ".", "_", and "-", which
+	// every file system and URL accepts as is.
+	VariantSchemePortable = "portable"
+	// VariantSchemeWindows keeps all but the characters Windows forbids in
+	// file names, and a trailing dot or space.
+	VariantSchemeWindows = "windows"
+	// VariantSchemePOSIX keeps all but "/" and control characters.
+	VariantSchemePOSIX = "posix"
+)
+
+// VariantNames has runner-config name a file for each variant of a test, for
+// runners that generate one wrapper per variant: the variant's query string
+// or fragment, made safe for file names, goes before the extension of the
+// test's dst, so that url/urlencoded.js with ?exclude=x is
+// url/urlencoded.exclude-x.js. Names are deterministic, and variants of a
+// test that would share one, including by case, tell apart by a hash.
+type VariantNames struct {
+	// Scheme is one of the VariantScheme constants. Empty means
+	// VariantSchemePortable.
+	Scheme string `json:"scheme,omitempty"`
+	// Separator goes between the dst's name and the variant. Empty means
+	// ".".
+	Separator string `json:"separator,omitempty"`
+	// MaxLength caps the length of the variant's part of the name, for
+	// platforms that limit the length of paths: a longer one is cut and
+	// ends in a hash of the variant. Zero means no limit.
+	MaxLength int `json:"max_length,omitempty"`
+}
+
+// minVariantLength is the shortest MaxLength, which leaves room for the
+// hash of a cut variant.
+const minVariantLength = 16
+
+func (v *VariantNames) check() error {
+	switch v.Scheme {
+	case "", VariantSchemePortable, VariantSchemeWindows, VariantSchemePOSIX:
+	default:
+		return fmt.Errorf("variant_names: scheme %q is not one of %q, %q, or %q", v.Scheme, VariantSchemePortable, VariantSchemeWindows, VariantSchemePOSIX)
+	}
+	if v.Separator != "" && v.sanitize(v.Separator) != v.Separator {
+		return fmt.Errorf("variant_names: separator %q has characters the %s scheme does not allow", v.Separator, v.scheme())
+	}
+	if v.MaxLength != 0 && v.MaxLength < minVariantLength {
+		return fmt.Errorf("variant_names: max_length %d is shorter than %d", v.MaxLength, minVariantLength)
+	}
+	return nil
+}
+
+func (v *VariantNames) scheme() string {
+	if v.Scheme == "" {
+		return VariantSchemePortable
+	}
+	return v.Scheme
+}
+
+// keeps reports whether the scheme keeps r in a file name.
+func (v *VariantNames) keeps(r rune) bool {
+	if r < 0x20 || r == 0x7f || r == '/' {
+		return false
+	}
+	switch v.scheme() {
+	case VariantSchemeWindows:
+		return !strings.ContainsRune(`<>:"\|?*`, r)
+	case VariantSchemePOSIX:
+		return true
+	}
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-'
+}
+
+// sanitize returns s with every run of characters the scheme does not keep
+// replaced by a "-", and without leading and trailing ones.
+func (v *VariantNames) sanitize(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range s {
+		if !v.keeps(r) {
+			dash = true
+			continue
+		}
+		if dash && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		dash = false
+		b.WriteRune(r)
+	}
+	out := b.String()
+	if v.scheme() == VariantSchemeWindows {
+		out = strings.TrimRight(out, ". ")
+	}
+	return out
+}
+
+// name returns the part of a file name standing for variant: its query
+// string or fragment, sanitized and cut to MaxLength.
+func (v *VariantNames) name(variant string) string {
+	name := v.sanitize(strings.TrimLeft(variant, "?#"))
+	if v.MaxLength > 0 && len(name) > v.MaxLength {
+		return v.hashed(name, variant)
+	}
+	return name
+}
+
+// hashed returns name with the hash of variant added, cut so that both fit
+// in MaxLength.
+func (v *VariantNames) hashed(name, variant string) string {
+	if n := v.MaxLength - 9; v.MaxLength > 0 && len(name) > n {
+		for n > 0 && !utf8.RuneStart(name[n]) {
+			n--
+		}
+		name = name[:n]
+	}
+	return strings.TrimLeft(strings.TrimRight(name, "-")+"-"+variantHash(variant), "-")
+}
+
+// variantHash is the short hash telling apart variants whose names are the
+// same.
+func variantHash(variant string) string {
+	sum := sha256.Sum256([]byte(variant))
+	return hex.EncodeToString(sum[:4])
+}
+
+// dsts returns the file name of each of variants of the test at dst, in
+// order. A variant whose name is empty, or taken by an earlier one, has the
+// hash of the variant added.
+func (v *VariantNames) dsts(dst string, variants []string) []string {
+	ext := path.Ext(dst)
+	stem := strings.TrimSuffix(dst, ext)
+	sep := v.Separator
+	if sep == "" {
+		sep = "."
+	}
+	out := make([]string, len(variants))
+	taken := make(map[string]bool, len(variants))
+	for i, variant := range variants {
+		name := v.name(variant)
+		// Case-insensitive file systems would have them collide too.
+		if name == "" || taken[strings.ToLower(name)] {
+			name = v.hashed(name, variant)
+		}
+		taken[strings.ToLower(name)] = true
+		out[i] = stem + sep + name + ext
+	}
+	return out
+}
@@ -0,0 +1,145 @@
+package wptsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultWatchInterval is how often Watch checks upstream when
+// WatchOptions.Interval is zero.
+const DefaultWatchInterval = 24 * time.Hour
+
+// WatchOptions configures a Watch run. A nil *WatchOptions is equivalent to
+// its zero value.
+type WatchOptions struct {
+	UpdateOptions
+	// Interval is the time between two checks. Zero means
+	// DefaultWatchInterval.
+	Interval time.Duration
+	// OnUpstreamChange updates only when a file the configuration syncs
+	// changed upstream, instead of whenever the upstream commit moves.
+	OnUpstreamChange bool
+	// Run is a shell command run in the configuration's directory after
+	// each update, for example to commit the result and open a pull
+	// request. It sees WPTSYNC_COMMIT, WPTSYNC_PREVIOUS_COMMIT, and
+	// WPTSYNC_CHANGED, the changed srcs one per line.
+	Run string
+	// Once checks a single time and returns, for running from a scheduler
+	// such as cron instead of as a daemon.
+	Once bool
+}
+
+// Watch keeps the configuration at configPath up to date: every
+// opts.Interval it checks for a new upstream commit, or with
+// opts.OnUpstreamChange for one changing a configured file, and moves the
+// pin to it and re-syncs as Update does, then runs opts.Run. A failed check
+// or update is logged and retried at the next one, except for an invalid
+// configuration. Watch returns when ctx is done, or after one check with
+// opts.Once, with the error of that check.
+func Watch(ctx context.Context, configPath string, opts *WatchOptions) error {
+	if opts == nil {
+		opts = &WatchOptions{}
+	}
+	if err := opts.SyncOptions.validate(); err != nil {
+		return err
+	}
+	if opts.Commit != "" || opts.Tag != "" || opts.SelectByResults != nil {
+		return invalidConfig(errors.New("watch follows the latest upstream commit; it cannot be combined with a commit, tag, or selection by results"))
+	}
+	interval := opts.Interval
+	if interval == 0 {
+		interval = DefaultWatchInterval
+	}
+	if interval < 0 {
+		return invalidConfig(fmt.Errorf("watch interval %s is negative", interval))
+	}
+
+	for {
+		err := opts.check(ctx, configPath)
+		if opts.Once || errors.Is(err, ErrInvalidConfig) {
+			return err
+		}
+		if err != nil && ctx.Err() == nil {
+			opts.logf("warning: %v\n", err)
+		}
+		opts.logf("Next check at %s\n", time.Now().Add(interval).Format("2006-01-02 15:04"))
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// check is one round of Watch.
+func (o *WatchOptions) check(ctx context.Context, configPath string) error {
+	root, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return fmt.Errorf("determine repo root from config: %w", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	syncOpts := o.SyncOptions.forConfig(cfg)
+
+	resolveCtx, cancel := withTimeout(ctx, syncOpts.timeouts().Resolve)
+	defer cancel()
+	gh := syncOpts.github()
+	latest, err := gh.latestCommit(resolveCtx)
+	if err != nil {
+		return fmt.Errorf("fetch latest commit: %w", err)
+	}
+	if sameCommit(latest, cfg.Commit) {
+		o.logf("Up to date at %s\n", shortSHA(cfg.Commit))
+		return nil
+	}
+	changed, complete, err := gh.changedPaths(resolveCtx, cfg.Commit, latest)
+	if err != nil {
+		return err
+	}
+	_, configured := movingSrcs(cfg)
+	var srcs []string
+	for p := range changed {
+		if configured(p) {
+			srcs = append(srcs, p)
+		}
+	}
+	slices.Sort(srcs)
+	if len(srcs) == 0 && complete && o.OnUpstreamChange {
+		o.logf("Upstream moved to %s without changing configured files; keeping %s\n", shortSHA(latest), shortSHA(cfg.Commit))
+		return nil
+	}
+	o.logf("Upstream moved to %s, changing %d configured files\n", shortSHA(latest), len(srcs))
+
+	update := o.UpdateOptions
+	// A configuration tracking releases has update resolve the release
+	// again, so that it records the tag.
+	if !cfg.TrackReleases {
+		update.Commit = latest
+	}
+	if err := Update(ctx, configPath, &update); err != nil {
+		return err
+	}
+	if o.Run == "" {
+		return nil
+	}
+	if o.DryRun {
+		o.logf(" - would run: %s\n", o.Run)
+		return nil
+	}
+	o.logf("Running: %s\n", o.Run)
+	env := append(os.Environ(), "WPTSYNC_COMMIT="+latest, "WPTSYNC_PREVIOUS_COMMIT="+cfg.Commit, "WPTSYNC_CHANGED="+strings.Join(srcs, "\n"))
+	return runHook(ctx, root, "watch", o.Run, env, nil)
+}
@@ -0,0 +1,57 @@
+package wptsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchUpdatesWhenConfiguredFilesChange(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	content := map[string]string{
+		"/c1/a/foo.js":     "foo v1\n",
+		"/c3/a/foo.js":     "foo v3\n",
+		"/commits/master":  `{"sha":"c2"}`,
+		"/compare/c1...c2": `{"files":[{"filename":"b/other.js"}]}`,
+		"/compare/c1...c3": `{"files":[{"filename":"b/other.js"},{"filename":"a/foo.js"}]}`,
+	}
+	server, dir, _ := newFixture(t, content)
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "a/foo.js"}}})
+
+	opts := &WatchOptions{
+		UpdateOptions:    UpdateOptions{SyncOptions: SyncOptions{BaseURL: server.URL, APIURL: server.URL}},
+		OnUpstreamChange: true,
+		Once:             true,
+		Run:              `printf '%s %s %s' "$WPTSYNC_PREVIOUS_COMMIT" "$WPTSYNC_COMMIT" "$WPTSYNC_CHANGED" > ran`,
+	}
+	if err := Watch(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if cfg, err := LoadConfig(configPath); err != nil || cfg.Commit != "c1" {
+		t.Errorf("commit after an unrelated upstream change = %v, %v, want c1 kept", cfg, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ran")); err == nil {
+		t.Error("-run ran without an update")
+	}
+
+	content["/commits/master"] = `{"sha":"c3"}`
+	if err := Watch(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "wpt", "a", "foo.js")); string(got) != "foo v3\n" {
+		t.Errorf("a/foo.js = %q, want it updated to c3", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "ran")); string(got) != "c1 c3 a/foo.js" {
+		t.Errorf("-run saw %q, want the commits and the changed src", got)
+	}
+
+	// Without Once, Watch keeps checking until its context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	opts.Once, opts.Interval = false, 10*time.Millisecond
+	if err := Watch(ctx, configPath, opts); err != nil {
+		t.Errorf("Watch until cancelled = %v, want nil", err)
+	}
+}
@@ -0,0 +1,216 @@
+package wptsync
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// DefaultWPTFyiURL is the default wpt.fyi instance queried for test results.
+const DefaultWPTFyiURL = "https://wpt.fyi"
+
+// resultRunsLimit is how many aligned runs per product are considered when
+// selecting a commit by results.
+const resultRunsLimit = 20
+
+// ErrNoGreenCommit is returned when no recent commit meets ResultCriteria.
+var ErrNoGreenCommit = errors.New("no recent commit meets the result criteria")
+
+// ResultCriteria selects a commit by its wpt.fyi results: the newest master
+// commit with runs for every product where the tests in the configured
+// directories pass at least MinPassRate.
+type ResultCriteria struct {
+	// Products are the wpt.fyi browser names that must all have run the
+	// commit. Empty means chrome and firefox.
+	Products []string
+	// MinPassRate is the fraction of subtests (0 to 1) that must pass in
+	// each product. Zero accepts any results.
+	MinPassRate float64
+	// URL is the wpt.fyi base URL. Empty means DefaultWPTFyiURL.
+	URL string
+}
+
+// wptRun is a single test run as listed by the wpt.fyi runs API.
+type wptRun struct {
+	BrowserName string `json:"browser_name"`
+	Revision    string `json:"full_revision_hash"`
+	ResultsURL  string `json:"results_url"`
+}
+
+// selectCommitByResults returns the newest commit whose aligned wpt.fyi runs
+// meet c for the directories cfg tracks.
+func selectCommitByResults(ctx context.Context, cfg *Config, c *ResultCriteria, opts *SyncOptions) (string, error) {
+	if c.MinPassRate < 0 || c.MinPassRate > 1 {
+		return "", invalidConfig(fmt.Errorf("min pass rate %v must be between 0 and 1", c.MinPassRate))
+	}
+	base := c.URL
+	if base == "" {
+		base = DefaultWPTFyiURL
+	}
+	products := c.Products
+	if len(products) == 0 {
+		products = []string{"chrome", "firefox"}
+	}
+	client := opts.httpClient()
+	timeout := opts.timeouts().Resolve
+
+	q := url.Values{}
+	q.Set("label", "master")
+	q.Set("aligned", "true")
+	q.Set("products", strings.Join(products, ","))
+	q.Set("max-count", fmt.Sprint(resultRunsLimit))
+	var runs []wptRun
+	runsCtx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	if err := getJSON(runsCtx, client, base+"/api/runs?"+q.Encode(), &runs); err != nil {
+		return "", fmt.Errorf("list wpt.fyi runs: %w", err)
+	}
+
+	// Runs come newest first; group them by commit, keeping that order.
+	var commits []string
+	byCommit := make(map[string]map[string]wptRun)
+	for _, run := range runs {
+		if byCommit[run.Revision] == nil {
+			byCommit[run.Revision] = make(map[string]wptRun)
+			commits = append(commits, run.Revision)
+		}
+		byCommit[run.Revision][run.BrowserName] = run
+	}
+
+	dirs := trackedDirs(cfg)
+	for _, commit := range commits {
+		ok, err := func() (bool, error) {
+			for _, product := range products {
+				run, found := byCommit[commit][product]
+				if !found {
+					return false, nil
+				}
+				runCtx, cancel := withTimeout(ctx, timeout)
+				defer cancel()
+				passed, total, err := runResults(runCtx, client, run.ResultsURL, dirs)
+				if err != nil {
+					return false, fmt.Errorf("results for %s at %s: %w", product, commit, err)
+				}
+				if total > 0 && float64(passed)/float64(total) < c.MinPassRate {
+					opts.logf(" x %s: %s passes %d/%d\n", shortSHA(commit), product, passed, total)
+					return false, nil
+				}
+			}
+			return true, nil
+		}()
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return commit, nil
+		}
+	}
+	return "", fmt.Errorf("%w (checked %d commits)", ErrNoGreenCommit, len(commits))
+}
+
+// trackedDirs returns the directories holding cfg's enabled files, as
+// wpt.fyi test path prefixes ("/url/").
+func trackedDirs(cfg *Config) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, f := range cfg.Files {
+		if !f.IsEnabled() {
+			continue
+		}
+		dir := "/" + strings.Trim(path.Dir(f.Src), "/") + "/"
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// runResults sums the passing and total subtests, in the run summary at
+// resultsURL, of the tests directly inside one of dirs.
+func runResults(ctx context.Context, client *http.Client, resultsURL string, dirs []string) (passed, total int, err error) {
+	var summary map[string]json.RawMessage
+	if err := getJSON(ctx, client, resultsURL, &summary); err != nil {
+		return 0, 0, err
+	}
+
+	for test, raw := range summary {
+		dir := path.Dir(test) + "/"
+		tracked := false
+		for _, d := range dirs {
+			if dir == d {
+				tracked = true
+				break
+			}
+		}
+		if !tracked {
+			continue
+		}
+
+		// Summaries are {"s": status, "c": [pass, total]} (v2) or a bare
+		// [pass, total] (v1).
+		var counts []int
+		if err := json.Unmarshal(raw, &counts); err != nil {
+			var v2 struct {
+				C []int `json:"c"`
+			}
+			if err := json.Unmarshal(raw, &v2); err != nil {
+				return 0, 0, fmt.Errorf("decode result for %s: %w", test, err)
+			}
+			counts = v2.C
+		}
+		if len(counts) == 2 {
+			passed += counts[0]
+			total += counts[1]
+		}
+	}
+	return passed, total, nil
+}
+
+// getJSON fetches url and decodes its JSON body into out, transparently
+// gunzipping bodies served as raw .gz files.
+func getJSON(ctx context.Context, client *http.Client, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+
+	body := bufio.NewReader(resp.Body)
+	var r io.Reader = body
+	if magic, err := body.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	if err := json.NewDecoder(r).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// shortSHA abbreviates a commit SHA for log output.
+func shortSHA(sha string) string {
+	if len(sha) > 10 {
+		return sha[:10]
+	}
+	return sha
+}
@@ -0,0 +1,81 @@
+package wptsync
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newWPTFyiFixture serves /api/runs listing a chrome and a firefox run for
+// each of revisions (newest first), with results_url pointing at
+// /results/<revision>-<browser>, answered from summaries (gzipped, as
+// wpt.fyi stores them).
+func newWPTFyiFixture(t *testing.T, revisions []string, summaries map[string]string) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/runs", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("products"); got != "chrome,firefox" {
+			t.Errorf("products = %q, want chrome,firefox", got)
+		}
+		var runs []string
+		for _, rev := range revisions {
+			for _, browser := range []string{"chrome", "firefox"} {
+				runs = append(runs, fmt.Sprintf(`{"browser_name":%q,"full_revision_hash":%q,"results_url":"http://%s/results/%s-%s"}`, browser, rev, r.Host, rev, browser))
+			}
+		}
+		_, _ = w.Write([]byte("[" + strings.Join(runs, ",") + "]"))
+	})
+	mux.HandleFunc("/results/{name}", func(w http.ResponseWriter, r *http.Request) {
+		body, ok := summaries[r.PathValue("name")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write([]byte(body))
+		_ = gz.Close()
+		_, _ = w.Write(buf.Bytes())
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestUpdateSelectsNewestGreenCommit(t *testing.T) {
+	summaries := map[string]string{
+		// c3 fails a url/ subtest in chrome; the failure in c2 is outside
+		// the tracked directories.
+		"c3-chrome":  `{"/url/a.any.html":{"s":"O","c":[4,5]}}`,
+		"c3-firefox": `{"/url/a.any.html":{"s":"O","c":[5,5]}}`,
+		"c2-chrome":  `{"/url/a.any.html":{"s":"O","c":[5,5]},"/css/x.html":{"s":"O","c":[0,3]}}`,
+		"c2-firefox": `{"/url/a.any.html":[5,5]}`,
+	}
+	fyiURL := newWPTFyiFixture(t, []string{"c3", "c2"}, summaries)
+
+	dir := t.TempDir()
+	configPath := saveTestConfig(t, dir, &Config{Commit: "c1", TargetDir: "wpt", Files: []FileSpec{{Src: "url/a.any.js"}}})
+
+	opts := &UpdateOptions{NoSync: true, SelectByResults: &ResultCriteria{MinPassRate: 1, URL: fyiURL}}
+	if err := Update(context.Background(), configPath, opts); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Commit != "c2" {
+		t.Errorf("commit = %q, want c2 (newest all-green commit)", cfg.Commit)
+	}
+
+	summaries["c2-firefox"] = `{"/url/a.any.html":[1,5]}`
+	if err := Update(context.Background(), configPath, opts); !errors.Is(err, ErrNoGreenCommit) {
+		t.Errorf("Update with no green commit: expected ErrNoGreenCommit, got %v", err)
+	}
+}
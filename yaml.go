@@ -0,0 +1,692 @@
+package wptsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The YAML support covers what configuration files need: block mappings and
+// lists, flow collections on a single line, plain, quoted, and block ("|",
+// ">") scalars, and comments. Anchors, aliases, tags, and multi-line plain
+// or quoted scalars are rejected, as are files of several documents.
+
+// yamlLine is a line of a YAML file: its number, its indentation, and the
+// rest of it. Blank lines have an empty text.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+	// pending holds the comments read since the last value, for the next
+	// key or item; header those at the top of the file, separated from the
+	// first key by a blank line.
+	pending []string
+	header  []string
+	started bool
+}
+
+// parseYAML parses a configuration file in YAML.
+func parseYAML(data []byte) (*cfgNode, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") && strings.TrimSpace(text) != "" {
+			return nil, fmt.Errorf("line %d: tabs cannot indent YAML", i+1)
+		}
+		if strings.TrimSpace(text) == "" {
+			text = ""
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(raw) - len(text), text: text})
+	}
+
+	if l := p.peek(); l != nil && (l.text == "---" || strings.HasPrefix(l.text, "--- ")) && l.indent == 0 {
+		p.i++
+	}
+	root := &cfgNode{kind: mapNode, line: 1}
+	if l := p.peek(); l != nil && l.text != "..." {
+		if l.indent != 0 {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		var err error
+		if root, err = p.block(0); err != nil {
+			return nil, err
+		}
+	}
+	if l := p.peek(); l != nil {
+		if l.text == "---" || strings.HasPrefix(l.text, "--- ") {
+			return nil, fmt.Errorf("line %d: only one YAML document is supported", l.num)
+		}
+		if l.text != "..." {
+			return nil, fmt.Errorf("line %d: unexpected %q", l.num, l.text)
+		}
+	}
+	root.comments = p.header
+	root.inline = strings.Join(p.pending, "\n")
+	return root, nil
+}
+
+// peek skips blank and comment lines, collecting the comments, and returns
+// the next line, or nil at the end of the file.
+func (p *yamlParser) peek() *yamlLine {
+	for ; p.i < len(p.lines); p.i++ {
+		l := &p.lines[p.i]
+		switch {
+		case l.text == "":
+			if !p.started {
+				p.header = append(p.header, p.pending...)
+				p.pending = nil
+			}
+		case strings.HasPrefix(l.text, "#"):
+			p.pending = append(p.pending, l.text[1:])
+		default:
+			return l
+		}
+	}
+	return nil
+}
+
+// comments returns the comments collected for the value about to be read.
+func (p *yamlParser) comments() []string {
+	p.started = true
+	c := p.pending
+	p.pending = nil
+	return c
+}
+
+// block reads the mapping or list starting at the next line, indented by
+// indent.
+func (p *yamlParser) block(indent int) (*cfgNode, error) {
+	if isYAMLItem(p.peek().text) {
+		return p.list(indent)
+	}
+	return p.mapping(indent)
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ") || strings.HasPrefix(text, "-\t")
+}
+
+func (p *yamlParser) mapping(indent int) (*cfgNode, error) {
+	n := &cfgNode{kind: mapNode, line: p.peek().num}
+	for {
+		l := p.peek()
+		if l == nil || l.indent < indent || l.text == "..." || l.text == "---" {
+			return n, nil
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		if isYAMLItem(l.text) {
+			if indent == 0 || len(n.keys) > 0 {
+				return nil, fmt.Errorf("line %d: expected a key, found a list item", l.num)
+			}
+			return n, nil
+		}
+		comments := p.comments()
+		key, rest, ok, err := splitYAMLKey(l.text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", l.num, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", l.num)
+		}
+		p.i++
+		v, err := p.value(*l, indent, rest, true)
+		if err != nil {
+			return nil, err
+		}
+		v.line, v.comments = l.num, comments
+		if err := n.set(key, v); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *yamlParser) list(indent int) (*cfgNode, error) {
+	n := &cfgNode{kind: listNode, line: p.peek().num}
+	for {
+		l := p.peek()
+		if l == nil || l.indent < indent || !isYAMLItem(l.text) {
+			if l != nil && l.indent > indent {
+				return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+			}
+			return n, nil
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		comments := p.comments()
+		after := l.text[1:]
+		content := strings.TrimLeft(after, " \t")
+		col := indent + 1 + len(after) - len(content)
+		var v *cfgNode
+		var err error
+		_, _, isKey, _ := splitYAMLKey(content)
+		if content != "" && !strings.HasPrefix(content, "#") && (isKey || isYAMLItem(content)) {
+			// The item is a mapping or list starting on this line: read it
+			// as a block indented to where its content starts.
+			p.lines[p.i] = yamlLine{num: l.num, indent: col, text: content}
+			v, err = p.block(col)
+		} else {
+			num := *l
+			p.i++
+			v, err = p.value(num, indent, content, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+		v.line, v.comments = l.num, comments
+		n.vals = append(n.vals, v)
+	}
+}
+
+// value reads the value following a key or list item "-" on line l, whose
+// rest is what follows the ":" or "-". inMapping says whether l holds a key,
+// whose value may be a list indented as much as the key.
+func (p *yamlParser) value(l yamlLine, indent int, rest string, inMapping bool) (*cfgNode, error) {
+	rest = strings.TrimSpace(rest)
+	if rest == "" || strings.HasPrefix(rest, "#") {
+		inline := strings.TrimPrefix(rest, "#")
+		next := p.peek()
+		var v *cfgNode
+		var err error
+		switch {
+		case next != nil && next.indent > indent:
+			v, err = p.block(next.indent)
+		case next != nil && next.indent == indent && inMapping && isYAMLItem(next.text):
+			v, err = p.list(indent)
+		default:
+			v = &cfgNode{plain: true}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rest != "" {
+			v.inline = inline
+		}
+		return v, nil
+	}
+	switch rest[0] {
+	case '|', '>':
+		return p.blockScalar(l, indent, rest)
+	case '[', '{':
+		f := &yamlFlow{s: rest, line: l.num}
+		v, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		f.space()
+		if f.pos < len(f.s) && f.s[f.pos] != '#' {
+			return nil, fmt.Errorf("line %d: unexpected %q after %c", l.num, f.s[f.pos:], rest[0])
+		}
+		if f.pos < len(f.s) {
+			v.inline = f.s[f.pos+1:]
+		}
+		return v, nil
+	}
+	v, tail, err := yamlScalar(rest)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", l.num, err)
+	}
+	if tail = strings.TrimSpace(tail); tail != "" {
+		if !strings.HasPrefix(tail, "#") {
+			return nil, fmt.Errorf("line %d: unexpected %q after the value", l.num, tail)
+		}
+		v.inline = tail[1:]
+	}
+	return v, nil
+}
+
+// yamlScalar reads the scalar s starts with, and returns the rest of s.
+func yamlScalar(s string) (*cfgNode, string, error) {
+	switch s[0] {
+	case '"', '\'':
+		value, n, err := yamlQuoted(s)
+		if err != nil {
+			return nil, "", err
+		}
+		return &cfgNode{value: value}, s[n:], nil
+	case '&', '*', '!', '%', '@', '`':
+		return nil, "", fmt.Errorf("%q: anchors, aliases, tags, and directives are not supported", s)
+	}
+	value, comment, _ := strings.Cut(s, " #")
+	if comment != "" || strings.HasSuffix(s, " #") {
+		comment = "#" + comment
+	}
+	return &cfgNode{value: strings.TrimSpace(value), plain: true}, comment, nil
+}
+
+// yamlQuoted reads the single- or double-quoted string s starts with, and
+// returns its value and length.
+func yamlQuoted(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote && quote == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			b.WriteByte('\'')
+			i++
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && quote == '"' && i+1 < len(s):
+			i++
+			switch e := s[i]; e {
+			case '0':
+				b.WriteByte(0)
+			case 'a':
+				b.WriteByte('\a')
+			case 'b':
+				b.WriteByte('\b')
+			case 't', '\t':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'v':
+				b.WriteByte('\v')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case 'e':
+				b.WriteByte(0x1b)
+			case ' ', '"', '/', '\\':
+				b.WriteByte(e)
+			case 'x', 'u', 'U':
+				size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[e]
+				if i+size >= len(s) {
+					return "", 0, fmt.Errorf("truncated escape in %s", s)
+				}
+				r, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+				if err != nil {
+					return "", 0, fmt.Errorf("bad escape \\%c%s", e, s[i+1:i+1+size])
+				}
+				b.WriteRune(rune(r))
+				i += size
+			default:
+				return "", 0, fmt.Errorf("unknown escape \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string %s (multi-line quoted strings are not supported)", s)
+}
+
+// splitYAMLKey splits "key: rest" into its key and the rest, reporting
+// whether text is a key at all.
+func splitYAMLKey(text string) (key, rest string, ok bool, err error) {
+	if text == "" {
+		return "", "", false, nil
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		key, n, err := yamlQuoted(text)
+		if err != nil {
+			return "", "", false, nil
+		}
+		after := strings.TrimLeft(text[n:], " ")
+		if after == ":" || strings.HasPrefix(after, ": ") {
+			return key, after[1:], true, nil
+		}
+		return "", "", false, nil
+	}
+	if strings.ContainsRune("[{#&*!|>%@`", rune(text[0])) || isYAMLItem(text) {
+		return "", "", false, nil
+	}
+	end := len(text)
+	if i := strings.Index(text, " #"); i >= 0 {
+		end = i
+	}
+	if i := strings.Index(text[:end], ": "); i >= 0 {
+		return strings.TrimSpace(text[:i]), text[i+1:], true, nil
+	}
+	if head := strings.TrimRight(text[:end], " "); strings.HasSuffix(head, ":") {
+		return strings.TrimSpace(head[:len(head)-1]), text[len(head):], true, nil
+	}
+	return "", "", false, nil
+}
+
+// blockScalar reads a "|" or ">" scalar whose header (such as "|-") ends
+// line l, from the lines indented more than indent that follow.
+func (p *yamlParser) blockScalar(l yamlLine, indent int, header string) (*cfgNode, error) {
+	folded := header[0] == '>'
+	chomp := byte(0)
+	explicit := 0
+	rest := header[1:]
+	for len(rest) > 0 && rest[0] != ' ' && rest[0] != '#' {
+		switch c := rest[0]; {
+		case c == '-' || c == '+':
+			chomp = c
+		case c >= '1' && c <= '9':
+			explicit = int(c - '0')
+		default:
+			return nil, fmt.Errorf("line %d: bad block scalar header %q", l.num, header)
+		}
+		rest = rest[1:]
+	}
+	v := &cfgNode{line: l.num}
+	if rest = strings.TrimSpace(rest); rest != "" {
+		if !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("line %d: unexpected %q after %q", l.num, rest, header)
+		}
+		v.inline = rest[1:]
+	}
+
+	blockIndent := indent + explicit
+	var lines []string
+	for ; p.i < len(p.lines); p.i++ {
+		line := p.lines[p.i]
+		if line.text == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if blockIndent == indent {
+			blockIndent = line.indent
+		}
+		if line.indent < blockIndent || line.indent <= indent {
+			break
+		}
+		lines = append(lines, strings.Repeat(" ", line.indent-blockIndent)+line.text)
+	}
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+
+	var b strings.Builder
+	for i, line := range lines {
+		switch {
+		case i == 0:
+		case !folded, line == "", strings.HasPrefix(line, " "), strings.HasPrefix(lines[i-1], " "):
+			if !folded || lines[i-1] != "" || line == "" {
+				b.WriteByte('\n')
+			}
+		default:
+			if lines[i-1] != "" {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(line)
+	}
+	v.value = b.String()
+	if len(lines) > 0 {
+		switch chomp {
+		case 0:
+			v.value += "\n"
+		case '+':
+			v.value += strings.Repeat("\n", trailing+1)
+		}
+	}
+	return v, nil
+}
+
+// yamlFlow reads a flow collection, such as [window, worker] or {a: 1}.
+type yamlFlow struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (f *yamlFlow) space() {
+	for f.pos < len(f.s) && (f.s[f.pos] == ' ' || f.s[f.pos] == '\t') {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", f.line, fmt.Sprintf(format, args...))
+}
+
+func (f *yamlFlow) value() (*cfgNode, error) {
+	f.space()
+	if f.pos >= len(f.s) {
+		return nil, f.errorf("unterminated flow collection (multi-line ones are not supported)")
+	}
+	switch f.s[f.pos] {
+	case '[', '{':
+		n := &cfgNode{kind: listNode, line: f.line}
+		end := byte(']')
+		if f.s[f.pos] == '{' {
+			n.kind, end = mapNode, '}'
+		}
+		f.pos++
+		for {
+			f.space()
+			if f.pos < len(f.s) && f.s[f.pos] == end {
+				f.pos++
+				return n, nil
+			}
+			if n.kind == mapNode {
+				key, err := f.scalar(":,}")
+				if err != nil {
+					return nil, err
+				}
+				f.space()
+				if f.pos >= len(f.s) || f.s[f.pos] != ':' {
+					return nil, f.errorf("expected \":\" after %q", key.value)
+				}
+				f.pos++
+				v, err := f.value()
+				if err != nil {
+					return nil, err
+				}
+				v.line = f.line
+				if err := n.set(key.value, v); err != nil {
+					return nil, err
+				}
+			} else {
+				v, err := f.value()
+				if err != nil {
+					return nil, err
+				}
+				v.line = f.line
+				n.vals = append(n.vals, v)
+			}
+			f.space()
+			switch {
+			case f.pos < len(f.s) && f.s[f.pos] == ',':
+				f.pos++
+			case f.pos < len(f.s) && f.s[f.pos] == end:
+			default:
+				return nil, f.errorf("expected \",\" or %q in %s", end, f.s)
+			}
+		}
+	}
+	return f.scalar(",]}")
+}
+
+// scalar reads a scalar ending before one of stops.
+func (f *yamlFlow) scalar(stops string) (*cfgNode, error) {
+	f.space()
+	if f.pos < len(f.s) && (f.s[f.pos] == '"' || f.s[f.pos] == '\'') {
+		value, n, err := yamlQuoted(f.s[f.pos:])
+		if err != nil {
+			return nil, f.errorf("%v", err)
+		}
+		f.pos += n
+		return &cfgNode{value: value}, nil
+	}
+	start := f.pos
+	for f.pos < len(f.s) && !strings.ContainsRune(stops, rune(f.s[f.pos])) && !strings.HasPrefix(f.s[f.pos:], " #") {
+		if f.s[f.pos] == ':' && stops == ",]}" && f.pos+1 < len(f.s) && f.s[f.pos+1] == ' ' {
+			break
+		}
+		f.pos++
+	}
+	v, _, err := yamlScalar(strings.TrimSpace(f.s[start:f.pos]) + " ")
+	if err != nil {
+		return nil, f.errorf("%v", err)
+	}
+	return v, nil
+}
+
+// emitYAML writes root, a configuration, as YAML, with the comments of the
+// file it replaces at the paths they were found at (see collectComments).
+func emitYAML(root *cfgNode, comments map[string]*cfgNode) []byte {
+	e := &yamlEmitter{comments: comments}
+	if c := comments[""]; c != nil && len(c.comments) > 0 {
+		e.comment(0, c.comments)
+		e.buf.WriteByte('\n')
+	}
+	e.mapping(root, 0, "", false)
+	if c := comments[""]; c != nil && c.inline != "" {
+		e.comment(0, strings.Split(c.inline, "\n"))
+	}
+	return e.buf.Bytes()
+}
+
+type yamlEmitter struct {
+	buf      bytes.Buffer
+	comments map[string]*cfgNode
+}
+
+func (e *yamlEmitter) comment(indent int, lines []string) {
+	for _, c := range lines {
+		fmt.Fprintf(&e.buf, "%s#%s\n", strings.Repeat(" ", indent), c)
+	}
+}
+
+// head writes the comments kept for path, if any.
+func (e *yamlEmitter) head(indent int, path string) {
+	if c := e.comments[path]; c != nil {
+		e.comment(indent, c.comments)
+	}
+}
+
+// mapping writes n at indent. inItem says its first key goes on the line
+// of a list item's "-", already written.
+func (e *yamlEmitter) mapping(n *cfgNode, indent int, path string, inItem bool) {
+	for i, key := range n.keys {
+		p := path + "." + key
+		if i > 0 || !inItem {
+			e.head(indent, p)
+			e.buf.WriteString(strings.Repeat(" ", indent))
+		}
+		e.buf.WriteString(yamlKey(key) + ":")
+		e.value(n.vals[i], indent, p)
+	}
+}
+
+// value writes v, the value of a key or list item at indent, after its ":"
+// or "-".
+func (e *yamlEmitter) value(v *cfgNode, indent int, path string) {
+	var inline string
+	if c := e.comments[path]; c != nil && c.inline != "" {
+		inline = " #" + c.inline
+	}
+	switch {
+	case v.kind == mapNode && len(v.keys) > 0:
+		e.buf.WriteString(inline + "\n")
+		e.mapping(v, indent+2, path, false)
+	case v.kind == listNode && len(v.vals) > 0:
+		e.buf.WriteString(inline + "\n")
+		e.list(v, indent+2, path)
+	case v.kind == mapNode:
+		e.buf.WriteString(" {}" + inline + "\n")
+	case v.kind == listNode:
+		e.buf.WriteString(" []" + inline + "\n")
+	case !v.plain && useYAMLBlock(v.value):
+		header := " |"
+		if !strings.HasSuffix(v.value, "\n") {
+			header = " |-"
+		}
+		e.buf.WriteString(header + inline + "\n")
+		pad := strings.Repeat(" ", indent+2)
+		for _, line := range strings.Split(strings.TrimSuffix(v.value, "\n"), "\n") {
+			if line != "" {
+				e.buf.WriteString(pad + line)
+			}
+			e.buf.WriteByte('\n')
+		}
+	default:
+		e.buf.WriteString(" " + yamlValue(v) + inline + "\n")
+	}
+}
+
+func (e *yamlEmitter) list(n *cfgNode, indent int, path string) {
+	pad := strings.Repeat(" ", indent)
+	for i, item := range n.vals {
+		p := n.itemPath(path, i)
+		e.head(indent, p)
+		switch {
+		case item.kind == mapNode && len(item.keys) > 0:
+			e.buf.WriteString(pad + "- ")
+			e.mapping(item, indent+2, p, true)
+		default:
+			e.buf.WriteString(pad + "-")
+			e.value(item, indent, p)
+		}
+	}
+}
+
+var yamlPlainKey = regexp.MustCompile(`^[A-Za-z0-9_.$/][A-Za-z0-9_.$/-]*$`)
+
+func yamlKey(key string) string {
+	if yamlPlainKey.MatchString(key) {
+		return key
+	}
+	return yamlQuote(key)
+}
+
+// yamlValue writes scalar v, quoting strings that would otherwise read as
+// something else.
+func yamlValue(v *cfgNode) string {
+	if v.plain {
+		return v.value
+	}
+	s := v.value
+	switch strings.ToLower(s) {
+	case "", "~", "null", "true", "false", "yes", "no", "on", "off", "y", "n":
+		return yamlQuote(s)
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(s, "_", ""), 64); err == nil {
+		return yamlQuote(s)
+	}
+	if _, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return yamlQuote(s)
+	}
+	if strings.ContainsRune("-?:,[]{}#&*!|>'\"%@` \t", rune(s[0])) || strings.HasSuffix(s, " ") ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") || !yamlPrintable(s) {
+		return yamlQuote(s)
+	}
+	return s
+}
+
+// useYAMLBlock reports whether s, a multi-line string, is best written as a
+// literal block scalar.
+func useYAMLBlock(s string) bool {
+	return strings.Contains(s, "\n") && !strings.HasSuffix(s, "\n\n") && !strings.HasPrefix(s, " ") &&
+		!strings.HasPrefix(s, "\n") && yamlPrintable(strings.ReplaceAll(s, "\n", ""))
+}
+
+// yamlPrintable reports whether s has no control characters but tabs.
+func yamlPrintable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if r < ' ' && r != '\t' || r == 0x7f || r == '\u2028' || r == '\u2029' || r == '\ufeff' {
+			return false
+		}
+	}
+	return true
+}
+
+// yamlQuote double-quotes s; JSON's escapes are valid YAML ones.
+func yamlQuote(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}